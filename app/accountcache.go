@@ -0,0 +1,160 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+const (
+	accountSnapshotsSize = 1000
+	// accountSnapshotTTL is a safety net, the snapshots should get invalidated by the activities we see
+	accountSnapshotTTL = 30 * time.Minute
+)
+
+// accountCollectionTypes are the activities which change the collections we keep in the account snapshots
+var accountCollectionTypes = pub.ActivityVocabularyTypes{
+	pub.FollowType,
+	pub.AcceptType,
+	pub.RejectType,
+	pub.BlockType,
+	pub.IgnoreType,
+	pub.LikeType,
+	pub.DislikeType,
+	pub.UndoType,
+	pub.AddType,
+}
+
+// accountSnapshot holds the collections of a logged account that we need for every page view
+type accountSnapshot struct {
+	votes     VoteCollection
+	followers AccountCollection
+	following AccountCollection
+	blocked   AccountCollection
+	ignored   AccountCollection
+	tags      FollowedTags
+	saved     SavedItems
+	outbox    pub.ItemCollection
+	at        time.Time
+}
+
+// accountSnapshots keeps the collections of the logged accounts in memory, so we don't have to load them
+// from fedbox for every request, or when a new session gets created.
+type accountSnapshots struct {
+	m         sync.RWMutex
+	snapshots map[pub.IRI]accountSnapshot
+}
+
+func newAccountSnapshots() *accountSnapshots {
+	return &accountSnapshots{snapshots: make(map[pub.IRI]accountSnapshot)}
+}
+
+// accountIRI returns the ActivityPub ID of the account, if we have it
+func accountIRI(a *Account) pub.IRI {
+	if a == nil {
+		return ""
+	}
+	if a.pub != nil {
+		return a.pub.GetLink()
+	}
+	if a.HasMetadata() {
+		return pub.IRI(a.Metadata.ID)
+	}
+	return ""
+}
+
+// Load sets the account's collections from its snapshot, it returns false if we don't have a valid one
+func (s *accountSnapshots) Load(a *Account) bool {
+	if s == nil || !a.HasMetadata() {
+		return false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	snap, ok := s.snapshots[accountIRI(a)]
+	if !ok || time.Since(snap.at) > accountSnapshotTTL {
+		return false
+	}
+	a.Votes = snap.votes
+	a.Followers = snap.followers
+	a.Following = snap.following
+	a.Blocked = snap.blocked
+	a.Ignored = snap.ignored
+	a.Tags = snap.tags
+	a.Saved = snap.saved
+	a.Metadata.Outbox = snap.outbox
+	return true
+}
+
+// Store saves a snapshot of the account's collections
+func (s *accountSnapshots) Store(a Account) {
+	iri := accountIRI(&a)
+	if s == nil || len(iri) == 0 || !a.HasMetadata() {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.snapshots[iri]; !ok && len(s.snapshots) >= accountSnapshotsSize {
+		for k, snap := range s.snapshots {
+			if time.Since(snap.at) > accountSnapshotTTL {
+				delete(s.snapshots, k)
+			}
+		}
+		if len(s.snapshots) >= accountSnapshotsSize {
+			s.snapshots = make(map[pub.IRI]accountSnapshot)
+		}
+	}
+	s.snapshots[iri] = accountSnapshot{
+		votes:     a.Votes,
+		followers: a.Followers,
+		following: a.Following,
+		blocked:   a.Blocked,
+		ignored:   a.Ignored,
+		tags:      a.Tags,
+		saved:     a.Saved,
+		outbox:    a.Metadata.Outbox,
+		at:        time.Now(),
+	}
+}
+
+// Remove drops the snapshots of the accounts
+func (s *accountSnapshots) Remove(accounts ...*Account) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, a := range accounts {
+		delete(s.snapshots, accountIRI(a))
+	}
+}
+
+// Invalidate drops the snapshots of the accounts involved in the activity, if it's newer than them
+func (s *accountSnapshots) Invalidate(a *pub.Activity) {
+	if s == nil || a == nil || !accountCollectionTypes.Contains(a.GetType()) {
+		return
+	}
+	changed := a.Published
+	if a.Updated.After(changed) {
+		changed = a.Updated
+	}
+	// NOTE(marius): the recipients are needed for Accept and Reject, where the object is the Follow activity
+	iris := make(pub.IRIs, 0, len(a.To)+2)
+	for _, rec := range a.To {
+		iris = append(iris, rec.GetLink())
+	}
+	if a.Actor != nil {
+		iris = append(iris, a.Actor.GetLink())
+	}
+	if a.Object != nil {
+		iris = append(iris, a.Object.GetLink())
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, iri := range iris {
+		if snap, ok := s.snapshots[iri]; ok && changed.After(snap.at) {
+			delete(s.snapshots, iri)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package app
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -37,6 +36,9 @@ type AccountMetadata struct {
 	Blurb                 []byte             `json:"blurb,omitempty"`
 	Icon                  ImageMetadata      `json:"icon,omitempty"`
 	Name                  string             `json:"name,omitempty"`
+	Emoji                 []Emoji            `json:"emoji,omitempty"`
+	Fields                []ProfileField     `json:"fields,omitempty"`
+	Settings              AccountSettings    `json:"settings"`
 	ID                    string             `json:"id,omitempty"`
 	URL                   string             `json:"url,omitempty"`
 	InboxIRI              string             `json:"inbox,omitempty"`
@@ -67,6 +69,8 @@ type Account struct {
 	Following AccountCollection    `json:"following,omitempty"`
 	Blocked   AccountCollection    `json:"-"`
 	Ignored   AccountCollection    `json:"-"`
+	Tags      FollowedTags         `json:"-"`
+	Saved     SavedItems           `json:"-"`
 	Level     uint8                `json:"-"`
 	Parent    *Account             `json:"-"`
 	Children  AccountPtrCollection `json:"-"`
@@ -204,7 +208,7 @@ func (h *handler)accountFromPost(r *http.Request) (Account, error) {
 	if len(hash) > 0 {
 		// NOTE(marius): coming from an invite
 		s := h.storage
-		a, _ = s.LoadAccount(context.TODO(), actors.IRI(s.BaseURL()).AddPath(hash))
+		a, _ = s.LoadAccount(r.Context(), actors.IRI(s.BaseURL()).AddPath(hash))
 	}
 	if accountsEqual(*a, AnonymousAccount) {
 		*a = Account{Metadata: &AccountMetadata{}}
@@ -240,7 +244,7 @@ func accountsFromRequestHandle(r *http.Request) ([]Account, error) {
 		Name: CompStrs{EqualsString(handle)},
 	}
 	repo := ContextRepository(r.Context())
-	return repo.accounts(context.TODO(), fa)
+	return repo.accounts(r.Context(), fa)
 }
 
 type AccountPtrCollection []*Account
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// apClient does the ActivityPub requests to fedbox the same way the go-ap client does, but through
+// the http.Client it receives, as the go-ap one always uses its package's default client and transport.
+type apClient struct {
+	c      *http.Client
+	signFn client.RequestSignFn
+	infoFn CtxLogFn
+	errFn  CtxLogFn
+}
+
+func newAPClient(c *http.Client, infoFn, errFn CtxLogFn) *apClient {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &apClient{c: c, infoFn: infoFn, errFn: errFn}
+}
+
+// SignFn sets the function which signs the requests, usually with the OAuth2 token of the current account
+func (c *apClient) SignFn(fn client.RequestSignFn) {
+	if fn == nil {
+		return
+	}
+	c.signFn = fn
+}
+
+func (c *apClient) req(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return req, err
+	}
+	req.Header.Set("User-Agent", client.UserAgent)
+	if method == http.MethodGet || method == http.MethodHead {
+		req.Header.Add("Accept", client.ContentTypeJsonLD)
+		req.Header.Add("Accept", client.ContentTypeActivityJson)
+		req.Header.Add("Accept", "application/json")
+	} else {
+		if len(contentType) == 0 {
+			contentType = client.ContentTypeJsonLD
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.signFn != nil {
+		if err = c.signFn(req); err != nil {
+			return req, errors.Annotatef(err, "unable to sign %s request to %s", req.Method, req.URL)
+		}
+	}
+	return req, nil
+}
+
+func (c *apClient) do(ctx context.Context, url, method, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := c.req(ctx, method, url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.c.Do(req)
+}
+
+// Get loads the url with the signature of the current account
+func (c *apClient) Get(url string) (*http.Response, error) {
+	return c.do(context.Background(), url, http.MethodGet, "", nil)
+}
+
+// CtxLoadIRI dereferences the IRI and loads the ActivityPub object it represents
+func (c *apClient) CtxLoadIRI(ctx context.Context, id pub.IRI) (pub.Item, error) {
+	lCtx := log.Ctx{"IRI": id}
+	st := time.Now()
+	if len(id) == 0 {
+		return nil, errors.Newf("invalid IRI, nil value")
+	}
+	if _, err := url.ParseRequestURI(id.String()); err != nil {
+		return nil, errors.Annotatef(err, "invalid IRI %s", id)
+	}
+	resp, err := c.do(ctx, id.String(), http.MethodGet, "", nil)
+	if err != nil {
+		c.errFn(lCtx, log.Ctx{"err": err})("unable to load from the ActivityPub end point")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	lCtx["duration"] = time.Now().Sub(st)
+	lCtx["status"] = resp.Status
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusGone {
+		err := errors.Newf("unable to load %s from the ActivityPub end point: invalid status %d", id, resp.StatusCode)
+		c.errFn(lCtx)("Error: %s", err)
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.errFn(lCtx, log.Ctx{"err": err})("unable to read the response")
+		return nil, err
+	}
+	c.infoFn(lCtx)("OK")
+	return pub.UnmarshalJSON(body)
+}
+
+// CtxToCollection posts the activity to the collection at iri, and returns the IRI and the object it created
+func (c *apClient) CtxToCollection(ctx context.Context, iri pub.IRI, a pub.Item) (pub.IRI, pub.Item, error) {
+	if len(iri) == 0 {
+		return "", nil, errors.Newf("invalid URL to post to")
+	}
+	body, err := pub.MarshalJSON(a)
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "unable to marshal activity")
+	}
+	resp, err := c.do(ctx, iri.String(), http.MethodPost, client.ContentTypeActivityJson, bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		c.errFn(log.Ctx{"err": err})("unable to read the response")
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusGone && resp.StatusCode >= http.StatusBadRequest {
+		msg := "invalid status received: %d"
+		if errs, err := errors.UnmarshalJSON(body); err == nil {
+			for _, e := range errs {
+				if len(e.Error()) > 0 {
+					msg = msg + ", " + e.Error()
+				}
+			}
+		}
+		return "", nil, errors.Errorf(msg, resp.StatusCode)
+	}
+	it, err := pub.UnmarshalJSON(body)
+	return pub.IRI(resp.Header.Get("Location")), it, err
+}
+
+// Collection loads the collection at i, with the filters applied
+func (c *apClient) Collection(ctx context.Context, i pub.IRI, filters ...client.FilterFn) (pub.CollectionInterface, error) {
+	it, err := c.CtxLoadIRI(ctx, iri(i, filters...))
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to load IRI: %s", i)
+	}
+	var col pub.CollectionInterface
+	err = pub.OnCollectionIntf(it, func(c pub.CollectionInterface) error {
+		col = c
+		return nil
+	})
+	return col, err
+}
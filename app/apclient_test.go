@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestAPClient(t *testing.T) {
+	var posted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer f00" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if !strings.Contains(strings.Join(r.Header["Accept"], ","), "application/activity+json") {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Write([]byte(`{"id":"https://example.com/objects/1","type":"Note","content":"test"}`))
+		case http.MethodPost:
+			data, _ := ioutil.ReadAll(r.Body)
+			posted = string(data)
+			w.Header().Set("Location", "https://example.com/activities/1")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"https://example.com/objects/2","type":"Note"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := newAPClient(srv.Client(), defaultCtxLogFn, defaultCtxLogFn)
+	if _, err := c.CtxLoadIRI(context.Background(), pub.IRI(srv.URL)); err == nil {
+		t.Errorf("expected the request without the token to fail")
+	}
+	c.SignFn(func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer f00")
+		return nil
+	})
+
+	it, err := c.CtxLoadIRI(context.Background(), pub.IRI(srv.URL))
+	if err != nil {
+		t.Fatalf("unable to load the object: %s", err)
+	}
+	if it.GetLink() != "https://example.com/objects/1" || it.GetType() != pub.NoteType {
+		t.Errorf("loaded %s %s, expected the Note https://example.com/objects/1", it.GetType(), it.GetLink())
+	}
+
+	act := &pub.Activity{Type: pub.CreateType, Object: &pub.Object{Type: pub.NoteType}}
+	iri, ob, err := c.CtxToCollection(context.Background(), pub.IRI(srv.URL+"/outbox"), act)
+	if err != nil {
+		t.Fatalf("unable to post the activity: %s", err)
+	}
+	if iri != "https://example.com/activities/1" {
+		t.Errorf("activity IRI = %s, expected the Location header", iri)
+	}
+	if ob.GetLink() != "https://example.com/objects/2" {
+		t.Errorf("object IRI = %s, expected https://example.com/objects/2", ob.GetLink())
+	}
+	if !strings.Contains(posted, `"Create"`) {
+		t.Errorf("posted %s, expected the Create activity", posted)
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/mariusor/go-littr/internal/assets"
 	"github.com/mariusor/go-littr/internal/config"
 	"github.com/mariusor/go-littr/internal/log"
-	"github.com/writeas/go-nodeinfo"
 )
 
 const (
@@ -84,6 +83,13 @@ func New(c *config.Configuration, host string, port int, ver string, m *chi.Mux)
 }
 
 func (a *Application) setUp(c *config.Configuration, host string, port int) error {
+	a.configure(c, host, port)
+	a.Front()
+	return nil
+}
+
+// configure sets up the application from the configuration, without starting the frontend
+func (a *Application) configure(c *config.Configuration, host string, port int) {
 	a.Conf = c
 	a.Logger = log.Dev(c.LogLevel)
 	if c.Secure {
@@ -103,9 +109,8 @@ func (a *Application) setUp(c *config.Configuration, host string, port int) erro
 	if c.APIURL == "" {
 		c.APIURL = fmt.Sprintf("%s/api", a.BaseURL)
 	}
+	setPageLimits(c)
 	Instance = *a
-	a.Front()
-	return nil
 }
 
 func (a *Application) Front() error {
@@ -115,6 +120,9 @@ func (a *Application) Front() error {
 		Logger:        a.Logger.New(log.Ctx{"package": "frontend"}),
 	}
 	front, err := Init(conf)
+	if err == errMissingSessionKeys {
+		a.Logger.Crit(err.Error())
+	}
 	if err != nil {
 		a.Logger.Error(err.Error())
 		return err
@@ -126,18 +134,16 @@ func (a *Application) Front() error {
 	r.With(front.Repository).Route("/", front.Routes(a.Conf))
 
 	// .well-known
-	cfg := NodeInfoConfig()
-	ni := nodeinfo.NewService(cfg, NodeInfoResolverNew(front.storage.fedbox))
-	// Web-Finger
 	r.Route("/.well-known", func(r chi.Router) {
 		r.Get("/webfinger", front.HandleWebFinger)
 		r.Get("/host-meta", front.HandleHostMeta)
-		r.Get("/nodeinfo", ni.NodeInfoDiscover)
+		r.Get("/nodeinfo", front.HandleNodeInfoDiscover)
 		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 			errors.HandleError(errors.NotFoundf("%s", r.RequestURI)).ServeHTTP(w, r)
 		})
 	})
-	r.Get("/nodeinfo", ni.NodeInfo)
+	r.Get("/nodeinfo", front.HandleNodeInfo)
+	r.Get("/nodeinfo/{version}", front.HandleNodeInfo)
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		front.v.HandleErrors(w, r, errors.NotFoundf("%s", r.RequestURI))
 	})
@@ -147,6 +153,14 @@ func (a *Application) Front() error {
 	return nil
 }
 
+// Prune removes the cached content older than the configured retention interval
+func (a Application) Prune() {
+	if a.front == nil || a.front.storage == nil {
+		return
+	}
+	a.front.storage.Prune(a.Conf.Retention)
+}
+
 type Cacheable interface {
 	GetAge() int
 }
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+// archivePeriod is one entry in the calendar navigation of the archive pages
+type archivePeriod struct {
+	Label   string
+	URL     string
+	Count   int
+	Current bool
+}
+
+// archive holds the period shown by an archive page and the calendar for navigating to the other ones
+type archive struct {
+	Year   int
+	Month  time.Month
+	Years  []archivePeriod
+	Months []archivePeriod
+}
+
+func (a archive) start() time.Time {
+	if a.Month == 0 {
+		return time.Date(a.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(a.Year, a.Month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (a archive) end() time.Time {
+	if a.Month == 0 {
+		return a.start().AddDate(1, 0, 0)
+	}
+	return a.start().AddDate(0, 1, 0)
+}
+
+// Contains returns if t is in the period shown by the archive page
+func (a archive) Contains(t time.Time) bool {
+	t = t.UTC()
+	return !t.Before(a.start()) && t.Before(a.end())
+}
+
+func (a archive) Title() string {
+	if a.Month == 0 {
+		return fmt.Sprintf("Best of %d", a.Year)
+	}
+	return fmt.Sprintf("Best of %s %d", a.Month, a.Year)
+}
+
+// archiveCounts returns the number of top level submissions in the index for each month
+func (l *localIndex) archiveCounts() map[int]map[time.Month]int {
+	counts := make(map[int]map[time.Month]int)
+	l.Select(func(e indexEntry) bool {
+		if !e.IsTop() || e.SubmittedAt.IsZero() {
+			return false
+		}
+		t := e.SubmittedAt.UTC()
+		if _, ok := counts[t.Year()]; !ok {
+			counts[t.Year()] = make(map[time.Month]int)
+		}
+		counts[t.Year()][t.Month()]++
+		return false
+	}, nil)
+	return counts
+}
+
+func loadArchive(counts map[int]map[time.Month]int, year int, month time.Month) archive {
+	a := archive{Year: year, Month: month}
+
+	years := make([]int, 0, len(counts))
+	for y := range counts {
+		years = append(years, y)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+	for _, y := range years {
+		cnt := 0
+		for _, c := range counts[y] {
+			cnt += c
+		}
+		a.Years = append(a.Years, archivePeriod{
+			Label:   strconv.Itoa(y),
+			URL:     fmt.Sprintf("/archive/%d", y),
+			Count:   cnt,
+			Current: y == year && month == 0,
+		})
+	}
+	for m := time.January; m <= time.December; m++ {
+		p := archivePeriod{
+			Label:   m.String()[:3],
+			Count:   counts[year][m],
+			Current: m == month,
+		}
+		if p.Count > 0 {
+			p.URL = fmt.Sprintf("/archive/%d/%02d", year, m)
+		}
+		a.Months = append(a.Months, p)
+	}
+	return a
+}
+
+func ContextArchive(ctx context.Context) *archive {
+	a, _ := ctx.Value(ArchiveCtxtKey).(*archive)
+	return a
+}
+
+// ArchiveMw loads the year and month of the archive page from the URL
+// When the year is missing we show the most recent one.
+func (h *handler) ArchiveMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts := h.storage.index.archiveCounts()
+
+		year := 0
+		for y := range counts {
+			if y > year {
+				year = y
+			}
+		}
+		if year == 0 {
+			year = time.Now().UTC().Year()
+		}
+		var month time.Month
+		if y := chi.URLParam(r, "year"); len(y) > 0 {
+			yy, err := strconv.Atoi(y)
+			if err != nil || counts[yy] == nil {
+				h.v.HandleErrors(w, r, errors.NotFoundf("archive for %q", y))
+				return
+			}
+			year = yy
+		}
+		if m := chi.URLParam(r, "month"); len(m) > 0 {
+			mm, err := strconv.Atoi(m)
+			if err != nil || mm < 1 || mm > 12 || counts[year][time.Month(mm)] == 0 {
+				h.v.HandleErrors(w, r, errors.NotFoundf("archive for %d/%s", year, m))
+				return
+			}
+			month = time.Month(mm)
+		}
+		a := loadArchive(counts, year, month)
+		if l := ContextListingModel(r.Context()); l != nil {
+			l.Title = a.Title()
+			l.Archive = &a
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ArchiveCtxtKey, &a)))
+	})
+}
+
+func archiveEntries(r *http.Request) func(indexEntry) bool {
+	a := ContextArchive(r.Context())
+	return func(e indexEntry) bool {
+		return a != nil && e.IsTop() && a.Contains(e.SubmittedAt)
+	}
+}
+
+// ArchiveListingMw selects the best scored submissions in the period of the archive page
+var ArchiveListingMw = IndexListingMw("", archiveEntries, byIndexScore)
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	completeHandles = "handles"
+	completeTags    = "tags"
+	completeBoards  = "boards"
+
+	// completeMax is the number of names we return for a prefix
+	completeMax = 10
+	// completeCacheTime is how long we keep the names we collected from the local index
+	completeCacheTime = time.Minute
+	// completeRate is how many requests an account can make in completeWindow, the forms ask
+	// while the users type, so it's high enough for that, but not for crawling the names
+	completeRate   = 60
+	completeWindow = time.Minute
+)
+
+// completion is a name offered for the autocompletion, Uses is how many items it appears on
+type completion struct {
+	Name  string `json:"name"`
+	Title string `json:"title,omitempty"`
+	Uses  int    `json:"uses"`
+}
+
+// completionNames caches the handles, tags and boards used in the local index, ordered by how often
+// they appear, and the latest requests of the accounts, for limiting them
+type completionNames struct {
+	m        sync.Mutex
+	names    map[string][]completion
+	loadedAt time.Time
+	requests map[Hash][]time.Time
+}
+
+// allow records the request of the account, and returns how long it needs to wait when it made too many
+func (c *completionNames) allow(acc Hash, now time.Time) time.Duration {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.requests == nil {
+		c.requests = make(map[Hash][]time.Time)
+	}
+	for h, times := range c.requests {
+		if len(times) > 0 && now.Sub(times[len(times)-1]) > completeWindow {
+			delete(c.requests, h)
+		}
+	}
+	times := c.requests[acc]
+	for len(times) > 0 && now.Sub(times[0]) > completeWindow {
+		times = times[1:]
+	}
+	if len(times) >= completeRate {
+		c.requests[acc] = times
+		return completeWindow - now.Sub(times[0])
+	}
+	c.requests[acc] = append(times, now)
+	return 0
+}
+
+func sortedCompletions(uses map[string]int, titles map[string]string) []completion {
+	res := make([]completion, 0, len(uses))
+	for name, n := range uses {
+		res = append(res, completion{Name: name, Title: titles[name], Uses: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Uses != res[j].Uses {
+			return res[i].Uses > res[j].Uses
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// completionNames returns the names of the kind, collecting them from the local index when the cache is stale.
+// We only offer the handles of the local accounts, the mentions without a host are resolved on this instance.
+func (r *repository) completionNames(kind string) []completion {
+	c := &r.completions
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.names != nil && time.Since(c.loadedAt) < completeCacheTime {
+		return c.names[kind]
+	}
+	handles := make(map[string]int)
+	tags := make(map[string]int)
+	boards := make(map[string]int)
+	r.index.m.RLock()
+	for _, e := range r.index.entries {
+		if !e.Listable() {
+			continue
+		}
+		if len(e.Handle) > 0 && HostIsLocal(e.IRI.String()) {
+			handles[e.Handle]++
+		}
+		for _, t := range e.Tags {
+			tags[t]++
+		}
+		if len(e.Board) > 0 {
+			boards[e.Board]++
+		}
+	}
+	r.index.m.RUnlock()
+	titles := make(map[string]string)
+	for _, b := range r.boards.List() {
+		titles[b.Name] = b.Title
+		if boards[b.Name] < b.Submissions+b.Comments {
+			boards[b.Name] = b.Submissions + b.Comments
+		}
+	}
+	c.names = map[string][]completion{
+		completeHandles: sortedCompletions(handles, nil),
+		completeTags:    sortedCompletions(tags, nil),
+		completeBoards:  sortedCompletions(boards, titles),
+	}
+	c.loadedAt = time.Now()
+	return c.names[kind]
+}
+
+// completePrefix returns the first max names starting with prefix, ignoring the case
+func completePrefix(names []completion, prefix string, max int) []completion {
+	prefix = strings.ToLower(prefix)
+	res := make([]completion, 0)
+	for _, n := range names {
+		if len(res) >= max {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(n.Name), prefix) {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// HandleComplete serves the /complete/{kind}?q= requests of the comment and submission forms, with the handles,
+// tags or boards starting with q. The @, ~ and # in front of q are ignored.
+func (h *handler) HandleComplete(w http.ResponseWriter, r *http.Request) {
+	kind := chi.URLParam(r, "kind")
+	if kind != completeHandles && kind != completeTags && kind != completeBoards {
+		writeJSONError(w, http.StatusNotFound, "unknown kind of names")
+		return
+	}
+	if wait := h.storage.completions.allow(loggedAccount(r).Hash, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeJSONError(w, http.StatusTooManyRequests, "too many requests, please slow down")
+		return
+	}
+	prefix := strings.TrimLeft(strings.TrimSpace(r.URL.Query().Get("q")), "@~#")
+	res := make([]completion, 0)
+	if len(prefix) > 0 {
+		res = completePrefix(h.storage.completionNames(kind), prefix, completeMax)
+	}
+	dat, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
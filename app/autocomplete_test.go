@@ -0,0 +1,37 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompletePrefix(t *testing.T) {
+	names := sortedCompletions(map[string]int{"golang": 3, "go": 5, "Gopher": 1, "rust": 4}, nil)
+	got := completePrefix(names, "GO", 2)
+	if len(got) != 2 || got[0].Name != "go" || got[1].Name != "golang" {
+		t.Errorf("expected the two most used names starting with go, got %v", got)
+	}
+	if got := completePrefix(names, "gop", completeMax); len(got) != 1 || got[0].Name != "Gopher" {
+		t.Errorf("expected Gopher, got %v", got)
+	}
+}
+
+func TestCompletionNamesAllow(t *testing.T) {
+	c := completionNames{}
+	acc := Hash{1}
+	now := time.Now()
+	for i := 0; i < completeRate; i++ {
+		if wait := c.allow(acc, now); wait > 0 {
+			t.Fatalf("request %d: expected to be allowed, wait %s", i, wait)
+		}
+	}
+	if wait := c.allow(acc, now.Add(time.Second)); wait <= 0 || wait > completeWindow {
+		t.Errorf("expected to wait after %d requests, got %s", completeRate, wait)
+	}
+	if wait := c.allow(Hash{2}, now); wait > 0 {
+		t.Errorf("expected the other accounts to be allowed, wait %s", wait)
+	}
+	if wait := c.allow(acc, now.Add(completeWindow+time.Second)); wait > 0 {
+		t.Errorf("expected to be allowed after the window, wait %s", wait)
+	}
+}
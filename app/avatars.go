@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	avatarLookupsFile = "avatar-lookups.json"
+	avatarsCacheSize  = 500
+	avatarsCacheTTL   = 24 * time.Hour
+	avatarMaxSize     = 256 << 10
+	avatarLoadTimeOut = 5 * time.Second
+	// avatarMaxSide is the size we scale the avatars down to, it's enough for the high density screens
+	avatarMaxSide = 256
+)
+
+// proxiedAvatar is an avatar image we loaded from the lookup service
+type proxiedAvatar struct {
+	mimeType string
+	data     []byte
+	at       time.Time
+}
+
+// avatarLookups keeps the hashed emails of the accounts which opted in to using their Libravatar/Gravatar
+// avatar when they didn't upload one. The images are loaded by us and served from our domain,
+// so the lookup service doesn't see the IP addresses of the readers.
+type avatarLookups struct {
+	m      sync.RWMutex
+	path   string
+	url    string
+	hashes map[string]string
+	cache  map[string]proxiedAvatar
+	// scanner checks the images before we serve them from our domain
+	scanner *mediaScanner
+}
+
+func loadAvatarLookups(path, url string) (*avatarLookups, error) {
+	l := &avatarLookups{path: path, url: url, hashes: make(map[string]string), cache: make(map[string]proxiedAvatar)}
+	return l, loadJSON(path, &l.hashes)
+}
+
+// emailHash returns the hash used by Libravatar and Gravatar for looking up the avatar of an email address
+func emailHash(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Enabled returns if the avatar lookup is configured for the instance
+func (l *avatarLookups) Enabled() bool {
+	return l != nil && len(l.url) > 0
+}
+
+// Has returns if the account with id opted in to the avatar lookup
+func (l *avatarLookups) Has(id string) bool {
+	if !l.Enabled() {
+		return false
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	_, ok := l.hashes[id]
+	return ok
+}
+
+// Set saves the hashed email of the account with id, an empty email removes it
+func (l *avatarLookups) Set(id, email string) error {
+	if l == nil {
+		return nil
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	delete(l.cache, l.hashes[id])
+	if email = strings.TrimSpace(email); len(email) > 0 {
+		l.hashes[id] = emailHash(email)
+	} else {
+		delete(l.hashes, id)
+	}
+	return saveJSON(l.path, l.hashes)
+}
+
+// Load returns the avatar of the account with id, from the cache or from the lookup service
+func (l *avatarLookups) Load(ctx context.Context, id string) (proxiedAvatar, error) {
+	if !l.Enabled() {
+		return proxiedAvatar{}, errors.NotFoundf("avatar")
+	}
+	l.m.RLock()
+	hash, ok := l.hashes[id]
+	cached, isCached := l.cache[hash]
+	l.m.RUnlock()
+	if !ok {
+		return proxiedAvatar{}, errors.NotFoundf("avatar")
+	}
+	if isCached && time.Since(cached.at) < avatarsCacheTTL {
+		if len(cached.data) == 0 {
+			return cached, errors.NotFoundf("avatar")
+		}
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, avatarLoadTimeOut)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(l.url, hash), nil)
+	if err != nil {
+		return proxiedAvatar{}, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return proxiedAvatar{}, err
+	}
+	defer res.Body.Close()
+
+	// NOTE(marius): we remember the missing avatars too, so we don't ask the service again for every page view
+	img := proxiedAvatar{at: time.Now()}
+	mimeType := res.Header.Get("Content-Type")
+	if res.StatusCode == http.StatusOK && strings.HasPrefix(mimeType, "image/") && mimeType != MimeTypeSVG {
+		data, err := ioutil.ReadAll(io.LimitReader(res.Body, avatarMaxSize+1))
+		if err != nil {
+			return proxiedAvatar{}, err
+		}
+		if len(data) <= avatarMaxSize {
+			// NOTE(marius): the rejected avatars are remembered as missing, the scanner failures are retried
+			if err := l.scanner.Check(ctx, "avatar lookup "+hash, data, mimeType); err == nil {
+				// NOTE(marius): the images we can't decode, or which are too large once decoded, are missing too
+				if data, mimeType, err = reencodeImage(data, avatarMaxSide); err == nil {
+					img.mimeType = mimeType
+					img.data = data
+				}
+			} else if !errors.IsForbidden(err) {
+				return proxiedAvatar{}, err
+			}
+		}
+	}
+
+	l.m.Lock()
+	if len(l.cache) >= avatarsCacheSize {
+		l.cache = make(map[string]proxiedAvatar)
+	}
+	l.cache[hash] = img
+	l.m.Unlock()
+
+	if len(img.data) == 0 {
+		return img, errors.NotFoundf("avatar")
+	}
+	return img, nil
+}
+
+// hasDefaultAvatar returns if the account didn't upload an avatar, and we show the generated one
+func hasDefaultAvatar(a *Account) bool {
+	return !a.HasIcon() || a.Metadata.Icon == accountDefaultAvatar(a)
+}
+
+// accountAvatar returns the avatar of the account, using the lookup service one if the account opted in
+func accountAvatar(l *avatarLookups) func(a *Account) template.HTML {
+	return func(a *Account) template.HTML {
+		if hasDefaultAvatar(a) && l.Has(accountIRI(a).String()) {
+			return template.HTML(fmt.Sprintf(`<img src="%s/avatar" width="48" height="48" class="icon avatar" alt="" loading="lazy"/>`,
+				template.HTMLEscapeString(AccountPermaLink(a))))
+		}
+		if a.HasIcon() {
+			return avatar(a.Metadata.Icon.MimeType, a.Metadata.Icon.URI)
+		}
+		return icon("user", "avatar")
+	}
+}
+
+// HandleAvatar serves /~{handle}/avatar requests, with the avatar loaded from the lookup service
+func (h *handler) HandleAvatar(w http.ResponseWriter, r *http.Request) {
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	img, err := h.storage.avatars.Load(r.Context(), accountIRI(&authors[0]).String())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			h.errFn(log.Ctx{"err": err, "handle": authors[0].Handle})("unable to load avatar")
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", img.mimeType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(avatarsCacheTTL.Seconds())))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(img.data)
+}
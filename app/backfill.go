@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	backfillQueueSize = 100
+	// backfillMaxItems is the maximum number of replies we import for a thread
+	backfillMaxItems = 500
+	// backfillMaxPages is the maximum number of pages we load from a remote replies collection
+	backfillMaxPages = 10
+	backfillTTL      = 30 * time.Minute
+	backfillTimeOut  = 2 * time.Minute
+)
+
+type backfillThread struct {
+	items ItemCollection
+	at    time.Time
+}
+
+// backfill loads the replies of federated items from their origin servers, for the cases where
+// fedbox received only some of them.
+// The replies are kept in memory and merged with the local ones when the item is shown.
+type backfill struct {
+	m       sync.RWMutex
+	queue   chan pub.IRI
+	threads map[pub.IRI]*backfillThread
+	loadFn  func(context.Context, pub.IRI) (pub.Item, error)
+	errFn   CtxLogFn
+	// doneFn gets called after the replies of an item were loaded
+	doneFn func(pub.IRI)
+}
+
+func newBackfill(loadFn func(context.Context, pub.IRI) (pub.Item, error), errFn CtxLogFn) *backfill {
+	return &backfill{
+		queue:   make(chan pub.IRI, backfillQueueSize),
+		threads: make(map[pub.IRI]*backfillThread),
+		loadFn:  loadFn,
+		errFn:   errFn,
+	}
+}
+
+func itemRepliesIRI(it Item) pub.IRI {
+	var iri pub.IRI
+	if it.pub == nil {
+		return iri
+	}
+	pub.OnObject(it.pub, func(o *pub.Object) error {
+		if o.Replies != nil {
+			iri = o.Replies.GetLink()
+		}
+		return nil
+	})
+	return iri
+}
+
+// Schedule queues the loading of the replies for a federated item,
+// it returns false if the item doesn't need it or the queue is full
+func (b *backfill) Schedule(it Item) bool {
+	if b == nil || !it.IsValid() || it.pub == nil || !it.IsFederated() || len(itemRepliesIRI(it)) == 0 {
+		return false
+	}
+	iri := it.pub.GetLink()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	if t, ok := b.threads[iri]; ok && (t.at.IsZero() || time.Since(t.at) < backfillTTL) {
+		// already pending or recently loaded
+		return false
+	}
+	select {
+	case b.queue <- iri:
+		b.threads[iri] = &backfillThread{}
+		return true
+	default:
+		return false
+	}
+}
+
+// Replies returns the replies we loaded for the item from its origin server
+func (b *backfill) Replies(it Item) ItemCollection {
+	if b == nil || it.pub == nil {
+		return nil
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	if t, ok := b.threads[it.pub.GetLink()]; ok {
+		return t.items
+	}
+	return nil
+}
+
+// run processes the queued items until the queue gets closed
+func (b *backfill) run() {
+	for iri := range b.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), backfillTimeOut)
+		items, err := b.load(ctx, iri)
+		cancel()
+		if err != nil {
+			b.errFn(log.Ctx{"iri": iri, "err": err})("unable to backfill replies")
+		}
+		b.m.Lock()
+		b.threads[iri] = &backfillThread{items: items, at: time.Now()}
+		if len(b.threads) > backfillQueueSize*10 {
+			for k, t := range b.threads {
+				if !t.at.IsZero() && time.Since(t.at) > backfillTTL {
+					delete(b.threads, k)
+				}
+			}
+		}
+		b.m.Unlock()
+		if b.doneFn != nil {
+			b.doneFn(iri)
+		}
+	}
+}
+
+// load walks the replies collection of the object and the ones of its replies
+func (b *backfill) load(ctx context.Context, iri pub.IRI) (ItemCollection, error) {
+	result := make(ItemCollection, 0)
+	seen := pub.IRIs{iri}
+	toLoad := []pub.IRI{iri}
+
+	for len(toLoad) > 0 && len(result) < backfillMaxItems {
+		cur := toLoad[0]
+		toLoad = toLoad[1:]
+
+		ob, err := b.loadFn(ctx, cur)
+		if err != nil {
+			if len(result) == 0 {
+				return result, err
+			}
+			continue
+		}
+		it := Item{}
+		if err := it.FromActivityPub(ob); err != nil {
+			continue
+		}
+		if cur != iri && it.IsValid() {
+			result = append(result, it)
+		}
+		col := itemRepliesIRI(it)
+		if len(col) == 0 {
+			continue
+		}
+		replies, err := b.loadCollection(ctx, col)
+		if err != nil {
+			b.errFn(log.Ctx{"iri": col, "err": err})("unable to load replies collection")
+		}
+		for _, r := range replies {
+			if seen.Contains(r) {
+				continue
+			}
+			seen = append(seen, r)
+			toLoad = append(toLoad, r)
+		}
+	}
+	return result, nil
+}
+
+// collectionPage returns the items of a collection or collection page, and where to find the next ones
+func collectionPage(it pub.Item) (pub.ItemCollection, pub.Item) {
+	switch c := it.(type) {
+	case *pub.OrderedCollection:
+		return c.OrderedItems, c.First
+	case *pub.OrderedCollectionPage:
+		return c.OrderedItems, c.Next
+	case *pub.Collection:
+		return c.Items, c.First
+	case *pub.CollectionPage:
+		return c.Items, c.Next
+	}
+	return nil, nil
+}
+
+// loadCollection returns the IRIs of the items in the remote collection, following the pages
+func (b *backfill) loadCollection(ctx context.Context, iri pub.IRI) (pub.IRIs, error) {
+	result := make(pub.IRIs, 0)
+	var next pub.Item = iri
+	for page := 0; next != nil && page < backfillMaxPages; page++ {
+		var it pub.Item = next
+		if next.IsLink() {
+			var err error
+			if it, err = b.loadFn(ctx, next.GetLink()); err != nil {
+				return result, err
+			}
+		}
+		items, n := collectionPage(it)
+		for _, i := range items {
+			if i != nil && !result.Contains(i.GetLink()) {
+				result = append(result, i.GetLink())
+			}
+		}
+		if n != nil && n.GetLink() == it.GetLink() {
+			break
+		}
+		next = n
+	}
+	return result, nil
+}
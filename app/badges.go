@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const badgeMaxSubmissions = 20
+
+// itemBadge is what the external sites get for showing how much their page was discussed here
+type itemBadge struct {
+	URL string `json:"url"`
+	// Link is the discussion with the highest score, if the page was submitted more than once
+	Link        string `json:"link,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Submissions int    `json:"submissions"`
+	Comments    int    `json:"comments"`
+	Score       int    `json:"score"`
+}
+
+// badgeURLVariants returns the URL with and without the trailing slash, as people submit both
+func badgeURLVariants(u string) CompStrs {
+	u = strings.TrimRight(u, "/")
+	return CompStrs{EqualsString(u), EqualsString(u + "/")}
+}
+
+// loadItemBadge loads the submissions of the page at u, and the number of comments of the best scored one
+func (r *repository) loadItemBadge(ctx context.Context, u string) (itemBadge, error) {
+	b := itemBadge{URL: u}
+	f := &Filters{
+		Type:     CompStrs{EqualsString(string(pub.PageType))},
+		URL:      badgeURLVariants(u),
+		MaxItems: badgeMaxSubmissions,
+	}
+	items, err := r.objects(ctx, f)
+	if err != nil && !errors.IsNotFound(err) {
+		return b, err
+	}
+	var best *Item
+	for i := range items {
+		it := &items[i]
+		if it.Deleted() || it.Private() {
+			continue
+		}
+		b.Submissions++
+		if best == nil || it.Score > best.Score {
+			best = it
+		}
+	}
+	if best == nil {
+		return b, nil
+	}
+	b.Link = AbsoluteLink(ItemSlugLink(best))
+	b.Title = best.Title
+	b.Score = best.Score
+	replies, err := r.loadItemsReplies(ctx, *best)
+	if err != nil {
+		return b, err
+	}
+	for _, it := range replies {
+		if !it.Deleted() {
+			b.Comments++
+		}
+	}
+	return b, nil
+}
+
+// itemBadgeJSON returns the JSON of the badge of the page at u, from the cache if we loaded it recently
+func (r *repository) itemBadgeJSON(ctx context.Context, u string) ([]byte, error) {
+	key := pub.IRI(u)
+	if ob, ok := r.badges.get(key); ok {
+		return ob.dat, nil
+	}
+	b, err := r.loadItemBadge(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	r.badges.set(key, cachedObject{dat: dat})
+	return dat, nil
+}
+
+// HandleBadge serves the /api/v1/badge?url= requests, with the number of comments and the score of the
+// discussion of a page. It can be loaded from any site, so blogs can link to their discussions.
+func (h *handler) HandleBadge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	u, err := url.Parse(strings.TrimSpace(r.URL.Query().Get("url")))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"the url parameter needs to be an absolute http(s) URL"}`))
+		return
+	}
+	u.Fragment = ""
+	dat, err := h.storage.itemBadgeJSON(r.Context(), u.String())
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "url": u.String()})("unable to load the badge")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"unable to load the discussion"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// SessionBannerKey holds the identifier of the last banner the current user has dismissed
+const SessionBannerKey = "__banner"
+
+// banner is the site wide announcement set up by the instance admin
+type banner struct {
+	Level   flashType
+	Message string
+	Expires time.Time
+}
+
+func loadBanner(c config.Configuration) *banner {
+	msg := strings.TrimSpace(c.BannerMessage)
+	if len(msg) == 0 {
+		return nil
+	}
+	b := banner{
+		Level:   Info,
+		Message: msg,
+		Expires: c.BannerExpires,
+	}
+	if flashType(c.BannerLevel) == Warning {
+		b.Level = Warning
+	}
+	return &b
+}
+
+// ID is used for keeping track of dismissals, when the admin changes the message, the banner gets shown again
+func (b banner) ID() string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(string(b.Level)+b.Message)))[:16]
+}
+
+func (b banner) Expired() bool {
+	return !b.Expires.IsZero() && b.Expires.Before(time.Now())
+}
+
+func (v *view) dismissedBanner(w http.ResponseWriter, r *http.Request) string {
+	s, err := v.s.get(w, r)
+	if err != nil || s == nil {
+		return ""
+	}
+	id, _ := s.Values[SessionBannerKey].(string)
+	return id
+}
+
+// currentBanner returns the banner that needs to be shown for the current request, if any
+func (v *view) currentBanner(w http.ResponseWriter, r *http.Request) func() *banner {
+	return func() *banner {
+		b := loadBanner(*v.c)
+		if b == nil || b.Expired() {
+			return nil
+		}
+		if v.s.enabled && v.dismissedBanner(w, r) == b.ID() {
+			return nil
+		}
+		return b
+	}
+}
+
+// HandleDismissBanner serves POST /banner/dismiss requests
+func (h *handler) HandleDismissBanner(w http.ResponseWriter, r *http.Request) {
+	backURL := "/"
+	if refURLs, ok := r.Header["Referer"]; ok {
+		backURL = refURLs[0]
+	}
+	b := loadBanner(*h.v.c)
+	if b == nil {
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	s, err := h.v.s.get(w, r)
+	if err != nil || s == nil {
+		h.errFn(log.Ctx{"err": err})("unable to load session for dismissing banner")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	s.Values[SessionBannerKey] = b.ID()
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
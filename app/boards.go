@@ -0,0 +1,1194 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// boardSettingsType is the type of the attachment of the board's actor which holds what only go-littr uses:
+	// the rules, the moderators, the pinned and locked threads and the rest
+	boardSettingsType pub.ActivityVocabularyType = "BoardSettings"
+
+	// BoardCreationAdmin allows only the moderators to create boards
+	BoardCreationAdmin = "admin"
+	// BoardCreationKarma allows the accounts whose items have a score of at least BoardCreationKarma to create boards
+	BoardCreationKarma = "karma"
+	// BoardCreationOpen allows all the logged accounts to create boards
+	BoardCreationOpen = "open"
+
+	// boardMaxPinned is how many submissions the moderators can pin at the top of a board
+	boardMaxPinned = 3
+)
+
+var boardNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// board is a topical section of the instance. The items posted in it are addressed to its IRI,
+// and have it as their audience, the same way the FEP-1b12 groups work.
+// It's saved in fedbox as a Group actor, which the accounts subscribe to by following it.
+type board struct {
+	id           pub.IRI
+	Name         string    `json:"-"`
+	Title        string    `json:"-"`
+	Description  string    `json:"-"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"-"`
+	Submissions  int       `json:"submissions"`
+	Comments     int       `json:"comments"`
+	LastActivity time.Time `json:"lastActivity,omitempty"`
+	// Rules are shown beside the submission form, and on the board's page
+	Rules string `json:"rules,omitempty"`
+	// Template is the text the new submissions in the board start from
+	Template string `json:"template,omitempty"`
+	// Theme is the name of the colour variant used on the board's pages
+	Theme string `json:"theme,omitempty"`
+	// CSS is the sanitized custom stylesheet of the board
+	CSS string `json:"css,omitempty"`
+	// WikiEdit is who can edit the wiki of the board, besides its moderators
+	WikiEdit string `json:"wikiEdit,omitempty"`
+	// Moderators are the handles of the accounts the admins appointed to moderate the board
+	Moderators []string `json:"moderators,omitempty"`
+	// Pinned are the submissions shown first on the board's page, the last pinned one first
+	Pinned []Hash `json:"pinned,omitempty"`
+	// Locked are the threads of the board which can't be commented on anymore
+	Locked []Hash `json:"locked,omitempty"`
+	// Banned are the handles of the accounts which can't post in the board anymore
+	Banned []string `json:"banned,omitempty"`
+	// WikiLocked are the slugs of the wiki pages which only the moderators can edit
+	WikiLocked []string `json:"wikiLocked,omitempty"`
+}
+
+// IRI returns the IRI of the board, it's the one of its page
+func (b board) IRI() pub.IRI {
+	return pub.IRI(boardLink(b.Name))
+}
+
+// Link returns the URL of the board's page
+func (b board) Link() string {
+	return fmt.Sprintf("/b/%s", b.Name)
+}
+
+// IsPinned returns if the submission is pinned at the top of the board
+func (b board) IsPinned(h Hash) bool {
+	return hashInList(b.Pinned, h)
+}
+
+// IsLocked returns if the thread of the board can't be commented on anymore
+func (b board) IsLocked(h Hash) bool {
+	return hashInList(b.Locked, h)
+}
+
+// IsBanned returns if the account was banned from posting in the board
+func (b board) IsBanned(acc *Account) bool {
+	if !acc.IsLogged() {
+		return false
+	}
+	for _, handle := range b.Banned {
+		if strings.EqualFold(handle, acc.Handle) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashInList(list []Hash, h Hash) bool {
+	for _, l := range list {
+		if l == h {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHash returns a copy of the list, without h
+func withoutHash(list []Hash, h Hash) []Hash {
+	res := make([]Hash, 0, len(list))
+	for _, l := range list {
+		if l != h {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+func boardLink(name string) string {
+	return fmt.Sprintf("%s/b/%s", Instance.BaseURL, name)
+}
+
+// boardFromAudience returns the name of the local board in the audience, or an empty string
+func boardFromAudience(aud pub.ItemCollection) string {
+	prefix := boardLink("")
+	for _, a := range aud {
+		if a == nil {
+			continue
+		}
+		if name := strings.TrimPrefix(a.GetLink().String(), prefix); name != a.GetLink().String() && boardNameRe.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// itemBoard returns the name of the board the item, or the thread it's part of, was posted in
+func itemBoard(i *Item) string {
+	for ; i != nil; i = i.Parent {
+		if i.HasMetadata() && len(i.Metadata.Board) > 0 {
+			return i.Metadata.Board
+		}
+		if i.OP.HasMetadata() && len(i.OP.Metadata.Board) > 0 {
+			return i.OP.Metadata.Board
+		}
+	}
+	return ""
+}
+
+// boardFollow is the Follow activity of an account for the actor of a board
+type boardFollow struct {
+	Name   string
+	Follow pub.IRI
+}
+
+// boards are the boards of the instance by their name, and the boards each account subscribed to.
+// They're loaded from fedbox, where saveFn stores the changes.
+type boards struct {
+	m             sync.RWMutex
+	saveFn        func(board) (board, error)
+	Boards        map[string]board
+	Subscriptions map[Hash][]boardFollow
+}
+
+// newBoards returns the boards which get saved with saveFn, or kept only in memory when it's nil
+func newBoards(saveFn func(board) (board, error)) *boards {
+	return &boards{saveFn: saveFn, Boards: make(map[string]board), Subscriptions: make(map[Hash][]boardFollow)}
+}
+
+// load replaces the boards and the subscriptions with the ones loaded from fedbox
+func (b *boards) load(list []board, subscriptions map[Hash][]boardFollow) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.Boards = make(map[string]board, len(list))
+	for _, bb := range list {
+		b.Boards[bb.Name] = bb
+	}
+	b.Subscriptions = subscriptions
+}
+
+// List returns the boards, the ones with recent activity first
+func (b *boards) List() []board {
+	if b == nil {
+		return nil
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	list := make([]board, 0, len(b.Boards))
+	for _, bb := range b.Boards {
+		list = append(list, bb)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].LastActivity.Equal(list[j].LastActivity) {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].LastActivity.After(list[j].LastActivity)
+	})
+	return list
+}
+
+// Get returns the board with the name
+func (b *boards) Get(name string) (board, bool) {
+	if b == nil {
+		return board{}, false
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	bb, ok := b.Boards[name]
+	return bb, ok
+}
+
+// ByIRI returns the board with the actor iri
+func (b *boards) ByIRI(iri pub.IRI) (board, bool) {
+	if b == nil || len(iri) == 0 {
+		return board{}, false
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	for _, bb := range b.Boards {
+		if bb.id.Equals(iri, false) {
+			return bb, true
+		}
+	}
+	return board{}, false
+}
+
+// Add creates a new board
+func (b *boards) Add(bb board) error {
+	if !boardNameRe.MatchString(bb.Name) {
+		return errors.BadRequestf("the name of the board can only contain lower case letters, digits and dashes")
+	}
+	if bb.Title = strings.TrimSpace(bb.Title); len(bb.Title) == 0 {
+		bb.Title = bb.Name
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	if _, ok := b.Boards[bb.Name]; ok {
+		return errors.Conflictf("board %s already exists", bb.Name)
+	}
+	return b.save(bb)
+}
+
+// Update changes the board with the name using fn, the board is saved only if fn doesn't return an error
+func (b *boards) Update(name string, fn func(*board) error) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	bb, ok := b.Boards[name]
+	if !ok {
+		return errors.NotFoundf("board %s", name)
+	}
+	if err := fn(&bb); err != nil {
+		return err
+	}
+	return b.save(bb)
+}
+
+// Pin pins the submission at the top of the board, or unpins it
+func (b *boards) Pin(name string, h Hash, pin bool) error {
+	return b.Update(name, func(bb *board) error {
+		pinned := withoutHash(bb.Pinned, h)
+		if pin {
+			if len(pinned) >= boardMaxPinned {
+				return errors.BadRequestf("only %d submissions can be pinned, unpin one of them first", boardMaxPinned)
+			}
+			pinned = append([]Hash{h}, pinned...)
+		}
+		bb.Pinned = pinned
+		return nil
+	})
+}
+
+// Lock stops the comments in the thread of the board, or allows them again
+func (b *boards) Lock(name string, thread Hash, lock bool) error {
+	return b.Update(name, func(bb *board) error {
+		locked := withoutHash(bb.Locked, thread)
+		if lock {
+			locked = append(locked, thread)
+		}
+		bb.Locked = locked
+		return nil
+	})
+}
+
+// LockWikiPage stops the edits of the wiki page for everybody except the moderators of the board,
+// or allows them again
+func (b *boards) LockWikiPage(name, slug string, lock bool) error {
+	return b.Update(name, func(bb *board) error {
+		locked := make([]string, 0, len(bb.WikiLocked))
+		for _, s := range bb.WikiLocked {
+			if s != slug {
+				locked = append(locked, s)
+			}
+		}
+		if lock {
+			locked = append(locked, slug)
+		}
+		bb.WikiLocked = locked
+		return nil
+	})
+}
+
+// Ban stops the account with the handle from posting in the board, or allows it again
+func (b *boards) Ban(name, handle string, ban bool) error {
+	handle = strings.TrimLeft(strings.TrimSpace(handle), "~@")
+	if len(handle) == 0 {
+		return errors.BadRequestf("the handle of the account is missing")
+	}
+	return b.Update(name, func(bb *board) error {
+		for _, m := range bb.Moderators {
+			if ban && strings.EqualFold(m, handle) {
+				return errors.BadRequestf("the moderators of the board can't be banned from it")
+			}
+		}
+		banned := make([]string, 0, len(bb.Banned))
+		for _, hh := range bb.Banned {
+			if !strings.EqualFold(hh, handle) {
+				banned = append(banned, hh)
+			}
+		}
+		if ban {
+			banned = append(banned, handle)
+		}
+		bb.Banned = banned
+		return nil
+	})
+}
+
+// Subscribed returns the names of the boards the account subscribed to
+func (b *boards) Subscribed(acc Hash) []string {
+	if b == nil {
+		return nil
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	names := make([]string, 0, len(b.Subscriptions[acc]))
+	for _, f := range b.Subscriptions[acc] {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// IsSubscribed returns if the account subscribed to the board with the name
+func (b *boards) IsSubscribed(acc Hash, name string) bool {
+	_, ok := b.Follow(acc, name)
+	return ok
+}
+
+// Follow returns the IRI of the Follow activity the account sent to the board with the name
+func (b *boards) Follow(acc Hash, name string) (pub.IRI, bool) {
+	if b == nil {
+		return "", false
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	for _, f := range b.Subscriptions[acc] {
+		if f.Name == name {
+			return f.Follow, true
+		}
+	}
+	return "", false
+}
+
+// Subscribers returns the number of accounts subscribed to the board with the name
+func (b *boards) Subscribers(name string) int {
+	if b == nil {
+		return 0
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	cnt := 0
+	for _, follows := range b.Subscriptions {
+		for _, f := range follows {
+			if f.Name == name {
+				cnt++
+			}
+		}
+	}
+	return cnt
+}
+
+// Subscribe records the Follow activity of the account for the board with the name,
+// or removes it when follow is empty, the activities themselves are saved in fedbox
+func (b *boards) Subscribe(acc Hash, name string, follow pub.IRI) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if _, ok := b.Boards[name]; !ok {
+		return errors.NotFoundf("board %s", name)
+	}
+	follows := make([]boardFollow, 0)
+	for _, f := range b.Subscriptions[acc] {
+		if f.Name != name {
+			follows = append(follows, f)
+		}
+	}
+	if len(follow) > 0 {
+		follows = append(follows, boardFollow{Name: name, Follow: follow})
+	}
+	if len(follows) == 0 {
+		delete(b.Subscriptions, acc)
+	} else {
+		b.Subscriptions[acc] = follows
+	}
+	return nil
+}
+
+// Record counts a new submission, or comment, posted in the board
+func (b *boards) Record(name string, comment bool) error {
+	if b == nil {
+		return nil
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	bb, ok := b.Boards[name]
+	if !ok {
+		return nil
+	}
+	if comment {
+		bb.Comments++
+	} else {
+		bb.Submissions++
+	}
+	bb.LastActivity = time.Now().UTC()
+	return b.save(bb)
+}
+
+// save stores the board, it needs to be called with the lock held
+func (b *boards) save(bb board) error {
+	if b.saveFn != nil {
+		saved, err := b.saveFn(bb)
+		if err != nil {
+			return err
+		}
+		bb = saved
+	}
+	b.Boards[bb.Name] = bb
+	return nil
+}
+
+// boardActor returns the Group actor which represents the board in fedbox
+func boardActor(b board) (*pub.Actor, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &pub.Actor{ID: b.id, Type: pub.GroupType}
+	p.PreferredUsername = pub.NaturalLanguageValuesNew()
+	p.PreferredUsername.Set(pub.NilLangRef, pub.Content(b.Name))
+	p.Name = pub.NaturalLanguageValuesNew()
+	p.Name.Set(pub.NilLangRef, pub.Content(b.Title))
+	if len(b.Description) > 0 {
+		p.Summary = pub.NaturalLanguageValuesNew()
+		p.Summary.Set(pub.NilLangRef, pub.Content(b.Description))
+	}
+	p.URL = b.IRI()
+	p.Published = b.CreatedAt
+	p.Updated = time.Now().UTC()
+
+	settings := &pub.Object{Type: boardSettingsType}
+	settings.MediaType = "application/json"
+	settings.Content = pub.NaturalLanguageValuesNew()
+	settings.Content.Set(pub.NilLangRef, pub.Content(data))
+	p.Attachment = pub.ItemCollection{settings}
+	return p, nil
+}
+
+// boardFromActivityPub loads the board from its Group actor, or from the activity which created or updated it
+func boardFromActivityPub(it pub.Item) (board, error) {
+	b := board{}
+	if it == nil {
+		return b, errors.Newf("nil item received")
+	}
+	if typ := it.GetType(); typ == pub.CreateType || typ == pub.UpdateType {
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			it = a.Object
+			return nil
+		})
+	}
+	if it == nil || it.GetType() != pub.GroupType {
+		return b, errors.NotValidf("the board needs to be a Group actor")
+	}
+	err := pub.OnActor(it, func(p *pub.Actor) error {
+		for _, att := range attachmentItems(p.Attachment) {
+			if att == nil || att.GetType() != boardSettingsType {
+				continue
+			}
+			pub.OnObject(att, func(o *pub.Object) error {
+				return json.Unmarshal([]byte(o.Content.First().Value.String()), &b)
+			})
+			break
+		}
+		b.id = p.GetLink()
+		b.Name = p.PreferredUsername.First().Value.String()
+		b.Title = p.Name.First().Value.String()
+		b.Description = p.Summary.First().Value.String()
+		b.CreatedAt = p.Published
+		return nil
+	})
+	if err == nil && !boardNameRe.MatchString(b.Name) {
+		err = errors.NotValidf("invalid board name %q", b.Name)
+	}
+	return b, err
+}
+
+// saveBoard creates the Group actor of the board in fedbox, or updates it, as the application actor
+func (r *repository) saveBoard(ctx context.Context, b board) (board, error) {
+	if r.app == nil || r.fedbox == nil {
+		return b, errors.NotValidf("the boards can't be saved until the instance is ready")
+	}
+	p, err := boardActor(b)
+	if err != nil {
+		return b, errors.Annotatef(err, "unable to marshal the board")
+	}
+	fx := r.fedbox.Service()
+	p.To = pub.ItemCollection{pub.PublicNS}
+	p.BCC = pub.ItemCollection{fx.ID}
+
+	act := &pub.Activity{
+		Type:    pub.UpdateType,
+		To:      pub.ItemCollection{pub.PublicNS},
+		BCC:     pub.ItemCollection{fx.ID},
+		Actor:   r.app.pub.GetLink(),
+		Object:  p,
+		Updated: p.Updated,
+	}
+	if len(b.id) == 0 {
+		act.Type = pub.CreateType
+	}
+	_, ap, err := r.WithAccount(r.app).fedbox.ToOutbox(ctx, act)
+	if err != nil {
+		r.errFn(log.Ctx{"err": err, "board": b.Name})("board save failed")
+		return b, err
+	}
+	if len(b.id) == 0 {
+		b.id = createdIRI(ap)
+	}
+	return b, nil
+}
+
+// createdIRI returns the IRI of the object fedbox created, from the Create activity it returned
+func createdIRI(ap pub.Item) pub.IRI {
+	if ap == nil {
+		return ""
+	}
+	iri := ap.GetLink()
+	if ap.GetType() == pub.CreateType {
+		pub.OnActivity(ap, func(a *pub.Activity) error {
+			if a.Object != nil {
+				iri = a.Object.GetLink()
+			}
+			return nil
+		})
+	}
+	return iri
+}
+
+// loadBoards loads the Group actors of the boards from fedbox, and the Follow activities of the accounts
+// which subscribed to them
+func (r *repository) loadBoards(ctx context.Context) error {
+	list := make([]board, 0)
+	actorsFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Actors(ctx, Values(f))
+	}
+	groups := &Filters{Type: ActivityTypesFilter(pub.GroupType), MaxItems: MaxContentItems}
+	err := LoadFromCollection(ctx, actorsFn, &colCursor{filters: groups}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			b, err := boardFromActivityPub(it)
+			if err != nil {
+				r.errFn(log.Ctx{"err": err, "iri": it.GetLink()})("unable to load the board")
+				continue
+			}
+			list = append(list, b)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the boards")
+	}
+
+	activitiesFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Activities(ctx, Values(f))
+	}
+	undone := make(pub.IRIs, 0)
+	undos := &Filters{
+		Type:     ActivityTypesFilter(pub.UndoType),
+		Object:   &Filters{Type: ActivityTypesFilter(pub.FollowType)},
+		MaxItems: MaxContentItems,
+	}
+	err = LoadFromCollection(ctx, activitiesFn, &colCursor{filters: undos}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			pub.OnActivity(it, func(a *pub.Activity) error {
+				if a.Object != nil {
+					undone = append(undone, a.Object.GetLink())
+				}
+				return nil
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the undone subscriptions")
+	}
+
+	subscriptions := make(map[Hash][]boardFollow)
+	follows := &Filters{
+		Type:     ActivityTypesFilter(pub.FollowType),
+		Object:   &Filters{Type: ActivityTypesFilter(pub.GroupType)},
+		MaxItems: MaxContentItems,
+	}
+	err = LoadFromCollection(ctx, activitiesFn, &colCursor{filters: follows}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			pub.OnActivity(it, func(a *pub.Activity) error {
+				if a.Object == nil || a.Actor == nil || undone.Contains(a.GetLink()) {
+					return nil
+				}
+				for _, b := range list {
+					if !b.id.Equals(a.Object.GetLink(), false) {
+						continue
+					}
+					acc := HashFromIRI(a.Actor.GetLink())
+					for _, f := range subscriptions[acc] {
+						if f.Name == b.Name {
+							return nil
+						}
+					}
+					subscriptions[acc] = append(subscriptions[acc], boardFollow{Name: b.Name, Follow: a.GetLink()})
+				}
+				return nil
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the subscriptions to the boards")
+	}
+	r.boards.load(list, subscriptions)
+	r.infoFn(log.Ctx{"boards": len(list), "subscribers": len(subscriptions)})("loaded the boards")
+	return nil
+}
+
+// FollowBoard creates a Follow activity from the account to the actor of the board
+func (r *repository) FollowBoard(ctx context.Context, er Account, b board) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	if len(b.id) == 0 {
+		return errors.NotFoundf("invalid board %s", b.Name)
+	}
+	if r.boards.IsSubscribed(er.Hash, b.Name) {
+		return nil
+	}
+	follower := r.loadAPPerson(er)
+
+	follow := new(pub.Follow)
+	follow.Type = pub.FollowType
+	follow.Name = make(pub.NaturalLanguageValues, 0)
+	follow.Name.Set(pub.NilLangRef, pub.Content(b.Name))
+	follow.To = pub.ItemCollection{b.id}
+	follow.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	follow.Object = b.id
+	follow.Actor = follower.GetLink()
+	iri, _, err := r.fedbox.ToOutbox(ctx, follow)
+	if err != nil {
+		r.errFn(log.Ctx{
+			"err":      err,
+			"follower": er.Handle,
+			"board":    b.Name,
+		})("Unable to follow board")
+		return err
+	}
+	return r.boards.Subscribe(er.Hash, b.Name, iri)
+}
+
+// UnfollowBoard undoes the Follow activity that er has created for the board
+func (r *repository) UnfollowBoard(ctx context.Context, er Account, b board) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	iri, ok := r.boards.Follow(er.Hash, b.Name)
+	if !ok {
+		return errors.NotFoundf("board %s is not followed", b.Name)
+	}
+	follower := r.loadAPPerson(er)
+
+	undo := new(pub.Activity)
+	undo.Type = pub.UndoType
+	undo.To = pub.ItemCollection{b.id}
+	undo.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	undo.Object = iri
+	undo.Actor = follower.GetLink()
+	if _, _, err := r.fedbox.ToOutbox(ctx, undo); err != nil {
+		r.errFn(log.Ctx{
+			"err":      err,
+			"follower": er.Handle,
+			"board":    b.Name,
+		})("Unable to unfollow board")
+		return err
+	}
+	return r.boards.Subscribe(er.Hash, b.Name, "")
+}
+
+// accountKarma returns the sum of the scores of the latest items submitted by the account
+func (r *repository) accountKarma(ctx context.Context, acc *Account) (int, error) {
+	if !acc.HasMetadata() {
+		return 0, errors.Unauthorizedf("invalid account")
+	}
+	actor, err := r.LoadAccount(ctx, pub.IRI(acc.Metadata.ID))
+	if err != nil {
+		return 0, err
+	}
+	f := &Filters{
+		Type:     CreateActivitiesFilter,
+		Object:   &Filters{Type: ActivityTypesFilter(ValidContentTypes...)},
+		MaxItems: MaxContentItems,
+	}
+	c, err := r.LoadActorOutbox(ctx, actor.pub, f)
+	if err != nil {
+		return 0, err
+	}
+	karma := 0
+	for _, it := range c.items {
+		if i, ok := it.(*Item); ok {
+			karma += i.Score
+		}
+	}
+	return karma, nil
+}
+
+// canCreateBoard returns if acc is allowed to create boards by the policy of the instance
+func (h *handler) canCreateBoard(ctx context.Context, c *config.Configuration, acc *Account) (bool, string) {
+	if !acc.IsLogged() {
+		return false, "You need to be logged in to create boards."
+	}
+	if isModerator(c, acc) {
+		return true, ""
+	}
+	switch c.BoardCreation {
+	case BoardCreationOpen:
+		return true, ""
+	case BoardCreationKarma:
+		karma, err := h.storage.accountKarma(ctx, acc)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to load the karma of the account")
+			return false, "Unable to check the score of your submissions."
+		}
+		if karma < c.BoardCreationKarma {
+			return false, fmt.Sprintf("You need a score of %d for your recent submissions to create boards.", c.BoardCreationKarma)
+		}
+		return true, ""
+	}
+	return false, "Only the moderators can create boards."
+}
+
+// canEditBoard returns if acc can change the rules and the template of the board: the moderators
+// of the instance, the ones of the board and the account which created it
+func canEditBoard(c *config.Configuration, acc *Account, b board) bool {
+	if !acc.IsLogged() {
+		return false
+	}
+	return isModerator(c, acc) || isBoardModerator(b, acc) || (len(b.CreatedBy) > 0 && b.CreatedBy == acc.Handle)
+}
+
+// isBoardModerator returns if acc is a local account, which the admins appointed as moderator of the board
+func isBoardModerator(b board, acc *Account) bool {
+	return localHandleIn(b.Moderators, acc)
+}
+
+// canModerateBoard returns if acc can pin and lock the threads of the board, and ban accounts from it:
+// the moderators of the instance and the ones of the board
+func canModerateBoard(c *config.Configuration, acc *Account, b board) bool {
+	return isModerator(c, acc) || isBoardModerator(b, acc)
+}
+
+// canModerateItem returns if acc can remove the item, or slow down its thread: the moderators of the instance
+// can do it everywhere, the ones of a board only for the items posted in it
+func canModerateItem(c *config.Configuration, bb *boards, acc *Account, it *Item) bool {
+	if isModerator(c, acc) {
+		return true
+	}
+	b, ok := bb.Get(itemBoard(it))
+	return ok && isBoardModerator(b, acc)
+}
+
+// checkBoardRestrictions returns an error if acc can't post the item in its board: the accounts banned
+// from the board can't post in it, and the locked threads can't be commented on. The moderators are not restricted.
+func checkBoardRestrictions(c *config.Configuration, bb *boards, acc *Account, it *Item) error {
+	b, ok := bb.Get(itemBoard(it))
+	if !ok || canModerateBoard(c, acc, b) {
+		return nil
+	}
+	if b.IsBanned(acc) {
+		return errors.Forbiddenf("you were banned from posting in %s", b.Title)
+	}
+	if it.Parent.IsValid() && !it.Hash.IsValid() && b.IsLocked(threadHash(it)) {
+		return errors.Forbiddenf("the thread is locked, it can't be commented on anymore")
+	}
+	return nil
+}
+
+// boardModerators returns the handles from the moderators field of the board form, separated by commas or spaces
+func boardModerators(s string) []string {
+	handles := make([]string, 0)
+	for _, h := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		h = strings.TrimLeft(h, "~@")
+		if len(h) > 0 && !stringInSlice(handles)(h) {
+			handles = append(handles, h)
+		}
+	}
+	return handles
+}
+
+// unchangedTemplate returns if the text of a submission is the template of its board, without anything added
+func unchangedTemplate(b board, data string) bool {
+	return len(b.Template) > 0 && strings.TrimSpace(data) == b.Template
+}
+
+type boardInfo struct {
+	board
+	Subscribers int
+	Subscribed  bool
+}
+
+type boardsModel struct {
+	Title     string
+	Boards    []boardInfo
+	CanCreate bool
+}
+
+func (m *boardsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (boardsModel) Template() string {
+	return "boards"
+}
+
+// HandleBoards serves the /boards requests, with the directory of the boards and their activity
+func (h *handler) HandleBoards(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	m := &boardsModel{Title: "Boards"}
+	for _, b := range h.storage.boards.List() {
+		m.Boards = append(m.Boards, boardInfo{
+			board:       b,
+			Subscribers: h.storage.boards.Subscribers(b.Name),
+			Subscribed:  h.storage.boards.IsSubscribed(acc.Hash, b.Name),
+		})
+	}
+	m.CanCreate = acc.IsLogged() && (isModerator(&h.conf.Configuration, acc) || h.conf.BoardCreation != BoardCreationAdmin)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleCreateBoard handles the POST /boards requests, which create a board if the policy of the instance allows it
+func (h *handler) HandleCreateBoard(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	if ok, msg := h.canCreateBoard(r.Context(), &h.conf.Configuration, acc); !ok {
+		h.v.addFlashMessage(Error, w, r, msg)
+		h.v.Redirect(w, r, "/boards", http.StatusSeeOther)
+		return
+	}
+	b := board{
+		Name:        strings.ToLower(strings.TrimSpace(r.PostFormValue("name"))),
+		Title:       r.PostFormValue("title"),
+		Description: strings.TrimSpace(r.PostFormValue("description")),
+		CreatedBy:   acc.Handle,
+		CreatedAt:   time.Now().UTC(),
+	}
+	lCtx := log.Ctx{"board": b.Name, "author": acc.Handle}
+	if err := h.storage.boards.Add(b); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to create the board")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to create the board: %s", err))
+		h.v.Redirect(w, r, "/boards", http.StatusSeeOther)
+		return
+	}
+	h.infoFn(lCtx)("board created")
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The board %s was created.", b.Name))
+	h.v.Redirect(w, r, b.Link(), http.StatusSeeOther)
+}
+
+// HandleBoardSubscription handles the POST /b/{name}/subscribe and /b/{name}/unsubscribe requests
+func (h *handler) HandleBoardSubscription(subscribe bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acc := loggedAccount(r)
+		name := chi.URLParam(r, "name")
+		b, ok := h.storage.boards.Get(name)
+		if !ok {
+			h.v.HandleErrors(w, r, errors.NotFoundf("board %s", name))
+			return
+		}
+		var err error
+		if subscribe {
+			err = h.storage.FollowBoard(r.Context(), *acc, b)
+		} else {
+			err = h.storage.UnfollowBoard(r.Context(), *acc, b)
+		}
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if subscribe {
+			h.v.addFlashMessage(Success, w, r, fmt.Sprintf("You subscribed to %s, its submissions are in your home feed.", name))
+		} else {
+			h.v.addFlashMessage(Success, w, r, fmt.Sprintf("You unsubscribed from %s.", name))
+		}
+		h.v.Redirect(w, r, fmt.Sprintf("/b/%s", name), http.StatusSeeOther)
+	}
+}
+
+// boardFilters returns the filters for the submissions posted in the boards with the names
+func boardFilters(r *http.Request, names ...string) *Filters {
+	f := FiltersFromRequest(r)
+	f.Type = CreateActivitiesFilter
+	for _, n := range names {
+		f.Recipients = append(f.Recipients, EqualsString(boardLink(n)))
+	}
+	f.Object = new(Filters)
+	f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+	f.Object.OP = nilIRIs
+	return f
+}
+
+// BoardFiltersMw loads the filters for the /b/{name} listing of the board's submissions
+func BoardFiltersMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		b, ok := ContextRepository(r.Context()).boards.Get(name)
+		if !ok {
+			ctxtErr(next, w, r, errors.NotFoundf("board %s", name))
+			return
+		}
+		m := ContextListingModel(r.Context())
+		m.Title = b.Title
+		m.ShowText = true
+		m.Board = &b
+		ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{boardFilters(r, b.Name)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// pinnedFirst wraps the sorting of the listing, so the pinned items are shown before the others
+func pinnedFirst(pinned []Hash, sortFn func(RenderableList) []Renderable) func(RenderableList) []Renderable {
+	return func(list RenderableList) []Renderable {
+		rl := sortFn(list)
+		positions := make(map[Hash]int, len(pinned))
+		for i, h := range pinned {
+			positions[h] = i
+		}
+		sort.SliceStable(rl, func(i, j int) bool {
+			pi, oki := positions[rl[i].ID()]
+			pj, okj := positions[rl[j].ID()]
+			if oki && okj {
+				return pi < pj
+			}
+			return oki
+		})
+		return rl
+	}
+}
+
+// BoardPinsMw shows the pinned submissions of the board at the top of its listing,
+// the ones which aren't on the first page get loaded separately
+func BoardPinsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer next.ServeHTTP(w, r)
+
+		m := ContextListingModel(r.Context())
+		if m == nil || m.Board == nil || len(m.Board.Pinned) == 0 {
+			return
+		}
+		if c := ContextCursor(r.Context()); c != nil && len(r.URL.Query().Get("after")) == 0 {
+			repo := ContextRepository(r.Context())
+			for _, h := range m.Board.Pinned {
+				if _, ok := c.items[h]; ok {
+					continue
+				}
+				it, err := repo.LoadItem(r.Context(), objects.IRI(repo.fedbox.Service()).AddPath(h.String()))
+				if err != nil || it.Deleted() {
+					repo.errFn(log.Ctx{"err": err, "board": m.Board.Name, "hash": h})("unable to load the pinned submission")
+					continue
+				}
+				c.items.Append(&it)
+			}
+		}
+		if m.sortFn != nil {
+			m.sortFn = pinnedFirst(m.Board.Pinned, m.sortFn)
+		}
+	})
+}
+
+type boardEditModel struct {
+	Title  string
+	Board  board
+	Themes []string
+}
+
+func (m *boardEditModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (boardEditModel) Template() string {
+	return "board-edit"
+}
+
+// loadEditableBoard returns the board from the URL, if the logged account can edit it
+func (h *handler) loadEditableBoard(r *http.Request) (board, error) {
+	name := chi.URLParam(r, "name")
+	b, ok := h.storage.boards.Get(name)
+	if !ok {
+		return b, errors.NotFoundf("board %s", name)
+	}
+	if !canEditBoard(&h.conf.Configuration, loggedAccount(r), b) {
+		return b, errors.Forbiddenf("only the moderators and the creator of the board can change it")
+	}
+	return b, nil
+}
+
+// HandleBoardEditForm serves the /b/{name}/edit GET requests, with the form for the rules and the submission template
+func (h *handler) HandleBoardEditForm(w http.ResponseWriter, r *http.Request) {
+	b, err := h.loadEditableBoard(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &boardEditModel{Title: fmt.Sprintf("Edit %s", b.Title), Board: b, Themes: boardThemeNames()}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleBoardEdit handles the /b/{name}/edit POST requests, which save the rules, the submission template
+// and the theme of the board
+func (h *handler) HandleBoardEdit(w http.ResponseWriter, r *http.Request) {
+	b, err := h.loadEditableBoard(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	lCtx := log.Ctx{"board": b.Name, "editor": loggedAccount(r).Handle}
+	theme := r.PostFormValue("theme")
+	if _, ok := boardThemes[theme]; !ok {
+		theme = ""
+	}
+	css, err := sanitizeBoardCSS(r.PostFormValue("css"))
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to use the CSS: %s", err))
+		h.v.Redirect(w, r, b.Link()+"/edit", http.StatusSeeOther)
+		return
+	}
+	err = h.storage.boards.Update(b.Name, func(b *board) error {
+		b.Rules = strings.TrimSpace(r.PostFormValue("rules"))
+		b.Template = strings.TrimSpace(r.PostFormValue("template"))
+		b.Theme = theme
+		b.CSS = css
+		b.WikiEdit = r.PostFormValue("wiki-edit")
+		if b.WikiEdit != WikiEditSubscribers && b.WikiEdit != WikiEditLogged {
+			b.WikiEdit = WikiEditModerators
+		}
+		// NOTE(marius): only the admins appoint the moderators of the boards
+		if isAdmin(&h.conf.Configuration, loggedAccount(r)) {
+			b.Moderators = boardModerators(r.PostFormValue("moderators"))
+		}
+		return nil
+	})
+	if err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the board")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the board"))
+		return
+	}
+	h.infoFn(lCtx)("board updated")
+	h.v.addFlashMessage(Success, w, r, "The board was saved.")
+	h.v.Redirect(w, r, b.Link(), http.StatusSeeOther)
+}
+
+// boardItem returns the submission from the URL, and the board it was posted in
+func (h *handler) boardItem(r *http.Request) (*Item, board, error) {
+	var it *Item
+	if c := ContextCursor(r.Context()); c != nil {
+		it = getItemFromList(HashFromString(chi.URLParam(r, "hash")), c.items)
+	}
+	if it == nil || it.Deleted() {
+		return nil, board{}, errors.NotFoundf("item")
+	}
+	if it.Parent.IsValid() {
+		return nil, board{}, errors.BadRequestf("only the submissions can be pinned or locked, not the comments")
+	}
+	b, ok := h.storage.boards.Get(itemBoard(it))
+	if !ok {
+		return nil, board{}, errors.BadRequestf("only the submissions posted in boards can be pinned or locked")
+	}
+	return it, b, nil
+}
+
+// HandleBoardPin handles the /{hash}/pin POST requests, from the moderators of the board,
+// which pin the submission at the top of its board, or unpin it
+func (h *handler) HandleBoardPin(w http.ResponseWriter, r *http.Request) {
+	it, b, err := h.boardItem(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	pin := r.PostFormValue("action") != "unpin"
+	lCtx := log.Ctx{"board": b.Name, "hash": it.Hash, "moderator": loggedAccount(r).Handle, "pin": pin}
+	if err := h.storage.boards.Pin(b.Name, it.Hash, pin); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to pin the submission")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to pin the submission: %s", err))
+		h.v.Redirect(w, r, ItemPermaLink(it), http.StatusSeeOther)
+		return
+	}
+	h.infoFn(lCtx)("submission pinned")
+	if pin {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The submission is pinned at the top of %s.", b.Title))
+	} else {
+		h.v.addFlashMessage(Success, w, r, "The submission was unpinned.")
+	}
+	h.v.Redirect(w, r, b.Link(), http.StatusSeeOther)
+}
+
+// HandleBoardLock handles the /{hash}/lock POST requests, from the moderators of the board,
+// which stop the comments in the thread, or allow them again
+func (h *handler) HandleBoardLock(w http.ResponseWriter, r *http.Request) {
+	it, b, err := h.boardItem(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	lock := r.PostFormValue("action") != "unlock"
+	lCtx := log.Ctx{"board": b.Name, "thread": it.Hash, "moderator": loggedAccount(r).Handle, "lock": lock}
+	if err := h.storage.boards.Lock(b.Name, it.Hash, lock); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to lock the thread")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to lock the thread"))
+		return
+	}
+	h.infoFn(lCtx)("thread locked")
+	if lock {
+		h.v.addFlashMessage(Success, w, r, "The thread is locked, it can't be commented on anymore.")
+	} else {
+		h.v.addFlashMessage(Success, w, r, "The thread was unlocked.")
+	}
+	h.v.Redirect(w, r, ItemPermaLink(it), http.StatusSeeOther)
+}
+
+// HandleBoardBan handles the /b/{name}/ban POST requests, from the moderators of the board,
+// which stop an account from posting in the board, or allow it again
+func (h *handler) HandleBoardBan(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	b, ok := h.storage.boards.Get(name)
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("board %s", name))
+		return
+	}
+	mod := loggedAccount(r)
+	if !canModerateBoard(&h.conf.Configuration, mod, b) {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("only the moderators of the board can ban accounts from it"))
+		return
+	}
+	handle := strings.TrimLeft(strings.TrimSpace(r.PostFormValue("handle")), "~@")
+	ban := r.PostFormValue("action") != "unban"
+	lCtx := log.Ctx{"board": b.Name, "handle": handle, "moderator": mod.Handle, "ban": ban}
+	if err := h.storage.boards.Ban(b.Name, handle, ban); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to ban the account from the board")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to ban %s: %s", handle, err))
+		h.v.Redirect(w, r, b.Link()+"/edit", http.StatusSeeOther)
+		return
+	}
+	h.infoFn(lCtx)("account banned from the board")
+	if ban {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%s can't post in %s anymore.", handle, b.Title))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%s can post in %s again.", handle, b.Title))
+	}
+	h.v.Redirect(w, r, b.Link()+"/edit", http.StatusSeeOther)
+}
+
+// BoardSubmitMw loads the board from the ?board= parameter of the submission form, for showing its rules
+// and starting the text from its template
+func (h *handler) BoardSubmitMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := ContextContentModel(r.Context())
+		if m != nil {
+			if b, ok := h.storage.boards.Get(r.URL.Query().Get("board")); ok {
+				m.Board = &b
+				if len(m.Message.Content) == 0 {
+					m.Message.Content = b.Template
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
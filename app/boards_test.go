@@ -0,0 +1,325 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestBoards(t *testing.T) {
+	b := newBoards(nil)
+	for _, name := range []string{"", "a", "Golang", "-go", "go lang"} {
+		if err := b.Add(board{Name: name}); err == nil {
+			t.Errorf("the board with the invalid name %q was created", name)
+		}
+	}
+	if err := b.Add(board{Name: "golang", Title: "  "}); err != nil {
+		t.Fatalf("unable to create the board: %s", err)
+	}
+	if err := b.Add(board{Name: "golang", Title: "Go"}); err == nil {
+		t.Errorf("the board was created twice")
+	}
+	if err := b.Add(board{Name: "rust"}); err != nil {
+		t.Fatalf("unable to create the board: %s", err)
+	}
+
+	acc := HashFromString("9f4c0b2e-7d34-4d1e-9b1a-3f5c2a6e8d10")
+	follow := pub.IRI("https://fedbox.example.com/activities/1")
+	if err := b.Subscribe(acc, "missing", follow); err == nil {
+		t.Errorf("subscribed to a board which doesn't exist")
+	}
+	if err := b.Subscribe(acc, "golang", follow); err != nil {
+		t.Fatalf("unable to subscribe: %s", err)
+	}
+	if err := b.Subscribe(acc, "golang", follow); err != nil {
+		t.Fatalf("unable to subscribe: %s", err)
+	}
+	if n := b.Subscribers("golang"); n != 1 {
+		t.Errorf("expected 1 subscriber, got %d", n)
+	}
+	if iri, ok := b.Follow(acc, "golang"); !ok || iri != follow {
+		t.Errorf("expected the Follow activity %s, got %s", follow, iri)
+	}
+	if err := b.Update("missing", func(*board) error { return nil }); err == nil {
+		t.Errorf("updated a board which doesn't exist")
+	}
+	err := b.Update("golang", func(b *board) error {
+		b.Rules, b.Template = "1. Only Go", "**Link:**\n**Why:**"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to update the board: %s", err)
+	}
+	if err := b.Record("golang", false); err != nil {
+		t.Fatalf("unable to record the activity: %s", err)
+	}
+	if err := b.Record("golang", true); err != nil {
+		t.Fatalf("unable to record the activity: %s", err)
+	}
+
+	g, ok := b.Get("golang")
+	if !ok {
+		t.Fatalf("the board was not created")
+	}
+	if g.Title != "golang" || g.Submissions != 1 || g.Comments != 1 {
+		t.Errorf("unexpected board %v", g)
+	}
+	if g.Rules != "1. Only Go" || !unchangedTemplate(g, "**Link:**\n**Why:**") || unchangedTemplate(g, "**Link:** go.dev\n**Why:**") {
+		t.Errorf("unexpected rules or template %q %q", g.Rules, g.Template)
+	}
+	if list := b.List(); len(list) != 2 || list[0].Name != "golang" {
+		t.Errorf("the boards with recent activity should be first: %v", list)
+	}
+	if !b.IsSubscribed(acc, "golang") || b.IsSubscribed(acc, "rust") {
+		t.Errorf("unexpected subscriptions %v", b.Subscribed(acc))
+	}
+	if err := b.Subscribe(acc, "golang", ""); err != nil {
+		t.Fatalf("unable to unsubscribe: %s", err)
+	}
+	if b.IsSubscribed(acc, "golang") {
+		t.Errorf("still subscribed after unsubscribing")
+	}
+}
+
+func TestBoardActor(t *testing.T) {
+	b := board{
+		id:          "https://fedbox.example.com/actors/1",
+		Name:        "golang",
+		Title:       "Go",
+		Description: "The Go programming language",
+		CreatedBy:   "gopher",
+		CreatedAt:   time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		Rules:       "1. Only Go",
+		Moderators:  []string{"jane"},
+		Pinned:      []Hash{testHash(1)},
+		Banned:      []string{"spammer"},
+	}
+	p, err := boardActor(b)
+	if err != nil {
+		t.Fatalf("unable to build the actor of the board: %s", err)
+	}
+	if p.Type != pub.GroupType || p.GetLink() != b.id || p.PreferredUsername.First().Value.String() != "golang" {
+		t.Errorf("unexpected actor %s %s", p.Type, p.GetLink())
+	}
+	if p.URL.GetLink() != b.IRI() {
+		t.Errorf("the URL of the actor is %s, expected the page of the board %s", p.URL.GetLink(), b.IRI())
+	}
+
+	for _, it := range []pub.Item{p, &pub.Activity{Type: pub.CreateType, Object: p}} {
+		loaded, err := boardFromActivityPub(it)
+		if err != nil {
+			t.Fatalf("unable to load the board from %s: %s", it.GetType(), err)
+		}
+		if loaded.id != b.id || loaded.Name != b.Name || loaded.Title != b.Title || loaded.Description != b.Description || !loaded.CreatedAt.Equal(b.CreatedAt) {
+			t.Errorf("unexpected board %+v", loaded)
+		}
+		if loaded.Rules != b.Rules || loaded.CreatedBy != b.CreatedBy || !isBoardModerator(loaded, &Account{Hash: testHash(2), Handle: "jane"}) {
+			t.Errorf("the settings of the board were not loaded: %+v", loaded)
+		}
+		if !loaded.IsPinned(testHash(1)) || len(loaded.Banned) != 1 {
+			t.Errorf("the moderation of the board was not loaded: %+v", loaded)
+		}
+	}
+	if _, err := boardFromActivityPub(&pub.Actor{Type: pub.PersonType}); err == nil {
+		t.Errorf("loaded a board from a Person actor")
+	}
+}
+
+func TestBoardModerators(t *testing.T) {
+	c := &config.Configuration{Moderators: []string{"admin"}}
+	bb := &boards{Boards: map[string]board{
+		"golang": {Name: "golang", CreatedBy: "gopher", Moderators: boardModerators("~jane, @john  jane")},
+		"rust":   {Name: "rust"},
+	}}
+	admin := &Account{Hash: testHash(1), Handle: "admin"}
+	jane := &Account{Hash: testHash(2), Handle: "Jane"}
+	other := &Account{Hash: testHash(3), Handle: "other"}
+
+	if m := bb.Boards["golang"].Moderators; len(m) != 2 || m[0] != "jane" || m[1] != "john" {
+		t.Errorf("unexpected moderators %v", m)
+	}
+	inGolang := &Item{Hash: testHash(10), Metadata: &ItemMetadata{Board: "golang"}}
+	inRust := &Item{Hash: testHash(11), Metadata: &ItemMetadata{Board: "rust"}}
+	reply := &Item{Hash: testHash(12), Parent: inGolang}
+	if !canModerateItem(c, bb, jane, inGolang) || !canModerateItem(c, bb, jane, reply) {
+		t.Errorf("the board moderator can't moderate the items of the board")
+	}
+	if canModerateItem(c, bb, jane, inRust) || canModerateItem(c, bb, jane, &Item{Hash: testHash(13)}) {
+		t.Errorf("the board moderator can moderate the items outside of the board")
+	}
+	if !canModerateItem(c, bb, admin, inRust) || canModerateItem(c, bb, other, inGolang) {
+		t.Errorf("unexpected moderation rights")
+	}
+	if !canEditBoard(c, jane, bb.Boards["golang"]) || canEditBoard(c, jane, bb.Boards["rust"]) {
+		t.Errorf("the board moderator can only edit their board")
+	}
+}
+
+func TestBoardAdmins(t *testing.T) {
+	c := &config.Configuration{Admins: []string{"~root"}, Moderators: []string{"admin"}}
+	root := &Account{Hash: testHash(1), Handle: "Root"}
+	admin := &Account{Hash: testHash(2), Handle: "admin"}
+
+	if !isAdmin(c, root) || !isModerator(c, root) {
+		t.Errorf("the admin is expected to be a moderator too")
+	}
+	if isAdmin(c, admin) || !isModerator(c, admin) {
+		t.Errorf("the moderator is expected not to be an admin")
+	}
+	if isAdmin(nil, root) || isAdmin(c, nil) || isAdmin(c, &Account{Hash: AnonymousHash, Handle: Anonymous}) {
+		t.Errorf("unexpected admin rights")
+	}
+}
+
+func TestBoardRestrictions(t *testing.T) {
+	c := &config.Configuration{Moderators: []string{"admin"}}
+	bb := newBoards(nil)
+	if err := bb.Add(board{Name: "golang", Moderators: []string{"jane"}}); err != nil {
+		t.Fatalf("unable to create the board: %s", err)
+	}
+	jane := &Account{Hash: testHash(1), Handle: "jane"}
+	spam := &Account{Hash: testHash(2), Handle: "Spammer"}
+	admin := &Account{Hash: testHash(3), Handle: "admin"}
+
+	for i := 1; i <= boardMaxPinned; i++ {
+		if err := bb.Pin("golang", testHash(10+i), true); err != nil {
+			t.Fatalf("unable to pin the submission: %s", err)
+		}
+	}
+	if err := bb.Pin("golang", testHash(20), true); err == nil {
+		t.Errorf("pinned more than %d submissions", boardMaxPinned)
+	}
+	if err := bb.Pin("golang", testHash(11), true); err != nil {
+		t.Errorf("unable to pin the submission again: %s", err)
+	}
+	if err := bb.Pin("golang", testHash(12), false); err != nil {
+		t.Errorf("unable to unpin the submission: %s", err)
+	}
+	if b, _ := bb.Get("golang"); len(b.Pinned) != 2 || b.Pinned[0] != testHash(11) || b.IsPinned(testHash(12)) {
+		t.Errorf("unexpected pinned submissions %v", b.Pinned)
+	}
+	if err := bb.Pin("missing", testHash(11), true); err == nil {
+		t.Errorf("pinned in a board which doesn't exist")
+	}
+
+	if err := bb.Ban("golang", "~jane", true); err == nil {
+		t.Errorf("the moderator of the board was banned from it")
+	}
+	if err := bb.Ban("golang", " ", true); err == nil {
+		t.Errorf("banned an empty handle")
+	}
+	if err := bb.Ban("golang", "@spammer", true); err != nil {
+		t.Fatalf("unable to ban the account: %s", err)
+	}
+	thread := HashFromString("3b8e1f6a-2c4d-4e5f-8a9b-0c1d2e3f4a5b")
+	if err := bb.Lock("golang", thread, true); err != nil {
+		t.Fatalf("unable to lock the thread: %s", err)
+	}
+
+	op := &Item{Hash: thread, Metadata: &ItemMetadata{Board: "golang"}}
+	reply := &Item{Parent: op, OP: op}
+	other := &Item{Parent: &Item{Hash: HashFromString("c4d5e6f7-8a9b-4c0d-9e1f-2a3b4c5d6e7f"), Metadata: &ItemMetadata{Board: "golang"}}}
+	if err := checkBoardRestrictions(c, bb, spam, &Item{Metadata: &ItemMetadata{Board: "golang"}}); !errors.IsForbidden(err) {
+		t.Errorf("the banned account can submit in the board, got %v", err)
+	}
+	if err := checkBoardRestrictions(c, bb, spam, &Item{Metadata: &ItemMetadata{Board: "rust"}}); err != nil {
+		t.Errorf("the banned account can't submit outside the board: %s", err)
+	}
+	member := &Account{Hash: testHash(4), Handle: "other"}
+	if err := checkBoardRestrictions(c, bb, member, reply); !errors.IsForbidden(err) {
+		t.Errorf("the locked thread can be commented on, got %v", err)
+	}
+	if err := checkBoardRestrictions(c, bb, member, other); err != nil {
+		t.Errorf("the thread which isn't locked can't be commented on: %s", err)
+	}
+	if err := checkBoardRestrictions(c, bb, jane, reply); err != nil {
+		t.Errorf("the board moderator can't comment in the locked thread: %s", err)
+	}
+	if err := checkBoardRestrictions(c, bb, admin, reply); err != nil {
+		t.Errorf("the moderator can't comment in the locked thread: %s", err)
+	}
+
+	if err := bb.Ban("golang", "SPAMMER", false); err != nil {
+		t.Fatalf("unable to unban the account: %s", err)
+	}
+	if err := bb.Lock("golang", thread, false); err != nil {
+		t.Fatalf("unable to unlock the thread: %s", err)
+	}
+	if err := checkBoardRestrictions(c, bb, spam, reply); err != nil {
+		t.Errorf("the account can't comment after being unbanned: %s", err)
+	}
+}
+
+func TestPinnedFirst(t *testing.T) {
+	list := RenderableList{}
+	for i := 1; i <= 4; i++ {
+		list.Append(&Item{Hash: testHash(i), SubmittedAt: time.Now().Add(-time.Duration(i) * time.Hour)})
+	}
+	sorted := pinnedFirst([]Hash{testHash(4), testHash(3)}, ByDate)(list)
+	expected := []Hash{testHash(4), testHash(3), testHash(1), testHash(2)}
+	if len(sorted) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(sorted))
+	}
+	for i, h := range expected {
+		if sorted[i].ID() != h {
+			t.Errorf("item %d is %s, expected %s", i, sorted[i].ID(), h)
+		}
+	}
+}
+
+func TestBoardFromAudience(t *testing.T) {
+	Instance.BaseURL = "https://littr.example"
+	tests := map[string]struct {
+		aud  pub.ItemCollection
+		want string
+	}{
+		"empty":   {aud: nil, want: ""},
+		"public":  {aud: pub.ItemCollection{pub.PublicNS}, want: ""},
+		"board":   {aud: pub.ItemCollection{pub.PublicNS, pub.IRI("https://littr.example/b/golang")}, want: "golang"},
+		"remote":  {aud: pub.ItemCollection{pub.IRI("https://other.example/b/golang")}, want: ""},
+		"invalid": {aud: pub.ItemCollection{pub.IRI("https://littr.example/b/Go%20lang")}, want: ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := boardFromAudience(tt.aud); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeBoardCSS(t *testing.T) {
+	tests := map[string]struct {
+		css     string
+		want    string
+		wantErr bool
+	}{
+		"empty":     {css: "", want: ""},
+		"scoped":    {css: "h2, article a:hover { color: #c00; font-weight: bold }", want: "main h2, main article a:hover {\n\tcolor: #c00;\n\tfont-weight: bold;\n}\n"},
+		"comments":  {css: "/* title */ h2 { color: red; }", want: "main h2 {\n\tcolor: red;\n}\n"},
+		"import":    {css: "@import 'https://example.com/x.css';", wantErr: true},
+		"url":       {css: "h2 { background-color: url(https://example.com/t.png) }", wantErr: true},
+		"escapes":   {css: "h2 { color: \\75 rl(x) }", wantErr: true},
+		"position":  {css: "form { position: fixed }", wantErr: true},
+		"display":   {css: "header { display: none }", wantErr: true},
+		"important": {css: "h2 { color: red !important }", wantErr: true},
+		"nested":    {css: "h2 { a { color: red } }", wantErr: true},
+		"unclosed":  {css: "h2 { color: red", wantErr: true},
+		"style tag": {css: "</style><script>", wantErr: true},
+		"selector":  {css: "a[href^='http'] { color: red }", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := sanitizeBoardCSS(tt.css)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
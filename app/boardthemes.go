@@ -0,0 +1,176 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+// boardCSSMaxLength is the maximum size of the custom CSS of a board
+const boardCSSMaxLength = 4096
+
+// boardThemes are the colour variants the boards can use, they change only the links and the accent colour,
+// so they work for the light and the dark themes alike
+var boardThemes = map[string]struct {
+	Link, Visited, Accent string
+}{
+	"ocean":  {Link: "#1e88e5", Visited: "#5e35b1", Accent: "#1e88e5"},
+	"forest": {Link: "#2e7d32", Visited: "#558b2f", Accent: "#43a047"},
+	"sunset": {Link: "#e65100", Visited: "#ad1457", Accent: "#f4511e"},
+	"slate":  {Link: "#546e7a", Visited: "#6d4c41", Accent: "#78909c"},
+}
+
+// boardThemeNames returns the names of the themes, sorted
+func boardThemeNames() []string {
+	names := make([]string, 0, len(boardThemes))
+	for n := range boardThemes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	cssCommentRe  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssSelectorRe = regexp.MustCompile(`^[a-zA-Z0-9 .#_:>+~*()-]+$`)
+	cssPropertyRe = regexp.MustCompile(`^[a-z-]+$`)
+	cssValueRe    = regexp.MustCompile(`^[a-zA-Z0-9 #%.,()+/_'"-]+$`)
+)
+
+// cssForbidden are the constructs which can load external resources, run code, or escape the checks below
+var cssForbidden = []string{`\`, "<", "@", "url(", "expression(", "javascript:", "image-set(", "!"}
+
+// cssProperties are the properties the custom CSS can use, the ones ending in a dash allow all their variants
+var cssProperties = []string{
+	"color", "background-color", "opacity", "box-shadow", "max-width",
+	"border", "border-", "margin", "margin-", "padding", "padding-",
+	"font", "font-", "text-", "letter-spacing", "word-spacing", "line-height", "list-style", "list-style-",
+}
+
+func allowedCSSProperty(p string) bool {
+	for _, a := range cssProperties {
+		if p == a || (strings.HasSuffix(a, "-") && strings.HasPrefix(p, a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeBoardCSS checks the custom CSS of a board and returns it rewritten from the rules it understood.
+// Only plain rules with properties from the allow list are accepted, and their selectors are scoped to the
+// main element of the page, so a board can't restyle, or hide, the navigation and the forms around it.
+func sanitizeBoardCSS(css string) (string, error) {
+	if len(css) > boardCSSMaxLength {
+		return "", errors.BadRequestf("the CSS can have at most %d characters", boardCSSMaxLength)
+	}
+	css = cssCommentRe.ReplaceAllString(css, "")
+	lower := strings.ToLower(css)
+	for _, f := range cssForbidden {
+		if strings.Contains(lower, f) {
+			return "", errors.BadRequestf("the CSS can't contain %q", f)
+		}
+	}
+	out := strings.Builder{}
+	for rest := strings.TrimSpace(css); len(rest) > 0; rest = strings.TrimSpace(rest) {
+		open := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+		if open < 0 || end < open {
+			return "", errors.BadRequestf("invalid CSS rule %q", rest)
+		}
+		body := rest[open+1 : end]
+		if strings.Contains(body, "{") {
+			return "", errors.BadRequestf("nested CSS rules are not allowed")
+		}
+		selectors := make([]string, 0)
+		for _, s := range strings.Split(rest[:open], ",") {
+			s = strings.Join(strings.Fields(s), " ")
+			if !cssSelectorRe.MatchString(s) {
+				return "", errors.BadRequestf("invalid CSS selector %q", s)
+			}
+			selectors = append(selectors, "main "+s)
+		}
+		decls := make([]string, 0)
+		for _, d := range strings.Split(body, ";") {
+			if len(strings.TrimSpace(d)) == 0 {
+				continue
+			}
+			kv := strings.SplitN(d, ":", 2)
+			if len(kv) != 2 {
+				return "", errors.BadRequestf("invalid CSS declaration %q", strings.TrimSpace(d))
+			}
+			prop, val := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+			if !cssPropertyRe.MatchString(prop) || !allowedCSSProperty(prop) {
+				return "", errors.BadRequestf("the CSS property %q is not allowed", prop)
+			}
+			if !cssValueRe.MatchString(val) {
+				return "", errors.BadRequestf("invalid value for the CSS property %q", prop)
+			}
+			decls = append(decls, fmt.Sprintf("\t%s: %s;\n", prop, val))
+		}
+		if len(decls) > 0 {
+			fmt.Fprintf(&out, "%s {\n%s}\n", strings.Join(selectors, ", "), strings.Join(decls, ""))
+		}
+		rest = rest[end+1:]
+	}
+	return out.String(), nil
+}
+
+// boardStyle returns the stylesheet of the board, with its theme and its custom CSS
+func boardStyle(b board) string {
+	out := strings.Builder{}
+	if t, ok := boardThemes[b.Theme]; ok {
+		fmt.Fprintf(&out, ":root:not(.theme-high-contrast) {\n\t--main-link-color: %s;\n\t--main-linkvisited-color: %s;\n}\n", t.Link, t.Visited)
+		fmt.Fprintf(&out, ":root:not(.theme-high-contrast) main {\n\tborder-top: .2em solid %s;\n}\n", t.Accent)
+	}
+	// NOTE(marius): the CSS was sanitized when saved, we check it again in case the rules changed since
+	if css, err := sanitizeBoardCSS(b.CSS); err == nil {
+		out.WriteString(css)
+	}
+	return out.String()
+}
+
+// modelBoard returns the function which finds the board of the page, for loading its stylesheet
+func modelBoard(bb *boards) func(m interface{}) *board {
+	return func(m interface{}) *board {
+		var name string
+		switch mm := m.(type) {
+		case *listingModel:
+			if mm.Board != nil {
+				name = mm.Board.Name
+			}
+		case *contentModel:
+			if mm.Board != nil {
+				name = mm.Board.Name
+			} else if it, ok := mm.Content.(*Item); ok {
+				name = itemBoard(it)
+			}
+		case *boardEditModel:
+			name = mm.Board.Name
+		case *wikiModel:
+			name = mm.Board.Name
+		}
+		if b, ok := bb.Get(name); ok && (len(b.Theme) > 0 || len(b.CSS) > 0) {
+			return &b
+		}
+		return nil
+	}
+}
+
+// HandleBoardTheme serves the /theme/b/{name} requests, with the stylesheet of the board
+func (h *handler) HandleBoardTheme(w http.ResponseWriter, r *http.Request) {
+	b, ok := h.storage.boards.Get(chi.URLParam(r, "name"))
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("board"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(boardStyle(b)))
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// postingWindow is the interval for which we keep the times of the submissions of every account
+	postingWindow = time.Hour
+	// postingRegularMin is the number of submissions at regular intervals after which the account looks scheduled
+	postingRegularMin    = 8
+	postingRegularJitter = 5 * time.Second
+)
+
+// IsBot returns if the account is an automated one, ActivityPub marks them with the Service or Application actor types
+func (a *Account) IsBot() bool {
+	if a == nil || a.pub == nil {
+		return false
+	}
+	typ := a.pub.GetType()
+	return typ == pub.ServiceType || typ == pub.ApplicationType
+}
+
+// postingPatterns keeps the recent submission times of the local accounts, so we can tell when an account
+// which isn't marked as a bot posts like one
+type postingPatterns struct {
+	m       sync.Mutex
+	perHour int
+	posts   map[Hash][]time.Time
+}
+
+func newPostingPatterns(perHour int) *postingPatterns {
+	return &postingPatterns{perHour: perHour, posts: make(map[Hash][]time.Time)}
+}
+
+// recent returns the submission times of the account from the last postingWindow, it needs to be called with the lock held
+func (p *postingPatterns) recent(h Hash) []time.Time {
+	times := p.posts[h]
+	i := 0
+	for i < len(times) && time.Since(times[i]) > postingWindow {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(p.posts, h)
+	} else {
+		p.posts[h] = times
+	}
+	return times
+}
+
+// Record saves the time of a new submission of the account
+func (p *postingPatterns) Record(h Hash) {
+	if p == nil || p.perHour <= 0 || !h.IsValid() {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.posts[h] = append(p.recent(h), time.Now())
+}
+
+// Automated returns if the account posted more than we allow for people in the last hour, or at intervals
+// regular enough that they look scheduled
+func (p *postingPatterns) Automated(h Hash) bool {
+	if p == nil || p.perHour <= 0 || !h.IsValid() {
+		return false
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	times := p.recent(h)
+	if len(times) >= p.perHour {
+		return true
+	}
+	if len(times) < postingRegularMin {
+		return false
+	}
+	last := times[len(times)-postingRegularMin:]
+	interval := last[1].Sub(last[0])
+	for i := 2; i < len(last); i++ {
+		diff := last[i].Sub(last[i-1]) - interval
+		if diff < -postingRegularJitter || diff > postingRegularJitter {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutBots returns the list without the items submitted by automated accounts
+func withoutBots(list []Renderable) []Renderable {
+	result := make([]Renderable, 0, len(list))
+	for _, it := range list {
+		if i, ok := it.(*Item); ok && i.SubmittedBy.IsBot() {
+			continue
+		}
+		result = append(result, it)
+	}
+	return result
+}
+
+// SetAccountBot marks the local account as automated, or as a person
+func (r *repository) SetAccountBot(ctx context.Context, a Account, bot bool) (Account, error) {
+	return r.updateAccount(ctx, a, func(p *pub.Actor) {
+		if bot {
+			p.Type = pub.ServiceType
+		} else {
+			p.Type = pub.PersonType
+		}
+	})
+}
+
+// HandleBotSettings handles POST /~handle/settings/bots requests
+func (h *handler) HandleBotSettings(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	s := acc.Settings()
+	s.HideBots = r.PostFormValue("hide-bots") == "y"
+	if err := h.saveSettings(r, acc, s); err != nil {
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save bot settings"))
+		return
+	}
+	if bot := r.PostFormValue("bot") == "y"; bot != acc.IsBot() {
+		if !bot && h.storage.posting.Automated(acc.Hash) {
+			h.v.HandleErrors(w, r, errors.BadRequestf("Your account is posting like an automated one, it needs to stay marked as a bot"))
+			return
+		}
+		updated, err := h.storage.SetAccountBot(r.Context(), *acc, bot)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to change the account type")
+			h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save bot settings"))
+			return
+		}
+		acc.pub = updated.pub
+	}
+	h.v.addFlashMessage(Success, w, r, "Your bot settings were saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
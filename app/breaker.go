@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// breakerTrips counts how many times the circuit breaker around fedbox opened, it's exposed under /debug/vars
+var breakerTrips = expvar.NewInt("fedbox_breaker_trips")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// errFedboxUnavailable is returned without calling fedbox while the circuit breaker is open
+var errFedboxUnavailable = errors.WrapWithStatus(http.StatusServiceUnavailable, nil, "the ActivityPub service is unavailable, retry later")
+
+// breaker stops the requests to fedbox after a number of consecutive failures, so when it's down
+// we fail fast instead of waiting for every request to time out.
+// After the cool down interval it lets one request through, and if that succeeds it closes again.
+type breaker struct {
+	m         sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	coolDown  time.Duration
+	openedAt  time.Time
+	logFn     CtxLogFn
+}
+
+func newBreaker(threshold int, coolDown time.Duration, logFn CtxLogFn) *breaker {
+	if logFn == nil {
+		logFn = defaultCtxLogFn
+	}
+	return &breaker{threshold: threshold, coolDown: coolDown, logFn: logFn}
+}
+
+// Allow returns an error if the request shouldn't be made
+func (b *breaker) Allow() error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.coolDown {
+			return errFedboxUnavailable
+		}
+		// NOTE(marius): the current request is the probe, the others keep failing until it's done
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		return errFedboxUnavailable
+	}
+	return nil
+}
+
+// isBackendFailure returns if the error means fedbox is in trouble, as opposed to the request being invalid
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return isTimeout(err) || errors.HttpStatus(err) >= http.StatusInternalServerError
+}
+
+// Done records the result of the request made with ctx. When the context of the request ran out of time, or was
+// canceled, the error says more about the request than about fedbox, so it's not counted as a failure.
+func (b *breaker) Done(ctx context.Context, err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	if err != nil && ctx.Err() != nil {
+		if b.state == breakerHalfOpen {
+			// NOTE(marius): the probe didn't tell us anything, the next request can try again
+			b.state = breakerOpen
+		}
+		return
+	}
+	if !isBackendFailure(err) {
+		if b.state != breakerClosed {
+			b.logFn()("the ActivityPub service recovered, closing the circuit breaker")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state == breakerClosed {
+			breakerTrips.Add(1)
+			b.logFn()("the ActivityPub service failed %d times, opening the circuit breaker", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// IsOpen returns if the requests to fedbox are currently stopped
+func (b *breaker) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.state != breakerClosed
+}
+
+const staleCursorsSize = 200
+
+// staleCursors keeps the last successfully loaded version of the public listings,
+// so we have something to show while fedbox is unavailable
+type staleCursors struct {
+	m       sync.RWMutex
+	cursors map[string]Cursor
+}
+
+func newStaleCursors() *staleCursors {
+	return &staleCursors{cursors: make(map[string]Cursor)}
+}
+
+func (s *staleCursors) set(key string, c Cursor) {
+	if s == nil || len(c.items) == 0 {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.cursors[key]; !ok && len(s.cursors) >= staleCursorsSize {
+		s.cursors = make(map[string]Cursor)
+	}
+	s.cursors[key] = c
+}
+
+func (s *staleCursors) get(key string) (*Cursor, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	c, ok := s.cursors[key]
+	if !ok {
+		return nil, false
+	}
+	c.stale = true
+	return &c, true
+}
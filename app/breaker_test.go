@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+func TestBreakerDone(t *testing.T) {
+	b := newBreaker(2, time.Minute, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		b.Done(ctx, ctx.Err())
+	}
+	if b.IsOpen() {
+		t.Errorf("expected the errors of the expired request contexts to not open the breaker")
+	}
+
+	failure := errors.WrapWithStatus(http.StatusInternalServerError, nil, "fedbox is down")
+	b.Done(context.Background(), failure)
+	b.Done(context.Background(), failure)
+	if !b.IsOpen() {
+		t.Errorf("expected the breaker to open after %d failures", b.threshold)
+	}
+}
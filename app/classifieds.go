@@ -0,0 +1,127 @@
+package app
+
+import (
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const (
+	// classifiedTag is the hashtag of the classifieds, it's what other software shows for them
+	classifiedTag = "forsale"
+
+	maxClassifiedPriceLength = 64
+
+	classifiedPriceField     = "Price"
+	classifiedConditionField = "Condition"
+	classifiedExpiresField   = "Expires"
+)
+
+// classifiedConditions are the conditions the sellers can choose from
+var classifiedConditions = []string{"new", "like new", "good", "fair", "for parts"}
+
+// ClassifiedMetadata are the details of the submissions which sell something. The items stay Notes and
+// Articles for the other servers, the details are sent as PropertyValue attachments and in the content.
+type ClassifiedMetadata struct {
+	Price     string    `json:"price"`
+	Condition string    `json:"condition,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired returns if the classified is archived: it can't be commented on, and it isn't listed anymore
+func (c ClassifiedMetadata) Expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+// IsClassified returns if the item is a classified
+func (i *Item) IsClassified() bool {
+	return i.HasMetadata() && i.Metadata.Classified != nil
+}
+
+func validClassifiedCondition(s string) bool {
+	for _, c := range classifiedConditions {
+		if c == s {
+			return true
+		}
+	}
+	return false
+}
+
+// classifiedFromRequest loads the classified details from the submission form, it returns nil if they're missing
+func classifiedFromRequest(r *http.Request, days int) (*ClassifiedMetadata, error) {
+	price := strings.TrimSpace(r.PostFormValue("classified-price"))
+	if len(price) == 0 {
+		return nil, nil
+	}
+	if len(price) > maxClassifiedPriceLength {
+		return nil, errors.BadRequestf("the price can have at most %d characters", maxClassifiedPriceLength)
+	}
+	c := ClassifiedMetadata{
+		Price:     price,
+		Condition: strings.TrimSpace(r.PostFormValue("classified-condition")),
+		ExpiresAt: time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour).Truncate(time.Second),
+	}
+	if len(c.Condition) > 0 && !validClassifiedCondition(c.Condition) {
+		return nil, errors.BadRequestf("invalid condition %q", c.Condition)
+	}
+	return &c, nil
+}
+
+// classifiedFromAttachment loads the classified details from the PropertyValue attachments of an object
+func classifiedFromAttachment(att pub.Item) *ClassifiedMetadata {
+	var c ClassifiedMetadata
+	for _, f := range propertyValuesFromAttachment(att, maxItemPropertyValues) {
+		switch f.Name {
+		case classifiedPriceField:
+			c.Price = f.Value
+		case classifiedConditionField:
+			if validClassifiedCondition(f.Value) {
+				c.Condition = f.Value
+			}
+		case classifiedExpiresField:
+			c.ExpiresAt, _ = time.Parse(time.RFC3339, f.Value)
+		}
+	}
+	if len(c.Price) == 0 {
+		return nil
+	}
+	return &c
+}
+
+// loadAPClassified sets the classified details on the ActivityPub object: the attachments for the
+// servers which look for them, and a line before the content and the hashtag for the ones which don't
+func loadAPClassified(o *pub.Object, c *ClassifiedMetadata) {
+	fields := []ProfileField{{Name: classifiedPriceField, Value: c.Price}}
+	if len(c.Condition) > 0 {
+		fields = append(fields, ProfileField{Name: classifiedConditionField, Value: c.Condition})
+	}
+	if !c.ExpiresAt.IsZero() {
+		fields = append(fields, ProfileField{Name: classifiedExpiresField, Value: c.ExpiresAt.Format(time.RFC3339)})
+	}
+	o.Attachment = profileFieldsAttachment(fields)
+
+	// NOTE(marius): we only change the rendered content, the Markdown source is what we load back
+	if content := o.Content.First().Value; len(content) > 0 && len(o.Source.Content) > 0 {
+		o.Content.Set(o.Content.First().Ref, pub.Content(classifiedHTML(c)+content.String()))
+	}
+	appendAPHashtag(o, classifiedTag)
+}
+
+// classifiedHTML is the line with the classified details which the other servers show before the content
+func classifiedHTML(c *ClassifiedMetadata) string {
+	s := fmt.Sprintf("<p><strong>%s:</strong> %s", classifiedPriceField, stdhtml.EscapeString(c.Price))
+	if len(c.Condition) > 0 {
+		s += fmt.Sprintf(", <strong>%s:</strong> %s", classifiedConditionField, stdhtml.EscapeString(c.Condition))
+	}
+	return s + "</p>"
+}
+
+// activeClassified returns if the item is a classified which didn't expire, for the /classifieds listing
+func activeClassified(i *Item) bool {
+	return i.IsClassified() && !i.Metadata.Classified.Expired()
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestClassifiedFromRequest(t *testing.T) {
+	tests := map[string]struct {
+		form    url.Values
+		want    *ClassifiedMetadata
+		wantErr bool
+	}{
+		"not for sale": {form: url.Values{"data": {"text"}}},
+		"price only":   {form: url.Values{"classified-price": {" 50 EUR "}}, want: &ClassifiedMetadata{Price: "50 EUR"}},
+		"with condition": {
+			form: url.Values{"classified-price": {"20$"}, "classified-condition": {"like new"}},
+			want: &ClassifiedMetadata{Price: "20$", Condition: "like new"},
+		},
+		"invalid condition": {form: url.Values{"classified-price": {"20$"}, "classified-condition": {"broken"}}, wantErr: true},
+		"long price":        {form: url.Values{"classified-price": {strings.Repeat("9", 65)}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got, err := classifiedFromRequest(r, 30)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if got == nil {
+				return
+			}
+			if got.Price != tt.want.Price || got.Condition != tt.want.Condition {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if exp := time.Until(got.ExpiresAt); exp < 29*24*time.Hour || exp > 30*24*time.Hour {
+				t.Errorf("expected the classified to expire in 30 days, got %s", got.ExpiresAt)
+			}
+			if got.Expired() {
+				t.Errorf("the new classified shouldn't be expired")
+			}
+		})
+	}
+}
+
+func TestLoadAPClassified(t *testing.T) {
+	c := &ClassifiedMetadata{Price: "50 EUR", Condition: "good", ExpiresAt: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)}
+	o := pub.ObjectNew(pub.NoteType)
+	o.Source.Content.Set("en", "A bike")
+	o.Content.Set("en", "<p>A bike</p>")
+	loadAPClassified(o, c)
+
+	if o.Type != pub.NoteType {
+		t.Errorf("expected the classified to stay a Note, got %s", o.Type)
+	}
+	if content := o.Content.First().Value.String(); !strings.HasPrefix(content, "<p><strong>Price:</strong> 50 EUR") {
+		t.Errorf("expected the price before the content, got %q", content)
+	}
+	if len(o.Tag) != 1 || tagName(o.Tag[0]) != "#"+classifiedTag {
+		t.Errorf("expected the #%s tag, got %v", classifiedTag, o.Tag)
+	}
+	got := classifiedFromAttachment(o.Attachment)
+	if got == nil || *got != *c {
+		t.Errorf("expected %v, got %v", c, got)
+	}
+	if !got.Expired() {
+		t.Errorf("expected the classified to be expired")
+	}
+}
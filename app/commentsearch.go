@@ -0,0 +1,106 @@
+package app
+
+import (
+	stdhtml "html"
+	"net/http"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	commentSearchParam = "q"
+	commentAuthorParam = "author"
+	// commentSearchMinCount is the number of comments after which we show the search box on a discussion
+	commentSearchMinCount = 20
+	commentSearchMaxLen   = 100
+)
+
+// commentSearch filters the comments of a discussion by keywords and by the handle of their author
+type commentSearch struct {
+	Query  string
+	Author string
+	// Matches is the number of comments which matched
+	Matches int
+	words   []string
+}
+
+func commentSearchFromRequest(r *http.Request) commentSearch {
+	q := r.URL.Query()
+	s := commentSearch{
+		Query:  strings.TrimSpace(q.Get(commentSearchParam)),
+		Author: strings.TrimLeft(strings.TrimSpace(q.Get(commentAuthorParam)), "~@"),
+	}
+	if runes := []rune(s.Query); len(runes) > commentSearchMaxLen {
+		s.Query = string(runes[:commentSearchMaxLen])
+	}
+	s.words = strings.Fields(strings.ToLower(s.Query))
+	return s
+}
+
+// Active returns if the comments are being filtered
+func (s commentSearch) Active() bool {
+	return len(s.words) > 0 || len(s.Author) > 0
+}
+
+// Match returns if the comment contains all the keywords and was submitted by the author we're looking for
+func (s commentSearch) Match(i *Item) bool {
+	if !s.Active() || i == nil || i.Deleted() {
+		return false
+	}
+	if len(s.Author) > 0 && (i.SubmittedBy == nil || !strings.EqualFold(i.SubmittedBy.Handle, s.Author)) {
+		return false
+	}
+	if len(s.words) == 0 {
+		return true
+	}
+	text := strings.ToLower(i.Title + " " + stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(i.Data)))
+	for _, w := range s.words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns copies of the comments which match, together with the ones they reply to, so the matches
+// are shown in the context of their discussion. The comments are copied because the trees are cached.
+func (s *commentSearch) filter(comments ItemPtrCollection) ItemPtrCollection {
+	result := make(ItemPtrCollection, 0)
+	for _, it := range comments {
+		if it == nil {
+			continue
+		}
+		children := s.filter(it.Children())
+		matches := s.Match(it)
+		if !matches && len(children) == 0 {
+			continue
+		}
+		if matches {
+			s.Matches++
+		}
+		c := *it
+		c.children = children
+		result = append(result, &c)
+	}
+	return result
+}
+
+// countComments returns the number of comments in the tree
+func countComments(comments ItemPtrCollection) int {
+	count := 0
+	for _, it := range comments {
+		count += 1 + countComments(it.Children())
+	}
+	return count
+}
+
+// CommentSearchMw loads the comment search parameters into the content model
+func CommentSearchMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := ContextContentModel(r.Context()); m != nil {
+			m.Search = commentSearchFromRequest(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
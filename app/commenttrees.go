@@ -0,0 +1,163 @@
+package app
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+const (
+	commentTreesSize = 500
+	commentTreeTTL   = 2 * time.Minute
+)
+
+// commentTree is the threaded cursor of an item page, for one sort order and type of viewer
+type commentTree struct {
+	cursor Cursor
+	at     time.Time
+}
+
+type commentTreesEntry struct {
+	iri   pub.IRI
+	trees map[string]commentTree
+}
+
+// commentTrees keeps the comment trees of the most recently shown items, so the popular discussions
+// don't have to be loaded from fedbox and threaded again for every request.
+// The trees of an item are dropped when we see new replies to it.
+type commentTrees struct {
+	m       sync.Mutex
+	lru     *list.List
+	entries map[pub.IRI]*list.Element
+}
+
+func newCommentTrees() *commentTrees {
+	return &commentTrees{lru: list.New(), entries: make(map[pub.IRI]*list.Element)}
+}
+
+// commentTreeVariant returns the key for the trees of an item that can differ between requests
+func commentTreeVariant(r *http.Request) string {
+	role := "anonymous"
+	if ContextAccount(r.Context()).IsLogged() {
+		role = "logged"
+	}
+	return role + ":" + r.URL.Query().Get("sort")
+}
+
+// itemIRI returns the ActivityPub ID of the item, if we have it
+func itemIRI(it *Item) pub.IRI {
+	if it == nil {
+		return ""
+	}
+	if it.pub != nil {
+		return it.pub.GetLink()
+	}
+	if id, ok := BuildIDFromItem(*it); ok {
+		return id
+	}
+	return ""
+}
+
+func (t *commentTrees) get(iri pub.IRI, variant string) (*Cursor, bool) {
+	if t == nil || len(iri) == 0 {
+		return nil, false
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	el, ok := t.entries[iri]
+	if !ok {
+		return nil, false
+	}
+	tree, ok := el.Value.(*commentTreesEntry).trees[variant]
+	if !ok || time.Since(tree.at) > commentTreeTTL {
+		return nil, false
+	}
+	t.lru.MoveToFront(el)
+	c := tree.cursor
+	return &c, true
+}
+
+func (t *commentTrees) set(iri pub.IRI, variant string, c Cursor) {
+	if t == nil || len(iri) == 0 {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	el, ok := t.entries[iri]
+	if !ok {
+		el = t.lru.PushFront(&commentTreesEntry{iri: iri, trees: make(map[string]commentTree)})
+		t.entries[iri] = el
+		for t.lru.Len() > commentTreesSize {
+			last := t.lru.Back()
+			t.lru.Remove(last)
+			delete(t.entries, last.Value.(*commentTreesEntry).iri)
+		}
+	} else {
+		t.lru.MoveToFront(el)
+	}
+	el.Value.(*commentTreesEntry).trees[variant] = commentTree{cursor: c, at: time.Now()}
+}
+
+// Invalidate drops the trees of the items' parents and OPs, when the items are newer than them
+func (t *commentTrees) Invalidate(items ...Item) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, it := range items {
+		changed := it.SubmittedAt
+		if it.UpdatedAt.After(changed) {
+			changed = it.UpdatedAt
+		}
+		for _, iri := range []pub.IRI{itemIRI(it.Parent), itemIRI(it.OP)} {
+			el, ok := t.entries[iri]
+			if !ok {
+				continue
+			}
+			for _, tree := range el.Value.(*commentTreesEntry).trees {
+				if changed.After(tree.at) {
+					t.remove(el)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Remove drops the trees of the items, and the ones of their parents and OPs
+func (t *commentTrees) Remove(items ...Item) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for k := range items {
+		it := &items[k]
+		for _, iri := range []pub.IRI{itemIRI(it), itemIRI(it.Parent), itemIRI(it.OP)} {
+			if el, ok := t.entries[iri]; ok {
+				t.remove(el)
+			}
+		}
+	}
+}
+
+// RemoveIRI drops the trees of the item with iri
+func (t *commentTrees) RemoveIRI(iri pub.IRI) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	if el, ok := t.entries[iri]; ok {
+		t.remove(el)
+	}
+}
+
+func (t *commentTrees) remove(el *list.Element) {
+	t.lru.Remove(el)
+	delete(t.entries, el.Value.(*commentTreesEntry).iri)
+}
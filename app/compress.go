@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/assets"
+)
+
+// compressWriter compresses the response body if its content type benefits from it
+// and it wasn't already encoded by the handler, like in the case of the static assets
+type compressWriter struct {
+	http.ResponseWriter
+	enc     string
+	w       io.WriteCloser
+	decided bool
+}
+
+func (c *compressWriter) decide(status int) {
+	c.decided = true
+	h := c.Header()
+	if len(h.Get("Content-Encoding")) > 0 || !assets.IsCompressible(h.Get("Content-Type")) {
+		return
+	}
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	h.Set("Content-Encoding", c.enc)
+	h.Del("Content-Length")
+	c.w = assets.NewEncoder(c.ResponseWriter, c.enc)
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		// NOTE(marius): the informational responses, like 103 Early Hints, don't have a body
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !c.decided {
+		c.decide(status)
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		if len(c.Header().Get("Content-Type")) == 0 {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.w != nil {
+		return c.w.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *compressWriter) Close() error {
+	if c.w == nil {
+		return nil
+	}
+	return c.w.Close()
+}
+
+func (c *compressWriter) Flush() {
+	if f, ok := c.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.NotImplementedf("the response writer doesn't support hijacking")
+}
+
+// Compress negotiates the content encoding of the responses with the client, using brotli or gzip
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := assets.AcceptedEncoding(r)
+		if len(enc) == 0 || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, enc: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// maxConversationDepth is how many inReplyTo links we follow looking for the top of a conversation
+	maxConversationDepth   = 10
+	conversationsCacheSize = 5000
+)
+
+// conversations caches the top level item of the conversations we resolved
+type conversations struct {
+	m     sync.RWMutex
+	roots map[pub.IRI]pub.IRI
+}
+
+func newConversations() *conversations {
+	return &conversations{roots: make(map[pub.IRI]pub.IRI)}
+}
+
+func (c *conversations) get(iri pub.IRI) (pub.IRI, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	root, ok := c.roots[iri]
+	return root, ok
+}
+
+func (c *conversations) set(iri, root pub.IRI) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.roots == nil || len(c.roots) >= conversationsCacheSize {
+		c.roots = make(map[pub.IRI]pub.IRI)
+	}
+	c.roots[iri] = root
+}
+
+// loadObject loads local objects from fedbox and remote ones from their origin server
+func (r *repository) loadObject(ctx context.Context, iri pub.IRI) (pub.Item, error) {
+	if HostIsLocal(iri.String()) {
+		return r.fedbox.Object(ctx, iri)
+	}
+	return r.fedbox.client.CtxLoadIRI(ctx, iri)
+}
+
+// needsConversation returns true for replies which don't specify their conversation,
+// in which case we use the item they reply to as their OP
+func needsConversation(it Item) bool {
+	if it.pub == nil || it.Parent == nil || it.Parent.pub == nil || it.OP == nil || it.OP.Hash != it.Parent.Hash {
+		return false
+	}
+	missing := false
+	pub.OnObject(it.pub, func(o *pub.Object) error {
+		missing = o.Context == nil && o.InReplyTo != nil
+		return nil
+	})
+	return missing
+}
+
+// resolveConversation follows the inReplyTo chain of the item up to the top level item
+func (r *repository) resolveConversation(ctx context.Context, it Item) (pub.IRI, error) {
+	root := it.Parent.pub.GetLink()
+	visited := make(pub.IRIs, 0)
+	for depth := 0; depth < maxConversationDepth && len(root) > 0; depth++ {
+		if cached, ok := r.convs.get(root); ok {
+			return cached, nil
+		}
+		if visited.Contains(root) {
+			break
+		}
+		visited = append(visited, root)
+
+		ob, err := r.loadObject(ctx, root)
+		if err != nil {
+			return root, err
+		}
+		var next pub.IRI
+		pub.OnObject(ob, func(o *pub.Object) error {
+			if o.Context != nil && !o.Context.GetLink().Equals(o.GetLink(), false) {
+				next = o.Context.GetLink()
+				return nil
+			}
+			if o.InReplyTo == nil {
+				return nil
+			}
+			if col, ok := o.InReplyTo.(pub.ItemCollection); ok {
+				if len(col) > 0 && col[0] != nil {
+					next = col[0].GetLink()
+				}
+				return nil
+			}
+			next = o.InReplyTo.GetLink()
+			return nil
+		})
+		if len(next) == 0 {
+			break
+		}
+		root = next
+	}
+	for _, v := range visited {
+		r.convs.set(v, root)
+	}
+	return root, nil
+}
+
+// resolveConversations sets the top level item of the conversation as OP for the replies that
+// don't specify it, so they're threaded correctly instead of being shown under their parent only
+func (r *repository) resolveConversations(ctx context.Context, items ItemCollection) ItemCollection {
+	for k, it := range items {
+		if !needsConversation(it) {
+			continue
+		}
+		root, err := r.resolveConversation(ctx, it)
+		if err != nil {
+			r.errFn(log.Ctx{"iri": it.pub.GetLink(), "err": err})("unable to resolve conversation")
+		}
+		if len(root) == 0 || root.Equals(it.Parent.pub.GetLink(), false) {
+			continue
+		}
+		op := Item{}
+		if err := op.FromActivityPub(root); err == nil && op.IsValid() {
+			items[k].OP = &op
+		}
+	}
+	return items
+}
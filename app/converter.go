@@ -86,8 +86,12 @@ func FromActor(a *Account, p *pub.Actor) error {
 		if p.URL != nil {
 			a.Metadata.URL = p.URL.GetLink().String()
 		}
-		if !HostIsLocal(a.Metadata.ID) {
-			a.Metadata.Name = name.String()
+		a.Metadata.Name = sanitizeDisplayName(name.String())
+		a.Metadata.Emoji = emojiFromTags(p.Tag)
+		a.Metadata.Fields = profileFieldsFromAttachment(p.Attachment)
+		a.Metadata.Blurb = nil
+		if bio := sanitizeBio(p.Summary.First().Value.String()); len(bio) > 0 {
+			a.Metadata.Blurb = []byte(bio)
 		}
 	}
 	if p.Icon != nil {
@@ -353,6 +357,19 @@ func FromArticle(i *Item, a *pub.Object) error {
 	if i.Metadata == nil {
 		i.Metadata = &ItemMetadata{}
 	}
+	if len(a.Content) > 0 {
+		i.Metadata.Lang = string(a.Content.First().Ref)
+	} else if len(a.Name) > 0 {
+		i.Metadata.Lang = string(a.Name.First().Ref)
+	}
+	i.Metadata.Board = boardFromAudience(a.Audience)
+	if a.Type == pub.EventType {
+		i.Metadata.Event = eventFromObject(a)
+	}
+	if a.InReplyTo == nil {
+		i.Metadata.Classified = classifiedFromAttachment(a.Attachment)
+		i.Metadata.Job = jobFromAttachment(a.Attachment)
+	}
 
 	if a.AttributedTo != nil {
 		auth := Account{Metadata: &AccountMetadata{}}
@@ -582,7 +599,7 @@ func (i *Item) FromActivityPub(it pub.Item) error {
 			i.Metadata.AuthorURI = act.Actor.GetLink().String()
 			return loadRecipients(i, act)
 		})
-	case pub.ArticleType, pub.NoteType, pub.DocumentType, pub.PageType:
+	case pub.ArticleType, pub.NoteType, pub.DocumentType, pub.PageType, pub.EventType:
 		return pub.OnObject(it, func(a *pub.Object) error {
 			return FromArticle(i, a)
 		})
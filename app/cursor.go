@@ -12,6 +12,12 @@ type Cursor struct {
 	before Hash
 	items  RenderableList
 	total  uint
+	// partial is set when we ran out of time before loading everything
+	partial bool
+	// stale is set when fedbox was unavailable and we're showing a previously loaded version
+	stale bool
+	// threaded is set when the comments were already arranged in their tree
+	threaded bool
 }
 
 var emptyCursor = Cursor{}
@@ -80,6 +86,17 @@ func ByDate (r RenderableList) []Renderable {
 	return rl
 }
 func ByScore (r RenderableList) []Renderable {
+	return ByScoreWithGravity(HNGravity)(r)
+}
+
+// ByScoreWithGravity sorts the items by their hot score, using gravity for how fast they drop with their age
+func ByScoreWithGravity(gravity float64) func(RenderableList) []Renderable {
+	return func(r RenderableList) []Renderable {
+		return byHotScore(r, gravity)
+	}
+}
+
+func byHotScore(r RenderableList, gravity float64) []Renderable {
 	rl := make([]Renderable, 0)
 	for _, rr := range r {
 		rl = append(rl, rr)
@@ -92,8 +109,8 @@ func ByScore (r RenderableList) []Renderable {
 			case CommentType:
 				ii, oki := ri.(*Item)
 				ij, okj := rj.(*Item)
-				hi := Hacker(int64(ii.Score), time.Now().Sub(ii.SubmittedAt))
-				hj := Hacker(int64(ij.Score), time.Now().Sub(ij.SubmittedAt))
+				hi := HackerWithGravity(int64(ii.Score), time.Now().Sub(ii.SubmittedAt), gravity)
+				hj := HackerWithGravity(int64(ij.Score), time.Now().Sub(ij.SubmittedAt), gravity)
 				return oki && okj && hi > hj
 			}
 		}
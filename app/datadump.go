@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	dumpItemsFile = "items.csv"
+	dumpVotesFile = "votes.csv"
+	// dumpPage is how many objects, or votes, the export command loads from fedbox at once
+	dumpPage = 100
+	// dumpTimeFmt truncates the dates to the hour, so they can't be matched with the access logs of other services
+	dumpTimeFmt = "2006-01-02T15:00Z"
+
+	dumpSubmission = "submission"
+	dumpComment    = "comment"
+)
+
+// DumpSchema documents the files written by the export command, it's shown in the help of the command
+const DumpSchema = `items.csv, the public submissions and comments, without their titles or contents:
+  id         the pseudonym of the item
+  op         the pseudonym of the submission of a comment, empty for the submissions
+  parent     the pseudonym of the item a comment replies to, empty for the submissions
+  author     the pseudonym of the account which submitted it
+  local      if the account is from this instance
+  kind       "submission" or "comment"
+  submitted  when it was submitted, truncated to the hour, in UTC
+  board      the board it was submitted to, if any
+  domain     the domain of the link of the submission, if it's a link
+  tags       the hashtags, separated by spaces
+  score      the sum of the votes
+  votes      the number of votes
+  comments   the number of comments of the submissions, or the number of replies of the comments
+votes.csv, the latest vote of the accounts on the items:
+  item       the pseudonym of the item
+  voter      the pseudonym of the account which voted
+  weight     1 for the upvotes, -1 for the downvotes
+  voted      when the vote was cast, truncated to the hour, in UTC`
+
+// dumpPseudonyms replaces the IDs of the items and accounts with the HMAC of the salt, so the rows of the export
+// can be joined together, but not with the data outside it
+type dumpPseudonyms struct {
+	salt []byte
+}
+
+func newDumpPseudonyms(salt string) (dumpPseudonyms, error) {
+	p := dumpPseudonyms{salt: []byte(salt)}
+	if len(p.salt) == 0 {
+		p.salt = make([]byte, 32)
+		if _, err := rand.Read(p.salt); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func (p dumpPseudonyms) of(h Hash) string {
+	if !h.IsValid() {
+		return ""
+	}
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(h.String()))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func dumpTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(dumpTimeFmt)
+}
+
+// dumpVote is the latest vote of an account on an item
+type dumpVote struct {
+	item, voter Hash
+	weight      int
+	votedAt     time.Time
+}
+
+// dumpItem is an item of the export, with the hash of its parent, which the index entries don't keep
+type dumpItem struct {
+	indexEntry
+	parent Hash
+	local  bool
+}
+
+// dumpVotes loads the votes from the inbox of the service, keeping the latest one of each account on an item
+func (r *repository) dumpVotes(ctx context.Context) ([]dumpVote, error) {
+	latest := make(map[[2]Hash]dumpVote)
+	voteActivities := pub.ActivityVocabularyTypes{pub.LikeType, pub.DislikeType}
+	f := &Filters{Type: ActivityTypesFilter(voteActivities...), MaxItems: dumpPage}
+	collFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Inbox(ctx, r.fedbox.Service(), Values(f))
+	}
+	err := LoadFromCollection(ctx, collFn, &colCursor{filters: f}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			if !voteActivities.Contains(it.GetType()) {
+				continue
+			}
+			v := Vote{}
+			if err := v.FromActivityPub(it); err != nil || v.Item == nil || v.SubmittedBy == nil {
+				continue
+			}
+			ev := dumpVote{item: v.Item.Hash, voter: v.SubmittedBy.Hash, weight: v.Weight, votedAt: v.SubmittedAt}
+			key := [2]Hash{ev.item, ev.voter}
+			if old, ok := latest[key]; !ok || ev.votedAt.After(old.votedAt) {
+				latest[key] = ev
+			}
+		}
+		return false, nil
+	})
+	votes := make([]dumpVote, 0, len(latest))
+	for _, v := range latest {
+		votes = append(votes, v)
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].votedAt.Before(votes[j].votedAt) })
+	return votes, err
+}
+
+// dumpItems loads the public items stored in fedbox, without their authors and votes
+func (r *repository) dumpItems(ctx context.Context) ([]dumpItem, error) {
+	items := make([]dumpItem, 0)
+	f := &Filters{Type: ActivityTypesFilter(ValidContentTypes...), MaxItems: dumpPage}
+	collFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Objects(ctx, Values(f))
+	}
+	err := LoadFromCollection(ctx, collFn, &colCursor{filters: f}, func(c pub.CollectionInterface) (bool, error) {
+		for _, ob := range c.Collection() {
+			it := Item{}
+			if err := it.FromActivityPub(ob); err != nil || !it.IsValid() || !it.Public() || it.Deleted() {
+				continue
+			}
+			e := dumpItem{indexEntry: indexEntryFromItem(it), local: HostIsLocal(ob.GetLink().String())}
+			if it.Parent != nil && it.Parent.Hash != it.Hash {
+				e.parent = it.Parent.Hash
+			}
+			items = append(items, e)
+		}
+		return false, nil
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].SubmittedAt.Before(items[j].SubmittedAt) })
+	return items, err
+}
+
+func writeDumpCSV(path string, header []string, rows [][]string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dumpRows returns the rows of the items and of the votes files, the scores and the comments are computed from
+// the exported votes and items, so the files are consistent with each other
+func dumpRows(items []dumpItem, votes []dumpVote, p dumpPseudonyms) ([][]string, [][]string) {
+	scores := make(map[Hash]int)
+	counts := make(map[Hash]int)
+	voteRows := make([][]string, 0, len(votes))
+	for _, v := range votes {
+		scores[v.item] += v.weight
+		counts[v.item]++
+		voteRows = append(voteRows, []string{p.of(v.item), p.of(v.voter), strconv.Itoa(v.weight), dumpTime(v.votedAt)})
+	}
+	comments := make(map[Hash]int)
+	for _, it := range items {
+		if it.parent.IsValid() && it.parent != it.OP {
+			comments[it.parent]++
+		}
+		if it.OP.IsValid() {
+			comments[it.OP]++
+		}
+	}
+	itemRows := make([][]string, 0, len(items))
+	for _, it := range items {
+		kind := dumpSubmission
+		if !it.IsTop() {
+			kind = dumpComment
+		}
+		itemRows = append(itemRows, []string{
+			p.of(it.Hash),
+			p.of(it.OP),
+			p.of(it.parent),
+			p.of(it.Author),
+			strconv.FormatBool(it.local),
+			kind,
+			dumpTime(it.SubmittedAt),
+			it.Board,
+			it.Domain,
+			strings.Join(it.Tags, " "),
+			strconv.Itoa(scores[it.Hash]),
+			strconv.Itoa(counts[it.Hash]),
+			strconv.Itoa(comments[it.Hash]),
+		})
+	}
+	return itemRows, voteRows
+}
+
+// DumpAnonymized writes the anonymized dumps of the items and votes stored in fedbox to the dir directory, it's
+// used by the export command. See DumpSchema for the columns of the files.
+func DumpAnonymized(ctx context.Context, c *config.Configuration, host string, port int, ver string, dir string) (int, int, error) {
+	if !c.ExportEnabled {
+		return 0, 0, errors.Errorf("the export is disabled, it can be enabled with %s", config.KeyExportEnabled)
+	}
+	p, err := newDumpPseudonyms(c.ExportSalt)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to generate the salt of the pseudonyms")
+	}
+	a := Application{Version: ver}
+	a.configure(c, host, port)
+	repo, err := ActivityPubService(appConfig{Configuration: *c, BaseURL: a.BaseURL, SessionKeys: loadEnvSessionKeys(), Logger: a.Logger.New(log.Ctx{"package": "export"})})
+	if repo == nil || repo.fedbox == nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the ActivityPub service")
+	}
+	if err := repo.fedbox.loadService(ctx); err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the fedbox service")
+	}
+	votes, err := repo.dumpVotes(ctx)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the votes")
+	}
+	items, err := repo.dumpItems(ctx)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the items")
+	}
+	itemRows, voteRows := dumpRows(items, votes, p)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to create the export directory")
+	}
+	itemsHeader := []string{"id", "op", "parent", "author", "local", "kind", "submitted", "board", "domain", "tags", "score", "votes", "comments"}
+	if err := writeDumpCSV(filepath.Join(dir, dumpItemsFile), itemsHeader, itemRows); err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to write the items")
+	}
+	votesHeader := []string{"item", "voter", "weight", "voted"}
+	if err := writeDumpCSV(filepath.Join(dir, dumpVotesFile), votesHeader, voteRows); err != nil {
+		return len(itemRows), 0, errors.Annotatef(err, "unable to write the votes")
+	}
+	return len(itemRows), len(voteRows), nil
+}
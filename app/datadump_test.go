@@ -0,0 +1,49 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpRows(t *testing.T) {
+	p, _ := newDumpPseudonyms("secret")
+	now := time.Date(2021, 6, 10, 12, 34, 56, 0, time.UTC)
+	op := dumpItem{indexEntry: indexEntry{Hash: testHash(1), Author: testHash(10), Domain: "example.com", Tags: []string{"go", "fediverse"}, SubmittedAt: now}, local: true}
+	reply := dumpItem{indexEntry: indexEntry{Hash: testHash(2), OP: op.Hash, Author: testHash(11), SubmittedAt: now}, parent: op.Hash}
+	nested := dumpItem{indexEntry: indexEntry{Hash: testHash(3), OP: op.Hash, Author: testHash(10), SubmittedAt: now}, parent: reply.Hash}
+	votes := []dumpVote{
+		{item: op.Hash, voter: testHash(11), weight: 1, votedAt: now},
+		{item: op.Hash, voter: testHash(12), weight: -1, votedAt: now},
+		{item: reply.Hash, voter: testHash(10), weight: 1, votedAt: now},
+	}
+	items, voteRows := dumpRows([]dumpItem{op, reply, nested}, votes, p)
+
+	want := [][]string{
+		{p.of(op.Hash), "", "", p.of(testHash(10)), "true", dumpSubmission, "2021-06-10T12:00Z", "", "example.com", "go fediverse", "0", "2", "2"},
+		{p.of(reply.Hash), p.of(op.Hash), p.of(op.Hash), p.of(testHash(11)), "false", dumpComment, "2021-06-10T12:00Z", "", "", "", "1", "1", "1"},
+		{p.of(nested.Hash), p.of(op.Hash), p.of(reply.Hash), p.of(testHash(10)), "false", dumpComment, "2021-06-10T12:00Z", "", "", "", "0", "0", "0"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("expected %d items, got %d", len(want), len(items))
+	}
+	for i, row := range items {
+		if strings.Join(row, ",") != strings.Join(want[i], ",") {
+			t.Errorf("item %d: expected %v, got %v", i, want[i], row)
+		}
+	}
+	if len(voteRows) != len(votes) {
+		t.Fatalf("expected %d votes, got %d", len(votes), len(voteRows))
+	}
+	for _, row := range append(items, voteRows...) {
+		for _, col := range row {
+			if strings.Contains(col, testHash(10).String()) {
+				t.Errorf("expected the hashes to be replaced with pseudonyms, got %v", row)
+			}
+		}
+	}
+	other, _ := newDumpPseudonyms("other")
+	if other.of(op.Hash) == p.of(op.Hash) {
+		t.Errorf("expected the pseudonyms to depend on the salt")
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+// dedupHits counts the redelivered activities we ignored, it's exposed under /debug/vars
+var dedupHits = expvar.NewInt("dedup_hits")
+
+type dedupEntry struct {
+	iri pub.IRI
+	at  time.Time
+}
+
+// activityDedup keeps track of the activities we processed recently, so when remote servers
+// redeliver the same activity under a different ID we don't end up with duplicate comments or votes
+type activityDedup struct {
+	m    sync.Mutex
+	ttl  time.Duration
+	seen map[string]dedupEntry
+	gcAt time.Time
+}
+
+func newActivityDedup(ttl time.Duration) *activityDedup {
+	return &activityDedup{ttl: ttl, seen: make(map[string]dedupEntry)}
+}
+
+// dedupKey identifies an activity by what it does, not by its ID
+func dedupKey(a *pub.Activity) string {
+	if a == nil || a.Actor == nil || a.Object == nil {
+		return ""
+	}
+	return strings.Join([]string{string(a.GetType()), a.Actor.GetLink().String(), a.Object.GetLink().String()}, " ")
+}
+
+// IsDuplicate returns true if we've seen an activity with a different ID but with the same type,
+// actor and object in the last ttl interval
+// NOTE(marius): a vote, followed by its Undo and a new vote inside the interval would also be
+// considered a duplicate, but in that case the first vote isn't present in the collections anymore.
+func (d *activityDedup) IsDuplicate(a *pub.Activity) bool {
+	if d == nil || d.ttl <= 0 {
+		return false
+	}
+	key := dedupKey(a)
+	if len(key) == 0 {
+		return false
+	}
+	now := time.Now()
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if now.After(d.gcAt) {
+		for k, e := range d.seen {
+			if now.Sub(e.at) > d.ttl {
+				delete(d.seen, k)
+			}
+		}
+		d.gcAt = now.Add(d.ttl)
+	}
+	if e, ok := d.seen[key]; ok && now.Sub(e.at) <= d.ttl && !e.iri.Equals(a.GetLink(), false) {
+		dedupHits.Add(1)
+		return true
+	}
+	d.seen[key] = dedupEntry{iri: a.GetLink(), at: now}
+	return false
+}
+
+// LocalOnly allows access only to requests coming from the loopback interface
+func LocalOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	pendingDeletesFile          = "deletes.json"
+	pendingDeletesCheckInterval = 30 * time.Second
+	pendingDeleteTimeOut        = 30 * time.Second
+	pendingDeleteMaxFailures    = 10
+)
+
+// pendingDelete is an item its author removed, whose Delete activity we didn't send yet.
+// We keep the token of the author, so we can send it as them when the restore window passes,
+// it's saved encrypted, see tokenKeys.
+type pendingDelete struct {
+	IRI         string        `json:"iri"`
+	Account     string        `json:"account"`
+	Token       *oauth2.Token `json:"-"`
+	SealedToken string        `json:"sealedToken,omitempty"`
+	At          time.Time     `json:"at"`
+	Failures    int           `json:"failures,omitempty"`
+}
+
+// pendingDeletes are the items waiting for the restore window to pass before being deleted, by their hash
+type pendingDeletes struct {
+	m      sync.RWMutex
+	path   string
+	window time.Duration
+	keys   tokenKeys
+	items  map[Hash]pendingDelete
+}
+
+func loadPendingDeletes(path string, window time.Duration, keys tokenKeys) (*pendingDeletes, error) {
+	d := &pendingDeletes{path: path, window: window, keys: keys, items: make(map[Hash]pendingDelete)}
+	if err := loadJSON(path, &d.items); err != nil {
+		return d, err
+	}
+	failed := 0
+	for h, p := range d.items {
+		tok, err := keys.open(p.SealedToken)
+		if err != nil {
+			failed++
+			continue
+		}
+		p.Token = tok
+		d.items[h] = p
+	}
+	if failed > 0 {
+		return d, errors.Newf("unable to decrypt the tokens of %d pending deletes", failed)
+	}
+	return d, nil
+}
+
+// Enabled returns if the deletes wait for the restore window, instead of being sent right away
+func (d *pendingDeletes) Enabled() bool {
+	return d != nil && d.window > 0
+}
+
+// Pending returns if the item with hash h was deleted, and can still be restored
+func (d *pendingDeletes) Pending(h Hash) bool {
+	if !d.Enabled() {
+		return false
+	}
+	d.m.RLock()
+	defer d.m.RUnlock()
+	_, ok := d.items[h]
+	return ok
+}
+
+// Add marks the item as deleted by acc
+func (d *pendingDeletes) Add(i Item, acc *Account) error {
+	if !d.Enabled() {
+		return errors.Errorf("the restore window is disabled")
+	}
+	if i.Metadata == nil || len(i.Metadata.ID) == 0 || !acc.IsLogged() || acc.Metadata == nil {
+		return errors.BadRequestf("invalid item to delete")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.items[i.Hash] = pendingDelete{
+		IRI:     i.Metadata.ID,
+		Account: acc.Metadata.ID,
+		Token:   acc.Metadata.OAuth.Token,
+		At:      time.Now().UTC(),
+	}
+	return d.save()
+}
+
+// Remove restores the item with hash h, it returns false if it wasn't pending anymore
+func (d *pendingDeletes) Remove(h Hash) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if _, ok := d.items[h]; !ok {
+		return false, nil
+	}
+	delete(d.items, h)
+	return true, d.save()
+}
+
+// due returns the deletes whose restore window passed
+func (d *pendingDeletes) due() map[Hash]pendingDelete {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	res := make(map[Hash]pendingDelete)
+	for h, p := range d.items {
+		// NOTE(marius): after too many failures we give up, the item stays hidden, and its author can restore it
+		if p.Failures >= pendingDeleteMaxFailures {
+			continue
+		}
+		if time.Now().After(p.At.Add(d.window)) {
+			res[h] = p
+		}
+	}
+	return res
+}
+
+// update saves the state of the delete after trying to send it, unless it was restored in the mean time
+func (d *pendingDeletes) update(h Hash, p pendingDelete) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if _, ok := d.items[h]; !ok {
+		return nil
+	}
+	d.items[h] = p
+	return d.save()
+}
+
+// save writes the pending deletes to disk, it needs to be called with the lock held
+func (d *pendingDeletes) save() error {
+	for h, p := range d.items {
+		if p.Token == nil {
+			// NOTE(marius): the tokens we couldn't decrypt are kept as they were
+			continue
+		}
+		var err error
+		if p.SealedToken, err = d.keys.seal(p.Token); err != nil {
+			return err
+		}
+		d.items[h] = p
+	}
+	return saveJSON(d.path, d.items)
+}
+
+// tokenAccount loads the account with the iri, with a valid OAuth2 token for submitting as them.
+// The token gets refreshed if needed, the callers need to keep the returned one.
+func (r *repository) tokenAccount(ctx context.Context, iri string, tok *oauth2.Token) (*Account, *oauth2.Token, error) {
+	if tok == nil {
+		return nil, tok, errors.Unauthorizedf("missing token for %s", iri)
+	}
+	config := GetOauth2Config(oauthClientProvider, r.SelfURL)
+	fresh, err := config.TokenSource(ctx, tok).Token()
+	if err != nil {
+		return nil, tok, errors.Annotatef(err, "unable to refresh the token of the author")
+	}
+	acc, err := r.LoadAccount(ctx, pub.IRI(iri))
+	if err != nil {
+		return nil, fresh, err
+	}
+	acc.Metadata.OAuth.Provider = oauthClientProvider
+	acc.Metadata.OAuth.Token = fresh
+	return acc, fresh, nil
+}
+
+// sendDelete deletes the item once its restore window passed
+func (r *repository) sendDelete(h Hash, p pendingDelete) {
+	ctx, cancel := context.WithTimeout(context.Background(), pendingDeleteTimeOut)
+	defer cancel()
+
+	lCtx := log.Ctx{"iri": p.IRI, "account": p.Account}
+	err := func() error {
+		acc, tok, err := r.tokenAccount(ctx, p.Account, p.Token)
+		p.Token = tok
+		if err != nil {
+			return err
+		}
+		it, err := r.LoadItem(ctx, pub.IRI(p.IRI))
+		if err != nil {
+			return err
+		}
+		if it.Deleted() {
+			return nil
+		}
+		it.SubmittedBy = acc
+		it.Delete()
+		_, err = r.SaveItem(ctx, it)
+		return err
+	}()
+	if err == nil || errors.IsNotFound(err) {
+		if _, err := r.deletes.Remove(h); err != nil {
+			r.errFn(lCtx, log.Ctx{"err": err})("unable to save the pending deletes")
+		}
+		return
+	}
+	p.Failures++
+	r.errFn(lCtx, log.Ctx{"err": err, "failures": p.Failures})("unable to delete the item")
+	if p.Failures >= pendingDeleteMaxFailures {
+		r.errFn(lCtx)("giving up deleting the item")
+	}
+	if err := r.deletes.update(h, p); err != nil {
+		r.errFn(lCtx, log.Ctx{"err": err})("unable to save the pending deletes")
+	}
+}
+
+// runPendingDeletes sends the Delete activities for the items whose restore window passed
+func (r *repository) runPendingDeletes() {
+	if !r.deletes.Enabled() {
+		return
+	}
+	t := time.NewTicker(pendingDeletesCheckInterval)
+	defer t.Stop()
+	for range t.C {
+		for h, p := range r.deletes.due() {
+			r.sendDelete(h, p)
+		}
+	}
+}
+
+// HandleRestore serves the /{hash}/restore POST requests, which undo the deletion of an item,
+// while its Delete activity wasn't sent yet
+func (h *handler) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	hash := HashFromString(chi.URLParam(r, "hash"))
+	url := r.URL
+	url.Path = path.Dir(url.Path)
+
+	ok, err := h.storage.deletes.Remove(hash)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": hash})("unable to save the pending deletes")
+	}
+	if ok {
+		h.v.addFlashMessage(Success, w, r, "The item was restored.")
+	} else {
+		h.v.addFlashMessage(Error, w, r, "The item can not be restored anymore.")
+	}
+	h.v.Redirect(w, r, url.RequestURI(), http.StatusSeeOther)
+}
+
+// deletedMessage is the confirmation we show after the deletion of an item which can be restored
+func deletedMessage(window time.Duration) string {
+	if window < 2*time.Minute {
+		return fmt.Sprintf("The item was deleted, you can restore it in the next %d seconds.", int(window.Seconds()))
+	}
+	return fmt.Sprintf("The item was deleted, you can restore it in the next %d minutes.", int(window.Minutes()))
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPendingDeletes(t *testing.T) {
+	d, err := loadPendingDeletes("", time.Minute, testTokenKeys)
+	if err != nil {
+		t.Fatalf("unable to load the pending deletes: %s", err)
+	}
+	it := Item{Hash: HashFromString("d5ad20a3"), Metadata: &ItemMetadata{ID: "https://littr.git/objects/d5ad20a3"}}
+	acc := &Account{
+		Handle:   "test",
+		Hash:     HashFromString("f00f00f00"),
+		Metadata: &AccountMetadata{ID: "https://littr.git/actors/f00f00f00"},
+	}
+	acc.Metadata.OAuth.Token = &oauth2.Token{AccessToken: "test"}
+	if err := d.Add(it, acc); err != nil {
+		t.Fatalf("unable to add the pending delete: %s", err)
+	}
+	if !d.Pending(it.Hash) {
+		t.Errorf("the item should be pending deletion")
+	}
+	if due := d.due(); len(due) > 0 {
+		t.Errorf("the item was deleted before the restore window passed")
+	}
+
+	p := d.items[it.Hash]
+	if p.Account != acc.Metadata.ID || p.Token == nil || p.Token.AccessToken != "test" {
+		t.Errorf("the pending delete doesn't have the author and their token: %v", p)
+	}
+	if tok, err := testTokenKeys.open(p.SealedToken); err != nil || tok.AccessToken != "test" || strings.Contains(p.SealedToken, "test") {
+		t.Errorf("the token of the pending delete wasn't sealed: %q %v", p.SealedToken, err)
+	}
+
+	p.At = p.At.Add(-2 * time.Minute)
+	d.items[it.Hash] = p
+	if due := d.due(); len(due) != 1 {
+		t.Errorf("expected the item to be due for deletion, got %d items", len(due))
+	}
+
+	if ok, err := d.Remove(it.Hash); !ok || err != nil {
+		t.Errorf("unable to restore the item: %t %v", ok, err)
+	}
+	if ok, _ := d.Remove(it.Hash); ok {
+		t.Errorf("the item was restored twice")
+	}
+	if d.Pending(it.Hash) {
+		t.Errorf("the restored item is still pending deletion")
+	}
+
+	disabled, _ := loadPendingDeletes("", 0, testTokenKeys)
+	if err := disabled.Add(it, acc); err == nil {
+		t.Errorf("the items should be deleted right away without a restore window")
+	}
+}
@@ -0,0 +1,495 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	digestsFile         = "digests.json"
+	digestTokenSize     = 16
+	digestCheckInterval = 5 * time.Minute
+
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// defaultDigestTemplate is the message of the digest emails, the instance admin can replace it with DIGEST_TEMPLATE.
+// The optional "subject" template is used for the subject of the messages.
+const defaultDigestTemplate = `{{ define "subject" }}Your {{ .Frequency }} digest from {{ .Name }}{{ end -}}
+Hello {{ .Handle }},
+
+these are the top submissions on {{ .Name }} since {{ .Since.Format "Monday, January 2" }}:
+{{ range .Items }}
+{{ .Position }}. {{ .Title }}
+   {{ .URL }}
+   {{ .Score }} points by {{ .Handle }}, {{ .Replies }} comments
+{{ end }}
+--
+Change the schedule of the digest: {{ .Settings }}
+Unsubscribe: {{ .Unsubscribe }}
+`
+
+// digestSubscription is the digest email an account opted in to. The followed tags are copied here
+// when they change, as the background job has no access to the outbox of the account.
+type digestSubscription struct {
+	Account   string       `json:"account"`
+	Handle    string       `json:"handle"`
+	Email     string       `json:"email"`
+	Frequency string       `json:"frequency"`
+	Hour      int          `json:"hour"`
+	Weekday   time.Weekday `json:"weekday"`
+	Tags      []string     `json:"tags,omitempty"`
+	Confirmed bool         `json:"confirmed"`
+	LastSent  time.Time    `json:"last_sent"`
+}
+
+// Period returns the interval between two digests
+func (s digestSubscription) Period() time.Duration {
+	if s.Frequency == digestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// lastScheduled returns the latest time, before now, when a digest was supposed to be sent. The hours are in UTC.
+func (s digestSubscription) lastScheduled(now time.Time) time.Time {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, 0, 0, 0, time.UTC)
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	for s.Frequency == digestWeekly && t.Weekday() != s.Weekday {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// due returns if we need to send the digest
+func (s digestSubscription) due(now time.Time) bool {
+	return s.Confirmed && s.LastSent.Before(s.lastScheduled(now))
+}
+
+// digests keeps the digest subscriptions of the local accounts, by the token used in their confirmation
+// and unsubscribe links. Every account has at most one.
+type digests struct {
+	m             sync.RWMutex
+	path          string
+	mail          *mailer
+	max           int
+	tpl           *template.Template
+	Subscriptions map[string]digestSubscription `json:"subscriptions"`
+}
+
+func loadDigests(path string, m *mailer, max int) (*digests, error) {
+	tpl, _ := template.New("digest").Parse(defaultDigestTemplate)
+	d := &digests{path: path, mail: m, max: max, tpl: tpl, Subscriptions: make(map[string]digestSubscription)}
+	return d, loadJSON(path, d)
+}
+
+// LoadTemplate replaces the default message of the digests with the template at path
+func (d *digests) LoadTemplate(path string) error {
+	if d == nil || len(path) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tpl, err := template.New("digest").Parse(string(data))
+	if err != nil {
+		return err
+	}
+	d.tpl = tpl
+	return nil
+}
+
+// Enabled returns if the instance can send the digests
+func (d *digests) Enabled() bool {
+	return d != nil && d.mail.Enabled()
+}
+
+// Get returns the digest subscription of the account with id
+func (d *digests) Get(id string) (string, digestSubscription, bool) {
+	if !d.Enabled() {
+		return "", digestSubscription{}, false
+	}
+	d.m.RLock()
+	defer d.m.RUnlock()
+	for tok, s := range d.Subscriptions {
+		if s.Account == id {
+			return tok, s, true
+		}
+	}
+	return "", digestSubscription{}, false
+}
+
+// Subscribe saves the digest subscription of the account, it returns the token of the subscription and
+// if it still needs to be confirmed. Changing the address needs a new confirmation.
+func (d *digests) Subscribe(s digestSubscription) (string, bool, error) {
+	if !d.Enabled() {
+		return "", false, errors.Errorf("the digests are disabled")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	for tok, old := range d.Subscriptions {
+		if old.Account != s.Account {
+			continue
+		}
+		if old.Confirmed && strings.EqualFold(old.Email, s.Email) {
+			s.Confirmed = true
+			s.LastSent = old.LastSent
+			d.Subscriptions[tok] = s
+			return tok, false, d.save()
+		}
+		delete(d.Subscriptions, tok)
+	}
+	buf := make([]byte, digestTokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	tok := hex.EncodeToString(buf)
+	s.Confirmed = false
+	d.Subscriptions[tok] = s
+	return tok, true, d.save()
+}
+
+// Confirm enables the digest subscription with the token, the first digest is sent at its next scheduled time
+func (d *digests) Confirm(tok string) (digestSubscription, error) {
+	if d == nil {
+		return digestSubscription{}, errors.NotFoundf("the digest subscription was not found")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	s, ok := d.Subscriptions[tok]
+	if !ok {
+		return s, errors.NotFoundf("the digest subscription was not found")
+	}
+	if s.Confirmed {
+		return s, nil
+	}
+	s.Confirmed = true
+	s.LastSent = time.Now().UTC()
+	d.Subscriptions[tok] = s
+	return s, d.save()
+}
+
+// Unsubscribe removes the digest subscription with the token
+func (d *digests) Unsubscribe(tok string) (digestSubscription, error) {
+	if d == nil {
+		return digestSubscription{}, errors.NotFoundf("the digest subscription was not found")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	s, ok := d.Subscriptions[tok]
+	if !ok {
+		return s, errors.NotFoundf("the digest subscription was not found")
+	}
+	delete(d.Subscriptions, tok)
+	return s, d.save()
+}
+
+// FollowTag updates the tags of the digest of the account with id when it follows, or unfollows, a tag
+func (d *digests) FollowTag(id, tag string, follow bool) error {
+	if !d.Enabled() {
+		return nil
+	}
+	tag = strings.ToLower(strings.TrimLeft(tag, "#"))
+	d.m.Lock()
+	defer d.m.Unlock()
+	for tok, s := range d.Subscriptions {
+		if s.Account != id || stringInSlice(s.Tags)(tag) == follow {
+			continue
+		}
+		tags := make([]string, 0, len(s.Tags)+1)
+		for _, t := range s.Tags {
+			if t != tag {
+				tags = append(tags, t)
+			}
+		}
+		if follow {
+			tags = append(tags, tag)
+		}
+		s.Tags = tags
+		d.Subscriptions[tok] = s
+		return d.save()
+	}
+	return nil
+}
+
+// due returns the tokens of the digests we need to send
+func (d *digests) due(now time.Time) []string {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	toks := make([]string, 0)
+	for tok, s := range d.Subscriptions {
+		if s.due(now) {
+			toks = append(toks, tok)
+		}
+	}
+	return toks
+}
+
+func (d *digests) sent(tok string, t time.Time) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	s, ok := d.Subscriptions[tok]
+	if !ok {
+		return nil
+	}
+	s.LastSent = t
+	d.Subscriptions[tok] = s
+	return d.save()
+}
+
+func (d *digests) subscription(tok string) (digestSubscription, bool) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	s, ok := d.Subscriptions[tok]
+	return s, ok
+}
+
+// save writes the subscriptions to disk, it needs to be called with the lock held
+func (d *digests) save() error {
+	return saveJSON(d.path, d)
+}
+
+type digestItem struct {
+	Position    int
+	Title       string
+	URL         string
+	Handle      string
+	Domain      string
+	Score       int
+	Replies     int
+	SubmittedAt time.Time
+}
+
+// digestModel is the data of the digest template
+type digestModel struct {
+	Name        string
+	Handle      string
+	Frequency   string
+	Since       time.Time
+	Items       []digestItem
+	Settings    string
+	Unsubscribe string
+}
+
+// render executes the digest template, returning the subject and the body of the message
+func (d *digests) render(m digestModel) (string, string, error) {
+	body := bytes.Buffer{}
+	if err := d.tpl.Execute(&body, m); err != nil {
+		return "", "", err
+	}
+	subject := fmt.Sprintf("Your %s digest from %s", m.Frequency, m.Name)
+	if t := d.tpl.Lookup("subject"); t != nil {
+		s := bytes.Buffer{}
+		if err := t.Execute(&s, m); err != nil {
+			return "", "", err
+		}
+		subject = strings.TrimSpace(s.String())
+	}
+	return subject, body.String(), nil
+}
+
+// digestEntries returns the best scored submissions since the date, from the followed tags and the subscribed
+// boards. When the account doesn't follow anything, it gets the best submissions of the whole instance.
+func digestEntries(idx *localIndex, since time.Time, tags, boards []string, max int) []indexEntry {
+	all := len(tags)+len(boards) == 0
+	entries := idx.Select(func(e indexEntry) bool {
+		if !e.IsTop() || !e.SubmittedAt.After(since) {
+			return false
+		}
+		if all || len(e.Board) > 0 && stringInSlice(boards)(e.Board) {
+			return true
+		}
+		for _, t := range tags {
+			if e.HasTag(t) {
+				return true
+			}
+		}
+		return false
+	}, byIndexScore)
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries
+}
+
+func digestLink(tok string) string {
+	return fmt.Sprintf("%s/digest/unsubscribe/%s", Instance.BaseURL, tok)
+}
+
+// sendDigest sends the digest with the token, if there's anything new in the followed tags and boards
+func (r *repository) sendDigest(tok string, now time.Time) error {
+	s, ok := r.digests.subscription(tok)
+	if !ok {
+		return nil
+	}
+	since := s.LastSent
+	if since.IsZero() {
+		since = now.Add(-s.Period())
+	}
+	boards := r.boards.Subscribed(HashFromIRI(pub.IRI(s.Account)))
+	entries := digestEntries(r.index, since, s.Tags, boards, r.digests.max)
+	if len(entries) == 0 {
+		return nil
+	}
+	m := digestModel{
+		Name:        Instance.Conf.Name,
+		Handle:      s.Handle,
+		Frequency:   s.Frequency,
+		Since:       since,
+		Items:       make([]digestItem, 0, len(entries)),
+		Settings:    fmt.Sprintf("%s%s/settings", Instance.BaseURL, AccountLocalLink(&Account{Handle: s.Handle})),
+		Unsubscribe: digestLink(tok),
+	}
+	for i, e := range entries {
+		m.Items = append(m.Items, digestItem{
+			Position:    i + 1,
+			Title:       e.Title,
+			URL:         Instance.BaseURL + e.LocalLink(),
+			Handle:      e.Handle,
+			Domain:      e.Domain,
+			Score:       e.Score,
+			Replies:     e.Replies,
+			SubmittedAt: e.SubmittedAt,
+		})
+	}
+	subject, body, err := r.digests.render(m)
+	if err != nil {
+		return errors.Annotatef(err, "unable to render the digest")
+	}
+	return r.digests.mail.Send(s.Email, subject, body, map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", m.Unsubscribe),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	})
+}
+
+// runDigests sends the digest emails at their scheduled times
+func (r *repository) runDigests() {
+	if !r.digests.Enabled() {
+		return
+	}
+	t := time.NewTicker(digestCheckInterval)
+	defer t.Stop()
+	for range t.C {
+		now := time.Now().UTC()
+		for _, tok := range r.digests.due(now) {
+			if err := r.sendDigest(tok, now); err != nil {
+				r.errFn(log.Ctx{"err": err})("unable to send the digest")
+			}
+			// NOTE(marius): the failed digests are not retried, so a broken address doesn't get
+			// a new attempt every few minutes
+			if err := r.digests.sent(tok, now); err != nil {
+				r.errFn(log.Ctx{"err": err})("unable to save the digest state")
+			}
+		}
+	}
+}
+
+// HandleDigestSettings handles POST /~handle/settings/digest requests, which save or remove the digest subscription
+func (h *handler) HandleDigestSettings(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	id := accountIRI(acc).String()
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+	if r.PostFormValue("action") == "unsubscribe" {
+		if tok, _, ok := h.storage.digests.Get(id); ok {
+			if _, err := h.storage.digests.Unsubscribe(tok); err != nil {
+				h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to remove the digest subscription")
+				h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to remove the digest subscription"))
+				return
+			}
+		}
+		h.v.addFlashMessage(Success, w, r, "You won't receive the digest emails anymore.")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+
+	addr, err := mail.ParseAddress(strings.TrimSpace(r.PostFormValue("email")))
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid email address"))
+		return
+	}
+	s := digestSubscription{Account: id, Handle: acc.Handle, Email: addr.Address, Frequency: digestDaily}
+	if r.PostFormValue("frequency") == digestWeekly {
+		s.Frequency = digestWeekly
+	}
+	if hour, err := strconv.Atoi(r.PostFormValue("hour")); err == nil && hour >= 0 && hour < 24 {
+		s.Hour = hour
+	}
+	if day, err := strconv.Atoi(r.PostFormValue("weekday")); err == nil && day >= 0 && day < 7 {
+		s.Weekday = time.Weekday(day)
+	}
+	for _, t := range acc.Tags {
+		s.Tags = append(s.Tags, strings.ToLower(strings.TrimLeft(t.Name, "#")))
+	}
+	tok, confirm, err := h.storage.digests.Subscribe(s)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the digest subscription")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the digest subscription"))
+		return
+	}
+	if !confirm {
+		h.v.addFlashMessage(Success, w, r, "Your digest settings were saved.")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	body := fmt.Sprintf("Hello %s,\n\nsomebody, hopefully you, asked for a %s digest of the top submissions on %s "+
+		"to be sent to this address.\n\nConfirm the subscription: %s/digest/confirm/%s\n\n"+
+		"If it wasn't you, ignore this message and you won't hear from us again.\n",
+		acc.Handle, s.Frequency, h.conf.Name, Instance.BaseURL, tok)
+	if err := h.storage.digests.mail.Send(s.Email, fmt.Sprintf("Confirm your %s digest subscription", h.conf.Name), body, nil); err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to send the digest confirmation")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to send the confirmation email"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("We sent a confirmation link to %s.", s.Email))
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// HandleDigestConfirm handles GET /digest/confirm/{token} requests, from the link of the confirmation email
+func (h *handler) HandleDigestConfirm(w http.ResponseWriter, r *http.Request) {
+	s, err := h.storage.digests.Confirm(chi.URLParam(r, "token"))
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("You will receive the %s digest at %s.", s.Frequency, s.Email))
+	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// HandleDigestUnsubscribe handles the GET and POST /digest/unsubscribe/{token} requests, from the link of the
+// digest emails, or from the mail clients supporting one-click unsubscribing
+func (h *handler) HandleDigestUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	s, err := h.storage.digests.Unsubscribe(chi.URLParam(r, "token"))
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.infoFn(log.Ctx{"handle": s.Handle})("digest unsubscribed")
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%s won't receive the digest emails anymore.", s.Email))
+	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+}
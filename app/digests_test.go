@@ -0,0 +1,85 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDigestSubscriptionDue(t *testing.T) {
+	// 2020-05-06 is a Wednesday
+	now := time.Date(2020, 5, 6, 10, 30, 0, 0, time.UTC)
+	tests := map[string]struct {
+		sub  digestSubscription
+		want bool
+	}{
+		"not confirmed": {sub: digestSubscription{Frequency: digestDaily, Hour: 8}},
+		"daily, sent yesterday": {
+			sub:  digestSubscription{Frequency: digestDaily, Hour: 8, Confirmed: true, LastSent: now.Add(-25 * time.Hour)},
+			want: true,
+		},
+		"daily, sent today": {
+			sub: digestSubscription{Frequency: digestDaily, Hour: 8, Confirmed: true, LastSent: now.Add(-2 * time.Hour)},
+		},
+		"daily, later today": {
+			sub: digestSubscription{Frequency: digestDaily, Hour: 11, Confirmed: true, LastSent: now.Add(-20 * time.Hour)},
+		},
+		"weekly, on monday": {
+			sub:  digestSubscription{Frequency: digestWeekly, Hour: 8, Weekday: time.Monday, Confirmed: true, LastSent: now.AddDate(0, 0, -7)},
+			want: true,
+		},
+		"weekly, sent on monday": {
+			sub: digestSubscription{Frequency: digestWeekly, Hour: 8, Weekday: time.Monday, Confirmed: true, LastSent: now.AddDate(0, 0, -2)},
+		},
+	}
+	for name, tt := range tests {
+		if got := tt.sub.due(now); got != tt.want {
+			t.Errorf("%s: expected due %t, got %t", name, tt.want, got)
+		}
+	}
+}
+
+func TestDigestEntries(t *testing.T) {
+	now := time.Now()
+	idx := newLocalIndex()
+	add := func(title string, score int, board string, tags ...string) {
+		e := indexEntry{Hash: Hash(uuid.New()), IRI: "https://littr.git/objects/1", Title: title, Score: score,
+			Board: board, Tags: tags, Public: true, SubmittedAt: now.Add(-time.Hour)}
+		idx.entries[e.Hash] = &e
+	}
+	add("go", 10, "", "golang")
+	add("rust", 20, "", "rust")
+	add("board", 5, "programming")
+	add("unrelated", 50, "")
+
+	got := digestEntries(idx, now.Add(-24*time.Hour), []string{"golang"}, []string{"programming"}, 10)
+	if len(got) != 2 || got[0].Title != "go" || got[1].Title != "board" {
+		t.Errorf("expected the followed tag and board, got %v", got)
+	}
+	if got := digestEntries(idx, now.Add(-24*time.Hour), nil, nil, 2); len(got) != 2 || got[0].Title != "unrelated" {
+		t.Errorf("expected the top of the instance, got %v", got)
+	}
+	if got := digestEntries(idx, now, nil, nil, 2); len(got) != 0 {
+		t.Errorf("expected no entries since now, got %v", got)
+	}
+
+	d, _ := loadDigests("", nil, 10)
+	subject, body, err := d.render(digestModel{
+		Name:        "littr",
+		Handle:      "test",
+		Frequency:   digestWeekly,
+		Items:       []digestItem{{Position: 1, Title: "go", URL: "https://littr.git/~test/1"}},
+		Unsubscribe: "https://littr.git/digest/unsubscribe/f00",
+	})
+	if err != nil {
+		t.Fatalf("unable to render the digest: %s", err)
+	}
+	if subject != "Your weekly digest from littr" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "1. go") || !strings.Contains(body, "https://littr.git/digest/unsubscribe/f00") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"unicode"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+const (
+	maxDisplayNameLength = 64
+	// maxNameEmoji limits the custom emoji we load for a name, as remote servers can send any number of them
+	maxNameEmoji = 16
+
+	emojiType pub.ActivityVocabularyType = "Emoji"
+)
+
+// Emoji is a custom emoji used in the display name of an account, like Mastodon's `:blobcat:`
+type Emoji struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+func (e Emoji) IsValid() bool {
+	if len(e.Name) == 0 || !strings.HasPrefix(e.URI, "https://") && !strings.HasPrefix(e.URI, "http://") {
+		return false
+	}
+	for _, c := range e.Name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// emojiFromTags loads the custom emoji from the tags of an actor
+func emojiFromTags(tags pub.ItemCollection) []Emoji {
+	emoji := make([]Emoji, 0)
+	for _, t := range tags {
+		if t == nil || t.GetType() != emojiType || len(emoji) >= maxNameEmoji {
+			continue
+		}
+		pub.OnObject(t, func(o *pub.Object) error {
+			e := Emoji{Name: strings.Trim(o.Name.First().Value.String(), ":")}
+			if o.Icon != nil {
+				pub.OnObject(o.Icon, func(ic *pub.Object) error {
+					if ic.URL != nil {
+						e.URI = ic.URL.GetLink().String()
+					}
+					return nil
+				})
+			}
+			if e.IsValid() {
+				emoji = append(emoji, e)
+			}
+			return nil
+		})
+	}
+	return emoji
+}
+
+// collapseSpaces removes the text direction characters, and replaces the control characters and the runs
+// of whitespace with single spaces
+func collapseSpaces(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if unicode.Is(unicode.Bidi_Control, c) {
+			return -1
+		}
+		if unicode.IsControl(c) {
+			return ' '
+		}
+		return c
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// sanitizeDisplayName removes the control and the text direction characters from the name,
+// collapses the whitespace and truncates it to maxDisplayNameLength characters
+func sanitizeDisplayName(name string) string {
+	name = collapseSpaces(name)
+	if runes := []rune(name); len(runes) > maxDisplayNameLength {
+		name = strings.TrimSpace(string(runes[:maxDisplayNameLength]))
+	}
+	return name
+}
+
+// displayName returns the display name of the account, if it has one which is different than its handle
+func displayName(a *Account) string {
+	if a == nil || !a.HasMetadata() {
+		return ""
+	}
+	name := sanitizeDisplayName(a.Metadata.Name)
+	if strings.EqualFold(name, a.Handle) {
+		return ""
+	}
+	return name
+}
+
+func hasDisplayName(a *Account) bool {
+	return len(displayName(a)) > 0
+}
+
+// ShowAccountName returns the display name of the account with its custom emoji, or its handle if it doesn't have one
+func ShowAccountName(a *Account) template.HTML {
+	name := displayName(a)
+	if len(name) == 0 {
+		return template.HTML(template.HTMLEscapeString(ShowAccountHandle(a)))
+	}
+	name = template.HTMLEscapeString(name)
+	for _, e := range a.Metadata.Emoji {
+		if !e.IsValid() {
+			continue
+		}
+		code := fmt.Sprintf(":%s:", e.Name)
+		img := fmt.Sprintf(`<img class="emoji" src="%s" alt="%s" title="%s"/>`, template.HTMLEscapeString(e.URI), code, code)
+		name = strings.Replace(name, code, img, -1)
+	}
+	return template.HTML(name)
+}
@@ -0,0 +1,42 @@
+package app
+
+import "testing"
+
+func TestSanitizeDisplayName(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "plain",
+			val:  "Marius Orcsik",
+			want: "Marius Orcsik",
+		},
+		{
+			name: "whitespace",
+			val:  "  Marius \t\n Orcsik ",
+			want: "Marius Orcsik",
+		},
+		{
+			name: "text direction override",
+			val:  "evil\u202egnp.exe",
+			want: "evilgnp.exe",
+		},
+		{
+			name: "too long",
+			val:  "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			want: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeDisplayName(tt.val); got != tt.want {
+				t.Errorf("sanitizeDisplayName(%q) = %q, want %q", tt.val, got, tt.want)
+			}
+		})
+	}
+}
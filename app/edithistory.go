@@ -0,0 +1,249 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+const (
+	editHistoryFile = "edit-history.json"
+	// editMaxRevisions is the number of old versions we keep for an item, the older ones get dropped
+	editMaxRevisions = 20
+	// diffMaxLines bounds the texts we compare line by line, the longer ones are shown as replaced
+	diffMaxLines = 500
+)
+
+// itemRevision is a version of an item which was replaced by an edit, it was shown from At until Until
+type itemRevision struct {
+	Title    string    `json:"title,omitempty"`
+	Data     string    `json:"data"`
+	MimeType string    `json:"mimeType,omitempty"`
+	At       time.Time `json:"at"`
+	Until    time.Time `json:"until"`
+}
+
+// editHistory keeps the versions the items had before their authors edited them, by the hash of the item
+type editHistory struct {
+	m     sync.RWMutex
+	path  string
+	Items map[string][]itemRevision `json:"items"`
+}
+
+func loadEditHistory(path string) (*editHistory, error) {
+	e := &editHistory{path: path, Items: make(map[string][]itemRevision)}
+	return e, loadJSON(path, e)
+}
+
+// Has returns if the item with hash was edited
+func (e *editHistory) Has(hash Hash) bool {
+	if e == nil {
+		return false
+	}
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return len(e.Items[hash.String()]) > 0
+}
+
+// List returns the old versions of the item with hash, the oldest first
+func (e *editHistory) List(hash Hash) []itemRevision {
+	if e == nil {
+		return nil
+	}
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return append([]itemRevision(nil), e.Items[hash.String()]...)
+}
+
+// Record saves old, the version of the item before the edit at the time at. The edits which don't change
+// the title or the content are not recorded.
+func (e *editHistory) Record(old, edited Item, at time.Time) error {
+	if e == nil || !old.Hash.IsValid() || (old.Title == edited.Title && old.Data == edited.Data) {
+		return nil
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	key := old.Hash.String()
+	revs := e.Items[key]
+	rev := itemRevision{Title: old.Title, Data: old.Data, MimeType: old.MimeType, At: old.SubmittedAt, Until: at.UTC()}
+	if len(revs) > 0 {
+		rev.At = revs[len(revs)-1].Until
+	}
+	revs = append(revs, rev)
+	if len(revs) > editMaxRevisions {
+		revs = revs[len(revs)-editMaxRevisions:]
+	}
+	e.Items[key] = revs
+	return e.save()
+}
+
+func (e *editHistory) save() error {
+	return saveJSON(e.path, e)
+}
+
+// editWindow returns how long after submitting it the author can edit the item, 0 means there's no limit
+func editWindow(c config.Configuration, it *Item) time.Duration {
+	if it.Parent.IsValid() {
+		return c.CommentEditWindow
+	}
+	return c.EditWindow
+}
+
+// canEditItem returns if the edit window of the item is still open at the time now
+func canEditItem(c config.Configuration, it *Item, now time.Time) bool {
+	w := editWindow(c, it)
+	return w <= 0 || it.SubmittedAt.IsZero() || now.Sub(it.SubmittedAt) <= w
+}
+
+const (
+	diffSame    = " "
+	diffAdded   = "+"
+	diffRemoved = "-"
+)
+
+// diffLine is a line of the difference between two versions of a text
+type diffLine struct {
+	Op   string
+	Text string
+}
+
+// diffLines compares the texts a and b line by line, with the longest common subsequence of their lines
+func diffLines(a, b string) []diffLine {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	res := make([]diffLine, 0, len(al)+len(bl))
+
+	// NOTE(marius): the edits usually change a few lines, so we only compare what's between the common start and end
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		res = append(res, diffLine{Op: diffSame, Text: al[pre]})
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+	am, bm := al[pre:len(al)-suf], bl[pre:len(bl)-suf]
+
+	if len(am) > diffMaxLines || len(bm) > diffMaxLines {
+		for _, l := range am {
+			res = append(res, diffLine{Op: diffRemoved, Text: l})
+		}
+		for _, l := range bm {
+			res = append(res, diffLine{Op: diffAdded, Text: l})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of am[i:] and bm[j:]
+		lcs := make([][]int32, len(am)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(bm)+1)
+		}
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				switch {
+				case am[i] == bm[j]:
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(am) || j < len(bm) {
+			switch {
+			case i < len(am) && j < len(bm) && am[i] == bm[j]:
+				res = append(res, diffLine{Op: diffSame, Text: am[i]})
+				i, j = i+1, j+1
+			case j >= len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]):
+				res = append(res, diffLine{Op: diffRemoved, Text: am[i]})
+				i++
+			default:
+				res = append(res, diffLine{Op: diffAdded, Text: bm[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range al[len(al)-suf:] {
+		res = append(res, diffLine{Op: diffSame, Text: l})
+	}
+	return res
+}
+
+// itemVersion is a version of the item on its history page, with what changed from the previous one
+type itemVersion struct {
+	itemRevision
+	Current      bool
+	TitleChanged bool
+	PrevTitle    string
+	Diff         []diffLine
+}
+
+type historyModel struct {
+	Title    string
+	Hash     Hash
+	Content  *Item
+	Versions []itemVersion
+}
+
+func (m *historyModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (historyModel) Template() string {
+	return "history"
+}
+
+func (m *historyModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// itemVersions returns the versions of it, from its old revisions and its current content, the newest first
+func itemVersions(it *Item, revs []itemRevision) []itemVersion {
+	all := append(revs, itemRevision{Title: it.Title, Data: it.Data, MimeType: it.MimeType, At: it.UpdatedAt})
+	if len(revs) > 0 {
+		all[len(all)-1].At = revs[len(revs)-1].Until
+	}
+	versions := make([]itemVersion, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		v := itemVersion{itemRevision: all[i], Current: i == len(all)-1}
+		if i > 0 {
+			v.TitleChanged = all[i-1].Title != all[i].Title
+			v.PrevTitle = all[i-1].Title
+			v.Diff = diffLines(all[i-1].Data, all[i].Data)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// HandleItemHistory serves the /{hash}/history requests, with the versions of the item and what its edits changed
+func (h *handler) HandleItemHistory(w http.ResponseWriter, r *http.Request) {
+	m := &historyModel{Hash: HashFromString(chi.URLParam(r, "hash"))}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || !m.Content.IsValid() || m.Content.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	revs := h.storage.edits.List(m.Hash)
+	if len(revs) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("the item wasn't edited"))
+		return
+	}
+	m.Versions = itemVersions(m.Content, revs)
+	title := m.Content.Title
+	if len(title) == 0 {
+		title = fmt.Sprintf("comment %s", m.Content.Hash)
+	}
+	m.Title = fmt.Sprintf("Edits of %s", title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
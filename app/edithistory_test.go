@@ -0,0 +1,61 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestDiffLines(t *testing.T) {
+	old := "first\nsecond\nthird\nfourth"
+	edited := "first\n2nd\nthird\nfourth\nfifth"
+	var got []string
+	for _, l := range diffLines(old, edited) {
+		got = append(got, l.Op+l.Text)
+	}
+	want := []string{" first", "-second", "+2nd", " third", " fourth", "+fifth"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("diffLines() = %v, want %v", got, want)
+	}
+}
+
+func TestEditHistory(t *testing.T) {
+	e, _ := loadEditHistory("")
+	submitted := time.Now().Add(-time.Hour).UTC()
+	v1 := Item{Hash: testHash(1), Title: "Title", Data: "text", SubmittedAt: submitted}
+	v2 := v1
+	v2.Data = "edited text"
+	if err := e.Record(v1, v1, time.Now()); err != nil || e.Has(v1.Hash) {
+		t.Fatalf("expected the edits which don't change anything to be ignored")
+	}
+	if err := e.Record(v1, v2, time.Now()); err != nil {
+		t.Fatalf("unable to record the edit: %s", err)
+	}
+	revs := e.List(v1.Hash)
+	if len(revs) != 1 || revs[0].Data != "text" || !revs[0].At.Equal(submitted) {
+		t.Fatalf("expected the first version to be saved, got %v", revs)
+	}
+	versions := itemVersions(&v2, revs)
+	if len(versions) != 2 || !versions[0].Current || versions[0].Data != "edited text" || len(versions[0].Diff) != 2 {
+		t.Errorf("expected the current version first, with its diff, got %v", versions)
+	}
+}
+
+func TestCanEditItem(t *testing.T) {
+	c := config.Configuration{CommentEditWindow: 10 * time.Minute}
+	now := time.Now()
+	submission := &Item{SubmittedAt: now.Add(-time.Hour)}
+	comment := &Item{SubmittedAt: now.Add(-time.Hour), Parent: &Item{Hash: testHash(1)}}
+	if !canEditItem(c, submission, now) {
+		t.Errorf("expected the submissions to be editable without an edit window")
+	}
+	if canEditItem(c, comment, now) {
+		t.Errorf("expected the comment to be past its edit window")
+	}
+	comment.SubmittedAt = now.Add(-time.Minute)
+	if !canEditItem(c, comment, now) {
+		t.Errorf("expected the comment to be editable in its edit window")
+	}
+}
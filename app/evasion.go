@@ -0,0 +1,277 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const fingerprintsFile = "fingerprints.json"
+
+// localNetworks are the addresses of the reverse proxies we trust the X-Forwarded-For header from,
+// and which can't be used as a network fingerprint
+var localNetworks = func() []*net.IPNet {
+	nets := make([]*net.IPNet, 0)
+	for _, cidr := range []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"} {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}()
+
+func isLocalIP(ip net.IP) bool {
+	for _, n := range localNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestNetwork returns the network the request comes from: the /24 for IPv4 and the /48 for IPv6 addresses,
+// or an empty string if it's a local one
+func requestNetwork(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && isLocalIP(ip) {
+		// NOTE(marius): behind a reverse proxy the address of the client is the first one it added
+		fwd := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		ip = net.ParseIP(strings.TrimSpace(fwd[0]))
+	}
+	if ip == nil || isLocalIP(ip) {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// requestBrowser returns the headers identifying the browser of the request
+func requestBrowser(r *http.Request) string {
+	return strings.Join([]string{
+		r.UserAgent(),
+		r.Header.Get("Accept-Language"),
+		r.Header.Get("Accept-Encoding"),
+	}, "\n")
+}
+
+// fingerprint are the hashes of the network and of the browser an account used last
+type fingerprint struct {
+	Handle  string    `json:"handle"`
+	Network string    `json:"network,omitempty"`
+	Browser string    `json:"browser,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// evasionFlag is a new account whose fingerprint matches the ones of recently banned accounts
+type evasionFlag struct {
+	Hash    Hash      `json:"hash"`
+	Handle  string    `json:"handle"`
+	Matches []string  `json:"matches"`
+	Browser bool      `json:"browser,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// fingerprints keeps the hashed fingerprints of the accounts at registration and login, and the ones of the
+// accounts banned by the moderators, both only for the window. The hashes are salted with a random value
+// generated for the instance, so they can't be matched against other data.
+type fingerprints struct {
+	m        sync.RWMutex
+	path     string
+	enabled  bool
+	window   time.Duration
+	Salt     string               `json:"salt"`
+	Accounts map[Hash]fingerprint `json:"accounts"`
+	Banned   map[Hash]fingerprint `json:"banned"`
+	Flags    map[Hash]evasionFlag `json:"flags"`
+}
+
+func loadFingerprints(path string, enabled bool, window time.Duration) (*fingerprints, error) {
+	f := &fingerprints{
+		path:     path,
+		enabled:  enabled,
+		window:   window,
+		Accounts: make(map[Hash]fingerprint),
+		Banned:   make(map[Hash]fingerprint),
+		Flags:    make(map[Hash]evasionFlag),
+	}
+	if !enabled {
+		// NOTE(marius): when the checks get disabled we remove what was recorded before
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return f, err
+		}
+		return f, nil
+	}
+	if err := loadJSON(path, f); err != nil {
+		return f, err
+	}
+	if len(f.Salt) == 0 {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return f, err
+		}
+		f.Salt = hex.EncodeToString(salt)
+	}
+	return f, nil
+}
+
+// Enabled returns if the ban evasion checks are enabled
+func (f *fingerprints) Enabled() bool {
+	return f != nil && f.enabled
+}
+
+func (f *fingerprints) hash(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(f.Salt + s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *fingerprints) fromRequest(acc *Account, r *http.Request) fingerprint {
+	return fingerprint{
+		Handle:  acc.Handle,
+		Network: f.hash(requestNetwork(r)),
+		Browser: f.hash(requestBrowser(r)),
+		At:      time.Now().UTC(),
+	}
+}
+
+// prune removes the fingerprints older than the window, it needs to be called with the lock held
+func (f *fingerprints) prune() {
+	for h, fp := range f.Accounts {
+		if time.Since(fp.At) > f.window {
+			delete(f.Accounts, h)
+		}
+	}
+	for h, fp := range f.Banned {
+		if time.Since(fp.At) > f.window {
+			delete(f.Banned, h)
+		}
+	}
+}
+
+// Seen records the fingerprint of the request for acc, when logging in
+func (f *fingerprints) Seen(acc *Account, r *http.Request) error {
+	if !f.Enabled() || !acc.IsValid() {
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.prune()
+	f.Accounts[acc.Hash] = f.fromRequest(acc, r)
+	return f.save()
+}
+
+// Register records the fingerprint of the registration of acc, and flags it if its network matches the one
+// of an account banned recently. The browser alone is too common to be a reason for a flag.
+func (f *fingerprints) Register(acc *Account, r *http.Request) (*evasionFlag, error) {
+	if !f.Enabled() || !acc.IsValid() {
+		return nil, nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.prune()
+	fp := f.fromRequest(acc, r)
+	f.Accounts[acc.Hash] = fp
+
+	var flag *evasionFlag
+	for _, b := range f.Banned {
+		if len(fp.Network) == 0 || b.Network != fp.Network {
+			continue
+		}
+		if flag == nil {
+			flag = &evasionFlag{Hash: acc.Hash, Handle: acc.Handle, At: fp.At}
+		}
+		flag.Matches = append(flag.Matches, b.Handle)
+		flag.Browser = flag.Browser || b.Browser == fp.Browser
+	}
+	if flag != nil {
+		sort.Strings(flag.Matches)
+		f.Flags[acc.Hash] = *flag
+	}
+	return flag, f.save()
+}
+
+// Ban keeps the last fingerprint of the account banned by a moderator, for matching the new accounts against it
+func (f *fingerprints) Ban(acc Account) error {
+	if !f.Enabled() {
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	fp, ok := f.Accounts[acc.Hash]
+	if !ok {
+		return nil
+	}
+	fp.At = time.Now().UTC()
+	f.Banned[acc.Hash] = fp
+	return f.save()
+}
+
+// List returns the flagged accounts, the newest first
+func (f *fingerprints) List() []evasionFlag {
+	if !f.Enabled() {
+		return nil
+	}
+	f.m.RLock()
+	defer f.m.RUnlock()
+	list := make([]evasionFlag, 0, len(f.Flags))
+	for _, fl := range f.Flags {
+		list = append(list, fl)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].At.After(list[j].At)
+	})
+	return list
+}
+
+// Dismiss removes the flag of the account with hash h, after a moderator reviewed it
+func (f *fingerprints) Dismiss(h Hash) error {
+	if !f.Enabled() {
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if _, ok := f.Flags[h]; !ok {
+		return errors.NotFoundf("flag %s", h)
+	}
+	delete(f.Flags, h)
+	return f.save()
+}
+
+// save writes the fingerprints to disk, it needs to be called with the lock held
+func (f *fingerprints) save() error {
+	return saveJSON(f.path, f)
+}
+
+// HandleDismissEvasion handles the POST /moderation/queue/evasion/{hash} requests, which remove the flag
+// of a reviewed account
+func (h *handler) HandleDismissEvasion(w http.ResponseWriter, r *http.Request) {
+	hash := HashFromString(chi.URLParam(r, "hash"))
+	lCtx := log.Ctx{"hash": hash, "moderator": loggedAccount(r).Handle}
+	if err := h.storage.fingerprints.Dismiss(hash); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to dismiss the ban evasion flag")
+		h.v.addFlashMessage(Error, w, r, "Unable to dismiss the flag.")
+	} else {
+		h.infoFn(lCtx)("ban evasion flag dismissed")
+		h.v.addFlashMessage(Success, w, r, "The flag was dismissed.")
+	}
+	h.v.Redirect(w, r, "/moderation/queue", http.StatusSeeOther)
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestNetwork(t *testing.T) {
+	tests := []struct {
+		remote string
+		fwd    string
+		want   string
+	}{
+		{remote: "203.0.113.7:4321", want: "203.0.113.0"},
+		{remote: "127.0.0.1:4321", fwd: "198.51.100.20, 10.0.0.1", want: "198.51.100.0"},
+		{remote: "127.0.0.1:4321", want: ""},
+		{remote: "[2001:db8:1:2::5]:4321", want: "2001:db8:1::"},
+		{remote: "203.0.113.7:4321", fwd: "198.51.100.20", want: "203.0.113.0"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if len(tt.fwd) > 0 {
+			r.Header.Set("X-Forwarded-For", tt.fwd)
+		}
+		if got := requestNetwork(r); got != tt.want {
+			t.Errorf("requestNetwork(%s, %q): expected %q, got %q", tt.remote, tt.fwd, tt.want, got)
+		}
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	f, err := loadFingerprints("", true, time.Hour)
+	if err != nil {
+		t.Fatalf("unable to load the fingerprints: %s", err)
+	}
+	banned := Account{Handle: "banned", Hash: HashFromString("2f0fa7c6-5d4e-4f1a-9b3c-1d2e3f4a5b6c")}
+	evader := &Account{Handle: "evader", Hash: HashFromString("7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")}
+	other := &Account{Handle: "other", Hash: HashFromString("c0ffee00-1234-4abc-9def-0123456789ab")}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	if err := f.Seen(&banned, r); err != nil {
+		t.Fatalf("unable to save the fingerprint: %s", err)
+	}
+	if err := f.Ban(banned); err != nil {
+		t.Fatalf("unable to ban the account: %s", err)
+	}
+	r.RemoteAddr = "203.0.113.99:4321"
+	flag, err := f.Register(evader, r)
+	if err != nil || flag == nil || len(flag.Matches) != 1 || flag.Matches[0] != banned.Handle || !flag.Browser {
+		t.Errorf("the account from the network of the banned one wasn't flagged: %v %s", flag, err)
+	}
+	r.RemoteAddr = "198.51.100.20:4321"
+	if flag, _ := f.Register(other, r); flag != nil {
+		t.Errorf("the account from another network was flagged")
+	}
+	if l := f.List(); len(l) != 1 || l[0].Handle != evader.Handle {
+		t.Errorf("unexpected flags %v", l)
+	}
+	if err := f.Dismiss(evader.Hash); err != nil || len(f.List()) != 0 {
+		t.Errorf("unable to dismiss the flag: %s", err)
+	}
+}
+
+func TestFingerprintsDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-fingerprints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, fingerprintsFile)
+	if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFingerprints(path, false, time.Hour); err != nil {
+		t.Fatalf("unable to disable the fingerprints: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the fingerprints were kept after disabling the checks")
+	}
+}
@@ -0,0 +1,588 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// eventTimeLayout is the format of the datetime-local inputs of the submission form
+	eventTimeLayout = "2006-01-02T15:04"
+	// icsTimeLayout is the format of the UTC times in the iCalendar files
+	icsTimeLayout = "20060102T150405Z"
+	// maxUpcomingEvents is the number of events shown in the sidebar of the listings
+	maxUpcomingEvents = 5
+
+	RSVPAccept = "accept"
+	RSVPMaybe  = "maybe"
+	RSVPReject = "reject"
+)
+
+// EventMetadata are the details of the submissions which are events, they map to the properties
+// of the ActivityPub Event objects, the same as the ones of Mobilizon
+type EventMetadata struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitempty"`
+	Location string    `json:"location,omitempty"`
+}
+
+// Ends returns the end of the event, the events without one last until the end of their first day
+func (e EventMetadata) Ends() time.Time {
+	if !e.End.IsZero() {
+		return e.End
+	}
+	y, m, d := e.Start.Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, e.Start.Location())
+}
+
+// Past returns if the event ended
+func (e EventMetadata) Past() bool {
+	return time.Now().After(e.Ends())
+}
+
+// IsEvent returns if the item is an event
+func (i *Item) IsEvent() bool {
+	return i.HasMetadata() && i.Metadata.Event != nil && !i.Metadata.Event.Start.IsZero()
+}
+
+// eventFromRequest loads the event details from the submission form, it returns nil if they're missing
+func eventFromRequest(r *http.Request) (*EventMetadata, error) {
+	start := strings.TrimSpace(r.PostFormValue("event-start"))
+	if len(start) == 0 {
+		return nil, nil
+	}
+	e := EventMetadata{Location: strings.TrimSpace(r.PostFormValue("event-location"))}
+	var err error
+	if e.Start, err = time.ParseInLocation(eventTimeLayout, start, time.UTC); err != nil {
+		return nil, errors.BadRequestf("invalid start time for the event")
+	}
+	if end := strings.TrimSpace(r.PostFormValue("event-end")); len(end) > 0 {
+		if e.End, err = time.ParseInLocation(eventTimeLayout, end, time.UTC); err != nil {
+			return nil, errors.BadRequestf("invalid end time for the event")
+		}
+		if e.End.Before(e.Start) {
+			return nil, errors.BadRequestf("the event can't end before it starts")
+		}
+	}
+	return &e, nil
+}
+
+// eventFromObject loads the event details of the ActivityPub Event object
+func eventFromObject(o *pub.Object) *EventMetadata {
+	if o.StartTime.IsZero() {
+		return nil
+	}
+	e := EventMetadata{Start: o.StartTime.UTC(), End: o.EndTime.UTC()}
+	if o.Location != nil {
+		if o.Location.IsLink() {
+			e.Location = o.Location.GetLink().String()
+		} else {
+			pub.OnObject(o.Location, func(l *pub.Object) error {
+				e.Location = l.Name.First().Value.String()
+				return nil
+			})
+		}
+	}
+	return &e
+}
+
+// loadAPEvent sets the event details on the ActivityPub object
+func loadAPEvent(o *pub.Object, e *EventMetadata) {
+	o.Type = pub.EventType
+	o.StartTime = e.Start
+	o.EndTime = e.End
+	if len(e.Location) > 0 {
+		place := pub.ObjectNew(pub.PlaceType)
+		place.Name.Set(pub.NilLangRef, pub.Content(e.Location))
+		o.Location = place
+	}
+}
+
+// upcomingEvent is an event we know of, for the sidebar of the listings
+type upcomingEvent struct {
+	Hash     Hash
+	Title    string
+	Link     string
+	Board    string
+	Event    EventMetadata
+	IRI      string
+	AuthorID string
+}
+
+func upcomingEventFromItem(i *Item) upcomingEvent {
+	return upcomingEvent{
+		Hash:     i.Hash,
+		Title:    i.Title,
+		Link:     ItemPermaLink(i),
+		Board:    i.Metadata.Board,
+		Event:    *i.Metadata.Event,
+		IRI:      i.Metadata.ID,
+		AuthorID: i.Metadata.AuthorURI,
+	}
+}
+
+// rsvp is the answer of an account to an event, and the activity which federated it
+type rsvp struct {
+	Handle   string
+	Answer   string
+	Activity pub.IRI
+}
+
+// rsvpActivityTypes are the activities the answers to the events are federated as, the same as Mobilizon's
+var rsvpActivityTypes = pub.ActivityVocabularyTypes{pub.AcceptType, pub.TentativeAcceptType, pub.RejectType}
+
+// rsvpType returns the type of the activity for the answer
+func rsvpType(answer string) pub.ActivityVocabularyType {
+	switch answer {
+	case RSVPAccept:
+		return pub.AcceptType
+	case RSVPMaybe:
+		return pub.TentativeAcceptType
+	case RSVPReject:
+		return pub.RejectType
+	}
+	return ""
+}
+
+// rsvpFromActivity loads the answer from the Accept, TentativeAccept or Reject activity, and returns it together
+// with the IRIs of the event and of the account which answered
+func rsvpFromActivity(it pub.Item) (pub.IRI, pub.IRI, rsvp, error) {
+	var event, account pub.IRI
+	res := rsvp{}
+	err := pub.OnActivity(it, func(a *pub.Activity) error {
+		switch a.Type {
+		case pub.AcceptType:
+			res.Answer = RSVPAccept
+		case pub.TentativeAcceptType:
+			res.Answer = RSVPMaybe
+		case pub.RejectType:
+			res.Answer = RSVPReject
+		default:
+			return errors.Newf("invalid activity type %s for an answer to an event", a.Type)
+		}
+		if a.Object == nil || a.Actor == nil {
+			return errors.Newf("the answer to the event is missing its actor or object")
+		}
+		event = a.Object.GetLink()
+		account = a.Actor.GetLink()
+		res.Activity = a.GetLink()
+		// NOTE(marius): the activity's Name holds the handle of the account, so we don't need to load its actor
+		if res.Handle = a.Name.First().Value.String(); len(res.Handle) == 0 {
+			res.Handle = path.Base(account.String())
+		}
+		return nil
+	})
+	return event, account, res, err
+}
+
+// eventRSVPs are the numbers of answers for an event, and the one of the current account
+type eventRSVPs struct {
+	Going     int
+	Maybe     int
+	NotGoing  int
+	Answer    string
+	Attendees []string
+}
+
+// events keeps the upcoming events, and the answers to them, the way they're federated through fedbox
+type events struct {
+	m        sync.RWMutex
+	Upcoming map[Hash]upcomingEvent
+	RSVPs    map[Hash]map[string]rsvp
+}
+
+func newEvents() *events {
+	return &events{Upcoming: make(map[Hash]upcomingEvent), RSVPs: make(map[Hash]map[string]rsvp)}
+}
+
+// load replaces the events and the answers with the ones loaded from fedbox
+func (e *events) load(upcoming []upcomingEvent, rsvps map[Hash]map[string]rsvp) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Upcoming = make(map[Hash]upcomingEvent, len(upcoming))
+	for _, ev := range upcoming {
+		e.Upcoming[ev.Hash] = ev
+	}
+	e.RSVPs = rsvps
+}
+
+// Index keeps the event, if it didn't end yet, for the upcoming events
+func (e *events) Index(i *Item) {
+	if e == nil || !i.IsEvent() || i.Parent.IsValid() {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if i.Deleted() || i.Metadata.Event.Past() {
+		delete(e.Upcoming, i.Hash)
+		delete(e.RSVPs, i.Hash)
+		return
+	}
+	e.Upcoming[i.Hash] = upcomingEventFromItem(i)
+}
+
+// List returns the events which didn't end yet, the ones in the board if it's not empty, the soonest first
+func (e *events) List(board string, max int) []upcomingEvent {
+	if e == nil {
+		return nil
+	}
+	e.m.RLock()
+	defer e.m.RUnlock()
+	list := make([]upcomingEvent, 0)
+	for _, ev := range e.Upcoming {
+		if ev.Event.Past() || (len(board) > 0 && ev.Board != board) {
+			continue
+		}
+		list = append(list, ev)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Event.Start.Before(list[j].Event.Start)
+	})
+	if max > 0 && len(list) > max {
+		list = list[:max]
+	}
+	return list
+}
+
+// Get returns the answer of the account with the IRI to the event
+func (e *events) Get(event Hash, account string) (rsvp, bool) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	r, ok := e.RSVPs[event][account]
+	return r, ok
+}
+
+// Answers returns the answers to the event, and the one of the account with the IRI
+func (e *events) Answers(event Hash, account string) eventRSVPs {
+	res := eventRSVPs{}
+	if e == nil {
+		return res
+	}
+	e.m.RLock()
+	defer e.m.RUnlock()
+	for iri, r := range e.RSVPs[event] {
+		switch r.Answer {
+		case RSVPAccept:
+			res.Going++
+			res.Attendees = append(res.Attendees, r.Handle)
+		case RSVPMaybe:
+			res.Maybe++
+		default:
+			res.NotGoing++
+		}
+		if iri == account {
+			res.Answer = r.Answer
+		}
+	}
+	sort.Strings(res.Attendees)
+	return res
+}
+
+// Answer keeps the answer of the account with the IRI to the event, an empty one removes it
+func (e *events) Answer(event Hash, account string, r rsvp) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if len(r.Answer) == 0 {
+		delete(e.RSVPs[event], account)
+		if len(e.RSVPs[event]) == 0 {
+			delete(e.RSVPs, event)
+		}
+		return
+	}
+	if _, ok := e.RSVPs[event]; !ok {
+		e.RSVPs[event] = make(map[string]rsvp)
+	}
+	e.RSVPs[event][account] = r
+}
+
+// SaveRSVP federates the answer of acc to the event as an Accept, TentativeAccept or Reject activity, after
+// undoing the previous one
+func (r *repository) SaveRSVP(ctx context.Context, acc *Account, it Item, answer string) error {
+	if !accountValidForC2S(acc) || !acc.HasMetadata() {
+		return errors.Unauthorizedf("invalid account %s", acc.Handle)
+	}
+	if !it.IsEvent() || !it.HasMetadata() || len(it.Metadata.ID) == 0 {
+		return errors.BadRequestf("the item is not an event")
+	}
+	if it.Metadata.Event.Past() {
+		return errors.BadRequestf("the event already ended")
+	}
+	author := r.loadAPPerson(*acc)
+	to := pub.ItemCollection{}
+	if len(it.Metadata.AuthorURI) > 0 {
+		to = append(to, pub.IRI(it.Metadata.AuthorURI))
+	}
+	act := &pub.Activity{
+		To:    to,
+		BCC:   pub.ItemCollection{r.fedbox.Service().ID},
+		Actor: author.GetLink(),
+	}
+	prev, exists := r.events.Get(it.Hash, acc.Metadata.ID)
+	if exists && prev.Answer == answer {
+		return nil
+	}
+	if exists && len(prev.Activity) > 0 {
+		act.Type = pub.UndoType
+		act.Object = prev.Activity
+		if _, _, err := r.fedbox.ToOutbox(ctx, act); err != nil {
+			r.errFn(log.Ctx{"err": err, "iri": prev.Activity})("unable to undo the previous answer to the event")
+		}
+	}
+	res := rsvp{Handle: acc.Handle, Answer: answer}
+	if len(answer) > 0 {
+		act.Type = rsvpType(answer)
+		act.Object = pub.IRI(it.Metadata.ID)
+		act.Name = pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: pub.Content(acc.Handle)}}
+		iri, _, err := r.fedbox.ToOutbox(ctx, act)
+		if err != nil {
+			return err
+		}
+		res.Activity = iri
+	}
+	// NOTE(marius): the remote events get to the upcoming ones once a local account answers to them
+	r.events.Index(&it)
+	r.events.Answer(it.Hash, acc.Metadata.ID, res)
+	return nil
+}
+
+// loadEvents loads from fedbox the events which didn't end yet, and the answers to them which weren't undone
+func (r *repository) loadEvents(ctx context.Context) error {
+	upcoming := make(map[Hash]upcomingEvent)
+	objectsFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Objects(ctx, Values(f))
+	}
+	evs := &Filters{Type: ActivityTypesFilter(pub.EventType), MaxItems: MaxContentItems}
+	err := LoadFromCollection(ctx, objectsFn, &colCursor{filters: evs}, func(c pub.CollectionInterface) (bool, error) {
+		for _, ob := range c.Collection() {
+			it := Item{}
+			if err := it.FromActivityPub(ob); err != nil || !it.IsEvent() || it.Parent.IsValid() || it.Deleted() || it.Metadata.Event.Past() {
+				continue
+			}
+			upcoming[it.Hash] = upcomingEventFromItem(&it)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the events")
+	}
+
+	activitiesFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Activities(ctx, Values(f))
+	}
+	undone := make(pub.IRIs, 0)
+	undos := &Filters{
+		Type:     ActivityTypesFilter(pub.UndoType),
+		Object:   &Filters{Type: ActivityTypesFilter(rsvpActivityTypes...)},
+		MaxItems: MaxContentItems,
+	}
+	err = LoadFromCollection(ctx, activitiesFn, &colCursor{filters: undos}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			pub.OnActivity(it, func(a *pub.Activity) error {
+				if a.Object != nil {
+					undone = append(undone, a.Object.GetLink())
+				}
+				return nil
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the undone answers to the events")
+	}
+
+	rsvps := make(map[Hash]map[string]rsvp)
+	unknown := make(pub.IRIs, 0)
+	answers := &Filters{
+		Type:     ActivityTypesFilter(rsvpActivityTypes...),
+		Object:   &Filters{Type: ActivityTypesFilter(pub.EventType)},
+		MaxItems: MaxContentItems,
+	}
+	// NOTE(marius): the activities are ordered newest first, so the first answer of an account is its current one
+	err = LoadFromCollection(ctx, activitiesFn, &colCursor{filters: answers}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			event, account, res, err := rsvpFromActivity(it)
+			if err != nil || undone.Contains(res.Activity) {
+				continue
+			}
+			h := HashFromItem(event)
+			if _, ok := rsvps[h]; !ok {
+				rsvps[h] = make(map[string]rsvp)
+			}
+			if _, ok := rsvps[h][account.String()]; ok {
+				continue
+			}
+			rsvps[h][account.String()] = res
+			if _, ok := upcoming[h]; !ok && !unknown.Contains(event) {
+				unknown = append(unknown, event)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the answers to the events")
+	}
+	for _, iri := range unknown {
+		it, err := r.LoadItem(ctx, iri)
+		if err != nil || !it.IsEvent() || it.Parent.IsValid() || it.Deleted() || it.Metadata.Event.Past() {
+			delete(rsvps, HashFromItem(iri))
+			continue
+		}
+		upcoming[it.Hash] = upcomingEventFromItem(&it)
+	}
+	list := make([]upcomingEvent, 0, len(upcoming))
+	for _, ev := range upcoming {
+		list = append(list, ev)
+	}
+	r.events.load(list, rsvps)
+	r.infoFn(log.Ctx{"events": len(list), "answered": len(rsvps)})("loaded the upcoming events")
+	return nil
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsFold splits the lines longer than 75 octets, as the iCalendar format requires
+func icsFold(line string) string {
+	out := strings.Builder{}
+	// NOTE(marius): the continuation lines start with a space, which counts towards their length
+	for max := 75; len(line) > max; max = 74 {
+		cut := max
+		for cut > 0 && (line[cut]&0xC0) == 0x80 {
+			cut--
+		}
+		out.WriteString(line[:cut])
+		out.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	out.WriteString(line)
+	return out.String()
+}
+
+// eventICS returns the iCalendar file of the event, for adding it to the calendar applications
+func eventICS(i *Item, link string) string {
+	e := i.Metadata.Event
+	host := "littr"
+	if u, err := url.Parse(Instance.BaseURL); err == nil && len(u.Host) > 0 {
+		host = u.Host
+	}
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//littr//events//EN",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%s@%s", i.Hash, host),
+		fmt.Sprintf("DTSTAMP:%s", time.Now().UTC().Format(icsTimeLayout)),
+		fmt.Sprintf("DTSTART:%s", e.Start.UTC().Format(icsTimeLayout)),
+		fmt.Sprintf("DTEND:%s", e.Ends().UTC().Format(icsTimeLayout)),
+		fmt.Sprintf("SUMMARY:%s", icsEscape(i.Title)),
+		fmt.Sprintf("URL:%s", link),
+	}
+	if len(e.Location) > 0 {
+		lines = append(lines, fmt.Sprintf("LOCATION:%s", icsEscape(e.Location)))
+	}
+	if i.MimeType == MimeTypeMarkdown || i.MimeType == "text/plain" {
+		lines = append(lines, fmt.Sprintf("DESCRIPTION:%s", icsEscape(i.Data)))
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+	for k, l := range lines {
+		lines[k] = icsFold(l)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// absoluteItemLink returns the permalink of the item, including the host of the instance for the local ones
+func absoluteItemLink(i *Item) string {
+	link := ItemPermaLink(i)
+	if strings.HasPrefix(link, "/") {
+		link = Instance.BaseURL + link
+	}
+	return link
+}
+
+// googleCalendarLink returns the link for adding the event to a Google calendar
+func googleCalendarLink(i *Item, link string) string {
+	if !i.IsEvent() {
+		return ""
+	}
+	e := i.Metadata.Event
+	q := url.Values{}
+	q.Set("action", "TEMPLATE")
+	q.Set("text", i.Title)
+	q.Set("dates", fmt.Sprintf("%s/%s", e.Start.UTC().Format(icsTimeLayout), e.Ends().UTC().Format(icsTimeLayout)))
+	q.Set("details", link)
+	if len(e.Location) > 0 {
+		q.Set("location", e.Location)
+	}
+	return "https://calendar.google.com/calendar/render?" + q.Encode()
+}
+
+// contextEvent returns the event the {hash} of the URL points to
+func contextEvent(r *http.Request) (*Item, error) {
+	var it *Item
+	if c := ContextCursor(r.Context()); c != nil {
+		it = getItemFromList(HashFromString(chi.URLParam(r, "hash")), c.items)
+	}
+	if it == nil || it.Deleted() || !it.IsEvent() {
+		return nil, errors.NotFoundf("event")
+	}
+	return it, nil
+}
+
+// HandleEventICS serves the /{hash}/event.ics requests, with the iCalendar file of the event
+func (h *handler) HandleEventICS(w http.ResponseWriter, r *http.Request) {
+	it, err := contextEvent(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, it.Hash))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(eventICS(it, absoluteItemLink(it))))
+}
+
+// HandleRSVP handles the /{hash}/rsvp POST requests, with the answer of the logged account to the event
+func (h *handler) HandleRSVP(w http.ResponseWriter, r *http.Request) {
+	it, err := contextEvent(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	acc := loggedAccount(r)
+	answer := r.PostFormValue("answer")
+	if len(rsvpType(answer)) == 0 {
+		answer = ""
+	}
+	lCtx := log.Ctx{"event": it.Hash, "account": acc.Handle, "answer": answer}
+	if err := h.storage.SaveRSVP(r.Context(), acc, *it, answer); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the answer to the event")
+		h.v.addFlashMessage(Error, w, r, "Unable to save your answer to the event.")
+		h.v.Redirect(w, r, ItemPermaLink(it), http.StatusSeeOther)
+		return
+	}
+	h.infoFn(lCtx)("event answer saved")
+	switch answer {
+	case RSVPAccept:
+		h.v.addFlashMessage(Success, w, r, "See you there!")
+	case RSVPMaybe:
+		h.v.addFlashMessage(Success, w, r, "Your answer was saved, you might be going.")
+	case RSVPReject:
+		h.v.addFlashMessage(Success, w, r, "Your answer was saved, you're not going.")
+	default:
+		h.v.addFlashMessage(Success, w, r, "Your answer was removed.")
+	}
+	h.v.Redirect(w, r, ItemPermaLink(it), http.StatusSeeOther)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestEventFromRequest(t *testing.T) {
+	tests := map[string]struct {
+		form    url.Values
+		want    *EventMetadata
+		wantErr bool
+	}{
+		"not an event": {form: url.Values{"data": {"text"}}},
+		"start only": {
+			form: url.Values{"event-start": {"2030-05-01T18:30"}, "event-location": {" Bucharest "}},
+			want: &EventMetadata{Start: time.Date(2030, 5, 1, 18, 30, 0, 0, time.UTC), Location: "Bucharest"},
+		},
+		"start and end": {
+			form: url.Values{"event-start": {"2030-05-01T18:30"}, "event-end": {"2030-05-01T21:00"}},
+			want: &EventMetadata{Start: time.Date(2030, 5, 1, 18, 30, 0, 0, time.UTC), End: time.Date(2030, 5, 1, 21, 0, 0, 0, time.UTC)},
+		},
+		"invalid start":    {form: url.Values{"event-start": {"tomorrow"}}, wantErr: true},
+		"end before start": {form: url.Values{"event-start": {"2030-05-01T18:30"}, "event-end": {"2030-05-01T12:00"}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got, err := eventFromRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	e := newEvents()
+	event := func(hash, board string, start time.Time) *Item {
+		return &Item{
+			Hash:     HashFromString(hash),
+			Title:    "Meetup " + hash[:4],
+			Metadata: &ItemMetadata{Board: board, Event: &EventMetadata{Start: start}},
+		}
+	}
+	later := event("2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60", "golang", time.Now().Add(48*time.Hour))
+	soon := event("3c8d9a7f-1d2e-4e6f-9a0b-2c3d4e5f6a71", "", time.Now().Add(2*time.Hour))
+	past := event("4d9e0b8a-2e3f-4f7a-8b1c-3d4e5f6a7b82", "", time.Now().Add(-48*time.Hour))
+	for _, it := range []*Item{later, soon, past} {
+		e.Index(it)
+	}
+	e.Answer(later.Hash, "https://example.com/actors/jane", rsvp{Handle: "jane", Answer: RSVPAccept})
+	e.Answer(later.Hash, "https://example.com/actors/jim", rsvp{Handle: "jim", Answer: RSVPMaybe})
+	e.Answer(later.Hash, "https://example.com/actors/john", rsvp{Handle: "john", Answer: RSVPReject})
+
+	list := e.List("", 0)
+	if len(list) != 2 || list[0].Hash != soon.Hash || list[1].Hash != later.Hash {
+		t.Errorf("expected the upcoming events, the soonest first, got %v", list)
+	}
+	if list := e.List("golang", 0); len(list) != 1 || list[0].Hash != later.Hash {
+		t.Errorf("expected the events of the board, got %v", list)
+	}
+	a := e.Answers(later.Hash, "https://example.com/actors/john")
+	if a.Going != 1 || a.Maybe != 1 || a.NotGoing != 1 || a.Answer != RSVPReject || len(a.Attendees) != 1 || a.Attendees[0] != "jane" {
+		t.Errorf("unexpected answers %v", a)
+	}
+	e.Answer(later.Hash, "https://example.com/actors/john", rsvp{})
+	if a := e.Answers(later.Hash, "https://example.com/actors/john"); a.NotGoing != 0 || len(a.Answer) > 0 {
+		t.Errorf("the answer was not removed: %v", a)
+	}
+}
+
+func TestRSVPFromActivity(t *testing.T) {
+	for _, answer := range []string{RSVPAccept, RSVPMaybe, RSVPReject} {
+		act := &pub.Activity{
+			ID:     "https://example.com/activities/1",
+			Type:   rsvpType(answer),
+			Actor:  pub.IRI("https://example.com/actors/jane"),
+			Object: pub.IRI("https://example.com/objects/2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60"),
+		}
+		event, account, res, err := rsvpFromActivity(act)
+		if err != nil {
+			t.Fatalf("unable to load the answer: %s", err)
+		}
+		if event != act.Object.GetLink() || account != act.Actor.GetLink() {
+			t.Errorf("expected the event %s and the account %s, got %s %s", act.Object.GetLink(), act.Actor.GetLink(), event, account)
+		}
+		if res.Answer != answer || res.Activity != act.ID || res.Handle != "jane" {
+			t.Errorf("unexpected answer %v for the %s activity", res, act.Type)
+		}
+	}
+	follow := &pub.Activity{Type: pub.FollowType, Actor: pub.IRI("https://example.com/actors/jane"), Object: pub.IRI("https://example.com/objects/1")}
+	if _, _, _, err := rsvpFromActivity(follow); err == nil {
+		t.Errorf("expected the Follow activity to not be an answer to an event")
+	}
+}
+
+func TestEventICS(t *testing.T) {
+	it := &Item{
+		Hash:     HashFromString("2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60"),
+		Title:    "Go meetup; with talks, and pizza",
+		MimeType: MimeTypeMarkdown,
+		Data:     strings.Repeat("A long description of the meetup. ", 5),
+		Metadata: &ItemMetadata{Event: &EventMetadata{Start: time.Date(2030, 5, 1, 18, 30, 0, 0, time.UTC), Location: "Str. Lipscani 1, Bucharest"}},
+	}
+	ics := eventICS(it, "https://littr.example/item")
+	for _, want := range []string{
+		"DTSTART:20300501T183000Z\r\n",
+		"DTEND:20300501T235959Z\r\n",
+		"SUMMARY:Go meetup\\; with talks\\, and pizza\r\n",
+		"LOCATION:Str. Lipscani 1\\, Bucharest\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("expected %q in %q", want, ics)
+		}
+	}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("the line %q is longer than 75 octets", line)
+		}
+	}
+}
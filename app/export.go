@@ -0,0 +1,319 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	exportFormatEPUB  = "epub"
+	exportFormatPrint = "print"
+)
+
+// exportModel is a discussion, rendered as a standalone page for printing and archiving
+type exportModel struct {
+	Title      string
+	Hash       Hash
+	Content    *Item
+	URL        string
+	ExportedAt time.Time
+	// Print is set for the print version, which has the links listed as footnotes
+	Print bool
+}
+
+func (m *exportModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (exportModel) Template() string {
+	return "export"
+}
+
+func (m *exportModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleExport serves the /{hash}/export requests, with the whole discussion without the navigation and
+// the forms. With ?format=epub it's packaged as an EPUB ebook.
+func (h *handler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	h.export(w, r, r.URL.Query().Get("format"))
+}
+
+// HandlePrint serves the /{hash}/print requests, with the export of the discussion prepared for printing
+func (h *handler) HandlePrint(w http.ResponseWriter, r *http.Request) {
+	h.export(w, r, exportFormatPrint)
+}
+
+func (h *handler) export(w http.ResponseWriter, r *http.Request, format string) {
+	m := &exportModel{
+		Hash:       HashFromString(chi.URLParam(r, "hash")),
+		ExportedAt: time.Now().UTC(),
+		Print:      format == exportFormatPrint,
+	}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || !m.Content.IsValid() || m.Content.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	m.URL = ItemPermaLink(m.Content)
+	if strings.HasPrefix(m.URL, "/") {
+		m.URL = h.storage.SelfURL + m.URL
+	}
+	m.Title = m.Content.Title
+	if len(m.Title) == 0 {
+		m.Title = fmt.Sprintf("Discussion %s", m.Content.Hash)
+	}
+
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	opts := h.v.htmlOptions(w, r, m)
+	opts.Layout = ""
+	if err := h.v.renderHTML(buf, http.StatusOK, m.Template(), m, opts); err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": m.Hash})("unable to render the export")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to export the discussion"))
+		return
+	}
+
+	if m.Print {
+		out := getRenderBuffer()
+		defer putRenderBuffer(out)
+		if err := addLinkFootnotes(out, buf, m.URL); err != nil {
+			h.errFn(log.Ctx{"err": err, "hash": m.Hash})("unable to add the link footnotes")
+			h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to print the discussion"))
+			return
+		}
+		buf = out
+	}
+	if format != exportFormatEPUB {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		buf.WriteTo(w)
+		return
+	}
+	book := new(bytes.Buffer)
+	if err := writeEPUB(book, m, buf); err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": m.Hash})("unable to generate the EPUB")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to export the discussion"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.epub"`, exportFileName(m.Title)))
+	book.WriteTo(w)
+}
+
+// addLinkFootnotes numbers the links of the page, and lists their URLs at its end, so they're not lost on paper.
+// The relative links are resolved against base.
+func addLinkFootnotes(w io.Writer, page io.Reader, base string) error {
+	doc, err := xhtml.Parse(page)
+	if err != nil {
+		return err
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	links := make([]string, 0)
+	index := make(map[string]int)
+	var body, footer *xhtml.Node
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if n.Type == xhtml.ElementNode {
+			switch n.DataAtom {
+			case atom.Body:
+				body = n
+			case atom.Footer:
+				footer = n
+				return
+			case atom.A:
+				link := ""
+				for _, a := range n.Attr {
+					if a.Key == "href" {
+						if u, err := baseURL.Parse(a.Val); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+							link = u.String()
+						}
+					}
+				}
+				// NOTE(marius): the links showing their URL already don't need a footnote
+				if len(link) == 0 || nodeText(n, false) == link {
+					return
+				}
+				i, ok := index[link]
+				if !ok {
+					links = append(links, link)
+					i = len(links)
+					index[link] = i
+				}
+				sup := &xhtml.Node{Type: xhtml.ElementNode, Data: "sup", DataAtom: atom.Sup, Attr: []xhtml.Attribute{{Key: "class", Val: "fn"}}}
+				sup.AppendChild(&xhtml.Node{Type: xhtml.TextNode, Data: fmt.Sprintf("[%d]", i)})
+				n.Parent.InsertBefore(sup, n.NextSibling)
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			walk(c)
+			c = next
+		}
+	}
+	walk(doc)
+	if body == nil {
+		return errors.Errorf("the page doesn't have a body")
+	}
+	if len(links) > 0 {
+		el := func(a atom.Atom, children ...*xhtml.Node) *xhtml.Node {
+			n := &xhtml.Node{Type: xhtml.ElementNode, Data: a.String(), DataAtom: a}
+			for _, c := range children {
+				n.AppendChild(c)
+			}
+			return n
+		}
+		text := func(s string) *xhtml.Node { return &xhtml.Node{Type: xhtml.TextNode, Data: s} }
+		list := el(atom.Ol)
+		for _, l := range links {
+			list.AppendChild(el(atom.Li, text(l)))
+		}
+		sec := el(atom.Section, el(atom.H2, text("Links")), list)
+		sec.Attr = []xhtml.Attribute{{Key: "class", Val: "footnotes"}}
+		if footer != nil && footer.Parent == body {
+			body.InsertBefore(sec, footer)
+		} else {
+			body.AppendChild(sec)
+		}
+	}
+	return xhtml.Render(w, doc)
+}
+
+var exportFileNameRe = regexp.MustCompile(`[^\w-]+`)
+
+func exportFileName(title string) string {
+	name := strings.Trim(exportFileNameRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if runes := []rune(name); len(runes) > 64 {
+		name = string(runes[:64])
+	}
+	if len(name) == 0 {
+		name = "discussion"
+	}
+	return name
+}
+
+// xhtmlBody returns the children of the body element of the page, serialized so they're valid XHTML
+func xhtmlBody(page io.Reader) (string, error) {
+	doc, err := xhtml.Parse(page)
+	if err != nil {
+		return "", err
+	}
+	var body *xhtml.Node
+	var find func(*xhtml.Node)
+	find = func(n *xhtml.Node) {
+		if n.Type == xhtml.ElementNode && n.DataAtom == atom.Body {
+			body = n
+			return
+		}
+		for c := n.FirstChild; c != nil && body == nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	if body == nil {
+		return "", errors.Errorf("the page doesn't have a body")
+	}
+	b := new(strings.Builder)
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := xhtml.Render(b, c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+var epubTemplates = template.Must(template.New("container").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+{{- define "opf" }}<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">{{ .URL | html }}</dc:identifier>
+<dc:title>{{ .Title | html }}</dc:title>
+<dc:language>en</dc:language>
+{{- if .Content.SubmittedBy }}
+<dc:creator>{{ .Content.SubmittedBy.Handle | html }}</dc:creator>
+{{- end }}
+<meta property="dcterms:modified">{{ .ExportedAt.Format "2006-01-02T15:04:05Z" }}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="discussion" href="discussion.xhtml" media-type="application/xhtml+xml"/>
+</manifest>
+<spine><itemref idref="discussion"/></spine>
+</package>
+{{- end }}
+{{- define "nav" }}<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{ .Title | html }}</title></head>
+<body><nav epub:type="toc"><ol><li><a href="discussion.xhtml">{{ .Title | html }}</a></li></ol></nav></body>
+</html>
+{{- end }}
+{{- define "discussion" }}<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{ .Title | html }}</title></head>
+<body>{{ .Body }}</body>
+</html>
+{{- end }}`))
+
+// writeEPUB packages the rendered discussion page as an EPUB 3 book
+func writeEPUB(w io.Writer, m *exportModel, page io.Reader) error {
+	body, err := xhtmlBody(page)
+	if err != nil {
+		return errors.Annotatef(err, "unable to convert the page to XHTML")
+	}
+	z := zip.NewWriter(w)
+	// NOTE(marius): the mimetype file needs to be the first one in the archive, and not compressed
+	mt, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		tpl  string
+		data interface{}
+	}{
+		{"META-INF/container.xml", "container", m},
+		{"OEBPS/content.opf", "opf", m},
+		{"OEBPS/nav.xhtml", "nav", m},
+		{"OEBPS/discussion.xhtml", "discussion", struct {
+			Title string
+			Body  string
+		}{m.Title, body}},
+	}
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if err = epubTemplates.ExecuteTemplate(fw, f.tpl, f.data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
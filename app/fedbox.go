@@ -8,12 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/client"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/handlers"
-	"github.com/mariusor/go-littr/internal/log"
 )
 
 const (
@@ -23,12 +23,13 @@ const (
 )
 
 type fedbox struct {
-	baseURL       pub.IRI
-	skipTLSVerify bool
-	pub           *pub.Actor
-	client        *client.C
-	infoFn        CtxLogFn
-	errFn         CtxLogFn
+	baseURL pub.IRI
+	http    *http.Client
+	pub     *pub.Actor
+	client  *apClient
+	breaker *breaker
+	infoFn  CtxLogFn
+	errFn   CtxLogFn
 }
 
 type OptionFn func(*fedbox) error
@@ -125,20 +126,19 @@ func SetUA(s string) OptionFn {
 	}
 }
 
-func SkipTLSCheck(skip bool) OptionFn {
+// SetCircuitBreaker stops the requests to fedbox for the cool down interval after threshold consecutive failures
+func SetCircuitBreaker(threshold int, coolDown time.Duration) OptionFn {
 	return func(f *fedbox) error {
-		f.skipTLSVerify = skip
+		f.breaker = newBreaker(threshold, coolDown, f.errFn)
 		return nil
 	}
 }
 
-var optionLogFn = func(fn CtxLogFn) func(ctx ...client.Ctx) client.LogFn {
-	return func(ctx ...client.Ctx) client.LogFn {
-		c := make([]log.Ctx, 0)
-		for _, v := range ctx {
-			c = append(c, log.Ctx(v))
-		}
-		return client.LogFn(fn(c...))
+// SetHTTPClient sets the client the requests to fedbox are made with
+func SetHTTPClient(c *http.Client) OptionFn {
+	return func(f *fedbox) error {
+		f.http = c
+		return nil
 	}
 }
 
@@ -153,16 +153,17 @@ func NewClient(o ...OptionFn) (*fedbox, error) {
 		}
 	}
 
-	f.client = client.New(
-		client.SetErrorLogger(optionLogFn(f.errFn)),
-		client.SetInfoLogger(optionLogFn(f.infoFn)),
-		client.SkipTLSValidation(f.skipTLSVerify),
-	)
-	service, err := f.client.LoadIRI(f.baseURL)
+	f.client = newAPClient(f.http, f.infoFn, f.errFn)
+	return &f, f.loadService(context.Background())
+}
+
+// loadService loads the fedbox service actor
+func (f *fedbox) loadService(ctx context.Context) error {
+	service, err := f.client.CtxLoadIRI(ctx, f.baseURL)
 	if err != nil {
-		return &f, err
+		return err
 	}
-	return &f, pub.OnActor(service, func(a *pub.Actor) error {
+	return pub.OnActor(service, func(a *pub.Actor) error {
 		f.pub = a
 		return nil
 	})
@@ -183,7 +184,7 @@ func (f fedbox) normaliseIRI(i pub.IRI) pub.IRI {
 }
 
 func (f fedbox) collection(ctx context.Context, i pub.IRI) (pub.CollectionInterface, error) {
-	it, err := f.client.CtxLoadIRI(ctx, f.normaliseIRI(i))
+	it, err := f.object(ctx, i)
 	if err != nil {
 		return nil, errors.Annotatef(err, "Unable to load IRI: %s", i)
 	}
@@ -213,7 +214,21 @@ func (f fedbox) collection(ctx context.Context, i pub.IRI) (pub.CollectionInterf
 }
 
 func (f fedbox) object(ctx context.Context, i pub.IRI) (pub.Item, error) {
-	return f.client.CtxLoadIRI(ctx, f.normaliseIRI(i))
+	if err := f.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	it, err := f.client.CtxLoadIRI(ctx, f.normaliseIRI(i))
+	f.breaker.Done(ctx, err)
+	return it, err
+}
+
+func (f fedbox) toCollection(ctx context.Context, i pub.IRI, a pub.Item) (pub.IRI, pub.Item, error) {
+	if err := f.breaker.Allow(); err != nil {
+		return "", nil, err
+	}
+	iri, it, err := f.client.CtxToCollection(ctx, f.normaliseIRI(i), a)
+	f.breaker.Done(ctx, err)
+	return iri, it, err
 }
 
 func rawFilterQuery(f ...client.FilterFn) string {
@@ -421,7 +436,7 @@ func (f fedbox) ToOutbox(ctx context.Context, a pub.Item) (pub.IRI, pub.Item, er
 	if err := validateIRIForRequest(iri); err != nil {
 		return "", nil, errors.Annotatef(err, "Invalid Outbox IRI")
 	}
-	return f.client.CtxToCollection(ctx, f.normaliseIRI(iri), a)
+	return f.toCollection(ctx, iri, a)
 }
 
 func (f fedbox) ToInbox(ctx context.Context, a pub.Item) (pub.IRI, pub.Item, error) {
@@ -433,7 +448,7 @@ func (f fedbox) ToInbox(ctx context.Context, a pub.Item) (pub.IRI, pub.Item, err
 	if err := validateIRIForRequest(iri); err != nil {
 		return "", nil, errors.Annotatef(err, "Invalid Inbox IRI")
 	}
-	return f.client.CtxToCollection(ctx, f.normaliseIRI(iri), a)
+	return f.toCollection(ctx, iri, a)
 }
 
 func (f *fedbox) Service() *pub.Service {
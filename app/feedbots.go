@@ -0,0 +1,512 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/oauth2"
+)
+
+const (
+	feedBotsFile = "feed-bots.json"
+
+	feedBotMinInterval   = 5 * time.Minute
+	feedBotMaxBackoff    = 24 * time.Hour
+	feedBotCheckInterval = time.Minute
+	feedBotLoadTimeOut   = 30 * time.Second
+	feedBotMaxSize       = 5 << 20
+	// feedBotMaxPerPoll is the number of entries we submit at most from a feed every time we load it,
+	// so a feed which was unavailable for a while doesn't flood the listings
+	feedBotMaxPerPoll = 5
+	// feedBotMaxSeen is the number of entry IDs we remember for every feed
+	feedBotMaxSeen = 500
+)
+
+// feedBot is a feed whose new entries get submitted by a bot account, together with the state of its polling
+type feedBot struct {
+	URL string `json:"url"`
+	// Account is the IRI of the bot account, Token is its OAuth2 token which we refresh when it expires
+	Account  string        `json:"account"`
+	Handle   string        `json:"handle"`
+	Tag      string        `json:"tag,omitempty"`
+	Interval time.Duration `json:"interval"`
+	Token    *oauth2.Token `json:"token,omitempty"`
+
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	LastFetch    time.Time `json:"lastFetch,omitempty"`
+	NextFetch    time.Time `json:"nextFetch,omitempty"`
+	LastSubmit   time.Time `json:"lastSubmit,omitempty"`
+	Submitted    int       `json:"submitted,omitempty"`
+	Failures     int       `json:"failures,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+	Seen         []string  `json:"seen,omitempty"`
+}
+
+// failed schedules the next load of the feed, waiting twice as long after every consecutive failure
+func (b *feedBot) failed(err error) {
+	b.Failures++
+	b.LastError = err.Error()
+	wait := b.Interval
+	for i := 1; i < b.Failures && wait < feedBotMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > feedBotMaxBackoff {
+		wait = feedBotMaxBackoff
+	}
+	b.NextFetch = time.Now().Add(wait)
+}
+
+func (b *feedBot) succeeded() {
+	b.Failures = 0
+	b.LastError = ""
+	b.NextFetch = time.Now().Add(b.Interval)
+}
+
+func (b feedBot) seen(id string) bool {
+	for _, s := range b.Seen {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *feedBot) markSeen(id string) {
+	b.Seen = append(b.Seen, id)
+	if len(b.Seen) > feedBotMaxSeen {
+		b.Seen = b.Seen[len(b.Seen)-feedBotMaxSeen:]
+	}
+}
+
+// feedBots keeps the feeds the admins registered, saved to disk in the data directory
+type feedBots struct {
+	m        sync.RWMutex
+	path     string
+	interval time.Duration
+	bots     map[string]feedBot
+}
+
+func loadFeedBots(path string, interval time.Duration) (*feedBots, error) {
+	if interval < feedBotMinInterval {
+		interval = feedBotMinInterval
+	}
+	f := &feedBots{path: path, interval: interval, bots: make(map[string]feedBot)}
+	return f, loadJSON(path, &f.bots)
+}
+
+// List returns the feeds, ordered by their URL
+func (f *feedBots) List() []feedBot {
+	if f == nil {
+		return nil
+	}
+	f.m.RLock()
+	defer f.m.RUnlock()
+	list := make([]feedBot, 0, len(f.bots))
+	for _, b := range f.bots {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].URL < list[j].URL
+	})
+	return list
+}
+
+// due returns the feeds which need to be loaded again
+func (f *feedBots) due() []feedBot {
+	list := make([]feedBot, 0)
+	for _, b := range f.List() {
+		if time.Now().After(b.NextFetch) {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+// Add registers a new feed, or changes the account and the tag of an existing one
+func (f *feedBots) Add(b feedBot) error {
+	if f == nil {
+		return errors.Errorf("feed bots are not available")
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if b.Interval < feedBotMinInterval {
+		b.Interval = f.interval
+	}
+	if old, ok := f.bots[b.URL]; ok {
+		b.ETag, b.LastModified, b.Seen = old.ETag, old.LastModified, old.Seen
+		b.LastFetch, b.LastSubmit, b.Submitted = old.LastFetch, old.LastSubmit, old.Submitted
+	}
+	f.bots[b.URL] = b
+	return f.save()
+}
+
+// Remove stops loading the feed at u
+func (f *feedBots) Remove(u string) error {
+	if f == nil {
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.bots, u)
+	return f.save()
+}
+
+// update saves the polling state of the feed, unless it was removed in the mean time
+func (f *feedBots) update(b feedBot) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if _, ok := f.bots[b.URL]; !ok {
+		return nil
+	}
+	f.bots[b.URL] = b
+	return f.save()
+}
+
+// save writes the feeds to disk, it needs to be called with the lock held
+func (f *feedBots) save() error {
+	return saveJSON(f.path, f.bots)
+}
+
+// feedEntry is an entry of an RSS or Atom feed
+type feedEntry struct {
+	ID        string
+	Title     string
+	Link      string
+	Published time.Time
+}
+
+type rssItem struct {
+	GUID    string   `xml:"guid"`
+	Title   string   `xml:"title"`
+	Link    []string `xml:"link"`
+	PubDate string   `xml:"pubDate"`
+	Date    string   `xml:"date"`
+}
+
+type atomInEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Link      []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+// feedDocument matches RSS 2.0, RSS 1.0 and Atom documents
+type feedDocument struct {
+	XMLName  xml.Name
+	Items    []rssItem     `xml:"channel>item"`
+	RDFItems []rssItem     `xml:"item"`
+	Entries  []atomInEntry `xml:"entry"`
+}
+
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseFeed returns the entries of the feed which have a title and an http(s) link, the oldest first
+func parseFeed(r io.Reader) ([]feedEntry, error) {
+	doc := feedDocument{}
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = charset.NewReaderLabel
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse the feed")
+	}
+	entries := make([]feedEntry, 0)
+	for _, it := range append(doc.Items, doc.RDFItems...) {
+		e := feedEntry{ID: it.GUID, Title: it.Title, Published: parseFeedTime(it.PubDate)}
+		// NOTE(marius): some RSS feeds have atom:link elements in their items too, which only have attributes
+		for _, l := range it.Link {
+			if l = strings.TrimSpace(l); len(l) > 0 {
+				e.Link = l
+				break
+			}
+		}
+		if e.Published.IsZero() {
+			e.Published = parseFeedTime(it.Date)
+		}
+		entries = append(entries, e)
+	}
+	for _, it := range doc.Entries {
+		e := feedEntry{ID: it.ID, Title: it.Title, Published: parseFeedTime(it.Published)}
+		if e.Published.IsZero() {
+			e.Published = parseFeedTime(it.Updated)
+		}
+		for _, l := range it.Link {
+			if l.Rel == "" || l.Rel == "alternate" {
+				e.Link = l.Href
+				break
+			}
+		}
+		entries = append(entries, e)
+	}
+	valid := make([]feedEntry, 0, len(entries))
+	for _, e := range entries {
+		e.Title = strings.Join(strings.Fields(e.Title), " ")
+		e.Link = strings.TrimSpace(e.Link)
+		if u, err := url.Parse(e.Link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(e.Title) == 0 {
+			continue
+		}
+		if len(e.ID) == 0 {
+			e.ID = e.Link
+		}
+		valid = append(valid, e)
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].Published.Before(valid[j].Published)
+	})
+	return valid, nil
+}
+
+// loadFeed loads the feed of the bot, it returns no entries if the feed didn't change since the last time
+func loadFeed(ctx context.Context, b *feedBot) ([]feedEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8")
+	if len(b.ETag) > 0 {
+		req.Header.Set("If-None-Match", b.ETag)
+	}
+	if len(b.LastModified) > 0 {
+		req.Header.Set("If-Modified-Since", b.LastModified)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("the feed returned %s", res.Status)
+	}
+	entries, err := parseFeed(io.LimitReader(res.Body, feedBotMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	b.ETag = res.Header.Get("ETag")
+	b.LastModified = res.Header.Get("Last-Modified")
+	return entries, nil
+}
+
+// feedBotAccount loads the bot account of the feed, with a valid OAuth2 token for submitting
+func (r *repository) feedBotAccount(ctx context.Context, b *feedBot) (*Account, error) {
+	if b.Token == nil {
+		return nil, errors.Unauthorizedf("the bot account doesn't have a token")
+	}
+	config := GetOauth2Config(oauthClientProvider, r.SelfURL)
+	tok, err := config.TokenSource(ctx, b.Token).Token()
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to refresh the token of the bot account")
+	}
+	b.Token = tok
+	acc, err := r.LoadAccount(ctx, pub.IRI(b.Account))
+	if err != nil {
+		return nil, err
+	}
+	acc.Metadata.OAuth.Provider = oauthClientProvider
+	acc.Metadata.OAuth.Token = tok
+	return acc, nil
+}
+
+// urlSubmitted returns if the page at u was already submitted, by the bot or by somebody else
+func (r *repository) urlSubmitted(ctx context.Context, u string) bool {
+	items, err := r.objects(ctx, &Filters{
+		Type:     CompStrs{EqualsString(string(pub.PageType))},
+		URL:      badgeURLVariants(u),
+		MaxItems: 1,
+	})
+	return err == nil && len(items) > 0
+}
+
+// pollFeedBot loads the feed, and submits its new entries
+func (r *repository) pollFeedBot(b feedBot) feedBot {
+	ctx, cancel := context.WithTimeout(context.Background(), feedBotLoadTimeOut)
+	defer cancel()
+
+	lCtx := log.Ctx{"feed": b.URL, "handle": b.Handle}
+	b.LastFetch = time.Now()
+	entries, err := loadFeed(ctx, &b)
+	if err != nil {
+		r.errFn(lCtx, log.Ctx{"err": err, "failures": b.Failures + 1})("unable to load the feed")
+		b.failed(err)
+		return b
+	}
+	fresh := make([]feedEntry, 0)
+	for _, e := range entries {
+		if !b.seen(e.ID) {
+			fresh = append(fresh, e)
+		}
+	}
+	if len(fresh) > feedBotMaxPerPoll {
+		for _, e := range fresh[:len(fresh)-feedBotMaxPerPoll] {
+			b.markSeen(e.ID)
+		}
+		fresh = fresh[len(fresh)-feedBotMaxPerPoll:]
+	}
+	if len(fresh) == 0 {
+		b.succeeded()
+		return b
+	}
+	acc, err := r.feedBotAccount(ctx, &b)
+	if err != nil {
+		r.errFn(lCtx, log.Ctx{"err": err})("unable to load the bot account")
+		b.failed(err)
+		return b
+	}
+	for _, e := range fresh {
+		if r.urlSubmitted(ctx, e.Link) {
+			b.markSeen(e.ID)
+			continue
+		}
+		it := Item{
+			Title:       r.titles.Normalize(e.Title),
+			Data:        e.Link,
+			MimeType:    MimeTypeURL,
+			SubmittedBy: acc,
+			SubmittedAt: time.Now().UTC(),
+			Metadata:    &ItemMetadata{},
+		}
+		if len(b.Tag) > 0 {
+			it.Metadata.Tags, _ = loadTags("#" + b.Tag)
+		}
+		if _, err := r.WithAccount(acc).SaveItem(ctx, it); err != nil {
+			r.errFn(lCtx, log.Ctx{"err": err, "url": e.Link})("unable to submit the feed entry")
+			b.failed(err)
+			return b
+		}
+		r.posting.Record(acc.Hash)
+		b.markSeen(e.ID)
+		b.Submitted++
+		b.LastSubmit = time.Now()
+		r.infoFn(lCtx, log.Ctx{"url": e.Link})("submitted feed entry")
+	}
+	b.succeeded()
+	return b
+}
+
+// runFeedBots loads the feeds when they're due, and submits their new entries
+func (r *repository) runFeedBots() {
+	if r.bots == nil {
+		return
+	}
+	t := time.NewTicker(feedBotCheckInterval)
+	defer t.Stop()
+	for range t.C {
+		for _, b := range r.bots.due() {
+			if err := r.bots.update(r.pollFeedBot(b)); err != nil {
+				r.errFn(log.Ctx{"err": err, "feed": b.URL})("unable to save the feed state")
+			}
+		}
+	}
+}
+
+type feedBotsModel struct {
+	Title string
+	Bots  []feedBot
+	// Interval is the default interval between the loads of a feed
+	Interval time.Duration
+}
+
+func (m *feedBotsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (feedBotsModel) Template() string {
+	return "feedbots"
+}
+
+func (*feedBotsModel) SetCursor(c *Cursor) {}
+
+// HandleFeedBots serves the /admin/feeds requests, with the feeds and the state of their polling
+func (h *handler) HandleFeedBots(w http.ResponseWriter, r *http.Request) {
+	m := &feedBotsModel{Title: "Feed bots", Bots: h.storage.bots.List(), Interval: h.storage.bots.interval}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleAddFeedBot handles POST /admin/feeds requests, which register a feed for a bot account
+// We only keep the OAuth2 token of the account, not its password.
+func (h *handler) HandleAddFeedBot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	back := "/admin/feeds"
+	fail := func(msg string, err error) {
+		h.errFn(log.Ctx{"err": err})("unable to add the feed bot")
+		h.v.addFlashMessage(Error, w, r, msg)
+		h.v.Redirect(w, r, back, http.StatusSeeOther)
+	}
+	u, err := url.Parse(strings.TrimSpace(r.PostFormValue("url")))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		fail("The feed needs an absolute http(s) URL", err)
+		return
+	}
+	b := feedBot{
+		URL:    u.String(),
+		Handle: strings.TrimLeft(strings.TrimSpace(r.PostFormValue("handle")), "~@"),
+		Tag:    strings.TrimLeft(strings.TrimSpace(r.PostFormValue("tag")), "#"),
+	}
+	if minutes, err := time.ParseDuration(r.PostFormValue("interval") + "m"); err == nil {
+		b.Interval = minutes
+	}
+	accts, err := h.storage.accounts(ctx, &Filters{
+		Name: CompStrs{EqualsString(b.Handle)},
+		Type: ActivityTypesFilter(ValidActorTypes...),
+	})
+	if err != nil || len(accts) == 0 {
+		fail(fmt.Sprintf("Unable to find the account %s", b.Handle), err)
+		return
+	}
+	acc := accts[0]
+	config := GetOauth2Config(oauthClientProvider, h.conf.BaseURL)
+	tok, err := config.PasswordCredentialsToken(ctx, acc.Metadata.ID, r.PostFormValue("pw"))
+	if err != nil || tok == nil {
+		fail(fmt.Sprintf("Unable to authenticate as %s", b.Handle), err)
+		return
+	}
+	b.Account = acc.Metadata.ID
+	b.Token = tok
+	if !acc.IsBot() {
+		if _, err := h.storage.SetAccountBot(ctx, acc, true); err != nil {
+			fail(fmt.Sprintf("Unable to mark %s as a bot", b.Handle), err)
+			return
+		}
+	}
+	if err := h.storage.bots.Add(b); err != nil {
+		fail("Unable to save the feed", err)
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The new entries of %s will be submitted by %s.", b.URL, b.Handle))
+	h.v.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// HandleRemoveFeedBot handles POST /admin/feeds/rm requests
+func (h *handler) HandleRemoveFeedBot(w http.ResponseWriter, r *http.Request) {
+	u := r.PostFormValue("url")
+	if err := h.storage.bots.Remove(u); err != nil {
+		h.errFn(log.Ctx{"err": err, "feed": u})("unable to remove the feed bot")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to remove the feed"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%s was removed.", u))
+	h.v.Redirect(w, r, "/admin/feeds", http.StatusSeeOther)
+}
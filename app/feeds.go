@@ -0,0 +1,306 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	feedTokensFile = "feed-tokens.json"
+	feedTokenSize  = 24
+	feedMaxItems   = 50
+
+	feedReplies  = "replies"
+	feedMentions = "mentions"
+)
+
+// feedTokens keeps the tokens of the private feeds of the local accounts, every account has at most one,
+// and creating a new one revokes the previous
+type feedTokens struct {
+	m      sync.RWMutex
+	path   string
+	tokens map[string]string
+}
+
+func loadFeedTokens(path string) (*feedTokens, error) {
+	t := &feedTokens{path: path, tokens: make(map[string]string)}
+	return t, loadJSON(path, &t.tokens)
+}
+
+// Token returns the feed token of the account with id, if it has one
+func (t *feedTokens) Token(id string) string {
+	if t == nil {
+		return ""
+	}
+	t.m.RLock()
+	defer t.m.RUnlock()
+	for tok, acc := range t.tokens {
+		if acc == id {
+			return tok
+		}
+	}
+	return ""
+}
+
+// Account returns the ID of the account the token belongs to
+func (t *feedTokens) Account(token string) (string, bool) {
+	if t == nil || len(token) == 0 {
+		return "", false
+	}
+	t.m.RLock()
+	defer t.m.RUnlock()
+	id, ok := t.tokens[token]
+	return id, ok
+}
+
+// Create generates a new token for the account with id, the old one stops working
+func (t *feedTokens) Create(id string) (string, error) {
+	if t == nil {
+		return "", errors.Errorf("feeds are not available")
+	}
+	buf := make([]byte, feedTokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	tok := hex.EncodeToString(buf)
+	return tok, t.save(id, tok)
+}
+
+// Revoke removes the token of the account with id
+func (t *feedTokens) Revoke(id string) error {
+	return t.save(id, "")
+}
+
+func (t *feedTokens) save(id, token string) error {
+	if t == nil {
+		return nil
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for tok, acc := range t.tokens {
+		if acc == id {
+			delete(t.tokens, tok)
+		}
+	}
+	if len(token) > 0 {
+		t.tokens[token] = id
+	}
+	return saveJSON(t.path, t.tokens)
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Link      []atomLink  `xml:"link"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Content   *atomText   `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Entry   []atomEntry `xml:"entry"`
+}
+
+// atomContent returns the HTML of the item, the way we show it on its page
+func atomContent(i Item) *atomText {
+	switch i.MimeType {
+	case "text/html":
+		return &atomText{Type: "html", Body: replaceTags(i.MimeType, i)}
+	case "text/markdown":
+		return &atomText{Type: "html", Body: string(Markdown(replaceTags(i.MimeType, i)))}
+	case MimeTypeURL:
+		return &atomText{Type: "html", Body: fmt.Sprintf(`<a href="%s">%s</a>`, xmlEscape(i.Data), xmlEscape(i.Data))}
+	}
+	return &atomText{Type: "text", Body: i.Data}
+}
+
+func xmlEscape(s string) string {
+	b := strings.Builder{}
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func atomEntryFromItem(baseURL string, i Item) atomEntry {
+	link := ItemPermaLink(&i)
+	if len(link) > 0 && link[0] == '/' {
+		link = baseURL + link
+	}
+	e := atomEntry{
+		ID:        link,
+		Title:     i.Title,
+		Updated:   i.UpdatedAt.UTC().Format(time.RFC3339),
+		Published: i.SubmittedAt.UTC().Format(time.RFC3339),
+		Link:      []atomLink{{Href: link, Rel: "alternate", Type: "text/html"}},
+		Content:   atomContent(i),
+	}
+	if i.HasMetadata() && len(i.Metadata.ID) > 0 {
+		e.ID = i.Metadata.ID
+	}
+	if i.UpdatedAt.IsZero() {
+		e.Updated = e.Published
+	}
+	if i.SubmittedBy != nil {
+		e.Author = &atomPerson{Name: displayName(i.SubmittedBy), URI: AccountPermaLink(i.SubmittedBy)}
+		if len(e.Author.URI) > 0 && e.Author.URI[0] == '/' {
+			e.Author.URI = baseURL + e.Author.URI
+		}
+	}
+	if len(e.Title) == 0 {
+		who := "somebody"
+		if i.SubmittedBy != nil {
+			who = displayName(i.SubmittedBy)
+		}
+		e.Title = fmt.Sprintf("Comment by %s", who)
+	}
+	return e
+}
+
+// loadFeedItems loads the replies to the recent items of the account, or the items mentioning it
+func (r *repository) loadFeedItems(ctx context.Context, acc *Account, kind string) (ItemCollection, error) {
+	f := new(Filters)
+	f.Type = CreateActivitiesFilter
+	f.MaxItems = feedMaxItems
+	f.Object = new(Filters)
+	f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+	switch kind {
+	case feedMentions:
+		f.Recipients = CompStrs{EqualsString(acc.Metadata.ID)}
+	case feedReplies:
+		own, err := r.LoadActorOutbox(ctx, acc.pub, &Filters{Type: CreateActivitiesFilter, MaxItems: feedMaxItems})
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range own.items.Items() {
+			if iri := itemIRI(&it); len(iri) > 0 {
+				f.Object.InReplTo = append(f.Object.InReplTo, EqualsString(iri.String()))
+			}
+		}
+		if len(f.Object.InReplTo) == 0 {
+			return ItemCollection{}, nil
+		}
+	default:
+		return nil, errors.NotFoundf("%s feed", kind)
+	}
+	c, err := r.LoadActorInbox(ctx, r.fedbox.Service(), f)
+	if err != nil {
+		return nil, err
+	}
+	items := make(ItemCollection, 0)
+	for _, it := range c.items.Items() {
+		if it.Deleted() || it.Private() || (it.SubmittedBy != nil && it.SubmittedBy.Hash == acc.Hash) {
+			continue
+		}
+		items = append(items, it)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].SubmittedAt.After(items[j].SubmittedAt)
+	})
+	return items, nil
+}
+
+// HandleFeed serves the /feed/{token}/{kind} requests, with the Atom feeds of the replies to an account,
+// or of its mentions. They don't need a session, the token in the URL authorizes them.
+func (h *handler) HandleFeed(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.storage.feeds.Account(chi.URLParam(r, "token"))
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("feed"))
+		return
+	}
+	kind := chi.URLParam(r, "kind")
+	acc, err := h.storage.LoadAccount(r.Context(), pub.IRI(id))
+	if err != nil || !acc.IsValid() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("feed"))
+		return
+	}
+	items, err := h.storage.loadFeedItems(r.Context(), acc, kind)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle, "feed": kind})("unable to load feed")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	base := h.storage.SelfURL
+	feed := atomFeed{
+		ID:      fmt.Sprintf("%s%s/%s", base, AccountLocalLink(acc), kind),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    []atomLink{{Href: base + AccountLocalLink(acc), Rel: "alternate", Type: "text/html"}},
+		Entry:   make([]atomEntry, 0, len(items)),
+	}
+	if kind == feedMentions {
+		feed.Title = fmt.Sprintf("Mentions of %s", displayName(acc))
+	} else {
+		feed.Title = fmt.Sprintf("Replies to %s", displayName(acc))
+	}
+	for _, it := range items {
+		feed.Entry = append(feed.Entry, atomEntryFromItem(base, it))
+	}
+	if len(items) > 0 {
+		feed.Updated = feed.Entry[0].Updated
+	}
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// HandleFeedToken handles POST /~handle/settings/feeds requests, which create or revoke the feed token
+func (h *handler) HandleFeedToken(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	id := accountIRI(acc).String()
+	var err error
+	if r.PostFormValue("action") == "revoke" {
+		if err = h.storage.feeds.Revoke(id); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Your feed links were revoked.")
+		}
+	} else {
+		if _, err = h.storage.feeds.Create(id); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Your new feed links were created, the old ones don't work anymore.")
+		}
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the feed token")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save feed links"))
+		return
+	}
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
@@ -49,9 +51,7 @@ func FiltersFromRequest(r *http.Request) *Filters {
 	if err := qstring.Unmarshal(r.URL.Query(), f); err != nil {
 		return nil
 	}
-	if f.MaxItems <= 0 {
-		f.MaxItems = MaxContentItems
-	}
+	f.MaxItems = clampItems(f.MaxItems)
 	return f
 }
 
@@ -113,6 +113,52 @@ func FollowedFiltersMw(next http.Handler) http.Handler {
 	})
 }
 
+// HomeFiltersMw loads the filters for the logged account's personalized feed:
+// the submissions tagged with the tags it follows, the submissions of the accounts it follows, and the ones
+// posted in the boards it subscribed to.
+func HomeFiltersMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc := loggedAccount(r)
+		allFilters := make([]*Filters, 0)
+		if len(acc.Tags) > 0 {
+			ft := FiltersFromRequest(r)
+			ft.Type = CreateActivitiesFilter
+			ft.Object = new(Filters)
+			ft.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+			ft.Object.Tag = new(Filters)
+			for _, t := range acc.Tags {
+				ft.Object.Tag.Name = append(ft.Object.Tag.Name, EqualsString(t.Name))
+			}
+			allFilters = append(allFilters, ft)
+		}
+		if len(acc.Following) > 0 {
+			fa := FiltersFromRequest(r)
+			fa.Type = CreateActivitiesFilter
+			fa.Actor = new(Filters)
+			for _, a := range acc.Following {
+				if a.HasMetadata() && len(a.Metadata.ID) > 0 {
+					fa.Actor.IRI = append(fa.Actor.IRI, EqualsString(a.Metadata.ID))
+				}
+			}
+			fa.Object = new(Filters)
+			fa.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+			fa.Object.OP = nilIRIs
+			if len(fa.Actor.IRI) > 0 {
+				allFilters = append(allFilters, fa)
+			}
+		}
+		if repo := ContextRepository(r.Context()); repo != nil {
+			if names := repo.boards.Subscribed(acc.Hash); len(names) > 0 {
+				allFilters = append(allFilters, boardFilters(r, names...))
+			}
+		}
+		m := ContextListingModel(r.Context())
+		m.Title = "Your home feed"
+		ctx := context.WithValue(r.Context(), FilterCtxtKey, allFilters)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func DifferentThanString(s string) CompStr {
 	return CompStr{Operator: "!", Str: s}
 }
@@ -132,9 +178,16 @@ func FederatedFiltersMw(id pub.IRI) func (next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			f := fedFilters(r)
 			f.Actor.IRI = CompStrs{DifferentThanString(id.String())}
+			f.Object.Type = ActivityTypesFilter(FederatedContentTypes...)
+			f.Recipients = CompStrs{EqualsString(pub.PublicNS.String())}
 			m := ContextListingModel(r.Context())
 			m.Title = "Federated items"
-			ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{f})
+			ff := []*Filters{f}
+			if rf := ContextRepository(r.Context()).relayFilters(*f); rf != nil {
+				ff = append(ff, rf)
+			}
+			ff = append(ff, groupFilters(*f))
+			ctx := context.WithValue(r.Context(), FilterCtxtKey, ff)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -197,12 +250,82 @@ func TagFiltersMw(next http.Handler) http.Handler {
 
 		m := ContextListingModel(r.Context())
 		m.ShowText = true
+		m.Tag = tag
 		m.Title = fmt.Sprintf("Items tagged as #%s", tag)
 		ctx := context.WithValue(r.Context(), FilterCtxtKey, allFilters)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// ItemKindFiltersMw loads the items tagged with the hashtag of an item kind, like the classifieds or the jobs
+func ItemKindFiltersMw(tag, title string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f := new(Filters)
+			f.Type = CreateActivitiesFilter
+			f.Object = new(Filters)
+			f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+			f.Object.Tag = tagsFilter(tag)
+
+			m := ContextListingModel(r.Context())
+			m.ShowText = true
+			m.Title = title
+			ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{f})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// KeepItemsMw removes from the listing the items for which keep returns false, like the expired
+// classifieds, or the items only tagged as one, without its details
+func KeepItemsMw(keep func(*Item) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer next.ServeHTTP(w, r)
+
+			c := ContextCursor(r.Context())
+			if c == nil {
+				return
+			}
+			items := make(RenderableList)
+			for h, ren := range c.items {
+				if it, ok := ren.(*Item); !ok || !keep(it) {
+					continue
+				}
+				items[h] = ren
+			}
+			c.items = items
+			c.total = uint(len(items))
+		})
+	}
+}
+
+// shortHashLength is the length of the hash prefixes used by the short links
+const shortHashLength = 8
+
+var shortHashRe = regexp.MustCompile(`^[0-9a-f]{8}$`)
+
+// ShortLinkFiltersMw loads the items whose hash starts with the prefix from the /s/{short} URL
+func ShortLinkFiltersMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		short := strings.ToLower(chi.URLParam(r, "short"))
+		if !shortHashRe.MatchString(short) {
+			ctxtErr(next, w, r, errors.NotFoundf("%q item", short))
+			return
+		}
+		f := new(Filters)
+		f.Type = CreateActivitiesFilter
+		f.Object = new(Filters)
+		f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+		f.Object.IRI = CompStrs{LikeString(short)}
+
+		m := ContextListingModel(r.Context())
+		m.Title = fmt.Sprintf("Items starting with %s", short)
+		ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{f})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (h handler) ItemFiltersMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		f := FiltersFromRequest(r)
@@ -355,7 +478,7 @@ func ModerationListing(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		ctx := context.TODO()
+		ctx := r.Context()
 		followups, _ := s.loadModerationFollowups(ctx, c.items)
 		c.items = aggregateModeration(c.items, followups)
 
@@ -375,7 +498,7 @@ func LoadInvitedMw(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		ctx := context.TODO()
+		ctx := r.Context()
 		a, err := s.LoadAccount(ctx, actors.IRI(s.fedbox.Service()).AddPath(hash))
 		if err != nil {
 			ctxtErr(next, w, r, err)
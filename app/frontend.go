@@ -2,13 +2,16 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/go-chi/chi"
 	"github.com/gorilla/csrf"
@@ -31,17 +34,22 @@ type handler struct {
 	logger  log.Logger
 	infoFn  CtxLogFn
 	errFn   CtxLogFn
+	csrfKey []byte
+	// ready is set after we loaded the application actor from fedbox
+	ready int32
 }
 
 var defaultAccount = AnonymousAccount
 
 type appConfig struct {
 	config.Configuration
-	BaseURL         string
-	SessionKeys     [][]byte
-	SessionsBackend string
-	SessionsPath    string
-	Logger          log.Logger
+	BaseURL          string
+	SessionKeys      [][]byte
+	SessionsBackend  string
+	SessionsPath     string
+	SessionsFileMode os.FileMode
+	SessionsGC       time.Duration
+	Logger           log.Logger
 }
 
 var defaultLogFn = func(string, ...interface{}) {}
@@ -81,65 +89,46 @@ func Init(c appConfig) (*handler, error) {
 	if c.SessionsPath = os.Getenv("SESSIONS_PATH"); c.SessionsPath == "" {
 		c.SessionsPath = os.TempDir()
 	}
+	c.SessionsFileMode = defaultSessionsFileMode
+	if mode, err := strconv.ParseUint(os.Getenv("SESSIONS_FILE_MODE"), 8, 32); err == nil && mode&0600 == 0600 {
+		c.SessionsFileMode = os.FileMode(mode)
+	}
+	c.SessionsGC = time.Hour
+	if gc, err := time.ParseDuration(os.Getenv("SESSIONS_GC_INTERVAL")); err == nil {
+		c.SessionsGC = gc
+	}
 	c.SessionsBackend = strings.ToLower(c.SessionsBackend)
 	c.SessionKeys = loadEnvSessionKeys()
+	if len(c.SessionKeys) > 0 {
+		h.csrfKey = c.SessionKeys[0]
+	} else {
+		if c.Env.IsProd() {
+			return nil, errMissingSessionKeys
+		}
+		// NOTE(marius): outside production we can live with the CSRF tokens becoming invalid on restart
+		h.errFn()("SESS_AUTH_KEY is not set, using a random CSRF key")
+		h.csrfKey = make([]byte, 32)
+		if _, err := rand.Read(h.csrfKey); err != nil {
+			return nil, errors.Annotatef(err, "unable to generate CSRF key")
+		}
+	}
 	h.conf = c
 
 	h.storage, err = ActivityPubService(c)
 	if err != nil {
-		h.conf.UserCreatingEnabled = false
-		h.errFn()("Failed to load actor: %s", err)
-	} else {
-		provider := "fedbox"
-		config := GetOauth2Config(provider, h.conf.BaseURL)
-		ctx := log.Ctx{
-			"provider":    provider,
-			"client":      config.ClientID,
-			"pw":          hideString(config.ClientSecret),
-			"authURL":     config.Endpoint.AuthURL,
-			"tokURL":      config.Endpoint.TokenURL,
-			"redirectURL": config.RedirectURL,
-		}
-		if len(config.ClientID) > 0 {
-			oauth, err := h.storage.fedbox.Actor(context.TODO(), actors.IRI(h.storage.BaseURL()).AddPath(config.ClientID))
-			if err != nil {
-				h.conf.UserCreatingEnabled = false
-				h.errFn(log.Ctx{"err": err}, ctx)("Failed to authenticate client")
-			}
-			if oauth != nil {
-				h.storage.app = new(Account)
-				h.storage.app.FromActivityPub(oauth)
-
-				handle := oauth.ID.String()
-				ctx["handle"] = handle
-				tok, err := config.PasswordCredentialsToken(context.TODO(), handle, config.ClientSecret)
-				if err != nil {
-					h.conf.UserCreatingEnabled = false
-					h.errFn(log.Ctx{"err": err}, ctx)("Failed to authenticate client")
-				} else {
-					if tok == nil {
-						h.conf.UserCreatingEnabled = false
-						h.errFn(ctx)("Failed to load a valid OAuth2 token for client")
-					}
-					h.storage.app.Metadata.OAuth.Provider = provider
-					h.storage.app.Metadata.OAuth.Token = tok
-					h.infoFn(ctx, log.Ctx{
-						"token":   hideString(tok.AccessToken),
-						"type":    tok.TokenType,
-						"refresh": hideString(tok.RefreshToken),
-					})("Loaded valid OAuth2 token for client")
-
-				}
-			}
-		} else {
-			h.conf.UserCreatingEnabled = false
-			h.errFn(log.Ctx{"conf": config})("Failed to load OAuth2 ClientID")
-		}
+		h.errFn(log.Ctx{"err": err})("Failed to load the ActivityPub service, retrying in the background")
 	}
+	// NOTE(marius): fedbox might not be available yet, so we keep trying to load what we need from it
+	// and the features depending on it become available as soon as it is
+	go h.startBackend()
+
 	h.v, err = ViewInit(h.conf, h.infoFn, h.errFn)
 	if err != nil {
 		h.errFn(log.Ctx{"err": err})("Error initializing view")
 	}
+	if h.v != nil {
+		go h.v.s.runJanitor(c.SessionsGC)
+	}
 	return h, err
 }
 
@@ -196,7 +185,58 @@ func (h *handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	if err := h.v.saveAccountToSession(w, r, account); err != nil {
 		h.errFn()("Unable to save account to session")
 	}
-	h.v.Redirect(w, r, "/", http.StatusFound)
+	h.v.Redirect(w, r, h.v.loadReturnTo(w, r), http.StatusFound)
+}
+
+// oauth2RevocationURL returns the token revocation endpoint (RFC 7009) of the provider, if it has one
+func oauth2RevocationURL(provider string) string {
+	switch strings.ToLower(provider) {
+	case "google":
+		return "https://oauth2.googleapis.com/revoke"
+	case "gitlab":
+		return "https://gitlab.com/oauth/revoke"
+	case "github", "facebook":
+		return ""
+	}
+	if revURL := os.Getenv("OAUTH2_REVOKE_URL"); len(revURL) > 0 {
+		return revURL
+	}
+	return fmt.Sprintf("%s/oauth/revoke", strings.TrimRight(os.Getenv("API_URL"), "/"))
+}
+
+// revokeOAuth2Token asks the provider to invalidate the refresh and access tokens
+func revokeOAuth2Token(ctx context.Context, provider string, tok *oauth2.Token) error {
+	revURL := oauth2RevocationURL(provider)
+	if tok == nil || len(revURL) == 0 {
+		return nil
+	}
+	conf := GetOauth2Config(provider, "")
+	tokens := [][2]string{
+		{"refresh_token", tok.RefreshToken},
+		{"access_token", tok.AccessToken},
+	}
+	for _, t := range tokens {
+		hint, val := t[0], t[1]
+		if len(val) == 0 {
+			continue
+		}
+		form := url.Values{"token": {val}, "token_type_hint": {hint}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, revURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return errors.Errorf("unable to revoke the %s: %s", hint, resp.Status)
+		}
+	}
+	return nil
 }
 
 func GetOauth2Config(provider string, localBaseURL string) oauth2.Config {
@@ -258,14 +298,18 @@ func GetOauth2Config(provider string, localBaseURL string) oauth2.Config {
 	return config
 }
 
-func isInverted(r *http.Request) bool {
-	cookies := r.Cookies()
-	for _, c := range cookies {
-		if c.Name == "inverted" {
-			return true
+// pageTheme returns the colour theme of the page, from the settings of the logged account, or from the cookie
+// set by the links in the footer. It's empty when the page follows the colours of the system.
+func pageTheme(r *http.Request) string {
+	if acc := loggedAccount(r); acc.IsLogged() {
+		if t := acc.Settings().Theme; len(t) > 0 && validTheme(t) {
+			return t
 		}
 	}
-	return false
+	if c, err := r.Cookie(themeCookie); err == nil && validTheme(c.Value) {
+		return c.Value
+	}
+	return themeAuto
 }
 
 func (v *view) saveAccountToSession(w http.ResponseWriter, r *http.Request, a Account) error {
@@ -276,7 +320,11 @@ func (v *view) saveAccountToSession(w http.ResponseWriter, r *http.Request, a Ac
 	if err != nil {
 		return err
 	}
-	s.Values[SessionUserKey] = a
+	sa := sessionAccountFrom(a)
+	if old, ok := s.Values[SessionUserKey].(sessionAccount); ok && old.Hash == sa.Hash && !old.LoggedAt.IsZero() {
+		sa.LoggedAt = old.LoggedAt
+	}
+	s.Values[SessionUserKey] = sa
 	return nil
 }
 
@@ -295,8 +343,14 @@ func (v *view) loadCurrentAccountFromSession(w http.ResponseWriter, r *http.Requ
 	raw, ok := s.Values[SessionUserKey]
 	if !ok {
 		v.errFn(log.Ctx{"sess": s.Values})("no account data saved to session")
-	} else if acc, ok = raw.(Account); !ok {
+	} else if sa, ok := raw.(sessionAccount); !ok || sa.Version != sessionAccountVersion {
 		v.errFn(log.Ctx{"sess": s.Values})("invalid account in session")
+		delete(s.Values, SessionUserKey)
+	} else if v.s.revoked.IsRevoked(sa) {
+		v.infoFn(log.Ctx{"handle": sa.Handle})("the account logged out of all sessions")
+		delete(s.Values, SessionUserKey)
+	} else {
+		acc = sa.Account()
 	}
 	lCtx := log.Ctx{
 		"handle": acc.Handle,
@@ -346,6 +400,11 @@ func loadAccountData(a *Account, b Account) {
 	}
 	if a.Metadata == nil && b.Metadata != nil {
 		a.Metadata = b.Metadata
+	} else if a.Metadata != nil && b.Metadata != nil && len(a.Metadata.InboxIRI) == 0 {
+		// NOTE(marius): the session only keeps the ID and the OAuth2 credentials of the account
+		m := *b.Metadata
+		m.OAuth = a.Metadata.OAuth
+		a.Metadata = &m
 	}
 	if a.pub == nil && b.pub != nil {
 		a.pub = b.pub
@@ -401,7 +460,7 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 				f.Name = CompStrs{EqualsString(acc.Handle)}
 				f.Type = ActivityTypesFilter(ValidActorTypes...)
 			}
-			ctx := context.TODO()
+			ctx := r.Context()
 			if account, err := h.storage.account(ctx, f); err != nil {
 				h.errFn(ltx, log.Ctx{"err": err.Error(), "filters": f})("unable to load actor for session account")
 			} else {
@@ -409,29 +468,37 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 			}
 
 			h.storage.WithAccount(&acc)
-			if time.Now().Sub(acc.Metadata.OutboxUpdated) > 5*time.Minute {
+			if acc.HasMetadata() && acc.pub != nil {
+				pub.OnActor(acc.pub, func(p *pub.Actor) error {
+					acc.Metadata.Settings = h.storage.loadAccountSettings(ctx, p.GetLink(), p.Attachment)
+					return nil
+				})
+			}
+			if acc.HasMetadata() && !h.storage.snaps.Load(&acc) {
+				// NOTE(marius): the collections saved in the session can be outdated, so we load all of them again
+				acc.Votes, acc.Followers, acc.Following, acc.Blocked, acc.Ignored = nil, nil, nil, nil, nil
+				acc.Metadata.Outbox = nil
+				complete := true
 				if err := h.storage.loadAccountsOutbox(ctx, &acc); err != nil {
 					h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's Outbox")
+					complete = false
 				}
 				h.infoFn(ltx, log.Ctx{"updated": acc.Metadata.OutboxUpdated.Format(time.StampMilli)})("Loaded account's outbox")
 				acc.Metadata.OutboxUpdated = time.Now()
-			}
-			if len(acc.Votes) == 0 {
-				var items ItemCollection
-				if cursor := ContextCursor(r.Context()); cursor != nil {
-					items = cursor.items.Items()
+				if err := h.storage.loadAccountVotes(ctx, &acc, nil); err != nil {
+					h.infoFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's votes")
+					complete = false
 				}
-				h.storage.loadAccountVotes(ctx, &acc, items)
-			}
-			if len(acc.Followers) == 0 {
-				// TODO(marius): this needs to be moved to where we're handling all Inbox activities, not on page load
 				if err := h.storage.loadAccountsFollowers(ctx, &acc); err != nil {
 					h.infoFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's followers")
+					complete = false
 				}
-			}
-			if len(acc.Following) == 0 {
 				if err := h.storage.loadAccountsFollowing(ctx, &acc); err != nil {
 					h.infoFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's following")
+					complete = false
+				}
+				if complete && acc.pub != nil {
+					h.storage.snaps.Store(acc)
 				}
 			}
 		}
@@ -459,22 +526,6 @@ func (h handler) NeedsSessions(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// HandleAbout serves /about request
-// It's something Mastodon compatible servers should show
-func (h *handler) HandleAbout(w http.ResponseWriter, r *http.Request) {
-	m := &aboutModel{Title: "About"}
-
-	repo := h.storage
-	info, err := repo.LoadInfo()
-	if err != nil {
-		h.v.HandleErrors(w, r, errors.NewNotValid(err, "oops!"))
-		return
-	}
-	m.Desc.Description = info.Description
-
-	h.v.RenderTemplate(r, w, m.Template(), m)
-}
-
 func httpErrorResponse(e error) int {
 	if errors.IsBadRequest(e) {
 		return http.StatusBadRequest
@@ -506,17 +557,37 @@ func httpErrorResponse(e error) int {
 	return http.StatusInternalServerError
 }
 
+// errMissingSessionKeys is returned when starting in production without SESS_AUTH_KEY
+var errMissingSessionKeys = errors.Newf("SESS_AUTH_KEY is not set, refusing to start without a session authentication key")
+
+// loadEnvSessionKeys returns the pairs of authentication and encryption keys for the sessions
+// SESS_AUTH_KEY and SESS_ENC_KEY can contain multiple comma separated keys, the first pair is used for
+// saving the sessions and the rest only for reading the existing ones, so we can rotate the keys
+// without logging out everyone.
 func loadEnvSessionKeys() [][]byte {
 	keys := make([][]byte, 0)
-	if authKey := os.Getenv("SESS_AUTH_KEY"); len(authKey) >= 16 {
-		keys = append(keys, []byte(authKey[:16]))
-	}
-	if encKey := os.Getenv("SESS_ENC_KEY"); len(encKey) >= 16 {
-		keys = append(keys, []byte(encKey[:16]))
+	encKeys := strings.Split(os.Getenv("SESS_ENC_KEY"), ",")
+	for i, authKey := range strings.Split(os.Getenv("SESS_AUTH_KEY"), ",") {
+		authKey = strings.TrimSpace(authKey)
+		if len(authKey) < 16 {
+			continue
+		}
+		var encKey []byte
+		if i < len(encKeys) {
+			if k := strings.TrimSpace(encKeys[i]); len(k) >= 16 {
+				encKey = []byte(k[:16])
+			}
+		}
+		keys = append(keys, []byte(authKey[:16]), encKey)
 	}
 	return keys
 }
 
+// sessionsEncrypted returns if we have an encryption key for saving the sessions
+func sessionsEncrypted(keys [][]byte) bool {
+	return len(keys) >= 2 && len(keys[1]) > 0
+}
+
 func (h *handler) ErrorHandler(errs ...error) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		h.v.HandleErrors(w, r, errs...)
@@ -531,15 +602,5 @@ func (h handler) CSRF(next http.Handler) http.Handler {
 		csrf.Secure(h.conf.Env.IsProd()),
 		csrf.ErrorHandler(h.ErrorHandler(errors.Forbiddenf("Invalid request token"))),
 	}
-	var authKey []byte
-	if len(h.conf.SessionKeys) > 0 {
-		authKey = h.conf.SessionKeys[0]
-	} else {
-		if h.conf.Env.IsProd() {
-			h.errFn()("Invalid CSRF auth key")
-		}
-		// TODO(marius): WTF is this?
-		authKey = []byte{0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1}
-	}
-	return csrf.Protect(authKey, opts...)(next)
+	return csrf.Protect(h.csrfKey, opts...)(next)
 }
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+// groupAnnouncedTypes are the activity types that FEP-1b12 groups (eg, Lemmy communities)
+// wrap in an Announce when distributing them to their followers
+var groupAnnouncedTypes = pub.ActivityVocabularyTypes{
+	pub.CreateType,
+	pub.UpdateType,
+	pub.DeleteType,
+	pub.UndoType,
+	pub.LikeType,
+	pub.DislikeType,
+}
+
+// unwrapGroupAnnounce returns the activity a group announced, or the received one if it's not the case.
+// Unlike the relays and the regular boosts, which announce objects, groups announce activities.
+func unwrapGroupAnnounce(a *pub.Activity) *pub.Activity {
+	if a == nil || a.Type != pub.AnnounceType || a.Object == nil || !a.Object.IsObject() {
+		return a
+	}
+	if !groupAnnouncedTypes.Contains(a.Object.GetType()) {
+		return a
+	}
+	inner, err := pub.ToActivity(a.Object)
+	if err != nil || inner == nil {
+		return a
+	}
+	return inner
+}
+
+// groupFilters returns the filters for loading the objects created in the groups we follow,
+// which arrive wrapped in Announce activities
+func groupFilters(f Filters) *Filters {
+	ob := Filters{}
+	if f.Object != nil {
+		ob = *f.Object
+	}
+	f.Type = ActivityTypesFilter(pub.AnnounceType)
+	f.Object = &Filters{
+		Type:   CreateActivitiesFilter,
+		Object: &ob,
+	}
+	return &f
+}
+
+// groupAudience returns the groups the item was posted to, using the FEP-1b12 audience property
+func groupAudience(it pub.Item) pub.ItemCollection {
+	aud := make(pub.ItemCollection, 0)
+	if it == nil {
+		return aud
+	}
+	pub.OnObject(it, func(o *pub.Object) error {
+		for _, a := range o.Audience {
+			if a != nil && !a.GetLink().Equals(pub.PublicNS, false) {
+				aud = append(aud, a.GetLink())
+			}
+		}
+		return nil
+	})
+	return aud
+}
+
+// conversationAudience returns the groups the item replied to belongs to, so the reply
+// can be addressed to them and they distribute it to the rest of their followers
+func (r *repository) conversationAudience(ctx context.Context, parent *Item) pub.ItemCollection {
+	if parent == nil {
+		return nil
+	}
+	p := parent.pub
+	if p == nil || p.IsLink() {
+		if !parent.HasMetadata() || len(parent.Metadata.ID) == 0 {
+			return nil
+		}
+		ob, err := r.loadObject(ctx, pub.IRI(parent.Metadata.ID))
+		if err != nil {
+			return nil
+		}
+		p = ob
+	}
+	return groupAudience(p)
+}
@@ -1,11 +1,11 @@
 package app
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"path"
 	"strings"
@@ -26,12 +26,14 @@ import (
 // HandleSubmit handles POST /year/month/day/hash/edit requests
 func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 
 	var (
 		n   Item
 		err error
 		saveVote = true
+		// old is the version of the item before the edit
+		old Item
 	)
 
 	c := ContextCursor(r.Context())
@@ -39,13 +41,55 @@ func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 		if hash := HashFromString(r.FormValue("hash")); hash.IsValid() {
 			n = *getItemFromList(hash, c.items)
 			saveVote = false
+			old = n
 		}
 	}
+	if old.Hash.IsValid() && !canEditItem(h.conf.Configuration, &old, time.Now()) {
+		h.v.addFlashMessage(Error, w, r, "The time for editing it has passed.")
+		h.v.Redirect(w, r, ItemPermaLink(&old), http.StatusSeeOther)
+		return
+	}
 	if err = updateItemFromRequest(r, *acc, &n); err != nil {
 		h.errFn(log.Ctx{ "err": err.Error() })("Error: wrong http method")
 		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
 		return
 	}
+	if !n.Parent.IsValid() {
+		ev, err := eventFromRequest(r)
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if ev != nil {
+			n.Metadata.Event = ev
+		}
+		cl, err := classifiedFromRequest(r, h.conf.ClassifiedsExpiryDays)
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if cl != nil {
+			if n.IsClassified() {
+				cl.ExpiresAt = n.Metadata.Classified.ExpiresAt
+			}
+			n.Metadata.Classified = cl
+		}
+		job, err := jobFromRequest(r, h.conf.JobsExpiryDays)
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if job != nil {
+			if n.IsJob() {
+				job.ExpiresAt = n.Metadata.Job.ExpiresAt
+			}
+			n.Metadata.Job = job
+		}
+		if n.IsClassified() && n.IsJob() {
+			h.v.HandleErrors(w, r, errors.BadRequestf("a submission can't be both a classified and a job posting"))
+			return
+		}
+	}
 	if c!= nil && len(c.items) > 0 && n.Parent.IsValid() {
 		if parent := getItemFromList(n.Parent.Hash, c.items); parent.IsValid() {
 			n.Parent = parent
@@ -68,11 +112,86 @@ func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	repo := h.storage
+	if n.Parent == nil && len(n.Title) > 0 {
+		n.Title = repo.titles.Normalize(n.Title)
+		n.Title = normalizeTitlePrefix(n.Title, h.conf.AskPrefixes, h.conf.ShowPrefixes)
+	}
+	if n.HasMetadata() && len(n.Metadata.Board) > 0 {
+		b, ok := repo.boards.Get(n.Metadata.Board)
+		if !ok {
+			h.v.HandleErrors(w, r, errors.NotFoundf("board %s", n.Metadata.Board))
+			return
+		}
+		if !n.Hash.IsValid() && unchangedTemplate(b, n.Data) {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Please fill in the template of %s before submitting.", b.Title))
+			h.v.Redirect(w, r, fmt.Sprintf("/submit?board=%s", b.Name), http.StatusSeeOther)
+			return
+		}
+	}
+	if !acc.IsBot() && repo.posting.Automated(acc.Hash) {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("You're posting like an automated account, please mark it as a bot in your settings to continue"))
+		return
+	}
+	newComment, thread, board := n.Parent.IsValid() && !n.Hash.IsValid(), threadHash(&n), itemBoard(&n)
+	if newComment && archivedItem(n.Parent) {
+		h.v.addFlashMessage(Error, w, r, "This submission expired, it can't be commented on anymore.")
+		h.v.Redirect(w, r, ItemPermaLink(n.Parent), http.StatusSeeOther)
+		return
+	}
+	if err := checkBoardRestrictions(&h.conf.Configuration, repo.boards, acc, &n); err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if newComment && !isModerator(&h.conf.Configuration, acc) {
+		if wait := repo.slow.Wait(thread, acc.Hash); wait > 0 {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("This thread is in slow mode, you can comment again in %s.", fmtWait(wait)))
+			h.v.Redirect(w, r, ItemPermaLink(n.Parent), http.StatusSeeOther)
+			return
+		}
+	}
+	holdJob := false
+	if n.IsJob() && !n.Hash.IsValid() {
+		if ok, msg := h.canPostJob(ctx, &h.conf.Configuration, acc); !ok {
+			if !h.conf.JobsApproval {
+				h.v.addFlashMessage(Error, w, r, msg)
+				h.v.Redirect(w, r, "/submit", http.StatusSeeOther)
+				return
+			}
+			holdJob = true
+		}
+	}
+	if !n.Hash.IsValid() && !n.Private() && (holdJob || repo.held.Holds(acc) && !isModerator(&h.conf.Configuration, acc)) {
+		if err = repo.held.Add(n, acc); err != nil {
+			h.errFn(log.Ctx{"err": err, "author": acc.Handle})("unable to hold the item for approval")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		back := "/"
+		if n.Parent.IsValid() {
+			back = ItemPermaLink(n.Parent)
+		}
+		h.v.addFlashMessage(Success, w, r, heldMessage(n))
+		h.v.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
 	if n, err = repo.SaveItem(ctx, n); err != nil {
 		h.errFn(log.Ctx{"err": err.Error()})("unable to save item")
 		h.v.HandleErrors(w, r, err)
 		return
 	}
+	if err := repo.edits.Record(old, n, time.Now()); err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": n.Hash})("unable to save the edit history")
+	}
+	repo.posting.Record(acc.Hash)
+	if newComment {
+		repo.slow.Record(thread, acc.Hash)
+	}
+	if len(board) > 0 && saveVote {
+		if err := repo.boards.Record(board, newComment); err != nil {
+			h.errFn(log.Ctx{"err": err, "board": board})("unable to save the board activity")
+		}
+	}
+	repo.events.Index(&n)
 
 	if saveVote {
 		v := Vote{
@@ -90,7 +209,7 @@ func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	acc.Metadata.OutboxUpdated = time.Time{}
-	h.v.Redirect(w, r, ItemPermaLink(&n), http.StatusSeeOther)
+	h.v.Redirect(w, r, ItemSlugLink(&n), http.StatusSeeOther)
 }
 
 // HandleDelete serves /{year}/{month}/{day}/{hash}/rm POST request
@@ -99,7 +218,7 @@ func (h *handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
 	repo := h.storage
 	iri := objects.IRI(h.storage.fedbox.Service()).AddPath(chi.URLParam(r, "hash"))
-	ctx := context.TODO()
+	ctx := r.Context()
 	p, err := repo.LoadItem(ctx, iri)
 	if err != nil {
 		h.errFn()("Error: %s", err)
@@ -112,6 +231,18 @@ func (h *handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	if !strings.Contains(backUrl, url) && strings.Contains(backUrl, Instance.BaseURL) {
 		url = fmt.Sprintf("%s#li-%s", backUrl, p.Hash)
 	}
+	if repo.deletes.Enabled() {
+		// NOTE(marius): the Delete activity is sent after the restore window passes, until then
+		// the item is only hidden, see runPendingDeletes
+		if err = repo.deletes.Add(p, acc); err != nil {
+			h.errFn(log.Ctx{"err": err, "hash": p.Hash})("unable to save the pending delete")
+			h.v.addFlashMessage(Error, w, r, "unable to delete item as current user")
+		} else {
+			h.v.addFlashMessage(Success, w, r, deletedMessage(repo.deletes.window))
+		}
+		h.v.Redirect(w, r, url, http.StatusFound)
+		return
+	}
 	p.Delete()
 	if p, err = repo.SaveItem(ctx, p); err != nil {
 		h.v.addFlashMessage(Error, w, r, "unable to delete item as current user")
@@ -126,7 +257,7 @@ func (h *handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 func (h *handler) HandleVoting(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
 	repo := h.storage
-	ctx := context.TODO()
+	ctx := r.Context()
 	iri := objects.IRI(h.storage.fedbox.Service()).AddPath(chi.URLParam(r, "hash"))
 	p, err := repo.LoadItem(ctx, iri)
 	if err != nil {
@@ -185,7 +316,7 @@ func (h *handler) FollowAccount(w http.ResponseWriter, r *http.Request) {
 	}
 	fol := toFollow[0]
 	// todo(marius): load follow reason from POST request so we can show it to the followed user
-	if err = repo.FollowAccount(context.TODO(), *acc, fol, nil); err != nil {
+	if err = repo.FollowAccount(r.Context(), *acc, fol, nil); err != nil {
 		h.v.HandleErrors(w, r, err)
 		return
 	}
@@ -193,9 +324,60 @@ func (h *handler) FollowAccount(w http.ResponseWriter, r *http.Request) {
 	h.v.Redirect(w, r, AccountPermaLink(&fol), http.StatusSeeOther)
 }
 
+// FollowTag serves POST /t/{tag}/follow requests
+func (h *handler) FollowTag(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	name := chi.URLParam(r, "tag")
+	backURL := fmt.Sprintf("/t/%s", name)
+	if acc.Tags.Contains(name) {
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	ctx := r.Context()
+	tags, _, err := h.storage.LoadTags(ctx, tagsFilter(name))
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if len(tags) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("tag #%s not found", name))
+		return
+	}
+	if err = h.storage.FollowTag(ctx, *acc, tags[0]); err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if err = h.storage.digests.FollowTag(accountIRI(acc).String(), name, true); err != nil {
+		h.errFn(log.Ctx{"err": err, "tag": name})("unable to update the tags of the digest")
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("You are now following #%s", name))
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// UnfollowTag serves POST /t/{tag}/unfollow requests
+func (h *handler) UnfollowTag(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	name := chi.URLParam(r, "tag")
+	t, ok := acc.Tags.Get(name)
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("you are not following #%s", name))
+		return
+	}
+	if err := h.storage.UnfollowTag(r.Context(), *acc, t); err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if err := h.storage.digests.FollowTag(accountIRI(acc).String(), name, false); err != nil {
+		h.errFn(log.Ctx{"err": err, "tag": name})("unable to update the tags of the digest")
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.Redirect(w, r, fmt.Sprintf("/t/%s", name), http.StatusSeeOther)
+}
+
 func (h *handler) HandleFollowRequest(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 	repo := h.storage
 	followers := ContextAuthors(r.Context())
 	if len(followers) == 0 {
@@ -240,7 +422,7 @@ func (h *handler) HandleFollowRequest(w http.ResponseWriter, r *http.Request) {
 // BlockAccount processes a report request received at /~{handle}/block
 func (h *handler) BlockAccount(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 
 	reason, err := ContentFromRequest(r, *acc)
 	if err != nil {
@@ -257,10 +439,15 @@ func (h *handler) BlockAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	block := toBlock[0]
-	if err = repo.BlockAccount(context.TODO(), *acc, block, &reason); err != nil {
+	if err = repo.BlockAccount(r.Context(), *acc, block, &reason); err != nil {
 		h.v.HandleErrors(w, r, err)
 		return
 	}
+	if isModerator(&h.conf.Configuration, acc) {
+		if err := repo.fingerprints.Ban(block); err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": block.Handle})("unable to save the fingerprint of the banned account")
+		}
+	}
 	acc.Metadata.OutboxUpdated = time.Time{}
 	h.v.Redirect(w, r, PermaLink(&block), http.StatusSeeOther)
 }
@@ -268,7 +455,7 @@ func (h *handler) BlockAccount(w http.ResponseWriter, r *http.Request) {
 // BlockItem processes a block request received at /~{handle}/{hash}/block
 func (h *handler) BlockItem(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 
 	reason, err := ContentFromRequest(r, *acc)
 	if err != nil {
@@ -295,9 +482,9 @@ func (h *handler) BlockItem(w http.ResponseWriter, r *http.Request) {
 // ReportAccount processes a report request received at /~{handle}/block
 func (h *handler) ReportAccount(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 
-	reason, err := ContentFromRequest(r, *acc)
+	reason, forward, err := reportFromRequest(r, *acc)
 	if err != nil {
 		h.errFn(log.Ctx{ "before": err })("Error: wrong http method")
 		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
@@ -312,7 +499,7 @@ func (h *handler) ReportAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p := byHandleAccounts[0]
-	if err = repo.ReportAccount(context.TODO(), *acc, p, &reason); err != nil {
+	if err = repo.ReportAccount(r.Context(), *acc, p, &reason, forward); err != nil {
 		h.errFn()("Error: %s", err)
 		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
 		return
@@ -330,9 +517,9 @@ func (h *handler) ReportAccount(w http.ResponseWriter, r *http.Request) {
 // ReportItem processes a report request received at /~{handle}/{hash}/bad
 func (h *handler) ReportItem(w http.ResponseWriter, r *http.Request) {
 	acc := loggedAccount(r)
-	ctx := context.TODO()
+	ctx := r.Context()
 
-	reason, err := ContentFromRequest(r, *acc)
+	reason, forward, err := reportFromRequest(r, *acc)
 	if err != nil {
 		h.errFn(log.Ctx{ "before": err })("Error: wrong http method")
 		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
@@ -347,7 +534,7 @@ func (h *handler) ReportItem(w http.ResponseWriter, r *http.Request) {
 		h.errFn(log.Ctx{ "before": err })("invalid item to report")
 		h.v.HandleErrors(w, r, errors.NewNotFound(err, ""))
 	}
-	if err = repo.ReportItem(ctx, *acc, p, &reason); err != nil {
+	if err = repo.ReportItem(ctx, *acc, p, &reason, forward); err != nil {
 		h.errFn()("Error: %s", err)
 		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
 		return
@@ -364,12 +551,88 @@ func (h *handler) ReportItem(w http.ResponseWriter, r *http.Request) {
 
 const SessionUserKey = "__current_acct"
 
+// SessionReturnToKey is where we keep the URL to return to after the user logs in
+const SessionReturnToKey = "__return_to"
+
+// safeRedirectPath returns the path and query of u if it points to our instance,
+// so we can't be used for redirecting users to other sites
+func safeRedirectPath(u string) (string, bool) {
+	if len(u) == 0 || strings.Contains(u, "\\") {
+		return "", false
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+	if len(pu.Scheme) > 0 || len(pu.Host) > 0 {
+		if Instance.Conf == nil || !strings.EqualFold(pu.Host, Instance.Conf.HostName) {
+			return "", false
+		}
+	}
+	p := pu.EscapedPath()
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") {
+		return "", false
+	}
+	// NOTE(marius): there's no point in returning to the authentication pages
+	for _, auth := range []string{"/login", "/logout", "/register", "/auth/"} {
+		if strings.HasPrefix(p, auth) {
+			return "", false
+		}
+	}
+	if len(pu.RawQuery) > 0 {
+		p = p + "?" + pu.RawQuery
+	}
+	return p, true
+}
+
+// saveReturnTo remembers where to send the user after logging in
+func (v *view) saveReturnTo(w http.ResponseWriter, r *http.Request, u string) {
+	p, ok := safeRedirectPath(u)
+	if !ok {
+		return
+	}
+	if s, err := v.s.get(w, r); err == nil && s != nil {
+		s.Values[SessionReturnToKey] = p
+	}
+}
+
+// loadReturnTo returns where to send the user after logging in, and forgets it
+func (v *view) loadReturnTo(w http.ResponseWriter, r *http.Request) string {
+	s, err := v.s.get(w, r)
+	if err != nil || s == nil {
+		return "/"
+	}
+	raw, ok := s.Values[SessionReturnToKey]
+	if !ok {
+		return "/"
+	}
+	delete(s.Values, SessionReturnToKey)
+	if u, ok := raw.(string); ok {
+		if p, ok := safeRedirectPath(u); ok {
+			return p
+		}
+	}
+	return "/"
+}
+
+// RememberReturnTo saves the page the user came from to the login page, or the one in the "back" URL parameter
+func (h *handler) RememberReturnTo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		back := r.URL.Query().Get("back")
+		if len(back) == 0 {
+			back = r.Header.Get("Referer")
+		}
+		h.v.saveReturnTo(w, r, back)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // HandleLogin handles POST /login requests
 func (h *handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	pw := r.PostFormValue("pw")
 	handle := r.PostFormValue("handle")
 	state := r.PostFormValue("state")
-	ctx := context.TODO()
+	ctx := r.Context()
 
 	config := GetOauth2Config("fedbox", h.conf.BaseURL)
 	// Try to load actor from handle
@@ -402,7 +665,7 @@ func (h *handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		acct = AnonymousAccount
 	)
 	for _, cur := range accts {
-		if tok, err = config.PasswordCredentialsToken(context.TODO(), cur.Metadata.ID, pw); tok != nil {
+		if tok, err = config.PasswordCredentialsToken(r.Context(), cur.Metadata.ID, pw); tok != nil {
 			acct = cur
 			acct.Metadata.OAuth.Provider = "fedbox"
 			acct.Metadata.OAuth.Token = tok
@@ -423,12 +686,54 @@ func (h *handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		handleErr("Login failed: unable to save session", lCtx)
 		return
 	}
-	s.Values[SessionUserKey] = acct
-	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+	s.Values[SessionUserKey] = sessionAccountFrom(acct)
+	if err := h.v.s.revoked.Issued(acct.Metadata.ID, acct.Metadata.OAuth); err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acct.Handle})("unable to save the issued token")
+	}
+	if err := h.storage.fingerprints.Seen(&acct, r); err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acct.Handle})("unable to save the fingerprint")
+	}
+	h.v.Redirect(w, r, h.v.loadReturnTo(w, r), http.StatusSeeOther)
 }
 
 // HandleLogout serves /logout requests
 func (h *handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if acc := loggedAccount(r); acc.IsLogged() && acc.HasMetadata() && acc.Metadata.OAuth.Token != nil {
+		oauth := acc.Metadata.OAuth
+		if err := revokeOAuth2Token(r.Context(), oauth.Provider, oauth.Token); err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle, "provider": oauth.Provider})("unable to revoke OAuth2 token")
+		}
+	}
+	h.loggedOut(w, r)
+}
+
+// HandleLogoutEverywhere serves POST /logout/all requests, it invalidates all the sessions of the logged account,
+// including the ones on other devices, and revokes all the OAuth2 tokens that were issued to them
+func (h *handler) HandleLogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	if acc := loggedAccount(r); acc.IsLogged() && acc.HasMetadata() {
+		tokens, err := h.v.s.revoked.Revoke(acc.Metadata.ID)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to invalidate the account's sessions")
+		}
+		if cur := acc.Metadata.OAuth; cur.Token != nil {
+			tokens = append(tokens, cur)
+		}
+		revoked := make(map[string]bool)
+		for _, oauth := range tokens {
+			if oauth.Token == nil || revoked[oauth.Token.AccessToken] {
+				continue
+			}
+			revoked[oauth.Token.AccessToken] = true
+			if err := revokeOAuth2Token(r.Context(), oauth.Provider, oauth.Token); err != nil {
+				h.errFn(log.Ctx{"err": err, "handle": acc.Handle, "provider": oauth.Provider})("unable to revoke OAuth2 token")
+			}
+		}
+	}
+	h.loggedOut(w, r)
+}
+
+// loggedOut clears the session and sends the user back to the page they logged out from
+func (h *handler) loggedOut(w http.ResponseWriter, r *http.Request) {
 	h.v.s.clear(w, r)
 	backUrl := "/"
 	if refUrl := r.Header.Get("Referer"); HostIsLocal(refUrl) && !strings.Contains(refUrl, "followed") {
@@ -437,8 +742,17 @@ func (h *handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	h.v.Redirect(w, r, backUrl, http.StatusSeeOther)
 }
 
+// RedirectToLogin sends the user to the login page, and after logging in, back to the current page
 func (h *handler) RedirectToLogin(w http.ResponseWriter, r *http.Request, errs ...error) {
-	h.v.Redirect(w, r, "/login", http.StatusMovedPermanently)
+	if r.Method == http.MethodGet {
+		h.v.saveReturnTo(w, r, r.URL.RequestURI())
+	}
+	for _, err := range errs {
+		if err != nil {
+			h.v.addFlashMessage(Error, w, r, err.Error())
+		}
+	}
+	h.v.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 func (h *handler) ValidateLoggedIn(eh ErrorHandler) Handler {
@@ -459,7 +773,7 @@ func (h *handler) ValidateLoggedIn(eh ErrorHandler) Handler {
 func (h *handler) ValidateItemAuthor(op string) Handler {
 	return func (next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.TODO()
+			ctx := r.Context()
 			acc := loggedAccount(r)
 			hash := chi.URLParam(r, "hash")
 			url := r.URL
@@ -477,6 +791,11 @@ func (h *handler) ValidateItemAuthor(op string) Handler {
 					h.v.Redirect(w, r, url.RequestURI(), http.StatusTemporaryRedirect)
 					return
 				}
+				if op == "edit" && !canEditItem(h.conf.Configuration, &m, time.Now()) {
+					h.v.addFlashMessage(Error, w, r, "The time for editing it has passed.")
+					h.v.Redirect(w, r, ItemPermaLink(&m), http.StatusSeeOther)
+					return
+				}
 				next.ServeHTTP(w, r)
 			}
 		}
@@ -484,10 +803,257 @@ func (h *handler) ValidateItemAuthor(op string) Handler {
 	}
 }
 
+// ValidateAccountOwner checks that the account in the URL is the logged one
+func (h *handler) ValidateAccountOwner(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		authors := ContextAuthors(r.Context())
+		if len(authors) == 0 || authors[0].Hash != loggedAccount(r).Hash {
+			h.v.HandleErrors(w, r, errors.Forbiddenf("Only the owner of the account can access this page"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// HandleRename handles POST /~handle/settings/handle requests
+func (h *handler) HandleRename(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	settingsURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+	handle := strings.TrimSpace(r.PostFormValue("handle"))
+	if handle == acc.Handle {
+		h.v.Redirect(w, r, settingsURL, http.StatusSeeOther)
+		return
+	}
+	if !validHandle(handle) {
+		h.v.HandleErrors(w, r, errors.BadRequestf("%q is not a valid handle, it can only contain letters, digits, '.', '-' and '_'", handle))
+		return
+	}
+	repo := h.storage
+	id := accountIRI(acc).String()
+	if next := repo.renames.NextRename(id); time.Now().Before(next) {
+		h.v.HandleErrors(w, r, errors.BadRequestf("You can change your handle again after %s", next.Format("2006-01-02")))
+		return
+	}
+	if repo.renames.Reserved(handle, id) {
+		h.v.HandleErrors(w, r, errors.BadRequestf("Handle %s is not available", handle))
+		return
+	}
+	maybeExists, err := repo.account(r.Context(), &Filters{Name: CompStrs{EqualsString(handle)}})
+	if err != nil && !errors.IsNotFound(err) {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "error when trying to load account %s", handle))
+		return
+	}
+	if maybeExists.IsValid() && maybeExists.Hash != acc.Hash {
+		h.v.HandleErrors(w, r, errors.BadRequestf("Handle %s is not available", handle))
+		return
+	}
+
+	renamed, err := repo.RenameAccount(r.Context(), *acc, handle)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to rename account")
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to change handle"))
+		return
+	}
+	acc.Handle = renamed.Handle
+	if err := h.v.saveAccountToSession(w, r, *acc); err != nil {
+		h.errFn(log.Ctx{"err": err})("unable to save the renamed account to session")
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Your handle is now %s.", acc.Handle))
+	h.v.Redirect(w, r, PermaLink(acc), http.StatusSeeOther)
+}
+
+// HandleDisplayName handles POST /~handle/settings/name requests
+func (h *handler) HandleDisplayName(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	name := sanitizeDisplayName(r.PostFormValue("name"))
+	updated, err := h.storage.SetAccountName(r.Context(), *acc, name)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to change the display name")
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to change display name"))
+		return
+	}
+	if acc.HasMetadata() && updated.HasMetadata() {
+		acc.Metadata.Name = updated.Metadata.Name
+	}
+	h.v.addFlashMessage(Success, w, r, "Your display name was saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleBio handles POST /~handle/settings/bio requests
+func (h *handler) HandleBio(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	bio := newBio(r.PostFormValue("bio"))
+	updated, err := h.storage.SetAccountBio(r.Context(), *acc, bio)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to change the bio")
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save bio"))
+		return
+	}
+	if acc.HasMetadata() && updated.HasMetadata() {
+		acc.Metadata.Blurb = updated.Metadata.Blurb
+	}
+	h.v.addFlashMessage(Success, w, r, "Your bio was saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleSettings redirects the /settings requests to the settings page of the logged account
+func (h *handler) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(loggedAccount(r))), http.StatusSeeOther)
+}
+
+// HandleEmail handles POST /~handle/settings/email requests, the address is kept with the private settings
+func (h *handler) HandleEmail(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	s := acc.Settings()
+	s.Email = ""
+	if email := strings.TrimSpace(r.PostFormValue("email")); len(email) > 0 {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid email address"))
+			return
+		}
+		s.Email = addr.Address
+	}
+	if err := h.saveSettings(r, acc, s); err != nil {
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save email address"))
+		return
+	}
+	if len(s.Email) > 0 {
+		h.v.addFlashMessage(Success, w, r, "Your email address was saved.")
+	} else {
+		h.v.addFlashMessage(Success, w, r, "Your email address was removed.")
+	}
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleProfileFields handles POST /~handle/settings/fields requests
+func (h *handler) HandleProfileFields(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	if err := r.ParseForm(); err != nil {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid profile fields"))
+		return
+	}
+	names := r.PostForm["field-name"]
+	values := r.PostForm["field-value"]
+	fields := make([]ProfileField, 0)
+	for i := 0; i < len(names) && i < len(values) && len(fields) < maxProfileFields; i++ {
+		if f, ok := newProfileField(names[i], values[i]); ok {
+			fields = append(fields, f)
+		}
+	}
+	updated, err := h.storage.SetAccountFields(r.Context(), *acc, fields)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to change the profile fields")
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save profile fields"))
+		return
+	}
+	if acc.HasMetadata() && updated.HasMetadata() {
+		acc.Metadata.Fields = updated.Metadata.Fields
+	}
+	h.v.addFlashMessage(Success, w, r, "Your profile fields were saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleAvatarLookup handles POST /~handle/settings/avatar requests
+func (h *handler) HandleAvatarLookup(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	settingsURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+	if !h.storage.avatars.Enabled() {
+		h.v.HandleErrors(w, r, errors.BadRequestf("Avatar lookup is disabled"))
+		return
+	}
+	id := accountIRI(acc).String()
+	email := ""
+	if r.PostFormValue("lookup") == "y" {
+		email = strings.TrimSpace(r.PostFormValue("email"))
+		if len(email) == 0 && h.storage.avatars.Has(id) {
+			// NOTE(marius): we don't show the email, so it's empty when the setting is saved again unchanged
+			h.v.Redirect(w, r, settingsURL, http.StatusSeeOther)
+			return
+		}
+		if !strings.Contains(email, "@") {
+			h.v.HandleErrors(w, r, errors.BadRequestf("Invalid email address"))
+			return
+		}
+	}
+	if err := h.storage.avatars.Set(id, email); err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the avatar lookup")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save avatar setting"))
+		return
+	}
+	if len(email) > 0 {
+		h.v.addFlashMessage(Success, w, r, "Your Libravatar/Gravatar avatar will be shown when you don't have one uploaded.")
+	} else {
+		h.v.addFlashMessage(Success, w, r, "Your Libravatar/Gravatar avatar won't be shown anymore.")
+	}
+	h.v.Redirect(w, r, settingsURL, http.StatusSeeOther)
+}
+
+// HandleMediaSettings handles POST /~handle/settings/media requests
+func (h *handler) HandleMediaSettings(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	s := acc.Settings()
+	s.HideVideo = r.PostFormValue("hide-video") == "y"
+	s.HideAudio = r.PostFormValue("hide-audio") == "y"
+	s.HideAnimated = r.PostFormValue("hide-animated") == "y"
+	if err := h.saveSettings(r, acc, s); err != nil {
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save media settings"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, "Your media settings were saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleThemeSettings handles POST /~handle/settings/theme requests
+func (h *handler) HandleThemeSettings(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	s := acc.Settings()
+	if s.Theme = r.PostFormValue("theme"); !validTheme(s.Theme) {
+		h.v.HandleErrors(w, r, errors.BadRequestf("unknown theme %q", s.Theme))
+		return
+	}
+	if err := h.saveSettings(r, acc, s); err != nil {
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the theme"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, "Your theme was saved.")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// HandleShortLink serves /s/{short} requests, it redirects to the item whose hash starts with short.
+// When more items match, they're all listed.
+func (h *handler) HandleShortLink(w http.ResponseWriter, r *http.Request) {
+	short := strings.ToLower(chi.URLParam(r, "short"))
+	c := ContextCursor(r.Context())
+	if c == nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("%q item", short))
+		return
+	}
+	matches := make(RenderableList)
+	for k, ren := range c.items {
+		if it, ok := ren.(*Item); ok && strings.HasPrefix(it.Hash.String(), short) {
+			matches[k] = it
+		}
+	}
+	c.items = matches
+	switch len(matches) {
+	case 0:
+		h.v.HandleErrors(w, r, errors.NotFoundf("%q item", short))
+	case 1:
+		for _, ren := range matches {
+			h.v.Redirect(w, r, ItemSlugLink(ren.(*Item)), http.StatusMovedPermanently)
+		}
+	default:
+		h.v.addFlashMessage(Warning, w, r, "The short link matches more than one item.")
+		h.HandleShow(w, r)
+	}
+}
+
 // HandleItemRedirect serves /i/{hash} request
 func (h *handler) HandleItemRedirect(w http.ResponseWriter, r *http.Request) {
 	repo := h.storage
-	ctx := context.TODO()
+	ctx := r.Context()
 	p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
 	if err != nil {
 		h.v.HandleErrors(w, r, errors.NewNotValid(err, "oops!"))
@@ -537,7 +1103,7 @@ func (h *handler) HandleCreateInvitation(w http.ResponseWriter, r *http.Request)
 	}
 
 	acc := loggedAccount(r)
-	invitee, err := h.storage.SaveAccount(context.TODO(), Account{ CreatedBy: acc })
+	invitee, err := h.storage.SaveAccount(r.Context(), Account{ CreatedBy: acc })
 	if err != nil {
 		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save account"))
 		return
@@ -558,7 +1124,7 @@ func (h *handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		h.v.HandleErrors(w, r, err)
 		return
 	}
-	ctx := context.TODO()
+	ctx := r.Context()
 
 	f := &Filters{Name: CompStrs{EqualsString(a.Handle)}}
 	maybeExists, err := h.storage.account(ctx, f)
@@ -571,6 +1137,10 @@ func (h *handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		h.v.HandleErrors(w, r, errors.BadRequestf("account %s already exists", a.Handle))
 		return
 	}
+	if h.storage.renames.Reserved(a.Handle, "") {
+		h.v.HandleErrors(w, r, errors.BadRequestf("handle %s is not available", a.Handle))
+		return
+	}
 
 	app := h.storage.app
 	a.CreatedBy = app
@@ -584,6 +1154,11 @@ func (h *handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		h.v.HandleErrors(w, r, errors.Newf("unable to save actor"))
 		return
 	}
+	if flag, err := h.storage.fingerprints.Register(&a, r); err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": a.Handle})("unable to save the fingerprint")
+	} else if flag != nil {
+		h.infoFn(log.Ctx{"handle": a.Handle, "matches": flag.Matches})("new account flagged for possible ban evasion")
+	}
 
 	// TODO(marius): Start oauth2 authorize session
 	config := GetOauth2Config("fedbox", h.conf.BaseURL)
@@ -667,7 +1242,15 @@ func (h *handler) HandleShow(w http.ResponseWriter, r *http.Request) {
 	if mod, ok := m.(Paginator); ok && cursor != nil {
 		mod.SetCursor(cursor)
 	}
-	if err := h.v.RenderTemplate(r, w, m.Template(), m); err != nil {
-		h.v.HandleErrors(w, r, err)
+	if lm, format := wantsListingFeed(r); lm != nil {
+		h.writeListingFeed(w, r, format, lm)
+		return
+	}
+	if cursor != nil && cursor.partial {
+		h.v.addFlashMessage(Warning, w, r, "Some of the content took too long to load and it's not shown.")
+	}
+	if cursor != nil && cursor.stale {
+		h.v.addFlashMessage(Warning, w, r, "The content could not be refreshed, you're seeing an older version of the page.")
 	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
 }
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestSafeRedirectPath(t *testing.T) {
+	Instance.Conf = &config.Configuration{HostName: "littr.git"}
+	tests := []struct {
+		name string
+		val  string
+		want string
+		ok   bool
+	}{
+		{
+			name: "empty",
+			val:  "",
+		},
+		{
+			name: "local path",
+			val:  "/followed?after=abc",
+			want: "/followed?after=abc",
+			ok:   true,
+		},
+		{
+			name: "local absolute URL",
+			val:  "https://littr.git/~marius",
+			want: "/~marius",
+			ok:   true,
+		},
+		{
+			name: "other host",
+			val:  "https://example.com/",
+		},
+		{
+			name: "other host with our name as prefix",
+			val:  "https://littr.git.example.com/",
+		},
+		{
+			name: "protocol relative URL",
+			val:  "//example.com/",
+		},
+		{
+			name: "backslash",
+			val:  "/\\example.com/",
+		},
+		{
+			name: "javascript",
+			val:  "javascript:alert(1)",
+		},
+		{
+			name: "login page",
+			val:  "/login",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := safeRedirectPath(tt.val)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("safeRedirectPath(%q) = %q, %t, want %q, %t", tt.val, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
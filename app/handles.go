@@ -0,0 +1,132 @@
+package app
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const handleRenamesFile = "renamed-handles.json"
+
+// handleRename records that the account with ID changed its handle from Old to New
+type handleRename struct {
+	Old string    `json:"old"`
+	New string    `json:"new"`
+	ID  string    `json:"id"`
+	At  time.Time `json:"at"`
+}
+
+// handleRenames keeps the old handles of the accounts which renamed themselves, so the links and mentions
+// using them keep working for a while, and so nobody else can register them in the meantime.
+type handleRenames struct {
+	m        sync.RWMutex
+	path     string
+	reserve  time.Duration
+	interval time.Duration
+	renames  map[string]handleRename
+}
+
+func loadHandleRenames(path string, reserve, interval time.Duration) (*handleRenames, error) {
+	h := &handleRenames{path: path, reserve: reserve, interval: interval, renames: make(map[string]handleRename)}
+	return h, loadJSON(path, &h.renames)
+}
+
+func handleKey(handle string) string {
+	return strings.ToLower(handle)
+}
+
+// validHandle checks the handles chosen by the users, they need to work in URLs and in mentions
+func validHandle(handle string) bool {
+	if len(handle) == 0 || len(handle) > 64 || handle == selfName {
+		return false
+	}
+	for _, c := range handle {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *handleRenames) reserved(ren handleRename) bool {
+	return time.Since(ren.At) < h.reserve
+}
+
+// Resolve returns the current handle of the account that used handle before, while it's still reserved
+func (h *handleRenames) Resolve(handle string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	current := ""
+	key := handleKey(handle)
+	// NOTE(marius): the account could have been renamed more than once, we follow the renames to the last one
+	for i := 0; i < len(h.renames); i++ {
+		ren, ok := h.renames[key]
+		if !ok || !h.reserved(ren) {
+			break
+		}
+		current = ren.New
+		key = handleKey(ren.New)
+	}
+	return current, len(current) > 0
+}
+
+// Current returns the handle the account which used handle has now, or handle if it wasn't renamed
+func (h *handleRenames) Current(handle string) string {
+	if current, ok := h.Resolve(handle); ok {
+		return current
+	}
+	return handle
+}
+
+// Reserved returns if handle was used by an account other than the one with id and it's not available yet
+func (h *handleRenames) Reserved(handle, id string) bool {
+	if h == nil {
+		return false
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	ren, ok := h.renames[handleKey(handle)]
+	return ok && ren.ID != id && h.reserved(ren)
+}
+
+// NextRename returns when the account with id is allowed to change its handle again
+func (h *handleRenames) NextRename(id string) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	last := time.Time{}
+	for _, ren := range h.renames {
+		if ren.ID == id && ren.At.After(last) {
+			last = ren.At
+		}
+	}
+	if last.IsZero() {
+		return last
+	}
+	return last.Add(h.interval)
+}
+
+// Add records that the account with id changed its handle from old, and saves the renames to disk
+func (h *handleRenames) Add(old, handle, id string) error {
+	if h == nil {
+		return nil
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	for k, ren := range h.renames {
+		if !h.reserved(ren) && time.Since(ren.At) > h.interval {
+			delete(h.renames, k)
+		}
+	}
+	// NOTE(marius): the account can go back to one of its old handles, which stops being a redirect
+	delete(h.renames, handleKey(handle))
+	h.renames[handleKey(old)] = handleRename{Old: old, New: handle, ID: id, At: time.Now().UTC()}
+
+	return saveJSON(h.path, h.renames)
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandleRenames(t *testing.T) {
+	h, err := loadHandleRenames("", time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unable to load renames: %s", err)
+	}
+	if err := h.Add("alice", "bob", "https://fedbox.git/actors/1"); err != nil {
+		t.Fatalf("unable to add rename: %s", err)
+	}
+	if err := h.Add("bob", "carol", "https://fedbox.git/actors/1"); err != nil {
+		t.Fatalf("unable to add rename: %s", err)
+	}
+	if got, ok := h.Resolve("Alice"); !ok || got != "carol" {
+		t.Errorf("Resolve(Alice) = %q, %t, want %q, true", got, ok, "carol")
+	}
+	if !h.Reserved("bob", "https://fedbox.git/actors/2") {
+		t.Errorf("bob should be reserved for other accounts")
+	}
+	if h.Reserved("bob", "https://fedbox.git/actors/1") {
+		t.Errorf("bob should be available for the account which used it")
+	}
+	if next := h.NextRename("https://fedbox.git/actors/1"); !next.After(time.Now()) {
+		t.Errorf("NextRename should be in the future, got %s", next)
+	}
+
+	// going back to an old handle stops its redirect
+	if err := h.Add("carol", "alice", "https://fedbox.git/actors/1"); err != nil {
+		t.Fatalf("unable to add rename: %s", err)
+	}
+	if got, ok := h.Resolve("alice"); ok {
+		t.Errorf("Resolve(alice) = %q, alice should not be a redirect anymore", got)
+	}
+	if got, ok := h.Resolve("bob"); !ok || got != "alice" {
+		t.Errorf("Resolve(bob) = %q, %t, want %q, true", got, ok, "alice")
+	}
+}
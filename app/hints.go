@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type preloadAsset struct {
+	path string
+	as   string
+}
+
+// criticalAssets returns the assets every page rendered with the template needs before the first paint
+func (v *view) criticalAssets(name string) []preloadAsset {
+	result := make([]preloadAsset, 0, 2)
+	if css := name + ".css"; len(v.assets[css]) > 0 {
+		result = append(result, preloadAsset{path: "/css/" + css, as: "style"})
+	}
+	result = append(result, preloadAsset{path: "/js/main.js", as: "script"})
+	return result
+}
+
+// hintAssets lets the client know about the assets the page is going to load, before we render it.
+// It sets the Link preload headers and sends them in a 103 Early Hints response, so the browser can start
+// loading the assets while we render the page. The headers stay on the final response too, for the clients
+// and the proxies which ignore the informational responses.
+func (v *view) hintAssets(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		return
+	}
+	assets := v.criticalAssets(name)
+	links := make([]string, 0, len(assets))
+	for _, a := range assets {
+		links = append(links, fmt.Sprintf("<%s>; rel=preload; as=%s", a.path, a.as))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	if earlyHintsSupported {
+		writeEarlyHints(w)
+	}
+}
+
+// writeEarlyHints sends the 103 status on the connection's response writer, because the wrapping ones,
+// like the request logger's, take the first status they see for the final one and drop the next.
+// The header map is shared between them, so the Link headers set on w are sent with it.
+func writeEarlyHints(w http.ResponseWriter) {
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+}
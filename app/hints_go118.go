@@ -0,0 +1,8 @@
+//go:build !go1.19
+// +build !go1.19
+
+package app
+
+// earlyHintsSupported is not set for the Go versions before 1.19, where the 103 status would be sent
+// as the final status of the response
+const earlyHintsSupported = false
@@ -0,0 +1,7 @@
+//go:build go1.19
+// +build go1.19
+
+package app
+
+// earlyHintsSupported is set when net/http can send informational responses before the final one
+const earlyHintsSupported = true
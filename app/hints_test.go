@@ -0,0 +1,64 @@
+//go:build go1.19
+// +build go1.19
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+)
+
+// firstStatusWriter behaves like the request logger's response writer, which keeps only the first status it sees
+type firstStatusWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (f *firstStatusWriter) WriteHeader(status int) {
+	if !f.wroteHeader {
+		f.wroteHeader = true
+		f.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (f *firstStatusWriter) Unwrap() http.ResponseWriter {
+	return f.ResponseWriter
+}
+
+func TestWriteEarlyHints(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w = &firstStatusWriter{ResponseWriter: w}
+		w.Header().Set("Link", "</js/main.js>; rel=preload; as=script")
+		writeEarlyHints(w)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, h.Get("Link"))
+			}
+			return nil
+		},
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	res.Body.Close()
+
+	if len(hints) != 1 || hints[0] != "</js/main.js>; rel=preload; as=script" {
+		t.Errorf("expected one 103 response with the preload link, got %v", hints)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the final status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+}
@@ -32,8 +32,13 @@ func Wilson(ups, downs int64) float64 {
 // hackernews' hot sort
 // https://medium.com/hacking-and-gonzo/how-hacker-news-ranking-algorithm-works-1d9b0cf2c08d
 func Hacker(votes int64, date time.Duration) float64 {
+	return HackerWithGravity(votes, date, HNGravity)
+}
+
+// HackerWithGravity is the hackernews' hot sort, with a different gravity than the default one
+func HackerWithGravity(votes int64, date time.Duration, gravity float64) float64 {
 	hoursAge := date.Hours()
-	return float64(votes-1) / math.Pow(hoursAge+2, HNGravity)
+	return float64(votes-1) / math.Pow(hoursAge+2, gravity)
 }
 
 // reddit's hot sort
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	stdimage "image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/go-ap/errors"
+)
+
+const (
+	// imageMaxPixels bounds the decoded size of the images, the small files which decode to huge bitmaps
+	// are rejected before we allocate the memory for them
+	imageMaxPixels = 4096 * 4096
+	// imageJPEGQuality is the quality of the re-encoded JPEG images
+	imageJPEGQuality = 85
+
+	exifOrientationTag = 0x0112
+)
+
+// reencodeImage decodes the image in data and encodes it again, scaled down to fit in maxSide if it's larger.
+// The encoders don't write any metadata, so the EXIF data of the photos, with the location and the camera,
+// and the comments of the PNG and GIF files are lost on the way. The JPEG images stay JPEG, the others
+// are encoded as PNG, with the first frame of the animated GIFs.
+func reencodeImage(data []byte, maxSide int) ([]byte, string, error) {
+	cfg, format, err := stdimage.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errors.NewNotValid(err, "unsupported image")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > imageMaxPixels {
+		return nil, "", errors.NotValidf("the image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := stdimage.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errors.NewNotValid(err, "invalid image")
+	}
+	img = scaleDownImage(img, maxSide)
+
+	buf := new(bytes.Buffer)
+	if format == "jpeg" {
+		// NOTE(marius): the cameras save the photos unrotated, with the orientation in the EXIF data we remove
+		img = orientImage(img, jpegOrientation(data))
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: imageJPEGQuality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// scaleDownImage resizes img to fit in a maxSide square, keeping its proportions. Each of the new pixels
+// is the average of the ones it replaces.
+func scaleDownImage(img stdimage.Image, maxSide int) stdimage.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxSide <= 0 || (w <= maxSide && h <= maxSide) {
+		return img
+	}
+	nw, nh := maxSide, h*maxSide/w
+	if h > w {
+		nw, nh = w*maxSide/h, maxSide
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	out := stdimage.NewNRGBA(stdimage.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			if n == 0 || a == 0 {
+				continue
+			}
+			// NOTE(marius): RGBA() returns premultiplied values, we undo it for the NRGBA pixels
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xffff / a >> 8),
+				G: uint8(g * 0xffff / a >> 8),
+				B: uint8(bl * 0xffff / a >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return out
+}
+
+// orientImage rotates and flips img so it's shown as the EXIF orientation o describes
+func orientImage(img stdimage.Image, o int) stdimage.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	nw, nh := w, h
+	if o >= 5 {
+		nw, nh = h, w
+	}
+	out := stdimage.NewNRGBA(stdimage.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			sx, sy := x, y
+			switch o {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			out.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// jpegOrientation returns the orientation from the EXIF data of the JPEG image, or 0 if it doesn't have one
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// NOTE(marius): the image data starts, the metadata segments are before it
+			return 0
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 0
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 0
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the TIFF structure of the EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	off := int(order.Uint32(tiff[4:]))
+	if off < 8 || off+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[off:]))
+	for k := 0; k < n; k++ {
+		e := off + 2 + 12*k
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	stdimage "image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withEXIF inserts an APP1 segment, with the orientation o and a fake GPS tag, after the start of the JPEG image
+func withEXIF(data []byte, o uint16) []byte {
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("II*\x00")
+	binary.Write(tiff, binary.LittleEndian, uint32(8))
+	binary.Write(tiff, binary.LittleEndian, uint16(2))
+	binary.Write(tiff, binary.LittleEndian, []uint16{exifOrientationTag, 3, 1, 0, o, 0})
+	binary.Write(tiff, binary.LittleEndian, []uint16{0x8825, 4, 1, 0, 0, 0})
+	tiff.WriteString("GPS 45.7489N 21.2087E")
+
+	seg := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, data[2:]...)
+}
+
+func testImage(w, h int) *stdimage.NRGBA {
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	return img
+}
+
+func TestReencodeImage(t *testing.T) {
+	t.Run("jpeg with EXIF", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		jpeg.Encode(buf, testImage(40, 20), nil)
+		data := withEXIF(buf.Bytes(), 6)
+		if o := jpegOrientation(data); o != 6 {
+			t.Fatalf("expected the orientation 6, got %d", o)
+		}
+		out, mime, err := reencodeImage(data, avatarMaxSide)
+		if err != nil {
+			t.Fatalf("unable to re-encode the image: %s", err)
+		}
+		if mime != "image/jpeg" {
+			t.Errorf("expected a JPEG image, got %s", mime)
+		}
+		if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("GPS")) {
+			t.Errorf("expected the EXIF data to be removed")
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("invalid JPEG image: %s", err)
+		}
+		if cfg.Width != 20 || cfg.Height != 40 {
+			t.Errorf("expected the image to be rotated to 20x40, got %dx%d", cfg.Width, cfg.Height)
+		}
+	})
+	t.Run("scaled down", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		png.Encode(buf, testImage(600, 300))
+		out, mime, err := reencodeImage(buf.Bytes(), avatarMaxSide)
+		if err != nil || mime != "image/png" {
+			t.Fatalf("unable to re-encode the image: %s %v", mime, err)
+		}
+		cfg, _ := png.DecodeConfig(bytes.NewReader(out))
+		if cfg.Width != avatarMaxSide || cfg.Height != avatarMaxSide/2 {
+			t.Errorf("expected the image to be scaled down to %dx%d, got %dx%d", avatarMaxSide, avatarMaxSide/2, cfg.Width, cfg.Height)
+		}
+	})
+	t.Run("decompression bomb", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		gif.Encode(buf, testImage(2, 2), nil)
+		data := buf.Bytes()
+		// NOTE(marius): the logical screen size of the GIF claims a 65535x65535 image
+		binary.LittleEndian.PutUint16(data[6:], 0xFFFF)
+		binary.LittleEndian.PutUint16(data[8:], 0xFFFF)
+		if _, _, err := reencodeImage(data, avatarMaxSide); err == nil {
+			t.Errorf("expected the image to be rejected")
+		}
+	})
+	t.Run("not an image", func(t *testing.T) {
+		if _, _, err := reencodeImage([]byte("<svg></svg>"), avatarMaxSide); err == nil {
+			t.Errorf("expected the file to be rejected")
+		}
+	})
+}
@@ -0,0 +1,439 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// indexEntry holds the information about an item that the listings computed locally need
+type indexEntry struct {
+	Hash        Hash
+	IRI         pub.IRI
+	OP          Hash
+	Author      Hash
+	Handle      string
+	Title       string
+	Domain      string
+	Board       string
+	Tags        []string
+	Score       int
+	Public      bool
+	Deleted     bool
+	SubmittedAt time.Time
+	LastReplyAt time.Time
+	Replies     int
+}
+
+// IsTop returns if the entry represents a top level submission
+func (e indexEntry) IsTop() bool {
+	return !e.OP.IsValid()
+}
+
+// Listable returns if the entry can be part of the public listings
+func (e indexEntry) Listable() bool {
+	return len(e.IRI) > 0 && e.Public && !e.Deleted
+}
+
+// LastActivity returns the date of the latest reply, or the submission date if there's none
+func (e indexEntry) LastActivity() time.Time {
+	if e.LastReplyAt.After(e.SubmittedAt) {
+		return e.LastReplyAt
+	}
+	return e.SubmittedAt
+}
+
+// LocalLink returns the path of the item page
+func (e indexEntry) LocalLink() string {
+	it := Item{Hash: e.Hash, SubmittedAt: e.SubmittedAt}
+	if len(e.Handle) > 0 {
+		it.SubmittedBy = &Account{Handle: e.Handle}
+	}
+	return ItemLocalLink(&it)
+}
+
+// HasTag returns if the entry is tagged with tag
+func (e indexEntry) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimLeft(tag, "#"))
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// localIndexSize is the maximum number of entries the local index keeps in memory,
+// when it's reached the ones with the oldest activity get dropped
+const localIndexSize = 10000
+
+// localIndex keeps the items we have seen in memory, so we can build the listings
+// that the fedbox collection filters can't express: by thread activity, by score in a time window, etc.
+type localIndex struct {
+	m       sync.RWMutex
+	entries map[Hash]*indexEntry
+	related relatedCache
+}
+
+func newLocalIndex() *localIndex {
+	return &localIndex{entries: make(map[Hash]*indexEntry)}
+}
+
+func itemDomain(it Item) string {
+	if !it.IsLink() {
+		return ""
+	}
+	u, err := url.Parse(it.Data)
+	if err != nil {
+		return ""
+	}
+	return getDomain(u)
+}
+
+func indexEntryFromItem(it Item) indexEntry {
+	e := indexEntry{
+		Hash:        it.Hash,
+		Title:       it.Title,
+		Domain:      itemDomain(it),
+		Score:       it.Score,
+		Public:      it.Public(),
+		Deleted:     it.Deleted(),
+		SubmittedAt: it.SubmittedAt,
+	}
+	if it.pub != nil {
+		e.IRI = it.pub.GetLink()
+	} else if id, ok := BuildIDFromItem(it); ok {
+		e.IRI = id
+	}
+	if it.OP != nil && it.OP.Hash != it.Hash {
+		e.OP = it.OP.Hash
+	}
+	if it.SubmittedBy != nil {
+		e.Author = it.SubmittedBy.Hash
+		e.Handle = it.SubmittedBy.Handle
+	}
+	if it.HasMetadata() {
+		e.Board = it.Metadata.Board
+		for _, t := range it.Metadata.Tags {
+			if name := strings.TrimLeft(t.Name, "#"); len(name) > 0 {
+				e.Tags = append(e.Tags, strings.ToLower(name))
+			}
+		}
+	}
+	return e
+}
+
+// Add indexes the items, updating the thread information of their OPs
+func (l *localIndex) Add(items ...Item) {
+	if l == nil {
+		return
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for _, it := range items {
+		// NOTE(marius): the listings built from the index are for the local content only,
+		// the federated items have their own listing
+		if !it.Hash.IsValid() || it.IsFederated() {
+			continue
+		}
+		e := indexEntryFromItem(it)
+		old, exists := l.entries[e.Hash]
+		if e.Deleted {
+			l.remove(e, old)
+			continue
+		}
+		if exists {
+			// NOTE(marius): keep the thread information, the item itself doesn't know about it
+			e.LastReplyAt = old.LastReplyAt
+			e.Replies = old.Replies
+			if e.Score == 0 {
+				e.Score = old.Score
+			}
+		}
+		l.entries[e.Hash] = &e
+		if exists && len(old.IRI) > 0 || !e.OP.IsValid() {
+			continue
+		}
+		op, ok := l.entries[e.OP]
+		if !ok {
+			// placeholder until we see the OP itself
+			op = &indexEntry{Hash: e.OP}
+			l.entries[e.OP] = op
+		}
+		op.Replies++
+		if e.SubmittedAt.After(op.LastReplyAt) {
+			op.LastReplyAt = e.SubmittedAt
+		}
+	}
+	if len(l.entries) > localIndexSize {
+		l.evict(localIndexSize * 9 / 10)
+	}
+}
+
+// remove drops the entry of a deleted item, and the reply it was counted as from its OP
+func (l *localIndex) remove(e indexEntry, old *indexEntry) {
+	if old == nil {
+		return
+	}
+	delete(l.entries, e.Hash)
+	if len(old.IRI) == 0 || !old.OP.IsValid() {
+		return
+	}
+	if op, ok := l.entries[old.OP]; ok && op.Replies > 0 {
+		op.Replies--
+	}
+}
+
+// evict keeps the size entries with the most recent activity
+func (l *localIndex) evict(size int) {
+	all := make([]*indexEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastActivity().After(all[j].LastActivity())
+	})
+	for _, e := range all[size:] {
+		delete(l.entries, e.Hash)
+	}
+}
+
+// Get returns the index entry for h
+func (l *localIndex) Get(h Hash) (indexEntry, bool) {
+	if l == nil {
+		return indexEntry{}, false
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	e, ok := l.entries[h]
+	if !ok || len(e.IRI) == 0 {
+		return indexEntry{}, false
+	}
+	return *e, true
+}
+
+// Len returns the number of indexed items
+func (l *localIndex) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return len(l.entries)
+}
+
+// Select returns copies of the entries matching keepFn ordered by lessFn
+func (l *localIndex) Select(keepFn func(indexEntry) bool, lessFn func(a, b indexEntry) bool) []indexEntry {
+	result := make([]indexEntry, 0)
+	if l == nil {
+		return result
+	}
+	l.m.RLock()
+	for _, e := range l.entries {
+		if !e.Listable() || !keepFn(*e) {
+			continue
+		}
+		result = append(result, *e)
+	}
+	l.m.RUnlock()
+	if lessFn != nil {
+		sort.SliceStable(result, func(i, j int) bool {
+			return lessFn(result[i], result[j])
+		})
+	}
+	return result
+}
+
+// pageEntries returns the max entries after or before the received hashes
+// together with the hashes needed for loading the next and previous pages
+func pageEntries(entries []indexEntry, after, before Hash, max int) ([]indexEntry, Hash, Hash) {
+	var next, prev Hash
+	if max <= 0 {
+		max = MaxContentItems
+	}
+	position := func(h Hash) int {
+		for i, e := range entries {
+			if e.Hash == h {
+				return i
+			}
+		}
+		return -1
+	}
+	start := 0
+	if after.IsValid() {
+		if pos := position(after); pos >= 0 {
+			start = pos + 1
+		}
+	} else if before.IsValid() {
+		if pos := position(before); pos >= 0 {
+			start = pos - max
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + max
+	if end > len(entries) {
+		end = len(entries)
+	}
+	if start >= end {
+		return nil, next, prev
+	}
+	page := entries[start:end]
+	if end < len(entries) {
+		next = page[len(page)-1].Hash
+	}
+	if start > 0 {
+		prev = page[0].Hash
+	}
+	return page, next, prev
+}
+
+// indexPage holds the entries selected from the local index for the current request
+type indexPage struct {
+	entries []indexEntry
+	after   Hash
+	before  Hash
+}
+
+func ContextIndexPage(ctx context.Context) *indexPage {
+	var p *indexPage
+	p, _ = ctx.Value(IndexPageCtxtKey).(*indexPage)
+	return p
+}
+
+// entriesFilters returns the filters for loading the Create activities of the entries
+func entriesFilters(page []indexEntry) []*Filters {
+	allFilters := make([]*Filters, 0)
+	if len(page) > 0 {
+		f := new(Filters)
+		f.Type = CreateActivitiesFilter
+		f.MaxItems = len(page)
+		f.Object = new(Filters)
+		for _, e := range page {
+			f.Object.IRI = append(f.Object.IRI, LikeString(e.Hash.String()))
+		}
+		allFilters = append(allFilters, f)
+	}
+	return allFilters
+}
+
+// IndexListingMw selects a page of items from the local index and generates the filters
+// for loading them from the service's inbox
+func IndexListingMw(title string, keepFn func(*http.Request) func(indexEntry) bool, lessFn func(a, b indexEntry) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			repo := ContextRepository(r.Context())
+			q := r.URL.Query()
+
+			all := repo.index.Select(keepFn(r), lessFn)
+			page, after, before := pageEntries(all, HashFromString(q.Get("after")), HashFromString(q.Get("before")), MaxContentItems)
+
+			if m := ContextListingModel(r.Context()); m != nil && len(title) > 0 {
+				m.Title = title
+			}
+			ctx := context.WithValue(r.Context(), FilterCtxtKey, entriesFilters(page))
+			ctx = context.WithValue(ctx, IndexPageCtxtKey, &indexPage{entries: page, after: after, before: before})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SortByIndex keeps the order of the entries selected from the local index,
+// and replaces the pagination of the loaded collection with the one of the index
+func SortByIndex(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer next.ServeHTTP(w, r)
+
+		p := ContextIndexPage(r.Context())
+		if p == nil {
+			return
+		}
+		if c := ContextCursor(r.Context()); c != nil {
+			c.after = p.after
+			c.before = p.before
+		}
+		m := ContextListingModel(r.Context())
+		if m == nil {
+			return
+		}
+		positions := make(map[Hash]int, len(p.entries))
+		for i, e := range p.entries {
+			positions[e.Hash] = i
+		}
+		m.sortFn = func(list RenderableList) []Renderable {
+			rl := ByDate(list)
+			sort.SliceStable(rl, func(i, j int) bool {
+				pi, oki := positions[rl[i].ID()]
+				pj, okj := positions[rl[j].ID()]
+				if oki && okj {
+					return pi < pj
+				}
+				return oki
+			})
+			return rl
+		}
+	})
+}
+
+func topLevelEntries(*http.Request) func(indexEntry) bool {
+	return func(e indexEntry) bool {
+		return e.IsTop()
+	}
+}
+
+func byLastActivity(a, b indexEntry) bool {
+	return a.LastActivity().After(b.LastActivity())
+}
+
+// ActiveListingMw selects the threads with the most recent comments
+var ActiveListingMw = IndexListingMw("Recently active discussions", topLevelEntries, byLastActivity)
+
+// RefreshIndex walks the service's inbox and adds up to max items to the local index
+func (r *repository) RefreshIndex(ctx context.Context, max int) error {
+	next := ""
+	loaded := 0
+	for loaded < max {
+		f := new(Filters)
+		f.Type = CreateActivitiesFilter
+		f.Object = new(Filters)
+		f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+		f.MaxItems = MaxContentItems
+		f.Next = next
+		// NOTE(marius): ActorCollection adds the loaded items to the index
+		c, err := r.LoadActorInbox(ctx, r.fedbox.Service(), f)
+		if err != nil {
+			return err
+		}
+		loaded += len(c.items)
+		if len(c.items) == 0 || !c.after.IsValid() || c.after.String() == next {
+			break
+		}
+		next = c.after.String()
+	}
+	r.infoFn(log.Ctx{"loaded": loaded, "indexed": r.index.Len()})("local index refreshed")
+	return nil
+}
+
+// runIndexer refreshes the local index periodically
+func (r *repository) runIndexer(interval time.Duration, max int) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := r.RefreshIndex(context.Background(), max); err != nil {
+			r.errFn(log.Ctx{"err": err})("unable to refresh the local index")
+		}
+		<-t.C
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func testHash(i int) Hash {
+	return HashFromString(fmt.Sprintf("00000000-0000-0000-0000-%012d", i))
+}
+
+func testEntries(cnt int) []indexEntry {
+	entries := make([]indexEntry, cnt)
+	for i := range entries {
+		entries[i] = indexEntry{Hash: testHash(i + 1)}
+	}
+	return entries
+}
+
+func Test_pageEntries(t *testing.T) {
+	entries := testEntries(5)
+	tests := []struct {
+		name   string
+		after  Hash
+		before Hash
+		max    int
+		first  Hash
+		len    int
+		next   Hash
+		prev   Hash
+	}{
+		{
+			name:  "first page",
+			max:   2,
+			first: testHash(1),
+			len:   2,
+			next:  testHash(2),
+		},
+		{
+			name:  "after",
+			after: testHash(2),
+			max:   2,
+			first: testHash(3),
+			len:   2,
+			next:  testHash(4),
+			prev:  testHash(3),
+		},
+		{
+			name:  "last page",
+			after: testHash(4),
+			max:   2,
+			first: testHash(5),
+			len:   1,
+			prev:  testHash(5),
+		},
+		{
+			name:   "before",
+			before: testHash(3),
+			max:    2,
+			first:  testHash(1),
+			len:    2,
+			next:   testHash(2),
+		},
+		{
+			name:  "unknown hash starts from the beginning",
+			after: testHash(42),
+			max:   10,
+			first: testHash(1),
+			len:   5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, next, prev := pageEntries(entries, tt.after, tt.before, tt.max)
+			if len(page) != tt.len {
+				t.Fatalf("pageEntries() returned %d entries, want %d", len(page), tt.len)
+			}
+			if page[0].Hash != tt.first {
+				t.Errorf("pageEntries() first entry = %s, want %s", page[0].Hash, tt.first)
+			}
+			if next != tt.next {
+				t.Errorf("pageEntries() next = %s, want %s", next, tt.next)
+			}
+			if prev != tt.prev {
+				t.Errorf("pageEntries() prev = %s, want %s", prev, tt.prev)
+			}
+		})
+	}
+}
+
+func Test_localIndex_Add(t *testing.T) {
+	now := time.Now()
+	op := Item{Hash: testHash(1), SubmittedAt: now.Add(-time.Hour), Metadata: &ItemMetadata{ID: "https://example.com/objects/1"}}
+	op.OP = &op
+	reply := Item{Hash: testHash(2), SubmittedAt: now, OP: &op, Metadata: &ItemMetadata{ID: "https://example.com/objects/2"}}
+
+	l := newLocalIndex()
+	l.Add(reply)
+	if _, ok := l.Get(op.Hash); ok {
+		t.Errorf("OP placeholder should not be returned before the OP is indexed")
+	}
+	l.Add(op, reply)
+
+	e, ok := l.Get(op.Hash)
+	if !ok {
+		t.Fatalf("OP was not indexed")
+	}
+	if !e.IsTop() {
+		t.Errorf("OP entry should be top level")
+	}
+	if e.Replies != 1 {
+		t.Errorf("OP entry replies = %d, want 1", e.Replies)
+	}
+	if !e.LastActivity().Equal(now) {
+		t.Errorf("OP entry last activity = %s, want %s", e.LastActivity(), now)
+	}
+}
+
+func Test_localIndex_AddDeleted(t *testing.T) {
+	now := time.Now()
+	op := Item{Hash: testHash(1), SubmittedAt: now.Add(-time.Hour), Metadata: &ItemMetadata{ID: "https://example.com/objects/1"}}
+	op.OP = &op
+	reply := Item{Hash: testHash(2), SubmittedAt: now, OP: &op, Metadata: &ItemMetadata{ID: "https://example.com/objects/2"}}
+
+	l := newLocalIndex()
+	l.Add(op, reply)
+
+	reply.Delete()
+	l.Add(reply)
+	if _, ok := l.Get(reply.Hash); ok {
+		t.Errorf("deleted item should have been removed from the index")
+	}
+	if e, _ := l.Get(op.Hash); e.Replies != 0 {
+		t.Errorf("OP entry replies = %d, want 0", e.Replies)
+	}
+	l.Add(reply)
+	if e, _ := l.Get(op.Hash); e.Replies != 0 {
+		t.Errorf("OP entry replies = %d after indexing the deleted reply again, want 0", e.Replies)
+	}
+}
+
+func Test_localIndex_evict(t *testing.T) {
+	now := time.Now()
+	l := newLocalIndex()
+	for i := 1; i <= 10; i++ {
+		l.entries[testHash(i)] = &indexEntry{Hash: testHash(i), SubmittedAt: now.Add(time.Duration(i) * time.Minute)}
+	}
+	l.evict(3)
+	if l.Len() != 3 {
+		t.Fatalf("index size = %d, want 3", l.Len())
+	}
+	for i := 8; i <= 10; i++ {
+		if _, ok := l.entries[testHash(i)]; !ok {
+			t.Errorf("entry %d with recent activity should have been kept", i)
+		}
+	}
+}
+
+func Test_repository_tagCollection(t *testing.T) {
+	now := time.Now()
+	r := repository{index: newLocalIndex()}
+	for i := 1; i <= MaxContentItems+2; i++ {
+		e := indexEntry{
+			Hash:        testHash(i),
+			IRI:         pub.IRI(fmt.Sprintf("https://example.com/objects/%d", i)),
+			Public:      true,
+			Tags:        []string{"golang"},
+			SubmittedAt: now.Add(time.Duration(i) * time.Minute),
+		}
+		r.index.entries[e.Hash] = &e
+	}
+	other := indexEntry{Hash: testHash(1000), IRI: "https://example.com/objects/1000", Public: true, Tags: []string{"rust"}}
+	r.index.entries[other.Hash] = &other
+
+	col, ok := r.tagCollection("#GoLang", false, Hash{}).(*pub.OrderedCollection)
+	if !ok {
+		t.Fatalf("expected an OrderedCollection")
+	}
+	if col.TotalItems != uint(MaxContentItems+2) {
+		t.Errorf("total items = %d, want %d", col.TotalItems, MaxContentItems+2)
+	}
+	page, ok := r.tagCollection("golang", true, Hash{}).(*pub.OrderedCollectionPage)
+	if !ok {
+		t.Fatalf("expected an OrderedCollectionPage")
+	}
+	if len(page.OrderedItems) != MaxContentItems {
+		t.Fatalf("page items = %d, want %d", len(page.OrderedItems), MaxContentItems)
+	}
+	if newest := pub.IRI(fmt.Sprintf("https://example.com/objects/%d", MaxContentItems+2)); page.OrderedItems[0].GetLink() != newest {
+		t.Errorf("first item = %s, want the newest %s", page.OrderedItems[0].GetLink(), newest)
+	}
+	if page.Next == nil {
+		t.Errorf("expected a link to the next page")
+	}
+}
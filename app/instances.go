@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	peerSoftwareQueueSize = 50
+	peerSoftwareTimeOut   = 10 * time.Second
+	peerSoftwareTTL       = 24 * time.Hour
+)
+
+// Peer is a remote instance we've seen content from
+type Peer struct {
+	Host      string
+	Software  string
+	Version   string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Items     int
+	Accounts  int
+	Blocked   bool
+	Limited   bool
+
+	items     Hashes
+	accounts  Hashes
+	checkedAt time.Time
+}
+
+// peers keeps track of the remote instances we've seen in the collections loaded from fedbox
+type peers struct {
+	m       sync.RWMutex
+	hosts   map[string]*Peer
+	blocked []string
+	limited []string
+	queue   chan string
+	errFn   CtxLogFn
+}
+
+func newPeers(blocked, limited []string, errFn CtxLogFn) *peers {
+	return &peers{
+		hosts:   make(map[string]*Peer),
+		blocked: lowerStrings(blocked),
+		limited: lowerStrings(limited),
+		queue:   make(chan string, peerSoftwareQueueSize),
+		errFn:   errFn,
+	}
+}
+
+func lowerStrings(ss []string) []string {
+	result := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if s = strings.ToLower(strings.TrimSpace(s)); len(s) > 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// hostMatches returns true if the host is one of the hosts, or a subdomain of one of them
+func hostMatches(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBlocked returns if the admin blocked the instance hosting the IRI
+func (p *peers) IsBlocked(iri string) bool {
+	if p == nil {
+		return false
+	}
+	return hostMatches(strings.ToLower(host(iri)), p.blocked)
+}
+
+// IsLimited returns if the admin limited the instance hosting the IRI
+func (p *peers) IsLimited(iri string) bool {
+	if p == nil {
+		return false
+	}
+	return hostMatches(strings.ToLower(host(iri)), p.limited)
+}
+
+func (p *peers) filterItems(items ItemCollection) ItemCollection {
+	if p == nil || len(p.blocked) == 0 {
+		return items
+	}
+	result := make(ItemCollection, 0, len(items))
+	for _, it := range items {
+		if it.HasMetadata() && p.IsBlocked(it.Metadata.ID) {
+			continue
+		}
+		if it.SubmittedBy != nil && it.SubmittedBy.HasMetadata() && p.IsBlocked(it.SubmittedBy.Metadata.ID) {
+			continue
+		}
+		result = append(result, it)
+	}
+	return result
+}
+
+func (p *peers) filterAccounts(accounts AccountCollection) AccountCollection {
+	if p == nil || len(p.blocked) == 0 {
+		return accounts
+	}
+	result := make(AccountCollection, 0, len(accounts))
+	for _, a := range accounts {
+		if a.HasMetadata() && p.IsBlocked(a.Metadata.ID) {
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+func (p *peers) peer(iri string) *Peer {
+	h := strings.ToLower(host(iri))
+	if len(h) == 0 || HostIsLocal(iri) {
+		return nil
+	}
+	pp, ok := p.hosts[h]
+	if !ok {
+		pp = &Peer{
+			Host:      h,
+			FirstSeen: time.Now(),
+			Blocked:   hostMatches(h, p.blocked),
+			Limited:   hostMatches(h, p.limited),
+		}
+		p.hosts[h] = pp
+	}
+	pp.LastSeen = time.Now()
+	if time.Since(pp.checkedAt) > peerSoftwareTTL {
+		select {
+		case p.queue <- h:
+			pp.checkedAt = time.Now()
+		default:
+		}
+	}
+	return pp
+}
+
+// Seen records the federated items and accounts
+func (p *peers) Seen(items ItemCollection, accounts AccountCollection) {
+	if p == nil {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, it := range items {
+		if !it.HasMetadata() || !it.IsFederated() {
+			continue
+		}
+		if pp := p.peer(it.Metadata.ID); pp != nil && !pp.items.Contains(it.Hash) {
+			pp.items = append(pp.items, it.Hash)
+			pp.Items = len(pp.items)
+		}
+	}
+	for _, a := range accounts {
+		if !a.HasMetadata() || !a.IsFederated() {
+			continue
+		}
+		if pp := p.peer(a.Metadata.ID); pp != nil && !pp.accounts.Contains(a.Hash) {
+			pp.accounts = append(pp.accounts, a.Hash)
+			pp.Accounts = len(pp.accounts)
+		}
+	}
+}
+
+// List returns the instances we've seen, the most recently seen first
+func (p *peers) List() []Peer {
+	result := make([]Peer, 0)
+	if p == nil {
+		return result
+	}
+	p.m.RLock()
+	for _, pp := range p.hosts {
+		result = append(result, *pp)
+	}
+	p.m.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+	return result
+}
+
+type nodeInfoLinks struct {
+	Links []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+func getJSON(ctx context.Context, c *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("invalid response status %d for %s", res.StatusCode, u)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// loadSoftware uses NodeInfo for finding what software the instance is running
+func loadSoftware(ctx context.Context, c *http.Client, host string) (string, string, error) {
+	links := nodeInfoLinks{}
+	wk := url.URL{Scheme: "https", Host: host, Path: "/.well-known/nodeinfo"}
+	if err := getJSON(ctx, c, wk.String(), &links); err != nil {
+		return "", "", err
+	}
+	for _, l := range links.Links {
+		if !strings.HasPrefix(l.Rel, "http://nodeinfo.diaspora.software/ns/schema/") {
+			continue
+		}
+		ni := struct {
+			Software nodeInfoSoftware `json:"software"`
+		}{}
+		if err := getJSON(ctx, c, l.Href, &ni); err != nil {
+			return "", "", err
+		}
+		return ni.Software.Name, ni.Software.Version, nil
+	}
+	return "", "", errors.Errorf("no NodeInfo links found for %s", host)
+}
+
+// run loads the software of the instances we've seen, until the queue gets closed
+func (p *peers) run() {
+	c := &http.Client{Timeout: peerSoftwareTimeOut}
+	for h := range p.queue {
+		name, version, err := loadSoftware(context.Background(), c, h)
+		if err != nil {
+			p.errFn(log.Ctx{"host": h, "err": err})("unable to load instance software")
+			continue
+		}
+		p.m.Lock()
+		if pp, ok := p.hosts[h]; ok {
+			pp.Software = name
+			pp.Version = version
+		}
+		p.m.Unlock()
+	}
+}
+
+// HideLimitedInstancesMw removes from the listing the items coming from the instances the admin limited
+func HideLimitedInstancesMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer next.ServeHTTP(w, r)
+
+		c := ContextCursor(r.Context())
+		repo := ContextRepository(r.Context())
+		if c == nil || repo == nil || len(repo.peers.limited) == 0 {
+			return
+		}
+		items := make(RenderableList)
+		for h, ren := range c.items {
+			if it, ok := ren.(*Item); ok && it.HasMetadata() && repo.peers.IsLimited(it.Metadata.ID) {
+				continue
+			}
+			items[h] = ren
+		}
+		c.items = items
+		c.total = uint(len(items))
+	})
+}
+
+// HandleInstances serves /instances request
+func (h *handler) HandleInstances(w http.ResponseWriter, r *http.Request) {
+	m := &instancesModel{Title: "Known instances", Peers: h.storage.peers.List()}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandlePeers serves /api/v1/instance/peers request
+// It's the Mastodon compatible list of the domains of the instances we've seen, except the blocked ones
+func (h *handler) HandlePeers(w http.ResponseWriter, r *http.Request) {
+	hosts := make([]string, 0)
+	for _, p := range h.storage.peers.List() {
+		if !p.Blocked {
+			hosts = append(hosts, p.Host)
+		}
+	}
+	sort.Strings(hosts)
+	dat, _ := json.Marshal(hosts)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
@@ -13,17 +13,22 @@ import (
 )
 
 type ItemMetadata struct {
-	To         AccountCollection `json:"to,omitempty"`
-	CC         AccountCollection `json:"to,omitempty"`
-	Tags       TagCollection     `json:"tags,omitempty"`
-	Mentions   TagCollection     `json:"mentions,omitempty"`
-	ID         string            `json:"id,omitempty"`
-	URL        string            `json:"url,omitempty"`
-	RepliesURI string            `json:"replies,omitempty"`
-	LikesURI   string            `json:"likes,omitempty"`
-	SharesURI  string            `json:"shares,omitempty"`
-	AuthorURI  string            `json:"author,omitempty"`
-	Icon       ImageMetadata     `json:"icon,omitempty"`
+	To         AccountCollection   `json:"to,omitempty"`
+	CC         AccountCollection   `json:"to,omitempty"`
+	Tags       TagCollection       `json:"tags,omitempty"`
+	Mentions   TagCollection       `json:"mentions,omitempty"`
+	ID         string              `json:"id,omitempty"`
+	URL        string              `json:"url,omitempty"`
+	RepliesURI string              `json:"replies,omitempty"`
+	LikesURI   string              `json:"likes,omitempty"`
+	SharesURI  string              `json:"shares,omitempty"`
+	AuthorURI  string              `json:"author,omitempty"`
+	Icon       ImageMetadata       `json:"icon,omitempty"`
+	Lang       string              `json:"lang,omitempty"`
+	Board      string              `json:"board,omitempty"`
+	Event      *EventMetadata      `json:"event,omitempty"`
+	Classified *ClassifiedMetadata `json:"classified,omitempty"`
+	Job        *JobMetadata        `json:"job,omitempty"`
 }
 
 var ValidContentTypes = pub.ActivityVocabularyTypes{
@@ -34,6 +39,15 @@ var ValidContentTypes = pub.ActivityVocabularyTypes{
 	pub.DocumentType,
 	pub.VideoType,
 	pub.AudioType,
+	pub.EventType,
+}
+
+// FederatedContentTypes are the object types shown in the federated listing
+var FederatedContentTypes = pub.ActivityVocabularyTypes{
+	pub.ArticleType,
+	pub.LinkType,
+	pub.PageType,
+	pub.EventType,
 }
 
 var ValidContentManagementTypes = pub.ActivityVocabularyTypes{
@@ -116,10 +130,6 @@ func (i ItemCollection) First() (*Item, error) {
 	return nil, errors.Errorf("empty %T", i)
 }
 
-const (
-	MaxContentItems = 35
-)
-
 func detectMimeType(data string) string {
 	u, err := url.ParseRequestURI(data)
 	if err == nil && u != nil && !bytes.ContainsRune([]byte(data), '\n') {
@@ -175,6 +185,9 @@ func updateItemFromRequest(r *http.Request, author Account, i *Item) error {
 			i.Parent = &Item{Hash: parent}
 		}
 	}
+	if board := r.PostFormValue("board"); len(board) > 0 && i.Parent == nil {
+		i.Metadata.Board = board
+	}
 	if op := HashFromString(r.PostFormValue("op")); op.IsValid() {
 		if i.OP != nil || i.OP.Hash != op {
 			i.OP = &Item{Hash: op}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// jobTag is the hashtag of the job postings
+	jobTag = "jobs"
+
+	maxJobFieldLength = 128
+
+	jobCompanyField  = "Company"
+	jobLocationField = "Location"
+	jobRemoteField   = "Remote"
+	jobApplyField    = "Apply"
+	jobExpiresField  = "Expires"
+)
+
+// JobMetadata are the details of the job postings. Like the classifieds, they stay Notes and Articles
+// for the other servers, with the details in PropertyValue attachments and in the content.
+type JobMetadata struct {
+	Company   string    `json:"company"`
+	Location  string    `json:"location,omitempty"`
+	Remote    bool      `json:"remote,omitempty"`
+	Apply     string    `json:"apply"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired returns if the job posting is archived
+func (j JobMetadata) Expired() bool {
+	return !j.ExpiresAt.IsZero() && time.Now().After(j.ExpiresAt)
+}
+
+// IsJob returns if the item is a job posting
+func (i *Item) IsJob() bool {
+	return i.HasMetadata() && i.Metadata.Job != nil
+}
+
+// validApplyLink returns if s is a link where the candidates can apply, a web page or an email address
+func validApplyLink(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return len(u.Host) > 0
+	case "mailto":
+		return len(u.Opaque) > 0
+	}
+	return false
+}
+
+// jobFromRequest loads the job details from the submission form, it returns nil if they're missing
+func jobFromRequest(r *http.Request, days int) (*JobMetadata, error) {
+	company := strings.TrimSpace(r.PostFormValue("job-company"))
+	if len(company) == 0 {
+		return nil, nil
+	}
+	j := JobMetadata{
+		Company:   company,
+		Location:  strings.TrimSpace(r.PostFormValue("job-location")),
+		Apply:     strings.TrimSpace(r.PostFormValue("job-apply")),
+		ExpiresAt: time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour).Truncate(time.Second),
+	}
+	j.Remote, _ = strconv.ParseBool(r.PostFormValue("job-remote"))
+	if len(j.Company) > maxJobFieldLength || len(j.Location) > maxJobFieldLength {
+		return nil, errors.BadRequestf("the company and the location can have at most %d characters", maxJobFieldLength)
+	}
+	if !validApplyLink(j.Apply) {
+		return nil, errors.BadRequestf("the job posting needs a link or an email address where to apply")
+	}
+	return &j, nil
+}
+
+// jobFromAttachment loads the job details from the PropertyValue attachments of an object
+func jobFromAttachment(att pub.Item) *JobMetadata {
+	var j JobMetadata
+	for _, f := range propertyValuesFromAttachment(att, maxItemPropertyValues) {
+		switch f.Name {
+		case jobCompanyField:
+			j.Company = f.Value
+		case jobLocationField:
+			j.Location = f.Value
+		case jobRemoteField:
+			j.Remote, _ = strconv.ParseBool(f.Value)
+		case jobApplyField:
+			if validApplyLink(f.Value) {
+				j.Apply = f.Value
+			}
+		case jobExpiresField:
+			j.ExpiresAt, _ = time.Parse(time.RFC3339, f.Value)
+		}
+	}
+	if len(j.Company) == 0 || len(j.Apply) == 0 {
+		return nil
+	}
+	return &j
+}
+
+// loadAPJob sets the job details on the ActivityPub object
+func loadAPJob(o *pub.Object, j *JobMetadata) {
+	fields := []ProfileField{{Name: jobCompanyField, Value: j.Company}}
+	if len(j.Location) > 0 {
+		fields = append(fields, ProfileField{Name: jobLocationField, Value: j.Location})
+	}
+	fields = append(fields, ProfileField{Name: jobRemoteField, Value: strconv.FormatBool(j.Remote)})
+	fields = append(fields, ProfileField{Name: jobApplyField, Value: j.Apply})
+	if !j.ExpiresAt.IsZero() {
+		fields = append(fields, ProfileField{Name: jobExpiresField, Value: j.ExpiresAt.Format(time.RFC3339)})
+	}
+	o.Attachment = profileFieldsAttachment(fields)
+
+	if content := o.Content.First().Value; len(content) > 0 && len(o.Source.Content) > 0 {
+		o.Content.Set(o.Content.First().Ref, pub.Content(jobHTML(j)+content.String()))
+	}
+	appendAPHashtag(o, jobTag)
+}
+
+// jobHTML is the line with the job details which the other servers show before the content
+func jobHTML(j *JobMetadata) string {
+	s := fmt.Sprintf("<p><strong>%s:</strong> %s", jobCompanyField, stdhtml.EscapeString(j.Company))
+	if len(j.Location) > 0 {
+		s += fmt.Sprintf(", <strong>%s:</strong> %s", jobLocationField, stdhtml.EscapeString(j.Location))
+	}
+	if j.Remote {
+		s += " (remote)"
+	}
+	apply := stdhtml.EscapeString(j.Apply)
+	return s + fmt.Sprintf(`<br/><a href="%s" rel="nofollow noopener noreferrer">Apply</a></p>`, apply)
+}
+
+// activeJob returns if the item is a job posting which didn't expire, for the /jobs listing
+func activeJob(i *Item) bool {
+	return i.IsJob() && !i.Metadata.Job.Expired()
+}
+
+// archivedItem returns if the item is a classified or a job posting which expired, they can't be commented on anymore
+func archivedItem(i *Item) bool {
+	if i.IsClassified() && i.Metadata.Classified.Expired() {
+		return true
+	}
+	return i.IsJob() && i.Metadata.Job.Expired()
+}
+
+// canPostJob returns if acc can publish job postings right away: the moderators, and the accounts
+// old enough, and with a good enough score of their recent submissions
+func (h *handler) canPostJob(ctx context.Context, c *config.Configuration, acc *Account) (bool, string) {
+	if !acc.IsLogged() {
+		return false, "You need to be logged in to post jobs."
+	}
+	if isModerator(c, acc) {
+		return true, ""
+	}
+	if c.JobsAccountAge > 0 && (acc.CreatedAt.IsZero() || time.Since(acc.CreatedAt) < c.JobsAccountAge) {
+		age := fmtWait(c.JobsAccountAge)
+		if days := int(c.JobsAccountAge / (24 * time.Hour)); days > 0 {
+			age = fmt.Sprintf("%d %s", days, pluralize(float64(days), "day"))
+		}
+		return false, fmt.Sprintf("Your account needs to be older than %s to post jobs.", age)
+	}
+	if c.JobsKarma > 0 {
+		karma, err := h.storage.accountKarma(ctx, acc)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to load the karma of the account")
+			return false, "Unable to check the score of your submissions."
+		}
+		if karma < c.JobsKarma {
+			return false, fmt.Sprintf("You need a score of %d for your recent submissions to post jobs.", c.JobsKarma)
+		}
+	}
+	return true, ""
+}
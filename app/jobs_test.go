@@ -0,0 +1,72 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestJobFromRequest(t *testing.T) {
+	tests := map[string]struct {
+		form    url.Values
+		want    *JobMetadata
+		wantErr bool
+	}{
+		"not a job": {form: url.Values{"data": {"text"}}},
+		"remote job": {
+			form: url.Values{"job-company": {" ACME "}, "job-remote": {"true"}, "job-apply": {"https://acme.example/careers"}},
+			want: &JobMetadata{Company: "ACME", Remote: true, Apply: "https://acme.example/careers"},
+		},
+		"email": {
+			form: url.Values{"job-company": {"ACME"}, "job-location": {"Bucharest"}, "job-apply": {"mailto:jobs@acme.example"}},
+			want: &JobMetadata{Company: "ACME", Location: "Bucharest", Apply: "mailto:jobs@acme.example"},
+		},
+		"no apply link":      {form: url.Values{"job-company": {"ACME"}}, wantErr: true},
+		"invalid apply link": {form: url.Values{"job-company": {"ACME"}, "job-apply": {"javascript:alert(1)"}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got, err := jobFromRequest(r, 60)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if got == nil {
+				return
+			}
+			if got.ExpiresAt.IsZero() || got.Expired() {
+				t.Errorf("expected the job posting to expire in the future, got %s", got.ExpiresAt)
+			}
+			got.ExpiresAt = time.Time{}
+			if *got != *tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadAPJob(t *testing.T) {
+	j := &JobMetadata{Company: "ACME", Location: "Bucharest", Remote: true, Apply: "https://acme.example/careers", ExpiresAt: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)}
+	o := pub.ObjectNew(pub.NoteType)
+	loadAPJob(o, j)
+
+	if len(o.Tag) != 1 || tagName(o.Tag[0]) != "#"+jobTag {
+		t.Errorf("expected the #%s tag, got %v", jobTag, o.Tag)
+	}
+	got := jobFromAttachment(o.Attachment)
+	if got == nil || *got != *j {
+		t.Errorf("expected %v, got %v", j, got)
+	}
+	if !archivedItem(&Item{Metadata: &ItemMetadata{Job: got}}) {
+		t.Errorf("expected the expired job posting to be archived")
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+	jsonFeedSuffix  = ".json"
+)
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	ExternalURL   string           `json:"external_url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	ContentText   string           `json:"content_text,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Language      string           `json:"language,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	NextURL     string         `json:"next_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+func jsonFeedItemFromItem(baseURL string, i Item) jsonFeedItem {
+	link := absoluteURL(baseURL, ItemPermaLink(&i))
+	e := jsonFeedItem{
+		ID:            link,
+		URL:           link,
+		Title:         i.Title,
+		DatePublished: i.SubmittedAt.UTC().Format(time.RFC3339),
+		Tags:          indexEntryFromItem(i).Tags,
+	}
+	if i.HasMetadata() {
+		if len(i.Metadata.ID) > 0 {
+			e.ID = i.Metadata.ID
+		}
+		e.Language = i.Metadata.Lang
+	}
+	if !i.UpdatedAt.IsZero() {
+		e.DateModified = i.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	if i.IsLink() {
+		e.ExternalURL = i.Data
+	}
+	if c := atomContent(i); c.Type == "html" {
+		e.ContentHTML = c.Body
+	} else {
+		e.ContentText = c.Body
+	}
+	if a := i.SubmittedBy; a != nil && len(a.Handle) > 0 {
+		e.Authors = []jsonFeedAuthor{{
+			Name:   feedAuthorName(a),
+			URL:    absoluteURL(baseURL, AccountPermaLink(a)),
+			Avatar: baseURL + AccountLocalLink(a) + "/avatar",
+		}}
+	}
+	return e
+}
+
+// writeJSONFeed renders the items of the listing as a JSON Feed, the next_url is the next page of the listing
+func (h *handler) writeJSONFeed(w http.ResponseWriter, r *http.Request, m *listingModel) {
+	base := h.storage.SelfURL
+	items := m.feedItems()
+	feed := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       h.listingFeedTitle(m),
+		HomePageURL: base + r.URL.Path,
+		FeedURL:     base + listingFeedURL(r, feedFormatJSON),
+		Items:       make([]jsonFeedItem, 0, len(items)),
+	}
+	if next := m.NextPage(); next.IsValid() {
+		q := r.URL.Query()
+		q.Del("before")
+		q.Set("after", next.String())
+		q.Set(feedFormatParam, feedFormatJSON)
+		feed.NextURL = base + r.URL.Path + "?" + q.Encode()
+	}
+	for _, it := range items {
+		feed.Items = append(feed.Items, jsonFeedItemFromItem(base, it))
+	}
+	data, err := json.Marshal(feed)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", JSONFeedContentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write(data)
+}
+
+// jsonFeedPath returns the path of the listing for the paths with the .json suffix, /index.json is the front page
+func jsonFeedPath(p string) (string, bool) {
+	if !strings.HasSuffix(p, jsonFeedSuffix) {
+		return "", false
+	}
+	base := strings.TrimSuffix(p, jsonFeedSuffix)
+	if base == "/index" {
+		return "/", true
+	}
+	for _, prefix := range []string{"/~", "/t/", "/d/"} {
+		if name := strings.TrimPrefix(base, prefix); name != base && len(name) > 0 && !strings.Contains(name, "/") {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// JSONFeedSuffixMw routes the listings with the .json suffix, like /t/golang.json, to the listing, asking for its JSON Feed
+func JSONFeedSuffixMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := jsonFeedPath(r.URL.Path); ok {
+			r.URL.Path = p
+			r.URL.RawPath = ""
+			q := r.URL.Query()
+			q.Set(feedFormatParam, feedFormatJSON)
+			r.URL.RawQuery = q.Encode()
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePath) > 0 {
+				rctx.RoutePath = p
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
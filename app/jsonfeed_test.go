@@ -0,0 +1,22 @@
+package app
+
+import "testing"
+
+func TestJSONFeedPath(t *testing.T) {
+	tests := map[string]string{
+		"/index.json":           "/",
+		"/~alice.json":          "/~alice",
+		"/t/golang.json":        "/t/golang",
+		"/d/example.com.json":   "/d/example.com",
+		"/ns.json":              "",
+		"/~alice/settings.json": "",
+		"/t/.json":              "",
+		"/t/golang":             "",
+	}
+	for p, want := range tests {
+		got, ok := jsonFeedPath(p)
+		if ok != (len(want) > 0) || got != want {
+			t.Errorf("%s: expected %q, got %q", p, want, got)
+		}
+	}
+}
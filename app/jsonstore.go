@@ -0,0 +1,68 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-ap/errors"
+)
+
+// loadJSON decodes the file at path into v, a missing file leaves v as it is.
+// The stores with an empty path are kept only in memory, which is what the tests use.
+func loadJSON(path string, v interface{}) error {
+	if len(path) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON encodes v to the file at path, creating the data directory if needed
+func saveJSON(path string, v interface{}) error {
+	if len(path) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Annotatef(err, "unable to create the data directory")
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, and renames it over path when it's done,
+// so a crash while writing leaves the previous contents in place instead of a truncated file
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
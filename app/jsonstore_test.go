@@ -0,0 +1,74 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type store struct {
+		Names map[string]int `json:"names"`
+	}
+	path := filepath.Join(dir, "data", "store.json")
+
+	missing := store{Names: map[string]int{"kept": 1}}
+	if err := loadJSON(path, &missing); err != nil || missing.Names["kept"] != 1 {
+		t.Fatalf("expected the missing file to leave the store as it is, got %v %v", missing, err)
+	}
+	if err := saveJSON(path, store{Names: map[string]int{"jane": 1, "john": 2}}); err != nil {
+		t.Fatalf("unable to save the store: %s", err)
+	}
+	if err := saveJSON(path, store{Names: map[string]int{"jane": 3}}); err != nil {
+		t.Fatalf("unable to save the store: %s", err)
+	}
+	loaded := store{Names: make(map[string]int)}
+	if err := loadJSON(path, &loaded); err != nil {
+		t.Fatalf("unable to load the store: %s", err)
+	}
+	if len(loaded.Names) != 1 || loaded.Names["jane"] != 3 {
+		t.Errorf("expected the last saved store, got %v", loaded.Names)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the store to be readable only by its owner, got %s", fi.Mode())
+	}
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Errorf("expected the temporary files to be removed, got %d files", len(files))
+	}
+
+	// NOTE(marius): the failed saves keep the previous contents
+	if err := saveJSON(path, map[string]interface{}{"invalid": func() {}}); err == nil {
+		t.Errorf("expected the value which can't be encoded to fail")
+	}
+	if err := loadJSON(path, &loaded); err != nil || loaded.Names["jane"] != 3 {
+		t.Errorf("expected the previous store after the failed save, got %v %v", loaded.Names, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadJSON(path, &loaded); err == nil {
+		t.Errorf("expected the invalid file to fail loading")
+	}
+
+	mem := store{Names: map[string]int{"jane": 1}}
+	if err := saveJSON("", mem); err != nil {
+		t.Errorf("expected the in memory store to save, got %s", err)
+	}
+	if err := loadJSON("", &mem); err != nil || mem.Names["jane"] != 1 {
+		t.Errorf("expected the in memory store to be kept, got %v %v", mem, err)
+	}
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+const (
+	DefaultItemsPerPage    = 35
+	DefaultMaxItemsPerPage = 100
+	DefaultMaxCommentDepth = 12
+)
+
+var (
+	// MaxContentItems is the number of items we load for a page, when the request doesn't ask for fewer
+	MaxContentItems = DefaultItemsPerPage
+	// MaxItemsPerPage is the largest number of items a request can ask for with the maxItems parameter
+	MaxItemsPerPage = DefaultMaxItemsPerPage
+	// MaxCommentDepth is the level of the comments after which we link to the rest of the thread,
+	// instead of rendering it, 0 renders the whole thread
+	MaxCommentDepth = DefaultMaxCommentDepth
+)
+
+// setPageLimits loads the page limits from the configuration, the invalid values keep the defaults
+func setPageLimits(c *config.Configuration) {
+	if c.MaxItemsPerPage > 0 {
+		MaxItemsPerPage = c.MaxItemsPerPage
+	}
+	if c.ItemsPerPage > 0 {
+		MaxContentItems = c.ItemsPerPage
+	}
+	if MaxContentItems > MaxItemsPerPage {
+		MaxContentItems = MaxItemsPerPage
+	}
+	if c.MaxCommentDepth >= 0 {
+		MaxCommentDepth = c.MaxCommentDepth
+	}
+}
+
+// clampItems returns the number of items a request asked for, limited to MaxItemsPerPage
+func clampItems(n int) int {
+	if n <= 0 {
+		return MaxContentItems
+	}
+	if n > MaxItemsPerPage {
+		return MaxItemsPerPage
+	}
+	return n
+}
+
+// commentDepth returns the depth of the comment threads for the request, it can ask for shallower threads
+// with the depth parameter, but not for deeper ones than the instance allows
+func commentDepth(r *http.Request) int {
+	depth := MaxCommentDepth
+	if r == nil {
+		return depth
+	}
+	d, err := strconv.Atoi(r.URL.Query().Get("depth"))
+	if err != nil || d <= 0 {
+		return depth
+	}
+	if depth > 0 && d > depth {
+		return depth
+	}
+	return d
+}
+
+// pageLimit is an instance limit, with what it costs to raise it, shown in the admin dashboard
+type pageLimit struct {
+	Name    string
+	Env     string
+	Value   int
+	Default int
+	Notes   string
+}
+
+func pageLimits() []pageLimit {
+	return []pageLimit{
+		{
+			Name:    "Items per page",
+			Env:     config.KeyItemsPerPage,
+			Value:   MaxContentItems,
+			Default: DefaultItemsPerPage,
+			Notes: "Every item of a listing needs its author, votes and replies count loaded from the storage. " +
+				"The rendering time grows linearly with it, up to 50 the pages usually render in under 200ms.",
+		},
+		{
+			Name:    "Maximum items per page",
+			Env:     config.KeyMaxItemsPerPage,
+			Value:   MaxItemsPerPage,
+			Default: DefaultMaxItemsPerPage,
+			Notes: "The most items a request can ask for with ?maxItems=. Over 200 the storage queries " +
+				"start to time out, and the account sessions store a lot more votes.",
+		},
+		{
+			Name:    "Maximum comment depth",
+			Env:     config.KeyMaxCommentDepth,
+			Value:   MaxCommentDepth,
+			Default: DefaultMaxCommentDepth,
+			Notes: "The deeper threads are linked instead of rendered. The comments are loaded regardless, " +
+				"so it affects the size of the pages and the rendering time, not the storage load. " +
+				"0 renders the whole thread, which can make long discussions very large.",
+		},
+	}
+}
+
+type adminModel struct {
+	Title   string
+	Limits  []pageLimit
+	Bots    int
+	Reasons int
+	// Media is the disk space the media files use, with the accounts using the most of it
+	Media             int64
+	MediaQuota        int64
+	AccountMediaQuota int64
+	MediaAccounts     []accountUsage
+}
+
+func (m *adminModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (adminModel) Template() string {
+	return "admin"
+}
+
+// HandleAdmin serves the /admin requests, with the instance limits and the links to the other admin pages
+func (h *handler) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	m := &adminModel{
+		Title:   "Administration",
+		Limits:  pageLimits(),
+		Bots:    len(h.storage.bots.List()),
+		Reasons: len(h.storage.reasons.List()),
+
+		Media:             h.storage.usage.Used(),
+		MediaQuota:        h.storage.usage.Quota(),
+		AccountMediaQuota: h.conf.AccountMediaQuota,
+		MediaAccounts:     h.storage.usage.Accounts(mediaUsageTopAccounts),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	linkSnapshotsDir = "snapshots"
+
+	linkCheckTTL      = 6 * time.Hour
+	linkCheckTimeOut  = 10 * time.Second
+	linkCheckMaxCache = 5000
+)
+
+// linkSnapshotHeaders are the response headers we keep in the snapshots
+var linkSnapshotHeaders = []string{"Content-Type", "Content-Language", "Last-Modified", "Date", "Server"}
+
+// linkSnapshot is the text of the page a link item pointed to, when it was submitted
+type linkSnapshot struct {
+	URL     string            `json:"url"`
+	Title   string            `json:"title,omitempty"`
+	Blocks  []readerBlock     `json:"blocks,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	At      time.Time         `json:"at"`
+}
+
+type linkStatus struct {
+	code int
+	at   time.Time
+}
+
+// linkSnapshots keeps the gzipped snapshots of the submitted pages in the data directory, and whether the pages
+// are still available, so we can offer the snapshot when the link rots
+type linkSnapshots struct {
+	m       sync.RWMutex
+	enabled bool
+	path    string
+	reader  *articleReader
+	status  map[Hash]linkStatus
+	// usage counts the snapshots in the media storage of the accounts which submitted the items
+	usage *mediaUsage
+}
+
+func newLinkSnapshots(enabled bool, path string, reader *articleReader, usage *mediaUsage) *linkSnapshots {
+	return &linkSnapshots{enabled: enabled, path: path, reader: reader, status: make(map[Hash]linkStatus), usage: usage}
+}
+
+func (l *linkSnapshots) file(h Hash) string {
+	return filepath.Join(l.path, fmt.Sprintf("%s.json.gz", h))
+}
+
+// usageName is the name of the snapshot of the item with hash h in the media usage, relative to the data directory
+func (l *linkSnapshots) usageName(h Hash) string {
+	return filepath.ToSlash(filepath.Join(linkSnapshotsDir, fmt.Sprintf("%s.json.gz", h)))
+}
+
+// Exists returns if we have a snapshot for the item with hash h
+func (l *linkSnapshots) Exists(h Hash) bool {
+	if l == nil || !h.IsValid() {
+		return false
+	}
+	_, err := os.Stat(l.file(h))
+	return err == nil
+}
+
+// Load returns the snapshot of the item with hash h
+func (l *linkSnapshots) Load(h Hash) (linkSnapshot, error) {
+	s := linkSnapshot{}
+	if !l.Exists(h) {
+		return s, errors.NotFoundf("snapshot")
+	}
+	f, err := os.Open(l.file(h))
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return s, err
+	}
+	defer z.Close()
+	return s, json.NewDecoder(z).Decode(&s)
+}
+
+// save writes the snapshot of the item with hash h, when it fits in the media quotas of account
+func (l *linkSnapshots) save(h Hash, s linkSnapshot, account string) error {
+	buf := new(bytes.Buffer)
+	z := gzip.NewWriter(buf)
+	if err := json.NewEncoder(z).Encode(s); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	name := l.usageName(h)
+	if err := l.usage.Allow(name, account, int64(buf.Len())); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.path, 0700); err != nil {
+		return errors.Annotatef(err, "unable to create the snapshots directory")
+	}
+	if err := writeFileAtomic(l.file(h), buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return l.usage.Add(name, account, h, int64(buf.Len()))
+}
+
+// Take loads the page at u, and saves its text as the snapshot of the item with hash h, submitted by account
+func (l *linkSnapshots) Take(ctx context.Context, h Hash, u, account string) error {
+	if l == nil || !l.enabled || l.reader.OptedOut(u) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readerLoadTimeOut)
+	defer cancel()
+	art, res, err := fetchArticle(ctx, l.reader.client, u)
+	if err != nil {
+		return err
+	}
+	if len(art.Blocks) == 0 {
+		return nil
+	}
+	s := linkSnapshot{URL: u, Title: art.Title, Blocks: art.Blocks, Status: res.StatusCode, At: time.Now().UTC()}
+	s.Headers = make(map[string]string)
+	for _, k := range linkSnapshotHeaders {
+		if v := res.Header.Get(k); len(v) > 0 {
+			s.Headers[k] = v
+		}
+	}
+	return l.save(h, s, account)
+}
+
+// Gone returns if the page at u, which the item with hash h links to, was missing the last time we checked.
+// The check runs in the background, so the first call for an item always returns false.
+func (l *linkSnapshots) Gone(h Hash, u string) bool {
+	if l == nil || !l.enabled {
+		return false
+	}
+	l.m.RLock()
+	st, ok := l.status[h]
+	l.m.RUnlock()
+	if ok && time.Since(st.at) < linkCheckTTL {
+		return st.code == http.StatusNotFound || st.code == http.StatusGone
+	}
+
+	l.m.Lock()
+	if len(l.status) >= linkCheckMaxCache {
+		l.status = make(map[Hash]linkStatus)
+	}
+	// NOTE(marius): the entry makes the other requests skip the check until this one finishes
+	l.status[h] = linkStatus{code: st.code, at: time.Now()}
+	l.m.Unlock()
+	go func() {
+		code := checkLink(l.reader.client, u)
+		l.m.Lock()
+		l.status[h] = linkStatus{code: code, at: time.Now()}
+		l.m.Unlock()
+	}()
+	return st.code == http.StatusNotFound || st.code == http.StatusGone
+}
+
+// checkLink returns the status code of the page at u, or 0 when it couldn't be loaded
+func checkLink(c *http.Client, u string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), linkCheckTimeOut)
+	defer cancel()
+	code := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return 0
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			return 0
+		}
+		res.Body.Close()
+		// NOTE(marius): some servers don't implement HEAD requests, so we retry those with a GET
+		if code = res.StatusCode; code != http.StatusMethodNotAllowed && code != http.StatusNotImplemented {
+			break
+		}
+	}
+	return code
+}
+
+// snapshotLink saves the snapshot of the page the newly submitted item links to
+func (r *repository) snapshotLink(it Item) {
+	if err := r.linkSnaps.Take(context.Background(), it.Hash, it.Data, accountIRI(it.SubmittedBy).String()); err != nil {
+		r.errFn(log.Ctx{"err": err, "hash": it.Hash, "url": it.Data})("unable to save the snapshot of the page")
+	}
+}
+
+type snapshotModel struct {
+	Title    string
+	Hash     Hash
+	Content  *Item
+	Snapshot linkSnapshot
+}
+
+func (m *snapshotModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (snapshotModel) Template() string {
+	return "snapshot"
+}
+
+func (m *snapshotModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleSnapshot serves the /{hash}/snapshot requests, with the text of the linked page from when it was submitted
+func (h *handler) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	m := &snapshotModel{Hash: HashFromString(chi.URLParam(r, "hash"))}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || !m.Content.IsLink() || m.Content.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("snapshot"))
+		return
+	}
+	s, err := h.storage.linkSnaps.Load(m.Hash)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			h.errFn(log.Ctx{"err": err, "hash": m.Hash})("unable to load the snapshot")
+		}
+		h.v.HandleErrors(w, r, errors.NotFoundf("snapshot"))
+		return
+	}
+	m.Snapshot = s
+	m.Title = fmt.Sprintf("Snapshot: %s", m.Content.Title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	RSSContentType      = "application/rss+xml"
+	AtomContentType     = "application/atom+xml"
+	JSONFeedContentType = "application/feed+json"
+
+	feedFormatRSS  = "rss"
+	feedFormatAtom = "atom"
+	feedFormatJSON = "json"
+	// feedFormatParam is the URL parameter selecting the feed format, for the readers which don't send an Accept header
+	feedFormatParam = "format"
+)
+
+// feedFormats are the content types of the feeds of the listings, by their format
+var feedFormats = map[string]string{
+	feedFormatRSS:  RSSContentType,
+	feedFormatAtom: AtomContentType,
+	feedFormatJSON: JSONFeedContentType,
+}
+
+// listingFeedFormat returns the feed format the request asks for, or an empty string for the HTML page.
+// The format URL parameter wins over the Accept header, where the first of HTML or a feed type wins.
+func listingFeedFormat(r *http.Request) string {
+	if f := r.URL.Query().Get(feedFormatParam); len(f) > 0 {
+		if _, ok := feedFormats[f]; ok {
+			return f
+		}
+		return ""
+	}
+	for _, acc := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, _, err := mime.ParseMediaType(strings.TrimSpace(acc))
+		if err != nil {
+			continue
+		}
+		if typ == "text/html" {
+			return ""
+		}
+		for f, ct := range feedFormats {
+			if typ == ct {
+				return f
+			}
+		}
+	}
+	return ""
+}
+
+// wantsListingFeed returns the listing, and the format of its feed, when the request asks for one
+func wantsListingFeed(r *http.Request) (*listingModel, string) {
+	m := ContextListingModel(r.Context())
+	if m == nil || !m.feed {
+		return nil, ""
+	}
+	f := listingFeedFormat(r)
+	if len(f) == 0 {
+		return nil, ""
+	}
+	return m, f
+}
+
+// FeedMw marks the listing as having feeds, HandleShow renders it as a feed when the request asks for one
+func FeedMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := ContextListingModel(r.Context()); m != nil {
+			m.feed = true
+		}
+		w.Header().Add("Vary", "Accept")
+		next.ServeHTTP(w, r)
+	})
+}
+
+type feedLink struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+// listingFeedURL returns the link of the feed of the page, with its other URL parameters
+func listingFeedURL(r *http.Request, format string) string {
+	q := r.URL.Query()
+	q.Set(feedFormatParam, format)
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// listingFeedLinks returns the alternate links of the feeds of the listing, for the head of the page
+func listingFeedLinks(r *http.Request, m Model) []feedLink {
+	lm, ok := m.(*listingModel)
+	if !ok || !lm.feed || r == nil {
+		return nil
+	}
+	links := make([]feedLink, 0, len(feedFormats))
+	for _, f := range []string{feedFormatRSS, feedFormatAtom, feedFormatJSON} {
+		links = append(links, feedLink{
+			Type:  feedFormats[f],
+			Title: fmt.Sprintf("%s (%s)", lm.Title, strings.ToUpper(f)),
+			Href:  listingFeedURL(r, f),
+		})
+	}
+	return links
+}
+
+// feedItems returns the items of the listing, in the order of the page, without the ones we don't publish
+func (m *listingModel) feedItems() []Item {
+	items := make([]Item, 0)
+	for _, ren := range m.Sorted() {
+		it, ok := ren.(*Item)
+		if !ok || it == nil || it.Deleted() || it.Private() || !it.Hash.IsValid() {
+			continue
+		}
+		items = append(items, *it)
+	}
+	return items
+}
+
+func absoluteURL(baseURL, link string) string {
+	if len(link) > 0 && link[0] == '/' {
+		return baseURL + link
+	}
+	return link
+}
+
+func feedAuthorName(a *Account) string {
+	if a == nil {
+		return ""
+	}
+	if name := displayName(a); len(name) > 0 {
+		return name
+	}
+	return a.Handle
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEntry struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Category    []string `xml:"category"`
+	Comments    string   `xml:"comments,omitempty"`
+	Description string   `xml:"description,omitempty"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate,omitempty"`
+	Self          atomLink   `xml:"atom:link"`
+	Items         []rssEntry `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// rssEntryFromItem links the entry to the submitted URL, for the links, and to the discussion, for the rest
+func rssEntryFromItem(baseURL string, i Item) rssEntry {
+	permaLink := absoluteURL(baseURL, ItemPermaLink(&i))
+	e := rssEntry{
+		Title:    i.Title,
+		Link:     permaLink,
+		GUID:     rssGUID{IsPermaLink: true, Value: permaLink},
+		PubDate:  i.SubmittedAt.UTC().Format(time.RFC1123Z),
+		Creator:  feedAuthorName(i.SubmittedBy),
+		Category: indexEntryFromItem(i).Tags,
+		Comments: permaLink,
+	}
+	if i.IsLink() {
+		e.Link = i.Data
+	}
+	if c := atomContent(i); c != nil {
+		e.Description = c.Body
+	}
+	if len(e.Title) == 0 {
+		e.Title = atomEntryFromItem(baseURL, i).Title
+	}
+	return e
+}
+
+func (h *handler) listingFeedTitle(m *listingModel) string {
+	if len(m.Title) == 0 {
+		return h.conf.Name
+	}
+	return fmt.Sprintf("%s - %s", m.Title, h.conf.Name)
+}
+
+// writeListingFeed renders the items of the listing as an RSS, Atom or JSON feed
+func (h *handler) writeListingFeed(w http.ResponseWriter, r *http.Request, format string, m *listingModel) {
+	if format == feedFormatJSON {
+		h.writeJSONFeed(w, r, m)
+		return
+	}
+	base := h.storage.SelfURL
+	items := m.feedItems()
+	page := base + r.URL.Path
+	self := base + listingFeedURL(r, format)
+	updated := time.Time{}
+	for _, it := range items {
+		if it.SubmittedAt.After(updated) {
+			updated = it.SubmittedAt.UTC()
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
+
+	var doc interface{}
+	switch format {
+	case feedFormatRSS:
+		feed := rssFeed{
+			Version: "2.0",
+			Atom:    "http://www.w3.org/2005/Atom",
+			DC:      "http://purl.org/dc/elements/1.1/",
+			Channel: rssChannel{
+				Title:         h.listingFeedTitle(m),
+				Link:          page,
+				Description:   h.listingFeedTitle(m),
+				LastBuildDate: updated.Format(time.RFC1123Z),
+				Self:          atomLink{Href: self, Rel: "self", Type: RSSContentType},
+				Items:         make([]rssEntry, 0, len(items)),
+			},
+		}
+		for _, it := range items {
+			feed.Channel.Items = append(feed.Channel.Items, rssEntryFromItem(base, it))
+		}
+		doc = feed
+	case feedFormatAtom:
+		feed := atomFeed{
+			ID:      page,
+			Title:   h.listingFeedTitle(m),
+			Updated: updated.Format(time.RFC3339),
+			Link: []atomLink{
+				{Href: page, Rel: "alternate", Type: "text/html"},
+				{Href: self, Rel: "self", Type: AtomContentType},
+			},
+			Entry: make([]atomEntry, 0, len(items)),
+		}
+		for _, it := range items {
+			e := atomEntryFromItem(base, it)
+			if it.IsLink() {
+				e.Link = append(e.Link, atomLink{Href: it.Data, Rel: "related"})
+			}
+			if e.Author != nil && len(e.Author.Name) == 0 {
+				e.Author.Name = it.SubmittedBy.Handle
+			}
+			feed.Entry = append(feed.Entry, e)
+		}
+		doc = feed
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", feedFormats[format]+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListingFeedFormat(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+	}{
+		{"/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ""},
+		{"/", "application/rss+xml, application/atom+xml;q=0.9, text/xml;q=0.8", feedFormatRSS},
+		{"/t/golang", "application/atom+xml", feedFormatAtom},
+		{"/~alice?format=rss", "text/html", feedFormatRSS},
+		{"/d/example.com?format=atom", "", feedFormatAtom},
+		{"/?format=json", "application/atom+xml", feedFormatJSON},
+		{"/t/golang", "application/feed+json", feedFormatJSON},
+		{"/?format=xml", "application/atom+xml", ""},
+		{"/", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if len(tt.accept) > 0 {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := listingFeedFormat(r); got != tt.want {
+			t.Errorf("%s with Accept %q: expected format %q, got %q", tt.url, tt.accept, tt.want, got)
+		}
+	}
+	r := httptest.NewRequest("GET", "/d/example.com?sort=new&format=atom", nil)
+	if got := listingFeedURL(r, feedFormatRSS); got != "/d/example.com?format=rss&sort=new" {
+		t.Errorf("expected the feed link to keep the other parameters, got %s", got)
+	}
+}
@@ -15,6 +15,9 @@ var (
 	AuthorCtxtKey        CtxtKey = "__author"
 	CursorCtxtKey        CtxtKey = "__cursor"
 	ContentCtxtKey       CtxtKey = "__content"
+	IndexPageCtxtKey     CtxtKey = "__index_page"
+	TopWindowCtxtKey     CtxtKey = "__top_window"
+	ArchiveCtxtKey       CtxtKey = "__archive"
 )
 
 type WebInfo struct {
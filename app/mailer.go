@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+// mailer sends the outgoing messages of the instance through the configured SMTP server
+type mailer struct {
+	addr string
+	from mail.Address
+	auth smtp.Auth
+}
+
+// newMailer returns nil when the instance doesn't have a mail server configured
+func newMailer(c *config.Configuration) *mailer {
+	if len(c.SMTPAddress) == 0 {
+		return nil
+	}
+	m := &mailer{addr: c.SMTPAddress, from: mail.Address{Name: c.Name, Address: c.MailFrom}}
+	if len(m.from.Address) == 0 {
+		m.from.Address = fmt.Sprintf("noreply@%s", c.HostName)
+	}
+	if len(c.SMTPUser) > 0 {
+		host, _, _ := net.SplitHostPort(c.SMTPAddress)
+		m.auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPassword, host)
+	}
+	return m
+}
+
+// Enabled returns if the instance can send emails
+func (m *mailer) Enabled() bool {
+	return m != nil
+}
+
+// message builds a plain text message to the address, the headers are added to the default ones
+func (m *mailer) message(to, subject, body string, headers map[string]string) ([]byte, error) {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, errors.NewBadRequest(err, "invalid email address %q", to)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := m.from.Address[strings.LastIndexByte(m.from.Address, '@')+1:]
+	hh := map[string]string{
+		"From":                      m.from.String(),
+		"To":                        rcpt.String(),
+		"Subject":                   mime.QEncoding.Encode("utf-8", subject),
+		"Date":                      time.Now().Format(time.RFC1123Z),
+		"Message-Id":                fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain),
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+		"Auto-Submitted":            "auto-generated",
+	}
+	for k, v := range headers {
+		hh[k] = v
+	}
+	names := make([]string, 0, len(hh))
+	for k := range hh {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	buf := bytes.Buffer{}
+	for _, k := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, hh[k])
+	}
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Send delivers a plain text message to the address
+func (m *mailer) Send(to, subject, body string, headers map[string]string) error {
+	if !m.Enabled() {
+		return errors.Errorf("sending emails is disabled")
+	}
+	msg, err := m.message(to, subject, body, headers)
+	if err != nil {
+		return err
+	}
+	rcpt, _ := mail.ParseAddress(to)
+	return smtp.SendMail(m.addr, m.auth, m.from.Address, []string{rcpt.Address}, msg)
+}
@@ -0,0 +1,479 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	mailAddressesFile = "mail-addresses.json"
+	mailSecretSize    = 10
+	// maxMailSize is the size of the largest message the gateway accepts, with its headers and attachments
+	maxMailSize     = 1 << 20
+	mailSaveTimeOut = 30 * time.Second
+)
+
+// mailAddress is the secret posting address of an account. Like for the held items, we keep the token
+// of the account, so the messages can be saved as it. It's saved encrypted, see tokenKeys.
+type mailAddress struct {
+	Account     string        `json:"account"`
+	Handle      string        `json:"handle"`
+	Token       *oauth2.Token `json:"-"`
+	SealedToken string        `json:"sealedToken,omitempty"`
+}
+
+// mailAddresses keeps the posting addresses of the local accounts, by their secret. Every account has at most
+// one, and creating a new one revokes the previous.
+type mailAddresses struct {
+	m         sync.RWMutex
+	path      string
+	domain    string
+	keys      tokenKeys
+	Addresses map[string]mailAddress `json:"addresses"`
+}
+
+func loadMailAddresses(path, domain string, keys tokenKeys) (*mailAddresses, error) {
+	m := &mailAddresses{path: path, domain: domain, keys: keys, Addresses: make(map[string]mailAddress)}
+	if err := loadJSON(path, m); err != nil {
+		return m, err
+	}
+	failed := 0
+	for secret, a := range m.Addresses {
+		tok, err := keys.open(a.SealedToken)
+		if err != nil {
+			failed++
+			continue
+		}
+		a.Token = tok
+		m.Addresses[secret] = a
+	}
+	if failed > 0 {
+		return m, errors.Newf("unable to decrypt the tokens of %d posting addresses", failed)
+	}
+	return m, nil
+}
+
+// Enabled returns if the instance has a mail gateway
+func (m *mailAddresses) Enabled() bool {
+	return m != nil && len(m.domain) > 0
+}
+
+// Address returns the posting address of the account with id, if it has one
+func (m *mailAddresses) Address(id string) string {
+	if !m.Enabled() {
+		return ""
+	}
+	m.m.RLock()
+	defer m.m.RUnlock()
+	for secret, a := range m.Addresses {
+		if a.Account == id {
+			return fmt.Sprintf("%s@%s", secret, m.domain)
+		}
+	}
+	return ""
+}
+
+// Get returns the posting address with the secret
+func (m *mailAddresses) Get(secret string) (mailAddress, bool) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	a, ok := m.Addresses[secret]
+	return a, ok
+}
+
+// Create generates a new posting address for acc, the old one stops working
+func (m *mailAddresses) Create(acc *Account) (string, error) {
+	if !m.Enabled() {
+		return "", errors.Errorf("the mail gateway is disabled")
+	}
+	if !acc.IsLogged() || acc.Metadata == nil || acc.Metadata.OAuth.Token == nil {
+		return "", errors.Unauthorizedf("invalid account")
+	}
+	buf := make([]byte, mailSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.remove(acc.Metadata.ID)
+	m.Addresses[secret] = mailAddress{Account: acc.Metadata.ID, Handle: acc.Handle, Token: acc.Metadata.OAuth.Token}
+	return fmt.Sprintf("%s@%s", secret, m.domain), m.save()
+}
+
+// Revoke removes the posting address of the account with id
+func (m *mailAddresses) Revoke(id string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.remove(id)
+	return m.save()
+}
+
+// SetToken keeps the refreshed token of the posting address with the secret
+func (m *mailAddresses) SetToken(secret string, tok *oauth2.Token) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	a, ok := m.Addresses[secret]
+	if !ok || tok == nil || a.Token != nil && a.Token.AccessToken == tok.AccessToken {
+		return nil
+	}
+	a.Token = tok
+	m.Addresses[secret] = a
+	return m.save()
+}
+
+// remove deletes the addresses of the account with id, it needs to be called with the lock held
+func (m *mailAddresses) remove(id string) {
+	for secret, a := range m.Addresses {
+		if a.Account == id {
+			delete(m.Addresses, secret)
+		}
+	}
+}
+
+// save writes the addresses to disk, it needs to be called with the lock held
+func (m *mailAddresses) save() error {
+	for secret, a := range m.Addresses {
+		if a.Token == nil {
+			// NOTE(marius): the tokens we couldn't decrypt are kept as they were
+			continue
+		}
+		var err error
+		if a.SealedToken, err = m.keys.seal(a.Token); err != nil {
+			return err
+		}
+		m.Addresses[secret] = a
+	}
+	return saveJSON(m.path, m)
+}
+
+// mailReplyAddress is the address for replying to the item with hash by email: the posting address,
+// with the hash of the item after a dot in its local part
+func mailReplyAddress(address string, hash Hash) string {
+	i := strings.LastIndex(address, "@")
+	if i < 0 || !hash.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s%s", address[:i], hash, address[i:])
+}
+
+// parseMailRecipient returns the secret, and the hash of the item replied to, of a posting address of the domain
+func parseMailRecipient(address, domain string) (string, Hash, bool) {
+	i := strings.LastIndex(address, "@")
+	if i < 0 || !strings.EqualFold(address[i+1:], domain) {
+		return "", Hash{}, false
+	}
+	local := strings.ToLower(address[:i])
+	secret, parent := local, ""
+	if j := strings.Index(local, "."); j > 0 {
+		secret, parent = local[:j], local[j+1:]
+	}
+	if len(secret) != 2*mailSecretSize {
+		return "", Hash{}, false
+	}
+	if len(parent) == 0 {
+		return secret, Hash{}, true
+	}
+	h := HashFromString(parent)
+	return secret, h, h.IsValid()
+}
+
+// mailMessage is what we use of the messages received by the gateway
+type mailMessage struct {
+	Recipients []string
+	Subject    string
+	Body       string
+	// Automatic is set for the auto-replies and the bounces, which we ignore
+	Automatic bool
+}
+
+// readMailMessage parses the RFC 5322 message, its text is the first text/plain part
+func readMailMessage(r io.Reader) (mailMessage, error) {
+	m := mailMessage{}
+	msg, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		return m, errors.NewBadRequest(err, "invalid message")
+	}
+	for _, k := range []string{"X-Original-To", "Delivered-To", "To", "Cc"} {
+		for _, v := range msg.Header[k] {
+			list, err := mail.ParseAddressList(v)
+			if err != nil {
+				continue
+			}
+			for _, a := range list {
+				m.Recipients = append(m.Recipients, a.Address)
+			}
+		}
+	}
+	auto := strings.ToLower(msg.Header.Get("Auto-Submitted"))
+	m.Automatic = (len(auto) > 0 && auto != "no") || len(msg.Header.Get("X-Autoreply")) > 0
+	dec := new(mime.WordDecoder)
+	if m.Subject, err = dec.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		m.Subject = msg.Header.Get("Subject")
+	}
+	text, err := mailText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return m, err
+	}
+	m.Body = stripMailQuotes(text)
+	return m, nil
+}
+
+// mailText returns the text of a message part, looking into the multipart ones for the first text/plain part
+func mailText(contentType, encoding string, body io.Reader) (string, error) {
+	typ, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		typ = "text/plain"
+	}
+	if strings.HasPrefix(typ, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", errors.NewBadRequest(err, "invalid multipart message")
+			}
+			text, err := mailText(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p)
+			if err == nil && len(text) > 0 {
+				return text, nil
+			}
+		}
+		return "", errors.BadRequestf("the message has no text")
+	}
+	if typ != "text/plain" {
+		return "", errors.BadRequestf("the message has no text")
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", errors.NewBadRequest(err, "unable to read the message")
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// quoteHeaderRe matches the "On <date>, <someone> wrote:" lines the mail clients put before the quoted message
+var quoteHeaderRe = regexp.MustCompile(`^(On|Le|Am|El) .+(wrote|a écrit|schrieb|escribió) ?:$`)
+
+// stripMailQuotes removes the quoted message and the signature of a reply
+func stripMailQuotes(s string) string {
+	lines := strings.Split(s, "\n")
+	keep := make([]string, 0, len(lines))
+	for _, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if l == "-- " || trimmed == "--" || quoteHeaderRe.MatchString(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		keep = append(keep, strings.TrimRight(l, " \t"))
+	}
+	return strings.TrimSpace(strings.Join(keep, "\n"))
+}
+
+// validMailGatewayRequest checks the shared secret the mail server sends as a bearer token
+func validMailGatewayRequest(r *http.Request, secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(secret)) == 1
+}
+
+func writeMailGatewayStatus(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	key := "status"
+	if status >= http.StatusBadRequest {
+		key = "error"
+	}
+	json.NewEncoder(w).Encode(map[string]string{key: msg})
+}
+
+// HandleMailGateway handles POST /api/v1/mail requests, which the mail server sends with the raw messages
+// received at the posting addresses. The recipient can also be passed in the "to" URL parameter, for the
+// servers which pipe the messages to us with the envelope recipient.
+// The messages to the posting address of an account become submissions, with the subject as title, and the ones
+// to its reply addresses become comments.
+func (h *handler) HandleMailGateway(w http.ResponseWriter, r *http.Request) {
+	repo := h.storage
+	if !repo.mail.Enabled() {
+		writeMailGatewayStatus(w, http.StatusNotFound, "the mail gateway is disabled")
+		return
+	}
+	if !validMailGatewayRequest(r, h.conf.MailGatewaySecret) {
+		writeMailGatewayStatus(w, http.StatusUnauthorized, "invalid authorization")
+		return
+	}
+	msg, err := readMailMessage(http.MaxBytesReader(w, r.Body, maxMailSize))
+	if err != nil {
+		writeMailGatewayStatus(w, errors.HttpStatus(err), err.Error())
+		return
+	}
+	if msg.Automatic {
+		writeMailGatewayStatus(w, http.StatusAccepted, "ignored")
+		return
+	}
+	var (
+		secret string
+		parent Hash
+		addr   mailAddress
+		found  bool
+	)
+	for _, rec := range append(r.URL.Query()["to"], msg.Recipients...) {
+		if secret, parent, found = parseMailRecipient(rec, h.conf.MailGatewayDomain); !found {
+			continue
+		}
+		if addr, found = repo.mail.Get(secret); found {
+			break
+		}
+	}
+	if !found {
+		writeMailGatewayStatus(w, http.StatusNotFound, "unknown recipient")
+		return
+	}
+	lCtx := log.Ctx{"handle": addr.Handle, "parent": parent}
+
+	status, err := h.saveMail(r.Context(), secret, addr, parent, msg)
+	if err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the message received by email")
+		writeMailGatewayStatus(w, errors.HttpStatus(err), err.Error())
+		return
+	}
+	h.infoFn(lCtx, log.Ctx{"status": status})("saved message received by email")
+	writeMailGatewayStatus(w, http.StatusAccepted, status)
+}
+
+// saveMail saves the message as the account of the posting address, the same way as the approved held items,
+// or puts it in the moderation queue if the account's items are held
+func (h *handler) saveMail(ctx context.Context, secret string, addr mailAddress, parent Hash, msg mailMessage) (string, error) {
+	repo := h.storage
+	ctx, cancel := context.WithTimeout(ctx, mailSaveTimeOut)
+	defer cancel()
+
+	acc, tok, err := repo.tokenAccount(ctx, addr.Account, addr.Token)
+	if tok != nil {
+		if err := repo.mail.SetToken(secret, tok); err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": addr.Handle})("unable to save the refreshed token of the posting address")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(msg.Body) == 0 {
+		return "", errors.BadRequestf("the message is empty")
+	}
+	it := Item{
+		Data:        msg.Body,
+		MimeType:    MimeTypeMarkdown,
+		SubmittedBy: acc,
+		Metadata:    new(ItemMetadata),
+	}
+	if parent.IsValid() {
+		p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(parent.String()))
+		if err != nil {
+			return "", errors.NotFoundf("the item replied to")
+		}
+		if p.Deleted() || archivedItem(&p) {
+			return "", errors.Forbiddenf("the item can't be replied to anymore")
+		}
+		it.Parent, it.OP = &p, &p
+		if p.OP.IsValid() {
+			it.OP = p.OP
+		}
+		if wait := repo.slow.Wait(threadHash(&it), acc.Hash); wait > 0 && !isModerator(&h.conf.Configuration, acc) {
+			return "", errors.Forbiddenf("the thread is in slow mode, you can comment again in %s", fmtWait(wait))
+		}
+		if err := checkBoardRestrictions(&h.conf.Configuration, repo.boards, acc, &it); err != nil {
+			return "", err
+		}
+	} else {
+		title := strings.TrimSpace(msg.Subject)
+		if len(title) == 0 {
+			return "", errors.BadRequestf("the subject of the message is the title of the submission, it can't be empty")
+		}
+		it.Title = normalizeTitlePrefix(repo.titles.Normalize(title), h.conf.AskPrefixes, h.conf.ShowPrefixes)
+	}
+
+	if repo.held.Holds(acc) && !isModerator(&h.conf.Configuration, acc) {
+		if err := repo.held.Add(it, acc); err != nil {
+			return "", err
+		}
+		return "held", nil
+	}
+	hi := heldItem{Title: it.Title, Data: it.Data, MimeType: it.MimeType, Account: addr.Account, Handle: acc.Handle, Token: tok}
+	if it.Parent.IsValid() {
+		hi.Parent, hi.ParentHash = it.Parent.Metadata.ID, it.Parent.Hash
+		if it.OP.HasMetadata() {
+			hi.OP = it.OP.Metadata.ID
+		}
+	}
+	if err := repo.approveHeld(ctx, hi); err != nil {
+		return "", err
+	}
+	repo.posting.Record(acc.Hash)
+	if it.Parent.IsValid() {
+		repo.slow.Record(threadHash(&it), acc.Hash)
+	}
+	return "published", nil
+}
+
+// HandleMailAddress handles POST /~handle/settings/mail requests, which create or revoke the posting address
+func (h *handler) HandleMailAddress(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	var err error
+	if r.PostFormValue("action") == "revoke" {
+		if err = h.storage.mail.Revoke(accountIRI(acc).String()); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Your posting address was revoked.")
+		}
+	} else {
+		if _, err = h.storage.mail.Create(acc); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Your new posting address was created, the old one doesn't work anymore.")
+		}
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the posting address")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the posting address"))
+		return
+	}
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
+
+// ReplyAddress returns the address where acc can reply by email to the item, if it has a posting address
+func (m *mailAddresses) ReplyAddress(acc *Account, it *Item) string {
+	if !m.Enabled() || !acc.IsLogged() || !it.IsValid() || it.Deleted() {
+		return ""
+	}
+	return mailReplyAddress(m.Address(accountIRI(acc).String()), it.Hash)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestMailAddresses(t *testing.T) {
+	m, err := loadMailAddresses("", "mail.littr.example", testTokenKeys)
+	if err != nil {
+		t.Fatalf("unable to load the posting addresses: %s", err)
+	}
+	acc := &Account{
+		Handle:    "test",
+		Hash:      HashFromString("f00f00f00"),
+		CreatedAt: time.Now().Add(-time.Hour),
+		Metadata:  &AccountMetadata{ID: "https://littr.git/actors/f00f00f00", OAuth: OAuth{Token: &oauth2.Token{AccessToken: "first"}}},
+	}
+	first, err := m.Create(acc)
+	if err != nil {
+		t.Fatalf("unable to create the posting address: %s", err)
+	}
+	second, err := m.Create(acc)
+	if err != nil {
+		t.Fatalf("unable to create the posting address: %s", err)
+	}
+
+	for _, a := range m.Addresses {
+		if tok, err := testTokenKeys.open(a.SealedToken); err != nil || tok.AccessToken != "first" || strings.Contains(a.SealedToken, "first") {
+			t.Errorf("the token of the posting address wasn't sealed: %q %v", a.SealedToken, err)
+		}
+	}
+	if got := m.Address(acc.Metadata.ID); got != second || got == first {
+		t.Errorf("expected only the new address %s, got %s", second, got)
+	}
+	parent := HashFromString("2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60")
+	secret, h, ok := parseMailRecipient(strings.ToUpper(mailReplyAddress(second, parent)), "mail.littr.example")
+	if !ok || h != parent {
+		t.Fatalf("unable to parse the reply address of %s", second)
+	}
+	if a, ok := m.Get(secret); !ok || a.Handle != "test" || a.Token.AccessToken != "first" {
+		t.Errorf("unexpected posting address %v", a)
+	}
+	if _, _, ok := parseMailRecipient(second, "littr.example"); ok {
+		t.Errorf("the address of another domain was accepted")
+	}
+	if err := m.Revoke(acc.Metadata.ID); err != nil {
+		t.Fatalf("unable to revoke the posting address: %s", err)
+	}
+	if _, ok := m.Get(secret); ok {
+		t.Errorf("the revoked address still works")
+	}
+}
+
+func TestReadMailMessage(t *testing.T) {
+	raw := strings.Join([]string{
+		`From: Jane <jane@example.com>`,
+		`To: "littr" <0123456789abcdef0123@mail.littr.example>`,
+		`Subject: =?UTF-8?Q?Caf=C3=A9s?=`,
+		`MIME-Version: 1.0`,
+		`Content-Type: multipart/alternative; boundary="XX"`,
+		``,
+		`--XX`,
+		`Content-Type: text/plain; charset=utf-8`,
+		`Content-Transfer-Encoding: quoted-printable`,
+		``,
+		`I agree, caf=C3=A9s are great.`,
+		``,
+		`On Mon, Jan 1, 2024 at 10:00 AM littr wrote:`,
+		`> the quoted text`,
+		`-- `,
+		`Jane`,
+		`--XX`,
+		`Content-Type: text/html`,
+		``,
+		`<p>I agree</p>`,
+		`--XX--`,
+	}, "\r\n")
+	m, err := readMailMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unable to read the message: %s", err)
+	}
+	if m.Subject != "Cafés" || m.Body != "I agree, cafés are great." || m.Automatic {
+		t.Errorf("unexpected message %#v", m)
+	}
+	if len(m.Recipients) != 1 || m.Recipients[0] != "0123456789abcdef0123@mail.littr.example" {
+		t.Errorf("unexpected recipients %v", m.Recipients)
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	stdhtml "html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// mediaShowParam is the URL parameter for showing the media the account chose to hide, for one page view
+const mediaShowParam = "media"
+
+var (
+	inlineVideoRe    = regexp.MustCompile(`(?is)<video\b.*?</video>`)
+	inlineAudioRe    = regexp.MustCompile(`(?is)<audio\b.*?</audio>`)
+	inlineAnimatedRe = regexp.MustCompile(`(?i)<img\b[^>]*?\bsrc=["']([^"']+\.(?:gif|apng))(?:\?[^"']*)?["'][^>]*>`)
+	inlineSrcRe      = regexp.MustCompile(`(?i)\bsrc=["']([^"']+)["']`)
+)
+
+// mediaFilter replaces the media the account doesn't want to see with placeholders linking to it,
+// so the players and the animated images aren't loaded unless requested
+type mediaFilter struct {
+	AccountSettings
+}
+
+func newMediaFilter(r *http.Request, s AccountSettings) mediaFilter {
+	if r.URL.Query().Get(mediaShowParam) == "show" {
+		return mediaFilter{}
+	}
+	return mediaFilter{AccountSettings: s}
+}
+
+func isAnimatedImage(mime string) bool {
+	return mime == "image/gif" || mime == "image/apng"
+}
+
+// Blocks returns if the media with mime type should be replaced with a placeholder
+func (f mediaFilter) Blocks(mime string) bool {
+	return f.HideVideo && isVideo(mime) || f.HideAudio && isAudio(mime) || f.HideAnimated && isAnimatedImage(mime)
+}
+
+func mediaLabel(mime string) string {
+	switch {
+	case isVideo(mime):
+		return "video"
+	case isAudio(mime):
+		return "audio"
+	}
+	return "animated image"
+}
+
+// mediaPlaceholder returns the link which loads the hidden media of type mime
+func mediaPlaceholder(mime, href string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<a class="media-placeholder" href="%s" rel="nofollow">%s Load %s</a>`,
+		template.HTMLEscapeString(href), icon("angle-right"), mediaLabel(mime)))
+}
+
+// itemMediaLink returns the link to the item which shows its media, regardless of the account's settings
+func itemMediaLink(i *Item) string {
+	return fmt.Sprintf("%s?%s=show", ItemPermaLink(i), mediaShowParam)
+}
+
+// Filter replaces the hidden media embedded in the content, with links to their sources
+func (f mediaFilter) Filter(content template.HTML) template.HTML {
+	if !f.HideVideo && !f.HideAudio && !f.HideAnimated {
+		return content
+	}
+	replaceWith := func(mime string) func(string) string {
+		return func(el string) string {
+			src := ""
+			if m := inlineSrcRe.FindStringSubmatch(el); len(m) > 1 {
+				src = stdhtml.UnescapeString(m[1])
+			}
+			lower := strings.ToLower(src)
+			if !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "/") {
+				return fmt.Sprintf(`<span class="media-placeholder">Hidden %s</span>`, mediaLabel(mime))
+			}
+			return string(mediaPlaceholder(mime, src))
+		}
+	}
+	s := string(content)
+	if f.HideVideo {
+		s = inlineVideoRe.ReplaceAllStringFunc(s, replaceWith("video"))
+	}
+	if f.HideAudio {
+		s = inlineAudioRe.ReplaceAllStringFunc(s, replaceWith("audio"))
+	}
+	if f.HideAnimated {
+		s = inlineAnimatedRe.ReplaceAllStringFunc(s, replaceWith("image/gif"))
+	}
+	return template.HTML(s)
+}
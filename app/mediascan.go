@@ -0,0 +1,249 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	MediaScannerClamd = "clamd"
+	MediaScannerHTTP  = "http"
+
+	quarantineLogFile = "quarantine.log"
+	// mediaScanTimeOut is how long we wait for the scanner, the media we check is small so it doesn't need long
+	mediaScanTimeOut = 10 * time.Second
+	// clamdChunkSize is the size of the chunks we stream to clamd, it needs to be lower than its StreamMaxLength
+	clamdChunkSize = 32 << 10
+)
+
+// mediaScanBackend checks the files with an antivirus, Scan returns the name of what it found, or an empty
+// string if the file is clean
+type mediaScanBackend interface {
+	Name() string
+	Scan(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// newMediaScanBackend returns the scanner for the backend from the configuration, or nil if scanning is disabled
+func newMediaScanBackend(c config.Configuration) (mediaScanBackend, error) {
+	switch strings.ToLower(c.MediaScanner) {
+	case "", "none":
+		return nil, nil
+	case MediaScannerClamd:
+		u, err := url.Parse(c.MediaScannerURL)
+		if err != nil || (u.Scheme != "unix" && u.Scheme != "tcp") {
+			return nil, errors.Errorf("MEDIA_SCANNER_URL needs to be a unix:// or tcp:// address for %s", c.MediaScanner)
+		}
+		addr := u.Host
+		if u.Scheme == "unix" {
+			addr = u.Path
+		}
+		return &clamdScanner{network: u.Scheme, address: addr}, nil
+	case MediaScannerHTTP:
+		if len(c.MediaScannerURL) == 0 {
+			return nil, errors.Errorf("MEDIA_SCANNER_URL is needed for %s", c.MediaScanner)
+		}
+		return &httpScanner{url: c.MediaScannerURL}, nil
+	}
+	return nil, errors.Errorf("unknown media scanner %q", c.MediaScanner)
+}
+
+// clamdScanner streams the files to a clamd daemon, with its INSTREAM command
+type clamdScanner struct {
+	network string
+	address string
+}
+
+func (c *clamdScanner) Name() string {
+	return MediaScannerClamd
+}
+
+func (c *clamdScanner) Scan(ctx context.Context, data []byte, _ string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	size := make([]byte, 4)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > clamdChunkSize {
+			chunk = chunk[:clamdChunkSize]
+		}
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err := conn.Write(append(size, chunk...)); err != nil {
+			return "", err
+		}
+		data = data[len(chunk):]
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return "", err
+	}
+	res, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && len(res) == 0 {
+		return "", err
+	}
+	return parseClamdResponse(res)
+}
+
+// parseClamdResponse reads the answers of clamd, which look like "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdResponse(res string) (string, error) {
+	res = strings.TrimSpace(strings.TrimRight(res, "\x00"))
+	res = strings.TrimSpace(strings.TrimPrefix(res, "stream:"))
+	switch {
+	case res == "OK":
+		return "", nil
+	case strings.HasSuffix(res, " FOUND"):
+		return strings.TrimSuffix(res, " FOUND"), nil
+	}
+	return "", errors.Errorf("clamd: %s", res)
+}
+
+// httpScanner sends the files to a scanning service, in the body of a POST request. The service answers
+// with a JSON object, with "infected" set to true, and the name of what it found in "signature", when the
+// file needs to be rejected.
+type httpScanner struct {
+	url string
+}
+
+func (h *httpScanner) Name() string {
+	return MediaScannerHTTP
+}
+
+func (h *httpScanner) Scan(ctx context.Context, data []byte, mimeType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if len(mimeType) > 0 {
+		req.Header.Set("Content-Type", mimeType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("the scanner answered with %s", res.Status)
+	}
+	result := struct {
+		Infected  bool   `json:"infected"`
+		Signature string `json:"signature"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", errors.Annotatef(err, "invalid answer from the scanner")
+	}
+	if !result.Infected {
+		return "", nil
+	}
+	if len(result.Signature) == 0 {
+		result.Signature = "unknown"
+	}
+	return result.Signature, nil
+}
+
+// quarantineEntry is a line of the quarantine log, we keep the hash of the rejected files, not their content
+type quarantineEntry struct {
+	At        time.Time `json:"at"`
+	Source    string    `json:"source"`
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	MimeType  string    `json:"mimeType,omitempty"`
+	Scanner   string    `json:"scanner"`
+	Signature string    `json:"signature"`
+}
+
+// mediaScanner checks the media before we accept it, and records the rejected files in the quarantine log
+type mediaScanner struct {
+	m       sync.Mutex
+	backend mediaScanBackend
+	path    string
+	errFn   CtxLogFn
+}
+
+func newMediaScanner(backend mediaScanBackend, path string, errFn CtxLogFn) *mediaScanner {
+	return &mediaScanner{backend: backend, path: path, errFn: errFn}
+}
+
+// Enabled returns if the instance is configured with a scanner
+func (s *mediaScanner) Enabled() bool {
+	return s != nil && s.backend != nil
+}
+
+// Check scans the data loaded from source, it returns an error when the file needs to be rejected. When the
+// scanner can't be reached the files are rejected too, the instances which need the scanning can't let them through.
+func (s *mediaScanner) Check(ctx context.Context, source string, data []byte, mimeType string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, mediaScanTimeOut)
+	defer cancel()
+	found, err := s.backend.Scan(ctx, data, mimeType)
+	if err != nil {
+		return errors.Annotatef(err, "unable to scan the file")
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	e := quarantineEntry{
+		At:        time.Now().UTC(),
+		Source:    source,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Size:      len(data),
+		MimeType:  mimeType,
+		Scanner:   s.backend.Name(),
+		Signature: found,
+	}
+	s.errFn(log.Ctx{"source": e.Source, "sha256": e.SHA256, "signature": e.Signature})("rejected infected file")
+	if err := s.quarantine(e); err != nil {
+		s.errFn(log.Ctx{"err": err, "path": s.path})("unable to write the quarantine log")
+	}
+	return errors.Forbiddenf("the file was rejected by the scanner")
+}
+
+// quarantine appends the entry to the quarantine log
+func (s *mediaScanner) quarantine(e quarantineEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return errors.Annotatef(err, "unable to create the data directory")
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
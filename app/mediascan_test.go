@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// fakeClamd answers the INSTREAM commands like clamd, it finds the files containing "EICAR"
+func fakeClamd(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+				conn.Close()
+				continue
+			}
+			data := new(bytes.Buffer)
+			size := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(r, size); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				io.CopyN(data, r, int64(n))
+			}
+			if strings.Contains(data.String(), "EICAR") {
+				conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestMediaScannerCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clamd := fakeClamd(t)
+	defer clamd.Close()
+
+	errFn := func(ctx ...log.Ctx) LogFn { return func(string, ...interface{}) {} }
+	path := filepath.Join(dir, quarantineLogFile)
+	s := newMediaScanner(&clamdScanner{network: "tcp", address: clamd.Addr().String()}, path, errFn)
+
+	clean := bytes.Repeat([]byte("x"), clamdChunkSize*2+10)
+	if err := s.Check(context.Background(), "test", clean, "image/png"); err != nil {
+		t.Errorf("expected the clean file to be accepted, got %s", err)
+	}
+	infected := append(clean, []byte("EICAR")...)
+	if err := s.Check(context.Background(), "test", infected, "image/png"); !errors.IsForbidden(err) {
+		t.Errorf("expected the infected file to be rejected, got %v", err)
+	}
+	q, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected the quarantine log to be written: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(q)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"signature":"Eicar-Signature"`) {
+		t.Errorf("expected one entry in the quarantine log, got %s", q)
+	}
+
+	// NOTE(marius): the files are rejected when the scanner can't be reached
+	down := newMediaScanner(&clamdScanner{network: "tcp", address: "127.0.0.1:1"}, path, errFn)
+	if err := down.Check(context.Background(), "test", clean, "image/png"); err == nil {
+		t.Errorf("expected the file to be rejected when the scanner is down")
+	}
+}
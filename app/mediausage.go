@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	mediaUsageFile = "media-usage.json"
+	// mediaUsageTopAccounts is the number of accounts using the most space we show on the admin page
+	mediaUsageTopAccounts = 10
+)
+
+// mediaFile is a media file we keep in the data directory, with the account it belongs to
+type mediaFile struct {
+	Account string    `json:"account"`
+	Hash    Hash      `json:"hash"`
+	Size    int64     `json:"size"`
+	At      time.Time `json:"at"`
+}
+
+// accountUsage is the disk space the media files of an account use
+type accountUsage struct {
+	Account string
+	Files   int
+	Size    int64
+}
+
+// mediaUsage keeps the sizes of the media files we store, by their path relative to the data directory,
+// and checks the instance and account quotas before new ones are written
+type mediaUsage struct {
+	m            sync.RWMutex
+	path         string
+	quota        int64
+	accountQuota int64
+	Files        map[string]mediaFile `json:"files"`
+}
+
+func loadMediaUsage(path string, quota, accountQuota int64) (*mediaUsage, error) {
+	u := &mediaUsage{path: path, quota: quota, accountQuota: accountQuota, Files: make(map[string]mediaFile)}
+	return u, loadJSON(path, u)
+}
+
+// fmtSize returns the size in bytes in a readable form
+func fmtSize(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// Used returns the space all the media files use
+func (u *mediaUsage) Used() int64 {
+	if u == nil {
+		return 0
+	}
+	u.m.RLock()
+	defer u.m.RUnlock()
+	total := int64(0)
+	for _, f := range u.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Quota returns the space the media files can use, 0 if it's not limited
+func (u *mediaUsage) Quota() int64 {
+	if u == nil {
+		return 0
+	}
+	return u.quota
+}
+
+// Accounts returns the accounts using the most space, at most max of them
+func (u *mediaUsage) Accounts(max int) []accountUsage {
+	if u == nil {
+		return nil
+	}
+	u.m.RLock()
+	byAccount := make(map[string]*accountUsage)
+	for _, f := range u.Files {
+		a, ok := byAccount[f.Account]
+		if !ok {
+			a = &accountUsage{Account: f.Account}
+			byAccount[f.Account] = a
+		}
+		a.Files++
+		a.Size += f.Size
+	}
+	u.m.RUnlock()
+	res := make([]accountUsage, 0, len(byAccount))
+	for _, a := range byAccount {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Size == res[j].Size {
+			return res[i].Account < res[j].Account
+		}
+		return res[i].Size > res[j].Size
+	})
+	if max > 0 && len(res) > max {
+		res = res[:max]
+	}
+	return res
+}
+
+// Allow checks that a new file of size bytes, belonging to account, fits in the quotas. The file replacing
+// the one at name doesn't count the size of the old one.
+func (u *mediaUsage) Allow(name, account string, size int64) error {
+	if u == nil || (u.quota <= 0 && u.accountQuota <= 0) {
+		return nil
+	}
+	u.m.RLock()
+	defer u.m.RUnlock()
+	total, byAccount := size, size
+	for n, f := range u.Files {
+		if n == name {
+			continue
+		}
+		total += f.Size
+		if f.Account == account {
+			byAccount += f.Size
+		}
+	}
+	if u.quota > 0 && total > u.quota {
+		return errors.Forbiddenf("the media storage of the instance is full, it uses %s of %s", fmtSize(total-size), fmtSize(u.quota))
+	}
+	if u.accountQuota > 0 && len(account) > 0 && byAccount > u.accountQuota {
+		return errors.Forbiddenf("the media of the account uses %s, it can't go over %s", fmtSize(byAccount-size), fmtSize(u.accountQuota))
+	}
+	return nil
+}
+
+// Add records the file at name, with its size and the account it belongs to
+func (u *mediaUsage) Add(name, account string, h Hash, size int64) error {
+	if u == nil {
+		return nil
+	}
+	u.m.Lock()
+	defer u.m.Unlock()
+	u.Files[filepath.ToSlash(name)] = mediaFile{Account: account, Hash: h, Size: size, At: time.Now().UTC()}
+	return u.save()
+}
+
+// Remove forgets the files at names, it doesn't delete them
+func (u *mediaUsage) Remove(names ...string) error {
+	if u == nil || len(names) == 0 {
+		return nil
+	}
+	u.m.Lock()
+	defer u.m.Unlock()
+	for _, n := range names {
+		delete(u.Files, filepath.ToSlash(n))
+	}
+	return u.save()
+}
+
+func (u *mediaUsage) save() error {
+	return saveJSON(u.path, u)
+}
+
+// mediaGCResult is what the media garbage collection removed
+type mediaGCResult struct {
+	Files int
+	Size  int64
+}
+
+// GC removes the media files in dir, relative to the data directory at base, which don't belong to an item
+// anymore, the exists function says if the item with the hash is still there. The files we don't track are
+// orphaned too, as are the tracked files missing from the disk, which are only forgotten.
+func (u *mediaUsage) GC(base, dir string, exists func(Hash) (bool, error)) (mediaGCResult, error) {
+	res := mediaGCResult{}
+	u.m.RLock()
+	tracked := make(map[string]mediaFile, len(u.Files))
+	for n, f := range u.Files {
+		tracked[n] = f
+	}
+	u.m.RUnlock()
+
+	removed := make([]string, 0)
+	onDisk := make(map[string]bool)
+	files, err := ioutil.ReadDir(filepath.Join(base, dir))
+	if err != nil && !os.IsNotExist(err) {
+		return res, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		name := filepath.ToSlash(filepath.Join(dir, fi.Name()))
+		onDisk[name] = true
+		f, ok := tracked[name]
+		if ok {
+			keep, err := exists(f.Hash)
+			if err != nil {
+				return res, errors.Annotatef(err, "unable to check the item %s", f.Hash)
+			}
+			if keep {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(base, name)); err != nil {
+			return res, err
+		}
+		res.Files++
+		res.Size += fi.Size()
+		removed = append(removed, name)
+	}
+	for name := range tracked {
+		if strings.HasPrefix(name, dir+"/") && !onDisk[name] {
+			removed = append(removed, name)
+		}
+	}
+	return res, u.Remove(removed...)
+}
+
+// MediaGC runs the "media gc" command, which removes the media files of the items that were deleted, and the ones
+// we don't know which item they belong to
+func MediaGC(ctx context.Context, c *config.Configuration, host string, port int, ver string) (int, int64, error) {
+	a := Application{Version: ver}
+	a.configure(c, host, port)
+	repo, err := ActivityPubService(appConfig{Configuration: *c, BaseURL: a.BaseURL, SessionKeys: loadEnvSessionKeys(), Logger: a.Logger.New(log.Ctx{"package": "media"})})
+	if repo == nil || repo.fedbox == nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the ActivityPub service")
+	}
+	if err := repo.fedbox.loadService(ctx); err != nil {
+		return 0, 0, errors.Annotatef(err, "unable to load the fedbox service")
+	}
+	exists := func(h Hash) (bool, error) {
+		if !h.IsValid() {
+			return false, nil
+		}
+		it, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(h.String()))
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return !it.Deleted(), nil
+	}
+	res, err := repo.usage.GC(c.DataPath, linkSnapshotsDir, exists)
+	return res.Files, res.Size, err
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ap/errors"
+)
+
+func TestMediaUsageAllow(t *testing.T) {
+	u := &mediaUsage{quota: 100, accountQuota: 60, Files: make(map[string]mediaFile)}
+	u.Files["snapshots/a"] = mediaFile{Account: "jane", Size: 50}
+	u.Files["snapshots/b"] = mediaFile{Account: "john", Size: 30}
+
+	if err := u.Allow("snapshots/c", "john", 20); err != nil {
+		t.Errorf("expected the file to fit in the quotas, got %s", err)
+	}
+	if err := u.Allow("snapshots/c", "jane", 20); !errors.IsForbidden(err) {
+		t.Errorf("expected the account quota to be exceeded, got %v", err)
+	}
+	if err := u.Allow("snapshots/c", "john", 25); !errors.IsForbidden(err) {
+		t.Errorf("expected the instance quota to be exceeded, got %v", err)
+	}
+	// NOTE(marius): the file replaces the one at the same name, its old size doesn't count
+	if err := u.Allow("snapshots/a", "jane", 55); err != nil {
+		t.Errorf("expected the replacing file to fit in the quotas, got %s", err)
+	}
+}
+
+func TestMediaUsageGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u, err := loadMediaUsage("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, linkSnapshotsDir), 0700)
+	write := func(name string, h Hash, tracked bool) {
+		ioutil.WriteFile(filepath.Join(dir, linkSnapshotsDir, name), []byte("snapshot"), 0600)
+		if tracked {
+			u.Add(filepath.Join(linkSnapshotsDir, name), "jane", h, 8)
+		}
+	}
+	write("kept", testHash(1), true)
+	write("deleted", testHash(2), true)
+	write("untracked", testHash(3), false)
+	u.Add(filepath.Join(linkSnapshotsDir, "missing"), "jane", testHash(4), 8)
+
+	exists := func(h Hash) (bool, error) { return h == testHash(1), nil }
+	res, err := u.GC(dir, linkSnapshotsDir, exists)
+	if err != nil {
+		t.Fatalf("unable to collect the media files: %s", err)
+	}
+	if res.Files != 2 || res.Size != 16 {
+		t.Errorf("expected 2 files of 16 bytes to be removed, got %d of %d", res.Files, res.Size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, linkSnapshotsDir, "kept")); err != nil {
+		t.Errorf("expected the file of the existing item to be kept")
+	}
+	if len(u.Files) != 1 || u.Used() != 8 {
+		t.Errorf("expected only the kept file to be tracked, got %v", u.Files)
+	}
+}
+
+func TestFmtSize(t *testing.T) {
+	tests := map[int64]string{
+		512:     "512 B",
+		1536:    "1.5 KiB",
+		5 << 20: "5.0 MiB",
+		3 << 30: "3.0 GiB",
+	}
+	for n, want := range tests {
+		if got := fmtSize(n); got != want {
+			t.Errorf("fmtSize(%d) = %q, expected %q", n, got, want)
+		}
+	}
+}
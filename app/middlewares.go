@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
@@ -30,13 +31,22 @@ func (h handler) LoadAuthorMw(next http.Handler) http.Handler {
 				Name: CompStrs{EqualsString(handle)},
 			}
 			repo := ContextRepository(r.Context())
-			authors, err = repo.accounts(context.TODO(), fa)
+			authors, err = repo.accounts(r.Context(), fa)
 			if err != nil {
 				h.ErrorHandler(err).ServeHTTP(w, r)
 				return
 			}
 		}
 		if len(authors) == 0 {
+			if current, ok := h.storage.renames.Resolve(handle); ok {
+				// NOTE(marius): the account was renamed, we keep the rest of the path, so the links to its items still work
+				url := "/~" + current + strings.TrimPrefix(r.URL.Path, "/~"+handle)
+				if len(r.URL.RawQuery) > 0 {
+					url += "?" + r.URL.RawQuery
+				}
+				h.v.Redirect(w, r, url, http.StatusMovedPermanently)
+				return
+			}
 			h.ErrorHandler(errors.NotFoundf("Account %q", chi.URLParam(r, "handle"))).ServeHTTP(w, r)
 			return
 		}
@@ -44,6 +54,38 @@ func (h handler) LoadAuthorMw(next http.Handler) http.Handler {
 	})
 }
 
+// SettingsModelMw loads the settings page of the logged account
+func (h handler) SettingsModelMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc := loggedAccount(r)
+		m := &settingsModel{
+			Title:       "Account settings",
+			Account:     acc,
+			NextRename:  h.storage.renames.NextRename(accountIRI(acc).String()),
+			ReserveDays: int(h.conf.HandleReservePeriod.Hours() / 24),
+			RenameDays:  int(h.conf.HandleRenameInterval.Hours() / 24),
+		}
+		m.AvatarLookup = h.storage.avatars.Enabled()
+		m.HasAvatarLookup = h.storage.avatars.Has(accountIRI(acc).String())
+		m.Settings = acc.Settings()
+		m.FeedToken = h.storage.feeds.Token(accountIRI(acc).String())
+		m.MailGateway = h.storage.mail.Enabled()
+		m.MailAddress = h.storage.mail.Address(accountIRI(acc).String())
+		m.Digests = h.storage.digests.Enabled()
+		if _, d, ok := h.storage.digests.Get(accountIRI(acc).String()); ok {
+			m.Digest = &d
+		}
+		m.PushKey = h.storage.push.PublicKey()
+		m.PushDevices = h.storage.push.Count(accountIRI(acc).String())
+		m.PushEvents = h.storage.push.EventsOf(accountIRI(acc).String())
+		m.NtfyEnabled = h.storage.channels.Enabled(channelNtfy)
+		m.GotifyEnabled = h.storage.channels.Enabled(channelGotify)
+		m.NotificationEmail = h.storage.channels.EmailAddress(accountIRI(acc).String())
+		m.Channels = h.storage.channels.Get(accountIRI(acc).String())
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ModelCtxtKey, m)))
+	})
+}
+
 func LoadOutboxMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authors := ContextAuthors(r.Context())
@@ -57,7 +99,7 @@ func LoadOutboxMw(next http.Handler) http.Handler {
 		var cursor = new(Cursor)
 		cursor.items = make(RenderableList, 0)
 		for _, author := range authors {
-			if c, err := repo.LoadAccountWithDetails(context.TODO(), author, f...); err == nil {
+			if c, err := repo.LoadAccountWithDetails(r.Context(), author, f...); err == nil {
 				cursor.items.Merge(c.items)
 				cursor.total += c.total
 				cursor.before = c.before
@@ -78,7 +120,7 @@ func LoadInboxMw(next http.Handler) http.Handler {
 			ctxtErr(next, w, r, errors.MethodNotAllowedf("nil account"))
 			return
 		}
-		cursor, err := repo.LoadActorInbox(context.TODO(), acc.pub, f...)
+		cursor, err := repo.LoadActorInbox(r.Context(), acc.pub, f...)
 		if err != nil {
 			ctxtErr(next, w, r, errors.Annotatef(err, "unable to load current account's inbox"))
 			return
@@ -92,10 +134,16 @@ func LoadServiceInboxMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		f := ContextActivityFilters(r.Context())
 		repo := ContextRepository(r.Context())
-		cursor, err := repo.LoadActorInbox(context.TODO(), repo.fedbox.Service(), f...)
+		cursor, err := repo.LoadActorInbox(r.Context(), repo.fedbox.Service(), f...)
 		if err != nil {
-			ctxtErr(next, w, r, errors.Annotatef(err, "unable to load the %s's inbox", repo.fedbox.Service().Type))
-			return
+			stale, ok := repo.stale.get(r.URL.RequestURI())
+			if !ok || !isBackendFailure(err) {
+				ctxtErr(next, w, r, errors.Annotatef(err, "unable to load the %s's inbox", repo.fedbox.Service().Type))
+				return
+			}
+			cursor = stale
+		} else if !cursor.partial {
+			repo.stale.set(r.URL.RequestURI(), *cursor)
 		}
 		ctx := context.WithValue(r.Context(), CursorCtxtKey, cursor)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -107,7 +155,7 @@ func LoadServiceWithSelfAuthInboxMw(next http.Handler) http.Handler {
 		f := ContextActivityFilters(r.Context())
 		repo := ContextRepository(r.Context())
 		repo.fedbox.SignBy(repo.app)
-		cursor, err := repo.LoadActorInbox(context.TODO(), repo.fedbox.Service(), f...)
+		cursor, err := repo.LoadActorInbox(r.Context(), repo.fedbox.Service(), f...)
 		if err != nil {
 			ctxtErr(next, w, r, errors.Annotatef(err, "unable to load the %s's inbox", repo.fedbox.Service().Type))
 			return
@@ -135,7 +183,7 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 
 		ff := ContextActivityFilters(r.Context())
 		repo := ContextRepository(r.Context())
-		ctx := context.TODO()
+		ctx := r.Context()
 
 		if len(ff) == 0 {
 			ctxtErr(next, w, r, errors.Newf("invalid filter"))
@@ -149,6 +197,8 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 			ctxtErr(next, w, r, errors.NotFoundf("Object not found"))
 			return
 		}
+		// NOTE(marius): only the items loaded from the service's inbox are public, so we can cache their comments
+		cacheable := col.Count() > 0
 		if col.Count() == 0 {
 			// if nothing found, try to load from the logged account's collections
 			current := ContextAccount(r.Context())
@@ -184,22 +234,16 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 			ctxtErr(next, w, r, errors.NotFoundf("Object not found"))
 			return
 		}
-		items := ItemCollection{i}
-		if comments, err := repo.loadItemsReplies(ctx, i); err == nil {
-			items = append(items, comments...)
-		}
-		if items, err = repo.loadItemsAuthors(ctx, items...); err != nil {
-			repo.errFn()("unable to load item authors")
-		}
-		if items, err = repo.loadItemsVotes(ctx, items...); err != nil {
-			repo.errFn()("unable to load item votes")
-		}
-		c := &Cursor{
-			items: make(RenderableList),
-		}
-		for k := range items {
-			c.items.Append(Renderable(&items[k]))
+		iri, variant := itemIRI(&i), commentTreeVariant(r)
+		c, cached := repo.trees.get(iri, variant)
+		if !cached {
+			var complete bool
+			if c, complete = repo.loadCommentTree(ctx, &i); cacheable && complete {
+				repo.trees.set(iri, variant, *c)
+			}
 		}
+		// NOTE(marius): for federated items we schedule a new load of their replies if the previous one is stale
+		repo.fill.Schedule(i)
 		m := ContextContentModel(r.Context())
 		m.Title = "Replies to item"
 		if i.SubmittedBy != nil {
@@ -213,6 +257,50 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 	})
 }
 
+// loadCommentTree loads the replies of the item, with their authors and votes, and arranges them in their tree
+// It returns false if something failed to load.
+func (r *repository) loadCommentTree(ctx context.Context, i *Item) (*Cursor, bool) {
+	c := &Cursor{
+		items: make(RenderableList),
+	}
+	complete := true
+	failed := func(err error) {
+		complete = false
+		c.partial = c.partial || isTimeout(err)
+	}
+	// NOTE(marius): replies which don't reference their conversation get threaded under its top level item
+	if resolved := r.resolveConversations(ctx, ItemCollection{*i}); len(resolved) == 1 {
+		*i = resolved[0]
+	}
+	items := ItemCollection{*i}
+	if comments, err := r.loadItemsReplies(ctx, *i); err == nil {
+		items = append(items, r.resolveConversations(ctx, comments)...)
+	} else {
+		failed(err)
+	}
+	// NOTE(marius): for federated items we add the replies loaded from their origin server
+	// that fedbox doesn't know about
+	for _, rem := range r.fill.Replies(*i) {
+		if !items.Contains(rem) {
+			items = append(items, rem)
+		}
+	}
+	var err error
+	if items, err = r.loadItemsAuthors(ctx, items...); err != nil {
+		r.errFn()("unable to load item authors")
+		failed(err)
+	}
+	if items, err = r.loadItemsVotes(ctx, items...); err != nil {
+		r.errFn()("unable to load item votes")
+		failed(err)
+	}
+	for k := range items {
+		c.items.Append(Renderable(&items[k]))
+	}
+	threadCursor(c)
+	return c, complete
+}
+
 func ModelMw(m Model) Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -268,6 +356,7 @@ func ContentModelMw(next http.Handler) http.Handler {
 		m.Message.Label = "Reply:"
 		m.Message.Back = "/"
 		m.Message.SubmitLabel = htmlf("Reply %s", icon("reply", "h-mirror"))
+		m.Message.Preview = true
 		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ModelCtxtKey, m)))
 	})
 }
@@ -289,6 +378,7 @@ func EditContentModelMw(next http.Handler) http.Handler {
 		m.Message.Label = "Edit:"
 		m.Message.Back = "/"
 		m.Message.SubmitLabel = htmlf("%s Save", icon("edit"))
+		m.Message.Preview = true
 		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ModelCtxtKey, m)))
 	})
 }
@@ -308,6 +398,7 @@ func AddModelMw(next http.Handler) http.Handler {
 		m.Message.Label = "Add new submission:"
 		m.Message.Back = "/"
 		m.Message.SubmitLabel = htmlf("%s Submit", icon("reply", "h-mirror", "v-mirror"))
+		m.Message.Preview = true
 		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ModelCtxtKey, m)))
 	})
 }
@@ -325,6 +416,7 @@ func reportModelFromCtx(ctx context.Context) *moderationModel {
 	m.Message.Editable = false
 	m.Message.SubmitLabel = htmlf("%s Report", icon("flag"))
 	m.Message.Label = "Please add your reason for reporting:"
+	m.Message.Categories = reportCategories
 	m.Message.Back = "/"
 
 	return m
@@ -452,51 +544,54 @@ func ThreadedListingMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer next.ServeHTTP(w, r)
 
-		c := ContextCursor(r.Context())
-		if c == nil {
-			return
+		if c := ContextCursor(r.Context()); c != nil && !c.threaded {
+			threadCursor(c)
 		}
+	})
+}
 
-		comments := make(ItemPtrCollection, 0)
-		accounts := make(AccountPtrCollection, 0)
-		for _, ren := range c.items {
-			if it, ok := ren.(*Item); ok {
-				comments = append(comments, it)
-			}
-			if ac, ok := ren.(*Account); ok {
-				accounts = append(accounts, ac)
-			}
+// threadCursor arranges the comments and accounts of the cursor in their trees
+func threadCursor(c *Cursor) {
+	comments := make(ItemPtrCollection, 0)
+	accounts := make(AccountPtrCollection, 0)
+	for _, ren := range c.items {
+		if it, ok := ren.(*Item); ok {
+			comments = append(comments, it)
+		}
+		if ac, ok := ren.(*Account); ok {
+			accounts = append(accounts, ac)
 		}
+	}
 
-		reparentComments(&comments)
-		addLevelComments(comments)
+	reparentComments(&comments)
+	addLevelComments(comments)
 
-		reparentAccounts(&accounts)
-		addLevelAccounts(accounts)
+	reparentAccounts(&accounts)
+	addLevelAccounts(accounts)
 
-		newitems := make(RenderableList, 0)
-		for _, ren := range c.items {
-			switch ren.Type() {
-			case CommentType:
-				for _, it := range comments {
-					if it == ren {
-						newitems.Append(it)
-					}
+	newitems := make(RenderableList, 0)
+	for _, ren := range c.items {
+		switch ren.Type() {
+		case CommentType:
+			for _, it := range comments {
+				if it == ren {
+					newitems.Append(it)
 				}
-			case ActorType:
-				for _, ac := range accounts {
-					if ac == ren {
-						newitems.Append(ac)
-					}
+			}
+		case ActorType:
+			for _, ac := range accounts {
+				if ac == ren {
+					newitems.Append(ac)
 				}
-			default:
-				newitems.Append(ren)
 			}
+		default:
+			newitems.Append(ren)
 		}
-		if len(newitems) > 0 {
-			c.items = newitems
-		}
-	})
+	}
+	if len(newitems) > 0 {
+		c.items = newitems
+	}
+	c.threaded = true
 }
 
 func (h *handler) OutOfOrderMw(next http.Handler) http.Handler {
@@ -526,6 +621,21 @@ func SortByScore(next http.Handler) http.Handler {
 	})
 }
 
+// SortByGravity sorts by score, with the gravity of the listing instead of the default one
+func SortByGravity(gravity float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer next.ServeHTTP(w, r)
+
+			m := ContextListingModel(r.Context())
+			if m == nil {
+				return
+			}
+			m.sortFn = ByScoreWithGravity(gravity)
+		})
+	}
+}
+
 func SortByDate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer next.ServeHTTP(w, r)
@@ -536,3 +646,14 @@ func SortByDate(next http.Handler) http.Handler {
 		m.sortFn = ByDate
 	})
 }
+
+// SortFromRequest sorts by date when the "sort" URL parameter is "new", and by score otherwise
+func SortFromRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sort") == "new" {
+			SortByDate(next).ServeHTTP(w, r)
+			return
+		}
+		SortByScore(next).ServeHTTP(w, r)
+	})
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"html/template"
+	"time"
 )
 
 type Paginator interface {
@@ -19,11 +20,17 @@ type listingModel struct {
 	Title    string
 	tpl      string
 	User     *Account
+	Tag      string
+	Window   string
+	Archive  *archive
+	Board    *board
 	Items    RenderableList
 	ShowText bool
 	after    Hash
 	before   Hash
 	sortFn   func(list RenderableList) []Renderable
+	// feed is set for the listings which are available as RSS, Atom and JSON feeds too
+	feed bool
 }
 
 func (m listingModel) NextPage() Hash {
@@ -69,6 +76,10 @@ type mBox struct {
 	Content     string
 	Back        string
 	SubmitLabel template.HTML
+	// Preview shows the button for previewing the submission, before saving it
+	Preview bool
+	// Categories are the kinds of problems a report can be about, they're shown only on the report forms
+	Categories []reportCategory
 }
 
 type contentModel struct {
@@ -78,10 +89,19 @@ type contentModel struct {
 	Content      Renderable
 	ShowChildren bool
 	Message      mBox
+	Board        *board
+	Related      []indexEntry
+	Search       commentSearch
+	comments     int
 	after        Hash
 	before       Hash
 }
 
+// ShowCommentSearch returns if the search box is shown, for the long discussions or while searching
+func (m contentModel) ShowCommentSearch() bool {
+	return m.Search.Active() || m.comments >= commentSearchMinCount
+}
+
 func (m contentModel) NextPage() Hash {
 	return m.after
 }
@@ -152,6 +172,12 @@ func (m *contentModel) SetCursor(c *Cursor) {
 	}
 	if m.Content != nil {
 		if it, ok := m.Content.(*Item); ok {
+			m.comments = countComments(it.Children())
+			if m.Search.Active() {
+				filtered := *it
+				filtered.children = m.Search.filter(it.Children())
+				m.Content = &filtered
+			}
 			if it.Private() {
 				lbl := "Reply"
 				if m.Message.Editable {
@@ -237,20 +263,95 @@ func (registerModel) Template() string {
 }
 func (*registerModel) SetCursor(c *Cursor) {}
 
-type aboutModel struct {
+type settingsModel struct {
+	Title       string
+	Account     *Account
+	NextRename  time.Time
+	ReserveDays int
+	RenameDays  int
+	// AvatarLookup is set when the instance can load the avatars from Libravatar/Gravatar
+	AvatarLookup bool
+	// HasAvatarLookup is set when the account opted in to using its Libravatar/Gravatar avatar
+	HasAvatarLookup bool
+	Settings        AccountSettings
+	// FeedToken authorizes the private feeds of the account, it's empty if they're not enabled
+	FeedToken string
+	// MailGateway is set when the instance accepts submissions and comments by email
+	MailGateway bool
+	// MailAddress is the secret posting address of the account
+	MailAddress string
+	// Digests is set when the instance can send the digest emails
+	Digests bool
+	// Digest is the digest subscription of the account, if it has one
+	Digest *digestSubscription
+	// PushKey is the VAPID public key the browsers subscribe to the push notifications with,
+	// it's empty if they're disabled
+	PushKey string
+	// PushDevices is the number of browsers the account gets push notifications in
+	PushDevices int
+	PushEvents  notificationEvents
+	// NtfyEnabled and GotifyEnabled are set when the instance allows the ntfy and Gotify notification channels
+	NtfyEnabled   bool
+	GotifyEnabled bool
+	// NotificationEmail is the address the email notifications are sent to, it's empty if the account
+	// didn't confirm one, or if the instance doesn't send emails
+	NotificationEmail string
+	Channels          notificationChannel
+}
+
+func (m *settingsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (settingsModel) Template() string {
+	return "settings"
+}
+
+func (*settingsModel) SetCursor(c *Cursor) {}
+
+// Fields returns the profile fields of the account, with empty ones up to the maximum number, for the settings form
+func (m settingsModel) Fields() []ProfileField {
+	fields := make([]ProfileField, maxProfileFields)
+	if m.Account != nil && m.Account.HasMetadata() {
+		copy(fields, m.Account.Metadata.Fields)
+	}
+	return fields
+}
+
+// CanRename returns if the account is allowed to change its handle now
+func (m settingsModel) CanRename() bool {
+	return time.Now().After(m.NextRename)
+}
+
+type pageModel struct {
+	Title string
+	Page  Page
+}
+
+func (m *pageModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (m pageModel) Template() string {
+	return "page"
+}
+
+func (*pageModel) SetCursor(c *Cursor) {}
+
+type instancesModel struct {
 	Title string
-	Desc  Desc
+	Peers []Peer
 }
 
-func (m *aboutModel) SetTitle(s string) {
+func (m *instancesModel) SetTitle(s string) {
 	m.Title = s
 }
 
-func (m aboutModel) Template() string {
-	return "about"
+func (m instancesModel) Template() string {
+	return "instances"
 }
 
-func (*aboutModel) SetCursor(c *Cursor) {}
+func (*instancesModel) SetCursor(c *Cursor) {}
 
 type errorModel struct {
 	Status     int
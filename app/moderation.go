@@ -116,6 +116,8 @@ type ModerationOp struct {
 	Metadata    *ModerationMetadata `json:"-"`
 	pub         pub.Item            `json:"-"`
 	Flags       FlagBits            `json:"flags,omitempty"`
+	// Category is the kind of problem a report is about, see reportCategories
+	Category    string              `json:"category,omitempty"`
 }
 
 type ModerationMetadata struct {
@@ -278,6 +280,9 @@ func (m *ModerationOp) FromActivityPub(it pub.Item) error {
 		if len(reason.MimeType) > 0 {
 			m.MimeType = reason.MimeType
 		}
+		if len(reportCategoryLabel(reason.Title)) > 0 {
+			m.Category = reason.Title
+		}
 		m.SubmittedAt = a.Published
 		m.Metadata = &ModerationMetadata{
 			ID: string(a.ID),
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	accountNotesFile     = "account-notes.json"
+	accountNoteMaxLength = 2000
+
+	NoteAdded   = "added"
+	NoteEdited  = "edited"
+	NoteRemoved = "removed"
+)
+
+// accountNote is a private note of a moderator about an account, only the staff can see it
+type accountNote struct {
+	ID        int       `json:"id"`
+	Text      string    `json:"text"`
+	By        string    `json:"by"`
+	At        time.Time `json:"at"`
+	UpdatedAt time.Time `json:"updated,omitempty"`
+}
+
+// noteChange is an entry of the log of the changes to the notes of an account
+type noteChange struct {
+	Action string    `json:"action"`
+	NoteID int       `json:"note"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+	// Text is the text of the note before the change, for the edits and the removals
+	Text string `json:"text,omitempty"`
+}
+
+// accountNotesEntry are the notes of an account, with the history of their changes
+type accountNotesEntry struct {
+	Hash    Hash          `json:"hash"`
+	Handle  string        `json:"handle"`
+	Notes   []accountNote `json:"notes"`
+	Changes []noteChange  `json:"changes"`
+}
+
+// accountNotes are the notes of the moderators, by the hash of the account they're about
+type accountNotes struct {
+	m        sync.RWMutex
+	path     string
+	accounts map[Hash]accountNotesEntry
+}
+
+func loadAccountNotes(path string) (*accountNotes, error) {
+	n := &accountNotes{path: path, accounts: make(map[Hash]accountNotesEntry)}
+	return n, loadJSON(path, &n.accounts)
+}
+
+// Get returns the notes of the account with hash h, with their changes
+func (n *accountNotes) Get(h Hash) accountNotesEntry {
+	if n == nil {
+		return accountNotesEntry{}
+	}
+	n.m.RLock()
+	defer n.m.RUnlock()
+	return n.accounts[h]
+}
+
+// List returns the accounts which have notes, or had them, ordered by their handle
+func (n *accountNotes) List() []accountNotesEntry {
+	if n == nil {
+		return nil
+	}
+	n.m.RLock()
+	defer n.m.RUnlock()
+	list := make([]accountNotesEntry, 0, len(n.accounts))
+	for _, e := range n.accounts {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Handle) < strings.ToLower(list[j].Handle)
+	})
+	return list
+}
+
+func validNoteText(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return "", errors.BadRequestf("the note is empty")
+	}
+	if len(text) > accountNoteMaxLength {
+		return "", errors.BadRequestf("the note is longer than %d characters", accountNoteMaxLength)
+	}
+	return text, nil
+}
+
+// Add saves a new note about acc, written by the moderator mod
+func (n *accountNotes) Add(acc Account, mod *Account, text string) error {
+	text, err := validNoteText(text)
+	if err != nil {
+		return err
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	e := n.accounts[acc.Hash]
+	e.Hash, e.Handle = acc.Hash, acc.Handle
+	id := 1
+	for _, c := range e.Changes {
+		if c.NoteID >= id {
+			id = c.NoteID + 1
+		}
+	}
+	now := time.Now().UTC()
+	e.Notes = append(e.Notes, accountNote{ID: id, Text: text, By: mod.Handle, At: now})
+	e.Changes = append(e.Changes, noteChange{Action: NoteAdded, NoteID: id, By: mod.Handle, At: now})
+	n.accounts[acc.Hash] = e
+	return n.save()
+}
+
+// Edit changes the text of the note with id, the previous text is kept in the changes
+func (n *accountNotes) Edit(acc Account, mod *Account, id int, text string) error {
+	text, err := validNoteText(text)
+	if err != nil {
+		return err
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	e := n.accounts[acc.Hash]
+	// NOTE(marius): the notes are copied, the slices returned by Get can still be in use
+	e.Notes = append([]accountNote(nil), e.Notes...)
+	for i, note := range e.Notes {
+		if note.ID != id {
+			continue
+		}
+		if note.Text == text {
+			return nil
+		}
+		now := time.Now().UTC()
+		e.Notes[i].Text = text
+		e.Notes[i].UpdatedAt = now
+		e.Changes = append(e.Changes, noteChange{Action: NoteEdited, NoteID: id, By: mod.Handle, At: now, Text: note.Text})
+		n.accounts[acc.Hash] = e
+		return n.save()
+	}
+	return errors.NotFoundf("note %d", id)
+}
+
+// Remove deletes the note with id, its text is kept in the changes
+func (n *accountNotes) Remove(acc Account, mod *Account, id int) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	e := n.accounts[acc.Hash]
+	for i, note := range e.Notes {
+		if note.ID != id {
+			continue
+		}
+		notes := make([]accountNote, 0, len(e.Notes)-1)
+		e.Notes = append(append(notes, e.Notes[:i]...), e.Notes[i+1:]...)
+		e.Changes = append(e.Changes, noteChange{Action: NoteRemoved, NoteID: id, By: mod.Handle, At: time.Now().UTC(), Text: note.Text})
+		n.accounts[acc.Hash] = e
+		return n.save()
+	}
+	return errors.NotFoundf("note %d", id)
+}
+
+// save writes the notes to disk, it needs to be called with the lock held
+func (n *accountNotes) save() error {
+	return saveJSON(n.path, n.accounts)
+}
+
+type accountNotesModel struct {
+	Title   string
+	Account *Account
+	Notes   accountNotesEntry
+}
+
+func (m *accountNotesModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (accountNotesModel) Template() string {
+	return "notes"
+}
+
+// HandleAccountNotes serves the /~{handle}/notes requests, with the notes of the moderators about the account
+// and the history of their changes
+func (h *handler) HandleAccountNotes(w http.ResponseWriter, r *http.Request) {
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	acc := authors[0]
+	m := &accountNotesModel{Title: fmt.Sprintf("Notes about %s", acc.Handle), Account: &acc, Notes: h.storage.notes.Get(acc.Hash)}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleSaveAccountNote handles the POST /~{handle}/notes requests, which add a note, or edit the one with the id
+// from the form. Every change gets logged, besides being kept in the history of the notes.
+func (h *handler) HandleSaveAccountNote(w http.ResponseWriter, r *http.Request) {
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	acc, mod := authors[0], loggedAccount(r)
+	back := fmt.Sprintf("%s/notes", AccountPermaLink(&acc))
+
+	var id int
+	fmt.Sscanf(r.PostFormValue("id"), "%d", &id)
+	remove := r.PostFormValue("action") == "rm"
+	action := NoteAdded
+	var err error
+	switch {
+	case remove:
+		action = NoteRemoved
+		err = h.storage.notes.Remove(acc, mod, id)
+	case id > 0:
+		action = NoteEdited
+		err = h.storage.notes.Edit(acc, mod, id, r.PostFormValue("text"))
+	default:
+		err = h.storage.notes.Add(acc, mod, r.PostFormValue("text"))
+	}
+	lCtx := log.Ctx{"account": acc.Handle, "moderator": mod.Handle, "note": id, "action": action}
+	if err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the account note")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save the note: %s", err))
+	} else {
+		h.infoFn(lCtx)("account note %s", action)
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The note was %s.", action))
+	}
+	h.v.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+type accountNotesListModel struct {
+	Title    string
+	Accounts []accountNotesEntry
+}
+
+func (m *accountNotesListModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (accountNotesListModel) Template() string {
+	return "admin-notes"
+}
+
+// HandleAdminNotes serves the /admin/notes requests, with the accounts the moderators wrote notes about
+func (h *handler) HandleAdminNotes(w http.ResponseWriter, r *http.Request) {
+	m := &accountNotesListModel{Title: "Account notes", Accounts: h.storage.notes.List()}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
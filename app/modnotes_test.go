@@ -0,0 +1,54 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestAccountNotes(t *testing.T) {
+	n, err := loadAccountNotes("")
+	if err != nil {
+		t.Fatalf("unable to load the notes: %s", err)
+	}
+	acc := Account{Handle: "johndoe", Hash: HashFromString("f00f00f00")}
+	mod := &Account{Handle: "mod", Hash: HashFromString("b4db4db4d")}
+
+	if err := n.Add(acc, mod, "  "); err == nil {
+		t.Errorf("an empty note was saved")
+	}
+	if err := n.Add(acc, mod, "first"); err != nil {
+		t.Fatalf("unable to add the note: %s", err)
+	}
+	if err := n.Add(acc, mod, "second"); err != nil {
+		t.Fatalf("unable to add the note: %s", err)
+	}
+	if err := n.Edit(acc, mod, 1, "first, edited"); err != nil {
+		t.Fatalf("unable to edit the note: %s", err)
+	}
+	if err := n.Remove(acc, mod, 2); err != nil {
+		t.Fatalf("unable to remove the note: %s", err)
+	}
+	if err := n.Remove(acc, mod, 2); err == nil {
+		t.Errorf("the note was removed twice")
+	}
+	// NOTE(marius): the ids of the removed notes don't get reused, so the changes point to the right note
+	if err := n.Add(acc, mod, "third"); err != nil {
+		t.Fatalf("unable to add the note: %s", err)
+	}
+
+	e := n.Get(acc.Hash)
+	if len(e.Notes) != 2 || e.Notes[0].Text != "first, edited" || e.Notes[1].ID != 3 {
+		t.Errorf("unexpected notes %v", e.Notes)
+	}
+	wantActions := []string{NoteAdded, NoteAdded, NoteEdited, NoteRemoved, NoteAdded}
+	if len(e.Changes) != len(wantActions) {
+		t.Fatalf("expected %d changes, got %d", len(wantActions), len(e.Changes))
+	}
+	for i, c := range e.Changes {
+		if c.Action != wantActions[i] || c.By != mod.Handle {
+			t.Errorf("change %d: expected %q by %s, got %q by %s", i, wantActions[i], mod.Handle, c.Action, c.By)
+		}
+	}
+	if e.Changes[2].Text != "first" || e.Changes[3].Text != "second" {
+		t.Errorf("the changes don't keep the previous texts: %v", e.Changes)
+	}
+}
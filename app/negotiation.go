@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	j "github.com/go-ap/jsonld"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	ActivityJSONContentType = "application/activity+json"
+	LDJSONContentType       = "application/ld+json"
+
+	objectCacheTTL  = time.Minute
+	objectCacheSize = 1000
+)
+
+// wantsActivityPub returns if the request accepts ActivityPub content instead of HTML
+func wantsActivityPub(r *http.Request) bool {
+	for _, acc := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(acc))
+		if err != nil {
+			continue
+		}
+		if typ == ActivityJSONContentType {
+			return true
+		}
+		if typ == LDJSONContentType && strings.Contains(params["profile"], pub.ActivityBaseURI.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedObject struct {
+	dat   []byte
+	rcpts pub.IRIs
+	at    time.Time
+}
+
+// objectCache keeps the JSON representation of the objects we loaded from fedbox for a short while,
+// as remote servers tend to dereference the same permalinks in bursts when they're shared
+type objectCache struct {
+	m    sync.RWMutex
+	objs map[pub.IRI]cachedObject
+}
+
+func newObjectCache() *objectCache {
+	return &objectCache{objs: make(map[pub.IRI]cachedObject)}
+}
+
+func (c *objectCache) get(iri pub.IRI) (cachedObject, bool) {
+	if c == nil {
+		return cachedObject{}, false
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	ob, ok := c.objs[iri]
+	if !ok || time.Since(ob.at) > objectCacheTTL {
+		return cachedObject{}, false
+	}
+	return ob, true
+}
+
+func (c *objectCache) set(iri pub.IRI, ob cachedObject) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.objs) >= objectCacheSize {
+		for k, ob := range c.objs {
+			if time.Since(ob.at) > objectCacheTTL {
+				delete(c.objs, k)
+			}
+		}
+		if len(c.objs) >= objectCacheSize {
+			c.objs = make(map[pub.IRI]cachedObject)
+		}
+	}
+	ob.at = time.Now()
+	c.objs[iri] = ob
+}
+
+// remove drops the objects that have been changed or deleted
+func (c *objectCache) remove(iris ...pub.IRI) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, iri := range iris {
+		delete(c.objs, iri)
+	}
+}
+
+// objectRecipients returns the IRIs the object is addressed to, together with its author
+func objectRecipients(it pub.Item) pub.IRIs {
+	rcpts := make(pub.IRIs, 0)
+	pub.OnObject(it, func(o *pub.Object) error {
+		for _, col := range []pub.ItemCollection{o.To, o.Bto, o.CC, o.BCC, o.Audience} {
+			for _, rec := range col {
+				rcpts = append(rcpts, rec.GetLink())
+			}
+		}
+		if o.AttributedTo != nil {
+			rcpts = append(rcpts, o.AttributedTo.GetLink())
+		}
+		return nil
+	})
+	return rcpts
+}
+
+// visibleTo returns if the object can be shown to acc
+func (ob cachedObject) visibleTo(acc *Account) bool {
+	if ob.rcpts.Contains(pub.PublicNS) {
+		return true
+	}
+	return acc.IsLogged() && ob.rcpts.Contains(accountIRI(acc))
+}
+
+// objectJSON returns the JSON-LD representation of the fedbox object, without its blind recipients
+func (r *repository) objectJSON(ctx context.Context, iri pub.IRI) (cachedObject, error) {
+	if ob, ok := r.apObjs.get(iri); ok {
+		return ob, nil
+	}
+	it, err := r.fedbox.client.CtxLoadIRI(ctx, iri)
+	if err != nil {
+		return cachedObject{}, err
+	}
+	if it == nil {
+		return cachedObject{}, errors.NotFoundf("object %s", iri)
+	}
+	if it.GetType() == pub.TombstoneType {
+		return cachedObject{}, errors.NotFoundf("object %s was deleted", iri)
+	}
+	ob := cachedObject{rcpts: objectRecipients(it)}
+	pub.OnObject(it, func(o *pub.Object) error {
+		o.Clean()
+		return nil
+	})
+	if ob.dat, err = j.WithContext(j.IRI(pub.ActivityBaseURI)).Marshal(it); err != nil {
+		return cachedObject{}, err
+	}
+	r.apObjs.set(iri, ob)
+	return ob, nil
+}
+
+func writeActivityPub(w http.ResponseWriter, dat []byte) {
+	w.Header().Set("Content-Type", ActivityJSONContentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// isRouteRoot returns if the request is for the root of the current sub-router
+func isRouteRoot(r *http.Request) bool {
+	rctx := chi.RouteContext(r.Context())
+	return rctx == nil || rctx.RoutePath == "" || rctx.RoutePath == "/"
+}
+
+// ItemActivityPubMw serves the ActivityPub object of the item, instead of its HTML page,
+// to the clients that request it through the Accept header
+func (h *handler) ItemActivityPubMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !isRouteRoot(r) || !wantsActivityPub(r) {
+			w.Header().Add("Vary", "Accept")
+			next.ServeHTTP(w, r)
+			return
+		}
+		hash := HashFromString(chi.URLParam(r, "hash"))
+		if !hash.IsValid() {
+			h.v.HandleErrors(w, r, errors.NotFoundf("%q item", chi.URLParam(r, "hash")))
+			return
+		}
+		iri := objects.IRI(h.storage.fedbox.Service()).AddPath(hash.String())
+		ob, err := h.storage.objectJSON(r.Context(), iri)
+		if err != nil {
+			h.errFn(log.Ctx{"iri": iri, "err": err})("unable to load ActivityPub object")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if !ob.visibleTo(loggedAccount(r)) {
+			// NOTE(marius): we load the objects with the credentials of the application, so we need to check
+			// ourselves that the private ones are only shown to their recipients
+			h.v.HandleErrors(w, r, errors.NotFoundf("%q item", hash))
+			return
+		}
+		writeActivityPub(w, ob.dat)
+	})
+}
+
+// AccountActivityPubMw serves the ActivityPub actor of the account, instead of its HTML page,
+// to the clients that request it through the Accept header
+func (h *handler) AccountActivityPubMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authors := ContextAuthors(r.Context())
+		if len(authors) == 0 || !wantsActivityPub(r) {
+			w.Header().Add("Vary", "Accept")
+			next.ServeHTTP(w, r)
+			return
+		}
+		a := authors[0]
+		if a.pub == nil {
+			h.v.HandleErrors(w, r, errors.NotFoundf("actor %s", a.Handle))
+			return
+		}
+		ob, err := h.storage.objectJSON(r.Context(), a.pub.GetLink())
+		if err != nil {
+			h.errFn(log.Ctx{"iri": a.pub.GetLink(), "err": err})("unable to load ActivityPub actor")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		writeActivityPub(w, ob.dat)
+	})
+}
+
+// TagActivityPubMw serves the items tagged with the tag as an ActivityPub OrderedCollection, instead of the
+// tag's page, to the clients that request it through the Accept header
+func (h *handler) TagActivityPubMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag := chi.URLParam(r, "tag")
+		if len(tag) == 0 || !wantsActivityPub(r) {
+			w.Header().Add("Vary", "Accept")
+			next.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		col := h.storage.tagCollection(tag, len(q.Get("page")) > 0, HashFromString(q.Get("after")))
+		dat, err := j.WithContext(j.IRI(pub.ActivityBaseURI)).Marshal(col)
+		if err != nil {
+			h.errFn(log.Ctx{"tag": tag, "err": err})("unable to render the tag collection")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		writeActivityPub(w, dat)
+	})
+}
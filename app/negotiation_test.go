@@ -0,0 +1,53 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func Test_cachedObject_visibleTo(t *testing.T) {
+	author := pub.IRI("https://example.com/actors/author")
+	recipient := pub.IRI("https://example.com/actors/recipient")
+	other := pub.IRI("https://example.com/actors/other")
+
+	accountOf := func(iri pub.IRI) *Account {
+		return &Account{Hash: HashFromString("00000000-0000-0000-0000-000000000001"), Metadata: &AccountMetadata{ID: iri.String()}}
+	}
+
+	public := cachedObject{rcpts: objectRecipients(&pub.Object{To: pub.ItemCollection{pub.PublicNS}, AttributedTo: author})}
+	private := cachedObject{rcpts: objectRecipients(&pub.Object{To: pub.ItemCollection{recipient}, AttributedTo: author})}
+
+	tests := []struct {
+		name string
+		ob   cachedObject
+		acc  *Account
+		want bool
+	}{
+		{name: "public to anonymous", ob: public, acc: &AnonymousAccount, want: true},
+		{name: "private to anonymous", ob: private, acc: &AnonymousAccount, want: false},
+		{name: "private to recipient", ob: private, acc: accountOf(recipient), want: true},
+		{name: "private to author", ob: private, acc: accountOf(author), want: true},
+		{name: "private to someone else", ob: private, acc: accountOf(other), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ob.visibleTo(tt.acc); got != tt.want {
+				t.Errorf("visibleTo() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_objectCache_remove(t *testing.T) {
+	iri := pub.IRI("https://example.com/objects/1")
+	c := newObjectCache()
+	c.set(iri, cachedObject{dat: []byte("{}")})
+	if _, ok := c.get(iri); !ok {
+		t.Fatalf("object should have been cached")
+	}
+	c.remove(iri)
+	if _, ok := c.get(iri); ok {
+		t.Errorf("object should have been removed from the cache")
+	}
+}
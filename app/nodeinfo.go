@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	githubUrl    = "https://github.com/mariusor/go-littr"
+	author       = "@mariusor@metalhead.club"
+	softwareName = "go-littr"
+
+	nodeInfoSchema      = "http://nodeinfo.diaspora.software/ns/schema/"
+	nodeInfoCacheTime   = 30 * time.Minute
+	nodeInfoLoadTimeOut = 20 * time.Second
+)
+
+// nodeInfoVersions are the versions of the NodeInfo schema we serve
+var nodeInfoVersions = []string{"2.0", "2.1"}
+
+var (
+	postsFilter = &Filters{
+		Type: ActivityTypesFilter(ValidContentTypes...),
+		OP:   nilIRIs,
+	}
+	allFilter = &Filters{
+		Type: ActivityTypesFilter(ValidContentTypes...),
+	}
+)
+
+type nodeInfoSoftware struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"`
+	Homepage   string `json:"homepage,omitempty"`
+}
+
+type nodeInfoUsers struct {
+	Total          int `json:"total"`
+	ActiveMonth    int `json:"activeMonth"`
+	ActiveHalfyear int `json:"activeHalfyear"`
+}
+
+type nodeInfoUsage struct {
+	Users         nodeInfoUsers `json:"users"`
+	LocalPosts    int           `json:"localPosts"`
+	LocalComments int           `json:"localComments"`
+}
+
+type nodeInfoServices struct {
+	Inbound  []string `json:"inbound"`
+	Outbound []string `json:"outbound"`
+}
+
+// nodeInfo is the NodeInfo document, the 2.0 version doesn't have the repository and homepage of the software
+type nodeInfo struct {
+	Version           string                 `json:"version"`
+	Software          nodeInfoSoftware       `json:"software"`
+	Protocols         []string               `json:"protocols"`
+	Services          nodeInfoServices       `json:"services"`
+	OpenRegistrations bool                   `json:"openRegistrations"`
+	Usage             nodeInfoUsage          `json:"usage"`
+	Metadata          map[string]interface{} `json:"metadata"`
+}
+
+// nodeInfoStats caches the usage of the instance, counting the actors and objects in fedbox on every
+// request of the crawlers would be too much
+type nodeInfoStats struct {
+	m        sync.Mutex
+	usage    nodeInfoUsage
+	loadedAt time.Time
+}
+
+// nodeInfoUsage returns the counts of the accounts and items of the instance
+func (r *repository) nodeInfoUsage(ctx context.Context) nodeInfoUsage {
+	r.nodeInfo.m.Lock()
+	defer r.nodeInfo.m.Unlock()
+	if time.Since(r.nodeInfo.loadedAt) < nodeInfoCacheTime {
+		return r.nodeInfo.usage
+	}
+	u := nodeInfoUsage{}
+	// NOTE(marius): fedbox keeps the remote actors we've seen in the same collection, the local ones are under its actors IRI
+	localActors := &Filters{
+		Type: ActivityTypesFilter(ValidActorTypes...),
+		IRI:  CompStrs{LikeString(actors.IRI(r.fedbox.Service()).String())},
+	}
+	if us, err := r.fedbox.Actors(ctx, Values(localActors)); err == nil && us != nil {
+		u.Users.Total = int(us.Count())
+	} else if err != nil {
+		r.errFn(log.Ctx{"err": err})("unable to count the actors for NodeInfo")
+	}
+	if posts, err := r.fedbox.Objects(ctx, Values(postsFilter)); err == nil && posts != nil {
+		u.LocalPosts = int(posts.Count())
+	}
+	if all, err := r.fedbox.Objects(ctx, Values(allFilter)); err == nil && all != nil {
+		u.LocalComments = int(all.Count()) - u.LocalPosts
+	}
+	u.Users.ActiveMonth, u.Users.ActiveHalfyear = activeAuthors(r.index, time.Now())
+
+	r.nodeInfo.usage = u
+	r.nodeInfo.loadedAt = time.Now()
+	return u
+}
+
+// activeAuthors counts the local accounts which submitted something in the last month, and in the last six months
+func activeAuthors(idx *localIndex, now time.Time) (int, int) {
+	month, halfYear := now.AddDate(0, -1, 0), now.AddDate(0, -6, 0)
+	lastActive := make(map[Hash]time.Time)
+	idx.Select(func(e indexEntry) bool {
+		if e.Author.IsValid() && HostIsLocal(e.IRI.String()) && e.SubmittedAt.After(lastActive[e.Author]) {
+			lastActive[e.Author] = e.SubmittedAt
+		}
+		return false
+	}, nil)
+	var activeMonth, activeHalfYear int
+	for _, at := range lastActive {
+		if at.After(month) {
+			activeMonth++
+		}
+		if at.After(halfYear) {
+			activeHalfYear++
+		}
+	}
+	return activeMonth, activeHalfYear
+}
+
+func (h handler) nodeInfo(ctx context.Context, version string) nodeInfo {
+	inf := Instance.NodeInfo()
+	ni := nodeInfo{
+		Version: version,
+		Software: nodeInfoSoftware{
+			Name:    softwareName,
+			Version: inf.Version,
+		},
+		Protocols:         []string{"activitypub"},
+		Services:          nodeInfoServices{Inbound: []string{}, Outbound: []string{"atom1.0"}},
+		OpenRegistrations: h.conf.UserCreatingEnabled,
+		Usage:             h.storage.nodeInfoUsage(ctx),
+		Metadata: map[string]interface{}{
+			"nodeName":        regexp.MustCompile(`<[\/\w]+>`).ReplaceAllString(inf.Title, ""),
+			"nodeDescription": inf.Summary,
+			"private":         false,
+			"software": map[string]string{
+				"github":   githubUrl,
+				"homepage": Instance.BaseURL,
+				"follow":   author,
+			},
+		},
+	}
+	if version != "2.0" {
+		ni.Software.Repository = githubUrl
+		ni.Software.Homepage = Instance.BaseURL
+	}
+	return ni
+}
+
+// HandleNodeInfoDiscover serves /.well-known/nodeinfo, with the links to the NodeInfo documents
+func (h handler) HandleNodeInfoDiscover(w http.ResponseWriter, r *http.Request) {
+	links := make([]link, 0, len(nodeInfoVersions))
+	for _, v := range nodeInfoVersions {
+		links = append(links, link{Rel: nodeInfoSchema + v, Href: Instance.BaseURL + "/nodeinfo/" + v})
+	}
+	dat, _ := json.Marshal(map[string][]link{"links": links})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// HandleNodeInfo serves the /nodeinfo/{version} documents, /nodeinfo is the 2.0 one
+func (h handler) HandleNodeInfo(w http.ResponseWriter, r *http.Request) {
+	version := chi.URLParam(r, "version")
+	if len(version) == 0 {
+		version = nodeInfoVersions[0]
+	}
+	if !stringInSlice(nodeInfoVersions)(version) {
+		errors.HandleError(errors.NotFoundf("NodeInfo version %s", version)).ServeHTTP(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), nodeInfoLoadTimeOut)
+	defer cancel()
+	dat, _ := json.Marshal(h.nodeInfo(ctx, version))
+	w.Header().Set("Content-Type", `application/json; profile="`+nodeInfoSchema+version+`#"`)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/google/uuid"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestActiveAuthors(t *testing.T) {
+	Instance.Conf = &config.Configuration{HostName: "littr.git", APIURL: "https://fedbox.git"}
+	now := time.Now()
+	idx := newLocalIndex()
+	add := func(author Hash, iri pub.IRI, at time.Time) {
+		e := indexEntry{Hash: Hash(uuid.New()), IRI: iri, Author: author, Public: true, SubmittedAt: at}
+		idx.entries[e.Hash] = &e
+	}
+	recent, older, remote := Hash(uuid.New()), Hash(uuid.New()), Hash(uuid.New())
+	add(recent, "https://fedbox.git/objects/1", now.Add(-time.Hour))
+	add(recent, "https://fedbox.git/objects/2", now.AddDate(0, -3, 0))
+	add(older, "https://fedbox.git/objects/3", now.AddDate(0, -3, 0))
+	add(older, "https://fedbox.git/objects/4", now.AddDate(-1, 0, 0))
+	add(remote, "https://mastodon.git/objects/5", now.Add(-time.Hour))
+
+	month, halfYear := activeAuthors(idx, now)
+	if month != 1 || halfYear != 2 {
+		t.Errorf("expected 1 account active in the last month and 2 in the last six, got %d and %d", month, halfYear)
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+const (
+	themeAuto         = ""
+	themeLight        = "light"
+	themeDark         = "dark"
+	themeHighContrast = "high-contrast"
+
+	// themeCookie keeps the theme of the anonymous visitors, the logged accounts have it in their settings
+	themeCookie = "theme"
+)
+
+// Themes are the colour themes which can be chosen instead of following the colours of the system
+var Themes = []string{themeLight, themeDark, themeHighContrast}
+
+func validTheme(t string) bool {
+	return t == themeAuto || stringInSlice(Themes)(t)
+}
+
+// HandleTheme serves the /theme/{kind} requests of the links in the footer, which change the colour theme. It's
+// saved in the settings of the logged accounts, and in a cookie for the others. The "auto" theme follows the system.
+func (h *handler) HandleTheme(w http.ResponseWriter, r *http.Request) {
+	theme := chi.URLParam(r, "kind")
+	if theme == "auto" {
+		theme = themeAuto
+	}
+	if !validTheme(theme) {
+		h.v.HandleErrors(w, r, errors.NotFoundf("theme"))
+		return
+	}
+	if acc := loggedAccount(r); acc.IsLogged() {
+		s := acc.Settings()
+		s.Theme = theme
+		if err := h.saveSettings(r, acc, s); err != nil {
+			h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the theme"))
+			return
+		}
+	} else {
+		c := http.Cookie{Name: themeCookie, Value: theme, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+		if theme == themeAuto {
+			c.Expires = time.Unix(0, 0)
+			c.MaxAge = -1
+		} else {
+			c.Expires = time.Now().Add(1000 * 24 * time.Hour)
+		}
+		http.SetCookie(w, &c)
+	}
+
+	back, ok := safeRedirectPath(r.Header.Get("Referer"))
+	if !ok {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// previewFields are the hidden fields of the submission forms we pass along from the preview page
+var previewFields = []string{"hash", "parent", "op", "mime-type"}
+
+type previewModel struct {
+	Title     string
+	Content   *Item
+	Action    string
+	ShowTitle bool
+	Fields    map[string]string
+}
+
+func (m *previewModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (previewModel) Template() string {
+	return "preview"
+}
+
+// HandlePreview handles the POST /preview requests, from the preview button of the submission forms.
+// It shows how the submission will look, and the form to continue editing it and to submit it.
+func (h *handler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	action, ok := safeRedirectPath(r.PostFormValue("action"))
+	if !ok {
+		h.v.HandleErrors(w, r, errors.BadRequestf("invalid submission form"))
+		return
+	}
+	it, err := ContentFromRequest(r, *acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid submission"))
+		return
+	}
+	m := &previewModel{Title: "Preview", Content: &it, Action: action, Fields: make(map[string]string)}
+	_, m.ShowTitle = r.PostForm["title"]
+	for _, f := range previewFields {
+		if v := r.PostFormValue(f); len(v) > 0 {
+			m.Fields[f] = v
+		}
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/config"
+	xhtml "golang.org/x/net/html"
+)
+
+func TestHandleTheme(t *testing.T) {
+	Instance.Conf = &config.Configuration{HostName: "littr.git"}
+	h := &handler{}
+	mux := chi.NewRouter()
+	mux.Get("/theme/{kind}", h.HandleTheme)
+
+	tests := []struct {
+		name     string
+		kind     string
+		referer  string
+		wantSet  string
+		wantBack string
+	}{
+		{
+			name:     "dark",
+			kind:     "dark",
+			wantSet:  themeDark,
+			wantBack: "/",
+		},
+		{
+			name:     "high contrast, back to the page",
+			kind:     "high-contrast",
+			referer:  "https://littr.git/~marius",
+			wantSet:  themeHighContrast,
+			wantBack: "/~marius",
+		},
+		{
+			name:     "auto, from another site",
+			kind:     "auto",
+			referer:  "https://example.com/",
+			wantBack: "/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/theme/"+tt.kind, nil)
+			r.AddCookie(&http.Cookie{Name: themeCookie, Value: themeLight})
+			if len(tt.referer) > 0 {
+				r.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("expected status %d, got %d", http.StatusSeeOther, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.wantBack {
+				t.Errorf("expected redirect to %q, got %q", tt.wantBack, loc)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != themeCookie {
+				t.Fatalf("expected the %q cookie, got %v", themeCookie, cookies)
+			}
+			set := ""
+			if cookies[0].MaxAge >= 0 {
+				set = cookies[0].Value
+			}
+			if set != tt.wantSet {
+				t.Errorf("expected the theme %q, got %v", tt.wantSet, cookies[0])
+			}
+			if !cookies[0].HttpOnly {
+				t.Errorf("the cookie doesn't need to be readable by the scripts")
+			}
+		})
+	}
+
+}
+
+func renderPartial(t *testing.T, v *view, r *http.Request, name string, m Model, data interface{}) *xhtml.Node {
+	w := httptest.NewRecorder()
+	ren := v.getRenderer()
+	defer v.putRenderer(ren)
+	tpl := ren.TemplateLookup(name)
+	if tpl == nil {
+		t.Fatalf("unable to find the %s template", name)
+	}
+	if err := tpl.Funcs(v.requestFuncs(w, r, m)).Execute(w.Body, data); err != nil {
+		t.Fatalf("unable to render template: %s", err)
+	}
+	doc, err := xhtml.Parse(w.Body)
+	if err != nil {
+		t.Fatalf("unable to parse the rendered template: %s", err)
+	}
+	return doc
+}
+
+func loggedRequest(method, path string) *http.Request {
+	acc := Account{Handle: "test", Hash: HashFromString("f00f00f00"), CreatedAt: time.Now()}
+	r := httptest.NewRequest(method, path, nil)
+	return r.WithContext(context.WithValue(r.Context(), LoggedAccountCtxtKey, &acc))
+}
+
+// TestRender_NoJSFallbacks checks that the controls the script enhances work as plain links and forms
+func TestRender_NoJSFallbacks(t *testing.T) {
+	v := testView(t)
+	conf := *v.c
+	conf.VotingEnabled = true
+	conf.DownvotingEnabled = true
+	v.c = &conf
+
+	t.Run("votes", func(t *testing.T) {
+		it := &Item{Hash: HashFromString("d5ad20a3"), Title: "Test item", Score: 3}
+		doc := renderPartial(t, v, loggedRequest(http.MethodGet, "/"), "partials/item/score", nil, it)
+		forms := findAll(doc, byTag("form"))
+		if len(forms) != 2 {
+			t.Fatalf("expected the yay and nay forms, found %d", len(forms))
+		}
+		for i, dir := range []string{Yay, Nay} {
+			if m, _ := attr(forms[i], "method"); m != "post" {
+				t.Errorf("the %s vote form uses the %q method", dir, m)
+			}
+			if a, _ := attr(forms[i], "action"); !strings.HasSuffix(a, "/"+dir) {
+				t.Errorf("the %s vote form posts to %q", dir, a)
+			}
+			if b := findAll(forms[i], byTag("button")); len(b) == 0 {
+				t.Errorf("the %s vote form has no submit button", dir)
+			}
+		}
+	})
+
+	t.Run("theme", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: themeCookie, Value: themeDark})
+		doc := renderPartial(t, v, r, "partials/footer", nil, &errorModel{})
+		for _, theme := range append([]string{"auto"}, Themes...) {
+			links := findAll(doc, func(n *xhtml.Node) bool {
+				i, _ := attr(n, "id")
+				return n.Data == "a" && i == "theme-"+theme
+			})
+			if len(links) == 0 {
+				t.Fatalf("the %s theme link is missing", theme)
+			}
+			if href, _ := attr(links[0], "href"); href != "/theme/"+theme {
+				t.Errorf("the %s theme link points to %q", theme, href)
+			}
+			if _, current := attr(links[0], "aria-current"); current != (theme == themeDark) {
+				t.Errorf("the %s theme link is marked as current: %t", theme, current)
+			}
+		}
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		m := &contentModel{Content: new(Item)}
+		m.Message.Preview = true
+		m.Message.ShowTitle = true
+		m.Message.Editable = true
+		r := loggedRequest(http.MethodGet, "/submit")
+		doc := renderPartial(t, v, r, "partials/content/edit", m, m)
+		buttons := findAll(doc, func(n *xhtml.Node) bool {
+			a, _ := attr(n, "formaction")
+			return n.Data == "button" && a == "/preview"
+		})
+		if len(buttons) == 0 {
+			t.Fatalf("the form has no preview button")
+		}
+		actions := findAll(doc, func(n *xhtml.Node) bool {
+			name, _ := attr(n, "name")
+			return n.Data == "input" && name == "action"
+		})
+		if len(actions) == 0 {
+			t.Fatalf("the form doesn't pass its action to the preview")
+		}
+		if val, _ := attr(actions[0], "value"); val != "/submit" {
+			t.Errorf("the preview returns to %q, instead of %q", val, "/submit")
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	notificationsFile = "notifications.json"
+	// maxNotifications is how many notifications we keep for an account, the older ones are dropped
+	maxNotifications = 200
+)
+
+// notificationEntry is a notification in the notifications page of an account
+type notificationEntry struct {
+	ID      string    `json:"id"`
+	Event   string    `json:"event"`
+	Actor   string    `json:"actor,omitempty"`
+	Title   string    `json:"title"`
+	Summary string    `json:"summary,omitempty"`
+	Body    string    `json:"body,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	At      time.Time `json:"at"`
+	Read    bool      `json:"read,omitempty"`
+}
+
+// notificationLog keeps the notifications of the local accounts, newest first, and if they have been read
+type notificationLog struct {
+	m       sync.RWMutex
+	path    string
+	max     int
+	Entries map[string][]notificationEntry `json:"entries"`
+}
+
+func loadNotificationLog(path string, max int) (*notificationLog, error) {
+	l := &notificationLog{path: path, max: max, Entries: make(map[string][]notificationEntry)}
+	return l, loadJSON(path, l)
+}
+
+// Notify adds the notification to the ones of the account with id
+func (l *notificationLog) Notify(_ context.Context, id, event string, n notification) error {
+	// NOTE(marius): the batches of the quiet hours are already in the log, as the notifications they hold
+	if l == nil || len(id) == 0 || event == eventBatch {
+		return nil
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	e := notificationEntry{
+		ID:      hex.EncodeToString(buf),
+		Event:   event,
+		Actor:   n.Actor,
+		Title:   n.Title,
+		Summary: n.Summary,
+		Body:    n.Body,
+		URL:     n.URL,
+		Target:  n.Target,
+		At:      time.Now().UTC(),
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	entries := append([]notificationEntry{e}, l.Entries[id]...)
+	if l.max > 0 && len(entries) > l.max {
+		entries = entries[:l.max]
+	}
+	l.Entries[id] = entries
+	return l.save()
+}
+
+// List returns the notifications of the account with id, newest first
+func (l *notificationLog) List(id string) []notificationEntry {
+	if l == nil {
+		return nil
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return append([]notificationEntry(nil), l.Entries[id]...)
+}
+
+// Unread returns how many notifications the account with id didn't read
+func (l *notificationLog) Unread(id string) int {
+	if l == nil {
+		return 0
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	cnt := 0
+	for _, e := range l.Entries[id] {
+		if !e.Read {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// MarkRead marks the notifications with ids of the account with id as read, or all of them when ids is empty
+func (l *notificationLog) MarkRead(id string, ids ...string) error {
+	if l == nil {
+		return errors.Errorf("notifications are disabled")
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	changed := false
+	for i, e := range l.Entries[id] {
+		if e.Read || (len(ids) > 0 && !stringInSlice(ids)(e.ID)) {
+			continue
+		}
+		l.Entries[id][i].Read = true
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return l.save()
+}
+
+// save writes the notifications to disk, it needs to be called with the lock held
+func (l *notificationLog) save() error {
+	return saveJSON(l.path, l)
+}
+
+// notificationGroup are the similar notifications of an account, like the upvotes of the same comment
+type notificationGroup struct {
+	IDs     []string
+	Event   string
+	Title   string
+	Body    string
+	URL     string
+	At      time.Time
+	Read    bool
+	actors  []string
+	summary string
+}
+
+// groupable returns the key of the group of the notification, the replies and the votes are grouped
+// by the item they're about
+func (e notificationEntry) groupable() (string, bool) {
+	if (e.Event != eventReplies && e.Event != eventVotes) || len(e.Target) == 0 || len(e.Summary) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s:%t", e.Event, e.Target, e.Read), true
+}
+
+func (g *notificationGroup) add(e notificationEntry) {
+	g.IDs = append(g.IDs, e.ID)
+	if len(e.Actor) > 0 && !stringInSlice(g.actors)(e.Actor) {
+		g.actors = append(g.actors, e.Actor)
+	}
+	switch len(g.actors) {
+	case 0:
+	case 1:
+		if len(g.IDs) > 1 {
+			g.Title = fmt.Sprintf("%s %s %d times", g.actors[0], g.summary, len(g.IDs))
+		}
+	case 2:
+		g.Title = fmt.Sprintf("%s and %s %s", g.actors[0], g.actors[1], g.summary)
+	default:
+		g.Title = fmt.Sprintf("%d people %s", len(g.actors), g.summary)
+	}
+}
+
+// groupNotifications groups the similar notifications, keeping the order of the newest one in each group
+func groupNotifications(entries []notificationEntry) []*notificationGroup {
+	groups := make([]*notificationGroup, 0)
+	byKey := make(map[string]*notificationGroup)
+	for _, e := range entries {
+		key, ok := e.groupable()
+		if g, exists := byKey[key]; ok && exists {
+			g.add(e)
+			continue
+		}
+		g := &notificationGroup{Event: e.Event, Title: e.Title, Body: e.Body, URL: e.URL, At: e.At, Read: e.Read, summary: e.Summary}
+		g.add(e)
+		if ok {
+			byKey[key] = g
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+type notificationsModel struct {
+	Title  string
+	Groups []*notificationGroup
+	Unread int
+}
+
+func (m *notificationsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (notificationsModel) Template() string {
+	return "notifications"
+}
+
+// HandleNotifications serves the /notifications requests, with the notifications of the logged account
+func (h *handler) HandleNotifications(w http.ResponseWriter, r *http.Request) {
+	id := accountIRI(loggedAccount(r)).String()
+	m := &notificationsModel{
+		Title:  "Notifications",
+		Groups: groupNotifications(h.storage.notifications.List(id)),
+		Unread: h.storage.notifications.Unread(id),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleNotificationsRead handles the POST /notifications/read requests, which mark the notifications
+// in the "id" values as read, and POST /notifications/read-all which marks all of them
+func (h *handler) HandleNotificationsRead(all bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid form"))
+			return
+		}
+		acc := loggedAccount(r)
+		ids := r.PostForm["id"]
+		if !all && len(ids) == 0 {
+			h.v.HandleErrors(w, r, errors.BadRequestf("no notifications to mark as read"))
+			return
+		}
+		if all {
+			ids = nil
+		}
+		if err := h.storage.notifications.MarkRead(accountIRI(acc).String(), ids...); err != nil {
+			h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the read notifications")
+			h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to mark the notifications as read"))
+			return
+		}
+		h.v.Redirect(w, r, "/notifications", http.StatusSeeOther)
+	}
+}
+
+// HandleUnreadNotifications serves the /notifications/unread requests, with the count of the unread
+// notifications of the logged account, which the header badge polls
+func (h *handler) HandleUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	cnt := h.storage.notifications.Unread(accountIRI(loggedAccount(r)).String())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]int{"unread": cnt})
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNotificationLog(t *testing.T) {
+	l, err := loadNotificationLog("", 7)
+	if err != nil {
+		t.Fatalf("unable to load the notifications: %s", err)
+	}
+	id := "https://littr.git/actors/f00"
+	vote := func(who, target string) notification {
+		return notification{Title: who + " upvoted your comment", Actor: who, Summary: "upvoted your comment", Target: target}
+	}
+	for _, n := range []notification{vote("alice", "1"), vote("bob", "1"), vote("carol", "1"), vote("alice", "2")} {
+		l.Notify(context.Background(), id, eventVotes, n)
+	}
+	l.Notify(context.Background(), id, eventMentions, notification{Title: "dave mentioned you", Actor: "dave"})
+	l.Notify(context.Background(), id, eventVotes, vote("erin", "3"))
+	l.Notify(context.Background(), id, eventVotes, vote("frank", "3"))
+
+	groups := groupNotifications(l.List(id))
+	titles := make([]string, 0)
+	for _, g := range groups {
+		titles = append(titles, g.Title)
+	}
+	expected := []string{"frank and erin upvoted your comment", "dave mentioned you", "alice upvoted your comment", "3 people upvoted your comment"}
+	if len(titles) != len(expected) {
+		t.Fatalf("expected groups %v, got %v", expected, titles)
+	}
+	for i := range expected {
+		if titles[i] != expected[i] {
+			t.Errorf("expected group %q, got %q", expected[i], titles[i])
+		}
+	}
+
+	if err := l.MarkRead(id, groups[0].IDs...); err != nil {
+		t.Fatalf("unable to mark the notifications as read: %s", err)
+	}
+	if got := l.Unread(id); got != 5 {
+		t.Errorf("expected 5 unread notifications, got %d", got)
+	}
+	l.Notify(context.Background(), id, eventVotes, vote("grace", "4"))
+	if got := len(l.List(id)); got != 7 {
+		t.Errorf("expected the notifications to be capped at 7, got %d", got)
+	}
+	if err := l.MarkRead(id); err != nil || l.Unread(id) != 0 {
+		t.Errorf("expected all the notifications to be read, got %d unread", l.Unread(id))
+	}
+}
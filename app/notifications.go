@@ -0,0 +1,478 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	notificationChannelsFile = "notification-channels.json"
+	notificationSendTimeOut  = 30 * time.Second
+
+	eventReplies    = "replies"
+	eventMentions   = "mentions"
+	eventModeration = "moderation"
+	// eventVotes are only kept in the notifications page, they're too many to be sent through the channels
+	eventVotes = "votes"
+
+	channelNtfy   = "ntfy"
+	channelGotify = "gotify"
+	channelEmail  = "email"
+)
+
+// notificationEvents are the events an account wants to be notified about
+type notificationEvents struct {
+	Replies    bool `json:"replies"`
+	Mentions   bool `json:"mentions"`
+	Moderation bool `json:"moderation"`
+}
+
+var defaultNotificationEvents = notificationEvents{Replies: true, Mentions: true, Moderation: true}
+
+// Has returns if the event is enabled
+func (e notificationEvents) Has(event string) bool {
+	switch event {
+	case eventReplies:
+		return e.Replies
+	case eventMentions:
+		return e.Mentions
+	case eventModeration:
+		return e.Moderation
+	}
+	return false
+}
+
+func notificationEventsFromRequest(r *http.Request) notificationEvents {
+	return notificationEvents{
+		Replies:    len(r.PostFormValue(eventReplies)) > 0,
+		Mentions:   len(r.PostFormValue(eventMentions)) > 0,
+		Moderation: len(r.PostFormValue(eventModeration)) > 0,
+	}
+}
+
+// notification is what we tell an account about an event, the channels format it the way they need.
+// The Actor, Summary and Target are used for grouping the similar events in the notifications page.
+type notification struct {
+	Title   string `json:"title"`
+	Body    string `json:"body,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Actor   string `json:"-"`
+	Summary string `json:"-"`
+	Target  string `json:"-"`
+	// batch are the notifications held during the quiet hours of the account
+	batch []heldNotification
+}
+
+// notifier delivers the notifications of the local accounts through one of the channels they configured
+type notifier interface {
+	Notify(ctx context.Context, id, event string, n notification) error
+}
+
+// notify adds the notification to the notifications page of the account with id, and sends it through
+// all its channels, unless the account is in its quiet hours, when it's held until they end
+func (r *repository) notify(ctx context.Context, id, event string, n notification) {
+	if err := r.notifications.Notify(ctx, id, event, n); err != nil {
+		r.errFn(log.Ctx{"err": err, "account": id, "event": event})("unable to save the notification")
+	}
+	if event != eventVotes && r.accountSettingsOf(ctx, id).QuietHours.Active(time.Now()) {
+		err := r.quiet.Hold(id, event, n)
+		if err == nil {
+			return
+		}
+		r.errFn(log.Ctx{"err": err, "account": id, "event": event})("unable to hold the notification, sending it")
+	}
+	r.deliver(ctx, id, event, n)
+}
+
+// deliver sends the notification to the account with id, through the Web Push, ntfy, Gotify and email channels
+func (r *repository) deliver(ctx context.Context, id, event string, n notification) {
+	for _, nn := range []notifier{r.push, r.channels} {
+		if err := nn.Notify(ctx, id, event, n); err != nil {
+			r.errFn(log.Ctx{"err": err, "account": id, "event": event})("unable to send the notification")
+		}
+	}
+}
+
+// notifyNewItem notifies the author of the parent of a new comment, and the accounts mentioned in it
+func (r *repository) notifyNewItem(it Item) {
+	if it.Private() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeOut)
+	defer cancel()
+
+	author := accountIRI(it.SubmittedBy)
+	who := "Somebody"
+	if it.SubmittedBy != nil && len(it.SubmittedBy.Handle) > 0 {
+		who = it.SubmittedBy.Handle
+	}
+	n := notification{Body: excerpt(&it), URL: Instance.BaseURL + ItemLocalLink(&it), Tag: it.Hash.String(), Actor: who}
+	notified := make(map[pub.IRI]bool)
+	if p := it.Parent; p != nil {
+		if p.SubmittedBy == nil || !p.SubmittedBy.HasMetadata() {
+			if loaded, err := r.LoadItem(ctx, itemIRI(p)); err == nil {
+				p = &loaded
+			}
+		}
+		if to := accountIRI(p.SubmittedBy); len(to) > 0 && to != author {
+			notified[to] = true
+			n.Title = fmt.Sprintf("%s replied to you", who)
+			r.notify(ctx, to.String(), eventReplies, n)
+		}
+	}
+	if !it.HasMetadata() {
+		return
+	}
+	n.Title = fmt.Sprintf("%s mentioned you", who)
+	for _, m := range it.Metadata.Mentions {
+		if m.Metadata == nil || len(m.Metadata.ID) == 0 {
+			continue
+		}
+		to := pub.IRI(m.Metadata.ID)
+		if to == author || notified[to] {
+			continue
+		}
+		notified[to] = true
+		r.notify(ctx, to.String(), eventMentions, n)
+	}
+}
+
+// notifyModeration notifies the account with id about a moderation decision
+func (r *repository) notifyModeration(id string, title, body, link string) {
+	if len(id) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeOut)
+	defer cancel()
+	r.notify(ctx, id, eventModeration, notification{Title: title, Body: body, URL: Instance.BaseURL + link, Tag: eventModeration})
+}
+
+// notifyVote notifies the author of the item about the upvote of the voter
+func (r *repository) notifyVote(it Item, voter Account) {
+	to := accountIRI(it.SubmittedBy)
+	if len(to) == 0 || to == accountIRI(&voter) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeOut)
+	defer cancel()
+	n := notification{
+		Title:   fmt.Sprintf("%s upvoted your %s", voter.Handle, itemKind(&it)),
+		Body:    excerpt(&it),
+		URL:     Instance.BaseURL + ItemLocalLink(&it),
+		Tag:     it.Hash.String(),
+		Actor:   voter.Handle,
+		Summary: "upvoted your " + itemKind(&it),
+		Target:  it.Hash.String(),
+	}
+	r.notify(ctx, to.String(), eventVotes, n)
+}
+
+// itemKind is how we name the item in the notifications
+func itemKind(it *Item) string {
+	if it.Parent != nil {
+		return "comment"
+	}
+	return "submission"
+}
+
+// excerpt returns the beginning of the title, or of the content, of the item
+func excerpt(it *Item) string {
+	s := it.Title
+	if len(s) == 0 {
+		s = it.Data
+	}
+	if r := []rune(s); len(r) > 200 {
+		s = string(r[:200]) + "…"
+	}
+	return s
+}
+
+// ntfyChannel publishes the notifications to a ntfy topic, the Token is needed for the protected ones
+type ntfyChannel struct {
+	Topic  string             `json:"topic"`
+	Token  string             `json:"token,omitempty"`
+	Events notificationEvents `json:"events"`
+}
+
+// gotifyChannel sends the notifications to a Gotify server, with the Token of an application created there
+type gotifyChannel struct {
+	Server string             `json:"server"`
+	Token  string             `json:"token"`
+	Events notificationEvents `json:"events"`
+}
+
+// notificationChannel are the channels an account configured, besides the Web Push subscriptions of its browsers.
+// The email channel uses the address confirmed for the digests.
+type notificationChannel struct {
+	Ntfy   *ntfyChannel        `json:"ntfy,omitempty"`
+	Gotify *gotifyChannel      `json:"gotify,omitempty"`
+	Email  *notificationEvents `json:"email,omitempty"`
+}
+
+func (c notificationChannel) empty() bool {
+	return c.Ntfy == nil && c.Gotify == nil && c.Email == nil
+}
+
+// notificationChannels keeps the notification channels of the local accounts
+type notificationChannels struct {
+	m       sync.RWMutex
+	path    string
+	enabled []string
+	mail    *mailer
+	// address returns the confirmed email address of an account
+	address func(id string) string
+	// client sends the ntfy and Gotify notifications, it can't reach the local network
+	client   *http.Client
+	Channels map[string]notificationChannel `json:"channels"`
+}
+
+func loadNotificationChannels(path string, enabled []string, m *mailer, address func(string) string) (*notificationChannels, error) {
+	c := &notificationChannels{path: path, enabled: enabled, mail: m, address: address, Channels: make(map[string]notificationChannel)}
+	return c, loadJSON(path, c)
+}
+
+// Enabled returns if the instance allows the channel kind
+func (c *notificationChannels) Enabled(kind string) bool {
+	if c == nil || !stringInSlice(c.enabled)(kind) {
+		return false
+	}
+	return kind != channelEmail || c.mail.Enabled()
+}
+
+// EmailAddress returns the address where the email notifications of the account with id go, if it has one
+func (c *notificationChannels) EmailAddress(id string) string {
+	if !c.Enabled(channelEmail) || c.address == nil {
+		return ""
+	}
+	return c.address(id)
+}
+
+// Get returns the channels of the account with id
+func (c *notificationChannels) Get(id string) notificationChannel {
+	if c == nil {
+		return notificationChannel{}
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.Channels[id]
+}
+
+// Update changes the channels of the account with id
+func (c *notificationChannels) Update(id string, fn func(*notificationChannel) error) error {
+	if c == nil {
+		return errors.Errorf("notification channels are disabled")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	ch := c.Channels[id]
+	if err := fn(&ch); err != nil {
+		return err
+	}
+	if ch.empty() {
+		delete(c.Channels, id)
+	} else {
+		c.Channels[id] = ch
+	}
+	return c.save()
+}
+
+// save writes the channels to disk, it needs to be called with the lock held
+func (c *notificationChannels) save() error {
+	return saveJSON(c.path, c)
+}
+
+// Notify sends the notification through the channels of the account with id that want to know about the event
+func (c *notificationChannels) Notify(ctx context.Context, id, event string, n notification) error {
+	ch := c.Get(id)
+	var err error
+	if ch.Ntfy != nil && c.Enabled(channelNtfy) {
+		if msg, ok := n.For(ch.Ntfy.Events, event); ok {
+			if e := ch.Ntfy.send(ctx, c.client, msg); e != nil {
+				err = errors.Annotatef(e, "ntfy")
+			}
+		}
+	}
+	if ch.Gotify != nil && c.Enabled(channelGotify) {
+		if msg, ok := n.For(ch.Gotify.Events, event); ok {
+			if e := ch.Gotify.send(ctx, c.client, msg); e != nil {
+				err = errors.Annotatef(e, "gotify")
+			}
+		}
+	}
+	if ch.Email != nil {
+		msg, ok := n.For(*ch.Email, event)
+		if to := c.EmailAddress(id); ok && len(to) > 0 {
+			body := fmt.Sprintf("%s\n\n%s\n", msg.Body, msg.URL)
+			if e := c.mail.Send(to, msg.Title, body, nil); e != nil {
+				err = errors.Annotatef(e, "email")
+			}
+		}
+	}
+	return err
+}
+
+func doNotificationRequest(c *http.Client, req *http.Request) error {
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.Errorf("%s returned %s", req.URL.Host, res.Status)
+	}
+	return nil
+}
+
+func (n ntfyChannel) send(ctx context.Context, c *http.Client, msg notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Topic, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", msg.Title))
+	if len(msg.URL) > 0 {
+		req.Header.Set("Click", msg.URL)
+	}
+	if len(n.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return doNotificationRequest(c, req)
+}
+
+func (g gotifyChannel) send(ctx context.Context, c *http.Client, msg notification) error {
+	body := msg.Body
+	if len(body) == 0 {
+		body = msg.Title
+	}
+	payload := map[string]interface{}{"title": msg.Title, "message": body, "priority": 5}
+	if len(msg.URL) > 0 {
+		payload["extras"] = map[string]interface{}{
+			"client::notification": map[string]interface{}{"click": map[string]string{"url": msg.URL}},
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(g.Server, "/")+"/message", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return doNotificationRequest(c, req)
+}
+
+// validChannelURL checks that the ntfy topic, or the Gotify server, are web addresses. The hosts given as IPs
+// need to be public ones, the names resolving to the local network are refused when sending the notifications.
+func validChannelURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 || u.User != nil {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip == nil || isPublicIP(ip)
+}
+
+// notificationEventsField is the data of the partials/user/notification-events template, the checkboxes
+// of the events of a channel
+type notificationEventsField struct {
+	Channel string
+	Events  notificationEvents
+}
+
+// NotificationEvents returns the events of the channel, for the settings page
+func (m settingsModel) NotificationEvents(channel string) notificationEventsField {
+	f := notificationEventsField{Channel: channel, Events: defaultNotificationEvents}
+	switch channel {
+	case "push":
+		f.Events = m.PushEvents
+	case channelNtfy:
+		if m.Channels.Ntfy != nil {
+			f.Events = m.Channels.Ntfy.Events
+		}
+	case channelGotify:
+		if m.Channels.Gotify != nil {
+			f.Events = m.Channels.Gotify.Events
+		}
+	case channelEmail:
+		if m.Channels.Email != nil {
+			f.Events = *m.Channels.Email
+		}
+	}
+	return f
+}
+
+// HandleNotificationChannel handles the POST /~handle/settings/channels/{kind} requests, which save,
+// or remove, the ntfy, Gotify or email notification channels of the account
+func (h *handler) HandleNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	id := accountIRI(acc).String()
+	kind := chi.URLParam(r, "kind")
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+	if !h.storage.channels.Enabled(kind) {
+		h.v.HandleErrors(w, r, errors.NotFoundf("%s notifications", kind))
+		return
+	}
+	remove := r.PostFormValue("action") == "remove"
+	events := notificationEventsFromRequest(r)
+	err := h.storage.channels.Update(id, func(ch *notificationChannel) error {
+		switch kind {
+		case channelNtfy:
+			ch.Ntfy = nil
+			if remove {
+				return nil
+			}
+			n := ntfyChannel{Topic: strings.TrimSpace(r.PostFormValue("topic")), Token: strings.TrimSpace(r.PostFormValue("token")), Events: events}
+			if !validChannelURL(n.Topic) {
+				return errors.BadRequestf("the ntfy topic needs to be a URL, like https://ntfy.sh/your-topic")
+			}
+			ch.Ntfy = &n
+		case channelGotify:
+			ch.Gotify = nil
+			if remove {
+				return nil
+			}
+			g := gotifyChannel{Server: strings.TrimSpace(r.PostFormValue("server")), Token: strings.TrimSpace(r.PostFormValue("token")), Events: events}
+			if !validChannelURL(g.Server) || len(g.Token) == 0 {
+				return errors.BadRequestf("the Gotify server needs to be a URL, and the token of an application is needed")
+			}
+			ch.Gotify = &g
+		case channelEmail:
+			ch.Email = nil
+			if remove {
+				return nil
+			}
+			if len(h.storage.channels.EmailAddress(id)) == 0 {
+				return errors.BadRequestf("you need to confirm an email address, from the digest settings, first")
+			}
+			ch.Email = &events
+		}
+		return nil
+	})
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle, "channel": kind})("unable to save the notification channel")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the notification channel"))
+		return
+	}
+	if remove {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("You won't receive %s notifications anymore.", kind))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Your %s notifications were saved.", kind))
+	}
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
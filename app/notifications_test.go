@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotificationChannelsNotify(t *testing.T) {
+	received := make(map[string]*http.Request)
+	bodies := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received[r.URL.Path] = r
+		bodies[r.URL.Path] = string(data)
+	}))
+	defer srv.Close()
+
+	c, err := loadNotificationChannels("", []string{channelNtfy, channelGotify}, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to load the notification channels: %s", err)
+	}
+	// NOTE(marius): the test server listens on the loopback address, which the remote client refuses to connect to
+	c.client = srv.Client()
+	id := "https://littr.git/actors/f00"
+	err = c.Update(id, func(ch *notificationChannel) error {
+		ch.Ntfy = &ntfyChannel{Topic: srv.URL + "/littr", Token: "tk_f00", Events: notificationEvents{Replies: true}}
+		ch.Gotify = &gotifyChannel{Server: srv.URL + "/", Token: "app", Events: notificationEvents{Mentions: true}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to save the notification channels: %s", err)
+	}
+
+	n := notification{Title: "test replied to you", Body: "I agree", URL: "https://littr.git/~test/f00"}
+	if err := c.Notify(context.Background(), id, eventReplies, n); err != nil {
+		t.Fatalf("unable to send the notification: %s", err)
+	}
+	r, ok := received["/littr"]
+	if !ok || bodies["/littr"] != "I agree" || r.Header.Get("Title") != n.Title || r.Header.Get("Click") != n.URL || r.Header.Get("Authorization") != "Bearer tk_f00" {
+		t.Errorf("unexpected ntfy request %v %q", r, bodies["/littr"])
+	}
+	if _, ok := received["/message"]; ok {
+		t.Errorf("the gotify channel didn't want the replies")
+	}
+
+	if err := c.Notify(context.Background(), id, eventMentions, n); err != nil {
+		t.Fatalf("unable to send the notification: %s", err)
+	}
+	r, ok = received["/message"]
+	if !ok || r.Header.Get("X-Gotify-Key") != "app" {
+		t.Fatalf("unexpected gotify request %v", r)
+	}
+	msg := struct {
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal([]byte(bodies["/message"]), &msg); err != nil || msg.Title != n.Title || msg.Message != n.Body {
+		t.Errorf("unexpected gotify message %q", bodies["/message"])
+	}
+
+	for s, valid := range map[string]bool{"https://ntfy.sh/littr": true, "ftp://ntfy.sh/littr": false, "https://user:pw@ntfy.sh/littr": false, "ntfy.sh/littr": false, "http://127.0.0.1:8080/littr": false, "http://[::1]/littr": false} {
+		if validChannelURL(s) != valid {
+			t.Errorf("expected %s to be valid %t", s, valid)
+		}
+	}
+}
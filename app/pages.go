@@ -0,0 +1,139 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+const (
+	pageAbout = "about"
+	pageRules = "rules"
+
+	pageExt = ".md"
+)
+
+var validPageSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Page is a static instance page, like the rules, the FAQ or the privacy policy
+// The content is markdown which is loaded from a file in the configured pages directory.
+type Page struct {
+	Slug      string
+	Title     string
+	Content   string
+	UpdatedAt time.Time
+}
+
+type pages struct {
+	path string
+}
+
+func (p pages) file(slug string) string {
+	return filepath.Join(p.path, slug+pageExt)
+}
+
+// Exists verifies if we have a page file for the slug
+func (p pages) Exists(slug string) bool {
+	if len(p.path) == 0 || !validPageSlug.MatchString(slug) {
+		return false
+	}
+	fi, err := os.Stat(p.file(slug))
+	return err == nil && !fi.IsDir()
+}
+
+// Load returns the page corresponding to slug
+func (p pages) Load(slug string) (Page, error) {
+	page := Page{Slug: slug}
+	if !validPageSlug.MatchString(slug) {
+		return page, errors.NotFoundf("invalid page %q", slug)
+	}
+	if len(p.path) == 0 {
+		return page, errors.NotFoundf("page %q not found", slug)
+	}
+	f := p.file(slug)
+	fi, err := os.Stat(f)
+	if err != nil || fi.IsDir() {
+		return page, errors.NotFoundf("page %q not found", slug)
+	}
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return page, errors.Annotatef(err, "unable to load page %q", slug)
+	}
+	page.UpdatedAt = fi.ModTime()
+	page.Title, page.Content = splitPageTitle(data)
+	if len(page.Title) == 0 {
+		page.Title = ToTitle(strings.Replace(slug, "-", " ", -1))
+	}
+	return page, nil
+}
+
+// List returns the pages available in the pages directory, sorted by slug
+func (p pages) List() []Page {
+	list := make([]Page, 0)
+	if len(p.path) == 0 {
+		return list
+	}
+	files, err := filepath.Glob(filepath.Join(p.path, "*"+pageExt))
+	if err != nil {
+		return list
+	}
+	for _, f := range files {
+		slug := strings.TrimSuffix(filepath.Base(f), pageExt)
+		if page, err := p.Load(slug); err == nil {
+			list = append(list, page)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Slug < list[j].Slug
+	})
+	return list
+}
+
+// splitPageTitle extracts the title from the first line of the document if it's a markdown level one header
+func splitPageTitle(data []byte) (string, string) {
+	data = bytes.Trim(data, "\x00")
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			idx := bytes.Index(data, []byte(line)) + len(line)
+			return strings.TrimSpace(line[2:]), strings.TrimSpace(string(data[idx:]))
+		}
+		break
+	}
+	return "", string(data)
+}
+
+// HandlePage serves /page/{slug} requests
+func (h *handler) HandlePage(w http.ResponseWriter, r *http.Request) {
+	h.showPage(w, r, chi.URLParam(r, "slug"))
+}
+
+// HandleAbout serves /about request
+// It's something Mastodon compatible servers should show
+func (h *handler) HandleAbout(w http.ResponseWriter, r *http.Request) {
+	h.showPage(w, r, pageAbout)
+}
+
+func (h *handler) showPage(w http.ResponseWriter, r *http.Request, slug string) {
+	page, err := h.storage.LoadPage(slug)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &pageModel{Title: page.Title, Page: page}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
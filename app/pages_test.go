@@ -0,0 +1,54 @@
+package app
+
+import "testing"
+
+func Test_splitPageTitle(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		title   string
+		content string
+	}{
+		{
+			name:    "empty",
+			data:    "",
+			title:   "",
+			content: "",
+		},
+		{
+			name:    "no title",
+			data:    "Be nice to each other.",
+			title:   "",
+			content: "Be nice to each other.",
+		},
+		{
+			name:    "title",
+			data:    "# Rules\n\nBe nice to each other.",
+			title:   "Rules",
+			content: "Be nice to each other.",
+		},
+		{
+			name:    "title after empty lines",
+			data:    "\n\n# Privacy policy\nWe don't track you.\n",
+			title:   "Privacy policy",
+			content: "We don't track you.",
+		},
+		{
+			name:    "second level header is not a title",
+			data:    "## FAQ\nNothing yet.",
+			title:   "",
+			content: "## FAQ\nNothing yet.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, content := splitPageTitle([]byte(tt.data))
+			if title != tt.title {
+				t.Errorf("splitPageTitle() title = %q, want %q", title, tt.title)
+			}
+			if content != tt.content {
+				t.Errorf("splitPageTitle() content = %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// titlePrefix returns the prefix of the title from the list, matched regardless of its case
+func titlePrefix(title string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if len(title) >= len(p) && strings.EqualFold(title[:len(p)], p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// normalizeTitlePrefix writes the prefix of the title the same way as in the list, so "ask: ..." and "ASK: ..."
+// get listed with the "Ask: ..." submissions
+func normalizeTitlePrefix(title string, prefixes ...[]string) string {
+	for _, list := range prefixes {
+		if p, ok := titlePrefix(title, list); ok {
+			return p + title[len(p):]
+		}
+	}
+	return title
+}
+
+// hasTitlePrefix returns a function checking if the top level items have one of the prefixes, for KeepItemsMw
+func hasTitlePrefix(prefixes []string) func(*Item) bool {
+	return func(i *Item) bool {
+		if i.Parent.IsValid() {
+			return false
+		}
+		_, ok := titlePrefix(i.Title, prefixes)
+		return ok
+	}
+}
+
+// PrefixFiltersMw loads the top level items whose titles start with one of the prefixes, for the /ask
+// and /show listings. The storage only matches them anywhere in the title, KeepItemsMw drops the rest.
+func PrefixFiltersMw(title string, prefixes []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f := FiltersFromRequest(r)
+			f.Type = CreateActivitiesFilter
+			f.Object = new(Filters)
+			f.Object.InReplTo = nilIRIs
+			f.Object.Type = ActivityTypesFilter(ValidContentTypes...)
+			for _, p := range prefixes {
+				f.Object.Name = append(f.Object.Name, LikeString(p))
+			}
+
+			m := ContextListingModel(r.Context())
+			m.Title = title
+			ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{f})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package app
+
+import "testing"
+
+func TestNormalizeTitlePrefix(t *testing.T) {
+	ask, show := []string{"Ask:", "Ask HN:"}, []string{"Show:"}
+	tests := map[string]string{
+		"ask: how do you deploy?":  "Ask: how do you deploy?",
+		"ASK HN: which editor?":    "Ask HN: which editor?",
+		"Show: my new static blog": "Show: my new static blog",
+		"Asking for a friend":      "Asking for a friend",
+		"A show: of hands":         "A show: of hands",
+		"ask":                      "ask",
+	}
+	for title, want := range tests {
+		if got := normalizeTitlePrefix(title, ask, show); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	isAsk := hasTitlePrefix(ask)
+	if !isAsk(&Item{Title: "Ask: how do you deploy?"}) {
+		t.Errorf("expected the submission to be listed in /ask")
+	}
+	if isAsk(&Item{Title: "Ask: how do you deploy?", Parent: &Item{Hash: HashFromString("2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60")}}) {
+		t.Errorf("the comments shouldn't be listed in /ask")
+	}
+}
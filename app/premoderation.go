@@ -0,0 +1,349 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/uuid"
+	"github.com/mariusor/go-littr/internal/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	heldItemsFile       = "held-items.json"
+	heldItemSaveTimeOut = 30 * time.Second
+)
+
+// heldItem is a submission or a comment of a new account, which waits for the approval of a moderator
+// before being saved, and federated. We keep the token of the author, to save it as them once approved,
+// it's saved encrypted, see tokenKeys.
+type heldItem struct {
+	Key         Hash                `json:"key"`
+	Title       string              `json:"title,omitempty"`
+	Data        string              `json:"data"`
+	MimeType    string              `json:"mime,omitempty"`
+	Board       string              `json:"board,omitempty"`
+	Event       *EventMetadata      `json:"event,omitempty"`
+	Classified  *ClassifiedMetadata `json:"classified,omitempty"`
+	Job         *JobMetadata        `json:"job,omitempty"`
+	Parent      string              `json:"parent,omitempty"`
+	ParentHash  Hash                `json:"parentHash,omitempty"`
+	OP          string              `json:"op,omitempty"`
+	Account     string              `json:"account"`
+	Handle      string              `json:"handle"`
+	Token       *oauth2.Token       `json:"-"`
+	SealedToken string              `json:"sealedToken,omitempty"`
+	At          time.Time           `json:"at"`
+}
+
+// IsComment returns if the held item is a reply
+func (h heldItem) IsComment() bool {
+	return len(h.Parent) > 0
+}
+
+// heldItems is the pre-moderation queue: the items of the new accounts, and how many items of each account
+// were approved, after the first ones they don't get held anymore
+type heldItems struct {
+	m        sync.RWMutex
+	path     string
+	first    int
+	maxAge   time.Duration
+	keys     tokenKeys
+	Items    map[Hash]heldItem `json:"items"`
+	Approved map[Hash]int      `json:"approved"`
+}
+
+func loadHeldItems(path string, first int, maxAge time.Duration, keys tokenKeys) (*heldItems, error) {
+	h := &heldItems{
+		path:     path,
+		first:    first,
+		maxAge:   maxAge,
+		keys:     keys,
+		Items:    make(map[Hash]heldItem),
+		Approved: make(map[Hash]int),
+	}
+	if err := loadJSON(path, h); err != nil {
+		return h, err
+	}
+	failed := 0
+	for k, hi := range h.Items {
+		tok, err := keys.open(hi.SealedToken)
+		if err != nil {
+			failed++
+			continue
+		}
+		hi.Token = tok
+		h.Items[k] = hi
+	}
+	if failed > 0 {
+		return h, errors.Newf("unable to decrypt the tokens of %d held items", failed)
+	}
+	return h, nil
+}
+
+// Enabled returns if the items of the new accounts get held for approval
+func (h *heldItems) Enabled() bool {
+	return h != nil && h.first > 0
+}
+
+// Holds returns if the items of acc need the approval of a moderator: it was created less than maxAge ago,
+// and it didn't have its first items approved yet
+func (h *heldItems) Holds(acc *Account) bool {
+	if !h.Enabled() || !acc.IsLogged() || acc.CreatedAt.IsZero() {
+		return false
+	}
+	if h.maxAge > 0 && time.Since(acc.CreatedAt) > h.maxAge {
+		return false
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return h.Approved[acc.Hash] < h.first
+}
+
+// Add puts the item of acc in the queue, the job postings can be held even with the pre-moderation disabled
+func (h *heldItems) Add(i Item, acc *Account) error {
+	if !h.Enabled() && !i.IsJob() {
+		return errors.Errorf("the pre-moderation is disabled")
+	}
+	if !acc.IsLogged() || acc.Metadata == nil {
+		return errors.Unauthorizedf("invalid account")
+	}
+	hi := heldItem{
+		Key:      Hash(uuid.New()),
+		Title:    i.Title,
+		Data:     i.Data,
+		MimeType: i.MimeType,
+		Account:  acc.Metadata.ID,
+		Handle:   acc.Handle,
+		Token:    acc.Metadata.OAuth.Token,
+		At:       time.Now().UTC(),
+	}
+	if i.Parent.IsValid() {
+		if !i.Parent.HasMetadata() || len(i.Parent.Metadata.ID) == 0 {
+			return errors.BadRequestf("invalid parent item")
+		}
+		hi.Parent, hi.ParentHash = i.Parent.Metadata.ID, i.Parent.Hash
+	}
+	if i.OP.HasMetadata() {
+		hi.OP = i.OP.Metadata.ID
+	}
+	if i.HasMetadata() {
+		hi.Board = i.Metadata.Board
+		hi.Event = i.Metadata.Event
+		hi.Classified = i.Metadata.Classified
+		hi.Job = i.Metadata.Job
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.Items[hi.Key] = hi
+	return h.save()
+}
+
+// List returns the items waiting for approval, the oldest first
+func (h *heldItems) List() []heldItem {
+	if h == nil {
+		return nil
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	list := make([]heldItem, 0, len(h.Items))
+	for _, hi := range h.Items {
+		list = append(list, hi)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].At.Before(list[j].At)
+	})
+	return list
+}
+
+// Count returns the number of items waiting for approval
+func (h *heldItems) Count() int {
+	if h == nil {
+		return 0
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return len(h.Items)
+}
+
+// Get returns the held item with the key
+func (h *heldItems) Get(key Hash) (heldItem, bool) {
+	h.m.RLock()
+	defer h.m.RUnlock()
+	hi, ok := h.Items[key]
+	return hi, ok
+}
+
+// Remove takes the item with the key out of the queue, when approved it counts towards the first items of its author
+func (h *heldItems) Remove(key Hash, approved bool) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	hi, ok := h.Items[key]
+	if !ok {
+		return errors.NotFoundf("held item %s", key)
+	}
+	delete(h.Items, key)
+	if approved {
+		h.Approved[HashFromIRI(pub.IRI(hi.Account))]++
+	}
+	return h.save()
+}
+
+// save writes the queue to disk, it needs to be called with the lock held
+func (h *heldItems) save() error {
+	for k, hi := range h.Items {
+		if hi.Token == nil {
+			// NOTE(marius): the tokens we couldn't decrypt are kept as they were
+			continue
+		}
+		var err error
+		if hi.SealedToken, err = h.keys.seal(hi.Token); err != nil {
+			return err
+		}
+		h.Items[k] = hi
+	}
+	return saveJSON(h.path, h)
+}
+
+// heldMessage is the confirmation for the authors whose item was held for approval
+func heldMessage(i Item) string {
+	if i.IsJob() {
+		return "Thank you, your job posting will be published after a moderator approves it."
+	}
+	kind := "submission"
+	if i.Parent.IsValid() {
+		kind = "comment"
+	}
+	return fmt.Sprintf("Thank you, your %s will be published after a moderator approves it, this happens only for the first ones of a new account.", kind)
+}
+
+// approveHeld saves the held item as its author
+func (r *repository) approveHeld(ctx context.Context, hi heldItem) error {
+	acc, _, err := r.tokenAccount(ctx, hi.Account, hi.Token)
+	if err != nil {
+		return err
+	}
+	it := Item{
+		Title:       hi.Title,
+		Data:        hi.Data,
+		MimeType:    hi.MimeType,
+		SubmittedBy: acc,
+		SubmittedAt: time.Now().UTC(),
+		Metadata:    new(ItemMetadata),
+	}
+	it.UpdatedAt = it.SubmittedAt
+	it.Metadata.Tags, it.Metadata.Mentions = loadTags(it.Data)
+	if !hi.IsComment() {
+		it.Metadata.Board = hi.Board
+		it.Metadata.Event = hi.Event
+		it.Metadata.Classified = hi.Classified
+		it.Metadata.Job = hi.Job
+	}
+	if hi.IsComment() {
+		parent, err := r.LoadItem(ctx, pub.IRI(hi.Parent))
+		if err != nil {
+			return errors.Annotatef(err, "unable to load the parent item")
+		}
+		it.Parent = &parent
+		if parent.SubmittedBy.IsValid() {
+			it.Metadata.To = append(it.Metadata.To, *parent.SubmittedBy)
+		}
+		it.OP = &parent
+		if len(hi.OP) > 0 && hi.OP != hi.Parent {
+			if op, err := r.LoadItem(ctx, pub.IRI(hi.OP)); err == nil {
+				it.OP = &op
+			}
+		}
+	}
+	saved, err := r.SaveItem(ctx, it)
+	if err != nil {
+		return err
+	}
+	r.events.Index(&saved)
+	if board := itemBoard(&it); len(board) > 0 {
+		if err := r.boards.Record(board, it.Parent != nil); err != nil {
+			r.errFn(log.Ctx{"err": err, "board": board})("unable to save the board activity")
+		}
+	}
+	return nil
+}
+
+type heldQueueModel struct {
+	Title   string
+	Items   []heldItem
+	Evasion []evasionFlag
+}
+
+func (m *heldQueueModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (heldQueueModel) Template() string {
+	return "queue"
+}
+
+// HandleModerationQueue serves the /moderation/queue requests, with the items of the new accounts which wait
+// for approval, and the new accounts flagged for possible ban evasion
+func (h *handler) HandleModerationQueue(w http.ResponseWriter, r *http.Request) {
+	m := &heldQueueModel{Title: "Moderation queue", Items: h.storage.held.List(), Evasion: h.storage.fingerprints.List()}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleModerateQueue handles the POST /moderation/queue requests, which approve or deny the selected items
+func (h *handler) HandleModerateQueue(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid form"))
+		return
+	}
+	mod := loggedAccount(r)
+	approve := r.PostFormValue("action") == "approve"
+	var done, failed int
+	for _, k := range r.PostForm["key"] {
+		key := HashFromString(k)
+		hi, ok := h.storage.held.Get(key)
+		if !ok {
+			continue
+		}
+		lCtx := log.Ctx{"key": key, "author": hi.Handle, "moderator": mod.Handle, "approved": approve}
+		if approve {
+			ctx, cancel := context.WithTimeout(r.Context(), heldItemSaveTimeOut)
+			err := h.storage.approveHeld(ctx, hi)
+			cancel()
+			if err != nil {
+				h.errFn(lCtx, log.Ctx{"err": err})("unable to save the held item")
+				failed++
+				continue
+			}
+		}
+		if err := h.storage.held.Remove(key, approve); err != nil {
+			h.errFn(lCtx, log.Ctx{"err": err})("unable to save the moderation queue")
+		}
+		what, verdict := "submission", "denied"
+		if hi.IsComment() {
+			what = "comment"
+		}
+		if approve {
+			verdict = "approved"
+		}
+		go h.storage.notifyModeration(hi.Account, fmt.Sprintf("Your %s was %s by the moderators", what, verdict), hi.Title, AccountLocalLink(&Account{Handle: hi.Handle}))
+		h.infoFn(lCtx)("held item moderated")
+		done++
+	}
+	action := "denied"
+	if approve {
+		action = "approved"
+	}
+	if done > 0 {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%d %s %s.", done, pluralize(float64(done), "item"), action))
+	}
+	if failed > 0 {
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to publish %d %s, they were left in the queue.", failed, pluralize(float64(failed), "item")))
+	}
+	h.v.Redirect(w, r, "/moderation/queue", http.StatusSeeOther)
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeldItems(t *testing.T) {
+	h, err := loadHeldItems("", 1, 24*time.Hour, testTokenKeys)
+	if err != nil {
+		t.Fatalf("unable to load the moderation queue: %s", err)
+	}
+	acc := &Account{
+		Handle:    "test",
+		Hash:      HashFromString("f00f00f00"),
+		CreatedAt: time.Now().Add(-time.Hour),
+		Metadata:  &AccountMetadata{ID: "https://littr.git/actors/f00f00f00"},
+	}
+	old := &Account{Handle: "old", Hash: HashFromString("b4db4db4d"), CreatedAt: time.Now().Add(-48 * time.Hour)}
+	if h.Holds(old) {
+		t.Errorf("the items of an old account are held")
+	}
+	if !h.Holds(acc) {
+		t.Fatalf("the items of a new account are not held")
+	}
+	parent := &Item{Hash: HashFromString("d5ad20a3"), Metadata: &ItemMetadata{ID: "https://littr.git/objects/d5ad20a3"}}
+	if err := h.Add(Item{Data: "first", Parent: parent}, acc); err != nil {
+		t.Fatalf("unable to hold the item: %s", err)
+	}
+	if err := h.Add(Item{Title: "second", Data: "second"}, acc); err != nil {
+		t.Fatalf("unable to hold the item: %s", err)
+	}
+
+	list := h.List()
+	if len(list) != 2 || list[0].Data != "first" || !list[0].IsComment() || list[0].ParentHash != parent.Hash {
+		t.Fatalf("unexpected held items %v", list)
+	}
+	if err := h.Remove(list[1].Key, false); err != nil {
+		t.Fatalf("unable to deny the item: %s", err)
+	}
+	if !h.Holds(acc) {
+		t.Errorf("a denied item counted towards the first items of the account")
+	}
+	if err := h.Remove(list[0].Key, true); err != nil {
+		t.Fatalf("unable to approve the item: %s", err)
+	}
+	if h.Holds(acc) || h.Count() != 0 {
+		t.Errorf("the items are still held after the first one was approved")
+	}
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// previewMaxSize is the size of the largest content we render for the live previews
+const previewMaxSize = 64 << 10
+
+// previewPolicy sanitizes the live previews, it keeps the markup the markdown renderer outputs,
+// with the classes of the tag and mention links
+var previewPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Globally()
+	return p
+}()
+
+type previewResponse struct {
+	HTML string `json:"html"`
+}
+
+// HandlePreviewAPI serves the POST /api/preview requests of the submission and comment forms, for their live preview.
+// The content goes through the same template as the published items, and we return it as sanitized HTML.
+func (h *handler) HandlePreviewAPI(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	it, err := ContentFromRequest(r, *acc)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid content")
+		return
+	}
+	if len(it.Data) > previewMaxSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "the content is too large for the preview")
+		return
+	}
+	if len(it.MimeType) == 0 {
+		it.MimeType = MimeTypeMarkdown
+	}
+
+	m := &previewModel{Title: "Preview", Content: &it}
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	opts := h.v.htmlOptions(w, r, m)
+	opts.Layout = ""
+	if err := h.v.renderHTML(buf, http.StatusOK, "partials/item/data", m.Content, opts); err != nil {
+		h.errFn(log.Ctx{"err": err})("unable to render the preview")
+		writeJSONError(w, http.StatusInternalServerError, "unable to render the preview")
+		return
+	}
+	dat, _ := json.Marshal(previewResponse{HTML: previewPolicy.Sanitize(buf.String())})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlePreviewAPI(t *testing.T) {
+	h := &handler{v: testView(t), errFn: defaultCtxLogFn}
+
+	form := url.Values{}
+	form.Set("data", "Some **bold** text <script>alert(1)</script>")
+	form.Set("mime-type", MimeTypeMarkdown)
+	r := httptest.NewRequest(http.MethodPost, "/api/preview", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	acc := Account{Handle: "test", Hash: HashFromString("f00f00f00"), CreatedAt: time.Now()}
+	r = r.WithContext(context.WithValue(r.Context(), LoggedAccountCtxtKey, &acc))
+	w := httptest.NewRecorder()
+	h.HandlePreviewAPI(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	res := previewResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response: %s", err)
+	}
+	if !strings.Contains(res.HTML, "<strong>bold</strong>") {
+		t.Errorf("expected the markdown to be rendered, got %q", res.HTML)
+	}
+	if strings.Contains(res.HTML, "<script") {
+		t.Errorf("expected the preview to be sanitized, got %q", res.HTML)
+	}
+}
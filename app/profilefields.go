@@ -0,0 +1,179 @@
+package app
+
+import (
+	stdhtml "html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	maxProfileFields          = 4
+	maxProfileFieldNameLength = 64
+	maxProfileFieldLength     = 255
+	maxBioLength              = 500
+	// maxItemPropertyValues is the number of PropertyValue attachments we load for the classifieds and the jobs
+	maxItemPropertyValues = 8
+
+	propertyValueType pub.ActivityVocabularyType = "PropertyValue"
+)
+
+// ProfileField is a key/value pair shown on the profile of an account, like its pronouns or its website.
+// They're stored in the attachments of the actor, the same way Mastodon does it.
+type ProfileField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// itemByType falls back to plain objects for the types go-ap doesn't know about, which are used by
+// other servers in the actors' attachments and tags
+func itemByType(typ pub.ActivityVocabularyType) (pub.Item, error) {
+	switch typ {
+	case propertyValueType, emojiType, hashtagType, accountSettingsType:
+		return &pub.Object{Type: typ}, nil
+	}
+	return pub.GetItemByType(typ)
+}
+
+// sanitizeProfileText removes the markup the remote servers put in the profile fields, we only show text
+func sanitizeProfileText(s string, max int) string {
+	s = stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(s))
+	s = sanitizeDisplayName(s)
+	if runes := []rune(s); len(runes) > max {
+		s = strings.TrimSpace(string(runes[:max]))
+	}
+	return s
+}
+
+var (
+	bioLineBreaks      = regexp.MustCompile(`(?i)<br\s*/?>`)
+	bioParagraphBreaks = regexp.MustCompile(`(?i)</p>`)
+)
+
+// sanitizeBio returns the text of the summary of an actor, keeping the line breaks of its paragraphs
+func sanitizeBio(s string) string {
+	s = bioLineBreaks.ReplaceAllString(s, "\n")
+	s = bioParagraphBreaks.ReplaceAllString(s, "\n\n")
+	s = stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(s))
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = collapseSpaces(l)
+	}
+	s = strings.TrimSpace(strings.Join(lines, "\n"))
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	if runes := []rune(s); len(runes) > maxBioLength {
+		s = strings.TrimSpace(string(runes[:maxBioLength]))
+	}
+	return s
+}
+
+// newBio cleans up the bio an account entered, it's plain text so the markup in it is kept as it is
+func newBio(text string) string {
+	return sanitizeBio(stdhtml.EscapeString(text))
+}
+
+// bioSummary returns the HTML we publish as the summary of the actor, each block of text is a paragraph
+func bioSummary(bio string) string {
+	paragraphs := make([]string, 0)
+	for _, p := range strings.Split(bio, "\n\n") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			paragraphs = append(paragraphs, "<p>"+strings.ReplaceAll(stdhtml.EscapeString(p), "\n", "<br/>")+"</p>")
+		}
+	}
+	return strings.Join(paragraphs, "")
+}
+
+func newProfileField(name, value string) (ProfileField, bool) {
+	f := ProfileField{
+		Name:  sanitizeProfileText(name, maxProfileFieldNameLength),
+		Value: sanitizeProfileText(value, maxProfileFieldLength),
+	}
+	return f, len(f.Name) > 0 && len(f.Value) > 0
+}
+
+// profileFieldsFromAttachment loads the profile fields from the PropertyValue attachments of an actor
+func profileFieldsFromAttachment(att pub.Item) []ProfileField {
+	return propertyValuesFromAttachment(att, maxProfileFields)
+}
+
+// propertyValuesFromAttachment loads at most max PropertyValue attachments of an object
+func propertyValuesFromAttachment(att pub.Item, max int) []ProfileField {
+	if att == nil {
+		return nil
+	}
+	fields := make([]ProfileField, 0)
+	for _, it := range attachmentItems(att) {
+		if it == nil || it.GetType() != propertyValueType || len(fields) >= max {
+			continue
+		}
+		pub.OnObject(it, func(o *pub.Object) error {
+			// NOTE(marius): Mastodon uses a "value" property which go-ap doesn't load, we put the value in the content
+			value := o.Content.First().Value.String()
+			if len(value) == 0 {
+				value = o.Summary.First().Value.String()
+			}
+			if f, ok := newProfileField(o.Name.First().Value.String(), value); ok {
+				fields = append(fields, f)
+			}
+			return nil
+		})
+	}
+	return fields
+}
+
+// attachmentItems returns the attachments of an object as a collection
+func attachmentItems(att pub.Item) pub.ItemCollection {
+	switch col := att.(type) {
+	case nil:
+		return nil
+	case pub.ItemCollection:
+		return col
+	case *pub.ItemCollection:
+		return *col
+	}
+	return pub.ItemCollection{att}
+}
+
+// replaceAttachments replaces the attachments of type typ with the new ones, keeping the others
+func replaceAttachments(att pub.Item, typ pub.ActivityVocabularyType, with pub.ItemCollection) pub.Item {
+	res := make(pub.ItemCollection, 0)
+	for _, it := range attachmentItems(att) {
+		if it != nil && it.GetType() != typ {
+			res = append(res, it)
+		}
+	}
+	res = append(res, with...)
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// profileFieldsAttachment returns the fields as PropertyValue objects for the attachments of an actor
+func profileFieldsAttachment(fields []ProfileField) pub.ItemCollection {
+	att := make(pub.ItemCollection, 0, len(fields))
+	for _, f := range fields {
+		// NOTE(marius): pub.ObjectNew replaces the types it doesn't know with Object
+		o := &pub.Object{Type: propertyValueType}
+		o.Name = pub.NaturalLanguageValuesNew()
+		o.Name.Set(pub.NilLangRef, pub.Content(f.Name))
+		o.Content = pub.NaturalLanguageValuesNew()
+		o.Content.Set(pub.NilLangRef, pub.Content(f.Value))
+		att = append(att, o)
+	}
+	return att
+}
+
+// profileFieldValue shows the value of a profile field, the URLs are turned into links
+func profileFieldValue(v string) template.HTML {
+	escaped := template.HTMLEscapeString(v)
+	if !strings.HasPrefix(v, "https://") && !strings.HasPrefix(v, "http://") || strings.ContainsAny(v, " \"'<>") {
+		return template.HTML(escaped)
+	}
+	return template.HTML(`<a href="` + escaped + `" rel="me nofollow noopener noreferrer" target="_blank">` + escaped + `</a>`)
+}
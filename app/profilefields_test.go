@@ -0,0 +1,72 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestSanitizeBio(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "paragraphs",
+			val:  "<p>Hello,<br/>I'm   Marius.</p><p>I write <a href=\"https://example.com\">code</a>.</p>",
+			want: "Hello,\nI'm Marius.\n\nI write code.",
+		},
+		{
+			name: "text direction override",
+			val:  "<p>evil\u202egnp.exe</p>",
+			want: "evilgnp.exe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeBio(tt.val); got != tt.want {
+				t.Errorf("sanitizeBio(%q) = %q, want %q", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBioSummary(t *testing.T) {
+	bio := newBio("I like <b>bold</b> text\r\nand\n\n\n\nparagraphs")
+	if want := "I like <b>bold</b> text\nand\n\nparagraphs"; bio != want {
+		t.Fatalf("newBio() = %q, want %q", bio, want)
+	}
+	summary := bioSummary(bio)
+	if want := "<p>I like &lt;b&gt;bold&lt;/b&gt; text<br/>and</p><p>paragraphs</p>"; summary != want {
+		t.Errorf("bioSummary() = %q, want %q", summary, want)
+	}
+	if got := sanitizeBio(summary); got != bio {
+		t.Errorf("expected the bio to be the same after publishing it, got %q", got)
+	}
+}
+
+func TestReplaceAttachments(t *testing.T) {
+	fields := []ProfileField{{Name: "Pronouns", Value: "they/them"}}
+	settings := pub.IRI("https://example.com/objects/2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60")
+
+	att := replaceAttachments(nil, propertyValueType, profileFieldsAttachment(fields))
+	att = replaceAttachments(att, accountSettingsType, accountSettingsAttachment(settings))
+	if got := profileFieldsFromAttachment(att); len(got) != 1 || got[0] != fields[0] {
+		t.Errorf("expected the profile fields to be kept with the settings, got %v", got)
+	}
+	if got := accountSettingsLink(att); got != settings {
+		t.Errorf("accountSettingsLink() = %s, want %s", got, settings)
+	}
+
+	att = replaceAttachments(att, accountSettingsType, accountSettingsAttachment(""))
+	if got := accountSettingsLink(att); len(got) > 0 {
+		t.Errorf("expected no link to the settings after removing it, got %s", got)
+	}
+	if got := profileFieldsFromAttachment(att); len(got) != 1 {
+		t.Errorf("expected the profile fields to be kept when removing the settings, got %v", got)
+	}
+}
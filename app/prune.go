@@ -0,0 +1,86 @@
+package app
+
+import (
+	"time"
+
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// Prune removes the entries that weren't submitted or replied to since before
+func (l *localIndex) Prune(before time.Time) int {
+	if l == nil {
+		return 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	cnt := 0
+	for h, e := range l.entries {
+		if e.LastActivity().Before(before) {
+			delete(l.entries, h)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Prune removes the replies we loaded for threads before
+func (b *backfill) Prune(before time.Time) int {
+	if b == nil {
+		return 0
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	cnt := 0
+	for iri, t := range b.threads {
+		if !t.at.IsZero() && t.at.Before(before) {
+			delete(b.threads, iri)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Prune removes the instances we haven't seen since before
+// The blocked and limited ones are kept, so the admins can still see them in the directory.
+func (p *peers) Prune(before time.Time) int {
+	if p == nil {
+		return 0
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	cnt := 0
+	for h, pp := range p.hosts {
+		if pp.LastSeen.Before(before) && !pp.Blocked && !pp.Limited {
+			delete(p.hosts, h)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Prune removes from the in memory caches the content older than the retention interval
+// The objects themselves are stored by fedbox, which has its own retention settings.
+func (r *repository) Prune(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-retention)
+	r.infoFn(log.Ctx{
+		"before":   before,
+		"index":    r.index.Prune(before),
+		"backfill": r.fill.Prune(before),
+		"peers":    r.peers.Prune(before),
+	})("pruned cached content")
+}
+
+// runPruner prunes the cached content periodically
+func (r *repository) runPruner(interval, retention time.Duration) {
+	if interval <= 0 || retention <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		r.Prune(retention)
+	}
+}
@@ -0,0 +1,489 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+// qrBlocks describes the error correction blocks of a QR code version, at the medium (M) correction level
+type qrBlocks struct {
+	ecPerBlock int
+	// short is the number of blocks with data codewords, the rest have one more
+	short int
+	long  int
+	data  int
+}
+
+// qrVersions are the QR code versions 1 to 10, which are enough for up to 213 bytes
+var qrVersions = []qrBlocks{
+	{10, 1, 0, 16},
+	{16, 1, 0, 28},
+	{26, 1, 0, 44},
+	{18, 2, 0, 32},
+	{24, 2, 0, 43},
+	{16, 4, 0, 27},
+	{18, 4, 0, 31},
+	{22, 2, 2, 38},
+	{22, 3, 2, 36},
+	{26, 4, 1, 43},
+}
+
+// qrAlignment are the positions of the alignment patterns for each version
+var qrAlignment = [][]int{
+	{},
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	return b.short*b.data + b.long*(b.data+1)
+}
+
+// qrCode is a QR code matrix, encoded in byte mode
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func gfMul(x, y int) int {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= ((y >> i) & 1) * x
+	}
+	return z
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree, without its leading term
+func rsDivisor(degree int) []int {
+	result := make([]int, degree)
+	result[degree-1] = 1
+	root := 1
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data []byte, divisor []int) []byte {
+	result := make([]int, len(divisor))
+	for _, b := range data {
+		factor := int(b) ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	ec := make([]byte, len(result))
+	for i, v := range result {
+		ec[i] = byte(v)
+	}
+	return ec
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(val, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (val>>i)&1 == 1)
+	}
+}
+
+// qrEncode returns the QR code of data, using the smallest version it fits in
+func qrEncode(data []byte) (*qrCode, error) {
+	version := 0
+	var blocks qrBlocks
+	for i, b := range qrVersions {
+		ccBits := 8
+		if i+1 >= 10 {
+			ccBits = 16
+		}
+		if 4+ccBits+8*len(data) <= b.dataCodewords()*8 {
+			version, blocks = i+1, b
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.Errorf("the data is too long for a QR code")
+	}
+
+	bits := make(bitBuffer, 0)
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := blocks.dataCodewords() * 8
+	term := capacity - len(bits)
+	if term > 4 {
+		term = 4
+	}
+	bits.append(0, term)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+
+	// NOTE(marius): the data is split in blocks, each gets its own error correction, then they're interleaved
+	divisor := rsDivisor(blocks.ecPerBlock)
+	dataBlocks := make([][]byte, 0, blocks.short+blocks.long)
+	ecBlocks := make([][]byte, 0, blocks.short+blocks.long)
+	k := 0
+	for i := 0; i < blocks.short+blocks.long; i++ {
+		l := blocks.data
+		if i >= blocks.short {
+			l++
+		}
+		dataBlocks = append(dataBlocks, codewords[k:k+l])
+		ecBlocks = append(ecBlocks, rsRemainder(codewords[k:k+l], divisor))
+		k += l
+	}
+	result := make([]byte, 0, len(codewords)+len(ecBlocks)*blocks.ecPerBlock)
+	for i := 0; i <= blocks.data; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				result = append(result, b[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			result = append(result, b[i])
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(result)
+	best, minPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); minPenalty < 0 || p < minPenalty {
+			best, minPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q, nil
+}
+
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+	pos := qrAlignment[version-1]
+	for i := range pos {
+		for j := range pos {
+			last := len(pos) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(pos[i]+dx, pos[j]+dy, maxAbs(dx, dy) != 1)
+				}
+			}
+		}
+	}
+	// NOTE(marius): reserve the format areas, they're drawn after choosing the mask
+	q.drawFormatBits(0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bit)
+			q.set(b, a, bit)
+		}
+	}
+	return q
+}
+
+func maxAbs(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// set draws a function module, x is the column and y the row
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.size || yy < 0 || yy >= q.size {
+				continue
+			}
+			d := maxAbs(dx, dy)
+			q.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawFormatBits draws the correction level, which is always M, and the mask
+func (q *qrCode) drawFormatBits(mask int) {
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawCodewords places the data in the zig-zag pattern, from the bottom right corner
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>(7-uint(i&7)))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read with the current mask, lower is better
+func (q *qrCode) penalty() int {
+	result := 0
+	finderLike := func(line []bool, i int) bool {
+		pattern := []bool{true, false, true, true, true, false, true}
+		for k, v := range pattern {
+			if line[i+k] != v {
+				return false
+			}
+		}
+		light := func(from, to int) bool {
+			for k := from; k < to; k++ {
+				if k >= 0 && k < len(line) && line[k] {
+					return false
+				}
+			}
+			return true
+		}
+		return light(i-4, i) || light(i+7, i+11)
+	}
+	lines := make([][]bool, 0, 2*q.size)
+	for y := 0; y < q.size; y++ {
+		lines = append(lines, q.modules[y])
+	}
+	for x := 0; x < q.size; x++ {
+		col := make([]bool, q.size)
+		for y := 0; y < q.size; y++ {
+			col[y] = q.modules[y][x]
+		}
+		lines = append(lines, col)
+	}
+	for _, line := range lines {
+		run := 1
+		for i := 1; i <= len(line); i++ {
+			if i < len(line) && line[i] == line[i-1] {
+				run++
+				continue
+			}
+			if run >= 5 {
+				result += 3 + run - 5
+			}
+			run = 1
+		}
+		for i := 0; i+7 <= len(line); i++ {
+			if finderLike(line, i) {
+				result += 40
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	diff := dark*20 - total*10
+	if diff < 0 {
+		diff = -diff
+	}
+	result += (diff+total-1)/total*10 - 10
+	return result
+}
+
+// SVG returns the code as an SVG image, with the quiet zone around it
+func (q *qrCode) SVG() string {
+	const border = 4
+	b := strings.Builder{}
+	dim := q.size + 2*border
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		dim, dim, dim*8, dim*8)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				fmt.Fprintf(&b, "M%d,%dh1v1h-1z", x+border, y+border)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// serveQRCode writes the QR code of the absolute URL of link
+func (h *handler) serveQRCode(w http.ResponseWriter, r *http.Request, link string) {
+	if strings.HasPrefix(link, "/") {
+		link = h.storage.SelfURL + link
+	}
+	q, err := qrEncode([]byte(link))
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to generate the QR code"))
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(q.SVG()))
+}
+
+// HandleItemQR serves the /{hash}/qr requests, with the QR code of the item's permalink
+func (h *handler) HandleItemQR(w http.ResponseWriter, r *http.Request) {
+	c := ContextCursor(r.Context())
+	if c == nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	it := getItemFromList(HashFromString(chi.URLParam(r, "hash")), c.items)
+	if it == nil || it.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	h.serveQRCode(w, r, ItemSlugLink(it))
+}
+
+// HandleAccountQR serves the /~{handle}/qr requests, with the QR code of the account's profile
+func (h *handler) HandleAccountQR(w http.ResponseWriter, r *http.Request) {
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	h.serveQRCode(w, r, AccountPermaLink(&authors[0]))
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	heldNotificationsFile   = "held-notifications.json"
+	quietHoursCheckInterval = time.Minute
+	// maxHeldNotifications is how many notifications we hold for an account, the older ones are dropped
+	maxHeldNotifications = 100
+	// eventBatch is the event of the notifications held during the quiet hours, which are sent together
+	eventBatch = "batch"
+)
+
+// QuietHours is the part of the day, in the time zone of the account, when its push and email notifications
+// are held, to be sent as a single one after it ends
+type QuietHours struct {
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+func (q QuietHours) location() *time.Location {
+	if loc, err := time.LoadLocation(q.TimeZone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Active returns if t is in the quiet hours, they can go past midnight, like from 22 to 7
+func (q *QuietHours) Active(t time.Time) bool {
+	if q == nil || q.From == q.To {
+		return false
+	}
+	h := t.In(q.location()).Hour()
+	if q.From < q.To {
+		return h >= q.From && h < q.To
+	}
+	return h >= q.From || h < q.To
+}
+
+// heldNotification is a notification received during the quiet hours of an account
+type heldNotification struct {
+	Event        string       `json:"event"`
+	Notification notification `json:"notification"`
+	At           time.Time    `json:"at"`
+}
+
+// For returns the notification the way a channel which wants the events needs it, and if it wants it at all.
+// From a batch only the held notifications the channel wants are kept.
+func (n notification) For(events notificationEvents, event string) (notification, bool) {
+	if event != eventBatch {
+		return n, events.Has(event)
+	}
+	wanted := make([]heldNotification, 0, len(n.batch))
+	for _, h := range n.batch {
+		if events.Has(h.Event) {
+			wanted = append(wanted, h)
+		}
+	}
+	if len(wanted) == 0 {
+		return n, false
+	}
+	return batchNotification(wanted), true
+}
+
+// batchNotification puts the held notifications together, a single one is sent as it was
+func batchNotification(held []heldNotification) notification {
+	if len(held) == 1 {
+		return held[0].Notification
+	}
+	lines := make([]string, 0, len(held))
+	for _, h := range held {
+		lines = append(lines, "- "+h.Notification.Title)
+	}
+	return notification{
+		Title: fmt.Sprintf("%d notifications during your quiet hours", len(held)),
+		Body:  strings.Join(lines, "\n"),
+		URL:   Instance.BaseURL + "/notifications",
+		Tag:   eventBatch,
+	}
+}
+
+// heldNotifications keeps the notifications of the accounts in their quiet hours
+type heldNotifications struct {
+	m    sync.RWMutex
+	path string
+	Held map[string][]heldNotification `json:"held"`
+}
+
+func loadHeldNotifications(path string) (*heldNotifications, error) {
+	h := &heldNotifications{path: path, Held: make(map[string][]heldNotification)}
+	return h, loadJSON(path, h)
+}
+
+// Hold keeps the notification of the account with id until its quiet hours end
+func (h *heldNotifications) Hold(id, event string, n notification) error {
+	if h == nil {
+		return errors.Errorf("unable to hold notifications")
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	held := append(h.Held[id], heldNotification{Event: event, Notification: n, At: time.Now().UTC()})
+	if len(held) > maxHeldNotifications {
+		held = held[len(held)-maxHeldNotifications:]
+	}
+	h.Held[id] = held
+	return h.save()
+}
+
+// Accounts returns the ids of the accounts which have held notifications
+func (h *heldNotifications) Accounts() []string {
+	if h == nil {
+		return nil
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	ids := make([]string, 0, len(h.Held))
+	for id := range h.Held {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Take removes the held notifications of the account with id, and returns them
+func (h *heldNotifications) Take(id string) ([]heldNotification, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	held, ok := h.Held[id]
+	if !ok {
+		return nil, nil
+	}
+	delete(h.Held, id)
+	return held, h.save()
+}
+
+// save writes the held notifications to disk, it needs to be called with the lock held
+func (h *heldNotifications) save() error {
+	return saveJSON(h.path, h)
+}
+
+// runQuietHours sends the notifications held for the accounts whose quiet hours ended
+func (r *repository) runQuietHours() {
+	t := time.NewTicker(quietHoursCheckInterval)
+	defer t.Stop()
+	for range t.C {
+		now := time.Now()
+		for _, id := range r.quiet.Accounts() {
+			ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeOut)
+			if r.accountSettingsOf(ctx, id).QuietHours.Active(now) {
+				cancel()
+				continue
+			}
+			held, err := r.quiet.Take(id)
+			if err != nil {
+				r.errFn(log.Ctx{"err": err, "account": id})("unable to save the held notifications")
+			}
+			if len(held) > 0 {
+				r.deliver(ctx, id, eventBatch, notification{batch: held})
+			}
+			cancel()
+		}
+	}
+}
+
+// HandleQuietHours handles POST /~handle/settings/quiet requests, which save, or remove, the quiet hours
+func (h *handler) HandleQuietHours(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	s := acc.Settings()
+	s.QuietHours = nil
+	if r.PostFormValue("action") != "remove" {
+		from, err1 := strconv.Atoi(r.PostFormValue("from"))
+		to, err2 := strconv.Atoi(r.PostFormValue("to"))
+		if err1 != nil || err2 != nil || from < 0 || from > 23 || to < 0 || to > 23 || from == to {
+			h.v.HandleErrors(w, r, errors.BadRequestf("the quiet hours need to start and end at different hours, between 0 and 23"))
+			return
+		}
+		tz := strings.TrimSpace(r.PostFormValue("timezone"))
+		if _, err := time.LoadLocation(tz); err != nil {
+			h.v.HandleErrors(w, r, errors.BadRequestf("unknown time zone %q", tz))
+			return
+		}
+		s.QuietHours = &QuietHours{From: from, To: to, TimeZone: tz}
+	}
+	if err := h.saveSettings(r, acc, s); err != nil {
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the quiet hours"))
+		return
+	}
+	if s.QuietHours == nil {
+		h.v.addFlashMessage(Success, w, r, "Your notifications are sent right away.")
+	} else {
+		h.v.addFlashMessage(Success, w, r, "Your quiet hours were saved.")
+	}
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursActive(t *testing.T) {
+	tests := map[string]struct {
+		q    *QuietHours
+		at   time.Time
+		want bool
+	}{
+		"none":                 {at: time.Date(2020, 5, 6, 23, 0, 0, 0, time.UTC)},
+		"same day":             {q: &QuietHours{From: 13, To: 15}, at: time.Date(2020, 5, 6, 14, 30, 0, 0, time.UTC), want: true},
+		"same day, after":      {q: &QuietHours{From: 13, To: 15}, at: time.Date(2020, 5, 6, 15, 0, 0, 0, time.UTC)},
+		"past midnight":        {q: &QuietHours{From: 22, To: 7}, at: time.Date(2020, 5, 6, 3, 0, 0, 0, time.UTC), want: true},
+		"past midnight, day":   {q: &QuietHours{From: 22, To: 7}, at: time.Date(2020, 5, 6, 12, 0, 0, 0, time.UTC)},
+		"in the time zone":     {q: &QuietHours{From: 22, To: 7, TimeZone: "Asia/Tokyo"}, at: time.Date(2020, 5, 6, 14, 0, 0, 0, time.UTC), want: true},
+		"not in the time zone": {q: &QuietHours{From: 22, To: 7, TimeZone: "Asia/Tokyo"}, at: time.Date(2020, 5, 6, 3, 0, 0, 0, time.UTC)},
+	}
+	for name, tt := range tests {
+		if got := tt.q.Active(tt.at); got != tt.want {
+			t.Errorf("%s: expected active %t, got %t", name, tt.want, got)
+		}
+	}
+}
+
+func TestNotificationFor(t *testing.T) {
+	reply := heldNotification{Event: eventReplies, Notification: notification{Title: "test replied to you"}}
+	mention := heldNotification{Event: eventMentions, Notification: notification{Title: "test mentioned you"}}
+	batch := notification{batch: []heldNotification{reply, mention, reply}}
+
+	if _, ok := batch.For(notificationEvents{Moderation: true}, eventBatch); ok {
+		t.Errorf("expected the batch to be skipped without any wanted events")
+	}
+	n, ok := batch.For(notificationEvents{Mentions: true}, eventBatch)
+	if !ok || n.Title != mention.Notification.Title {
+		t.Errorf("expected the single mention to be sent as it was, got %v", n)
+	}
+	n, ok = batch.For(defaultNotificationEvents, eventBatch)
+	if !ok || n.Title != "3 notifications during your quiet hours" || n.Body != "- test replied to you\n- test mentioned you\n- test replied to you" {
+		t.Errorf("unexpected batch %v", n)
+	}
+	if _, ok := reply.Notification.For(notificationEvents{Mentions: true}, eventReplies); ok {
+		t.Errorf("expected the reply to be skipped")
+	}
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// HandleRandom serves /random requests
+// It redirects to a random submission from the local index, optionally only from the ones having the "tag" URL parameter
+func (h *handler) HandleRandom(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	entries := h.storage.index.Select(func(e indexEntry) bool {
+		return e.IsTop() && (len(tag) == 0 || e.HasTag(tag))
+	}, nil)
+	if len(entries) == 0 {
+		if len(tag) > 0 {
+			h.v.HandleErrors(w, r, errors.NotFoundf("no items found tagged #%s", tag))
+			return
+		}
+		h.v.HandleErrors(w, r, errors.NotFoundf("no items found"))
+		return
+	}
+	e := entries[rand.Intn(len(entries))]
+	h.v.Redirect(w, r, e.LocalLink(), http.StatusSeeOther)
+}
@@ -0,0 +1,324 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	readerCacheSize    = 200
+	readerCacheTTL     = 6 * time.Hour
+	readerMaxSize      = 2 << 20
+	readerMaxBlocks    = 500
+	readerLoadTimeOut  = 10 * time.Second
+	readerMinParagraph = 25
+)
+
+// readerBlock is a paragraph of the extracted article text, Kind is one of: p, h, quote, pre, li
+type readerBlock struct {
+	Kind string
+	Text string
+}
+
+// readerArticle is the text extracted from the page an item links to
+type readerArticle struct {
+	URL    string
+	Title  string
+	Blocks []readerBlock
+	at     time.Time
+}
+
+// articleReader loads the pages the link items point to, and keeps the article text extracted from them
+type articleReader struct {
+	m       sync.RWMutex
+	enabled bool
+	optOut  []string
+	cache   map[string]readerArticle
+	// client loads the pages, it can't reach the local network
+	client *http.Client
+}
+
+func newArticleReader(enabled bool, optOut []string, client *http.Client) *articleReader {
+	return &articleReader{enabled: enabled, optOut: optOut, cache: make(map[string]readerArticle), client: client}
+}
+
+// Allowed returns if the reader mode can be shown for the page at u,
+// the sites which asked to not have their content extracted are excluded
+func (a *articleReader) Allowed(u string) bool {
+	if a == nil || !a.enabled {
+		return false
+	}
+	return !a.OptedOut(u)
+}
+
+// OptedOut returns if the page at u can't have its content extracted, because it's not a http(s) page,
+// or its site is in the opt out list
+func (a *articleReader) OptedOut(u string) bool {
+	uu, err := url.Parse(u)
+	if err != nil || (uu.Scheme != "http" && uu.Scheme != "https") || len(uu.Host) == 0 {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	host := strings.ToLower(uu.Hostname())
+	for _, d := range a.optOut {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load returns the article text of the page at u, from the cache or by loading the page
+func (a *articleReader) Load(ctx context.Context, u string) (readerArticle, error) {
+	if !a.Allowed(u) {
+		return readerArticle{}, errors.NotFoundf("article")
+	}
+	a.m.RLock()
+	cached, ok := a.cache[u]
+	a.m.RUnlock()
+	if ok && time.Since(cached.at) < readerCacheTTL {
+		if len(cached.Blocks) == 0 {
+			return cached, errors.NotFoundf("article")
+		}
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readerLoadTimeOut)
+	defer cancel()
+	// NOTE(marius): the pages we can't extract anything from are remembered too, so we don't load them on every view
+	art, _, err := fetchArticle(ctx, a.client, u)
+	if err != nil {
+		return readerArticle{}, err
+	}
+	art.at = time.Now()
+
+	a.m.Lock()
+	if len(a.cache) >= readerCacheSize {
+		a.cache = make(map[string]readerArticle)
+	}
+	a.cache[u] = art
+	a.m.Unlock()
+
+	if len(art.Blocks) == 0 {
+		return art, errors.NotFoundf("article")
+	}
+	return art, nil
+}
+
+// fetchArticle loads the page at u and extracts its article text, the text is empty if the page isn't a HTML one.
+// The response is returned for its status and headers, its body is already closed.
+func fetchArticle(ctx context.Context, c *http.Client, u string) (readerArticle, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return readerArticle{}, nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	res, err := c.Do(req)
+	if err != nil {
+		return readerArticle{}, nil, err
+	}
+	defer res.Body.Close()
+
+	art := readerArticle{URL: u}
+	if res.StatusCode == http.StatusOK && strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		if art, err = extractArticle(io.LimitReader(res.Body, readerMaxSize)); err != nil {
+			return readerArticle{}, res, err
+		}
+		art.URL = u
+	}
+	return art, res, nil
+}
+
+// readerSkipped are the elements which don't contain the article text
+var readerSkipped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Svg: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Form: true,
+	atom.Button: true, atom.Iframe: true, atom.Figure: true, atom.Select: true,
+}
+
+func readerBlockKind(a atom.Atom) string {
+	switch a {
+	case atom.P:
+		return "p"
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return "h"
+	case atom.Blockquote:
+		return "quote"
+	case atom.Pre:
+		return "pre"
+	case atom.Li:
+		return "li"
+	}
+	return ""
+}
+
+// nodeText returns the text of the node, with the whitespace collapsed unless keepSpace is set
+func nodeText(n *xhtml.Node, keepSpace bool) string {
+	b := strings.Builder{}
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if n.Type == xhtml.ElementNode && readerSkipped[n.DataAtom] {
+			return
+		}
+		if n.Type == xhtml.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.Type == xhtml.ElementNode && n.DataAtom == atom.Br {
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	if keepSpace {
+		return strings.Trim(b.String(), "\n")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// extractArticle finds the element of the page containing most of the paragraphs, and returns their text.
+// It's a simplified version of what the browsers' reader modes do, it's good enough for most articles.
+func extractArticle(r io.Reader) (readerArticle, error) {
+	doc, err := xhtml.Parse(r)
+	if err != nil {
+		return readerArticle{}, errors.Annotatef(err, "unable to parse the page")
+	}
+	art := readerArticle{}
+	scores := make(map[*xhtml.Node]int)
+
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if n.Type == xhtml.ElementNode {
+			if readerSkipped[n.DataAtom] {
+				return
+			}
+			switch n.DataAtom {
+			case atom.Title:
+				if len(art.Title) == 0 {
+					art.Title = nodeText(n, false)
+				}
+			case atom.Meta:
+				var prop, content string
+				for _, at := range n.Attr {
+					switch at.Key {
+					case "property":
+						prop = at.Val
+					case "content":
+						content = at.Val
+					}
+				}
+				if prop == "og:title" && len(content) > 0 {
+					art.Title = strings.Join(strings.Fields(content), " ")
+				}
+			case atom.P, atom.Pre:
+				if l := len(nodeText(n, false)); l >= readerMinParagraph && n.Parent != nil {
+					score := 1 + l/100
+					if score > 4 {
+						score = 4
+					}
+					scores[n.Parent] += 2 * score
+					if n.Parent.Parent != nil {
+						scores[n.Parent.Parent] += score
+					}
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var top *xhtml.Node
+	for n, score := range scores {
+		if top == nil || score > scores[top] {
+			top = n
+		}
+	}
+	if top == nil {
+		return art, nil
+	}
+
+	var collect func(*xhtml.Node)
+	collect = func(n *xhtml.Node) {
+		if len(art.Blocks) >= readerMaxBlocks {
+			return
+		}
+		if n.Type == xhtml.ElementNode {
+			if readerSkipped[n.DataAtom] {
+				return
+			}
+			if kind := readerBlockKind(n.DataAtom); len(kind) > 0 {
+				if txt := nodeText(n, kind == "pre"); len(txt) > 0 {
+					art.Blocks = append(art.Blocks, readerBlock{Kind: kind, Text: txt})
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(top)
+	return art, nil
+}
+
+type readerModel struct {
+	Title   string
+	Hash    Hash
+	Content *Item
+	Article readerArticle
+}
+
+func (m *readerModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (readerModel) Template() string {
+	return "reader"
+}
+
+func (m *readerModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleReader serves the /{hash}/reader requests, with the text extracted from the page the item links to
+func (h *handler) HandleReader(w http.ResponseWriter, r *http.Request) {
+	m := &readerModel{Hash: HashFromString(chi.URLParam(r, "hash"))}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || !m.Content.IsLink() || !h.storage.reader.Allowed(m.Content.Data) {
+		h.v.HandleErrors(w, r, errors.NotFoundf("article"))
+		return
+	}
+	art, err := h.storage.reader.Load(r.Context(), m.Content.Data)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			h.errFn(log.Ctx{"err": err, "url": m.Content.Data})("unable to load the article")
+		}
+		h.v.HandleErrors(w, r, errors.NotFoundf("Unable to extract the article text"))
+		return
+	}
+	m.Article = art
+	m.Title = fmt.Sprintf("Reader: %s", m.Content.Title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// MaxRelatedItems is the number of related submissions shown on an item page
+	MaxRelatedItems = 5
+
+	relatedCacheTTL  = 15 * time.Minute
+	relatedCacheSize = 1000
+)
+
+// titleStopWords are ignored when comparing titles
+var titleStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "you": true, "your": true, "are": true,
+	"this": true, "that": true, "from": true, "how": true, "what": true, "why": true, "not": true,
+	"but": true, "can": true, "was": true, "has": true, "have": true, "its": true, "into": true,
+	"about": true, "using": true, "show": true, "ask": true,
+}
+
+func titleWords(title string) map[string]bool {
+	words := make(map[string]bool)
+	fields := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range fields {
+		if len(w) < 3 || titleStopWords[w] {
+			continue
+		}
+		words[w] = true
+	}
+	return words
+}
+
+// titleSimilarity returns the Jaccard index of the significant words in the two titles
+func titleSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// relatedness returns how close the candidate is to the item, zero means they're not related
+func relatedness(item indexEntry, words map[string]bool, candidate indexEntry) float64 {
+	var score float64
+	for _, t := range candidate.Tags {
+		if item.HasTag(t) {
+			score += 3
+		}
+	}
+	if len(item.Domain) > 0 && item.Domain == candidate.Domain {
+		score += 2
+	}
+	score += 5 * titleSimilarity(words, titleWords(candidate.Title))
+	return score
+}
+
+type relatedCacheEntry struct {
+	entries []indexEntry
+	at      time.Time
+}
+
+type relatedCache struct {
+	m     sync.Mutex
+	items map[Hash]relatedCacheEntry
+}
+
+func (c *relatedCache) get(h Hash) ([]indexEntry, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	e, ok := c.items[h]
+	if !ok || time.Since(e.at) > relatedCacheTTL {
+		return nil, false
+	}
+	return e.entries, true
+}
+
+func (c *relatedCache) set(h Hash, entries []indexEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.items == nil || len(c.items) >= relatedCacheSize {
+		c.items = make(map[Hash]relatedCacheEntry)
+	}
+	c.items[h] = relatedCacheEntry{entries: entries, at: time.Now()}
+}
+
+// Related returns up to max submissions that share tags, domain or title words with the one corresponding to h
+func (l *localIndex) Related(h Hash, max int) []indexEntry {
+	if l == nil {
+		return nil
+	}
+	item, ok := l.Get(h)
+	if !ok {
+		return nil
+	}
+	if !item.IsTop() {
+		if item, ok = l.Get(item.OP); !ok {
+			return nil
+		}
+	}
+	if related, ok := l.related.get(item.Hash); ok {
+		return related
+	}
+
+	words := titleWords(item.Title)
+	scores := make(map[Hash]float64)
+	candidates := l.Select(func(e indexEntry) bool {
+		if !e.IsTop() || e.Hash == item.Hash {
+			return false
+		}
+		if s := relatedness(item, words, e); s > 1 {
+			scores[e.Hash] = s
+			return true
+		}
+		return false
+	}, nil)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		si, sj := scores[candidates[i].Hash], scores[candidates[j].Hash]
+		if si == sj {
+			return byIndexScore(candidates[i], candidates[j])
+		}
+		return si > sj
+	})
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	l.related.set(item.Hash, candidates)
+	return candidates
+}
+
+// RelatedItemsMw loads the submissions related to the current item from the local index
+func RelatedItemsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer next.ServeHTTP(w, r)
+
+		m := ContextContentModel(r.Context())
+		if m == nil || !m.Hash.IsValid() {
+			return
+		}
+		repo := ContextRepository(r.Context())
+		if repo == nil {
+			return
+		}
+		m.Related = repo.index.Related(m.Hash, MaxRelatedItems)
+	})
+}
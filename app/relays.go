@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// relayObjectTypes returns the object types we accept from the relays' Announce activities
+// Types that we can't show are ignored, and if nothing remains we accept all of the ones we can.
+func relayObjectTypes(types []string) pub.ActivityVocabularyTypes {
+	result := make(pub.ActivityVocabularyTypes, 0)
+	for _, t := range types {
+		for _, valid := range ValidContentTypes {
+			if strings.EqualFold(string(valid), strings.TrimSpace(t)) && !result.Contains(valid) {
+				result = append(result, valid)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return ValidContentTypes
+	}
+	return result
+}
+
+func relayIRIs(relays []string) pub.IRIs {
+	result := make(pub.IRIs, 0)
+	for _, rel := range relays {
+		if rel = strings.TrimSpace(rel); len(rel) > 0 {
+			result = append(result, pub.IRI(rel))
+		}
+	}
+	return result
+}
+
+// IsRelay returns if the actor is one of the relays we're subscribed to
+func (r *repository) IsRelay(actor pub.Item) bool {
+	return actor != nil && r.relays.Contains(actor.GetLink())
+}
+
+// relayFollowed checks the outbox of the application actor for a Follow of the relay
+func (r *repository) relayFollowed(ctx context.Context, relay pub.IRI) (bool, error) {
+	f := &Filters{
+		Type:   ActivityTypesFilter(pub.FollowType),
+		Object: &Filters{IRI: CompStrs{EqualsString(relay.String())}},
+	}
+	col, err := r.fedbox.Outbox(ctx, r.app.pub, Values(f))
+	if err != nil {
+		return false, err
+	}
+	return col.Count() > 0, nil
+}
+
+// FollowRelay subscribes the instance to the relay, LitePub style: the application actor follows the relay actor
+// After the relay accepts, the Announce activities it sends end up in the service's inbox.
+func (r *repository) FollowRelay(ctx context.Context, relay pub.IRI) error {
+	if r.app == nil || r.app.pub == nil {
+		return errors.Newf("invalid application actor")
+	}
+	if ok, err := r.relayFollowed(ctx, relay); err != nil || ok {
+		return err
+	}
+	follow := new(pub.Follow)
+	follow.Type = pub.FollowType
+	follow.To = pub.ItemCollection{relay}
+	follow.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	follow.Object = relay
+	follow.Actor = r.app.pub.GetLink()
+	if _, _, err := r.WithAccount(r.app).fedbox.ToOutbox(ctx, follow); err != nil {
+		return errors.Annotatef(err, "unable to follow relay %s", relay)
+	}
+	r.infoFn(log.Ctx{"relay": relay})("subscribed to relay")
+	return nil
+}
+
+// SubscribeRelays follows all the configured relays we're not already following
+func (r *repository) SubscribeRelays(ctx context.Context) {
+	for _, relay := range r.relays {
+		if err := r.FollowRelay(ctx, relay); err != nil {
+			r.errFn(log.Ctx{"relay": relay, "err": err})("unable to subscribe to relay")
+		}
+	}
+}
+
+// relayFilters returns the filters for loading the objects announced by the relays
+// to the service's inbox, or nil if we don't have any configured
+func (r *repository) relayFilters(f Filters) *Filters {
+	if len(r.relays) == 0 {
+		return nil
+	}
+	f.Type = ActivityTypesFilter(pub.AnnounceType)
+	f.Actor = &Filters{}
+	for _, relay := range r.relays {
+		f.Actor.IRI = append(f.Actor.IRI, EqualsString(relay.String()))
+	}
+	ob := Filters{}
+	if f.Object != nil {
+		ob = *f.Object
+	}
+	types := make(pub.ActivityVocabularyTypes, 0)
+	for _, t := range r.relayTypes {
+		if FederatedContentTypes.Contains(t) {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	ob.Type = ActivityTypesFilter(types...)
+	f.Object = &ob
+	return &f
+}
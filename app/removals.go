@@ -0,0 +1,406 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	removalsFile       = "removals.json"
+	removalReasonsFile = "removal-reasons.json"
+)
+
+// isModerator returns if the account is a local one, in the MODERATORS or the ADMINS lists from the configuration
+func isModerator(c *config.Configuration, acc *Account) bool {
+	if c == nil {
+		return false
+	}
+	return isAdmin(c, acc) || localHandleIn(c.Moderators, acc)
+}
+
+// isAdmin returns if the account is a local one, in the ADMINS list from the configuration
+func isAdmin(c *config.Configuration, acc *Account) bool {
+	return c != nil && localHandleIn(c.Admins, acc)
+}
+
+func localHandleIn(handles []string, acc *Account) bool {
+	if !acc.IsLogged() || !acc.IsLocal() {
+		return false
+	}
+	for _, m := range handles {
+		if strings.EqualFold(strings.TrimLeft(m, "~@"), acc.Handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// removalReason is a reason the moderators can pick when removing content.
+// The Message is sent to the author, and can contain the {author}, {title}, {link} and {reason} placeholders.
+type removalReason struct {
+	Name    string `json:"name"`
+	Label   string `json:"label"`
+	Message string `json:"message"`
+}
+
+var defaultRemovalReasons = []removalReason{
+	{
+		Name:    "spam",
+		Label:   "Spam",
+		Message: "Hi {author}, your submission \"{title}\" was removed because it looks like spam.",
+	},
+	{
+		Name:    "off-topic",
+		Label:   "Off topic",
+		Message: "Hi {author}, your submission \"{title}\" was removed because it's off topic for this instance.",
+	},
+	{
+		Name:  "abuse",
+		Label: "Harassment or abuse",
+		Message: "Hi {author}, your submission \"{title}\" was removed because it breaks the rules of this " +
+			"instance about harassment and abuse.",
+	},
+}
+
+// Text returns the message for the author of the item
+func (rr removalReason) Text(i Item) string {
+	author := ""
+	if i.SubmittedBy != nil {
+		author = i.SubmittedBy.Handle
+	}
+	title := i.Title
+	if len(title) == 0 && i.Parent != nil {
+		title = fmt.Sprintf("reply to %s", i.Parent.Title)
+	}
+	return strings.NewReplacer(
+		"{author}", author,
+		"{title}", title,
+		"{link}", Instance.BaseURL+ItemPermaLink(&i),
+		"{reason}", rr.Label,
+	).Replace(rr.Message)
+}
+
+var validReasonName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// removalReasons are the reasons configured by the admins at /admin/reasons
+type removalReasons struct {
+	m       sync.RWMutex
+	path    string
+	reasons map[string]removalReason
+}
+
+func loadRemovalReasons(path string) (*removalReasons, error) {
+	rr := &removalReasons{path: path, reasons: make(map[string]removalReason)}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		for _, r := range defaultRemovalReasons {
+			rr.reasons[r.Name] = r
+		}
+		return rr, nil
+	}
+	return rr, loadJSON(path, &rr.reasons)
+}
+
+// List returns the reasons, ordered by their label
+func (rr *removalReasons) List() []removalReason {
+	if rr == nil {
+		return nil
+	}
+	rr.m.RLock()
+	defer rr.m.RUnlock()
+	list := make([]removalReason, 0, len(rr.reasons))
+	for _, r := range rr.reasons {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Label < list[j].Label
+	})
+	return list
+}
+
+// Get returns the reason with the name
+func (rr *removalReasons) Get(name string) (removalReason, bool) {
+	if rr == nil {
+		return removalReason{}, false
+	}
+	rr.m.RLock()
+	defer rr.m.RUnlock()
+	r, ok := rr.reasons[name]
+	return r, ok
+}
+
+// Add saves a new reason, or changes an existing one
+func (rr *removalReasons) Add(r removalReason) error {
+	if rr == nil {
+		return errors.Errorf("removal reasons are not available")
+	}
+	if !validReasonName.MatchString(r.Name) {
+		return errors.BadRequestf("invalid name %q, it can contain lowercase letters, digits and dashes", r.Name)
+	}
+	if len(r.Label) == 0 || len(r.Message) == 0 {
+		return errors.BadRequestf("the reason needs a label and a message")
+	}
+	rr.m.Lock()
+	defer rr.m.Unlock()
+	rr.reasons[r.Name] = r
+	return rr.save()
+}
+
+// Remove deletes the reason with the name, the content already removed for it keeps its label
+func (rr *removalReasons) Remove(name string) error {
+	if rr == nil {
+		return nil
+	}
+	rr.m.Lock()
+	defer rr.m.Unlock()
+	delete(rr.reasons, name)
+	return rr.save()
+}
+
+// save writes the reasons to disk, it needs to be called with the lock held
+func (rr *removalReasons) save() error {
+	return saveJSON(rr.path, rr.reasons)
+}
+
+// removal is the record of an item removed by a moderator, shown instead of its content
+type removal struct {
+	Reason    string    `json:"reason"`
+	Label     string    `json:"label"`
+	Moderator string    `json:"moderator"`
+	At        time.Time `json:"at"`
+}
+
+// removals are the items removed by the moderators, by their hash
+type removals struct {
+	m     sync.RWMutex
+	path  string
+	items map[Hash]removal
+}
+
+func loadRemovals(path string) (*removals, error) {
+	rm := &removals{path: path, items: make(map[Hash]removal)}
+	return rm, loadJSON(path, &rm.items)
+}
+
+// Get returns the removal of the item with hash h, or nil if it wasn't removed
+func (rm *removals) Get(h Hash) *removal {
+	if rm == nil {
+		return nil
+	}
+	rm.m.RLock()
+	defer rm.m.RUnlock()
+	if r, ok := rm.items[h]; ok {
+		return &r
+	}
+	return nil
+}
+
+// Add records the removal of the item with hash h
+func (rm *removals) Add(h Hash, r removal) error {
+	if rm == nil {
+		return errors.Errorf("removals are not available")
+	}
+	rm.m.Lock()
+	defer rm.m.Unlock()
+	rm.items[h] = r
+	return rm.save()
+}
+
+// save writes the removals to disk, it needs to be called with the lock held
+func (rm *removals) save() error {
+	return saveJSON(rm.path, rm.items)
+}
+
+// RemoveItem removes the item on behalf of the moderator mod. The removal is logged in the moderation log
+// as a Block of the item by the moderator, with the reason as its content, and the author gets a private
+// message with the text of the reason.
+func (r *repository) RemoveItem(ctx context.Context, mod *Account, it Item, rr removalReason, note string) error {
+	if it.SubmittedBy == nil || !it.SubmittedBy.HasMetadata() {
+		return errors.NotFoundf("the author of the item")
+	}
+	if err := r.removals.Add(it.Hash, removal{Reason: rr.Name, Label: rr.Label, Moderator: mod.Handle, At: time.Now().UTC()}); err != nil {
+		return errors.Annotatef(err, "unable to save the removal")
+	}
+
+	text := rr.Text(it)
+	if note = strings.TrimSpace(note); len(note) > 0 {
+		text = fmt.Sprintf("%s\n\n%s", text, note)
+	}
+	lCtx := log.Ctx{"hash": it.Hash, "reason": rr.Name, "moderator": mod.Handle}
+	logged := Item{
+		Data:        fmt.Sprintf("Removed: %s\n\n%s", rr.Label, text),
+		MimeType:    MimeTypeText,
+		SubmittedBy: mod,
+		SubmittedAt: time.Now().UTC(),
+		Metadata:    new(ItemMetadata),
+	}
+	if err := r.BlockItem(ctx, *mod, it, &logged); err != nil {
+		r.errFn(lCtx, log.Ctx{"err": err})("unable to log the removal")
+	}
+	msg := Item{
+		Title:       fmt.Sprintf("Removed: %s", rr.Label),
+		Data:        text,
+		MimeType:    MimeTypeText,
+		SubmittedBy: mod,
+		SubmittedAt: time.Now().UTC(),
+		Metadata:    &ItemMetadata{To: AccountCollection{*it.SubmittedBy}},
+	}
+	msg.MakePrivate()
+	if _, err := r.SaveItem(ctx, msg); err != nil {
+		r.errFn(lCtx, log.Ctx{"err": err})("unable to notify the author of the removal")
+	}
+	go r.notifyModeration(it.SubmittedBy.Metadata.ID, fmt.Sprintf("Your item was removed: %s", rr.Label), it.Title, ItemLocalLink(&it))
+	return nil
+}
+
+// ValidateModerator checks that the logged account is one of the moderators
+func (h *handler) ValidateModerator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isModerator(&h.conf.Configuration, loggedAccount(r)) {
+			h.v.HandleErrors(w, r, errors.Forbiddenf("only the moderators can do this"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidateItemModerator checks that the logged account can moderate the item in the URL, as a moderator
+// of the instance, or of the board the item was posted in
+func (h *handler) ValidateItemModerator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc := loggedAccount(r)
+		if isModerator(&h.conf.Configuration, acc) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		repo := h.storage
+		it, err := repo.LoadItem(r.Context(), objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+		if err != nil {
+			h.v.HandleErrors(w, r, errors.NewNotFound(err, "item"))
+			return
+		}
+		if !canModerateItem(&h.conf.Configuration, repo.boards, acc, &it) {
+			h.v.HandleErrors(w, r, errors.Forbiddenf("only the moderators can do this"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type removeModel struct {
+	Title   string
+	Hash    Hash
+	Content *Item
+	Reasons []removalReason
+}
+
+func (m *removeModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (removeModel) Template() string {
+	return "remove"
+}
+
+func (m *removeModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleRemoveForm serves the /{hash}/remove GET requests, with the form for picking the reason of the removal
+func (h *handler) HandleRemoveForm(w http.ResponseWriter, r *http.Request) {
+	m := &removeModel{Hash: HashFromString(chi.URLParam(r, "hash")), Reasons: h.storage.reasons.List()}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || m.Content.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	m.Title = fmt.Sprintf("Remove: %s", m.Content.Title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleRemove handles the /{hash}/remove POST requests, from the moderators
+func (h *handler) HandleRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	acc := loggedAccount(r)
+	repo := h.storage
+	it, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "item"))
+		return
+	}
+	rr, ok := repo.reasons.Get(r.PostFormValue("reason"))
+	if !ok {
+		h.v.addFlashMessage(Error, w, r, "Please pick the reason of the removal.")
+		h.v.Redirect(w, r, ItemPermaLink(&it)+"/remove", http.StatusSeeOther)
+		return
+	}
+	if err := repo.RemoveItem(ctx, acc, it, rr, r.PostFormValue("note")); err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to remove the item")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The item was removed as %q, and its author was notified.", rr.Label))
+	h.v.Redirect(w, r, ItemPermaLink(&it), http.StatusSeeOther)
+}
+
+type removalReasonsModel struct {
+	Title   string
+	Reasons []removalReason
+}
+
+func (m *removalReasonsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (removalReasonsModel) Template() string {
+	return "reasons"
+}
+
+// HandleRemovalReasons serves the /admin/reasons requests, with the reasons the moderators can remove content for
+func (h *handler) HandleRemovalReasons(w http.ResponseWriter, r *http.Request) {
+	m := &removalReasonsModel{Title: "Removal reasons", Reasons: h.storage.reasons.List()}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleAddRemovalReason handles the POST /admin/reasons requests, which add or change a reason
+func (h *handler) HandleAddRemovalReason(w http.ResponseWriter, r *http.Request) {
+	rr := removalReason{
+		Name:    strings.ToLower(strings.TrimSpace(r.PostFormValue("name"))),
+		Label:   strings.TrimSpace(r.PostFormValue("label")),
+		Message: strings.TrimSpace(r.PostFormValue("message")),
+	}
+	if err := h.storage.reasons.Add(rr); err != nil {
+		h.errFn(log.Ctx{"err": err, "reason": rr.Name})("unable to save the removal reason")
+		h.v.addFlashMessage(Error, w, r, err.Error())
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The %q reason was saved.", rr.Label))
+	}
+	h.v.Redirect(w, r, "/admin/reasons", http.StatusSeeOther)
+}
+
+// HandleRemoveRemovalReason handles the POST /admin/reasons/rm requests
+func (h *handler) HandleRemoveRemovalReason(w http.ResponseWriter, r *http.Request) {
+	name := r.PostFormValue("name")
+	if err := h.storage.reasons.Remove(name); err != nil {
+		h.errFn(log.Ctx{"err": err, "reason": name})("unable to remove the removal reason")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to remove the reason"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("%s was removed.", name))
+	h.v.Redirect(w, r, "/admin/reasons", http.StatusSeeOther)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"net/http"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+const (
+	ReportSpam       = "spam"
+	ReportHarassment = "harassment"
+	ReportIllegal    = "illegal"
+	ReportOther      = "other"
+)
+
+// reportCategory is the kind of problem a report is about, it's saved as the name of the Flag activity
+type reportCategory struct {
+	Name        string
+	Label       string
+	Description string
+}
+
+var reportCategories = []reportCategory{
+	{Name: ReportSpam, Label: "Spam", Description: "Advertising, link farming, or repeated off topic posting"},
+	{Name: ReportHarassment, Label: "Harassment", Description: "Insults, threats, or targeting somebody"},
+	{
+		Name:        ReportIllegal,
+		Label:       "Illegal content",
+		Description: "Content which is against the law, the report is only visible to the administrators",
+	},
+	{Name: ReportOther, Label: "Other", Description: "Something else, please explain below"},
+}
+
+// reportCategoryLabel returns the label of the category with name, or an empty string if it's not a known one
+func reportCategoryLabel(name string) string {
+	for _, c := range reportCategories {
+		if c.Name == name {
+			return c.Label
+		}
+	}
+	return ""
+}
+
+// reportFromRequest loads the category and the free text of a report, the forward value is set when the user
+// asked for the report to be sent to the instance of the reported content too
+func reportFromRequest(r *http.Request, acc Account) (reason Item, forward bool, err error) {
+	if reason, err = ContentFromRequest(r, acc); err != nil {
+		return reason, false, err
+	}
+	reason.Title = ReportOther
+	if cat := r.PostFormValue("category"); len(reportCategoryLabel(cat)) > 0 {
+		reason.Title = cat
+	}
+	return reason, r.PostFormValue("forward") == "true", nil
+}
+
+// CategoryLabel returns the label of the category of the report
+func (m ModerationOp) CategoryLabel() string {
+	return reportCategoryLabel(m.Category)
+}
+
+// RemoteObject returns if the reported item or account is from another instance
+func (m ModerationOp) RemoteObject() bool {
+	switch o := m.Object.(type) {
+	case *Item:
+		return o.SubmittedBy != nil && o.SubmittedBy.HasMetadata() && o.SubmittedBy.IsFederated()
+	case *Account:
+		return o.HasMetadata() && o.IsFederated()
+	}
+	return false
+}
+
+// restricted returns if the report can only be seen by the administrators
+func (m ModerationOp) restricted() bool {
+	return m.IsReport() && m.Category == ReportIllegal
+}
+
+// filterReports removes from the cursor the reports which are restricted, or the other ones if restricted is set
+func filterReports(c *Cursor, restricted bool) {
+	if c == nil {
+		return
+	}
+	for k, it := range c.items {
+		m, ok := it.(*ModerationOp)
+		if (ok && m.restricted()) != restricted {
+			delete(c.items, k)
+		}
+	}
+}
+
+// HideRestrictedReportsMw removes the reports for illegal content from the public moderation log
+func HideRestrictedReportsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filterReports(ContextCursor(r.Context()), false)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RestrictedReportsMw keeps only the reports for illegal content, for the /admin/reports listing
+func RestrictedReportsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filterReports(ContextCursor(r.Context()), true)
+		if m := ContextListingModel(r.Context()); m != nil {
+			m.Title = "Reports for illegal content"
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reportAudience returns the recipients of a report, besides the moderators of this instance.
+// The reports for remote content are forwarded to its author's instance only when the user asks for it.
+func reportAudience(forward bool, reported *Account) pub.ItemCollection {
+	aud := make(pub.ItemCollection, 0)
+	if !forward || reported == nil || !reported.HasMetadata() || reported.IsLocal() {
+		return aud
+	}
+	return append(aud, pub.IRI(reported.Metadata.ID))
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestFilterReports(t *testing.T) {
+	items := func() RenderableList {
+		return RenderableList{
+			HashFromString("1"): &ModerationOp{Hash: HashFromString("1"), pub: &pub.Flag{Type: pub.FlagType}, Category: ReportSpam},
+			HashFromString("2"): &ModerationOp{Hash: HashFromString("2"), pub: &pub.Flag{Type: pub.FlagType}, Category: ReportIllegal},
+			HashFromString("3"): &ModerationOp{Hash: HashFromString("3"), pub: &pub.Block{Type: pub.BlockType}},
+			HashFromString("4"): &Item{Hash: HashFromString("4")},
+		}
+	}
+
+	c := &Cursor{items: items()}
+	filterReports(c, false)
+	if _, ok := c.items[HashFromString("2")]; ok {
+		t.Errorf("the report for illegal content is in the moderation log")
+	}
+	if len(c.items) != 3 {
+		t.Errorf("expected 3 items in the moderation log, got %d", len(c.items))
+	}
+
+	c = &Cursor{items: items()}
+	filterReports(c, true)
+	if _, ok := c.items[HashFromString("2")]; !ok || len(c.items) != 1 {
+		t.Errorf("expected only the report for illegal content for the admins, got %d items", len(c.items))
+	}
+}
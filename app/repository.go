@@ -32,8 +32,82 @@ type repository struct {
 	SelfURL string
 	app     *Account
 	fedbox  *fedbox
-	infoFn  CtxLogFn
-	errFn   CtxLogFn
+	pages   pages
+	index   *localIndex
+	dedup   *activityDedup
+	fill    *backfill
+	peers   *peers
+	convs   *conversations
+	apObjs  *objectCache
+	badges  *objectCache
+	stale   *staleCursors
+	trees   *commentTrees
+	snaps   *accountSnapshots
+	renames *handleRenames
+	avatars *avatarLookups
+	// media checks the files we accept, or serve from our domain, with the antivirus
+	media *mediaScanner
+	// usage are the sizes of the media files we keep, for the storage quotas
+	usage   *mediaUsage
+	reader  *articleReader
+	feeds   *feedTokens
+	posting *postingPatterns
+	bots    *feedBots
+	// linkSnaps are the snapshots of the pages the link items point to
+	linkSnaps *linkSnapshots
+	titles    titleRules
+	translate *translations
+	// deletes are the deleted items which can still be restored by their authors
+	deletes *pendingDeletes
+	// removals are the items removed by the moderators, and reasons the reasons they can pick from
+	removals *removals
+	reasons  *removalReasons
+	// notes are the private notes of the moderators about accounts
+	notes *accountNotes
+	// slow are the threads the moderators put in slow mode
+	slow *slowModes
+	// held are the items of the new accounts waiting for the approval of the moderators
+	held *heldItems
+	// fingerprints are the hashed fingerprints used for detecting the new accounts of banned users
+	fingerprints *fingerprints
+	// boards are the topical sections of the instance, and the subscriptions of the accounts to them
+	boards *boards
+	// wiki are the collaborative pages of the boards, with their revisions
+	wiki *wiki
+	// events are the upcoming events, and the answers of the local accounts to them
+	events *events
+	// mail are the secret posting addresses of the accounts, for the mail gateway
+	mail *mailAddresses
+	// digests are the digest email subscriptions of the accounts
+	digests *digests
+	// push are the Web Push subscriptions of the browsers of the accounts
+	push *webPush
+	// channels are the ntfy, Gotify and email notification channels of the accounts
+	channels *notificationChannels
+	// notifications are the notifications of the local accounts, with their read state
+	notifications *notificationLog
+	// quiet are the notifications held during the quiet hours of the accounts
+	quiet *heldNotifications
+	// search sends the items we load to the full text search index
+	search *searchIndexer
+	// nodeInfo caches the usage counts we publish in the NodeInfo documents
+	nodeInfo nodeInfoStats
+	// completions are the names offered by the autocompletion of the forms
+	completions completionNames
+	// trending are the tags used by the most accounts lately
+	trending *trendingTags
+	// stats are the daily statistics of the instance, for the /stats page
+	stats *instanceStats
+	// settings are the private settings of the local accounts we loaded
+	settings *accountSettingsCache
+	// edits are the versions the items had before they were edited
+	edits  *editHistory
+	relays pub.IRIs
+	infoFn CtxLogFn
+	errFn  CtxLogFn
+
+	// relayTypes are the object types we accept from the relays
+	relayTypes pub.ActivityVocabularyTypes
 }
 
 func (r repository) BaseURL() pub.IRI {
@@ -50,7 +124,7 @@ func (h handler) Repository(next http.Handler) http.Handler {
 }
 
 func ActivityPubService(c appConfig) (*repository, error) {
-	pub.ItemTyperFunc = pub.GetItemByType
+	pub.ItemTyperFunc = itemByType
 
 	infoFn := func(ctx ...log.Ctx) LogFn {
 		return c.Logger.WithContext(append(ctx, log.Ctx{"client": "api"})...).Debugf
@@ -60,23 +134,164 @@ func ActivityPubService(c appConfig) (*repository, error) {
 	}
 	ua := fmt.Sprintf("%s-%s", c.HostName, Instance.Version)
 
+	var err error
 	repo := &repository{
 		SelfURL: c.BaseURL,
+		pages:   pages{path: c.PagesPath},
+		index:   newLocalIndex(),
+		dedup:   newActivityDedup(c.DedupTTL),
+		convs:   newConversations(),
+		apObjs:  newObjectCache(),
+		badges:  newObjectCache(),
+		stale:   newStaleCursors(),
+		trees:   newCommentTrees(),
+		snaps:   newAccountSnapshots(),
+		relays:  relayIRIs(c.Relays),
 		infoFn:  infoFn,
 		errFn:   errFn,
 	}
-	var err error
+	repo.relayTypes = relayObjectTypes(c.RelayObjectTypes)
+	repo.settings = newAccountSettingsCache()
+	repo.peers = newPeers(c.InstancesBlocked, c.InstancesLimited, errFn)
+	renamesPath := path.Join(c.DataPath, handleRenamesFile)
+	if repo.renames, err = loadHandleRenames(renamesPath, c.HandleReservePeriod, c.HandleRenameInterval); err != nil {
+		errFn(log.Ctx{"err": err, "path": renamesPath})("unable to load the renamed handles")
+	}
+	scanBackend, err := newMediaScanBackend(c.Configuration)
+	if err != nil {
+		errFn(log.Ctx{"err": err, "scanner": c.MediaScanner})("unable to set up the media scanner")
+	}
+	repo.media = newMediaScanner(scanBackend, path.Join(c.DataPath, quarantineLogFile), errFn)
+	avatarsPath := path.Join(c.DataPath, avatarLookupsFile)
+	if repo.avatars, err = loadAvatarLookups(avatarsPath, c.AvatarLookupURL); err != nil {
+		errFn(log.Ctx{"err": err, "path": avatarsPath})("unable to load the avatar lookups")
+	}
+	repo.avatars.scanner = repo.media
+	// NOTE(marius): the URLs the users give us are loaded with a client which can't reach the local network
+	remote := &http.Client{Transport: newRemoteTransport(c.Configuration)}
+	repo.reader = newArticleReader(c.ReaderEnabled, c.ReaderOptOut, remote)
+	repo.posting = newPostingPatterns(c.BotPostsPerHour)
+	usagePath := path.Join(c.DataPath, mediaUsageFile)
+	if repo.usage, err = loadMediaUsage(usagePath, c.MediaQuota, c.AccountMediaQuota); err != nil {
+		errFn(log.Ctx{"err": err, "path": usagePath})("unable to load the media usage")
+	}
+	repo.linkSnaps = newLinkSnapshots(c.SnapshotsEnabled, path.Join(c.DataPath, linkSnapshotsDir), repo.reader, repo.usage)
+	repo.translate = newTranslations(newTranslator(c.TranslateProvider, c.TranslateURL, c.TranslateAPIKey))
+	if repo.titles, err = newTitleRules(c.TitleStripPatterns); err != nil {
+		errFn(log.Ctx{"err": err})("unable to load the title rules")
+	}
+	feedsPath := path.Join(c.DataPath, feedTokensFile)
+	if repo.feeds, err = loadFeedTokens(feedsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": feedsPath})("unable to load the feed tokens")
+	}
+	botsPath := path.Join(c.DataPath, feedBotsFile)
+	if repo.bots, err = loadFeedBots(botsPath, c.FeedBotsInterval); err != nil {
+		errFn(log.Ctx{"err": err, "path": botsPath})("unable to load the feed bots")
+	}
+	tokens := newTokenKeys(c.SessionKeys)
+	deletesPath := path.Join(c.DataPath, pendingDeletesFile)
+	if repo.deletes, err = loadPendingDeletes(deletesPath, c.DeleteRestoreWindow, tokens); err != nil {
+		errFn(log.Ctx{"err": err, "path": deletesPath})("unable to load the pending deletes")
+	}
+	removalsPath := path.Join(c.DataPath, removalsFile)
+	if repo.removals, err = loadRemovals(removalsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": removalsPath})("unable to load the removed items")
+	}
+	reasonsPath := path.Join(c.DataPath, removalReasonsFile)
+	if repo.reasons, err = loadRemovalReasons(reasonsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": reasonsPath})("unable to load the removal reasons")
+	}
+	notesPath := path.Join(c.DataPath, accountNotesFile)
+	if repo.notes, err = loadAccountNotes(notesPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": notesPath})("unable to load the account notes")
+	}
+	slowPath := path.Join(c.DataPath, slowModeFile)
+	if repo.slow, err = loadSlowModes(slowPath, c.SlowModeInterval, c.SlowModeDuration); err != nil {
+		errFn(log.Ctx{"err": err, "path": slowPath})("unable to load the threads in slow mode")
+	}
+	heldPath := path.Join(c.DataPath, heldItemsFile)
+	if repo.held, err = loadHeldItems(heldPath, c.PremoderateFirst, c.PremoderateAccountAge, tokens); err != nil {
+		errFn(log.Ctx{"err": err, "path": heldPath})("unable to load the moderation queue")
+	}
+	fpPath := path.Join(c.DataPath, fingerprintsFile)
+	if repo.fingerprints, err = loadFingerprints(fpPath, c.BanEvasionChecksEnabled, c.BanEvasionWindow); err != nil {
+		errFn(log.Ctx{"err": err, "path": fpPath})("unable to load the fingerprints")
+	}
+	// NOTE(marius): the boards, their wiki pages and the upcoming events are loaded from fedbox by startBackend, once it's available
+	repo.boards = newBoards(func(b board) (board, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), backendLoadTimeOut)
+		defer cancel()
+		return repo.saveBoard(ctx, b)
+	})
+	repo.wiki = newWiki(func(p wikiPage) (wikiPage, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), backendLoadTimeOut)
+		defer cancel()
+		return repo.saveWikiPage(ctx, p)
+	})
+	repo.events = newEvents()
+	mailPath := path.Join(c.DataPath, mailAddressesFile)
+	if repo.mail, err = loadMailAddresses(mailPath, c.MailGatewayDomain, tokens); err != nil {
+		errFn(log.Ctx{"err": err, "path": mailPath})("unable to load the posting addresses")
+	}
+	digestsPath := path.Join(c.DataPath, digestsFile)
+	if repo.digests, err = loadDigests(digestsPath, newMailer(&c.Configuration), c.DigestItems); err != nil {
+		errFn(log.Ctx{"err": err, "path": digestsPath})("unable to load the digest subscriptions")
+	}
+	if err = repo.digests.LoadTemplate(c.DigestTemplate); err != nil {
+		errFn(log.Ctx{"err": err, "path": c.DigestTemplate})("unable to load the digest template, using the default one")
+	}
+	pushPath := path.Join(c.DataPath, pushSubscriptionsFile)
+	if repo.push, err = loadWebPush(pushPath, &c.Configuration, c.BaseURL); err != nil {
+		errFn(log.Ctx{"err": err, "path": pushPath})("unable to load the push subscriptions")
+	}
+	repo.push.client = remote
+	channelsPath := path.Join(c.DataPath, notificationChannelsFile)
+	confirmedEmail := func(id string) string {
+		if _, d, ok := repo.digests.Get(id); ok && d.Confirmed {
+			return d.Email
+		}
+		return ""
+	}
+	if repo.channels, err = loadNotificationChannels(channelsPath, c.NotificationChannels, repo.digests.mail, confirmedEmail); err != nil {
+		errFn(log.Ctx{"err": err, "path": channelsPath})("unable to load the notification channels")
+	}
+	repo.channels.client = remote
+	notificationsPath := path.Join(c.DataPath, notificationsFile)
+	if repo.notifications, err = loadNotificationLog(notificationsPath, maxNotifications); err != nil {
+		errFn(log.Ctx{"err": err, "path": notificationsPath})("unable to load the notifications")
+	}
+	heldNotificationsPath := path.Join(c.DataPath, heldNotificationsFile)
+	if repo.quiet, err = loadHeldNotifications(heldNotificationsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": heldNotificationsPath})("unable to load the held notifications")
+	}
+	idx, err := newSearchIndex(c.Configuration, c.DataPath)
+	if err != nil {
+		errFn(log.Ctx{"err": err, "backend": c.SearchBackend})("unable to load the search index")
+	}
+	repo.search = newSearchIndexer(idx, errFn)
+	repo.trending = newTrendingTags(repo.index, c.TrendingMinAuthors)
+	statsPath := path.Join(c.DataPath, statsFile)
+	if repo.stats, err = loadInstanceStats(statsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": statsPath})("unable to load the instance statistics")
+	}
+	editsPath := path.Join(c.DataPath, editHistoryFile)
+	if repo.edits, err = loadEditHistory(editsPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": editsPath})("unable to load the edit history")
+	}
 	repo.fedbox, err = NewClient(
 		SetURL(c.APIURL),
 		SetInfoLogger(infoFn),
 		SetErrorLogger(errFn),
 		SetUA(ua),
-		SkipTLSCheck(!c.Env.IsProd()),
+		SetHTTPClient(&http.Client{Transport: newTransport(c.Configuration, !c.Env.IsProd())}),
+		SetCircuitBreaker(c.BreakerThreshold, c.BreakerCoolDown),
 	)
-	if err != nil {
+	if repo.fedbox == nil {
 		return repo, err
 	}
-	return repo, nil
+	repo.fill = newBackfill(repo.fedbox.client.CtxLoadIRI, errFn)
+	repo.fill.doneFn = repo.trees.RemoveIRI
+	return repo, err
 }
 
 func accountURL(acc Account) pub.IRI {
@@ -139,6 +354,10 @@ func loadAPItem(it pub.Item, item Item) error {
 			}
 		}
 
+		if item.IsEvent() && item.Parent == nil {
+			loadAPEvent(o, item.Metadata.Event)
+		}
+
 		o.Published = item.SubmittedAt
 		o.Updated = item.UpdatedAt
 
@@ -254,6 +473,7 @@ func loadAPItem(it pub.Item, item Item) error {
 				}
 				for _, tag := range m.Tags {
 					t := pub.Object{
+						Type: hashtagType,
 						URL:  pub.ID(tag.URL),
 						To:   pub.ItemCollection{pub.PublicNS},
 						Name: pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: pub.Content("#" + tag.Name)}},
@@ -265,6 +485,12 @@ func loadAPItem(it pub.Item, item Item) error {
 				}
 			}
 		}
+		if item.IsClassified() && item.Parent == nil {
+			loadAPClassified(o, item.Metadata.Classified)
+		}
+		if item.IsJob() && item.Parent == nil {
+			loadAPJob(o, item.Metadata.Job)
+		}
 		o.To = to
 		o.CC = cc
 		o.BCC = bcc
@@ -300,7 +526,7 @@ func (r *repository) loadAPPerson(a Account) *pub.Actor {
 	if a.HasMetadata() {
 		if p.Summary.Count() == 0 && a.Metadata.Blurb != nil && len(a.Metadata.Blurb) > 0 {
 			p.Summary = pub.NaturalLanguageValuesNew()
-			p.Summary.Set(pub.NilLangRef, a.Metadata.Blurb)
+			p.Summary.Set(pub.NilLangRef, pub.Content(bioSummary(string(a.Metadata.Blurb))))
 		}
 		if p.Icon == nil && len(a.Metadata.Icon.URI) > 0 {
 			avatar := pub.ObjectNew(pub.ImageType)
@@ -483,6 +709,14 @@ func (r *repository) loadAccountsOutbox(ctx context.Context, acc *Account) error
 	}
 	latest := time.Now().Add(-6 * 30 * 24 * time.Hour).UTC()
 	max := MaxContentItems * 25 // NOTE(marius): this affects how big the session stored value for an account can get
+	tags := make(FollowedTags, 0)
+	saved := make(SavedItems, 0)
+	savedCol := savedCollection(accountIRI(acc))
+	undone := make(pub.IRIs, 0)
+	defer func() {
+		acc.Tags = tags
+		acc.Saved = saved
+	}()
 	return LoadFromCollection(ctx, collFn, &colCursor{filters: &Filters{MaxItems: max}}, func(o pub.CollectionInterface) (bool, error) {
 		if ocTypes.Contains(o.GetType()) {
 			pub.OnOrderedCollection(o, func(oc *pub.OrderedCollection) error {
@@ -521,6 +755,31 @@ func (r *repository) loadAccountsOutbox(ctx context.Context, acc *Account) error
 			}
 			pub.OnActivity(it, func(a *pub.Activity) error {
 				skipOutbox = skipOutbox || a.Updated.Sub(latest) > 0
+				if a.Object == nil {
+					return nil
+				}
+				// NOTE(marius): the outbox is ordered newest first, so the Undo activities
+				// get processed before the activities they are reverting
+				if typ == pub.UndoType {
+					undone = append(undone, a.Object.GetLink())
+				}
+				if typ == pub.FollowType && strings.Contains(a.Object.GetLink().String(), string(objects)) {
+					if undone.Contains(a.GetLink()) {
+						return nil
+					}
+					t := FollowedTag{
+						Name:   a.Name.First().Value.String(),
+						IRI:    a.Object.GetLink(),
+						Follow: a.GetLink(),
+					}
+					if len(t.Name) > 0 && !tags.Contains(t.Name) {
+						tags = append(tags, t)
+					}
+				}
+				var isSave bool
+				if saved, isSave = saved.load(a, savedCol, undone); isSave {
+					skipOutbox = true
+				}
 				return nil
 			})
 			if len(acc.Metadata.Outbox) < max && !skipOutbox {
@@ -635,6 +894,14 @@ func (r *repository) loadItemsVotes(ctx context.Context, items ...Item) (ItemCol
 			if !vAct.IsObject() || !voteActivities.Contains(vAct.GetType()) {
 				continue
 			}
+			duplicate := false
+			pub.OnActivity(vAct, func(a *pub.Activity) error {
+				duplicate = r.dedup.IsDuplicate(a)
+				return nil
+			})
+			if duplicate {
+				continue
+			}
 			v := new(Vote)
 			if err := v.FromActivityPub(vAct); err == nil {
 				for k, ob := range items {
@@ -1152,24 +1419,29 @@ func (r *repository) objects(ctx context.Context, ff ...*Filters) (ItemCollectio
 			})
 		})
 	}
+	// NOTE(marius): on errors we return the items loaded so far, for the callers which can show partial results
 	if err := g.Wait(); err != nil {
-		return nil, err
+		return items, err
 	}
 
 	var err error
 	if items, err = r.loadItemsAuthors(ctx, items...); err != nil {
-		return nil, err
+		return items, err
 	}
 	if items, err = r.loadItemsVotes(ctx, items...); err != nil {
-		return nil, err
+		return items, err
 	}
 	return items, nil
 }
 
 func (r *repository) Objects(ctx context.Context, ff ...*Filters) (Cursor, error) {
+	partial := false
 	items, err := r.objects(ctx, ff...)
 	if err != nil {
-		return emptyCursor, err
+		if !isTimeout(err) {
+			return emptyCursor, err
+		}
+		partial = true
 	}
 	result := make(RenderableList, 0)
 	for _, it := range items {
@@ -1183,10 +1455,11 @@ func (r *repository) Objects(ctx context.Context, ff ...*Filters) (Cursor, error
 		prev = HashFromString(f.Prev)
 	}
 	return Cursor{
-		after:  next,
-		before: prev,
-		items:  result,
-		total:  uint(len(result)),
+		after:   next,
+		before:  prev,
+		items:   result,
+		total:   uint(len(result)),
+		partial: partial,
 	}, nil
 }
 
@@ -1301,10 +1574,15 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 			err := LoadFromCollection(ctx, fn, &colCursor{filters: f}, func(col pub.CollectionInterface) (bool, error) {
 				for _, it := range col.Collection() {
 					pub.OnActivity(it, func(a *pub.Activity) error {
+						a = unwrapGroupAnnounce(a)
+						if r.dedup.IsDuplicate(a) {
+							return nil
+						}
 						relM.Lock()
 						defer relM.Unlock()
 
-						typ := it.GetType()
+						typ := a.GetType()
+						r.snaps.Invalidate(a)
 						if typ == pub.CreateType {
 							ob := a.Object
 							if ob == nil {
@@ -1330,7 +1608,26 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 							}
 							relations[a.GetLink()] = ob.GetLink()
 						}
-						if it.GetType() == pub.FollowType {
+						if typ == pub.AnnounceType && r.IsRelay(a.Actor) {
+							ob := a.Object
+							if ob == nil {
+								return nil
+							}
+							if ob.IsObject() {
+								if !r.relayTypes.Contains(ob.GetType()) {
+									return nil
+								}
+								i := Item{}
+								i.FromActivityPub(ob)
+								if validItem(i, f) {
+									items = append(items, i)
+								}
+							} else {
+								appendToDeferred(ob, EqualsString)
+							}
+							relations[a.GetLink()] = ob.GetLink()
+						}
+						if typ == pub.FollowType {
 							f := FollowRequest{}
 							f.FromActivityPub(a)
 							follows = append(follows, f)
@@ -1395,34 +1692,53 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 			return nil
 		})
 	}
-	if err := g.Wait(); err != nil {
+	// NOTE(marius): when we run out of the time budget of the request we show what we managed to load
+	partial := false
+	timedOut := func(err error) bool {
+		if !isTimeout(err) {
+			return false
+		}
+		partial = true
+		return true
+	}
+	if err := g.Wait(); err != nil && !timedOut(err) {
 		return emptyCursor, err
 	}
-	var err error
-	items, err = r.loadItemsAuthors(ctx, items...)
-	if err != nil {
+	if it, err := r.loadItemsAuthors(ctx, items...); err == nil {
+		items = it
+	} else if !timedOut(err) {
 		return emptyCursor, err
 	}
-	items, err = r.loadItemsVotes(ctx, items...)
-	if err != nil {
+	if it, err := r.loadItemsVotes(ctx, items...); err == nil {
+		items = it
+	} else if !timedOut(err) {
 		return emptyCursor, err
 	}
-	_, err = r.loadItemsReplies(ctx, items...)
-	if err != nil {
+	items = r.resolveConversations(ctx, items)
+	r.index.Add(items...)
+	r.search.Queue(items...)
+	r.trees.Invalidate(items...)
+	if _, err := r.loadItemsReplies(ctx, items...); err != nil && !timedOut(err) {
 		return emptyCursor, err
 	}
-	follows, err = r.loadFollowsAuthors(ctx, follows...)
-	if err != nil {
+	if f, err := r.loadFollowsAuthors(ctx, follows...); err == nil {
+		follows = f
+	} else if !timedOut(err) {
 		return emptyCursor, err
 	}
-	accounts, err = r.loadAccountsAuthors(ctx, accounts...)
-	if err != nil {
+	if a, err := r.loadAccountsAuthors(ctx, accounts...); err == nil {
+		accounts = a
+	} else if !timedOut(err) {
 		return emptyCursor, err
 	}
-	moderations, err = r.loadModerationDetails(ctx, moderations...)
-	if err != nil {
+	if m, err := r.loadModerationDetails(ctx, moderations...); err == nil {
+		moderations = m
+	} else if !timedOut(err) {
 		return emptyCursor, err
 	}
+	r.peers.Seen(items, accounts)
+	items = r.peers.filterItems(items)
+	accounts = r.peers.filterAccounts(accounts)
 
 	relM.RLock()
 	defer relM.RUnlock()
@@ -1472,10 +1788,11 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 	}
 
 	return Cursor{
-		after:  next,
-		before: prev,
-		items:  result,
-		total:  uint(len(result)),
+		after:   next,
+		before:  prev,
+		items:   result,
+		total:   uint(len(result)),
+		partial: partial,
 	}, nil
 }
 
@@ -1546,6 +1863,11 @@ func (r *repository) SaveVote(ctx context.Context, v Vote) (Vote, error) {
 		return v, err
 	}
 	r.infoFn(log.Ctx{"act": iri, "obj": it.GetLink(), "type": it.GetType()})("saved activity")
+	r.trees.Remove(*v.Item)
+	r.snaps.Remove(v.SubmittedBy)
+	if act.Type == pub.LikeType {
+		go r.notifyVote(*v.Item, *v.SubmittedBy)
+	}
 	err = v.FromActivityPub(act)
 	return v, err
 }
@@ -1653,7 +1975,9 @@ func loadMentionsIfExisting (r *repository, ctx context.Context, incoming TagCol
 	}
 
 	remoteFilters := make([]*Filters, 0)
-	for _, m := range incoming {
+	names := make([]string, len(incoming))
+	for i, m := range incoming {
+		names[i] = m.Name
 		// TODO(marius): we need to make a distinction between FedBOX remote servers and Webfinger remote servers
 		u, err := url.ParseRequestURI(m.URL)
 		if err != nil {
@@ -1662,6 +1986,8 @@ func loadMentionsIfExisting (r *repository, ctx context.Context, incoming TagCol
 		host := fmt.Sprintf("%s://%s", u.Scheme, u.Hostname())
 		if strings.Contains(r.SelfURL, host) {
 			host = r.fedbox.baseURL.String()
+			// NOTE(marius): the old handles of the renamed local accounts still resolve to them
+			names[i] = r.renames.Current(m.Name)
 		}
 		urlFilter := EqualsString(host)
 		var (
@@ -1678,7 +2004,7 @@ func loadMentionsIfExisting (r *repository, ctx context.Context, incoming TagCol
 			filter.IRI = append(filter.IRI, urlFilter)
 			add = true
 		}
-		nameFilter := EqualsString(m.Name)
+		nameFilter := EqualsString(names[i])
 		if !filter.Name.Contains(nameFilter) {
 			filter.Name = append(filter.Name, nameFilter)
 		}
@@ -1697,10 +2023,10 @@ func loadMentionsIfExisting (r *repository, ctx context.Context, incoming TagCol
 		}
 		pub.OnCollectionIntf(col, func(col pub.CollectionInterface) error {
 			for _, it := range col.Collection() {
-				for i, t := range incoming {
+				for i := range incoming {
 					pub.OnActor(it, func(act *pub.Actor) error {
-						if strings.ToLower(t.Name) == strings.ToLower(string(act.Name.Get("-"))) ||
-							strings.ToLower(t.Name) == strings.ToLower(string(act.PreferredUsername.Get("-"))) {
+						if strings.ToLower(names[i]) == strings.ToLower(string(act.Name.Get("-"))) ||
+							strings.ToLower(names[i]) == strings.ToLower(string(act.PreferredUsername.Get("-"))) {
 							url := act.ID.String()
 							if act.URL != nil {
 								url = act.URL.GetLink().String()
@@ -1797,6 +2123,17 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 	art := new(pub.Object)
 	loadAPItem(art, it)
 	id := art.GetLink()
+	if it.Parent == nil && it.HasMetadata() && len(it.Metadata.Board) > 0 && !it.Private() {
+		// NOTE(marius): the submissions posted in a board are addressed to it, which is what its listing loads
+		aud := pub.ItemCollection{pub.IRI(boardLink(it.Metadata.Board))}
+		art.Audience = aud
+		cc = append(cc, aud...)
+	}
+	if aud := r.conversationAudience(ctx, it.Parent); len(aud) > 0 && !it.Private() {
+		// NOTE(marius): replies to items posted in groups get addressed to them too, see FEP-1b12
+		art.Audience = aud
+		cc = append(cc, aud...)
+	}
 
 	act := &pub.Activity{
 		To:     to,
@@ -1833,13 +2170,21 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 		return it, err
 	}
 	r.infoFn(log.Ctx{"act": i, "obj": ob.GetLink(), "type": ob.GetType()})("saved activity")
+	r.apObjs.remove(id)
 	err = it.FromActivityPub(ob)
 	if err != nil {
 		r.errFn()(err.Error())
 		return it, err
 	}
+	r.trees.Remove(it)
+	if act.Type == pub.CreateType && it.IsLink() {
+		go r.snapshotLink(it)
+	}
 	if loadAuthors {
 		items, err := r.loadItemsAuthors(ctx, it)
+		if act.Type == pub.CreateType && err == nil {
+			go r.notifyNewItem(items[0])
+		}
 		return items[0], err
 	}
 	return it, err
@@ -2024,6 +2369,7 @@ func (r *repository) SendFollowResponse(ctx context.Context, f FollowRequest, ac
 		})("unable to respond to follow")
 		return err
 	}
+	r.snaps.Remove(er, ed)
 	return nil
 }
 
@@ -2059,6 +2405,67 @@ func (r *repository) FollowAccount(ctx context.Context, er, ed Account, reason *
 		})("Unable to follow")
 		return err
 	}
+	r.snaps.Remove(&er, &ed)
+	return nil
+}
+
+// FollowTag creates a Follow activity from the account to the tag
+// We're storing the name of the tag in the activity, so we can load the account's tags
+// from its outbox without dereferencing every object.
+func (r *repository) FollowTag(ctx context.Context, er Account, t Tag) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	if t.pub == nil {
+		return errors.NotFoundf("invalid tag %s", t.Name)
+	}
+	follower := r.loadAPPerson(er)
+
+	follow := new(pub.Follow)
+	follow.Type = pub.FollowType
+	follow.Name = make(pub.NaturalLanguageValues, 0)
+	follow.Name.Set(pub.NilLangRef, pub.Content(t.Name))
+	follow.To = pub.ItemCollection{follower.GetLink()}
+	follow.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	follow.Object = t.pub.GetLink()
+	follow.Actor = follower.GetLink()
+	if _, _, err := r.fedbox.ToOutbox(ctx, follow); err != nil {
+		r.errFn(log.Ctx{
+			"err":      err,
+			"follower": er.Handle,
+			"tag":      t.Name,
+		})("Unable to follow tag")
+		return err
+	}
+	r.snaps.Remove(&er)
+	return nil
+}
+
+// UnfollowTag undoes the Follow activity that er has created for the tag
+func (r *repository) UnfollowTag(ctx context.Context, er Account, t FollowedTag) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	if len(t.Follow) == 0 {
+		return errors.NotFoundf("tag %s is not followed", t.Name)
+	}
+	follower := r.loadAPPerson(er)
+
+	undo := new(pub.Activity)
+	undo.Type = pub.UndoType
+	undo.To = pub.ItemCollection{follower.GetLink()}
+	undo.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	undo.Object = t.Follow
+	undo.Actor = follower.GetLink()
+	if _, _, err := r.fedbox.ToOutbox(ctx, undo); err != nil {
+		r.errFn(log.Ctx{
+			"err":      err,
+			"follower": er.Handle,
+			"tag":      t.Name,
+		})("Unable to unfollow tag")
+		return err
+	}
+	r.snaps.Remove(&er)
 	return nil
 }
 
@@ -2120,16 +2527,92 @@ func (r *repository) SaveAccount(ctx context.Context, a Account) (Account, error
 		r.errFn(ltx, log.Ctx{"err": err})("account save failed")
 		return a, err
 	}
+	r.apObjs.remove(id)
 	if err := a.FromActivityPub(ap); err != nil {
 		r.errFn(ltx, log.Ctx{"err": err})("loading of actor from JSON failed")
 	}
 	return a, nil
 }
 
-// LoadInfo this method is here to keep compatibility with the repository interfaces
-// but in the long term we might want to store some of this information in the DB
-func (r *repository) LoadInfo() (WebInfo, error) {
-	return Instance.NodeInfo(), nil
+// updateAccount loads the actor of the local account from fedbox, changes it with fn, and saves it
+func (r *repository) updateAccount(ctx context.Context, a Account, fn func(p *pub.Actor)) (Account, error) {
+	iri := accountIRI(&a)
+	if len(iri) == 0 {
+		return a, errors.NotValidf("unable to update account without an ID")
+	}
+	p, err := r.fedbox.Actor(ctx, iri)
+	if err != nil {
+		return a, err
+	}
+	fn(p)
+
+	updated := Account{}
+	if err := updated.FromActivityPub(p); err != nil {
+		return a, err
+	}
+	return r.WithAccount(r.app).SaveAccount(ctx, updated)
+}
+
+// RenameAccount changes the handle of the local account, and its profile URL when it was pointing to the old one.
+// The old handle is kept, so it can redirect to the new one while it's reserved.
+func (r *repository) RenameAccount(ctx context.Context, a Account, handle string) (Account, error) {
+	renamed, err := r.updateAccount(ctx, a, func(p *pub.Actor) {
+		p.PreferredUsername = pub.NaturalLanguageValuesNew()
+		p.PreferredUsername.Set(pub.NilLangRef, pub.Content(handle))
+		if p.URL == nil || p.URL.GetLink().Equals(accountURL(a), false) {
+			p.URL = accountURL(Account{Handle: handle})
+		}
+	})
+	if err != nil {
+		return a, err
+	}
+	if err := r.renames.Add(a.Handle, handle, accountIRI(&a).String()); err != nil {
+		r.errFn(log.Ctx{"err": err, "old": a.Handle, "handle": handle})("unable to save the renamed handle")
+	}
+	return renamed, nil
+}
+
+// SetAccountFields replaces the profile fields of the local account
+func (r *repository) SetAccountFields(ctx context.Context, a Account, fields []ProfileField) (Account, error) {
+	return r.updateAccount(ctx, a, func(p *pub.Actor) {
+		p.Attachment = replaceAttachments(p.Attachment, propertyValueType, profileFieldsAttachment(fields))
+	})
+}
+
+// SetAccountName changes the display name of the local account, an empty name removes it
+func (r *repository) SetAccountName(ctx context.Context, a Account, name string) (Account, error) {
+	return r.updateAccount(ctx, a, func(p *pub.Actor) {
+		p.Name = nil
+		if len(name) > 0 {
+			p.Name = pub.NaturalLanguageValuesNew()
+			p.Name.Set(pub.NilLangRef, pub.Content(name))
+		}
+	})
+}
+
+// SetAccountBio changes the summary of the local account, an empty bio removes it
+func (r *repository) SetAccountBio(ctx context.Context, a Account, bio string) (Account, error) {
+	return r.updateAccount(ctx, a, func(p *pub.Actor) {
+		p.Summary = nil
+		if len(bio) > 0 {
+			p.Summary = pub.NaturalLanguageValuesNew()
+			p.Summary.Set(pub.NilLangRef, pub.Content(bioSummary(bio)))
+		}
+	})
+}
+
+// LoadPage loads the instance page corresponding to slug.
+// The about page falls back to the application's description when no custom page has been created for it.
+func (r *repository) LoadPage(slug string) (Page, error) {
+	p, err := r.pages.Load(slug)
+	if err != nil && slug == pageAbout && errors.IsNotFound(err) {
+		p.Title, p.Content = splitPageTitle([]byte(Instance.NodeInfo().Description))
+		if len(p.Title) == 0 {
+			p.Title = "About"
+		}
+		return p, nil
+	}
+	return p, err
 }
 
 func (r *repository) LoadAccountWithDetails(ctx context.Context, actor Account, f ...*Filters) (*Cursor, error) {
@@ -2260,6 +2743,7 @@ func (r *repository) BlockAccount(ctx context.Context, er, ed Account, reason *I
 		r.errFn()(err.Error())
 		return err
 	}
+	r.snaps.Remove(&er, &ed)
 	return nil
 }
 
@@ -2274,16 +2758,20 @@ func (r *repository) BlockItem(ctx context.Context, er Account, ed Item, reason
 		r.errFn()(err.Error())
 		return err
 	}
+	r.snaps.Remove(&er)
 	return nil
 }
 
-func (r *repository) ReportItem(ctx context.Context, er Account, it Item, reason *Item) error {
+// ReportItem sends a Flag activity for the item, when forward is set the reports for remote items
+// are addressed to their authors too, so they get delivered to their instances
+func (r *repository) ReportItem(ctx context.Context, er Account, it Item, reason *Item, forward bool) error {
 	flag, err := r.moderationActivityOnItem(ctx, er, it, reason)
 	if err != nil {
 		r.errFn()(err.Error())
 		return err
 	}
 	flag.Type = pub.FlagType
+	flag.To = append(flag.To, reportAudience(forward, it.SubmittedBy)...)
 	if _, _, err = r.fedbox.ToOutbox(ctx, flag); err != nil {
 		r.errFn()(err.Error())
 		return err
@@ -2291,13 +2779,15 @@ func (r *repository) ReportItem(ctx context.Context, er Account, it Item, reason
 	return nil
 }
 
-func (r *repository) ReportAccount(ctx context.Context, er, ed Account, reason *Item) error {
+// ReportAccount sends a Flag activity for the account, see ReportItem
+func (r *repository) ReportAccount(ctx context.Context, er, ed Account, reason *Item, forward bool) error {
 	flag, err := r.moderationActivityOnAccount(ctx, er, ed, reason)
 	if err != nil {
 		r.errFn()(err.Error())
 		return err
 	}
 	flag.Type = pub.FlagType
+	flag.To = append(flag.To, reportAudience(forward, &ed)...)
 	if _, _, err = r.fedbox.ToOutbox(ctx, flag); err != nil {
 		r.errFn()(err.Error())
 		return err
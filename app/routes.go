@@ -1,6 +1,7 @@
 package app
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,14 +15,28 @@ import (
 
 func (h *handler) ItemRoutes() func(chi.Router) {
 	return func(r chi.Router) {
-		r.Use(h.CSRF, ContentModelMw, h.ItemFiltersMw, LoadObjectFromInboxMw, ThreadedListingMw, SortByScore)
-		r.Get("/", h.HandleShow)
+		r.Use(h.ItemActivityPubMw, h.CSRF, ContentModelMw, h.ItemFiltersMw, LoadObjectFromInboxMw, ThreadedListingMw, SortByScore)
+		r.With(ItemSlugMw, RelatedItemsMw, CommentSearchMw).Get("/", h.HandleShow)
+		r.Get("/reader", h.HandleReader)
+		r.Get("/export", h.HandleExport)
+		r.Get("/print", h.HandlePrint)
+		r.Get("/qr", h.HandleItemQR)
+		r.Get("/share", h.HandleShare)
+		r.Get("/snapshot", h.HandleSnapshot)
+		r.Get("/history", h.HandleItemHistory)
+		r.Get("/translate", h.HandleTranslate)
+		r.Get("/event.ics", h.HandleEventICS)
 		r.With(h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/", h.HandleSubmit)
 
 		r.Group(func(r chi.Router) {
 			r.Use(h.ValidateLoggedIn(h.v.RedirectToErrors))
 			r.Get("/yay", h.HandleVoting)
 			r.Get("/nay", h.HandleVoting)
+			r.Post("/yay", h.HandleVoting)
+			r.Post("/nay", h.HandleVoting)
+			r.Post("/rsvp", h.HandleRSVP)
+			r.Post("/save", h.HandleSaveItem)
+			r.Post("/unsave", h.HandleSaveItem)
 
 			//r.Get("/bad", h.ShowReport)
 			r.With(ReportContentModelMw).Get("/bad", h.HandleShow)
@@ -33,8 +48,21 @@ func (h *handler) ItemRoutes() func(chi.Router) {
 				r.With(h.ValidateItemAuthor("edit"), EditContentModelMw).Get("/edit", h.HandleShow)
 				r.With(h.ValidateItemAuthor("edit")).Post("/edit", h.HandleSubmit)
 				r.With(h.ValidateItemAuthor("delete")).Get("/rm", h.HandleDelete)
+				r.With(h.ValidateItemAuthor("restore")).Post("/restore", h.HandleRestore)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(h.ValidateItemModerator)
+				r.Get("/remove", h.HandleRemoveForm)
+				r.Post("/remove", h.HandleRemove)
+				r.Get("/slow", h.HandleSlowModeForm)
+				r.Post("/slow", h.HandleSlowMode)
+				r.Post("/pin", h.HandleBoardPin)
+				r.Post("/lock", h.HandleBoardLock)
 			})
 		})
+
+		r.With(ItemSlugMw, RelatedItemsMw, CommentSearchMw).Get("/{slug}", h.HandleShow)
+		r.With(h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/{slug}", h.HandleSubmit)
 	}
 }
 
@@ -42,44 +70,79 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 	return func(r chi.Router) {
 		r.Use(middleware.GetHead)
 		r.Use(ReqLogger(h.logger))
+		r.Use(Compress)
+		r.Use(JSONFeedSuffixMw)
 
 		workDir, _ := os.Getwd()
 		assetsDir := filepath.Join(workDir, "assets")
 		h.v.assets = assets.AssetFiles{
-			"moderate.css":     []string{"main.css", "listing.css", "article.css", "moderate.css", "user.css"},
-			"content.css":      []string{"main.css", "article.css", "content.css"},
-			"listing.css":      []string{"main.css", "listing.css", "article.css", "moderate.css"},
-			"moderation.css":   []string{"main.css", "listing.css", "article.css", "moderation.css"},
-			"user.css":         []string{"main.css", "listing.css", "article.css", "user.css"},
-			"user-message.css": []string{"main.css", "listing.css", "article.css", "user-message.css"},
-			"new.css":          []string{"main.css", "listing.css", "article.css"},
-			"404.css":          []string{"main.css", "error.css"},
-			"about.css":        []string{"main.css", "about.css"},
-			"error.css":        []string{"main.css", "error.css"},
-			"login.css":        []string{"main.css", "login.css"},
-			"register.css":     []string{"main.css", "login.css"},
-			"inline.css":       []string{"inline.css"},
-			"main.js":          []string{"base.js", "main.js"},
+			"moderate.css":      []string{"main.css", "listing.css", "article.css", "moderate.css", "user.css"},
+			"content.css":       []string{"main.css", "article.css", "content.css"},
+			"listing.css":       []string{"main.css", "listing.css", "article.css", "moderate.css"},
+			"moderation.css":    []string{"main.css", "listing.css", "article.css", "moderation.css"},
+			"user.css":          []string{"main.css", "listing.css", "article.css", "user.css"},
+			"user-message.css":  []string{"main.css", "listing.css", "article.css", "user-message.css"},
+			"new.css":           []string{"main.css", "listing.css", "article.css"},
+			"404.css":           []string{"main.css", "error.css"},
+			"page.css":          []string{"main.css", "page.css"},
+			"instances.css":     []string{"main.css", "instances.css"},
+			"error.css":         []string{"main.css", "error.css"},
+			"login.css":         []string{"main.css", "login.css"},
+			"register.css":      []string{"main.css", "login.css"},
+			"settings.css":      []string{"main.css", "login.css"},
+			"feedbots.css":      []string{"main.css", "login.css", "feedbots.css"},
+			"admin.css":         []string{"main.css", "feedbots.css"},
+			"reasons.css":       []string{"main.css", "login.css", "feedbots.css"},
+			"notes.css":         []string{"main.css", "login.css", "feedbots.css"},
+			"admin-notes.css":   []string{"main.css", "feedbots.css"},
+			"boards.css":        []string{"main.css", "login.css", "feedbots.css"},
+			"board-edit.css":    []string{"main.css", "login.css"},
+			"wiki.css":          []string{"main.css", "login.css", "wiki.css"},
+			"queue.css":         []string{"main.css", "feedbots.css"},
+			"notifications.css": []string{"main.css", "notifications.css"},
+			"search.css":        []string{"main.css", "search.css"},
+			"trending.css":      []string{"main.css", "trending.css"},
+			"stats.css":         []string{"main.css", "stats.css"},
+			"remove.css":        []string{"main.css", "article.css", "content.css", "login.css"},
+			"slow-mode.css":     []string{"main.css", "article.css", "content.css", "login.css"},
+			"reader.css":        []string{"main.css", "article.css", "content.css"},
+			"snapshot.css":      []string{"main.css", "article.css", "content.css"},
+			"translation.css":   []string{"main.css", "article.css", "content.css"},
+			"preview.css":       []string{"main.css", "article.css", "content.css"},
+			"history.css":       []string{"main.css", "article.css", "content.css"},
+			"inline.css":        []string{"inline.css"},
+			"main.js":           []string{"base.js", "main.js"},
 		}
 
 		r.Group(func(r chi.Router) {
 			//r.Use(middleware.Timeout(60 * time.Millisecond))
 			r.Use(h.SetSecurityHeaders)
+			r.Use(h.RequestTimeoutMw)
 			r.Use(h.LoadSession)
 			r.Use(h.OutOfOrderMw)
 
 			usersEnabledFn := func() (bool, string) {
+				if !h.Ready() {
+					return false, "Account creation is temporarily unavailable"
+				}
 				return c.UserCreatingEnabled, "Account creation is disabled"
 			}
 			usersInvitesFn := func() (bool, string) {
+				if !h.Ready() {
+					return false, "Account invites are temporarily unavailable"
+				}
 				return c.UserInvitesEnabled, "Account invites are disabled"
 			}
 			usersEnabledOrInvitesFn := func() (bool, string) {
-				return c.UserInvitesEnabled || c.UserCreatingEnabled, "Unable to create account"
+				return h.Ready() && (c.UserInvitesEnabled || c.UserCreatingEnabled), "Unable to create account"
 			}
 			r.With(h.CSRF).Group(func(r chi.Router) {
-				r.With(AddModelMw).Get("/submit", h.HandleShow)
+				r.With(AddModelMw, h.BoardSubmitMw).Get("/submit", h.HandleShow)
 				r.Post("/submit", h.HandleSubmit)
+				r.With(h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/preview", h.HandlePreview)
+				r.With(h.ValidateLoggedIn(h.v.HandleErrors)).Post("/api/preview", h.HandlePreviewAPI)
+				r.With(h.ValidateLoggedIn(h.v.HandleErrors)).Get("/submit/title", h.HandleSubmitTitle)
+				r.With(h.ValidateLoggedIn(h.v.HandleErrors)).Get("/complete/{kind}", h.HandleComplete)
 				r.Route("/register", func(r chi.Router) {
 					r.Group(func(r chi.Router) {
 						r.With(h.v.FailWithMessage(usersEnabledFn), ModelMw(&registerModel{Title: "Register new account"})).Get("/", h.HandleShow)
@@ -88,20 +151,47 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 					r.With(h.v.FailWithMessage(usersEnabledOrInvitesFn)).Post("/", h.HandleRegister)
 				})
 				r.With(h.NeedsSessions).Group(func(r chi.Router) {
-					r.With(ModelMw(&loginModel{Title: "Local authentication"})).Get("/login", h.HandleShow)
+					r.With(h.RememberReturnTo, ModelMw(&loginModel{Title: "Local authentication"})).Get("/login", h.HandleShow)
 					r.Post("/login", h.HandleLogin)
 				})
 			})
 
 			r.With(h.LoadAuthorMw).Route("/~{handle}", func(r chi.Router) {
-				r.With(AccountListingModelMw, AccountFiltersMw, LoadOutboxMw).Get("/", h.HandleShow)
+				r.With(h.AccountActivityPubMw, h.CSRF, AccountListingModelMw, FeedMw, AccountFiltersMw, LoadOutboxMw).Get("/", h.HandleShow)
+				r.Get("/avatar", h.HandleAvatar)
+				r.Get("/qr", h.HandleAccountQR)
 
 				r.Group(func(r chi.Router) {
 					r.Use(h.ValidateLoggedIn(h.v.RedirectToErrors))
 					r.Get("/follow", h.FollowAccount)
+					r.With(h.CSRF).Post("/follow", h.FollowAccount)
 					r.Get("/follow/{action}", h.HandleFollowRequest)
 					r.With(h.NeedsSessions, h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/invite", h.HandleCreateInvitation)
+					r.With(h.NeedsSessions, h.CSRF, h.ValidateAccountOwner).Route("/settings", func(r chi.Router) {
+						r.With(h.SettingsModelMw).Get("/", h.HandleShow)
+						r.Post("/handle", h.HandleRename)
+						r.Post("/name", h.HandleDisplayName)
+						r.Post("/bio", h.HandleBio)
+						r.Post("/email", h.HandleEmail)
+						r.Post("/fields", h.HandleProfileFields)
+						r.Post("/avatar", h.HandleAvatarLookup)
+						r.Post("/media", h.HandleMediaSettings)
+						r.Post("/theme", h.HandleThemeSettings)
+						r.Post("/bots", h.HandleBotSettings)
+						r.Post("/feeds", h.HandleFeedToken)
+						r.Post("/mail", h.HandleMailAddress)
+						r.Post("/digest", h.HandleDigestSettings)
+						r.Post("/push", h.HandlePushSettings)
+						r.Post("/channels/{kind}", h.HandleNotificationChannel)
+						r.Post("/quiet", h.HandleQuietHours)
+					})
 
+					r.With(h.CSRF, h.ValidateAccountOwner, ListingModelMw, SavedListingMw, LoadServiceInboxMw, SortByIndex).
+						Get("/saved", h.HandleShow)
+					r.With(h.CSRF, h.ValidateModerator).Route("/notes", func(r chi.Router) {
+						r.Get("/", h.HandleAccountNotes)
+						r.Post("/", h.HandleSaveAccountNote)
+					})
 					r.With(h.CSRF, MessageUserContentModelMw, MessageFiltersMw, LoadOutboxMw).Route("/message", func(r chi.Router) {
 						r.Get("/", h.HandleShow)
 						r.Post("/", h.HandleSubmit)
@@ -123,24 +213,104 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 			r.Get("/i/{hash}", h.HandleItemRedirect)
 
 			r.With(h.NeedsSessions).Get("/logout", h.HandleLogout)
+			r.With(h.NeedsSessions, h.ValidateLoggedIn(h.v.RedirectToErrors)).Group(func(r chi.Router) {
+				r.With(h.CSRF).Post("/logout/all", h.HandleLogoutEverywhere)
+				r.With(h.CSRF).Post("/t/{tag}/follow", h.FollowTag)
+				r.With(h.CSRF).Post("/t/{tag}/unfollow", h.UnfollowTag)
+				r.With(h.CSRF).Post("/b/{name}/subscribe", h.HandleBoardSubscription(true))
+				r.With(h.CSRF).Post("/b/{name}/unsubscribe", h.HandleBoardSubscription(false))
+				r.With(h.CSRF).Post("/boards", h.HandleCreateBoard)
+				r.With(h.CSRF).Get("/b/{name}/edit", h.HandleBoardEditForm)
+				r.With(h.CSRF).Post("/b/{name}/edit", h.HandleBoardEdit)
+				r.With(h.CSRF).Post("/b/{name}/ban", h.HandleBoardBan)
+				r.With(h.CSRF).Post("/b/{name}/wiki", h.HandleNewWikiPage)
+				r.With(h.CSRF).Get("/b/{name}/wiki/{slug}/edit", h.HandleWikiEditForm)
+				r.With(h.CSRF).Post("/b/{name}/wiki/{slug}/edit", h.HandleWikiEdit)
+				r.With(h.CSRF).Post("/b/{name}/wiki/{slug}/lock", h.HandleWikiLock)
+				r.Get("/settings", h.HandleSettings)
+				r.With(h.CSRF).Get("/notifications", h.HandleNotifications)
+				r.With(h.CSRF).Post("/notifications/read", h.HandleNotificationsRead(false))
+				r.With(h.CSRF).Post("/notifications/read-all", h.HandleNotificationsRead(true))
+				r.Get("/notifications/unread", h.HandleUnreadNotifications)
+			})
+			r.With(h.NeedsSessions, h.CSRF).Post("/banner/dismiss", h.HandleDismissBanner)
 
-			r.With(ListingModelMw).Group(func(r chi.Router) {
+			r.With(h.CSRF, ListingModelMw).Group(func(r chi.Router) {
 				// @todo(marius) :link_generation:
-				r.With(DefaultFilters, LoadServiceInboxMw, SortByScore).Get("/", h.HandleShow)
+				r.With(FeedMw, DefaultFilters, LoadServiceInboxMw, SortByScore).Get("/", h.HandleShow)
 				r.With(DomainFiltersMw, LoadServiceInboxMw, middleware.StripSlashes, SortByDate).Get("/d", h.HandleShow)
-				r.With(DomainFiltersMw, LoadServiceInboxMw, SortByDate).Get("/d/{domain}", h.HandleShow)
-				r.With(TagFiltersMw, LoadServiceInboxMw, ModerationListing, SortByDate).Get("/t/{tag}", h.HandleShow)
+				r.With(FeedMw, DomainFiltersMw, LoadServiceInboxMw, SortByDate).Get("/d/{domain}", h.HandleShow)
+				r.With(h.TagActivityPubMw, FeedMw, TagFiltersMw, LoadServiceInboxMw, ModerationListing, SortByDate).Get("/t/{tag}", h.HandleShow)
+				r.With(BoardFiltersMw, LoadServiceInboxMw, SortByScore, BoardPinsMw).Get("/b/{name}", h.HandleShow)
+				r.With(ItemKindFiltersMw(classifiedTag, "Classifieds"), LoadServiceInboxMw, KeepItemsMw(activeClassified), SortByDate).
+					Get("/classifieds", h.HandleShow)
+				r.With(PrefixFiltersMw("Ask", c.AskPrefixes), LoadServiceInboxMw, KeepItemsMw(hasTitlePrefix(c.AskPrefixes)),
+					SortByGravity(c.AskGravity)).Get("/ask", h.HandleShow)
+				r.With(PrefixFiltersMw("Show", c.ShowPrefixes), LoadServiceInboxMw, KeepItemsMw(hasTitlePrefix(c.ShowPrefixes)),
+					SortByGravity(c.ShowGravity)).Get("/show", h.HandleShow)
+				r.With(ItemKindFiltersMw(jobTag, "Jobs"), LoadServiceInboxMw, KeepItemsMw(activeJob), SortByDate).Get("/jobs", h.HandleShow)
 				r.With(SelfFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, SortByScore).Get("/self", h.HandleShow)
-				r.With(FederatedFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, SortByScore).Get("/federated", h.HandleShow)
-				r.With(h.NeedsSessions, FollowedFiltersMw, h.ValidateLoggedIn(h.v.RedirectToErrors), LoadInboxMw, SortByDate).
+				r.With(FederatedFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, HideLimitedInstancesMw, SortFromRequest).Get("/federated", h.HandleShow)
+				r.With(ActiveListingMw, LoadServiceInboxMw, SortByIndex).Get("/active", h.HandleShow)
+				r.With(ShortLinkFiltersMw, LoadServiceInboxMw, SortByDate).Get("/s/{short}", h.HandleShortLink)
+				r.With(h.TopWindowMw, TopListingMw, LoadServiceInboxMw, SortByIndex).Get("/top", h.HandleShow)
+				r.Route("/archive", func(r chi.Router) {
+					r.Use(h.ArchiveMw, ArchiveListingMw, LoadServiceInboxMw, SortByIndex)
+					r.Get("/", h.HandleShow)
+					r.Get("/{year}", h.HandleShow)
+					r.Get("/{year}/{month}", h.HandleShow)
+				})
+				r.With(h.NeedsSessions, FollowedFiltersMw, h.ValidateLoggedIn(h.RedirectToLogin), LoadInboxMw, SortByDate).
 					Get("/followed", h.HandleShow)
-				r.With(ModelMw(&listingModel{tpl: "moderation", sortFn: ByDate}), ModerationFiltersMw, LoadServiceWithSelfAuthInboxMw, ModerationListing).
-					Get("/moderation", h.HandleShow)
+				r.With(h.NeedsSessions, h.ValidateLoggedIn(h.RedirectToLogin), HomeFiltersMw, LoadServiceInboxMw, SortByDate).
+					Get("/home", h.HandleShow)
+				r.With(ModelMw(&listingModel{tpl: "moderation", sortFn: ByDate}), ModerationFiltersMw, LoadServiceWithSelfAuthInboxMw,
+					HideRestrictedReportsMw, ModerationListing).Get("/moderation", h.HandleShow)
 				r.With(ModelMw(&listingModel{tpl: "listing", sortFn: ByDate}), ActorsFiltersMw, LoadServiceInboxMw, ThreadedListingMw).
 					Get("/~", h.HandleShow)
 			})
 
+			r.Get("/theme/{kind}", h.HandleTheme)
+			r.Get("/theme/b/{name}", h.HandleBoardTheme)
 			r.Get("/about", h.HandleAbout)
+			r.With(h.CSRF).Get("/boards", h.HandleBoards)
+			r.With(h.CSRF).Get("/b/{name}/wiki", h.HandleWikiIndex)
+			r.With(h.CSRF).Get("/b/{name}/wiki/{slug}", h.HandleWikiPage)
+			r.Get("/b/{name}/wiki/{slug}/history", h.HandleWikiHistory)
+			r.Get("/random", h.HandleRandom)
+			r.Get("/search", h.HandleSearch)
+			r.Get("/trending", h.HandleTrending)
+			r.Get("/stats", h.HandleStats)
+			r.Get("/instances", h.HandleInstances)
+			r.Get("/api/v1/instance/peers", h.HandlePeers)
+			r.Get("/api/v1/badge", h.HandleBadge)
+			r.Post("/api/v1/mail", h.HandleMailGateway)
+			r.Get("/page/{slug}", h.HandlePage)
+			r.Get("/feed/{token}/{kind:replies|mentions}", h.HandleFeed)
+			r.Get("/digest/confirm/{token}", h.HandleDigestConfirm)
+			r.Get("/digest/unsubscribe/{token}", h.HandleDigestUnsubscribe)
+			r.Post("/digest/unsubscribe/{token}", h.HandleDigestUnsubscribe)
+			r.With(h.CSRF, h.ValidateModerator).Route("/moderation/queue", func(r chi.Router) {
+				r.Get("/", h.HandleModerationQueue)
+				r.Post("/", h.HandleModerateQueue)
+				r.Post("/evasion/{hash}", h.HandleDismissEvasion)
+			})
+			r.With(h.ValidateLoggedIn(h.v.RedirectToErrors), h.ValidateModerator).Route("/admin", func(r chi.Router) {
+				r.Get("/", h.HandleAdmin)
+				r.With(ModelMw(&listingModel{tpl: "moderation", sortFn: ByDate}), ModerationFiltersMw,
+					LoadServiceWithSelfAuthInboxMw, RestrictedReportsMw, ModerationListing).Get("/reports", h.HandleShow)
+				r.Get("/notes", h.HandleAdminNotes)
+				r.With(h.CSRF).Route("/reasons", func(r chi.Router) {
+					r.Get("/", h.HandleRemovalReasons)
+					r.Post("/", h.HandleAddRemovalReason)
+					r.Post("/rm", h.HandleRemoveRemovalReason)
+				})
+				r.With(h.CSRF).Route("/feeds", func(r chi.Router) {
+					r.Get("/", h.HandleFeedBots)
+					r.Post("/", h.HandleAddFeedBot)
+					r.Post("/rm", h.HandleRemoveFeedBot)
+				})
+			})
 			r.Route("/auth", func(r chi.Router) {
 				r.Use(h.NeedsSessions)
 				r.Get("/{provider}/callback", h.HandleCallback)
@@ -159,6 +329,9 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 			r.Get("/favicon.ico", assets.ServeStatic(filepath.Join(assetsDir, "/favicon.ico")))
 			r.Get("/icons.svg", assets.ServeStatic(filepath.Join(assetsDir, "/icons.svg")))
 			r.Get("/robots.txt", assets.ServeStatic(filepath.Join(assetsDir, "/robots.txt")))
+			// NOTE(marius): the service worker needs to be served from the root, so it can show the notifications of all the pages
+			r.Get("/sw.js", assets.ServeStatic(filepath.Join(assetsDir, "/js/sw.js")))
+			r.With(LocalOnly).Get("/debug/vars", expvar.Handler().ServeHTTP)
 			r.Get("/css/{path}", assets.ServeAsset(h.v.assets))
 			r.Get("/js/{path}", assets.ServeAsset(h.v.assets))
 		})
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// SavedItem is an item, or a comment, the account saved for later, and the Add activity which saved it
+type SavedItem struct {
+	Hash    Hash
+	IRI     pub.IRI
+	Add     pub.IRI
+	SavedAt time.Time
+}
+
+// SavedItems are the items the account saved, newest first
+type SavedItems []SavedItem
+
+// Get returns the saved item with the hash
+func (s SavedItems) Get(h Hash) (SavedItem, bool) {
+	for _, it := range s {
+		if it.Hash == h {
+			return it, true
+		}
+	}
+	return SavedItem{}, false
+}
+
+func (s SavedItems) Contains(h Hash) bool {
+	_, ok := s.Get(h)
+	return ok
+}
+
+// load appends the item the Add activity saved to savedCol, unless it was undone, and returns if the activity
+// was one saving an item
+func (s SavedItems) load(a *pub.Activity, savedCol pub.IRI, undone pub.IRIs) (SavedItems, bool) {
+	if a == nil || a.Type != pub.AddType || a.Object == nil || a.Target == nil || !a.Target.GetLink().Equals(savedCol, false) {
+		return s, false
+	}
+	if undone.Contains(a.GetLink()) {
+		return s, true
+	}
+	it := SavedItem{
+		Hash:    HashFromIRI(a.Object.GetLink()),
+		IRI:     a.Object.GetLink(),
+		Add:     a.GetLink(),
+		SavedAt: a.Published,
+	}
+	if !s.Contains(it.Hash) {
+		s = append(s, it)
+	}
+	return s, true
+}
+
+// savedCollection is the collection of the account's saved items, the Add activities targeting it
+// are addressed only to the account, so they stay private.
+func savedCollection(actor pub.IRI) pub.IRI {
+	return actor.AddPath("saved")
+}
+
+// SaveForLater creates an Add activity of the item to the saved collection of the account
+func (r *repository) SaveForLater(ctx context.Context, er Account, it Item) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	if !it.HasMetadata() || len(it.Metadata.ID) == 0 {
+		return errors.NotFoundf("invalid item %s", it.Hash)
+	}
+	actor := r.loadAPPerson(er)
+
+	add := new(pub.Activity)
+	add.Type = pub.AddType
+	add.To = pub.ItemCollection{actor.GetLink()}
+	add.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	add.Object = pub.IRI(it.Metadata.ID)
+	add.Target = savedCollection(actor.GetLink())
+	add.Actor = actor.GetLink()
+	if _, _, err := r.fedbox.ToOutbox(ctx, add); err != nil {
+		r.errFn(log.Ctx{
+			"err":     err,
+			"account": er.Handle,
+			"item":    it.Hash,
+		})("Unable to save item")
+		return err
+	}
+	r.snaps.Remove(&er)
+	return nil
+}
+
+// RemoveSaved undoes the Add activity that er has created for the saved item
+func (r *repository) RemoveSaved(ctx context.Context, er Account, s SavedItem) error {
+	if !accountValidForC2S(&er) {
+		return errors.Unauthorizedf("invalid account %s", er.Handle)
+	}
+	if len(s.Add) == 0 {
+		return errors.NotFoundf("item %s is not saved", s.Hash)
+	}
+	actor := r.loadAPPerson(er)
+
+	undo := new(pub.Activity)
+	undo.Type = pub.UndoType
+	undo.To = pub.ItemCollection{actor.GetLink()}
+	undo.BCC = pub.ItemCollection{r.fedbox.Service().ID}
+	undo.Object = s.Add
+	undo.Actor = actor.GetLink()
+	if _, _, err := r.fedbox.ToOutbox(ctx, undo); err != nil {
+		r.errFn(log.Ctx{
+			"err":     err,
+			"account": er.Handle,
+			"item":    s.Hash,
+		})("Unable to remove saved item")
+		return err
+	}
+	r.snaps.Remove(&er)
+	return nil
+}
+
+// HandleSaveItem handles the POST requests to the /save and /unsave paths of the items
+func (h *handler) HandleSaveItem(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	iri := objects.IRI(h.storage.fedbox.Service()).AddPath(chi.URLParam(r, "hash"))
+	it, err := h.storage.LoadItem(r.Context(), iri)
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
+		return
+	}
+	url := ItemPermaLink(&it)
+	if back := r.Header.Get("Referer"); !strings.Contains(back, url) && strings.Contains(back, Instance.BaseURL) {
+		url = fmt.Sprintf("%s#li-%s", back, it.Hash)
+	}
+	if strings.HasSuffix(r.URL.Path, "/unsave") {
+		if saved, ok := acc.Saved.Get(it.Hash); ok {
+			err = h.storage.RemoveSaved(r.Context(), *acc, saved)
+		}
+	} else if !acc.Saved.Contains(it.Hash) {
+		err = h.storage.SaveForLater(r.Context(), *acc, it)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to save the item")
+		h.v.addFlashMessage(Error, w, r, "Unable to save the item")
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// SavedListingMw selects a page of the items the account saved, in the order they were saved
+func SavedListingMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		saved := loggedAccount(r).Saved
+		all := make([]indexEntry, 0, len(saved))
+		for _, s := range saved {
+			all = append(all, indexEntry{Hash: s.Hash})
+		}
+		page, after, before := pageEntries(all, HashFromString(q.Get("after")), HashFromString(q.Get("before")), MaxContentItems)
+
+		if m := ContextListingModel(r.Context()); m != nil {
+			m.Title = "Saved items"
+			m.ShowText = true
+		}
+		ctx := context.WithValue(r.Context(), FilterCtxtKey, entriesFilters(page))
+		ctx = context.WithValue(ctx, IndexPageCtxtKey, &indexPage{entries: page, after: after, before: before})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestSavedItemsLoad(t *testing.T) {
+	actor := pub.IRI("https://example.com/actors/jane")
+	col := savedCollection(actor)
+	add := func(id, object string) *pub.Activity {
+		return &pub.Activity{
+			ID:     pub.IRI("https://example.com/activities/" + id),
+			Type:   pub.AddType,
+			Actor:  actor,
+			Object: pub.IRI("https://example.com/objects/" + object),
+			Target: col,
+		}
+	}
+	first := add("1", "2b7c8f6e-0c1d-4d5e-8f9a-1b2c3d4e5f60")
+	second := add("2", "3c8d9a7f-1d2e-4e6f-9a0b-2c3d4e5f6a71")
+	unsave := &pub.Activity{ID: "https://example.com/activities/3", Type: pub.UndoType, Actor: actor, Object: first.GetLink()}
+
+	// NOTE(marius): the outbox is ordered newest first, so the Undo activity comes before the Add it reverts
+	saved := make(SavedItems, 0)
+	undone := make(pub.IRIs, 0)
+	for _, a := range []*pub.Activity{unsave, second, first} {
+		if a.Type == pub.UndoType {
+			undone = append(undone, a.Object.GetLink())
+		}
+		var isSave bool
+		saved, isSave = saved.load(a, col, undone)
+		if isSave != (a.Type == pub.AddType) {
+			t.Errorf("expected only the Add activities to be saving items, got %t for %s", isSave, a.Type)
+		}
+	}
+	if len(saved) != 1 || !saved.Contains(HashFromIRI(second.Object.GetLink())) {
+		t.Fatalf("expected only the item which wasn't unsaved, got %v", saved)
+	}
+	if s, _ := saved.Get(HashFromIRI(second.Object.GetLink())); s.Add != second.GetLink() {
+		t.Errorf("expected the Add activity %s to be kept for unsaving the item, got %s", second.GetLink(), s.Add)
+	}
+
+	other := add("4", "4d9e0b8a-2e3f-4f7a-8b1c-3d4e5f6a7b82")
+	other.Target = pub.IRI("https://example.com/actors/jane/featured")
+	noObject := add("5", "")
+	noObject.Object = nil
+	for _, a := range []*pub.Activity{other, noObject, nil} {
+		if res, isSave := saved.load(a, col, undone); isSave || len(res) != 1 {
+			t.Errorf("expected the activity to be ignored, got %t %v", isSave, res)
+		}
+	}
+}
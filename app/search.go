@@ -0,0 +1,817 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	stdhtml "html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	SearchBleve         = "bleve"
+	SearchLocal         = "local"
+	SearchElasticsearch = "elasticsearch"
+	SearchMeilisearch   = "meilisearch"
+
+	searchIndexFile     = "search-index.json.gz"
+	searchQueueSize     = 1000
+	searchBatchSize     = 200
+	searchFlushInterval = 5 * time.Second
+	searchTimeOut       = 15 * time.Second
+	searchMaxResults    = 50
+	searchExcerptLen    = 300
+	// searchPageSize is the number of results on a page of the search, searchMaxPage is the last page we show
+	searchPageSize = 25
+	searchMaxPage  = 40
+	// searchReindexPage is how many objects the reindex command loads from fedbox at once
+	searchReindexPage = 100
+)
+
+// searchDocument is what we index about an item, the drivers store it as it is, so the field names
+// are part of the format of their indexes
+type searchDocument struct {
+	ID          string    `json:"id"`
+	IRI         string    `json:"iri"`
+	OP          string    `json:"op,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Domain      string    `json:"domain,omitempty"`
+	Board       string    `json:"board,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Self        bool      `json:"self"`
+	Local       bool      `json:"local"`
+	SubmittedAt time.Time `json:"submittedAt"`
+	Published   int64     `json:"published"`
+	public      bool
+	deleted     bool
+}
+
+func searchDocumentFromItem(it Item) searchDocument {
+	e := indexEntryFromItem(it)
+	d := searchDocument{
+		ID:          it.Hash.String(),
+		IRI:         e.IRI.String(),
+		Author:      e.Handle,
+		Title:       it.Title,
+		Domain:      e.Domain,
+		Board:       e.Board,
+		Tags:        e.Tags,
+		Self:        it.IsSelf(),
+		Local:       HostIsLocal(e.IRI.String()),
+		SubmittedAt: it.SubmittedAt.UTC(),
+		Published:   it.SubmittedAt.Unix(),
+		public:      e.Public,
+		deleted:     e.Deleted,
+	}
+	if e.OP.IsValid() {
+		d.OP = e.OP.String()
+	}
+	if it.Parent != nil && it.Parent.Hash.IsValid() {
+		d.Parent = it.Parent.Hash.String()
+	}
+	if it.IsLink() {
+		d.URL = it.Data
+	} else {
+		d.Content = strings.TrimSpace(stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(it.Data)))
+	}
+	return d
+}
+
+// fingerprint changes when the indexed content of the document does
+func (d searchDocument) fingerprint() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%t\x00%t", d.Title, d.Content, d.Author, d.Board, strings.Join(d.Tags, ","), d.public, d.deleted)
+	return h.Sum64()
+}
+
+// Link returns the local link of the item
+func (d searchDocument) Link() string {
+	if len(d.Author) == 0 || d.Author == Anonymous {
+		return path.Join("/", d.SubmittedAt.Format("2006/01/02"), d.ID)
+	}
+	return path.Join("/~"+d.Author, d.ID)
+}
+
+// Excerpt returns the beginning of the content, the comments don't have a title
+func (d searchDocument) Excerpt() string {
+	if r := []rune(d.Content); len(r) > searchExcerptLen {
+		return string(r[:searchExcerptLen]) + "…"
+	}
+	return d.Content
+}
+
+// searchQuery is what the search drivers need to find the documents, all the conditions need to match.
+// Expr is the parsed search syntax, Text are its words and phrases for the drivers which only search for text.
+type searchQuery struct {
+	Expr   *searchExpr
+	Text   string
+	Author string
+	Domain string
+	Tag    string
+	Board  string
+	After  time.Time
+	Before time.Time
+	Max    int
+	Offset int
+}
+
+func (q searchQuery) max() int {
+	if q.Max <= 0 || q.Max > searchMaxResults {
+		return searchMaxResults
+	}
+	return q.Max
+}
+
+// matches checks the conditions of the query, for the drivers which filter the documents themselves
+func (q searchQuery) matches(d searchDocument) bool {
+	if len(q.Author) > 0 && !strings.EqualFold(d.Author, q.Author) {
+		return false
+	}
+	if len(q.Domain) > 0 && !strings.EqualFold(d.Domain, q.Domain) && !strings.HasSuffix(strings.ToLower(d.Domain), "."+strings.ToLower(q.Domain)) {
+		return false
+	}
+	if len(q.Tag) > 0 && !stringInSlice(d.Tags)(strings.ToLower(strings.TrimLeft(q.Tag, "#"))) {
+		return false
+	}
+	if len(q.Board) > 0 && !strings.EqualFold(d.Board, q.Board) {
+		return false
+	}
+	if !q.After.IsZero() && !d.SubmittedAt.After(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !d.SubmittedAt.Before(q.Before) {
+		return false
+	}
+	return q.Expr.Match(d)
+}
+
+type searchHit struct {
+	Document searchDocument
+	Score    float64
+	// Title and Snippet are the title, and the part of the content, with the words of the query highlighted
+	Title   []snippetPart
+	Snippet []snippetPart
+	// Matched are the parts of the item where the words were found: the title, the text or the comment
+	Matched []string
+}
+
+// searchIndex is a full text index of the items, the built-in ones, Bleve and the simpler local one, are kept
+// in the data directory, the others are external services
+type searchIndex interface {
+	Name() string
+	Index(ctx context.Context, docs ...searchDocument) error
+	Remove(ctx context.Context, ids ...string) error
+	Search(ctx context.Context, q searchQuery) ([]searchHit, error)
+}
+
+// newSearchIndex returns the search index for the backend from the configuration, or nil if search is disabled
+func newSearchIndex(c config.Configuration, dataPath string) (searchIndex, error) {
+	switch strings.ToLower(c.SearchBackend) {
+	case "", SearchBleve:
+		return loadBleveSearch(dataPath)
+	case SearchLocal:
+		return loadLocalSearch(dataPath)
+	case SearchElasticsearch:
+		if len(c.SearchURL) == 0 {
+			return nil, errors.Errorf("SEARCH_URL is needed for %s", c.SearchBackend)
+		}
+		return &elasticSearch{url: strings.TrimRight(c.SearchURL, "/"), index: c.SearchIndex, key: c.SearchAPIKey}, nil
+	case SearchMeilisearch:
+		if len(c.SearchURL) == 0 {
+			return nil, errors.Errorf("SEARCH_URL is needed for %s", c.SearchBackend)
+		}
+		return &meiliSearch{url: strings.TrimRight(c.SearchURL, "/"), index: c.SearchIndex, key: c.SearchAPIKey}, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, errors.Errorf("unknown search backend %q", c.SearchBackend)
+}
+
+// searchIndexer updates the search index with the items we load from fedbox, the inbox processing included.
+// The documents are sent to the index in batches, and only when their content changed.
+type searchIndexer struct {
+	m     sync.Mutex
+	index searchIndex
+	queue chan searchDocument
+	seen  map[string]uint64
+	errFn CtxLogFn
+}
+
+func newSearchIndexer(idx searchIndex, errFn CtxLogFn) *searchIndexer {
+	if idx == nil {
+		return nil
+	}
+	return &searchIndexer{index: idx, queue: make(chan searchDocument, searchQueueSize), seen: make(map[string]uint64), errFn: errFn}
+}
+
+// Enabled returns if we have a search index
+func (s *searchIndexer) Enabled() bool {
+	return s != nil && s.index != nil
+}
+
+// changed returns if the document needs to be sent to the index, and remembers it was
+func (s *searchIndexer) changed(d searchDocument) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	fp := d.fingerprint()
+	if s.seen[d.ID] == fp {
+		return false
+	}
+	s.seen[d.ID] = fp
+	return true
+}
+
+func (s *searchIndexer) forget(docs ...searchDocument) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, d := range docs {
+		delete(s.seen, d.ID)
+	}
+}
+
+// Queue schedules the indexing of the items, the private ones are never indexed
+func (s *searchIndexer) Queue(items ...Item) {
+	if !s.Enabled() {
+		return
+	}
+	for _, it := range items {
+		if !it.Hash.IsValid() {
+			continue
+		}
+		d := searchDocumentFromItem(it)
+		if !d.public || !s.changed(d) {
+			continue
+		}
+		select {
+		case s.queue <- d:
+		default:
+			// NOTE(marius): the queue is full, the item gets indexed the next time we load it, or by the reindex command
+			s.forget(d)
+		}
+	}
+}
+
+// Search returns the documents matching the query, with the words of the query highlighted
+func (s *searchIndexer) Search(ctx context.Context, q searchQuery) ([]searchHit, error) {
+	if !s.Enabled() {
+		return nil, errors.NotFoundf("search is disabled")
+	}
+	hits, err := s.index.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	words := q.Expr.words()
+	for i := range hits {
+		hits[i].highlight(words)
+	}
+	return hits, nil
+}
+
+// flush sends the documents to the index, the deleted ones are removed from it
+func (s *searchIndexer) flush(docs []searchDocument) error {
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeOut)
+	defer cancel()
+	toIndex := make([]searchDocument, 0, len(docs))
+	toRemove := make([]string, 0)
+	for _, d := range docs {
+		if d.deleted {
+			toRemove = append(toRemove, d.ID)
+		} else {
+			toIndex = append(toIndex, d)
+		}
+	}
+	var err error
+	if len(toIndex) > 0 {
+		err = s.index.Index(ctx, toIndex...)
+	}
+	if len(toRemove) > 0 {
+		if e := s.index.Remove(ctx, toRemove...); e != nil {
+			err = e
+		}
+	}
+	if f, ok := s.index.(interface{ Flush() error }); ok {
+		if e := f.Flush(); e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		// NOTE(marius): we forget them, so they're retried when they're loaded again
+		s.forget(docs...)
+	}
+	return err
+}
+
+// run indexes the queued documents, at most every searchFlushInterval
+func (s *searchIndexer) run() {
+	if !s.Enabled() {
+		return
+	}
+	t := time.NewTicker(searchFlushInterval)
+	defer t.Stop()
+	batch := make([]searchDocument, 0, searchBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.flush(batch); err != nil {
+			s.errFn(log.Ctx{"err": err, "index": s.index.Name(), "count": len(batch)})("unable to update the search index")
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case d := <-s.queue:
+			if batch = append(batch, d); len(batch) >= searchBatchSize {
+				send()
+			}
+		case <-t.C:
+			send()
+		}
+	}
+}
+
+type searchModel struct {
+	Title   string
+	Query   string
+	Enabled bool
+	Error   string
+	Results []searchHit
+	// Author, Tag, Domain, After and Before are the filters of the form, they narrow the results of the query
+	Author string
+	Tag    string
+	Domain string
+	After  string
+	Before string
+	Page   int
+	More   bool
+}
+
+func (m *searchModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (searchModel) Template() string {
+	return "search"
+}
+
+// Filtered returns if any of the filters of the form are set
+func (m searchModel) Filtered() bool {
+	return len(m.Author)+len(m.Tag)+len(m.Domain)+len(m.After)+len(m.Before) > 0
+}
+
+// pageLink returns the link to another page of the results, with the same query and filters
+func (m searchModel) pageLink(page int) string {
+	q := url.Values{}
+	set := func(k, v string) {
+		if len(v) > 0 {
+			q.Set(k, v)
+		}
+	}
+	set("q", m.Query)
+	set("author", m.Author)
+	set("tag", m.Tag)
+	set("domain", m.Domain)
+	set("after", m.After)
+	set("before", m.Before)
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return "/search?" + q.Encode()
+}
+
+// PrevLink returns the link to the previous page of the results, if there is one
+func (m searchModel) PrevLink() string {
+	if m.Page <= 1 {
+		return ""
+	}
+	return m.pageLink(m.Page - 1)
+}
+
+// NextLink returns the link to the next page of the results, if there is one
+func (m searchModel) NextLink() string {
+	if !m.More {
+		return ""
+	}
+	return m.pageLink(m.Page + 1)
+}
+
+// searchModelFromRequest loads the query, the filters and the page from the URL parameters
+func searchModelFromRequest(r *http.Request) *searchModel {
+	v := r.URL.Query()
+	param := func(k string) string {
+		return strings.TrimSpace(v.Get(k))
+	}
+	m := &searchModel{
+		Title:  "Search",
+		Query:  param("q"),
+		Author: strings.TrimLeft(param("author"), "@~"),
+		Tag:    strings.TrimLeft(param("tag"), "#"),
+		Domain: param("domain"),
+		After:  param("after"),
+		Before: param("before"),
+		Page:   1,
+	}
+	if p, err := strconv.Atoi(param("page")); err == nil && p > 1 {
+		m.Page = p
+		if m.Page > searchMaxPage {
+			m.Page = searchMaxPage
+		}
+	}
+	return m
+}
+
+// query parses the search syntax of the query, and adds the filters of the form to it
+func (m searchModel) query(now time.Time) (searchQuery, error) {
+	q, err := parseSearchQuery(m.Query, now)
+	if err != nil {
+		return q, err
+	}
+	if len(m.Author) > 0 {
+		q.Author = m.Author
+	}
+	if len(m.Tag) > 0 {
+		q.Tag = m.Tag
+	}
+	if len(m.Domain) > 0 {
+		q.Domain = strings.ToLower(m.Domain)
+	}
+	if len(m.After) > 0 {
+		if q.After, err = parseSearchDate(m.After, now); err != nil {
+			return q, err
+		}
+	}
+	if len(m.Before) > 0 {
+		if q.Before, err = parseSearchDate(m.Before, now); err != nil {
+			return q, err
+		}
+	}
+	// NOTE(marius): we ask for one more result than we show, to know if there is a next page
+	q.Offset = (m.Page - 1) * searchPageSize
+	q.Max = searchPageSize + 1
+	return q, nil
+}
+
+// HandleSearch serves the /search page, the q parameter is parsed with the search syntax, and the author, tag,
+// domain, after and before parameters filter its results
+func (h *handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	m := searchModelFromRequest(r)
+	m.Enabled = h.storage.search.Enabled()
+	if m.Enabled && (len(m.Query) > 0 || m.Filtered()) {
+		m.Title = "Search results"
+		if len(m.Query) > 0 {
+			m.Title = fmt.Sprintf("Search for %s", m.Query)
+		}
+		if m.Page > 1 {
+			m.Title = fmt.Sprintf("%s, page %d", m.Title, m.Page)
+		}
+		q, err := m.query(time.Now().UTC())
+		if err != nil {
+			m.Error = err.Error()
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), searchTimeOut)
+			defer cancel()
+			if m.Results, err = h.storage.search.Search(ctx, q); err != nil {
+				h.errFn(log.Ctx{"err": err, "q": m.Query})("unable to search")
+				m.Error = "The search failed, please try again later."
+			}
+			if len(m.Results) > searchPageSize {
+				m.Results = m.Results[:searchPageSize]
+				m.More = m.Page < searchMaxPage
+			}
+		}
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// reindex sends all the objects stored in fedbox to the search index
+func (r *repository) reindex(ctx context.Context, progressFn func(int)) (int, error) {
+	if !r.search.Enabled() {
+		return 0, errors.Errorf("search is disabled")
+	}
+	f := &Filters{Type: ActivityTypesFilter(ValidContentTypes...), MaxItems: searchReindexPage}
+	total, prev := 0, ""
+	for {
+		items, err := r.objects(ctx, f)
+		if err != nil {
+			return total, err
+		}
+		docs := make([]searchDocument, 0, len(items))
+		for _, it := range items {
+			if d := searchDocumentFromItem(it); it.Hash.IsValid() && d.public {
+				docs = append(docs, d)
+			}
+		}
+		if err := r.search.flush(docs); err != nil {
+			return total, err
+		}
+		total += len(docs)
+		if progressFn != nil {
+			progressFn(total)
+		}
+		if len(items) == 0 || len(f.Next) == 0 || f.Next == prev {
+			break
+		}
+		prev = f.Next
+	}
+	return total, nil
+}
+
+// Reindex rebuilds the search index from the objects stored in fedbox. It's used by the reindex command, and with
+// the built-in index it needs to run while the application is stopped, as they'd overwrite each other's index.
+func Reindex(ctx context.Context, c *config.Configuration, host string, port int, ver string) (int, error) {
+	a := Application{Version: ver}
+	a.configure(c, host, port)
+	repo, err := ActivityPubService(appConfig{Configuration: *c, BaseURL: a.BaseURL, SessionKeys: loadEnvSessionKeys(), Logger: a.Logger.New(log.Ctx{"package": "reindex"})})
+	if repo == nil || repo.fedbox == nil {
+		return 0, errors.Annotatef(err, "unable to load the ActivityPub service")
+	}
+	if err := repo.fedbox.loadService(ctx); err != nil {
+		return 0, errors.Annotatef(err, "unable to load the fedbox service")
+	}
+	return repo.reindex(ctx, func(cnt int) {
+		a.Logger.WithContext(log.Ctx{"count": cnt}).Info("indexed")
+	})
+}
+
+func doSearchRequest(ctx context.Context, method, u string, body interface{}, headers map[string]string, res interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if b, ok := body.([]byte); ok {
+			buf.Write(b)
+		} else if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("invalid response from the search service: %s", resp.Status)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// elasticSearch keeps the documents in an Elasticsearch, or OpenSearch, index
+type elasticSearch struct {
+	once  sync.Once
+	url   string
+	index string
+	key   string
+}
+
+func (e *elasticSearch) Name() string {
+	return "Elasticsearch"
+}
+
+func (e *elasticSearch) headers() map[string]string {
+	if len(e.key) == 0 {
+		return nil
+	}
+	return map[string]string{"Authorization": "ApiKey " + e.key}
+}
+
+// init creates the index, with keyword fields for the filters
+func (e *elasticSearch) init(ctx context.Context) {
+	e.once.Do(func() {
+		keyword := map[string]string{"type": "keyword"}
+		mapping := map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"id": keyword, "iri": keyword, "op": keyword, "parent": keyword, "url": keyword,
+					"author":      map[string]string{"type": "keyword", "normalizer": "lowercase"},
+					"domain":      map[string]string{"type": "keyword", "normalizer": "lowercase"},
+					"board":       map[string]string{"type": "keyword", "normalizer": "lowercase"},
+					"tags":        keyword,
+					"title":       map[string]string{"type": "text"},
+					"content":     map[string]string{"type": "text"},
+					"submittedAt": map[string]string{"type": "date"},
+				},
+			},
+			"settings": map[string]interface{}{
+				"analysis": map[string]interface{}{
+					"normalizer": map[string]interface{}{
+						"lowercase": map[string]interface{}{"type": "custom", "filter": []string{"lowercase"}},
+					},
+				},
+			},
+		}
+		// NOTE(marius): this fails when the index exists already, which is fine
+		doSearchRequest(ctx, http.MethodPut, e.url+"/"+url.PathEscape(e.index), mapping, e.headers(), nil)
+	})
+}
+
+func (e *elasticSearch) bulk(ctx context.Context, lines []interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, l := range lines {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	res := struct {
+		Errors bool `json:"errors"`
+	}{}
+	if err := doSearchRequest(ctx, http.MethodPost, e.url+"/"+url.PathEscape(e.index)+"/_bulk", buf.Bytes(), e.headers(), &res); err != nil {
+		return err
+	}
+	if res.Errors {
+		return errors.Errorf("some of the documents failed to be updated")
+	}
+	return nil
+}
+
+func (e *elasticSearch) Index(ctx context.Context, docs ...searchDocument) error {
+	e.init(ctx)
+	lines := make([]interface{}, 0, 2*len(docs))
+	for _, d := range docs {
+		lines = append(lines, map[string]interface{}{"index": map[string]string{"_id": d.ID}}, d)
+	}
+	return e.bulk(ctx, lines)
+}
+
+func (e *elasticSearch) Remove(ctx context.Context, ids ...string) error {
+	e.init(ctx)
+	lines := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, map[string]interface{}{"delete": map[string]string{"_id": id}})
+	}
+	return e.bulk(ctx, lines)
+}
+
+func (e *elasticSearch) Search(ctx context.Context, q searchQuery) ([]searchHit, error) {
+	e.init(ctx)
+	must := make([]interface{}, 0)
+	if q.Expr != nil {
+		must = append(must, q.Expr.elastic())
+	}
+	filter := make([]interface{}, 0)
+	term := func(field, value string) {
+		if len(value) > 0 {
+			filter = append(filter, map[string]interface{}{"term": map[string]string{field: value}})
+		}
+	}
+	term("author", q.Author)
+	term("domain", q.Domain)
+	term("tags", strings.ToLower(strings.TrimLeft(q.Tag, "#")))
+	term("board", q.Board)
+	if !q.After.IsZero() || !q.Before.IsZero() {
+		rng := make(map[string]string)
+		if !q.After.IsZero() {
+			rng["gt"] = q.After.UTC().Format(time.RFC3339)
+		}
+		if !q.Before.IsZero() {
+			rng["lt"] = q.Before.UTC().Format(time.RFC3339)
+		}
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{"submittedAt": rng}})
+	}
+	body := map[string]interface{}{
+		"from":  q.Offset,
+		"size":  q.max(),
+		"query": map[string]interface{}{"bool": map[string]interface{}{"must": must, "filter": filter}},
+		"sort":  []interface{}{"_score", map[string]string{"submittedAt": "desc"}},
+	}
+	res := struct {
+		Hits struct {
+			Hits []struct {
+				Score  float64        `json:"_score"`
+				Source searchDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	if err := doSearchRequest(ctx, http.MethodPost, e.url+"/"+url.PathEscape(e.index)+"/_search", body, e.headers(), &res); err != nil {
+		return nil, err
+	}
+	hits := make([]searchHit, 0, len(res.Hits.Hits))
+	for _, h := range res.Hits.Hits {
+		hits = append(hits, searchHit{Document: h.Source, Score: h.Score})
+	}
+	return hits, nil
+}
+
+// meiliSearch keeps the documents in a Meilisearch index
+type meiliSearch struct {
+	once  sync.Once
+	url   string
+	index string
+	key   string
+}
+
+func (m *meiliSearch) Name() string {
+	return "Meilisearch"
+}
+
+func (m *meiliSearch) headers() map[string]string {
+	if len(m.key) == 0 {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + m.key}
+}
+
+func (m *meiliSearch) indexURL() string {
+	return m.url + "/indexes/" + url.PathEscape(m.index)
+}
+
+// init sets up the attributes of the index, Meilisearch creates the index itself with the first documents
+func (m *meiliSearch) init(ctx context.Context) {
+	m.once.Do(func() {
+		settings := map[string]interface{}{
+			"searchableAttributes": []string{"title", "content"},
+			"filterableAttributes": []string{"author", "domain", "tags", "board", "published", "self", "local"},
+			"sortableAttributes":   []string{"published"},
+		}
+		doSearchRequest(ctx, http.MethodPatch, m.indexURL()+"/settings", settings, m.headers(), nil)
+	})
+}
+
+func (m *meiliSearch) Index(ctx context.Context, docs ...searchDocument) error {
+	m.init(ctx)
+	return doSearchRequest(ctx, http.MethodPost, m.indexURL()+"/documents?primaryKey=id", docs, m.headers(), nil)
+}
+
+func (m *meiliSearch) Remove(ctx context.Context, ids ...string) error {
+	m.init(ctx)
+	return doSearchRequest(ctx, http.MethodPost, m.indexURL()+"/documents/delete-batch", ids, m.headers(), nil)
+}
+
+func meiliFilterValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (m *meiliSearch) Search(ctx context.Context, q searchQuery) ([]searchHit, error) {
+	m.init(ctx)
+	filter := make([]string, 0)
+	eq := func(field, value string) {
+		if len(value) > 0 {
+			filter = append(filter, field+" = "+meiliFilterValue(value))
+		}
+	}
+	eq("author", q.Author)
+	eq("domain", q.Domain)
+	eq("tags", strings.ToLower(strings.TrimLeft(q.Tag, "#")))
+	eq("board", q.Board)
+	if !q.After.IsZero() {
+		filter = append(filter, fmt.Sprintf("published > %d", q.After.Unix()))
+	}
+	if !q.Before.IsZero() {
+		filter = append(filter, fmt.Sprintf("published < %d", q.Before.Unix()))
+	}
+	body := map[string]interface{}{
+		"q":                q.Text,
+		"offset":           q.Offset,
+		"limit":            q.max(),
+		"filter":           strings.Join(filter, " AND "),
+		"showRankingScore": true,
+		// NOTE(marius): Meilisearch doesn't have OR and NOT for the words, so we ask for the documents matching
+		// some of them, and we check the expression ourselves
+		"matchingStrategy": "last",
+	}
+	if len(q.Text) == 0 {
+		body["sort"] = []string{"published:desc"}
+	}
+	res := struct {
+		Hits []struct {
+			searchDocument
+			Score float64 `json:"_rankingScore"`
+		} `json:"hits"`
+	}{}
+	if err := doSearchRequest(ctx, http.MethodPost, m.indexURL()+"/search", body, m.headers(), &res); err != nil {
+		return nil, err
+	}
+	hits := make([]searchHit, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		if q.Expr.Match(h.searchDocument) {
+			hits = append(hits, searchHit{Document: h.searchDocument, Score: h.Score})
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,271 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/go-ap/errors"
+)
+
+const (
+	bleveIndexDir = "search.bleve"
+	// bleveKeyword is the analyzer of the fields we only filter on: the whole value, lowercased
+	bleveKeyword = "lowercase_keyword"
+)
+
+// bleveSearch is the built-in search index, a Bleve index in the data directory
+type bleveSearch struct {
+	index bleve.Index
+}
+
+func bleveMapping() (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(bleveKeyword, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = standard.Name
+	keyword := bleve.NewTextFieldMapping()
+	keyword.Analyzer = bleveKeyword
+	keyword.IncludeInAll = false
+	flag := bleve.NewBooleanFieldMapping()
+	flag.IncludeInAll = false
+	date := bleve.NewDateTimeFieldMapping()
+	date.IncludeInAll = false
+	// NOTE(marius): we keep the whole document in a field which isn't indexed, so the hits can be shown without
+	// loading the items from fedbox
+	source := bleve.NewTextFieldMapping()
+	source.Index = false
+	source.IncludeInAll = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("title", text)
+	doc.AddFieldMappingsAt("content", text)
+	for _, f := range []string{"author", "domain", "board", "tags"} {
+		doc.AddFieldMappingsAt(f, keyword)
+	}
+	for _, f := range []string{"self", "local", "link", "comment"} {
+		doc.AddFieldMappingsAt(f, flag)
+	}
+	doc.AddFieldMappingsAt("submittedAt", date)
+	doc.AddFieldMappingsAt("source", source)
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+func loadBleveSearch(dataPath string) (*bleveSearch, error) {
+	if len(dataPath) == 0 {
+		m, err := bleveMapping()
+		if err != nil {
+			return nil, err
+		}
+		idx, err := bleve.NewMemOnly(m)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to create the search index")
+		}
+		return &bleveSearch{index: idx}, nil
+	}
+	p := filepath.Join(dataPath, bleveIndexDir)
+	idx, err := bleve.Open(p)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		var m mapping.IndexMapping
+		if m, err = bleveMapping(); err != nil {
+			return nil, err
+		}
+		idx, err = bleve.New(p, m)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to open the search index %s", p)
+	}
+	return &bleveSearch{index: idx}, nil
+}
+
+func (b *bleveSearch) Name() string {
+	return "Bleve"
+}
+
+// bleveDocument is what we give Bleve to index, with the conditions of the is: operator precomputed,
+// as it can't check if a field exists
+func bleveDocument(d searchDocument) (map[string]interface{}, error) {
+	source, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"title":       d.Title,
+		"content":     d.Content,
+		"author":      d.Author,
+		"domain":      d.Domain,
+		"board":       d.Board,
+		"tags":        d.Tags,
+		"self":        d.Self,
+		"local":       d.Local,
+		"link":        !d.Self && len(d.URL) > 0,
+		"comment":     d.IsComment(),
+		"submittedAt": d.SubmittedAt,
+		"source":      string(source),
+	}, nil
+}
+
+func (b *bleveSearch) Index(_ context.Context, docs ...searchDocument) error {
+	batch := b.index.NewBatch()
+	for _, d := range docs {
+		bd, err := bleveDocument(d)
+		if err != nil {
+			return err
+		}
+		if err := batch.Index(d.ID, bd); err != nil {
+			return err
+		}
+	}
+	return b.index.Batch(batch)
+}
+
+func (b *bleveSearch) Remove(_ context.Context, ids ...string) error {
+	batch := b.index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(id)
+	}
+	return b.index.Batch(batch)
+}
+
+func (b *bleveSearch) Search(ctx context.Context, q searchQuery) ([]searchHit, error) {
+	must := make([]query.Query, 0)
+	if q.Expr != nil {
+		must = append(must, q.Expr.bleve())
+	}
+	term := func(field, value string) {
+		if len(value) > 0 {
+			t := bleve.NewTermQuery(strings.ToLower(value))
+			t.SetField(field)
+			must = append(must, t)
+		}
+	}
+	term("author", q.Author)
+	term("tags", strings.TrimLeft(q.Tag, "#"))
+	term("board", q.Board)
+	if len(q.Domain) > 0 {
+		// NOTE(marius): like the domain: operator, it matches the subdomains too
+		must = append(must, (&searchExpr{Field: "domain", Value: q.Domain}).bleve())
+	}
+	if !q.After.IsZero() || !q.Before.IsZero() {
+		must = append(must, bleveDateRange(q.After, q.Before))
+	}
+	var qq query.Query = bleve.NewMatchAllQuery()
+	if len(must) > 0 {
+		qq = bleve.NewConjunctionQuery(must...)
+	}
+	req := bleve.NewSearchRequestOptions(qq, q.max(), q.Offset, false)
+	req.Fields = []string{"source"}
+	req.SortBy([]string{"-_score", "-submittedAt"})
+	res, err := b.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]searchHit, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		source, _ := h.Fields["source"].(string)
+		d := searchDocument{}
+		if err := json.Unmarshal([]byte(source), &d); err != nil {
+			continue
+		}
+		hits = append(hits, searchHit{Document: d, Score: h.Score})
+	}
+	return hits, nil
+}
+
+// bleveDateRange matches the documents submitted between after and before, a zero time leaves that end open
+func bleveDateRange(after, before time.Time) query.Query {
+	exclusive := false
+	r := bleve.NewDateRangeInclusiveQuery(after, before, &exclusive, &exclusive)
+	r.SetField("submittedAt")
+	return r
+}
+
+// bleve translates the expression to a Bleve query
+func (e *searchExpr) bleve() query.Query {
+	args := func() []query.Query {
+		res := make([]query.Query, 0, len(e.Args))
+		for _, a := range e.Args {
+			res = append(res, a.bleve())
+		}
+		return res
+	}
+	term := func(field, value string) query.Query {
+		t := bleve.NewTermQuery(strings.ToLower(value))
+		t.SetField(field)
+		return t
+	}
+	flag := func(field string, value bool) query.Query {
+		f := bleve.NewBoolFieldQuery(value)
+		f.SetField(field)
+		return f
+	}
+	text := func(field string, boost float64) query.Query {
+		if e.Phrase {
+			p := bleve.NewMatchPhraseQuery(e.Value)
+			p.SetField(field)
+			p.SetBoost(boost)
+			return p
+		}
+		m := bleve.NewMatchQuery(e.Value)
+		m.SetField(field)
+		m.SetBoost(boost)
+		m.SetOperator(query.MatchQueryOperatorAnd)
+		return m
+	}
+	switch e.Op {
+	case searchOpAnd:
+		return bleve.NewConjunctionQuery(args()...)
+	case searchOpOr:
+		return bleve.NewDisjunctionQuery(args()...)
+	case searchOpNot:
+		b := bleve.NewBooleanQuery()
+		b.AddMust(bleve.NewMatchAllQuery())
+		b.AddMustNot(args()...)
+		return b
+	}
+	switch e.Field {
+	case "":
+		return bleve.NewDisjunctionQuery(text("title", searchTitleBoost), text("content", 1))
+	case "domain":
+		sub := bleve.NewWildcardQuery("*." + strings.ToLower(e.Value))
+		sub.SetField("domain")
+		return bleve.NewDisjunctionQuery(term("domain", e.Value), sub)
+	case "tag":
+		return term("tags", e.Value)
+	case "before":
+		return bleveDateRange(time.Time{}, e.At)
+	case "after":
+		return bleveDateRange(e.At, time.Time{})
+	case "is":
+		switch e.Value {
+		case "self":
+			return flag("self", true)
+		case "link":
+			return flag("link", true)
+		case "comment":
+			return flag("comment", true)
+		case "submission":
+			return flag("comment", false)
+		case "local":
+			return flag("local", true)
+		}
+	}
+	return term(e.Field, e.Value)
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBleveSearch(t *testing.T) {
+	b, err := loadBleveSearch("")
+	if err != nil {
+		t.Fatalf("unable to load the search index: %s", err)
+	}
+	now := time.Now().UTC()
+	docs := []searchDocument{
+		{ID: "1", Title: "Go generics", Content: "Type parameters are coming to Go", Author: "alice", Domain: "blog.golang.org", URL: "https://blog.golang.org/generics", Tags: []string{"go"}, SubmittedAt: now.Add(-3 * time.Hour)},
+		{ID: "2", Title: "Rust or Go", Content: "A comparison of the two languages", Author: "bob", Self: true, SubmittedAt: now.Add(-2 * time.Hour)},
+		{ID: "3", Parent: "1", Content: "I prefer generics in Go, with type parameters", Author: "alice", Self: true, SubmittedAt: now.Add(-time.Hour)},
+	}
+	ctx := context.Background()
+	if err := b.Index(ctx, docs...); err != nil {
+		t.Fatalf("unable to index the documents: %s", err)
+	}
+
+	// NOTE(marius): the scores of Bleve are different from the ones of the local index, so we only check what matched
+	ids := func(hits []searchHit) []string {
+		res := make([]string, 0, len(hits))
+		for _, h := range hits {
+			res = append(res, h.Document.ID)
+		}
+		sort.Strings(res)
+		return res
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"generics", []string{"1", "3"}},
+		{"type parameters go", []string{"1", "3"}},
+		{"generics rust", []string{}},
+		{"go author:Bob", []string{"2"}},
+		{"go tag:#go", []string{"1"}},
+		{"author:alice", []string{"1", "3"}},
+		{"go -generics", []string{"2"}},
+		{"rust OR \"type parameters\"", []string{"1", "2", "3"}},
+		{"\"parameters type\"", []string{}},
+		{"is:comment OR rust", []string{"2", "3"}},
+		{"is:link", []string{"1"}},
+		{"site:golang.org", []string{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := parseSearchQuery(tt.query, now)
+			if err != nil {
+				t.Fatalf("invalid query: %s", err)
+			}
+			hits, err := b.Search(ctx, q)
+			if err != nil {
+				t.Fatalf("search failed: %s", err)
+			}
+			got := ids(hits)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	if err := b.Remove(ctx, "1"); err != nil {
+		t.Fatalf("unable to remove the document: %s", err)
+	}
+	q, _ := parseSearchQuery("generics", now)
+	hits, _ := b.Search(ctx, q)
+	if got := ids(hits); len(got) != 1 || got[0] != "3" {
+		t.Errorf("expected the index to find only 3 after removing 1, got %v", got)
+	}
+}
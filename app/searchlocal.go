@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-ap/errors"
+)
+
+// searchTitleBoost is how much more a word weighs in the title than in the content
+const searchTitleBoost = 3
+
+// searchTerms splits the text in the lowercase words we index, the single letters are skipped
+func searchTerms(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if len([]rune(w)) > 1 {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+func documentTerms(d searchDocument) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, t := range searchTerms(d.Title) {
+		weights[t] += searchTitleBoost
+	}
+	for _, t := range searchTerms(d.Content) {
+		weights[t]++
+	}
+	return weights
+}
+
+// localSearch is the simpler built-in search index, an inverted index of the words in the titles and content of the items.
+// The documents are kept compressed in the data directory, and the index is rebuilt from them at start.
+// Without a data directory they are kept only in memory.
+type localSearch struct {
+	m     sync.RWMutex
+	path  string
+	dirty bool
+	docs  map[string]searchDocument
+	terms map[string]map[string]float64
+}
+
+func loadLocalSearch(dataPath string) (*localSearch, error) {
+	l := &localSearch{
+		docs:  make(map[string]searchDocument),
+		terms: make(map[string]map[string]float64),
+	}
+	if len(dataPath) == 0 {
+		return l, nil
+	}
+	l.path = filepath.Join(dataPath, searchIndexFile)
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return l, err
+	}
+	defer z.Close()
+	docs := make([]searchDocument, 0)
+	if err := json.NewDecoder(z).Decode(&docs); err != nil {
+		return l, err
+	}
+	for _, d := range docs {
+		l.add(d)
+	}
+	return l, nil
+}
+
+func (l *localSearch) Name() string {
+	return "built-in"
+}
+
+// add needs to be called with the lock held
+func (l *localSearch) add(d searchDocument) {
+	l.remove(d.ID)
+	l.docs[d.ID] = d
+	for t, w := range documentTerms(d) {
+		postings, ok := l.terms[t]
+		if !ok {
+			postings = make(map[string]float64)
+			l.terms[t] = postings
+		}
+		postings[d.ID] = w
+	}
+}
+
+// remove needs to be called with the lock held
+func (l *localSearch) remove(id string) {
+	d, ok := l.docs[id]
+	if !ok {
+		return
+	}
+	for t := range documentTerms(d) {
+		delete(l.terms[t], id)
+		if len(l.terms[t]) == 0 {
+			delete(l.terms, t)
+		}
+	}
+	delete(l.docs, id)
+}
+
+func (l *localSearch) Index(_ context.Context, docs ...searchDocument) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, d := range docs {
+		l.add(d)
+	}
+	l.dirty = l.dirty || len(docs) > 0
+	return nil
+}
+
+func (l *localSearch) Remove(_ context.Context, ids ...string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, id := range ids {
+		if _, ok := l.docs[id]; ok {
+			l.remove(id)
+			l.dirty = true
+		}
+	}
+	return nil
+}
+
+// Search returns the documents matching the query, scored by the TF-IDF of the words they were searched for
+func (l *localSearch) Search(_ context.Context, q searchQuery) ([]searchHit, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	// NOTE(marius): when some words are required we only check the documents containing the rarest of them
+	var candidates map[string]float64
+	for _, e := range q.Expr.required() {
+		if len(e.Field) > 0 {
+			continue
+		}
+		for _, t := range searchTerms(e.Value) {
+			if postings := l.terms[t]; candidates == nil || len(postings) < len(candidates) {
+				candidates = postings
+				if candidates == nil {
+					candidates = map[string]float64{}
+				}
+			}
+		}
+	}
+	words := make(map[string]float64)
+	for _, t := range q.Expr.words() {
+		if df := len(l.terms[t]); df > 0 {
+			words[t] = math.Log(1 + float64(len(l.docs))/float64(df))
+		}
+	}
+	hits := make([]searchHit, 0)
+	check := func(d searchDocument) {
+		if !q.matches(d) {
+			return
+		}
+		h := searchHit{Document: d}
+		for t, idf := range words {
+			h.Score += l.terms[t][d.ID] * idf
+		}
+		hits = append(hits, h)
+	}
+	if candidates != nil {
+		for id := range candidates {
+			check(l.docs[id])
+		}
+	} else {
+		for _, d := range l.docs {
+			check(d)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Document.SubmittedAt.After(hits[j].Document.SubmittedAt)
+	})
+	if q.Offset >= len(hits) {
+		return hits[:0], nil
+	}
+	hits = hits[q.Offset:]
+	if max := q.max(); len(hits) > max {
+		hits = hits[:max]
+	}
+	return hits, nil
+}
+
+// Flush writes the documents to disk, if they changed since the last time
+func (l *localSearch) Flush() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if !l.dirty || len(l.path) == 0 {
+		return nil
+	}
+	docs := make([]searchDocument, 0, len(l.docs))
+	for _, d := range l.docs {
+		docs = append(docs, d)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return errors.Annotatef(err, "unable to create the data directory")
+	}
+	buf := new(bytes.Buffer)
+	z := gzip.NewWriter(buf)
+	err := json.NewEncoder(z).Encode(docs)
+	if e := z.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(l.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
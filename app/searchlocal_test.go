@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalSearch(t *testing.T) {
+	l, err := loadLocalSearch("")
+	if err != nil {
+		t.Fatalf("unable to load the search index: %s", err)
+	}
+	now := time.Now().UTC()
+	docs := []searchDocument{
+		{ID: "1", Title: "Go generics", Content: "Type parameters are coming to Go", Author: "alice", Tags: []string{"go"}, SubmittedAt: now.Add(-3 * time.Hour)},
+		{ID: "2", Title: "Rust or Go", Content: "A comparison of the two languages", Author: "bob", SubmittedAt: now.Add(-2 * time.Hour)},
+		{ID: "3", Parent: "1", Content: "I prefer generics in Go, with type parameters", Author: "alice", SubmittedAt: now.Add(-time.Hour)},
+	}
+	ctx := context.Background()
+	if err := l.Index(ctx, docs...); err != nil {
+		t.Fatalf("unable to index the documents: %s", err)
+	}
+
+	ids := func(hits []searchHit) []string {
+		res := make([]string, 0, len(hits))
+		for _, h := range hits {
+			res = append(res, h.Document.ID)
+		}
+		return res
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"generics", []string{"1", "3"}},
+		{"type parameters go", []string{"1", "3"}},
+		{"generics rust", []string{}},
+		{"go author:Bob", []string{"2"}},
+		{"go tag:#go", []string{"1"}},
+		{"author:alice", []string{"3", "1"}},
+		{"go -generics", []string{"2"}},
+		{"rust OR \"type parameters\"", []string{"2", "3", "1"}},
+		{"\"parameters type\"", []string{}},
+		{"is:comment OR rust", []string{"2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := parseSearchQuery(tt.query, now)
+			if err != nil {
+				t.Fatalf("invalid query: %s", err)
+			}
+			hits, err := l.Search(ctx, q)
+			if err != nil {
+				t.Fatalf("search failed: %s", err)
+			}
+			got := ids(hits)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	if err := l.Remove(ctx, "1"); err != nil {
+		t.Fatalf("unable to remove the document: %s", err)
+	}
+	q, _ := parseSearchQuery("generics", now)
+	hits, _ := l.Search(ctx, q)
+	if got := ids(hits); len(got) != 1 || got[0] != "3" {
+		t.Errorf("expected the index to find only 3 after removing 1, got %v", got)
+	}
+}
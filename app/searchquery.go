@@ -0,0 +1,522 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-ap/errors"
+)
+
+const (
+	searchOpAnd = "and"
+	searchOpOr  = "or"
+	searchOpNot = "not"
+
+	searchMaxQueryLen = 256
+)
+
+// searchFields are the operators of the search syntax, like author:handle, the aliases point to the same field
+var searchFields = map[string]string{
+	"author": "author",
+	"by":     "author",
+	"domain": "domain",
+	"site":   "domain",
+	"tag":    "tag",
+	"board":  "board",
+	"before": "before",
+	"after":  "after",
+	"is":     "is",
+}
+
+// searchKinds are the values of the is: operator
+var searchKinds = []string{"self", "link", "comment", "submission", "local"}
+
+// searchExpr is a node of a parsed search query: a word, a "quoted phrase", a field:value condition,
+// or the and, or, not combination of other nodes
+type searchExpr struct {
+	Op     string
+	Field  string
+	Value  string
+	Phrase bool
+	At     time.Time
+	Args   []*searchExpr
+}
+
+const (
+	tokWord = iota
+	tokPhrase
+	tokOpen
+	tokClose
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type searchToken struct {
+	kind  int
+	field string
+	value string
+}
+
+// lexSearch splits the query in tokens, it never fails: the unknown operators are plain words, and the
+// phrases without the closing quote end with the query
+func lexSearch(s string) []searchToken {
+	runes := []rune(s)
+	toks := make([]searchToken, 0)
+	quoted := func(i int) (string, int) {
+		end := i + 1
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		return string(runes[i+1 : end]), end + 1
+	}
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			toks = append(toks, searchToken{kind: tokOpen})
+			i++
+		case c == ')':
+			toks = append(toks, searchToken{kind: tokClose})
+			i++
+		case c == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+			toks = append(toks, searchToken{kind: tokNot})
+			i++
+		case c == '"':
+			var v string
+			v, i = quoted(i)
+			toks = append(toks, searchToken{kind: tokPhrase, value: v})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+			word := string(runes[start:i])
+			if idx := strings.Index(word, ":"); idx > 0 {
+				if field, ok := searchFields[strings.ToLower(word[:idx])]; ok {
+					v := word[idx+1:]
+					if len(v) == 0 && i < len(runes) && runes[i] == '"' {
+						v, i = quoted(i)
+					}
+					toks = append(toks, searchToken{kind: tokWord, field: field, value: v})
+					continue
+				}
+			}
+			switch word {
+			case "AND", "&&":
+				toks = append(toks, searchToken{kind: tokAnd})
+			case "OR", "||", "|":
+				toks = append(toks, searchToken{kind: tokOr})
+			case "NOT":
+				toks = append(toks, searchToken{kind: tokNot})
+			default:
+				toks = append(toks, searchToken{kind: tokWord, value: word})
+			}
+		}
+	}
+	return toks
+}
+
+// searchParser builds the expression from the tokens, OR binds less than the implicit AND between the terms,
+// and the dangling operators and parentheses are ignored
+type searchParser struct {
+	toks []searchToken
+	pos  int
+	now  time.Time
+	err  error
+}
+
+func (p *searchParser) peek(kind int) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == kind
+}
+
+func combineSearch(op string, args []*searchExpr) *searchExpr {
+	switch len(args) {
+	case 0:
+		return nil
+	case 1:
+		return args[0]
+	}
+	return &searchExpr{Op: op, Args: args}
+}
+
+func (p *searchParser) or() *searchExpr {
+	args := make([]*searchExpr, 0)
+	if e := p.and(); e != nil {
+		args = append(args, e)
+	}
+	for p.peek(tokOr) {
+		p.pos++
+		if e := p.and(); e != nil {
+			args = append(args, e)
+		}
+	}
+	return combineSearch(searchOpOr, args)
+}
+
+func (p *searchParser) and() *searchExpr {
+	args := make([]*searchExpr, 0)
+	for p.pos < len(p.toks) && !p.peek(tokOr) && !p.peek(tokClose) {
+		if p.peek(tokAnd) {
+			p.pos++
+			continue
+		}
+		if e := p.unary(); e != nil {
+			args = append(args, e)
+		}
+	}
+	return combineSearch(searchOpAnd, args)
+}
+
+func (p *searchParser) unary() *searchExpr {
+	t := p.toks[p.pos]
+	switch t.kind {
+	case tokNot:
+		p.pos++
+		if p.pos >= len(p.toks) || p.peek(tokOr) || p.peek(tokClose) || p.peek(tokAnd) {
+			return nil
+		}
+		if e := p.unary(); e != nil {
+			return &searchExpr{Op: searchOpNot, Args: []*searchExpr{e}}
+		}
+		return nil
+	case tokOpen:
+		p.pos++
+		e := p.or()
+		if p.peek(tokClose) {
+			p.pos++
+		}
+		return e
+	}
+	p.pos++
+	return p.leaf(t)
+}
+
+// parseSearchDate accepts the 2006-01-02, 2006-01 and 2006 dates, and the 7d, 2w, 3m, 1y durations before now
+func parseSearchDate(s string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if len(s) > 1 {
+		if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n > 0 {
+			switch s[len(s)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			case 'm':
+				return now.AddDate(0, -n, 0), nil
+			case 'y':
+				return now.AddDate(-n, 0, 0), nil
+			}
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid date %q, use 2006-01-02, 2006-01, 2006, or a number of days, weeks, months or years ago, like 7d, 2w, 3m, 1y", s)
+}
+
+func (p *searchParser) leaf(t searchToken) *searchExpr {
+	v := strings.TrimSpace(t.value)
+	if len(v) == 0 {
+		return nil
+	}
+	if t.kind == tokPhrase || len(t.field) == 0 {
+		if len(searchTerms(v)) == 0 {
+			return nil
+		}
+		return &searchExpr{Value: v, Phrase: t.kind == tokPhrase}
+	}
+	e := &searchExpr{Field: t.field, Value: v}
+	switch t.field {
+	case "author":
+		e.Value = strings.TrimLeft(v, "@~")
+	case "tag":
+		e.Value = strings.ToLower(strings.TrimLeft(v, "#"))
+	case "domain":
+		e.Value = strings.TrimPrefix(strings.ToLower(v), "www.")
+	case "before", "after":
+		at, err := parseSearchDate(v, p.now)
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		e.At = at
+	case "is":
+		e.Value = strings.ToLower(v)
+		if e.Value == "post" {
+			e.Value = "submission"
+		}
+		if !stringInSlice(searchKinds)(e.Value) && p.err == nil {
+			p.err = errors.Errorf("unknown is:%s, it can be %s", v, strings.Join(searchKinds, ", "))
+		}
+	}
+	if len(e.Value) == 0 {
+		return nil
+	}
+	return e
+}
+
+// parseSearchExpr parses the search syntax: words, "quoted phrases", the author:, domain:, tag:, board:, before:,
+// after: and is: operators, the words can be combined with AND, which is implicit, OR, NOT or -, and parentheses
+func parseSearchExpr(s string, now time.Time) (*searchExpr, error) {
+	if r := []rune(s); len(r) > searchMaxQueryLen {
+		s = string(r[:searchMaxQueryLen])
+	}
+	p := searchParser{toks: lexSearch(s), now: now}
+	parts := make([]*searchExpr, 0)
+	for p.pos < len(p.toks) {
+		if e := p.or(); e != nil {
+			parts = append(parts, e)
+		}
+		if p.peek(tokClose) {
+			// NOTE(marius): a ) without its (
+			p.pos++
+		}
+	}
+	return combineSearch(searchOpAnd, parts), p.err
+}
+
+// parseSearchQuery builds the query for the search drivers from the text the users search for. The conditions on
+// the fields which need to match, the ones which aren't part of an OR or NOT, are set on the query too, for the
+// drivers which filter the documents themselves.
+func parseSearchQuery(s string, now time.Time) (searchQuery, error) {
+	e, err := parseSearchExpr(s, now)
+	q := searchQuery{Expr: e, Text: strings.Join(e.text(), " ")}
+	for _, f := range e.required() {
+		switch f.Field {
+		case "author":
+			q.Author = f.Value
+		case "domain":
+			q.Domain = f.Value
+		case "tag":
+			q.Tag = f.Value
+		case "board":
+			q.Board = f.Value
+		case "before":
+			q.Before = f.At
+		case "after":
+			q.After = f.At
+		}
+	}
+	return q, err
+}
+
+// required returns the conditions every matching document needs to meet, the terms of a top level AND
+func (e *searchExpr) required() []*searchExpr {
+	if e == nil || e.Op == searchOpOr || e.Op == searchOpNot {
+		return nil
+	}
+	if e.Op != searchOpAnd {
+		return []*searchExpr{e}
+	}
+	res := make([]*searchExpr, 0, len(e.Args))
+	for _, a := range e.Args {
+		res = append(res, a.required()...)
+	}
+	return res
+}
+
+// text returns the words and phrases the documents are searched for, without the negated ones
+func (e *searchExpr) text() []string {
+	if e == nil || e.Op == searchOpNot {
+		return nil
+	}
+	if len(e.Op) > 0 {
+		res := make([]string, 0)
+		for _, a := range e.Args {
+			res = append(res, a.text()...)
+		}
+		return res
+	}
+	if len(e.Field) > 0 {
+		return nil
+	}
+	if e.Phrase {
+		return []string{`"` + e.Value + `"`}
+	}
+	return []string{e.Value}
+}
+
+// words returns the indexed terms of the words and phrases the documents are searched for, for scoring them
+func (e *searchExpr) words() []string {
+	res := make([]string, 0)
+	for _, t := range e.text() {
+		res = append(res, searchTerms(t)...)
+	}
+	return res
+}
+
+// searchText is the title and content of a document, split in terms once for all the nodes of the expression
+type searchText struct {
+	title   []string
+	content []string
+	terms   map[string]bool
+}
+
+func newSearchText(d searchDocument) *searchText {
+	t := &searchText{title: searchTerms(d.Title), content: searchTerms(d.Content), terms: make(map[string]bool)}
+	for _, w := range t.title {
+		t.terms[w] = true
+	}
+	for _, w := range t.content {
+		t.terms[w] = true
+	}
+	return t
+}
+
+func containsTerms(haystack, needle []string) bool {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		found := true
+		for j := range needle {
+			if haystack[i+j] != needle[j] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// has checks if the text contains the terms, the phrases and the words which have more than one term,
+// like e-mail, need the terms one after the other
+func (t *searchText) has(terms []string, phrase bool) bool {
+	if len(terms) == 0 {
+		return true
+	}
+	if phrase || len(terms) > 1 {
+		return containsTerms(t.title, terms) || containsTerms(t.content, terms)
+	}
+	return t.terms[terms[0]]
+}
+
+// Match evaluates the expression on the document
+func (e *searchExpr) Match(d searchDocument) bool {
+	if e == nil {
+		return true
+	}
+	return e.match(d, newSearchText(d))
+}
+
+func (e *searchExpr) match(d searchDocument, t *searchText) bool {
+	switch e.Op {
+	case searchOpAnd:
+		for _, a := range e.Args {
+			if !a.match(d, t) {
+				return false
+			}
+		}
+		return true
+	case searchOpOr:
+		for _, a := range e.Args {
+			if a.match(d, t) {
+				return true
+			}
+		}
+		return false
+	case searchOpNot:
+		return !e.Args[0].match(d, t)
+	}
+	q := searchQuery{}
+	switch e.Field {
+	case "":
+		return t.has(searchTerms(e.Value), e.Phrase)
+	case "author":
+		q.Author = e.Value
+	case "domain":
+		q.Domain = e.Value
+	case "tag":
+		q.Tag = e.Value
+	case "board":
+		q.Board = e.Value
+	case "before":
+		q.Before = e.At
+	case "after":
+		q.After = e.At
+	case "is":
+		switch e.Value {
+		case "self":
+			return d.Self
+		case "link":
+			return !d.Self && len(d.URL) > 0
+		case "comment":
+			return d.IsComment()
+		case "submission":
+			return !d.IsComment()
+		case "local":
+			return d.Local
+		}
+		return false
+	}
+	return q.matches(d)
+}
+
+// elastic translates the expression to an Elasticsearch query
+func (e *searchExpr) elastic() map[string]interface{} {
+	args := func() []interface{} {
+		res := make([]interface{}, 0, len(e.Args))
+		for _, a := range e.Args {
+			res = append(res, a.elastic())
+		}
+		return res
+	}
+	boolQuery := func(q map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"bool": q}
+	}
+	term := func(field string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"term": map[string]interface{}{field: value}}
+	}
+	exists := func(field string) map[string]interface{} {
+		return map[string]interface{}{"exists": map[string]string{"field": field}}
+	}
+	switch e.Op {
+	case searchOpAnd:
+		return boolQuery(map[string]interface{}{"must": args()})
+	case searchOpOr:
+		return boolQuery(map[string]interface{}{"should": args(), "minimum_should_match": 1})
+	case searchOpNot:
+		return boolQuery(map[string]interface{}{"must_not": args()})
+	}
+	switch e.Field {
+	case "":
+		m := map[string]interface{}{"query": e.Value, "fields": []string{"title^3", "content"}, "operator": "and"}
+		if e.Phrase {
+			m["type"] = "phrase"
+		}
+		return map[string]interface{}{"multi_match": m}
+	case "domain":
+		return boolQuery(map[string]interface{}{
+			"should": []interface{}{
+				term("domain", e.Value),
+				map[string]interface{}{"wildcard": map[string]string{"domain": "*." + e.Value}},
+			},
+			"minimum_should_match": 1,
+		})
+	case "tag":
+		return term("tags", e.Value)
+	case "before":
+		return map[string]interface{}{"range": map[string]interface{}{"submittedAt": map[string]string{"lt": e.At.UTC().Format(time.RFC3339)}}}
+	case "after":
+		return map[string]interface{}{"range": map[string]interface{}{"submittedAt": map[string]string{"gt": e.At.UTC().Format(time.RFC3339)}}}
+	case "is":
+		switch e.Value {
+		case "self":
+			return term("self", true)
+		case "link":
+			return boolQuery(map[string]interface{}{"must": []interface{}{term("self", false), exists("url")}})
+		case "comment":
+			return boolQuery(map[string]interface{}{"should": []interface{}{exists("parent"), exists("op")}, "minimum_should_match": 1})
+		case "submission":
+			return boolQuery(map[string]interface{}{"must_not": []interface{}{exists("parent"), exists("op")}})
+		case "local":
+			return term("local", true)
+		}
+	}
+	return term(e.Field, e.Value)
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exprString(e *searchExpr) string {
+	if e == nil {
+		return ""
+	}
+	if len(e.Op) > 0 {
+		args := make([]string, 0, len(e.Args))
+		for _, a := range e.Args {
+			args = append(args, exprString(a))
+		}
+		return fmt.Sprintf("%s(%s)", e.Op, strings.Join(args, " "))
+	}
+	if e.Phrase {
+		return `"` + e.Value + `"`
+	}
+	if len(e.Field) > 0 {
+		return e.Field + ":" + e.Value
+	}
+	return e.Value
+}
+
+func TestParseSearchExpr(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{"go generics", "and(go generics)", false},
+		{`"type parameters" go`, `and("type parameters" go)`, false},
+		{"rust OR go compiler", "or(rust and(go compiler))", false},
+		{"(rust OR go) compiler", "and(or(rust go) compiler)", false},
+		{"go -rust NOT java", "and(go not(rust) not(java))", false},
+		{"author:@alice By:bob domain:www.Example.com tag:#Go", "and(author:alice author:bob domain:example.com tag:go)", false},
+		{`board:"the board" is:post`, "and(board:the board is:submission)", false},
+		{"http://example.com a", "http://example.com", false},
+		{"((go OR ) AND", "go", false},
+		{"go)) rust", "and(go rust)", false},
+		{`"unfinished phrase`, `"unfinished phrase"`, false},
+		{"after:yesterday", "after:yesterday", true},
+		{"is:video", "is:video", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e, err := parseSearchExpr(tt.query, now)
+			if (err != nil) != tt.err {
+				t.Errorf("expected error %t, got %v", tt.err, err)
+			}
+			if got := exprString(e); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	q, err := parseSearchQuery(`go "type parameters" -rust author:alice after:2w before:2021-07 (tag:a OR tag:b)`, now)
+	if err != nil {
+		t.Fatalf("invalid query: %s", err)
+	}
+	if q.Text != `go "type parameters"` {
+		t.Errorf("expected the text without the negated words, got %q", q.Text)
+	}
+	if q.Author != "alice" || len(q.Tag) > 0 {
+		t.Errorf("expected only the required fields to be set, got author %q and tag %q", q.Author, q.Tag)
+	}
+	if !q.After.Equal(now.AddDate(0, 0, -14)) || !q.Before.Equal(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("invalid dates, after %s, before %s", q.After, q.Before)
+	}
+	d := searchDocument{Title: "Type parameters in Go", Author: "Alice", Tags: []string{"b"}, SubmittedAt: now.AddDate(0, 0, -10)}
+	if !q.matches(d) {
+		t.Errorf("expected the document to match")
+	}
+	d.Content = "unlike Rust"
+	if q.matches(d) {
+		t.Errorf("expected the document with a negated word not to match")
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"path"
+	"strings"
+	"unicode"
+)
+
+const (
+	// searchSnippetLen is the number of characters of the content we show around the first matching word
+	searchSnippetLen = 240
+
+	matchedTitle   = "title"
+	matchedText    = "text"
+	matchedComment = "comment"
+)
+
+// snippetPart is a piece of a search result, Match is set for the words of the query, which we highlight
+type snippetPart struct {
+	Text  string
+	Match bool
+}
+
+// searchWord is a word of a text, with its position in runes
+type searchWord struct {
+	start, end int
+	match      bool
+}
+
+func matchingWords(runes []rune, terms map[string]bool) []searchWord {
+	words := make([]searchWord, 0)
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+	for i := 0; i < len(runes); {
+		if !isWord(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && isWord(runes[i]) {
+			i++
+		}
+		words = append(words, searchWord{start: start, end: i, match: terms[strings.ToLower(string(runes[start:i]))]})
+	}
+	return words
+}
+
+// highlight splits the text in the parts matching the terms, and the rest. When max is positive the text is cut
+// to about max characters around the first match, or from its beginning if nothing matches.
+func highlight(text string, terms map[string]bool, max int) ([]snippetPart, bool) {
+	runes := []rune(text)
+	words := matchingWords(runes, terms)
+	start, end := 0, len(runes)
+	first := -1
+	for _, w := range words {
+		if w.match {
+			first = w.start
+			break
+		}
+	}
+	if max > 0 && len(runes) > max {
+		if first > max/3 {
+			start = first - max/3
+		}
+		if end = start + max; end > len(runes) {
+			end = len(runes)
+			if start = end - max; start < 0 {
+				start = 0
+			}
+		}
+		// NOTE(marius): we don't cut the words in half
+		for _, w := range words {
+			if w.start < start && w.end > start {
+				start = w.end
+			}
+			if w.start < end && w.end > end {
+				end = w.start
+			}
+		}
+		for start < end && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		for end > start && unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+	}
+	parts := make([]snippetPart, 0)
+	add := func(s string, match bool) {
+		if len(s) > 0 {
+			parts = append(parts, snippetPart{Text: s, Match: match})
+		}
+	}
+	if start > 0 {
+		add("…", false)
+	}
+	last := start
+	for _, w := range words {
+		if !w.match || w.start < start || w.end > end {
+			continue
+		}
+		add(string(runes[last:w.start]), false)
+		add(string(runes[w.start:w.end]), true)
+		last = w.end
+	}
+	add(string(runes[last:end]), false)
+	if end < len(runes) {
+		add("…", false)
+	}
+	return parts, first >= 0
+}
+
+// highlight marks the words of the query in the title and content of the hit, and where they were found
+func (h *searchHit) highlight(words []string) {
+	terms := make(map[string]bool, len(words))
+	for _, w := range words {
+		terms[w] = true
+	}
+	d := h.Document
+	var inTitle, inContent bool
+	h.Title, inTitle = highlight(d.Title, terms, 0)
+	h.Snippet, inContent = highlight(d.Content, terms, searchSnippetLen)
+	h.Matched = h.Matched[:0]
+	if inTitle {
+		h.Matched = append(h.Matched, matchedTitle)
+	}
+	if inContent {
+		if d.IsComment() {
+			h.Matched = append(h.Matched, matchedComment)
+		} else {
+			h.Matched = append(h.Matched, matchedText)
+		}
+	}
+}
+
+// IsComment returns if the document is a reply
+func (d searchDocument) IsComment() bool {
+	return len(d.Parent) > 0 || len(d.OP) > 0
+}
+
+// ThreadLink returns the link of the comment in its discussion, for the local ones, or the link of the item
+func (d searchDocument) ThreadLink() string {
+	if !d.IsComment() || len(d.OP) == 0 || !HostIsLocal(d.OP) {
+		return d.Link()
+	}
+	return "/i/" + path.Base(d.OP) + "#item-" + d.ID
+}
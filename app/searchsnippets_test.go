@@ -0,0 +1,46 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func snippetString(parts []snippetPart) string {
+	s := strings.Builder{}
+	for _, p := range parts {
+		if p.Match {
+			s.WriteString("[" + p.Text + "]")
+		} else {
+			s.WriteString(p.Text)
+		}
+	}
+	return s.String()
+}
+
+func TestHighlight(t *testing.T) {
+	terms := map[string]bool{"go": true, "generics": true}
+	tests := []struct {
+		text  string
+		max   int
+		want  string
+		match bool
+	}{
+		{"Generics in Go!", 0, "[Generics] in [Go]!", true},
+		{"Nothing to see here", 0, "Nothing to see here", false},
+		{"Nothing to see here", 10, "Nothing to…", false},
+		{"the quick brown fox jumps over the lazy dog, then it learns go", 30, "…lazy dog, then it learns [go]", true},
+		{"gopher goes, go", 0, "gopher goes, [go]", true},
+	}
+	for _, tt := range tests {
+		parts, match := highlight(tt.text, terms, tt.max)
+		if got := snippetString(parts); got != tt.want || match != tt.match {
+			t.Errorf("%q: expected %q %t, got %q %t", tt.text, tt.want, tt.match, got, match)
+		}
+	}
+
+	h := searchHit{Document: searchDocument{Title: "Go generics", Content: "more about generics", OP: "https://littr.git/objects/1"}}
+	h.highlight([]string{"generics"})
+	if len(h.Matched) != 2 || h.Matched[0] != matchedTitle || h.Matched[1] != matchedComment {
+		t.Errorf("expected the match in the title and the comment, got %v", h.Matched)
+	}
+}
@@ -2,12 +2,17 @@ package app
 
 import (
 	"encoding/gob"
+	"expvar"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/gorilla/sessions"
 	"github.com/mariusor/go-littr/internal/log"
@@ -27,27 +32,133 @@ type flash struct {
 	Msg  string
 }
 
+// defaultSessionsFileMode is the mode the gorilla/sessions filesystem store creates the session files with
+const defaultSessionsFileMode os.FileMode = 0600
+
+// sessionAccountVersion needs to be incremented when sessionAccount changes, the sessions
+// saved with a different version get discarded instead of failing to decode
+const sessionAccountVersion = 1
+
+// sessionAccount is what we keep in the session for the logged account,
+// the rest of its information gets loaded from fedbox or from the account snapshots
+type sessionAccount struct {
+	Version int
+	Hash    Hash
+	Handle  string
+	ID      string
+	OAuth   OAuth
+	// LoggedAt is when the user logged in, the sessions older than the user's last "log out everywhere" are invalid
+	LoggedAt time.Time
+}
+
+func sessionAccountFrom(a Account) sessionAccount {
+	s := sessionAccount{
+		Version:  sessionAccountVersion,
+		Hash:     a.Hash,
+		Handle:   a.Handle,
+		LoggedAt: time.Now().UTC(),
+	}
+	if a.HasMetadata() {
+		s.ID = a.Metadata.ID
+		s.OAuth = a.Metadata.OAuth
+	}
+	return s
+}
+
+// Account returns the account with the information we have in the session
+func (s sessionAccount) Account() Account {
+	return Account{
+		Hash:   s.Hash,
+		Handle: s.Handle,
+		Metadata: &AccountMetadata{
+			ID:    s.ID,
+			OAuth: s.OAuth,
+		},
+	}
+}
+
+// sessionRevocations keeps when the accounts logged out of all their sessions, and the OAuth2 tokens
+// issued to their sessions, so they can be revoked together.
+// It's saved to disk, so it works with the cookie backend too, and it survives restarts.
+type sessionRevocations struct {
+	m       sync.RWMutex
+	path    string
+	revoked map[string]time.Time
+	tokens  map[string][]OAuth
+}
+
+type sessionRevocationsFile struct {
+	Revoked map[string]time.Time `json:"revoked"`
+	Tokens  map[string][]OAuth   `json:"tokens,omitempty"`
+}
+
+func loadSessionRevocations(path string) (*sessionRevocations, error) {
+	f := sessionRevocationsFile{Revoked: make(map[string]time.Time), Tokens: make(map[string][]OAuth)}
+	err := loadJSON(path, &f)
+	return &sessionRevocations{path: path, revoked: f.Revoked, tokens: f.Tokens}, err
+}
+
+func (s *sessionRevocations) save() error {
+	return saveJSON(s.path, sessionRevocationsFile{Revoked: s.revoked, Tokens: s.tokens})
+}
+
+// Issued keeps the token the account received when logging in, the expired ones are dropped
+func (s *sessionRevocations) Issued(id string, o OAuth) error {
+	if s == nil || len(id) == 0 || o.Token == nil {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	tokens := []OAuth{{Provider: o.Provider, Token: o.Token}}
+	for _, t := range s.tokens[id] {
+		if t.Token == nil || (len(t.Token.RefreshToken) == 0 && !t.Token.Valid()) {
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	s.tokens[id] = tokens
+	return s.save()
+}
+
+// Revoke invalidates all the sessions of the account that were created until now,
+// and returns the tokens that were issued to them
+func (s *sessionRevocations) Revoke(id string) ([]OAuth, error) {
+	if s == nil || len(id) == 0 {
+		return nil, nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.revoked[id] = time.Now().UTC()
+	tokens := s.tokens[id]
+	delete(s.tokens, id)
+	return tokens, s.save()
+}
+
+// IsRevoked returns if the session account was logged in before the last revocation
+func (s *sessionRevocations) IsRevoked(a sessionAccount) bool {
+	if s == nil {
+		return false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	at, ok := s.revoked[a.ID]
+	return ok && !a.LoggedAt.After(at)
+}
+
 type sess struct {
 	enabled bool
 	path    string
 	name    string
 	s       sessions.Store
+	revoked *sessionRevocations
 	infoFn  CtxLogFn
 	errFn   CtxLogFn
 }
 
 func initSession(c appConfig, infoFn, errFn CtxLogFn) (sess, error) {
 	// session encoding for account and flash message objects
-	gob.Register(Account{})
+	gob.Register(sessionAccount{})
 	gob.Register(flash{})
-	gob.Register(activitypub.Activity{})
-	gob.Register(activitypub.IRI(""))
-	gob.Register(activitypub.NaturalLanguageValues{})
-	gob.Register(activitypub.Object{})
-	gob.Register(activitypub.Actor{})
-	gob.Register(activitypub.ItemCollection{})
-	gob.Register(activitypub.Link{})
-	gob.Register(activitypub.Tombstone{})
 
 	if len(c.SessionKeys) == 0 {
 		return sess{}, errors.NotImplementedf("no session encryption keys, unable to use sessions")
@@ -75,6 +186,11 @@ func initSession(c appConfig, infoFn, errFn CtxLogFn) (sess, error) {
 	}
 	if err != nil {
 		s.enabled = false
+		return s, nil
+	}
+	revPath := path.Join(c.SessionsPath, string(c.Env), fmt.Sprintf("%s-revoked.json", c.HostName))
+	if s.revoked, err = loadSessionRevocations(revPath); err != nil {
+		errFn(log.Ctx{"err": err, "path": revPath})("unable to load the revoked sessions")
 	}
 	return s, nil
 }
@@ -107,17 +223,110 @@ func initCookieSession(c appConfig, infoFn, errFn CtxLogFn) (sessions.Store, err
 	return ss, nil
 }
 
-func makeSessionsPath(path string) error {
-	err := os.MkdirAll(path, 0700)
+// sessionsDirMode returns the mode for the sessions directory, which needs the execute bits
+// for the users that can read the session files
+func sessionsDirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+func makeSessionsPath(path string, mode os.FileMode) error {
+	err := os.MkdirAll(path, sessionsDirMode(mode))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// fileSessionStore sets the configured mode on the session files after saving them
+type fileSessionStore struct {
+	*sessions.FilesystemStore
+	path string
+	mode os.FileMode
+}
+
+func (f fileSessionStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := f.FilesystemStore.Save(r, w, s); err != nil {
+		return err
+	}
+	if len(s.ID) == 0 || f.mode == defaultSessionsFileMode {
+		return nil
+	}
+	// NOTE(marius): this is the file name that the gorilla/sessions filesystem store uses
+	err := os.Chmod(filepath.Join(f.path, "session_"+s.ID), f.mode)
+	if os.IsNotExist(err) {
+		// the session has been deleted
+		return nil
+	}
+	return err
+}
+
+// activeSessions is the number of sessions the filesystem backend had after the last clean up, it's exposed under /debug/vars
+var activeSessions = expvar.NewInt("sessions_active")
+
+// removeExpired deletes the session files which weren't saved for longer than the sessions' max age
+// It returns how many sessions were removed, and how many are left.
+func (f fileSessionStore) removeExpired() (int, int, error) {
+	maxAge := time.Duration(f.Options.MaxAge) * time.Second
+	if maxAge <= 0 {
+		return 0, 0, nil
+	}
+	files, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, active := 0, 0
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasPrefix(fi.Name(), "session_") {
+			continue
+		}
+		if time.Since(fi.ModTime()) < maxAge {
+			active++
+			continue
+		}
+		if err := os.Remove(filepath.Join(f.path, fi.Name())); err != nil && !os.IsNotExist(err) {
+			active++
+			continue
+		}
+		removed++
+	}
+	return removed, active, nil
+}
+
+// runJanitor removes the expired sessions periodically
+func (s *sess) runJanitor(interval time.Duration) {
+	f, ok := s.s.(fileSessionStore)
+	if !s.enabled || !ok || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		removed, active, err := f.removeExpired()
+		if err != nil {
+			s.errFn(log.Ctx{"err": err, "path": f.path})("unable to remove the expired sessions")
+		} else {
+			activeSessions.Set(int64(active))
+			s.infoFn(log.Ctx{"removed": removed, "active": active})("removed the expired sessions")
+		}
+		<-t.C
+	}
+}
+
 func initFileSession(c appConfig, path string, infoFn, errFn CtxLogFn) (sessions.Store, error) {
+	if c.SessionsPath == os.TempDir() {
+		errFn(log.Ctx{"path": path})("SESSIONS_PATH is not set, the sessions are stored in the temporary directory")
+	}
+	// NOTE(marius): the session files are encrypted only when we have both the authentication and the encryption keys
+	if !sessionsEncrypted(c.SessionKeys) {
+		if c.Env.IsProd() {
+			err := errors.Newf("SESS_ENC_KEY is not set, refusing to store unencrypted sessions in %s", path)
+			errFn(log.Ctx{"path": path})(err.Error())
+			return nil, err
+		}
+		errFn(log.Ctx{"path": path})("SESS_ENC_KEY is not set, the sessions are stored unencrypted")
+	}
 	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
-		if err := makeSessionsPath(path); err != nil {
+		if err := makeSessionsPath(path, c.SessionsFileMode); err != nil {
 			return nil, err
 		}
 	}
@@ -130,6 +339,7 @@ func initFileSession(c appConfig, path string, infoFn, errFn CtxLogFn) (sessions
 		"type":     c.SessionsBackend,
 		"env":      c.Env,
 		"path":     path,
+		"mode":     c.SessionsFileMode,
 		"keys":     hideSessionKeys(c.SessionKeys...),
 		"hostname": c.HostName,
 	})("Session settings")
@@ -143,7 +353,7 @@ func initFileSession(c appConfig, path string, infoFn, errFn CtxLogFn) (sessions
 		ss.Options.SameSite = http.SameSiteStrictMode
 	}
 	ss.MaxLength(1 << 20)
-	return ss, nil
+	return fileSessionStore{FilesystemStore: ss, path: path, mode: c.SessionsFileMode}, nil
 }
 
 func (s *sess) clear(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// accountSettingsType is the type of the actor attachment which links to the settings of a local account
+const accountSettingsType pub.ActivityVocabularyType = "AccountSettings"
+
+// AccountSettings are the preferences of an account which only change how go-littr shows the content to it,
+// and its email address. They're private, so they're kept in a Profile object describing its actor, which is
+// addressed only to the account and to the instance, and the public actor only links to it.
+type AccountSettings struct {
+	Email        string `json:"email,omitempty"`
+	HideVideo    bool   `json:"hideVideo,omitempty"`
+	HideAudio    bool   `json:"hideAudio,omitempty"`
+	HideAnimated bool   `json:"hideAnimated,omitempty"`
+	HideBots     bool   `json:"hideBots,omitempty"`
+	// Theme is the colour theme of the pages, empty for following the colours of the system
+	Theme string `json:"theme,omitempty"`
+	// QuietHours is when the notifications of the account are held, to be sent when it ends
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+}
+
+// Settings returns the settings of the account, or the default ones if it didn't change them
+func (a *Account) Settings() AccountSettings {
+	if !a.HasMetadata() {
+		return AccountSettings{}
+	}
+	return a.Metadata.Settings
+}
+
+// accountSettingsLink returns the IRI of the Profile object with the settings, from the attachments of an actor
+func accountSettingsLink(att pub.Item) pub.IRI {
+	for _, it := range attachmentItems(att) {
+		if it == nil || it.GetType() != accountSettingsType {
+			continue
+		}
+		var iri pub.IRI
+		pub.OnObject(it, func(o *pub.Object) error {
+			if o.URL != nil {
+				iri = o.URL.GetLink()
+			}
+			return nil
+		})
+		return iri
+	}
+	return ""
+}
+
+// accountSettingsAttachment returns the link to the Profile object with the settings, for the attachments
+// of an actor
+func accountSettingsAttachment(iri pub.IRI) pub.ItemCollection {
+	if len(iri) == 0 {
+		return nil
+	}
+	return pub.ItemCollection{&pub.Object{Type: accountSettingsType, URL: iri}}
+}
+
+// accountSettingsProfile returns the Profile object of the actor with the settings
+func accountSettingsProfile(actor pub.IRI, s AccountSettings) (*pub.Profile, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &pub.Profile{Type: pub.ProfileType, Describes: actor, AttributedTo: actor}
+	p.Name = pub.NaturalLanguageValuesNew()
+	p.Name.Set(pub.NilLangRef, pub.Content(accountSettingsType))
+	p.MediaType = "application/json"
+	p.Content = pub.NaturalLanguageValuesNew()
+	p.Content.Set(pub.NilLangRef, pub.Content(data))
+	p.Updated = time.Now().UTC()
+	return p, nil
+}
+
+// accountSettingsFromProfile loads the settings from the Profile object which describes an actor
+func accountSettingsFromProfile(it pub.Item) (AccountSettings, error) {
+	s := AccountSettings{}
+	if it == nil {
+		return s, errors.Newf("nil settings received")
+	}
+	err := pub.OnObject(it, func(o *pub.Object) error {
+		if o.Type != pub.ProfileType {
+			return errors.Newf("invalid settings type %s", o.Type)
+		}
+		return json.Unmarshal([]byte(o.Content.First().Value.String()), &s)
+	})
+	return s, err
+}
+
+// accountSettingsCache keeps the settings of the local accounts we loaded, by the IRIs of their actors
+type accountSettingsCache struct {
+	m sync.RWMutex
+	s map[pub.IRI]AccountSettings
+}
+
+func newAccountSettingsCache() *accountSettingsCache {
+	return &accountSettingsCache{s: make(map[pub.IRI]AccountSettings)}
+}
+
+func (c *accountSettingsCache) get(iri pub.IRI) (AccountSettings, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	s, ok := c.s[iri]
+	return s, ok
+}
+
+func (c *accountSettingsCache) set(iri pub.IRI, s AccountSettings) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.s[iri] = s
+}
+
+// SetAccountSettings replaces the settings of the local account, with an Update of their Profile object, or
+// a Create of it, and an Update of the actor linking to it, the first time the account saves them
+func (r *repository) SetAccountSettings(ctx context.Context, a Account, s AccountSettings) (Account, error) {
+	if !accountValidForC2S(&a) {
+		return a, errors.Unauthorizedf("invalid account %s", a.Handle)
+	}
+	actor := accountIRI(&a)
+	p, err := r.fedbox.Actor(ctx, actor)
+	if err != nil {
+		return a, err
+	}
+	profile, err := accountSettingsProfile(actor, s)
+	if err != nil {
+		return a, errors.Annotatef(err, "unable to marshal the account settings")
+	}
+	// NOTE(marius): the settings are addressed only to the account, and to the instance which needs them
+	// for holding the notifications during the quiet hours
+	rcpts := pub.ItemCollection{actor}
+	bcc := pub.ItemCollection{r.fedbox.Service().ID}
+	profile.To, profile.BCC = rcpts, bcc
+	act := &pub.Activity{
+		Type:    pub.UpdateType,
+		To:      rcpts,
+		BCC:     bcc,
+		Actor:   actor,
+		Object:  profile,
+		Updated: profile.Updated,
+	}
+	link := accountSettingsLink(p.Attachment)
+	if len(link) == 0 {
+		act.Type = pub.CreateType
+	}
+	profile.ID = link
+	_, ap, err := r.fedbox.ToOutbox(ctx, act)
+	if err != nil {
+		r.errFn(log.Ctx{"err": err, "account": a.Handle})("unable to save the account settings")
+		return a, err
+	}
+	r.settings.set(actor, s)
+	if len(link) == 0 {
+		link = createdIRI(ap)
+		if a, err = r.updateAccount(ctx, a, func(p *pub.Actor) {
+			p.Attachment = replaceAttachments(p.Attachment, accountSettingsType, accountSettingsAttachment(link))
+		}); err != nil {
+			return a, err
+		}
+	}
+	if a.HasMetadata() {
+		a.Metadata.Settings = s
+	}
+	return a, nil
+}
+
+// accountSettingsOf loads the settings of the account with id, for when we don't have the account loaded already
+func (r *repository) accountSettingsOf(ctx context.Context, id string) AccountSettings {
+	if s, ok := r.settings.get(pub.IRI(id)); ok {
+		return s
+	}
+	p, err := r.fedbox.Actor(ctx, pub.IRI(id))
+	if err != nil {
+		r.errFn(log.Ctx{"err": err, "account": id})("unable to load the account settings")
+		return AccountSettings{}
+	}
+	return r.loadAccountSettings(ctx, p.GetLink(), p.Attachment)
+}
+
+// loadAccountSettings loads the settings from the Profile object the attachments of the actor link to
+func (r *repository) loadAccountSettings(ctx context.Context, actor pub.IRI, att pub.Item) AccountSettings {
+	if s, ok := r.settings.get(actor); ok {
+		return s
+	}
+	s := AccountSettings{}
+	if link := accountSettingsLink(att); len(link) > 0 {
+		it, err := r.fedbox.Object(ctx, link)
+		if err == nil {
+			s, err = accountSettingsFromProfile(it)
+		}
+		if err != nil {
+			r.errFn(log.Ctx{"err": err, "account": actor, "iri": link})("unable to load the account settings")
+			return s
+		}
+	}
+	r.settings.set(actor, s)
+	return s
+}
+
+// saveSettings saves the settings of the logged account, and updates the ones of the current request
+func (h *handler) saveSettings(r *http.Request, acc *Account, s AccountSettings) error {
+	updated, err := h.storage.SetAccountSettings(r.Context(), *acc, s)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the account settings")
+		return err
+	}
+	if acc.HasMetadata() && updated.HasMetadata() {
+		acc.Metadata.Settings = updated.Metadata.Settings
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestAccountSettingsProfile(t *testing.T) {
+	actor := pub.IRI("https://example.com/actors/jane")
+	settings := AccountSettings{Email: "jane@example.com", HideVideo: true, QuietHours: &QuietHours{From: 22, To: 7, TimeZone: "UTC"}}
+
+	p, err := accountSettingsProfile(actor, settings)
+	if err != nil {
+		t.Fatalf("unable to build the settings profile: %s", err)
+	}
+	if p.Describes != actor {
+		t.Errorf("expected the profile to describe %s, got %v", actor, p.Describes)
+	}
+	data, err := pub.MarshalJSON(p)
+	if err != nil {
+		t.Fatalf("unable to marshal the settings profile: %s", err)
+	}
+	it, err := pub.UnmarshalJSON(data)
+	if err != nil {
+		t.Fatalf("unable to unmarshal the settings profile: %s", err)
+	}
+	got, err := accountSettingsFromProfile(it)
+	if err != nil {
+		t.Fatalf("unable to load the settings: %s", err)
+	}
+	if got.Email != settings.Email || !got.HideVideo || got.QuietHours == nil || *got.QuietHours != *settings.QuietHours {
+		t.Errorf("accountSettingsFromProfile() = %+v, want %+v", got, settings)
+	}
+	if _, err := accountSettingsFromProfile(&pub.Object{Type: pub.NoteType}); err == nil {
+		t.Errorf("expected the Note to not be loaded as settings")
+	}
+
+	// NOTE(marius): the public actor only links to the settings, it doesn't contain them
+	a := &pub.Actor{ID: actor, Type: pub.PersonType}
+	a.Attachment = replaceAttachments(a.Attachment, accountSettingsType, accountSettingsAttachment("https://example.com/objects/1"))
+	data, err = pub.MarshalJSON(a)
+	if err != nil {
+		t.Fatalf("unable to marshal the actor: %s", err)
+	}
+	if strings.Contains(string(data), settings.Email) || strings.Contains(string(data), "quietHours") {
+		t.Errorf("the actor contains the private settings: %s", data)
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+const (
+	shareInstanceCookie = "share_instance"
+	shareInstanceParam  = "instance"
+)
+
+var shareHostRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+(:[0-9]{1,5})?$`)
+
+// shareInstanceHost returns the host of the instance the user wants to share to. Like for the remote follows,
+// we accept the host, the URL of the instance, or the user's full handle: @user@example.com
+func shareInstanceHost(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return "", errors.BadRequestf("%q is not a valid instance", s)
+		}
+		s = u.Host
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.TrimRight(s, "/")
+	if !shareHostRe.MatchString(s) {
+		return "", errors.BadRequestf("%q is not a valid instance", s)
+	}
+	return s, nil
+}
+
+// shareTargetURL returns the link to the share page of the instance at host. Mastodon, Misskey and most of the
+// software compatible with them, accept the text and url parameters on /share.
+func shareTargetURL(host, text, link string) string {
+	q := url.Values{}
+	if len(text) > 0 {
+		q.Set("text", text)
+	}
+	q.Set("url", link)
+	return fmt.Sprintf("https://%s/share?%s", host, q.Encode())
+}
+
+// shareInstance returns the instance the user shared to last time, so we can fill it in the share form
+func shareInstance(r *http.Request) string {
+	c, err := r.Cookie(shareInstanceCookie)
+	if err != nil {
+		return ""
+	}
+	host, err := shareInstanceHost(c.Value)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// AbsoluteLink returns the link prefixed with the base URL of the instance, if it's a local one
+func AbsoluteLink(link string) string {
+	if strings.HasPrefix(link, "/") {
+		return Instance.BaseURL + link
+	}
+	return link
+}
+
+// HandleShare serves the /{hash}/share requests, which redirect to the share page of the user's home instance
+func (h *handler) HandleShare(w http.ResponseWriter, r *http.Request) {
+	c := ContextCursor(r.Context())
+	if c == nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	it := getItemFromList(HashFromString(chi.URLParam(r, "hash")), c.items)
+	if it == nil || it.Deleted() || it.Private() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	link := ItemSlugLink(it)
+	host, err := shareInstanceHost(r.URL.Query().Get(shareInstanceParam))
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, "Please enter your instance, for example: mastodon.social")
+		h.v.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareInstanceCookie,
+		Value:    host,
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, shareTargetURL(host, it.Title, AbsoluteLink(link)), http.StatusSeeOther)
+}
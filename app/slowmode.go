@@ -0,0 +1,252 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	slowModeFile        = "slow-mode.json"
+	slowModeMinInterval = time.Minute
+	slowModeMaxDuration = 30 * 24 * time.Hour
+)
+
+// slowMode limits the comments in a thread to one per user every Interval, until it expires
+type slowMode struct {
+	Interval time.Duration `json:"interval"`
+	Until    time.Time     `json:"until"`
+	By       string        `json:"by"`
+}
+
+// Active returns if the slow mode didn't expire yet
+func (s slowMode) Active() bool {
+	return time.Now().Before(s.Until)
+}
+
+// slowModes are the threads in slow mode, by the hash of their top item, and the time of the last comment
+// of the users in them. The latter is kept only in memory, after a restart everybody can comment right away.
+type slowModes struct {
+	m        sync.RWMutex
+	path     string
+	interval time.Duration
+	duration time.Duration
+	threads  map[Hash]slowMode
+	last     map[string]time.Time
+}
+
+func loadSlowModes(path string, interval, duration time.Duration) (*slowModes, error) {
+	if interval < slowModeMinInterval {
+		interval = slowModeMinInterval
+	}
+	if duration <= 0 {
+		duration = 24 * time.Hour
+	}
+	s := &slowModes{
+		path:     path,
+		interval: interval,
+		duration: duration,
+		threads:  make(map[Hash]slowMode),
+		last:     make(map[string]time.Time),
+	}
+	return s, loadJSON(path, &s.threads)
+}
+
+// Get returns the slow mode of the thread, or nil if it's not in slow mode
+func (s *slowModes) Get(thread Hash) *slowMode {
+	if s == nil {
+		return nil
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if sm, ok := s.threads[thread]; ok && sm.Active() {
+		return &sm
+	}
+	return nil
+}
+
+// Set puts the thread in slow mode, an interval of 0 stops it
+func (s *slowModes) Set(thread Hash, sm slowMode) error {
+	if s == nil {
+		return errors.Errorf("slow mode is not available")
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if sm.Interval <= 0 {
+		delete(s.threads, thread)
+	} else {
+		if sm.Interval < slowModeMinInterval {
+			sm.Interval = slowModeMinInterval
+		}
+		s.threads[thread] = sm
+	}
+	// NOTE(marius): the expired threads get removed when saving
+	for h, t := range s.threads {
+		if !t.Active() {
+			delete(s.threads, h)
+		}
+	}
+	return s.save()
+}
+
+// Every returns the time between the comments of a user, for the templates
+func (s slowMode) Every() string {
+	return fmtWait(s.Interval)
+}
+
+func slowModeKey(thread, account Hash) string {
+	return fmt.Sprintf("%s:%s", thread, account)
+}
+
+// Wait returns how long the account needs to wait before commenting again in the thread
+func (s *slowModes) Wait(thread, account Hash) time.Duration {
+	sm := s.Get(thread)
+	if sm == nil {
+		return 0
+	}
+	s.m.RLock()
+	last, ok := s.last[slowModeKey(thread, account)]
+	s.m.RUnlock()
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(last.Add(sm.Interval)); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Record saves the time of the comment of the account in the thread, if it's in slow mode
+func (s *slowModes) Record(thread, account Hash) {
+	if s.Get(thread) == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	for k, t := range s.last {
+		if now.Sub(t) > slowModeMaxDuration {
+			delete(s.last, k)
+		}
+	}
+	s.last[slowModeKey(thread, account)] = now
+}
+
+// save writes the threads in slow mode to disk, it needs to be called with the lock held
+func (s *slowModes) save() error {
+	return saveJSON(s.path, s.threads)
+}
+
+// threadHash returns the hash of the top item of the thread the item is part of
+func threadHash(i *Item) Hash {
+	if i == nil {
+		return AnonymousHash
+	}
+	if i.OP.IsValid() {
+		return i.OP.Hash
+	}
+	if i.Parent.IsValid() {
+		return i.Parent.Hash
+	}
+	return i.Hash
+}
+
+// fmtWait returns the duration to wait in minutes, or seconds under a minute
+func fmtWait(d time.Duration) string {
+	if d < time.Minute {
+		s := int((d + time.Second - 1) / time.Second)
+		return fmt.Sprintf("%d %s", s, pluralize(float64(s), "second"))
+	}
+	m := int((d + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%d %s", m, pluralize(float64(m), "minute"))
+}
+
+type slowModeModel struct {
+	Title    string
+	Hash     Hash
+	Content  *Item
+	SlowMode *slowMode
+	Interval time.Duration
+	Duration time.Duration
+}
+
+func (m *slowModeModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (slowModeModel) Template() string {
+	return "slow-mode"
+}
+
+func (m *slowModeModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleSlowModeForm serves the /{hash}/slow GET requests, with the form for the moderators
+// to put the thread in slow mode
+func (h *handler) HandleSlowModeForm(w http.ResponseWriter, r *http.Request) {
+	s := h.storage.slow
+	m := &slowModeModel{Hash: HashFromString(chi.URLParam(r, "hash")), Interval: s.interval, Duration: s.duration}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || m.Content.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	if m.SlowMode = s.Get(threadHash(m.Content)); m.SlowMode != nil {
+		m.Interval = m.SlowMode.Interval
+	}
+	m.Title = fmt.Sprintf("Slow mode: %s", m.Content.Title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleSlowMode handles the /{hash}/slow POST requests, which start or stop the slow mode of the thread
+func (h *handler) HandleSlowMode(w http.ResponseWriter, r *http.Request) {
+	var it *Item
+	if c := ContextCursor(r.Context()); c != nil {
+		it = getItemFromList(HashFromString(chi.URLParam(r, "hash")), c.items)
+	}
+	if it == nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	thread := threadHash(it)
+	mod := loggedAccount(r)
+	sm := slowMode{By: mod.Handle}
+	if r.PostFormValue("action") != "stop" {
+		minutes, _ := strconv.Atoi(r.PostFormValue("interval"))
+		hours, _ := strconv.Atoi(r.PostFormValue("duration"))
+		sm.Interval = time.Duration(minutes) * time.Minute
+		duration := time.Duration(hours) * time.Hour
+		if duration <= 0 || duration > slowModeMaxDuration {
+			duration = h.storage.slow.duration
+		}
+		sm.Until = time.Now().UTC().Add(duration)
+		if sm.Interval <= 0 {
+			h.v.addFlashMessage(Error, w, r, "The slow mode needs the number of minutes between the comments.")
+			h.v.Redirect(w, r, ItemPermaLink(it)+"/slow", http.StatusSeeOther)
+			return
+		}
+	}
+	lCtx := log.Ctx{"thread": thread, "moderator": mod.Handle, "interval": sm.Interval, "until": sm.Until}
+	if err := h.storage.slow.Set(thread, sm); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the slow mode")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the slow mode"))
+		return
+	}
+	h.infoFn(lCtx)("slow mode changed")
+	if sm.Interval > 0 {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("The thread is in slow mode, one comment every %s.", fmtWait(sm.Interval)))
+	} else {
+		h.v.addFlashMessage(Success, w, r, "The slow mode was stopped.")
+	}
+	h.v.Redirect(w, r, ItemPermaLink(it), http.StatusSeeOther)
+}
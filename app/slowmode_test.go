@@ -0,0 +1,56 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowModes(t *testing.T) {
+	s, err := loadSlowModes("", 10*time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unable to load the slow modes: %s", err)
+	}
+	thread, other := HashFromString("f00f00f00"), HashFromString("b4db4db4d")
+	user := HashFromString("c0ffee")
+
+	s.Record(thread, user)
+	if w := s.Wait(thread, user); w != 0 {
+		t.Errorf("the comments were limited before the slow mode started, wait %s", w)
+	}
+	if err := s.Set(thread, slowMode{Interval: 10 * time.Minute, Until: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("unable to start the slow mode: %s", err)
+	}
+	if err := s.Set(other, slowMode{Interval: time.Minute, Until: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("unable to start the slow mode: %s", err)
+	}
+	s.Record(thread, user)
+	if w := s.Wait(thread, user); w <= 9*time.Minute || w > 10*time.Minute {
+		t.Errorf("expected to wait about 10 minutes, got %s", w)
+	}
+	s.Record(other, user)
+	if w := s.Wait(other, user); w != 0 {
+		t.Errorf("the expired slow mode limits the comments, wait %s", w)
+	}
+	if s.Get(thread) == nil || s.Get(other) != nil {
+		t.Errorf("expected only the active slow mode to be kept")
+	}
+	if err := s.Set(thread, slowMode{}); err != nil {
+		t.Fatalf("unable to stop the slow mode: %s", err)
+	}
+	if w := s.Wait(thread, user); w != 0 {
+		t.Errorf("the stopped slow mode limits the comments, wait %s", w)
+	}
+}
+
+func TestFmtWait(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second:               "30 seconds",
+		time.Minute:                    "1 minute",
+		9*time.Minute + 10*time.Second: "10 minutes",
+	}
+	for d, want := range tests {
+		if got := fmtWait(d); got != want {
+			t.Errorf("fmtWait(%s): expected %q, got %q", d, want, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi"
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxSlugLength = 60
+
+// itemSubPaths are the pages under the item URLs, the slugs can't use them
+var itemSubPaths = []string{"yay", "nay", "bad", "block", "edit", "rm", "reader", "export", "print", "qr", "share", "snapshot", "translate", "restore", "remove", "slow", "rsvp", "history"}
+
+// sluggify returns the URL slug for s: the letters without diacritics and the digits, lower cased and
+// separated by dashes
+func sluggify(s string) string {
+	b := strings.Builder{}
+	dash := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		default:
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > maxSlugLength/2 {
+			slug = slug[:i]
+		}
+	}
+	return strings.Trim(slug, "-")
+}
+
+// itemSlug returns the slug of the top level items with titles
+func itemSlug(i *Item) string {
+	if i == nil || !i.IsTop() || i.Deleted() || len(i.Title) == 0 {
+		return ""
+	}
+	slug := sluggify(i.Title)
+	if stringInSlice(itemSubPaths)(slug) {
+		return ""
+	}
+	return slug
+}
+
+// ItemSlugLink returns the canonical link of the item, which ends in the slug of its title when we host it
+func ItemSlugLink(i *Item) string {
+	link := ItemPermaLink(i)
+	if !strings.HasPrefix(link, "/") {
+		return link
+	}
+	if slug := itemSlug(i); len(slug) > 0 {
+		return link + "/" + slug
+	}
+	return link
+}
+
+// ItemSlugMw redirects the item pages to their canonical URL. The hash identifies the item, the slug is only
+// for readability, so the links with a missing or outdated slug keep working.
+func ItemSlugMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := ContextCursor(r.Context())
+		hash := HashFromString(chi.URLParam(r, "hash"))
+		if c == nil || !hash.IsValid() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		it := getItemFromList(hash, c.items)
+		if it == nil || chi.URLParam(r, "slug") == itemSlug(it) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		link := ItemSlugLink(it)
+		if !strings.HasPrefix(link, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(r.URL.RawQuery) > 0 {
+			link += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, link, http.StatusMovedPermanently)
+	})
+}
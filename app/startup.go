@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	backendRetryMin     = time.Second
+	backendRetryMax     = 5 * time.Minute
+	backendLoadTimeOut  = 30 * time.Second
+	oauthClientProvider = "fedbox"
+)
+
+// retryWithBackoff calls fn until it succeeds, waiting exponentially longer between the tries
+func retryWithBackoff(fn func(context.Context) error, errFn func(error, time.Duration)) {
+	wait := backendRetryMin
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), backendLoadTimeOut)
+		err := fn(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		errFn(err, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > backendRetryMax {
+			wait = backendRetryMax
+		}
+	}
+}
+
+// loadService loads the fedbox service actor, if we didn't manage to do it already
+func (h *handler) loadService(ctx context.Context) error {
+	if h.storage.fedbox.pub != nil {
+		return nil
+	}
+	return h.storage.fedbox.loadService(ctx)
+}
+
+// loadApplication loads the actor of the OAuth2 client and gets a token for it
+// We need it for creating accounts, and for the activities the instance itself generates.
+func (h *handler) loadApplication(ctx context.Context) error {
+	config := GetOauth2Config(oauthClientProvider, h.conf.BaseURL)
+	lCtx := log.Ctx{
+		"provider":    oauthClientProvider,
+		"client":      config.ClientID,
+		"pw":          hideString(config.ClientSecret),
+		"authURL":     config.Endpoint.AuthURL,
+		"tokURL":      config.Endpoint.TokenURL,
+		"redirectURL": config.RedirectURL,
+	}
+	oauth, err := h.storage.fedbox.Actor(ctx, actors.IRI(h.storage.BaseURL()).AddPath(config.ClientID))
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the OAuth2 client's actor")
+	}
+	if oauth == nil {
+		return errors.NotFoundf("OAuth2 client's actor")
+	}
+	app := new(Account)
+	app.FromActivityPub(oauth)
+
+	handle := oauth.ID.String()
+	lCtx["handle"] = handle
+	tok, err := config.PasswordCredentialsToken(ctx, handle, config.ClientSecret)
+	if err != nil {
+		return errors.Annotatef(err, "unable to authenticate the OAuth2 client")
+	}
+	if tok == nil {
+		return errors.Newf("unable to load a valid OAuth2 token for client")
+	}
+	app.Metadata.OAuth.Provider = oauthClientProvider
+	app.Metadata.OAuth.Token = tok
+	h.storage.app = app
+	h.infoFn(lCtx, log.Ctx{
+		"token":   hideString(tok.AccessToken),
+		"type":    tok.TokenType,
+		"refresh": hideString(tok.RefreshToken),
+	})("Loaded valid OAuth2 token for client")
+	return nil
+}
+
+// startBackend loads what we need from fedbox, retrying until it becomes available,
+// and afterwards it starts the background workers that depend on it
+func (h *handler) startBackend() {
+	if h.storage == nil || h.storage.fedbox == nil {
+		h.errFn()("Invalid ActivityPub service configuration")
+		return
+	}
+	retryWithBackoff(h.loadService, func(err error, wait time.Duration) {
+		h.errFn(log.Ctx{"err": err, "retry": wait})("Failed to load the ActivityPub service")
+	})
+	retryWithBackoff(h.storage.loadBoards, func(err error, wait time.Duration) {
+		h.errFn(log.Ctx{"err": err, "retry": wait})("Failed to load the boards")
+	})
+	retryWithBackoff(h.storage.loadWiki, func(err error, wait time.Duration) {
+		h.errFn(log.Ctx{"err": err, "retry": wait})("Failed to load the wiki pages")
+	})
+	retryWithBackoff(h.storage.loadEvents, func(err error, wait time.Duration) {
+		h.errFn(log.Ctx{"err": err, "retry": wait})("Failed to load the upcoming events")
+	})
+	c := h.conf
+	go h.storage.runIndexer(c.IndexRefreshInterval, c.IndexMaxItems)
+	go h.storage.search.run()
+	go h.storage.fill.run()
+	go h.storage.peers.run()
+	go h.storage.runPruner(c.PruneInterval, c.Retention)
+	go h.storage.runStats()
+
+	if len(GetOauth2Config(oauthClientProvider, h.conf.BaseURL).ClientID) == 0 {
+		h.errFn()("Failed to load OAuth2 ClientID, account creation is disabled")
+		return
+	}
+	retryWithBackoff(h.loadApplication, func(err error, wait time.Duration) {
+		h.errFn(log.Ctx{"err": err, "retry": wait})("Failed to authenticate OAuth2 client")
+	})
+	atomic.StoreInt32(&h.ready, 1)
+	h.infoFn()("The ActivityPub service is available")
+
+	go h.storage.runFeedBots()
+	go h.storage.runPendingDeletes()
+	go h.storage.runDigests()
+	go h.storage.runQuietHours()
+
+	h.storage.SubscribeRelays(context.Background())
+}
+
+// Ready returns if we loaded the application actor, which is needed for creating accounts
+func (h *handler) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"fmt"
+	stdhtml "html"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	statsFile = "stats.json"
+	// statsDays is how many days of statistics we keep, and show on the /stats page
+	statsDays            = 90
+	statsCollectInterval = time.Hour
+	statsDateFmt         = "2006-01-02"
+
+	statsChartWidth  = 720
+	statsChartHeight = 160
+	// statsChartPadding is the space on the left of the bars, for the labels of the scale
+	statsChartPadding = 40
+)
+
+// statsDay are the statistics of a day: the submissions and comments of the local accounts, how many
+// of them posted, and how many instances we federated with
+type statsDay struct {
+	Date     string `json:"date"`
+	Posts    int    `json:"posts"`
+	Comments int    `json:"comments"`
+	Active   int    `json:"active"`
+	Peers    int    `json:"peers"`
+}
+
+// instanceStats keeps the daily statistics of the instance, the local index only has the recent items, and
+// the peers are forgotten on restart, so we can't compute them again for the past days
+type instanceStats struct {
+	m    sync.RWMutex
+	path string
+	Days map[string]statsDay `json:"days"`
+}
+
+func loadInstanceStats(path string) (*instanceStats, error) {
+	s := &instanceStats{path: path, Days: make(map[string]statsDay)}
+	return s, loadJSON(path, s)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Record counts the local items of the entries by day, and the peers for the current day. The counts only
+// grow, as the older items get pruned from the local index.
+func (s *instanceStats) Record(entries []indexEntry, peers int, now time.Time) error {
+	if s == nil {
+		return nil
+	}
+	since := now.AddDate(0, 0, -statsDays)
+	days := make(map[string]statsDay)
+	active := make(map[string]map[Hash]bool)
+	for _, e := range entries {
+		if !e.SubmittedAt.After(since) || !HostIsLocal(e.IRI.String()) {
+			continue
+		}
+		date := e.SubmittedAt.UTC().Format(statsDateFmt)
+		d := days[date]
+		if e.IsTop() {
+			d.Posts++
+		} else {
+			d.Comments++
+		}
+		if e.Author.IsValid() {
+			if active[date] == nil {
+				active[date] = make(map[Hash]bool)
+			}
+			active[date][e.Author] = true
+			d.Active = len(active[date])
+		}
+		days[date] = d
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	today := now.UTC().Format(statsDateFmt)
+	for date, d := range days {
+		old := s.Days[date]
+		s.Days[date] = statsDay{
+			Date:     date,
+			Posts:    maxInt(old.Posts, d.Posts),
+			Comments: maxInt(old.Comments, d.Comments),
+			Active:   maxInt(old.Active, d.Active),
+			Peers:    old.Peers,
+		}
+	}
+	d := s.Days[today]
+	d.Date = today
+	d.Peers = maxInt(d.Peers, peers)
+	s.Days[today] = d
+	for date := range s.Days {
+		if date < since.UTC().Format(statsDateFmt) {
+			delete(s.Days, date)
+		}
+	}
+	return s.save()
+}
+
+// Series returns the statistics of the last days, oldest first, with the days we have nothing for as zeros
+func (s *instanceStats) Series(days int, now time.Time) []statsDay {
+	res := make([]statsDay, 0, days)
+	if s == nil {
+		return res
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for i := days - 1; i >= 0; i-- {
+		date := now.UTC().AddDate(0, 0, -i).Format(statsDateFmt)
+		d := s.Days[date]
+		d.Date = date
+		res = append(res, d)
+	}
+	return res
+}
+
+// save writes the statistics to disk, it needs to be called with the lock held
+func (s *instanceStats) save() error {
+	return saveJSON(s.path, s)
+}
+
+// collectStats records the statistics of the local index and of the peers
+func (r *repository) collectStats() {
+	entries := r.index.Select(func(indexEntry) bool { return true }, nil)
+	peers := 0
+	for _, p := range r.peers.List() {
+		if !p.Blocked {
+			peers++
+		}
+	}
+	if err := r.stats.Record(entries, peers, time.Now()); err != nil {
+		r.errFn(log.Ctx{"err": err})("unable to save the instance statistics")
+	}
+}
+
+// runStats collects the statistics of the instance every hour
+func (r *repository) runStats() {
+	t := time.NewTicker(statsCollectInterval)
+	defer t.Stop()
+	for {
+		r.collectStats()
+		<-t.C
+	}
+}
+
+// statsChart renders the values as a bar chart, the title of each bar has its date and value
+func statsChart(label string, days []statsDay, value func(statsDay) int) template.HTML {
+	top := 0
+	for _, d := range days {
+		top = maxInt(top, value(d))
+	}
+	s := strings.Builder{}
+	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" role="img" aria-label="%s">`,
+		statsChartWidth, statsChartHeight+20, stdhtml.EscapeString(label))
+	fmt.Fprintf(&s, `<title>%s</title>`, stdhtml.EscapeString(label))
+	fmt.Fprintf(&s, `<text x="%d" y="12" text-anchor="end">%d</text>`, statsChartPadding-6, top)
+	fmt.Fprintf(&s, `<text x="%d" y="%d" text-anchor="end">0</text>`, statsChartPadding-6, statsChartHeight)
+	fmt.Fprintf(&s, `<line x1="%d" y1="%d" x2="%d" y2="%d"/>`, statsChartPadding, statsChartHeight, statsChartWidth, statsChartHeight)
+	if len(days) > 0 {
+		w := float64(statsChartWidth-statsChartPadding) / float64(len(days))
+		for i, d := range days {
+			v := value(d)
+			h := 0.0
+			if top > 0 {
+				h = float64(v) / float64(top) * float64(statsChartHeight-4)
+			}
+			fmt.Fprintf(&s, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f"><title>%s: %d</title></rect>`,
+				float64(statsChartPadding)+float64(i)*w+w*.1, float64(statsChartHeight)-h, w*.8, h, d.Date, v)
+		}
+		fmt.Fprintf(&s, `<text x="%d" y="%d">%s</text>`, statsChartPadding, statsChartHeight+16, days[0].Date)
+		fmt.Fprintf(&s, `<text x="%d" y="%d" text-anchor="end">%s</text>`, statsChartWidth, statsChartHeight+16, days[len(days)-1].Date)
+	}
+	s.WriteString(`</svg>`)
+	return template.HTML(s.String())
+}
+
+type statsGraph struct {
+	Title   string
+	Summary string
+	Chart   template.HTML
+}
+
+type statsModel struct {
+	Title  string
+	Days   int
+	Graphs []statsGraph
+}
+
+func (m *statsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (statsModel) Template() string {
+	return "stats"
+}
+
+// HandleStats serves the /stats page, with the charts of the submissions, the active accounts and the
+// instances we federated with in the last days
+func (h *handler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	days := h.storage.stats.Series(statsDays, time.Now())
+	graph := func(title string, value func(statsDay) int, sum bool) statsGraph {
+		total, top := 0, 0
+		for _, d := range days {
+			total += value(d)
+			top = maxInt(top, value(d))
+		}
+		g := statsGraph{Title: title, Chart: statsChart(title, days, value)}
+		if sum {
+			g.Summary = fmt.Sprintf("%d in the last %d days", total, len(days))
+		} else {
+			g.Summary = fmt.Sprintf("at most %d in a day", top)
+		}
+		return g
+	}
+	m := &statsModel{
+		Title: "Statistics",
+		Days:  statsDays,
+		Graphs: []statsGraph{
+			graph("Submissions per day", func(d statsDay) int { return d.Posts }, true),
+			graph("Comments per day", func(d statsDay) int { return d.Comments }, true),
+			graph("Active accounts per day", func(d statsDay) int { return d.Active }, false),
+			graph("Instances we federate with", func(d statsDay) int { return d.Peers }, false),
+		},
+	}
+	w.Header().Set("Cache-Control", "max-age=600")
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestInstanceStatsRecord(t *testing.T) {
+	Instance.Conf = &config.Configuration{HostName: "littr.git"}
+	s, _ := loadInstanceStats("")
+	now := time.Date(2021, 6, 10, 12, 0, 0, 0, time.UTC)
+	op := indexEntry{Hash: testHash(1), IRI: "https://littr.git/objects/1", Author: testHash(10), SubmittedAt: now.Add(-time.Hour)}
+	entries := []indexEntry{
+		op,
+		{Hash: testHash(2), IRI: "https://littr.git/objects/2", OP: op.Hash, Author: testHash(11), SubmittedAt: now.Add(-time.Hour)},
+		{Hash: testHash(3), IRI: "https://littr.git/objects/3", OP: op.Hash, Author: testHash(10), SubmittedAt: now.Add(-time.Hour)},
+		{Hash: testHash(4), IRI: "https://littr.git/objects/4", Author: testHash(12), SubmittedAt: now.AddDate(0, 0, -1)},
+		{Hash: testHash(5), IRI: "https://example.com/objects/5", Author: testHash(13), SubmittedAt: now.Add(-time.Hour)},
+	}
+	if err := s.Record(entries, 4, now); err != nil {
+		t.Fatalf("unable to record the statistics: %s", err)
+	}
+	// NOTE(marius): the pruned items and the forgotten peers don't lower the counts
+	if err := s.Record(entries[3:], 2, now); err != nil {
+		t.Fatalf("unable to record the statistics: %s", err)
+	}
+	days := s.Series(3, now)
+	if len(days) != 3 {
+		t.Fatalf("expected 3 days, got %d", len(days))
+	}
+	want := []statsDay{
+		{Date: "2021-06-08"},
+		{Date: "2021-06-09", Posts: 1, Active: 1},
+		{Date: "2021-06-10", Posts: 1, Comments: 2, Active: 2, Peers: 4},
+	}
+	for i, d := range days {
+		if d != want[i] {
+			t.Errorf("day %d: expected %+v, got %+v", i, want[i], d)
+		}
+	}
+	if svg := statsChart("Submissions", days, func(d statsDay) int { return d.Posts }); !strings.Contains(string(svg), "<title>2021-06-10: 1</title>") {
+		t.Errorf("expected the chart to have the value of the last day, got %s", svg)
+	}
+}
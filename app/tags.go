@@ -14,6 +14,10 @@ import (
 const TagMention = "mention"
 const TagTag = "tag"
 
+// hashtagType is the type Mastodon and the other microblogging software expect for the hashtags of the objects,
+// they ignore the tags without it
+const hashtagType pub.ActivityVocabularyType = "Hashtag"
+
 type Tag struct {
 	Hash        Hash          `json:"hash"`
 	Type        string        `json:"-"`
@@ -38,6 +42,31 @@ func (c TagCollection) Contains(t Tag) bool {
 	return false
 }
 
+// FollowedTag represents a tag an account is following, and the Follow activity that created the relationship
+type FollowedTag struct {
+	Name   string
+	IRI    pub.IRI
+	Follow pub.IRI
+}
+
+type FollowedTags []FollowedTag
+
+// Get returns the followed tag with the corresponding name
+func (f FollowedTags) Get(name string) (FollowedTag, bool) {
+	name = strings.TrimLeft(name, "#")
+	for _, t := range f {
+		if strings.EqualFold(strings.TrimLeft(t.Name, "#"), name) {
+			return t, true
+		}
+	}
+	return FollowedTag{}, false
+}
+
+func (f FollowedTags) Contains(name string) bool {
+	_, ok := f.Get(name)
+	return ok
+}
+
 func mimeTypeTagReplace(m string, t Tag) string {
 	var cls string
 
@@ -209,3 +238,76 @@ func getTagFromBytes(d []byte) Tag {
 	}
 	return t
 }
+
+// tagName returns the name of an ActivityPub tag, with its leading "#"
+func tagName(it pub.Item) string {
+	var name string
+	pub.OnObject(it, func(o *pub.Object) error {
+		name = o.Name.First().Value.String()
+		return nil
+	})
+	return name
+}
+
+// appendAPHashtag adds the hashtag to the tags of the object, if it doesn't have it already
+func appendAPHashtag(o *pub.Object, tag string) {
+	if o.Tag == nil {
+		o.Tag = make(pub.ItemCollection, 0)
+	}
+	name := pub.Content("#" + tag)
+	for _, t := range o.Tag {
+		if t != nil && t.GetType() != pub.MentionType && strings.EqualFold(tagName(t), string(name)) {
+			return
+		}
+	}
+	o.Tag.Append(pub.Object{
+		Type: hashtagType,
+		URL:  tagCollectionIRI(tag),
+		To:   pub.ItemCollection{pub.PublicNS},
+		Name: pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: name}},
+	})
+}
+
+// tagCollectionIRI returns the IRI of the items tagged with tag, it's the one of the tag's page, which serves
+// them as an OrderedCollection to the ActivityPub clients
+func tagCollectionIRI(tag string) pub.IRI {
+	return pub.IRI(fmt.Sprintf("%s/t/%s", Instance.BaseURL, url.PathEscape(strings.TrimLeft(tag, "#"))))
+}
+
+func newestFirst(a, b indexEntry) bool {
+	return a.SubmittedAt.After(b.SubmittedAt)
+}
+
+// tagCollection returns the tag collection, or its page after the received hash when page is set.
+// The collection only has the public items of the local index, newest first.
+func (r *repository) tagCollection(tag string, page bool, after Hash) pub.Item {
+	tag = strings.ToLower(strings.TrimLeft(tag, "#"))
+	entries := r.index.Select(func(e indexEntry) bool { return e.HasTag(tag) }, newestFirst)
+	id := tagCollectionIRI(tag)
+	first := pub.IRI(fmt.Sprintf("%s?page=true", id))
+	if !page {
+		return &pub.OrderedCollection{
+			ID:         id,
+			Type:       pub.OrderedCollectionType,
+			TotalItems: uint(len(entries)),
+			First:      first,
+		}
+	}
+	entries, next, _ := pageEntries(entries, after, Hash{}, MaxContentItems)
+	p := &pub.OrderedCollectionPage{
+		ID:           first,
+		Type:         pub.OrderedCollectionPageType,
+		PartOf:       id,
+		OrderedItems: make(pub.ItemCollection, 0, len(entries)),
+	}
+	if after.IsValid() {
+		p.ID = pub.IRI(fmt.Sprintf("%s&after=%s", first, after))
+	}
+	if next.IsValid() {
+		p.Next = pub.IRI(fmt.Sprintf("%s&after=%s", first, next))
+	}
+	for _, e := range entries {
+		p.OrderedItems = append(p.OrderedItems, e.IRI)
+	}
+	return p
+}
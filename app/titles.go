@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// titleRules are the patterns removed from the titles of the submissions, like the " | Site Name" suffixes
+// or the "BREAKING:" prefixes
+type titleRules []*regexp.Regexp
+
+func newTitleRules(patterns []string) (titleRules, error) {
+	rules := make(titleRules, 0, len(patterns))
+	var invalid []string
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			invalid = append(invalid, p)
+			continue
+		}
+		rules = append(rules, re)
+	}
+	if len(invalid) > 0 {
+		return rules, errors.Errorf("invalid title patterns: %s", strings.Join(invalid, " "))
+	}
+	return rules, nil
+}
+
+// Normalize collapses the whitespace of the title and removes the parts matching the rules.
+// If nothing would remain of it, the title is returned with just the whitespace collapsed.
+func (t titleRules) Normalize(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	res := title
+	for _, re := range t {
+		res = strings.Join(strings.Fields(re.ReplaceAllString(res, "")), " ")
+	}
+	if len(res) == 0 {
+		return title
+	}
+	return res
+}
+
+// loadPageTitle returns the normalized title of the page at u, from its og:title or <title>
+func (r *repository) loadPageTitle(ctx context.Context, u string) (string, error) {
+	uu, err := url.Parse(u)
+	if err != nil || (uu.Scheme != "http" && uu.Scheme != "https") || len(uu.Host) == 0 {
+		return "", errors.BadRequestf("%q is not a valid URL", u)
+	}
+	ctx, cancel := context.WithTimeout(ctx, readerLoadTimeOut)
+	defer cancel()
+	art, res, err := fetchArticle(ctx, r.reader.client, uu.String())
+	if isPrivateAddress(err) {
+		return "", errors.BadRequestf("%q is not a public URL", u)
+	}
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK || len(art.Title) == 0 {
+		return "", errors.NotFoundf("title")
+	}
+	return r.titles.Normalize(art.Title), nil
+}
+
+// HandleSubmitTitle serves the /submit/title?url= requests, with the title of the page the user is submitting,
+// so the submit form can offer it
+func (h *handler) HandleSubmitTitle(w http.ResponseWriter, r *http.Request) {
+	u := strings.TrimSpace(r.URL.Query().Get("url"))
+	title, err := h.storage.loadPageTitle(r.Context(), u)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		if !errors.IsNotFound(err) && !errors.IsBadRequest(err) {
+			h.errFn(log.Ctx{"err": err, "url": u})("unable to load the page title")
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"unable to load the title of the page"}`))
+		return
+	}
+	dat, _ := json.Marshal(struct {
+		Title string `json:"title"`
+	}{title})
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/go-ap/errors"
+	"golang.org/x/oauth2"
+)
+
+// errNoTokenKeys is returned when saving an OAuth2 token without an encryption key, like for the file sessions
+// we refuse to keep them on disk unencrypted
+var errNoTokenKeys = errors.Newf("SESS_ENC_KEY is not set, refusing to store the OAuth2 tokens unencrypted")
+
+// tokenKeys encrypt the OAuth2 tokens we keep on disk for acting as the accounts later: for the posting addresses,
+// the held items and the pending deletes. They're derived from the session encryption keys, the first one
+// seals the tokens and all of them can open them, so they get rotated together with the session keys.
+type tokenKeys [][]byte
+
+func newTokenKeys(sessionKeys [][]byte) tokenKeys {
+	keys := make(tokenKeys, 0)
+	// NOTE(marius): the session keys are pairs of authentication and encryption keys
+	for i := 1; i < len(sessionKeys); i += 2 {
+		if len(sessionKeys[i]) == 0 {
+			continue
+		}
+		mac := hmac.New(sha256.New, sessionKeys[i])
+		mac.Write([]byte("oauth2 tokens"))
+		keys = append(keys, mac.Sum(nil))
+	}
+	return keys
+}
+
+func tokenCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the encrypted token, encoded for saving it in the JSON files
+func (k tokenKeys) seal(tok *oauth2.Token) (string, error) {
+	if tok == nil {
+		return "", nil
+	}
+	if len(k) == 0 {
+		return "", errNoTokenKeys
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+	c, err := tokenCipher(k[0])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(c.Seal(nonce, nonce, data, nil)), nil
+}
+
+// open returns the token sealed with any of the keys
+func (k tokenKeys) open(sealed string) (*oauth2.Token, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range k {
+		c, err := tokenCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < c.NonceSize() {
+			break
+		}
+		plain, err := c.Open(nil, data[:c.NonceSize()], data[c.NonceSize():], nil)
+		if err != nil {
+			continue
+		}
+		tok := new(oauth2.Token)
+		return tok, json.Unmarshal(plain, tok)
+	}
+	return nil, errors.Newf("unable to decrypt the token with the session keys")
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+var testTokenKeys = newTokenKeys([][]byte{[]byte("0123456789abcdef"), []byte("fedcba9876543210")})
+
+func TestTokenKeys(t *testing.T) {
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	sealed, err := testTokenKeys.seal(tok)
+	if err != nil {
+		t.Fatalf("unable to seal the token: %s", err)
+	}
+	opened, err := testTokenKeys.open(sealed)
+	if err != nil || opened.AccessToken != tok.AccessToken || opened.RefreshToken != tok.RefreshToken {
+		t.Errorf("unable to open the sealed token: %v %v", opened, err)
+	}
+
+	rotated := newTokenKeys([][]byte{[]byte("0123456789abcdef"), []byte("abcdefabcdefabcd"), []byte("0123456789abcdef"), []byte("fedcba9876543210")})
+	if opened, err := rotated.open(sealed); err != nil || opened.AccessToken != tok.AccessToken {
+		t.Errorf("unable to open the token sealed with the previous key: %v %v", opened, err)
+	}
+	other := newTokenKeys([][]byte{[]byte("0123456789abcdef"), []byte("abcdefabcdefabcd")})
+	if _, err := other.open(sealed); err == nil {
+		t.Errorf("the token was opened with another key")
+	}
+	if _, err := newTokenKeys([][]byte{[]byte("0123456789abcdef"), nil}).seal(tok); err != errNoTokenKeys {
+		t.Errorf("the token was sealed without an encryption key: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// SessionTopWindowKey holds the last time window the current user has selected for the /top listing
+const SessionTopWindowKey = "__top_window"
+
+const (
+	topDay   = "day"
+	topWeek  = "week"
+	topMonth = "month"
+	topYear  = "year"
+	topAll   = "all"
+
+	defaultTopWindow = topDay
+)
+
+// TopWindows are the time windows valid for the /top listing, in the order they're shown
+var TopWindows = []string{topDay, topWeek, topMonth, topYear, topAll}
+
+func validTopWindow(t string) bool {
+	return stringInSlice(TopWindows)(t)
+}
+
+// topWindowStart returns the oldest submission date included in the window
+func topWindowStart(t string, now time.Time) time.Time {
+	switch t {
+	case topDay:
+		return now.Add(-24 * time.Hour)
+	case topWeek:
+		return now.AddDate(0, 0, -7)
+	case topMonth:
+		return now.AddDate(0, -1, 0)
+	case topYear:
+		return now.AddDate(-1, 0, 0)
+	}
+	return time.Time{}
+}
+
+func topWindowTitle(t string) string {
+	if t == topAll {
+		return "Top of all time"
+	}
+	return "Top of the " + t
+}
+
+func ContextTopWindow(ctx context.Context) string {
+	t, _ := ctx.Value(TopWindowCtxtKey).(string)
+	if !validTopWindow(t) {
+		return defaultTopWindow
+	}
+	return t
+}
+
+// TopWindowMw loads the time window for the /top listing from the "t" URL parameter,
+// falling back to the one the user selected previously.
+func (h *handler) TopWindowMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := r.URL.Query().Get("t")
+		s, err := h.v.s.get(w, r)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err})("unable to load session")
+		}
+		if s != nil {
+			if validTopWindow(t) {
+				if prev, _ := s.Values[SessionTopWindowKey].(string); prev != t {
+					s.Values[SessionTopWindowKey] = t
+					// NOTE(marius): the session is saved only after the listing is rendered,
+					// which is too late for setting the cookie for new sessions
+					if err := h.v.s.save(w, r); err != nil {
+						h.errFn(log.Ctx{"err": err})("unable to save the top window in the session")
+					}
+				}
+			} else {
+				t, _ = s.Values[SessionTopWindowKey].(string)
+			}
+		}
+		if !validTopWindow(t) {
+			t = defaultTopWindow
+		}
+		if m := ContextListingModel(r.Context()); m != nil {
+			m.Title = topWindowTitle(t)
+			m.Window = t
+		}
+		ctx := context.WithValue(r.Context(), TopWindowCtxtKey, t)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func topEntriesInWindow(r *http.Request) func(indexEntry) bool {
+	since := topWindowStart(ContextTopWindow(r.Context()), time.Now())
+	return func(e indexEntry) bool {
+		return e.IsTop() && e.SubmittedAt.After(since)
+	}
+}
+
+func byIndexScore(a, b indexEntry) bool {
+	if a.Score == b.Score {
+		return a.SubmittedAt.After(b.SubmittedAt)
+	}
+	return a.Score > b.Score
+}
+
+// TopListingMw selects the best scored submissions in the time window of the request
+var TopListingMw = IndexListingMw("", topEntriesInWindow, byIndexScore)
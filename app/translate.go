@@ -0,0 +1,329 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/text/language"
+)
+
+const (
+	translationsCacheSize = 500
+	translateTimeOut      = 15 * time.Second
+	translateMaxLength    = 10000
+
+	TranslateLibreTranslate = "libretranslate"
+	TranslateDeepL          = "deepl"
+)
+
+// translator is a machine translation service, it returns the texts translated to the language to,
+// in the same order, and the language it detected for the source
+type translator interface {
+	Name() string
+	Translate(ctx context.Context, to string, texts ...string) ([]string, string, error)
+}
+
+// newTranslator returns the translator for the provider from the configuration, or nil if it's not a known one
+func newTranslator(provider, u, key string) translator {
+	switch strings.ToLower(provider) {
+	case TranslateLibreTranslate:
+		if len(u) == 0 {
+			u = "https://libretranslate.com"
+		}
+		return &libreTranslate{url: strings.TrimRight(u, "/"), key: key}
+	case TranslateDeepL:
+		if len(u) == 0 {
+			u = "https://api-free.deepl.com"
+		}
+		return &deepL{url: strings.TrimRight(u, "/"), key: key}
+	}
+	return nil
+}
+
+func postTranslation(ctx context.Context, req *http.Request, res interface{}) error {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("invalid response from the translation service: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+type libreTranslate struct {
+	url string
+	key string
+}
+
+func (l libreTranslate) Name() string {
+	return "LibreTranslate"
+}
+
+func (l libreTranslate) Translate(ctx context.Context, to string, texts ...string) ([]string, string, error) {
+	body := map[string]interface{}{"q": texts, "source": "auto", "target": to, "format": "text"}
+	if len(l.key) > 0 {
+		body["api_key"] = l.key
+	}
+	dat, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, l.url+"/translate", bytes.NewReader(dat))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res := struct {
+		TranslatedText []string `json:"translatedText"`
+		Detected       []struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}{}
+	if err := postTranslation(ctx, req, &res); err != nil {
+		return nil, "", err
+	}
+	from := ""
+	if len(res.Detected) > 0 {
+		from = res.Detected[0].Language
+	}
+	return res.TranslatedText, from, nil
+}
+
+type deepL struct {
+	url string
+	key string
+}
+
+func (d deepL) Name() string {
+	return "DeepL"
+}
+
+func (d deepL) Translate(ctx context.Context, to string, texts ...string) ([]string, string, error) {
+	form := url.Values{}
+	form.Set("target_lang", strings.ToUpper(to))
+	for _, t := range texts {
+		form.Add("text", t)
+	}
+	req, err := http.NewRequest(http.MethodPost, d.url+"/v2/translate", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.key)
+	res := struct {
+		Translations []struct {
+			Source string `json:"detected_source_language"`
+			Text   string `json:"text"`
+		} `json:"translations"`
+	}{}
+	if err := postTranslation(ctx, req, &res); err != nil {
+		return nil, "", err
+	}
+	result := make([]string, len(res.Translations))
+	from := ""
+	for i, t := range res.Translations {
+		result[i] = t.Text
+		if len(from) == 0 {
+			from = strings.ToLower(t.Source)
+		}
+	}
+	return result, from, nil
+}
+
+// itemTranslation is the title and text of an item, translated to Lang
+type itemTranslation struct {
+	Hash     Hash   `json:"hash"`
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text"`
+	From     string `json:"from,omitempty"`
+	Lang     string `json:"lang"`
+	Provider string `json:"provider"`
+}
+
+// translations translates the items with the configured service, and keeps the results per item and language
+type translations struct {
+	m     sync.RWMutex
+	t     translator
+	cache map[string]itemTranslation
+}
+
+func newTranslations(t translator) *translations {
+	return &translations{t: t, cache: make(map[string]itemTranslation)}
+}
+
+// Enabled returns if we have a translation service configured
+func (t *translations) Enabled() bool {
+	return t != nil && t.t != nil
+}
+
+func translationKey(i Item, lang string) string {
+	// NOTE(marius): the time of the last update is part of the key, so the edited items get translated again
+	return fmt.Sprintf("%s:%s:%d", i.Hash, lang, i.UpdatedAt.Unix())
+}
+
+// itemText returns the text of the item for translating, without its markup
+func itemText(i Item) string {
+	if i.IsLink() {
+		return ""
+	}
+	txt := i.Data
+	if i.MimeType == MimeTypeHTML {
+		txt = stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(txt))
+	}
+	if len(txt) > translateMaxLength {
+		txt = txt[:translateMaxLength]
+	}
+	return strings.TrimSpace(txt)
+}
+
+// Translate returns the translation of the item to lang, from the cache or from the translation service
+func (t *translations) Translate(ctx context.Context, i Item, lang string) (itemTranslation, error) {
+	if !t.Enabled() {
+		return itemTranslation{}, errors.NotImplementedf("translations are disabled")
+	}
+	key := translationKey(i, lang)
+	t.m.RLock()
+	tr, ok := t.cache[key]
+	t.m.RUnlock()
+	if ok {
+		return tr, nil
+	}
+
+	texts := make([]string, 0, 2)
+	if len(i.Title) > 0 {
+		texts = append(texts, i.Title)
+	}
+	if txt := itemText(i); len(txt) > 0 {
+		texts = append(texts, txt)
+	}
+	if len(texts) == 0 {
+		return itemTranslation{}, errors.NotFoundf("nothing to translate")
+	}
+	ctx, cancel := context.WithTimeout(ctx, translateTimeOut)
+	defer cancel()
+	res, from, err := t.t.Translate(ctx, lang, texts...)
+	if err != nil {
+		return itemTranslation{}, err
+	}
+	if len(res) != len(texts) {
+		return itemTranslation{}, errors.Errorf("invalid number of translated texts %d, expected %d", len(res), len(texts))
+	}
+	tr = itemTranslation{Hash: i.Hash, From: from, Lang: lang, Provider: t.t.Name()}
+	if len(i.Title) > 0 {
+		tr.Title, res = res[0], res[1:]
+	}
+	if len(res) > 0 {
+		tr.Text = res[0]
+	}
+	if len(tr.From) == 0 && i.Metadata != nil {
+		tr.From = i.Metadata.Lang
+	}
+
+	t.m.Lock()
+	if len(t.cache) >= translationsCacheSize {
+		t.cache = make(map[string]itemTranslation)
+	}
+	t.cache[key] = tr
+	t.m.Unlock()
+	return tr, nil
+}
+
+// langBase returns the base language of the tag, eg: "pt" for "pt-BR"
+func langBase(s string) string {
+	tag, err := language.Parse(s)
+	if err != nil {
+		return ""
+	}
+	base, _ := tag.Base()
+	return base.String()
+}
+
+// requestLanguage returns the language the user prefers, from the lang parameter or the Accept-Language header
+func requestLanguage(r *http.Request) string {
+	if l := langBase(r.URL.Query().Get("lang")); len(l) > 0 {
+		return l
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		if base, _ := tags[0].Base(); base.String() != "und" {
+			return base.String()
+		}
+	}
+	return "en"
+}
+
+// isForeign returns if the item is in a language different than the one the user prefers
+func isForeign(i *Item, lang string) bool {
+	if i == nil || i.Deleted() || i.Metadata == nil {
+		return false
+	}
+	l := i.Metadata.Lang
+	if len(l) == 0 || l == string(pub.NilLangRef) {
+		return false
+	}
+	base := langBase(l)
+	return len(base) > 0 && base != lang
+}
+
+type translationModel struct {
+	Title       string
+	Hash        Hash
+	Content     *Item
+	Translation itemTranslation
+}
+
+func (m *translationModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (translationModel) Template() string {
+	return "translation"
+}
+
+func (m *translationModel) SetCursor(c *Cursor) {
+	if c == nil || m.Content != nil {
+		return
+	}
+	m.Content = getItemFromList(m.Hash, c.items)
+}
+
+// HandleTranslate serves the /{hash}/translate requests, with the item translated to the user's language.
+// The script on the item pages requests the JSON version, to show the translation under the item.
+func (h *handler) HandleTranslate(w http.ResponseWriter, r *http.Request) {
+	m := &translationModel{Hash: HashFromString(chi.URLParam(r, "hash"))}
+	m.SetCursor(ContextCursor(r.Context()))
+	if m.Content == nil || m.Content.Deleted() || !h.storage.translate.Enabled() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item"))
+		return
+	}
+	lang := requestLanguage(r)
+	tr, err := h.storage.translate.Translate(r.Context(), *m.Content, lang)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			h.errFn(log.Ctx{"err": err, "hash": m.Hash, "lang": lang})("unable to translate the item")
+		}
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "Unable to translate the item"))
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		dat, _ := json.Marshal(tr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(dat)
+		return
+	}
+	m.Translation = tr
+	m.Title = fmt.Sprintf("Translation: %s", m.Content.Title)
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+// newTransport returns the transport of the client for the requests to fedbox, with the settings from the configuration.
+// The net/http defaults keep only 2 idle connections per host, which under bursts of requests to fedbox
+// result in opening and closing new connections all the time.
+func newTransport(c config.Configuration, skipTLSVerify bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   c.HTTPDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     c.HTTP2Enabled,
+		MaxIdleConns:          c.HTTPMaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   c.HTTPMaxIdleConnsPerHost,
+		IdleConnTimeout:       c.HTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   c.HTTPTLSTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: skipTLSVerify},
+	}
+	if !c.HTTP2Enabled {
+		// NOTE(marius): a non nil empty map disables the HTTP/2 upgrade for TLS connections
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return tr
+}
+
+// errPrivateAddress is the error of the remote client's connections to addresses which aren't public
+var errPrivateAddress = errors.New("not a public address")
+
+// isPrivateAddress returns if the remote client refused to connect because the host resolved to a non public address
+func isPrivateAddress(err error) bool {
+	return errors.Is(err, errPrivateAddress)
+}
+
+// newRemoteTransport returns a transport like newTransport, for loading the URLs the users give us: the pages they
+// submit, the notification servers and the push endpoints they subscribe with. It refuses to connect to the loopback,
+// private, link-local and other reserved addresses. The dialer checks the address after the host name was resolved,
+// for every new connection, so the redirects can't get around it. The proxy from the environment isn't used, as it
+// would resolve the host names itself.
+func newRemoteTransport(c config.Configuration) *http.Transport {
+	tr := newTransport(c, false)
+	dialer := &net.Dialer{
+		Timeout:   c.HTTPDialTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   publicAddressOnly,
+	}
+	tr.DialContext = dialer.DialContext
+	tr.Proxy = nil
+	return tr
+}
+
+// reservedNetworks are the address ranges which aren't reachable from the internet, or shouldn't be
+var reservedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+		"224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if _, n, err := net.ParseCIDR(c); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}()
+
+// isPublicIP returns if ip is outside of the reserved networks, the IPv4 mapped IPv6 addresses are checked as IPv4
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicAddressOnly is the Control function of the remote transport's dialer, it's called with the resolved address
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("%s: %w", host, errPrivateAddress)
+	}
+	return nil
+}
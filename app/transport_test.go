@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::248": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.20.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.100.100.200":      false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+	}
+	for s, want := range tests {
+		if got := isPublicIP(net.ParseIP(s)); got != want {
+			t.Errorf("isPublicIP(%s) = %t, expected %t", s, got, want)
+		}
+	}
+}
+
+func TestRemoteClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>internal</title>"))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: newRemoteTransport(config.Configuration{})}
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req); !isPrivateAddress(err) {
+		t.Errorf("expected the request to the loopback address to be refused, got %v", err)
+	}
+}
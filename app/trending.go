@@ -0,0 +1,173 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	trendingDay  = "day"
+	trendingWeek = "week"
+
+	// trendingCacheTime is how long we keep the trending tags, before computing them again from the local index
+	trendingCacheTime = 5 * time.Minute
+	// trendingWidgetTags is the number of tags in the sidebar of the front page
+	trendingWidgetTags = 5
+	trendingPageTags   = 30
+)
+
+// TrendingWindows are the time windows of the /trending page, in the order they're shown
+var TrendingWindows = []string{trendingDay, trendingWeek}
+
+func trendingWindow(t string) time.Duration {
+	if t == trendingWeek {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// trendingTag is a tag used by at least the minimum number of accounts in the window, Score is the sum of
+// the decayed weights of its uses, where each account counts only with its latest one
+type trendingTag struct {
+	Name    string
+	Score   float64
+	Uses    int
+	Authors int
+}
+
+// Link returns the path of the tag's page
+func (t trendingTag) Link() string {
+	return "/t/" + t.Name
+}
+
+// computeTrending returns the tags of the entries submitted in the window before now, by their score.
+// The weight of a use halves every quarter of the window, so the recent uses count more. An account using
+// a tag many times counts only once, and the tags need at least minAuthors accounts, so they can't be
+// pushed by a single account.
+func computeTrending(entries []indexEntry, now time.Time, window time.Duration, minAuthors int) []trendingTag {
+	halfLife := window / 4
+	since := now.Add(-window)
+	type use struct {
+		weight float64
+		count  int
+	}
+	uses := make(map[string]map[string]*use)
+	for _, e := range entries {
+		if !e.SubmittedAt.After(since) || e.SubmittedAt.After(now) || len(e.Tags) == 0 {
+			continue
+		}
+		author := e.Author.String()
+		if !e.Author.IsValid() {
+			if len(e.Handle) == 0 {
+				continue
+			}
+			author = e.Handle
+		}
+		weight := math.Pow(0.5, float64(now.Sub(e.SubmittedAt))/float64(halfLife))
+		for _, t := range e.Tags {
+			byAuthor, ok := uses[t]
+			if !ok {
+				byAuthor = make(map[string]*use)
+				uses[t] = byAuthor
+			}
+			u, ok := byAuthor[author]
+			if !ok {
+				u = &use{}
+				byAuthor[author] = u
+			}
+			u.count++
+			if weight > u.weight {
+				u.weight = weight
+			}
+		}
+	}
+	res := make([]trendingTag, 0)
+	for name, byAuthor := range uses {
+		if len(byAuthor) < minAuthors {
+			continue
+		}
+		t := trendingTag{Name: name, Authors: len(byAuthor)}
+		for _, u := range byAuthor {
+			t.Score += u.weight
+			t.Uses += u.count
+		}
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// trendingTags caches the trending tags of the windows
+type trendingTags struct {
+	m          sync.Mutex
+	index      *localIndex
+	minAuthors int
+	tags       map[string][]trendingTag
+	loadedAt   map[string]time.Time
+}
+
+func newTrendingTags(index *localIndex, minAuthors int) *trendingTags {
+	return &trendingTags{
+		index:      index,
+		minAuthors: minAuthors,
+		tags:       make(map[string][]trendingTag),
+		loadedAt:   make(map[string]time.Time),
+	}
+}
+
+// List returns the max first trending tags of the window
+func (t *trendingTags) List(window string, max int) []trendingTag {
+	if t == nil {
+		return nil
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	if time.Since(t.loadedAt[window]) > trendingCacheTime {
+		now := time.Now()
+		entries := t.index.Select(func(e indexEntry) bool { return len(e.Tags) > 0 }, nil)
+		t.tags[window] = computeTrending(entries, now, trendingWindow(window), t.minAuthors)
+		t.loadedAt[window] = now
+	}
+	tags := t.tags[window]
+	if max > 0 && len(tags) > max {
+		tags = tags[:max]
+	}
+	return tags
+}
+
+type trendingModel struct {
+	Title  string
+	Window string
+	Tags   []trendingTag
+}
+
+func (m *trendingModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (trendingModel) Template() string {
+	return "trending"
+}
+
+// HandleTrending serves the /trending page, with the tags trending in the day, or in the week with ?t=week
+func (h *handler) HandleTrending(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("t")
+	if !stringInSlice(TrendingWindows)(window) {
+		window = trendingDay
+	}
+	m := &trendingModel{
+		Title:  fmt.Sprintf("Trending tags of the %s", window),
+		Window: window,
+		Tags:   h.storage.trending.List(window, trendingPageTags),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
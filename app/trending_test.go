@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_computeTrending(t *testing.T) {
+	now := time.Now()
+	entry := func(author int, age time.Duration, tags ...string) indexEntry {
+		return indexEntry{Author: testHash(author), SubmittedAt: now.Add(-age), Tags: tags}
+	}
+	entries := []indexEntry{
+		// a single account spamming a tag doesn't make it trend
+		entry(1, time.Minute, "spam"),
+		entry(1, 2*time.Minute, "spam"),
+		entry(1, 3*time.Minute, "spam"),
+		entry(1, 4*time.Minute, "spam"),
+		// golang is used by three accounts recently
+		entry(2, time.Hour, "golang"),
+		entry(3, 2*time.Hour, "golang"),
+		entry(4, 3*time.Hour, "golang", "rust"),
+		entry(4, 4*time.Hour, "golang"),
+		// rust is used by three accounts too, but a while ago
+		entry(5, 20*time.Hour, "rust"),
+		entry(6, 22*time.Hour, "rust"),
+		// too old for the window
+		entry(7, 30*time.Hour, "rust"),
+	}
+	got := computeTrending(entries, now, 24*time.Hour, 3)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 trending tags, got %v", got)
+	}
+	if got[0].Name != "golang" || got[1].Name != "rust" {
+		t.Errorf("expected golang before rust, got %s, %s", got[0].Name, got[1].Name)
+	}
+	if got[0].Authors != 3 || got[0].Uses != 4 {
+		t.Errorf("expected golang used 4 times by 3 accounts, got %d times by %d", got[0].Uses, got[0].Authors)
+	}
+	if got := computeTrending(entries, now, 24*time.Hour, 4); len(got) != 0 {
+		t.Errorf("expected no tag used by 4 accounts, got %v", got)
+	}
+}
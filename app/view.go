@@ -1,16 +1,20 @@
 package app
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"html/template"
+	"io"
 	"math"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	pub "github.com/go-ap/activitypub"
@@ -29,11 +33,14 @@ type CtxLogFn func(...log.Ctx) LogFn
 type LogFn func(string, ...interface{})
 
 type view struct {
-	c      *config.Configuration
-	assets assets.AssetFiles
-	s      sess
-	infoFn CtxLogFn
-	errFn  CtxLogFn
+	c         *config.Configuration
+	assets    assets.AssetFiles
+	s         sess
+	renM      sync.Mutex
+	renCount  int
+	renderers chan *render.Render
+	infoFn    CtxLogFn
+	errFn     CtxLogFn
 }
 
 func ViewInit(c appConfig, infoFn, errFn CtxLogFn) (*view, error) {
@@ -135,126 +142,275 @@ func stringInSlice(ss []string) func(v string) bool {
 	}
 }
 
-func (v *view) RenderTemplate(r *http.Request, w http.ResponseWriter, name string, m Model) error {
-	var err error
+// staticFuncs returns the template functions that don't depend on the request being rendered
+func (v *view) staticFuncs() template.FuncMap {
+	version := Instance.Version
+	return template.FuncMap{
+		//"urlParam":          func(s string) string { return chi.URLParam(r, s) },
+		//"get":               func(s string) string { return r.URL.Query().Get(s) },
+		"sluggify":          sluggify,
+		"ItemSlugLink":      ItemSlugLink,
+		"ItemShortLink":     ItemShortLink,
+		"AbsoluteLink":      AbsoluteLink,
+		"title":             func(t []byte) string { return string(t) },
+		"getProviders":      getAuthProviders,
+		"IsComment":         func(t Renderable) bool { return t.Type() == CommentType },
+		"IsFollowRequest":   func(t Renderable) bool { return t.Type() == FollowType },
+		"IsVote":            func(t Renderable) bool { return t.Type() == AppreciationType },
+		"IsAccount":         func(t Renderable) bool { return t.Type() == ActorType },
+		"IsModeration":      func(t Renderable) bool { return t.Type() == ModerationType },
+		"SessionEnabled":    func() bool { return v.s.enabled },
+		"Mod10":             mod10,
+		"HTML":              html,
+		"Text":              text,
+		"isAudio":           isAudio,
+		"Audio":             audio,
+		"Video":             video,
+		"isVideo":           isVideo,
+		"Image":             image,
+		"Avatar":            avatar,
+		"isImage":           isImage,
+		"Markdown":          Markdown,
+		"Size":              fmtSize,
+		"replaceTags":       replaceTags,
+		"AccountLocalLink":  AccountLocalLink,
+		"ShowAccountHandle": ShowAccountHandle,
+		"AccountName":       ShowAccountName,
+		"HasDisplayName":    hasDisplayName,
+		"ProfileFieldValue": profileFieldValue,
+		"MediaPlaceholder":  mediaPlaceholder,
+		"ItemMediaLink":     itemMediaLink,
+		"PermaLink":         PermaLink,
+		"ParentLink":        parentLink,
+		"OPLink":            opLink,
+		"IsYay":             isYay,
+		"IsNay":             isNay,
+		"ScoreFmt":          scoreFmt,
+		"NumberFmt":         func(i int) string { return numberFormat("%d", i) },
+		"TimeFmt":           relTimeFmt,
+		"ISOTimeFmt":        isoTimeFmt,
+		"ShowUpdate":        showUpdateTime,
+		"ScoreClass":        scoreClass,
+		"YayLink":           yayLink,
+		"NayLink":           nayLink,
+		"AcceptLink":        acceptLink,
+		"RejectLink":        rejectLink,
+		"NextPageLink":      nextPageLink,
+		"PrevPageLink":      prevPageLink,
+		"CanPaginate":       canPaginate,
+		"Config":            func() config.Configuration { return *v.c },
+		"Version":           func() string { return version },
+		"Name":              appName,
+		"icon":              icon,
+		"icons":             icons,
+		"svg":               assets.Svg,
+		"js":                assets.Js,
+		"style":             assets.Style,
+		"integrity":         assets.Integrity,
+		"sameBase":          sameBasePath,
+		"sameHash":          func(h1, h2 Hash) bool { return h1 == h2 },
+		"fmtPubKey":         fmtPubKey,
+		"pluralize":         func(s string, cnt int) string { return pluralize(float64(cnt), s) },
+		"pasttensify":       pastTenseVerb,
+		"TopWindows":        func() []string { return TopWindows },
+		"TrendingWindows":   func() []string { return TrendingWindows },
+		"RenderLabel":       renderActivityLabel,
+		"ToTitle":           ToTitle,
+		"itemType":          itemType,
+		"trimSuffix":        strings.TrimSuffix,
+		"GetDomainURL":      GetDomainURL,
+		"GetDomainTitle":    GetDomainTitle,
+		//"ScoreFmt":          func(i int64) string { return humanize.FormatInteger("#\u202F###", int(i)) },
+		//"NumberFmt":         func(i int64) string { return humanize.FormatInteger("#\u202F###", int(i)) },
+		"invitationLink": GetInviteLink(v),
+		"HasPage":        pages{path: v.c.PagesPath}.Exists,
+	}
+}
+
+// requestFuncs returns the template functions that depend on the request and on the model being rendered
+func (v *view) requestFuncs(w http.ResponseWriter, r *http.Request, m Model) template.FuncMap {
 	var ac *Account
-
-	_, isError := m.(*errorModel)
-
-	layout := "layout"
 	accountFromRequest := func() *Account {
 		if ac == nil {
 			ac = loggedAccount(r)
 		}
 		return ac
 	}
-
-	version := Instance.Version
-	ren := render.New(render.Options{
-		AssetNames: assets.TemplateNames,
-		Asset:      assets.Template,
-		Layout:     layout,
-		Extensions: []string{".html"},
-		Funcs: []template.FuncMap{{
-			//"urlParam":          func(s string) string { return chi.URLParam(r, s) },
-			//"get":               func(s string) string { return r.URL.Query().Get(s) },
-			"isInverted":            func() bool { return isInverted(r) },
-			"sluggify":              sluggify,
-			"title":                 func(t []byte) string { return string(t) },
-			"getProviders":          getAuthProviders,
-			"CurrentAccount":        accountFromRequest,
-			"IsComment":             func(t Renderable) bool { return t.Type() == CommentType },
-			"IsFollowRequest":       func(t Renderable) bool { return t.Type() == FollowType },
-			"IsVote":                func(t Renderable) bool { return t.Type() == AppreciationType },
-			"IsAccount":             func(t Renderable) bool { return t.Type() == ActorType },
-			"IsModeration":          func(t Renderable) bool { return t.Type() == ModerationType },
-			"SessionEnabled":        func() bool { return v.s.enabled },
-			"LoadFlashMessages":     v.loadFlashMessages(w, r),
-			"Mod10":                 mod10,
-			"ShowText":              showText(m),
-			"ShowTitle":             showTitle(m),
-			"HTML":                  html,
-			"Text":                  text,
-			"isAudio":               isAudio,
-			"Audio":                 audio,
-			"Video":                 video,
-			"isVideo":               isVideo,
-			"Image":                 image,
-			"Avatar":                avatar,
-			"isImage":               isImage,
-			"Markdown":              Markdown,
-			"replaceTags":           replaceTags,
-			"AccountLocalLink":      AccountLocalLink,
-			"ShowAccountHandle":     ShowAccountHandle,
-			"PermaLink":             PermaLink,
-			"ParentLink":            parentLink,
-			"OPLink":                opLink,
-			"IsYay":                 isYay,
-			"IsNay":                 isNay,
-			"ScoreFmt":              scoreFmt,
-			"NumberFmt":             func(i int) string { return numberFormat("%d", i) },
-			"TimeFmt":               relTimeFmt,
-			"ISOTimeFmt":            isoTimeFmt,
-			"ShowUpdate":            showUpdateTime,
-			"ScoreClass":            scoreClass,
-			"YayLink":               yayLink,
-			"NayLink":               nayLink,
-			"AcceptLink":            acceptLink,
-			"RejectLink":            rejectLink,
-			"NextPageLink":          nextPageLink,
-			"PrevPageLink":          prevPageLink,
-			"CanPaginate":           canPaginate,
-			"Config":                func() config.Configuration { return *v.c },
-			"Version":               func() string { return version },
-			"Name":                  appName,
-			"Menu":                  func() []headerEl { return headerMenu(r) },
-			"icon":                  icon,
-			"icons":                 icons,
-			"svg":                   assets.Svg,
-			"js":                    assets.Js,
-			"style":                 assets.Style,
-			"integrity":             assets.Integrity,
-			"req":                   func() *http.Request { return r },
-			"url":                   func() url.Values { return r.URL.Query() },
-			"urlValue":              func(k string) []string { return r.URL.Query()[k] },
-			"urlValueContains":      func(k, v string) bool { return stringInSlice(r.URL.Query()[k])(v) },
-			"sameBase":              sameBasePath,
-			"sameHash":              func(h1, h2 Hash) bool { return h1 == h2 },
-			"fmtPubKey":             fmtPubKey,
-			"pluralize":             func(s string, cnt int) string { return pluralize(float64(cnt), s) },
-			"pasttensify":           pastTenseVerb,
-			"ShowFollowLink":        func(a *Account) bool { return showFollowLink(accountFromRequest(), a) },
-			"ShowAccountBlockLink":  func(a *Account) bool { return showAccountBlockLink(accountFromRequest(), a) },
-			"ShowAccountReportLink": func(a *Account) bool { return showAccountReportLink(accountFromRequest(), a) },
-			"AccountFollows":        func(a *Account) bool { return AccountFollows(a, accountFromRequest()) },
-			"AccountIsFollowed":     func(a *Account) bool { return AccountIsFollowed(accountFromRequest(), a) },
-			"AccountIsRejected":     func(a *Account) bool { return AccountIsRejected(accountFromRequest(), a) },
-			"AccountIsBlocked":      func(a *Account) bool { return AccountIsBlocked(accountFromRequest(), a) },
-			"AccountIsReported":     func(a *Account) bool { return AccountIsReported(accountFromRequest(), a) },
-			"ItemReported":          func(i *Item) bool { return ItemIsReported(accountFromRequest(), i) },
-			"RenderLabel":           renderActivityLabel,
-			csrf.TemplateTag:        func() template.HTML { return csrf.TemplateField(r) },
-			"ToTitle":               ToTitle,
-			"itemType":              itemType,
-			"trimSuffix":            strings.TrimSuffix,
-			"Sort": func(list RenderableList) []Renderable {
-				if list == nil {
-					return nil
+	var (
+		avatars   *avatarLookups
+		reader    *articleReader
+		linkSnaps *linkSnapshots
+		translate *translations
+		deletes   *pendingDeletes
+		removed   *removals
+		notes     *accountNotes
+		slow      *slowModes
+		held      *heldItems
+		fps       *fingerprints
+		bb        *boards
+		evs       *events
+		mails     *mailAddresses
+		notifs    *notificationLog
+		fullText  *searchIndexer
+		trends    *trendingTags
+		edits     *editHistory
+	)
+	var search commentSearch
+	// NOTE(marius): the request is nil when the functions are only placeholders for parsing the templates
+	if r != nil {
+		if repo := ContextRepository(r.Context()); repo != nil {
+			avatars = repo.avatars
+			reader = repo.reader
+			linkSnaps = repo.linkSnaps
+			translate = repo.translate
+			deletes = repo.deletes
+			removed = repo.removals
+			notes = repo.notes
+			slow = repo.slow
+			held = repo.held
+			fps = repo.fingerprints
+			bb = repo.boards
+			evs = repo.events
+			mails = repo.mail
+			notifs = repo.notifications
+			fullText = repo.search
+			trends = repo.trending
+			edits = repo.edits
+		}
+		search = commentSearchFromRequest(r)
+	}
+	var media *mediaFilter
+	mediaFromRequest := func() mediaFilter {
+		if media == nil {
+			f := newMediaFilter(r, accountFromRequest().Settings())
+			media = &f
+		}
+		return *media
+	}
+	return template.FuncMap{
+		"Theme":                 func() string { return pageTheme(r) },
+		"Themes":                func() []string { return Themes },
+		"CurrentAccount":        accountFromRequest,
+		"AccountAvatar":         accountAvatar(avatars),
+		"MediaBlocked":          func(mime string) bool { return mediaFromRequest().Blocks(mime) },
+		"FilterMedia":           func(c template.HTML) template.HTML { return mediaFromRequest().Filter(c) },
+		"ShowReader":            func(i *Item) bool { return i.IsLink() && reader.Allowed(i.Data) },
+		"CommentMatches":        search.Match,
+		"ShareInstance":         func() string { return shareInstance(r) },
+		"LinkGone":              func(i *Item) bool { return i.IsLink() && linkSnaps.Exists(i.Hash) && linkSnaps.Gone(i.Hash, i.Data) },
+		"Translatable":          func(i *Item) bool { return translate.Enabled() && isForeign(i, requestLanguage(r)) },
+		"DeletePending":         func(i *Item) bool { return i != nil && deletes.Pending(i.Hash) },
+		"Removal": func(i *Item) *removal {
+			if i == nil {
+				return nil
+			}
+			return removed.Get(i.Hash)
+		},
+		"IsModerator":           func() bool { return isModerator(v.c, accountFromRequest()) },
+		"IsAdmin":               func() bool { return isAdmin(v.c, accountFromRequest()) },
+		"AccountNotes": func(a *Account) []accountNote {
+			if a == nil || !isModerator(v.c, accountFromRequest()) {
+				return nil
+			}
+			return notes.Get(a.Hash).Notes
+		},
+		"SlowMode":              func(i *Item) *slowMode { return slow.Get(threadHash(i)) },
+		"SlowModeWait": func(i *Item) string {
+			acc := accountFromRequest()
+			if !acc.IsLogged() || isModerator(v.c, acc) {
+				return ""
+			}
+			if wait := slow.Wait(threadHash(i), acc.Hash); wait > 0 {
+				return fmtWait(wait)
+			}
+			return ""
+		},
+		"QueueCount":            func() int { return held.Count() + len(fps.List()) },
+		"Boards":                func() []board { return bb.List() },
+		"SubscribedBoard":       func(name string) bool { return bb.IsSubscribed(accountFromRequest().Hash, name) },
+		"CanEditBoard":          func(b *board) bool { return b != nil && canEditBoard(v.c, accountFromRequest(), *b) },
+		"CanModerate":           func(i *Item) bool { return i != nil && canModerateItem(v.c, bb, accountFromRequest(), i) },
+		"CanModerateBoard":      func(b board) bool { return canModerateBoard(v.c, accountFromRequest(), b) },
+		"ItemBoard": func(i *Item) *board {
+			if b, ok := bb.Get(itemBoard(i)); ok {
+				return &b
+			}
+			return nil
+		},
+		"ModelBoard":            modelBoard(bb),
+		"ClassifiedConditions":  func() []string { return classifiedConditions },
+		"Weekdays":              func() []time.Weekday { return weekdays },
+		"UnreadNotifications":   func() int { return notifs.Unread(accountIRI(accountFromRequest()).String()) },
+		"SearchEnabled":         func() bool { return fullText.Enabled() },
+		"TrendingTags":          func() []trendingTag { return trends.List(trendingDay, trendingWidgetTags) },
+		"IsSaved":               func(i *Item) bool { return accountFromRequest().Saved.Contains(i.Hash) },
+		"Edited":                func(i *Item) bool { return edits.Has(i.Hash) },
+		"CanEdit":               func(i *Item) bool { return canEditItem(*v.c, i, time.Now()) },
+		"FeedLinks":             func(m Model) []feedLink { return listingFeedLinks(r, m) },
+		"MailReplyAddress":      func(i *Item) string { return mails.ReplyAddress(accountFromRequest(), i) },
+		"UpcomingEvents":        func(board string) []upcomingEvent { return evs.List(board, maxUpcomingEvents) },
+		"EventAnswers": func(i *Item) eventRSVPs {
+			acc := accountFromRequest()
+			if !acc.HasMetadata() {
+				return evs.Answers(i.Hash, "")
+			}
+			return evs.Answers(i.Hash, acc.Metadata.ID)
+		},
+		"CalendarLink":          func(i *Item) string { return googleCalendarLink(i, absoluteItemLink(i)) },
+		"ShowReplies": func(i *Item) bool {
+			d := commentDepth(r)
+			return d <= 0 || int(i.Level) < d
+		},
+		"LoadFlashMessages":     func() []flash { return v.loadFlashMessages(w, r)() },
+		"Banner":                v.currentBanner(w, r),
+		"ShowText":              showText(m),
+		"ShowTitle":             showTitle(m),
+		"Menu":                  func() []headerEl { return headerMenu(r) },
+		"req":                   func() *http.Request { return r },
+		"url":                   func() url.Values { return r.URL.Query() },
+		"urlValue":              func(k string) []string { return r.URL.Query()[k] },
+		"urlValueContains":      func(k, v string) bool { return stringInSlice(r.URL.Query()[k])(v) },
+		"ShowFollowLink":        func(a *Account) bool { return showFollowLink(accountFromRequest(), a) },
+		"ShowAccountBlockLink":  func(a *Account) bool { return showAccountBlockLink(accountFromRequest(), a) },
+		"ShowAccountReportLink": func(a *Account) bool { return showAccountReportLink(accountFromRequest(), a) },
+		"AccountFollows":        func(a *Account) bool { return AccountFollows(a, accountFromRequest()) },
+		"AccountIsFollowed":     func(a *Account) bool { return AccountIsFollowed(accountFromRequest(), a) },
+		"AccountIsRejected":     func(a *Account) bool { return AccountIsRejected(accountFromRequest(), a) },
+		"AccountIsBlocked":      func(a *Account) bool { return AccountIsBlocked(accountFromRequest(), a) },
+		"AccountIsReported":     func(a *Account) bool { return AccountIsReported(accountFromRequest(), a) },
+		"ItemReported":          func(i *Item) bool { return ItemIsReported(accountFromRequest(), i) },
+		"FollowsTag":            func(t string) bool { return accountFromRequest().Tags.Contains(t) },
+		csrf.TemplateTag:        func() template.HTML { return csrf.TemplateField(r) },
+		"Sort": func(list RenderableList) []Renderable {
+			if list == nil {
+				return nil
+			}
+			if lModel, ok := m.(*listingModel); ok {
+				sortFn := lModel.sortFn
+				if sortFn == nil {
+					sortFn = ByDate
 				}
-				if lModel, ok := m.(*listingModel); ok {
-					if lModel.sortFn == nil {
-						return ByDate(list)
-					}
-					return lModel.sortFn(list)
+				sorted := sortFn(list)
+				// NOTE(marius): on their profile pages the bots' submissions are always shown
+				if lModel.tpl != "user" && accountFromRequest().Settings().HideBots {
+					sorted = withoutBots(sorted)
 				}
-				return nil
-			},
-			"GetDomainURL":   GetDomainURL,
-			"GetDomainTitle": GetDomainTitle,
-			//"ScoreFmt":          func(i int64) string { return humanize.FormatInteger("#\u202F###", int(i)) },
-			//"NumberFmt":         func(i int64) string { return humanize.FormatInteger("#\u202F###", int(i)) },
-			"invitationLink": GetInviteLink(v),
-		}},
+				return sorted
+			}
+			return nil
+		},
+	}
+}
+
+// newRenderer parses the templates and returns a renderer for them
+// The request dependent functions are only placeholders at this point, they get replaced for every render.
+func (v *view) newRenderer() *render.Render {
+	return render.New(render.Options{
+		AssetNames:                assets.TemplateNames,
+		Asset:                     assets.Template,
+		Layout:                    "layout",
+		Extensions:                []string{".html"},
+		Funcs:                     []template.FuncMap{v.staticFuncs(), v.requestFuncs(nil, nil, nil)},
 		Delims:                    render.Delims{Left: "{{", Right: "}}"},
 		Charset:                   "UTF-8",
 		DisableCharset:            false,
@@ -263,21 +419,122 @@ func (v *view) RenderTemplate(r *http.Request, w http.ResponseWriter, name strin
 		IsDevelopment:             Instance.Conf.Env.IsDev(),
 		DisableHTTPErrorRendering: true,
 	})
+}
+
+// maxRenderers is the number of renderers we keep, each with its own copy of the parsed templates.
+// unrolled/render holds a lock for the whole execution of a template, so the pages using the same renderer
+// are rendered one after the other. With one renderer per CPU they don't wait for each other, at the cost
+// of keeping the templates in memory that many times, and of parsing them again for each new renderer.
+var maxRenderers = runtime.GOMAXPROCS(0)
+
+// getRenderer returns a renderer no other request is using, it needs to be given back with putRenderer.
+// The renderers are created when they're first needed, and when there are maxRenderers of them already,
+// it waits for one to be given back.
+// In development mode unrolled/render reloads the templates for every render, so changes show up without a restart.
+func (v *view) getRenderer() *render.Render {
+	v.renM.Lock()
+	if v.renderers == nil {
+		v.renderers = make(chan *render.Render, maxRenderers)
+	}
+	select {
+	case ren := <-v.renderers:
+		v.renM.Unlock()
+		return ren
+	default:
+	}
+	if v.renCount < cap(v.renderers) {
+		v.renCount++
+		v.renM.Unlock()
+		return v.newRenderer()
+	}
+	renderers := v.renderers
+	v.renM.Unlock()
+	return <-renderers
+}
 
-	if err = ren.HTML(w, http.StatusOK, name, m); err != nil {
+func (v *view) putRenderer(ren *render.Render) {
+	v.renderers <- ren
+}
+
+// renderHTML renders the template name with data into w, with one of the renderers
+func (v *view) renderHTML(w io.Writer, status int, name string, data interface{}, opt render.HTMLOptions) error {
+	ren := v.getRenderer()
+	defer v.putRenderer(ren)
+	return ren.HTML(w, status, name, data, opt)
+}
+
+// htmlOptions returns the render options with the template functions for the current request
+// NOTE(marius): the functions are set on the templates of the renderer, which only this request is using
+// until it's done, so the values captured in them don't leak between concurrent requests
+func (v *view) htmlOptions(w http.ResponseWriter, r *http.Request, m Model) render.HTMLOptions {
+	return render.HTMLOptions{
+		Layout: "layout",
+		Funcs:  v.requestFuncs(w, r, m),
+	}
+}
+
+// renderBuffers are reused between renders, as the listing pages produce quite large outputs
+var renderBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBufferSize is the size over which we don't return the buffers to the pool,
+// so one very large page doesn't keep its memory allocated forever
+const maxPooledBufferSize = 1 << 20
+
+func getRenderBuffer() *bytes.Buffer {
+	buf := renderBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putRenderBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	renderBuffers.Put(buf)
+}
+
+// RenderTemplate renders the template into a buffer, and only when it succeeded it sends it to the client.
+// This way an error in the middle of the template still results in a proper error page.
+func (v *view) RenderTemplate(r *http.Request, w http.ResponseWriter, name string, m Model) error {
+	var err error
+
+	status := http.StatusOK
+	em, isError := m.(*errorModel)
+	if isError && em.Status > 0 {
+		status = em.Status
+	}
+
+	v.hintAssets(w, r, name)
+
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+
+	if err = v.renderHTML(buf, status, name, m, v.htmlOptions(w, r, m)); err != nil {
 		v.errFn(log.Ctx{"err": err, "model": m})("failed to render template %s", name)
+		if isError {
+			http.Error(w, http.StatusText(status), status)
+		} else {
+			v.HandleErrors(w, r, errors.Annotatef(err, "failed to render template"))
+		}
 		return errors.Annotatef(err, "failed to render template")
 	}
 	if !isError {
+		// NOTE(marius): the session needs to be saved before writing the response, as it can set cookies
 		if err = v.s.save(w, r); err != nil {
 			v.errFn(log.Ctx{"err": err.Error()})("session save failed")
-			return nil
 		}
 	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(status)
+	if _, err = buf.WriteTo(w); err != nil {
+		v.errFn(log.Ctx{"err": err.Error()})("unable to write response for template %s", name)
+	}
 	return nil
 }
 
-func getCSPHashes(m Model, v view) (string, string) {
+func getCSPHashes(m Model, v *view) (string, string) {
 	var (
 		assets    = make([]string, 0)
 		styles    = make([]string, 0)
@@ -311,7 +568,7 @@ func getCSPHashes(m Model, v view) (string, string) {
 	return styleSrc, scriptSrc
 }
 
-func (v view) SetCSP(m Model, w http.ResponseWriter) error {
+func (v *view) SetCSP(m Model, w http.ResponseWriter) error {
 	styleSrc, scriptSrc := getCSPHashes(m, v)
 	cspHdrVal := fmt.Sprintf("default-src https: 'self'; style-src https: 'self' %s; script-src https: 'self' %s; media-src https: data: 'self'; img-src https: data: 'self'", styleSrc, scriptSrc)
 	w.Header().Set("Content-Security-Policy", cspHdrVal)
@@ -373,7 +630,6 @@ func (v *view) HandleErrors(w http.ResponseWriter, r *http.Request, errs ...erro
 		w.Header().Set("Cache-Control", " no-store, must-revalidate")
 		w.Header().Set("Pragma", " no-cache")
 		w.Header().Set("Expires", " 0")
-		w.WriteHeader(status)
 		v.RenderTemplate(r, w, "error", d)
 	} else {
 		v.Redirect(w, r, backURL, http.StatusFound)
@@ -500,7 +756,7 @@ type headerEl struct {
 }
 
 func headerMenu(r *http.Request) []headerEl {
-	sections := []string{"/self", "/federated", "/followed", "submit"}
+	sections := []string{"/home", "/self", "/federated", "/active", "/followed", "/boards", "/ask", "/show", "submit"}
 	ret := make([]headerEl, 0)
 	for _, s := range sections {
 		el := headerEl{
@@ -511,13 +767,24 @@ func headerMenu(r *http.Request) []headerEl {
 			el.IsCurrent = true
 		}
 		switch strings.ToLower(s) {
+		case "/home":
+			el.Icon = []string{"asterisk"}
+			el.Auth = true
 		case "/self":
 			el.Icon = []string{"home"}
 		case "/federated":
 			el.Icon = []string{"activitypub"}
+		case "/active":
+			el.Icon = []string{"clock-o"}
 		case "/followed":
 			el.Icon = []string{"star"}
 			el.Auth = true
+		case "/boards":
+			el.Icon = []string{"users"}
+		case "/ask":
+			el.Icon = []string{"reply"}
+		case "/show":
+			el.Icon = []string{"code"}
 		case "submit":
 			el.Icon = []string{"edit", "v-mirror"}
 			el.Auth = true
@@ -575,13 +842,6 @@ func showText(m Model) func() bool {
 	}
 }
 
-func sluggify(s string) string {
-	if s == "" {
-		return ""
-	}
-	return strings.Replace(s, "/", "-", -1)
-}
-
 func getAuthProviders() map[string]string {
 	p := make(map[string]string)
 	if os.Getenv("GITHUB_KEY") != "" {
@@ -685,8 +945,8 @@ func accountDefaultAvatar (act *Account) ImageMetadata {
 }
 
 const (
-	imageFmt     = `<image src='data:%s;base64,%s' />`
-	avatarFmt    = `<image src='data:%s;base64,%s' width='48' height='48' class='icon avatar' />`
+	imageFmt     = `<image src='data:%s;base64,%s' alt='' />`
+	avatarFmt    = `<image src='data:%s;base64,%s' alt='' width='48' height='48' class='icon avatar' />`
 	videoFmt     = `<video controls width='90%%'><source src='data:%s;base64,%s' type='%s'/></video>`
 	audioFmt     = `<audio controls><source src='data:%s;base64,%s' type='%s'/></audio>`
 	iconFmt      = `<svg aria-hidden="true" class="icon icon-%s"><use xlink:href="#icon-%s"><title>%s</title></use></svg>`
@@ -1015,6 +1275,14 @@ func ItemPermaLink(i *Item) string {
 	return ItemLocalLink(i)
 }
 
+// ItemShortLink returns the /s/ link of the item, using the first characters of its hash
+func ItemShortLink(i *Item) string {
+	if !i.IsValid() {
+		return ""
+	}
+	return "/s/" + i.Hash.String()[:shortHashLength]
+}
+
 // PermaLink
 func PermaLink(r Renderable) string {
 	if i, ok := r.(*Item); ok {
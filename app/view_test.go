@@ -0,0 +1,249 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/unrolled/render"
+	xhtml "golang.org/x/net/html"
+)
+
+func testView(tb testing.TB) *view {
+	// NOTE(marius): the templates are loaded relative to the working directory
+	wd, _ := os.Getwd()
+	if err := os.Chdir(".."); err != nil {
+		tb.Skipf("unable to change working directory: %s", err)
+	}
+	tb.Cleanup(func() { os.Chdir(wd) })
+
+	if Instance.Conf == nil {
+		Instance.Conf = &config.Configuration{Env: config.PROD}
+	}
+	return &view{
+		c:      Instance.Conf,
+		infoFn: defaultCtxLogFn,
+		errFn:  defaultCtxLogFn,
+	}
+}
+
+func benchmarkRender(b *testing.B, parallel bool, renderFn func(*view, http.ResponseWriter, *http.Request, Model) error) {
+	v := testView(b)
+	m := &errorModel{
+		Status:     http.StatusNotFound,
+		StatusText: http.StatusText(http.StatusNotFound),
+		Title:      "Not found",
+		Errors:     []error{errors.NotFoundf("test")},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	if parallel {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := renderFn(v, httptest.NewRecorder(), r, m); err != nil {
+					b.Fatalf("unable to render template: %s", err)
+				}
+			}
+		})
+		return
+	}
+	for i := 0; i < b.N; i++ {
+		if err := renderFn(v, httptest.NewRecorder(), r, m); err != nil {
+			b.Fatalf("unable to render template: %s", err)
+		}
+	}
+}
+
+func renderPooled(v *view, w http.ResponseWriter, r *http.Request, m Model) error {
+	return v.renderHTML(w, http.StatusOK, m.Template(), m, v.htmlOptions(w, r, m))
+}
+
+// BenchmarkRender_ParseEveryRequest is how the rendering worked before caching the parsed templates
+func BenchmarkRender_ParseEveryRequest(b *testing.B) {
+	benchmarkRender(b, false, func(v *view, w http.ResponseWriter, r *http.Request, m Model) error {
+		return v.newRenderer().HTML(w, http.StatusOK, m.Template(), m, v.htmlOptions(w, r, m))
+	})
+}
+
+func BenchmarkRender_ParseOnce(b *testing.B) {
+	benchmarkRender(b, false, renderPooled)
+}
+
+// BenchmarkRender_ParallelOneRenderer shows how the concurrent renders wait for each other on the lock of the renderer
+func BenchmarkRender_ParallelOneRenderer(b *testing.B) {
+	var ren *render.Render
+	once := sync.Once{}
+	benchmarkRender(b, true, func(v *view, w http.ResponseWriter, r *http.Request, m Model) error {
+		once.Do(func() { ren = v.newRenderer() })
+		return ren.HTML(w, http.StatusOK, m.Template(), m, v.htmlOptions(w, r, m))
+	})
+}
+
+func BenchmarkRender_ParallelPooled(b *testing.B) {
+	benchmarkRender(b, true, renderPooled)
+}
+
+func attr(n *xhtml.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func findAll(n *xhtml.Node, match func(*xhtml.Node) bool) []*xhtml.Node {
+	var res []*xhtml.Node
+	if n.Type == xhtml.ElementNode && match(n) {
+		res = append(res, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		res = append(res, findAll(c, match)...)
+	}
+	return res
+}
+
+func byTag(tag string) func(*xhtml.Node) bool {
+	return func(n *xhtml.Node) bool { return n.Data == tag }
+}
+
+func textContent(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	}
+	b := strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// accessibleName returns the text a screen reader announces for the element
+func accessibleName(n *xhtml.Node) string {
+	if l, ok := attr(n, "aria-label"); ok {
+		return l
+	}
+	if t := strings.TrimSpace(textContent(n)); len(t) > 0 {
+		return t
+	}
+	t, _ := attr(n, "title")
+	return t
+}
+
+func checkAccessibility(t *testing.T, doc *xhtml.Node) {
+	for _, img := range findAll(doc, byTag("img")) {
+		if _, ok := attr(img, "alt"); !ok {
+			t.Errorf("image without an alt attribute: %v", img.Attr)
+		}
+	}
+	for _, svg := range findAll(doc, byTag("svg")) {
+		if h, _ := attr(svg, "aria-hidden"); h != "true" {
+			if _, ok := attr(svg, "aria-label"); !ok {
+				t.Errorf("svg without an aria-hidden or aria-label attribute: %v", svg.Attr)
+			}
+		}
+	}
+	for _, a := range findAll(doc, byTag("a")) {
+		if len(accessibleName(a)) == 0 {
+			t.Errorf("link without an accessible name: %v", a.Attr)
+		}
+	}
+	navs := findAll(doc, byTag("nav"))
+	if len(navs) > 1 {
+		for _, n := range navs {
+			if _, ok := attr(n, "aria-label"); !ok {
+				t.Errorf("the page has more than one navigation landmark, and one has no aria-label: %v", n.Attr)
+			}
+		}
+	}
+}
+
+func TestRender_LayoutAccessibility(t *testing.T) {
+	v := testView(t)
+	m := &errorModel{
+		Status:     http.StatusNotFound,
+		StatusText: http.StatusText(http.StatusNotFound),
+		Title:      "Not found",
+		Errors:     []error{errors.NotFoundf("test")},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	w := httptest.NewRecorder()
+	if err := v.renderHTML(w, http.StatusOK, m.Template(), m, v.htmlOptions(w, r, m)); err != nil {
+		t.Fatalf("unable to render template: %s", err)
+	}
+	doc, err := xhtml.Parse(w.Body)
+	if err != nil {
+		t.Fatalf("unable to parse the rendered page: %s", err)
+	}
+
+	for _, landmark := range []string{"header", "main", "footer"} {
+		if l := len(findAll(doc, byTag(landmark))); l == 0 {
+			t.Errorf("the page has no %s landmark", landmark)
+		}
+	}
+	skip := findAll(doc, func(n *xhtml.Node) bool {
+		c, _ := attr(n, "class")
+		return n.Data == "a" && c == "skip-link"
+	})
+	if len(skip) == 0 {
+		t.Fatalf("the page has no skip link")
+	}
+	target, _ := attr(skip[0], "href")
+	main := findAll(doc, byTag("main"))
+	if id, _ := attr(main[0], "id"); "#"+id != target {
+		t.Errorf("the skip link points to %q, the main landmark is %q", target, "#"+id)
+	}
+	if idx, _ := attr(main[0], "tabindex"); idx != "-1" {
+		t.Errorf("the main landmark can't receive the focus, its tabindex is %q", idx)
+	}
+	checkAccessibility(t, doc)
+}
+
+func TestRender_VoteControlsAccessibility(t *testing.T) {
+	v := testView(t)
+	conf := *v.c
+	conf.VotingEnabled = true
+	conf.DownvotingEnabled = true
+	v.c = &conf
+
+	it := &Item{Hash: HashFromString("d5ad20a3"), Title: "Test item", Score: 3}
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	w := httptest.NewRecorder()
+	ren := v.getRenderer()
+	defer v.putRenderer(ren)
+	tpl := ren.TemplateLookup("partials/item/score")
+	if tpl == nil {
+		t.Fatalf("unable to find the score template")
+	}
+	if err := tpl.Funcs(v.requestFuncs(w, r, nil)).Execute(w.Body, it); err != nil {
+		t.Fatalf("unable to render template: %s", err)
+	}
+	doc, err := xhtml.Parse(w.Body)
+	if err != nil {
+		t.Fatalf("unable to parse the rendered page: %s", err)
+	}
+	votes := findAll(doc, func(n *xhtml.Node) bool {
+		_, ok := attr(n, "data-action")
+		return n.Data == "a" && ok
+	})
+	if len(votes) != 2 {
+		t.Fatalf("expected the yay and nay controls, found %d", len(votes))
+	}
+	for _, a := range votes {
+		l, ok := attr(a, "aria-label")
+		if !ok || !strings.Contains(l, it.Title) {
+			t.Errorf("the vote control has no aria-label with the item title: %q", l)
+		}
+		if d, _ := attr(a, "aria-disabled"); d != "true" {
+			t.Errorf("the vote control isn't marked as disabled for anonymous users")
+		}
+	}
+	checkAccessibility(t, doc)
+}
@@ -1,16 +1,15 @@
 package app
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strings"
 
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
-	"github.com/writeas/go-nodeinfo"
+	"github.com/mariusor/go-littr/internal/log"
 )
 
 type link struct {
@@ -26,98 +25,6 @@ type node struct {
 	Links   []link   `json:"links"`
 }
 
-type NodeInfoResolver struct {
-	users    int
-	comments int
-	posts    int
-}
-
-var (
-	actorsFilter = &Filters{
-		Type: ActivityTypesFilter(ValidActorTypes...),
-	}
-	postsFilter = &Filters{
-		Type: ActivityTypesFilter(ValidContentTypes...),
-		OP:   nilIRIs,
-	}
-	allFilter = &Filters{
-		Type: ActivityTypesFilter(ValidContentTypes...),
-	}
-)
-
-func NodeInfoResolverNew(f *fedbox) NodeInfoResolver {
-	n := NodeInfoResolver{}
-	if f == nil {
-		return n
-	}
-
-	us, _ := f.Actors(context.TODO(), Values(actorsFilter))
-	if us != nil {
-		n.users = int(us.Count())
-	}
-
-	posts, _ := f.Objects(context.TODO(), Values(postsFilter))
-	if posts != nil {
-		n.posts = int(posts.Count())
-	}
-	all, _ := f.Objects(context.TODO(), Values(allFilter))
-
-	if all != nil {
-		n.comments = int(all.Count()) - n.posts
-	}
-	return n
-}
-
-func (n NodeInfoResolver) IsOpenRegistration() (bool, error) {
-	return Instance.Conf.UserCreatingEnabled, nil
-}
-
-func (n NodeInfoResolver) Usage() (nodeinfo.Usage, error) {
-	u := nodeinfo.Usage{
-		Users: nodeinfo.UsageUsers{
-			Total: n.users,
-		},
-		LocalComments: n.comments,
-		LocalPosts:    n.posts,
-	}
-	return u, nil
-}
-
-const (
-	githubUrl    = "https://github.com/mariusor/go-littr"
-	author       = "@mariusor@metalhead.club"
-	softwareName = "go-littr"
-)
-
-func NodeInfoConfig() nodeinfo.Config {
-	return nodeinfo.Config{
-		BaseURL: Instance.BaseURL,
-		InfoURL: "/nodeinfo",
-
-		Metadata: nodeinfo.Metadata{
-			NodeName:        string(regexp.MustCompile(`<[\/\w]+>`).ReplaceAll([]byte(Instance.NodeInfo().Title), []byte{})),
-			NodeDescription: Instance.NodeInfo().Summary,
-			Private:         false,
-			Software: nodeinfo.SoftwareMeta{
-				GitHub:   githubUrl,
-				HomePage: Instance.BaseURL,
-				Follow:   author,
-			},
-		},
-		Protocols: []nodeinfo.NodeProtocol{
-			nodeinfo.ProtocolActivityPub,
-		},
-		Services: nodeinfo.Services{
-			Inbound:  []nodeinfo.NodeService{},
-			Outbound: []nodeinfo.NodeService{nodeinfo.ServiceAtom},
-		},
-		Software: nodeinfo.SoftwareInfo{
-			Name:    softwareName,
-			Version: Instance.NodeInfo().Version,
-		},
-	}
-}
-
 // HandleHostMeta serves /.well-known/host-meta
 func (h handler) HandleHostMeta(w http.ResponseWriter, r *http.Request) {
 	hm := node{
@@ -127,7 +34,7 @@ func (h handler) HandleHostMeta(w http.ResponseWriter, r *http.Request) {
 			{
 				Rel:      "lrdd",
 				Type:     "application/xrd+json",
-				Template: fmt.Sprintf("%s/.well-known/node?resource={uri}", h.conf.BaseURL),
+				Template: fmt.Sprintf("%s/.well-known/webfinger?resource={uri}", h.conf.BaseURL),
 			},
 		},
 	}
@@ -140,92 +47,102 @@ func (h handler) HandleHostMeta(w http.ResponseWriter, r *http.Request) {
 
 const selfName = "self"
 
-// HandleWebFinger serves /.well-known/webfinger/
-func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
-	res := r.URL.Query().Get("resource")
-
-	typ, handle := func(res string) (string, string) {
-		split := ":"
-		if strings.Contains(res, "://") {
-			split = "://"
+// webFingerHandle returns the handle of the local account the WebFinger resource refers to. The resource
+// can be an acct: URI, with or without the leading '@', or the URL of the account's page.
+// The accounts on other instances are not ours to describe, so ok is false for them.
+func webFingerHandle(res string) (string, bool) {
+	if strings.HasPrefix(res, "acct:") || !strings.Contains(res, "://") {
+		acct := strings.TrimPrefix(strings.TrimPrefix(res, "acct:"), "@")
+		handle, hostName := acct, ""
+		if i := strings.LastIndex(acct, "@"); i >= 0 {
+			handle, hostName = acct[:i], acct[i+1:]
 		}
-		ar := strings.Split(res, split)
-		if len(ar) != 2 {
-			return "", ""
+		if len(handle) == 0 || strings.ContainsAny(handle, "@/") {
+			return "", false
 		}
-		typ := ar[0]
-		handle := ar[1]
-		if handle[0] == '@' && len(handle) > 1 {
-			handle = handle[1:]
+		if len(hostName) > 0 && !HostIsLocal("https://"+hostName) {
+			return "", false
 		}
-		return typ, handle
-	}(res)
+		return handle, true
+	}
+	u, err := url.Parse(res)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || !HostIsLocal(res) {
+		return "", false
+	}
+	p := strings.Trim(u.Path, "/")
+	if len(p) == 0 {
+		return selfName, true
+	}
+	if strings.HasPrefix(p, "~") && len(p) > 1 && !strings.Contains(p, "/") {
+		return p[1:], true
+	}
+	return "", false
+}
 
-	if typ == "" || handle == "" {
-		errors.HandleError(errors.BadRequestf("invalid resource %s", res)).ServeHTTP(w, r)
+// HandleWebFinger serves /.well-known/webfinger/ requests, which resolve acct:handle@instance resources
+// to the actors of the local accounts
+func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
+	res := r.URL.Query().Get("resource")
+	notFound := func(err error) {
+		h.errFn(log.Ctx{"resource": res, "err": err})("webfinger resource not found")
+		errors.HandleError(errors.NotFoundf("resource not found %s", res)).ServeHTTP(w, r)
+	}
+	if len(res) == 0 {
+		errors.HandleError(errors.BadRequestf("missing resource")).ServeHTTP(w, r)
 		return
 	}
-
-	wf := node{}
-	if strings.Contains(handle, "@") {
-		handle, _ = func(s string) (string, string) {
-			split := "@"
-			ar := strings.Split(s, split)
-			if len(ar) != 2 {
-				return "", ""
-			}
-			return ar[0], ar[1]
-		}(handle)
+	handle, ok := webFingerHandle(res)
+	if !ok {
+		notFound(errors.Errorf("not a local account"))
+		return
 	}
+
 	var a *Account
 	fedbox := h.storage.fedbox.Service()
-	handleIRI := pub.IRI(fmt.Sprintf("https://%s/", handle))
-	if fedbox.GetLink().Equals(handleIRI, false) || handle == selfName {
+	if handle == selfName || fedbox.GetLink().Equals(pub.IRI(fmt.Sprintf("https://%s/", handle)), false) {
 		a = new(Account)
 		if err := a.FromActivityPub(fedbox); err != nil {
-			err := errors.NotFoundf("resource not found %s", res)
-			h.errFn()("Error: %s", err)
-			errors.HandleError(err).ServeHTTP(w, r)
+			notFound(err)
 			return
 		}
 	} else {
-		ff := &Filters{Name: CompStrs{EqualsString(handle)}}
-		accounts, _, err := h.storage.LoadAccounts(context.TODO(), ff)
+		ff := &Filters{Name: CompStrs{EqualsString(h.storage.renames.Current(handle))}}
+		accounts, _, err := h.storage.LoadAccounts(r.Context(), ff)
 		if err != nil {
-			err := errors.NotFoundf("resource not found %s", res)
-			h.errFn()("Error: %s", err)
-			errors.HandleError(err).ServeHTTP(w, r)
+			notFound(err)
 			return
 		}
-		a, err = accounts.First()
-		if err != nil {
-			err := errors.NotFoundf("resource not found %s", res)
-			h.errFn()("Error: %s", err)
-			errors.HandleError(err).ServeHTTP(w, r)
+		// NOTE(marius): fedbox keeps the actors of the other instances too, which can have the same handle
+		for i := range accounts {
+			if accounts[i].IsLocal() {
+				a = &accounts[i]
+				break
+			}
+		}
+		if a == nil {
+			notFound(errors.Errorf("no local account %s", handle))
 			return
 		}
 	}
 	id := a.GetLink()
-	url := accountURL(*a).String()
-	url1 := a.Metadata.URL
-	wf.Aliases = []string{id, url}
-	wf.Subject = res
-	wf.Links = []link{
-		{
-			Rel:  "self",
-			Type: "application/activity+json",
-			Href: id,
-		},
-		{
-			Rel:  "http://webfinger.net/rel/profile-page",
-			Type: "text/html",
-			Href: url,
-		},
-		{
-			Rel: "http://ostatus.org/schema/1.0/subscribe",
+	profile := accountURL(*a).String()
+	wf := node{
+		Subject: fmt.Sprintf("acct:%s@%s", a.Handle, h.conf.HostName),
+		Aliases: []string{id, profile},
+		Links: []link{
+			{
+				Rel:  "self",
+				Type: "application/activity+json",
+				Href: id,
+			},
+			{
+				Rel:  "http://webfinger.net/rel/profile-page",
+				Type: "text/html",
+				Href: profile,
+			},
 		},
 	}
-	if url1 != url && url1 != id {
+	if url1 := a.Metadata.URL; len(url1) > 0 && url1 != profile && url1 != id {
 		wf.Links = append(wf.Links, link{
 			Rel:  "http://webfinger.net/rel/profile-page",
 			Type: "text/html",
@@ -235,6 +152,7 @@ func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
 
 	dat, _ := json.Marshal(wf)
 	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.WriteHeader(http.StatusOK)
 	w.Write(dat)
 }
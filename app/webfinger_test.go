@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func TestWebFingerHandle(t *testing.T) {
+	Instance.Conf = &config.Configuration{HostName: "littr.git", APIURL: "https://fedbox.git"}
+	tests := map[string]struct {
+		handle string
+		ok     bool
+	}{
+		"acct:marius@littr.git":         {"marius", true},
+		"acct:@marius@littr.git":        {"marius", true},
+		"marius@littr.git":              {"marius", true},
+		"acct:marius":                   {"marius", true},
+		"acct:marius@mastodon.git":      {"", false},
+		"acct:@":                        {"", false},
+		"https://littr.git/~marius":     {"marius", true},
+		"https://littr.git/":            {selfName, true},
+		"https://littr.git/~marius/f00": {"", false},
+		"https://mastodon.git/@marius":  {"", false},
+	}
+	for res, tt := range tests {
+		handle, ok := webFingerHandle(res)
+		if handle != tt.handle || ok != tt.ok {
+			t.Errorf("%s: expected %q %t, got %q %t", res, tt.handle, tt.ok, handle, ok)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	pushSubscriptionsFile = "push-subscriptions.json"
+	pushSendTimeOut       = 30 * time.Second
+	// pushMaxFailures is how many deliveries in a row can fail before we drop the subscription
+	pushMaxFailures = 5
+	pushTTL         = 24 * time.Hour
+	// pushRecordSize is the record size of the aes128gcm content encoding, our messages fit in one record
+	pushRecordSize = 4096
+)
+
+var b64 = base64.RawURLEncoding
+
+// pushSubscription is the PushSubscription of a browser, as returned by the Push API
+type pushSubscription struct {
+	Account   string    `json:"account"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"p256dh"`
+	Auth      string    `json:"auth"`
+	Failures  int       `json:"failures,omitempty"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// webPush keeps the Web Push subscriptions of the browsers of the local accounts, by their endpoint, and
+// the VAPID key we sign the requests to the push services with.
+type webPush struct {
+	m       sync.RWMutex
+	path    string
+	subject string
+	key     *ecdsa.PrivateKey
+	// client sends the messages to the push services, it can't reach the local network
+	client        *http.Client
+	Key           string                        `json:"key,omitempty"`
+	Subscriptions map[string]pushSubscription   `json:"subscriptions"`
+	Events        map[string]notificationEvents `json:"events"`
+}
+
+func vapidKeyFromString(s string) (*ecdsa.PrivateKey, error) {
+	d, err := b64.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) != 32 {
+		return nil, errors.Errorf("invalid P-256 private key length %d", len(d))
+	}
+	k := new(ecdsa.PrivateKey)
+	k.Curve = elliptic.P256()
+	k.D = new(big.Int).SetBytes(d)
+	k.X, k.Y = k.Curve.ScalarBaseMult(d)
+	return k, nil
+}
+
+func loadWebPush(path string, c *config.Configuration, baseURL string) (*webPush, error) {
+	w := &webPush{
+		path:          path,
+		subject:       c.VAPIDSubject,
+		Subscriptions: make(map[string]pushSubscription),
+		Events:        make(map[string]notificationEvents),
+	}
+	if !c.WebPush {
+		return w, nil
+	}
+	if len(w.subject) == 0 {
+		w.subject = baseURL
+	}
+	err := loadJSON(path, w)
+	if err != nil {
+		return w, err
+	}
+	if len(c.VAPIDPrivateKey) > 0 {
+		w.key, err = vapidKeyFromString(c.VAPIDPrivateKey)
+		return w, err
+	}
+	if len(w.Key) > 0 {
+		w.key, err = vapidKeyFromString(w.Key)
+		return w, err
+	}
+	// NOTE(marius): without a configured key we generate one, it needs to stay the same, otherwise
+	// the existing subscriptions stop working
+	if w.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return w, err
+	}
+	w.Key = b64.EncodeToString(fillBytes(w.key.D, make([]byte, 32)))
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w, w.save()
+}
+
+// Enabled returns if the instance sends push notifications
+func (w *webPush) Enabled() bool {
+	return w != nil && w.key != nil
+}
+
+// PublicKey returns the VAPID public key, the applicationServerKey of the Push API subscriptions
+func (w *webPush) PublicKey() string {
+	if !w.Enabled() {
+		return ""
+	}
+	return b64.EncodeToString(elliptic.Marshal(w.key.Curve, w.key.X, w.key.Y))
+}
+
+// Subscribe saves the subscription of a browser of the account with id
+func (w *webPush) Subscribe(id string, s pushSubscription) error {
+	if !w.Enabled() {
+		return errors.Errorf("push notifications are disabled")
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+		return errors.BadRequestf("invalid push endpoint")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !isPublicIP(ip) {
+		return errors.BadRequestf("invalid push endpoint")
+	}
+	if key, err := b64.DecodeString(strings.TrimRight(s.P256dh, "=")); err != nil || len(key) != 65 {
+		return errors.BadRequestf("invalid push subscription key")
+	}
+	if auth, err := b64.DecodeString(strings.TrimRight(s.Auth, "=")); err != nil || len(auth) != 16 {
+		return errors.BadRequestf("invalid push subscription secret")
+	}
+	s.Account = id
+	s.Failures = 0
+	s.CreatedAt = time.Now().UTC()
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.Subscriptions[s.Endpoint] = s
+	if _, ok := w.Events[id]; !ok {
+		w.Events[id] = defaultNotificationEvents
+	}
+	return w.save()
+}
+
+// Unsubscribe removes the subscription with the endpoint, if it belongs to the account with id
+func (w *webPush) Unsubscribe(id, endpoint string) error {
+	if !w.Enabled() {
+		return nil
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	if s, ok := w.Subscriptions[endpoint]; !ok || s.Account != id {
+		return nil
+	}
+	delete(w.Subscriptions, endpoint)
+	return w.save()
+}
+
+// Count returns the number of browsers the account with id gets notifications in
+func (w *webPush) Count(id string) int {
+	if !w.Enabled() {
+		return 0
+	}
+	w.m.RLock()
+	defer w.m.RUnlock()
+	cnt := 0
+	for _, s := range w.Subscriptions {
+		if s.Account == id {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// EventsOf returns the events the account with id wants to be notified about
+func (w *webPush) EventsOf(id string) notificationEvents {
+	if !w.Enabled() {
+		return defaultNotificationEvents
+	}
+	w.m.RLock()
+	defer w.m.RUnlock()
+	if e, ok := w.Events[id]; ok {
+		return e
+	}
+	return defaultNotificationEvents
+}
+
+// SetEvents saves the events the account with id wants to be notified about
+func (w *webPush) SetEvents(id string, e notificationEvents) error {
+	if !w.Enabled() {
+		return errors.Errorf("push notifications are disabled")
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.Events[id] = e
+	return w.save()
+}
+
+// save writes the subscriptions to disk, it needs to be called with the lock held
+func (w *webPush) save() error {
+	return saveJSON(w.path, w)
+}
+
+// fillBytes writes the absolute value of i to buf, padded with zeros to the left
+func fillBytes(i *big.Int, buf []byte) []byte {
+	b := i.Bytes()
+	copy(buf[len(buf)-len(b):], b)
+	return buf
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// encryptPushMessage encrypts the payload for the subscription, with the aes128gcm content encoding
+// of RFC 8188, and the keys derived as in RFC 8291
+func encryptPushMessage(s pushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := b64.DecodeString(strings.TrimRight(s.P256dh, "="))
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := b64.DecodeString(strings.TrimRight(s.Auth, "="))
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	ux, uy := elliptic.Unmarshal(curve, uaPublic)
+	if ux == nil {
+		return nil, errors.Errorf("invalid push subscription key")
+	}
+	asPrivate, ax, ay, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, ax, ay)
+	sx, _ := curve.ScalarMult(ux, uy, asPrivate)
+	ecdhSecret := fillBytes(sx, make([]byte, 32))
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prkKey := hmacSHA256(authSecret, ecdhSecret)
+	ikm := hmacSHA256(prkKey, []byte("WebPush: info\x00"), uaPublic, asPublic, []byte{1})
+	prk := hmacSHA256(salt, ikm)
+	cek := hmacSHA256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16]
+	nonce := hmacSHA256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12]
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(marius): the 0x02 delimiter marks the last, and only, record
+	plain := append(payload, 2)
+	if len(plain)+gcm.Overhead() > pushRecordSize {
+		return nil, errors.Errorf("the push message is too large")
+	}
+
+	body := bytes.Buffer{}
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(pushRecordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(gcm.Seal(nil, nonce, plain, nil))
+	return body.Bytes(), nil
+}
+
+// vapidAuthorization returns the Authorization header for the push service of the endpoint, as in RFC 8292
+func (w *webPush) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": w.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, w.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	fillBytes(r, sig[:32])
+	fillBytes(s, sig[32:])
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, b64.EncodeToString(sig), w.PublicKey()), nil
+}
+
+// send delivers the message to the browser, it returns the status of the response of the push service
+func (w *webPush) send(ctx context.Context, s pushSubscription, msg notification) (int, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	body, err := encryptPushMessage(s, payload)
+	if err != nil {
+		return 0, err
+	}
+	auth, err := w.vapidAuthorization(s.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprintf("%d", int(pushTTL.Seconds())))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// Notify sends the message to the browsers of the account with id, if it wants to know about the event.
+// The subscriptions the push services don't know anymore are removed, like the ones failing too many times.
+func (w *webPush) Notify(ctx context.Context, id, event string, msg notification) error {
+	if !w.Enabled() {
+		return nil
+	}
+	msg, ok := msg.For(w.EventsOf(id), event)
+	if !ok {
+		return nil
+	}
+	w.m.RLock()
+	subs := make([]pushSubscription, 0)
+	for _, s := range w.Subscriptions {
+		if s.Account == id {
+			subs = append(subs, s)
+		}
+	}
+	w.m.RUnlock()
+	if len(subs) == 0 {
+		return nil
+	}
+
+	failures := make(map[string]int)
+	for _, s := range subs {
+		status, err := w.send(ctx, s, msg)
+		switch {
+		case err == nil && status >= 200 && status < 300:
+			failures[s.Endpoint] = 0
+		case status == http.StatusNotFound || status == http.StatusGone:
+			failures[s.Endpoint] = pushMaxFailures
+		default:
+			failures[s.Endpoint] = s.Failures + 1
+		}
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	changed := false
+	for endpoint, cnt := range failures {
+		s, ok := w.Subscriptions[endpoint]
+		if !ok || s.Failures == cnt {
+			continue
+		}
+		if cnt >= pushMaxFailures {
+			delete(w.Subscriptions, endpoint)
+		} else {
+			s.Failures = cnt
+			w.Subscriptions[endpoint] = s
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return w.save()
+}
+
+// HandlePushSettings handles POST /~handle/settings/push requests, which save the notification events, and
+// add or remove the subscription of the current browser
+func (h *handler) HandlePushSettings(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	id := accountIRI(acc).String()
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+
+	var err error
+	msg := "Your notification settings were saved."
+	switch r.PostFormValue("action") {
+	case "subscribe":
+		err = h.storage.push.Subscribe(id, pushSubscription{
+			Endpoint: r.PostFormValue("endpoint"),
+			P256dh:   r.PostFormValue("p256dh"),
+			Auth:     r.PostFormValue("auth"),
+		})
+		msg = "You will receive notifications in this browser."
+	case "unsubscribe":
+		err = h.storage.push.Unsubscribe(id, r.PostFormValue("endpoint"))
+		msg = "You won't receive notifications in this browser anymore."
+	default:
+		err = h.storage.push.SetEvents(id, notificationEventsFromRequest(r))
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "handle": acc.Handle})("unable to save the push notification settings")
+		h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to save the notification settings"))
+		return
+	}
+	h.v.addFlashMessage(Success, w, r, msg)
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
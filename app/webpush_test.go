@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// decryptPushMessage is what the browser does with the messages it receives
+func decryptPushMessage(t *testing.T, uaPrivate []byte, uaPublic, authSecret, body []byte) []byte {
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	asPublic, ciphertext := body[21:21+idLen], body[21+idLen:]
+	if rs != pushRecordSize {
+		t.Fatalf("unexpected record size %d", rs)
+	}
+	curve := elliptic.P256()
+	ax, ay := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(ax, ay, uaPrivate)
+
+	prkKey := hmacSHA256(authSecret, fillBytes(sx, make([]byte, 32)))
+	ikm := hmacSHA256(prkKey, []byte("WebPush: info\x00"), uaPublic, asPublic, []byte{1})
+	prk := hmacSHA256(salt, ikm)
+	block, _ := aes.NewCipher(hmacSHA256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16])
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, hmacSHA256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12], ciphertext, nil)
+	if err != nil {
+		t.Fatalf("unable to decrypt the message: %s", err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatalf("missing the last record delimiter")
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestEncryptPushMessage(t *testing.T) {
+	uaPrivate, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := elliptic.Marshal(elliptic.P256(), x, y)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	s := pushSubscription{Endpoint: "https://push.example/send/f00", P256dh: b64.EncodeToString(uaPublic), Auth: b64.EncodeToString(authSecret)}
+	payload := []byte(`{"title":"test replied to you"}`)
+	body, err := encryptPushMessage(s, payload)
+	if err != nil {
+		t.Fatalf("unable to encrypt the message: %s", err)
+	}
+	if got := decryptPushMessage(t, uaPrivate, uaPublic, authSecret, body); !bytes.Equal(got, payload) {
+		t.Errorf("expected %s, got %s", payload, got)
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &webPush{key: key, subject: "mailto:admin@littr.example"}
+	auth, err := w.vapidAuthorization("https://push.example/send/f00")
+	if err != nil {
+		t.Fatalf("unable to sign the request: %s", err)
+	}
+	if !strings.HasPrefix(auth, "vapid t=") || !strings.HasSuffix(auth, ", k="+w.PublicKey()) {
+		t.Fatalf("unexpected Authorization header %q", auth)
+	}
+	jwt := strings.TrimPrefix(strings.Split(auth, ", ")[0], "vapid t=")
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid JWT %q", jwt)
+	}
+	sig, _ := b64.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+		t.Errorf("invalid JWT signature")
+	}
+	if claims, _ := b64.DecodeString(parts[1]); !bytes.Contains(claims, []byte(`"aud":"https://push.example"`)) {
+		t.Errorf("unexpected claims %s", claims)
+	}
+
+	loaded, err := vapidKeyFromString(b64.EncodeToString(fillBytes(key.D, make([]byte, 32))))
+	if err != nil || loaded.X.Cmp(key.X) != 0 || loaded.Y.Cmp(key.Y) != 0 {
+		t.Errorf("unable to load the key back: %v", err)
+	}
+}
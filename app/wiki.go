@@ -0,0 +1,557 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	j "github.com/go-ap/jsonld"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// wikiMaxRevisions is the number of revisions we keep in memory for a page, the older ones are only in fedbox
+	wikiMaxRevisions = 50
+
+	// WikiEditModerators allows only the moderators and the creator of the board to edit its wiki
+	WikiEditModerators = "moderators"
+	// WikiEditSubscribers allows the accounts subscribed to the board to edit its wiki too
+	WikiEditSubscribers = "subscribers"
+	// WikiEditLogged allows all the logged accounts to edit the wiki of the board
+	WikiEditLogged = "logged"
+)
+
+// wikiRevision is a version of a wiki page, it's the Create or Update activity of the page's Article
+type wikiRevision struct {
+	ID      int
+	Title   string
+	Content string
+	Summary string
+	// By is the handle of the account which edited the page, and Actor is its IRI
+	By    string
+	Actor pub.IRI
+	At    time.Time
+}
+
+// wikiPage is a markdown page of a board's wiki, with its latest revisions, the last one is the current version.
+// It's saved in fedbox as an Article, in the wiki collection of the board.
+type wikiPage struct {
+	id        pub.IRI
+	Board     string
+	Slug      string
+	Locked    bool
+	Revisions []wikiRevision
+}
+
+// Current returns the latest revision of the page
+func (p wikiPage) Current() wikiRevision {
+	if len(p.Revisions) == 0 {
+		return wikiRevision{}
+	}
+	return p.Revisions[len(p.Revisions)-1]
+}
+
+// Revision returns the revision with the id
+func (p wikiPage) Revision(id int) (wikiRevision, bool) {
+	for _, rev := range p.Revisions {
+		if rev.ID == id {
+			return rev, true
+		}
+	}
+	return wikiRevision{}, false
+}
+
+// Link returns the URL of the page
+func (p wikiPage) Link() string {
+	return fmt.Sprintf("/b/%s/wiki/%s", p.Board, p.Slug)
+}
+
+// wikiCollectionIRI returns the IRI of the collection of the wiki pages of the board
+func wikiCollectionIRI(name string) pub.IRI {
+	return pub.IRI(boardLink(name) + "/wiki")
+}
+
+// Article returns the ActivityPub representation of the current revision of the page
+func (p wikiPage) Article() *pub.Object {
+	cur := p.Current()
+	a := pub.ObjectNew(pub.ArticleType)
+	a.ID = p.id
+	a.URL = pub.IRI(Instance.BaseURL + p.Link())
+	a.Name.Set(pub.NilLangRef, pub.Content(cur.Title))
+	a.MediaType = MimeTypeHTML
+	a.Content.Set(pub.NilLangRef, pub.Content(Markdown(cur.Content)))
+	a.Source.MediaType = MimeTypeMarkdown
+	a.Source.Content.Set(pub.NilLangRef, pub.Content(cur.Content))
+	a.Audience = pub.ItemCollection{pub.IRI(boardLink(p.Board))}
+	a.Context = wikiCollectionIRI(p.Board)
+	if len(p.Revisions) > 0 {
+		a.AttributedTo = p.Revisions[0].Actor
+		a.Published = p.Revisions[0].At
+	}
+	a.Updated = cur.At
+	return a
+}
+
+// wikiPageFromActivity loads the page, and the revision, from the Create or Update activity of its Article
+// We're storing the handle of the editor in the activity, so we can show the history of the page
+// without dereferencing every actor.
+func wikiPageFromActivity(it pub.Item) (wikiPage, wikiRevision, error) {
+	p, rev := wikiPage{}, wikiRevision{}
+	if it == nil {
+		return p, rev, errors.Newf("nil item received")
+	}
+	err := pub.OnActivity(it, func(a *pub.Activity) error {
+		if a.Object == nil || a.Object.GetType() != pub.ArticleType {
+			return errors.NotValidf("the wiki pages need to be Articles")
+		}
+		rev.By = a.Name.First().Value.String()
+		rev.Summary = a.Summary.First().Value.String()
+		rev.At = a.Published
+		if a.Actor != nil {
+			rev.Actor = a.Actor.GetLink()
+		}
+		return pub.OnObject(a.Object, func(o *pub.Object) error {
+			if o.Context == nil || o.URL == nil {
+				return errors.NotValidf("the wiki page is missing its board")
+			}
+			p.id = o.GetLink()
+			p.Board = strings.TrimSuffix(strings.TrimPrefix(o.Context.GetLink().String(), boardLink("")), "/wiki")
+			p.Slug = path.Base(o.URL.GetLink().String())
+			rev.Title = o.Name.First().Value.String()
+			rev.Content = o.Source.Content.First().Value.String()
+			if rev.At.IsZero() {
+				rev.At = o.Updated
+			}
+			return nil
+		})
+	})
+	if err == nil && (!boardNameRe.MatchString(p.Board) || !validPageSlug.MatchString(p.Slug)) {
+		err = errors.NotValidf("invalid wiki page %s/%s", p.Board, p.Slug)
+	}
+	return p, rev, err
+}
+
+// wiki are the wiki pages of the boards, by the name of the board and their slug.
+// They're loaded from fedbox, where saveFn stores the new revisions.
+type wiki struct {
+	m      sync.RWMutex
+	saveFn func(wikiPage) (wikiPage, error)
+	Pages  map[string]map[string]wikiPage
+}
+
+// newWiki returns the wiki which gets saved with saveFn, or kept only in memory when it's nil
+func newWiki(saveFn func(wikiPage) (wikiPage, error)) *wiki {
+	return &wiki{saveFn: saveFn, Pages: make(map[string]map[string]wikiPage)}
+}
+
+// load replaces the pages with the ones loaded from fedbox
+func (wk *wiki) load(pages []wikiPage) {
+	wk.m.Lock()
+	defer wk.m.Unlock()
+	wk.Pages = make(map[string]map[string]wikiPage)
+	for _, p := range pages {
+		if _, ok := wk.Pages[p.Board]; !ok {
+			wk.Pages[p.Board] = make(map[string]wikiPage)
+		}
+		wk.Pages[p.Board][p.Slug] = p
+	}
+}
+
+// List returns the pages of the board, sorted by their slug
+func (wk *wiki) List(board string) []wikiPage {
+	if wk == nil {
+		return nil
+	}
+	wk.m.RLock()
+	defer wk.m.RUnlock()
+	list := make([]wikiPage, 0, len(wk.Pages[board]))
+	for _, p := range wk.Pages[board] {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Slug < list[j].Slug
+	})
+	return list
+}
+
+// Get returns the page of the board with the slug
+func (wk *wiki) Get(board, slug string) (wikiPage, bool) {
+	if wk == nil {
+		return wikiPage{}, false
+	}
+	wk.m.RLock()
+	defer wk.m.RUnlock()
+	p, ok := wk.Pages[board][slug]
+	return p, ok
+}
+
+// Save adds a new revision to the page, creating it if it doesn't exist
+func (wk *wiki) Save(board, slug string, rev wikiRevision) error {
+	if !validPageSlug.MatchString(slug) {
+		return errors.BadRequestf("the page name can only contain lower case letters, digits, dashes and underscores")
+	}
+	if rev.Title = strings.TrimSpace(rev.Title); len(rev.Title) == 0 {
+		rev.Title = ToTitle(strings.Replace(slug, "-", " ", -1))
+	}
+	if rev.Content = strings.TrimSpace(rev.Content); len(rev.Content) == 0 {
+		return errors.BadRequestf("the page can't be empty")
+	}
+	wk.m.Lock()
+	defer wk.m.Unlock()
+	p, ok := wk.Pages[board][slug]
+	if !ok {
+		p = wikiPage{Board: board, Slug: slug}
+	}
+	if cur := p.Current(); ok && cur.Title == rev.Title && cur.Content == rev.Content {
+		return nil
+	}
+	rev.ID = p.Current().ID + 1
+	rev.At = time.Now().UTC()
+	p.Revisions = append(p.Revisions, rev)
+	if len(p.Revisions) > wikiMaxRevisions {
+		p.Revisions = p.Revisions[len(p.Revisions)-wikiMaxRevisions:]
+	}
+	if wk.saveFn != nil {
+		saved, err := wk.saveFn(p)
+		if err != nil {
+			return err
+		}
+		p = saved
+	}
+	if _, ok := wk.Pages[board]; !ok {
+		wk.Pages[board] = make(map[string]wikiPage)
+	}
+	wk.Pages[board][slug] = p
+	return nil
+}
+
+// saveWikiPage creates the Article of the wiki page in fedbox, or updates it with the current revision,
+// as the account which edited it
+func (r *repository) saveWikiPage(ctx context.Context, p wikiPage) (wikiPage, error) {
+	rev := p.Current()
+	if len(rev.Actor) == 0 {
+		return p, errors.Unauthorizedf("invalid account %s", rev.By)
+	}
+	fx := r.fedbox.Service()
+	art := p.Article()
+	art.To = pub.ItemCollection{pub.PublicNS}
+	art.BCC = pub.ItemCollection{fx.ID}
+
+	act := &pub.Activity{
+		Type:    pub.UpdateType,
+		To:      pub.ItemCollection{pub.PublicNS},
+		BCC:     pub.ItemCollection{fx.ID},
+		Actor:   rev.Actor,
+		Object:  art,
+		Updated: rev.At,
+	}
+	act.Name = pub.NaturalLanguageValuesNew()
+	act.Name.Set(pub.NilLangRef, pub.Content(rev.By))
+	if len(rev.Summary) > 0 {
+		act.Summary = pub.NaturalLanguageValuesNew()
+		act.Summary.Set(pub.NilLangRef, pub.Content(rev.Summary))
+	}
+	if len(p.id) == 0 {
+		act.Type = pub.CreateType
+	}
+	_, ap, err := r.fedbox.ToOutbox(ctx, act)
+	if err != nil {
+		r.errFn(log.Ctx{"err": err, "board": p.Board, "page": p.Slug})("wiki page save failed")
+		return p, err
+	}
+	if len(p.id) == 0 {
+		p.id = createdIRI(ap)
+	}
+	return p, nil
+}
+
+// loadWiki loads the wiki pages of the boards from fedbox, with their revisions from the activities of their Articles
+func (r *repository) loadWiki(ctx context.Context) error {
+	pages := make(map[pub.IRI]wikiPage)
+	activitiesFn := func(ctx context.Context, f *Filters) (pub.CollectionInterface, error) {
+		return r.fedbox.Activities(ctx, Values(f))
+	}
+	f := &Filters{
+		Type: ActivityTypesFilter(pub.CreateType, pub.UpdateType),
+		Object: &Filters{
+			Type: ActivityTypesFilter(pub.ArticleType),
+			OP:   CompStrs{LikeString(boardLink(""))},
+		},
+		MaxItems: MaxContentItems,
+	}
+	// NOTE(marius): the activities are ordered newest first, so we prepend the older revisions
+	err := LoadFromCollection(ctx, activitiesFn, &colCursor{filters: f}, func(c pub.CollectionInterface) (bool, error) {
+		for _, it := range c.Collection() {
+			p, rev, err := wikiPageFromActivity(it)
+			if err != nil {
+				r.errFn(log.Ctx{"err": err, "iri": it.GetLink()})("unable to load the wiki page")
+				continue
+			}
+			if loaded, ok := pages[p.id]; ok {
+				p = loaded
+			}
+			p.Revisions = append([]wikiRevision{rev}, p.Revisions...)
+			pages[p.id] = p
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the wiki pages")
+	}
+	list := make([]wikiPage, 0, len(pages))
+	for _, p := range pages {
+		for i := range p.Revisions {
+			p.Revisions[i].ID = i + 1
+		}
+		if len(p.Revisions) > wikiMaxRevisions {
+			p.Revisions = p.Revisions[len(p.Revisions)-wikiMaxRevisions:]
+		}
+		list = append(list, p)
+	}
+	r.wiki.load(list)
+	r.infoFn(log.Ctx{"pages": len(list)})("loaded the wiki pages")
+	return nil
+}
+
+// canEditWiki returns if acc can edit the page of the board's wiki, by the policy the board's moderators chose
+func (h *handler) canEditWiki(acc *Account, b board, p wikiPage) bool {
+	if canEditBoard(&h.conf.Configuration, acc, b) {
+		return true
+	}
+	if !acc.IsLogged() || p.Locked {
+		return false
+	}
+	switch b.WikiEdit {
+	case WikiEditLogged:
+		return true
+	case WikiEditSubscribers:
+		return h.storage.boards.IsSubscribed(acc.Hash, b.Name)
+	}
+	return false
+}
+
+type wikiModel struct {
+	Title    string
+	Board    board
+	Pages    []wikiPage
+	Page     wikiPage
+	Revision wikiRevision
+	// Mode is the view of the wiki: the list of pages, a page, its history or the edit form
+	Mode    string
+	CanEdit bool
+	CanLock bool
+}
+
+func (m *wikiModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (wikiModel) Template() string {
+	return "wiki"
+}
+
+// loadWikiPage returns the board, and the page of its wiki, from the URL
+func (h *handler) loadWikiPage(r *http.Request) (*wikiModel, bool, error) {
+	name := chi.URLParam(r, "name")
+	b, ok := h.storage.boards.Get(name)
+	if !ok {
+		return nil, false, errors.NotFoundf("board %s", name)
+	}
+	slug := chi.URLParam(r, "slug")
+	if !validPageSlug.MatchString(slug) {
+		return nil, false, errors.NotFoundf("wiki page %q", slug)
+	}
+	p, exists := h.storage.wiki.Get(b.Name, slug)
+	if !exists {
+		p = wikiPage{Board: b.Name, Slug: slug}
+	}
+	p.Locked = stringInSlice(b.WikiLocked)(slug)
+	acc := loggedAccount(r)
+	m := &wikiModel{
+		Board:    b,
+		Page:     p,
+		Revision: p.Current(),
+		CanEdit:  h.canEditWiki(acc, b, p),
+		CanLock:  exists && canEditBoard(&h.conf.Configuration, acc, b),
+	}
+	return m, exists, nil
+}
+
+func (h *handler) writeActivityPubObject(w http.ResponseWriter, r *http.Request, it pub.Item) {
+	dat, err := j.WithContext(j.IRI(pub.ActivityBaseURI)).Marshal(it)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	writeActivityPub(w, dat)
+}
+
+// HandleWikiIndex serves the /b/{name}/wiki requests, with the list of the wiki pages of the board, or their
+// ActivityPub collection
+func (h *handler) HandleWikiIndex(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	b, ok := h.storage.boards.Get(name)
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("board %s", name))
+		return
+	}
+	pages := h.storage.wiki.List(b.Name)
+	if wantsActivityPub(r) {
+		col := pub.OrderedCollectionNew(wikiCollectionIRI(b.Name))
+		col.Name.Set(pub.NilLangRef, pub.Content(fmt.Sprintf("Wiki of %s", b.Title)))
+		col.Audience = pub.ItemCollection{b.IRI()}
+		for _, p := range pages {
+			col.OrderedItems = append(col.OrderedItems, p.Article())
+		}
+		col.TotalItems = uint(len(col.OrderedItems))
+		h.writeActivityPubObject(w, r, col)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	m := &wikiModel{
+		Title:   fmt.Sprintf("Wiki of %s", b.Title),
+		Board:   b,
+		Pages:   pages,
+		Mode:    "index",
+		CanEdit: h.canEditWiki(loggedAccount(r), b, wikiPage{}),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleNewWikiPage handles the POST /b/{name}/wiki requests, which go to the edit form of a new page
+func (h *handler) HandleNewWikiPage(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	slug := strings.ToLower(strings.TrimSpace(r.PostFormValue("slug")))
+	if !validPageSlug.MatchString(slug) {
+		h.v.addFlashMessage(Error, w, r, "The page name can only contain lower case letters, digits, dashes and underscores.")
+		h.v.Redirect(w, r, fmt.Sprintf("/b/%s/wiki", name), http.StatusSeeOther)
+		return
+	}
+	h.v.Redirect(w, r, fmt.Sprintf("/b/%s/wiki/%s/edit", name, slug), http.StatusSeeOther)
+}
+
+// HandleWikiPage serves the /b/{name}/wiki/{slug} requests, with the current revision of the page,
+// or the one from the ?rev= parameter
+func (h *handler) HandleWikiPage(w http.ResponseWriter, r *http.Request) {
+	m, exists, err := h.loadWikiPage(r)
+	if err == nil && !exists {
+		err = errors.NotFoundf("wiki page %s", chi.URLParam(r, "slug"))
+	}
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if wantsActivityPub(r) {
+		h.writeActivityPubObject(w, r, m.Page.Article())
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if rev := r.URL.Query().Get("rev"); len(rev) > 0 {
+		id, _ := strconv.Atoi(rev)
+		var ok bool
+		if m.Revision, ok = m.Page.Revision(id); !ok {
+			h.v.HandleErrors(w, r, errors.NotFoundf("revision %s", rev))
+			return
+		}
+	}
+	m.Title = m.Revision.Title
+	m.Mode = "page"
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleWikiHistory serves the /b/{name}/wiki/{slug}/history requests, with the revisions of the page
+func (h *handler) HandleWikiHistory(w http.ResponseWriter, r *http.Request) {
+	m, exists, err := h.loadWikiPage(r)
+	if err == nil && !exists {
+		err = errors.NotFoundf("wiki page %s", chi.URLParam(r, "slug"))
+	}
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m.Title = fmt.Sprintf("History of %s", m.Revision.Title)
+	m.Mode = "history"
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleWikiEditForm serves the /b/{name}/wiki/{slug}/edit GET requests, with the form for editing,
+// or creating, the page
+func (h *handler) HandleWikiEditForm(w http.ResponseWriter, r *http.Request) {
+	m, exists, err := h.loadWikiPage(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if !m.CanEdit {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("you can't edit the wiki of %s", m.Board.Title))
+		return
+	}
+	m.Title = fmt.Sprintf("Edit %s", m.Revision.Title)
+	if !exists {
+		m.Title = fmt.Sprintf("New page in the wiki of %s", m.Board.Title)
+	}
+	m.Mode = "edit"
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleWikiEdit handles the /b/{name}/wiki/{slug}/edit POST requests, which save a new revision of the page
+func (h *handler) HandleWikiEdit(w http.ResponseWriter, r *http.Request) {
+	m, _, err := h.loadWikiPage(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if !m.CanEdit {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("you can't edit the wiki of %s", m.Board.Title))
+		return
+	}
+	acc := loggedAccount(r)
+	rev := wikiRevision{
+		Title:   r.PostFormValue("title"),
+		Content: r.PostFormValue("content"),
+		Summary: strings.TrimSpace(r.PostFormValue("summary")),
+		By:      acc.Handle,
+		Actor:   accountIRI(acc),
+	}
+	lCtx := log.Ctx{"board": m.Board.Name, "page": m.Page.Slug, "author": acc.Handle}
+	if err := h.storage.wiki.Save(m.Board.Name, m.Page.Slug, rev); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the wiki page")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save the page: %s", err))
+		h.v.Redirect(w, r, m.Page.Link()+"/edit", http.StatusSeeOther)
+		return
+	}
+	h.infoFn(lCtx)("wiki page saved")
+	h.v.Redirect(w, r, m.Page.Link(), http.StatusSeeOther)
+}
+
+// HandleWikiLock handles the /b/{name}/wiki/{slug}/lock POST requests, which stop, or allow again, the edits
+// of the page for the accounts which are not moderators of the board
+func (h *handler) HandleWikiLock(w http.ResponseWriter, r *http.Request) {
+	m, _, err := h.loadWikiPage(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if !m.CanLock {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("only the moderators of %s can lock its wiki pages", m.Board.Title))
+		return
+	}
+	locked := r.PostFormValue("action") != "unlock"
+	lCtx := log.Ctx{"board": m.Board.Name, "page": m.Page.Slug, "moderator": loggedAccount(r).Handle, "locked": locked}
+	if err := h.storage.boards.LockWikiPage(m.Board.Name, m.Page.Slug, locked); err != nil {
+		h.errFn(lCtx, log.Ctx{"err": err})("unable to save the wiki page")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.infoFn(lCtx)("wiki page lock changed")
+	h.v.Redirect(w, r, m.Page.Link(), http.StatusSeeOther)
+}
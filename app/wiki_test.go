@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestWiki(t *testing.T) {
+	wk := newWiki(nil)
+	if err := wk.Save("golang", "Not Valid", wikiRevision{Content: "text"}); err == nil {
+		t.Errorf("saved a page with an invalid slug")
+	}
+	if err := wk.Save("golang", "faq", wikiRevision{Content: "  "}); err == nil {
+		t.Errorf("saved an empty page")
+	}
+	if err := wk.Save("golang", "getting-started", wikiRevision{Content: "Install it", By: "johndoe"}); err != nil {
+		t.Fatalf("unable to save the page: %s", err)
+	}
+	// NOTE(marius): saving the same content again doesn't add a revision
+	if err := wk.Save("golang", "getting-started", wikiRevision{Title: "Getting started", Content: "Install it"}); err != nil {
+		t.Fatalf("unable to save the page: %s", err)
+	}
+	if err := wk.Save("golang", "getting-started", wikiRevision{Content: "Install it, then run it"}); err != nil {
+		t.Fatalf("unable to save the page: %s", err)
+	}
+	for i := 0; i <= wikiMaxRevisions; i++ {
+		if err := wk.Save("golang", "faq", wikiRevision{Content: fmt.Sprintf("version %d", i)}); err != nil {
+			t.Fatalf("unable to save the page: %s", err)
+		}
+	}
+
+	if list := wk.List("golang"); len(list) != 2 || list[0].Slug != "faq" {
+		t.Errorf("unexpected pages %v", list)
+	}
+	p, _ := wk.Get("golang", "getting-started")
+	if len(p.Revisions) != 2 || p.Revisions[0].Title != "Getting started" || p.Current().ID != 2 {
+		t.Errorf("unexpected revisions %v", p.Revisions)
+	}
+	faq, _ := wk.Get("golang", "faq")
+	if len(faq.Revisions) != wikiMaxRevisions || faq.Current().ID != wikiMaxRevisions+1 {
+		t.Errorf("expected the last %d revisions, got %d, the current one being %d", wikiMaxRevisions, len(faq.Revisions), faq.Current().ID)
+	}
+	if _, ok := faq.Revision(1); ok {
+		t.Errorf("the oldest revision was not dropped")
+	}
+}
+
+func TestWikiLock(t *testing.T) {
+	bb := newBoards(nil)
+	if err := bb.Add(board{Name: "golang"}); err != nil {
+		t.Fatalf("unable to create the board: %s", err)
+	}
+	if err := bb.LockWikiPage("golang", "faq", true); err != nil {
+		t.Fatalf("unable to lock the page: %s", err)
+	}
+	if err := bb.LockWikiPage("golang", "faq", true); err != nil {
+		t.Fatalf("unable to lock the page: %s", err)
+	}
+	if err := bb.LockWikiPage("missing", "faq", true); err == nil {
+		t.Errorf("locked a page of a board which doesn't exist")
+	}
+	if b, _ := bb.Get("golang"); len(b.WikiLocked) != 1 || b.WikiLocked[0] != "faq" {
+		t.Errorf("unexpected locked pages %v", b.WikiLocked)
+	}
+	if err := bb.LockWikiPage("golang", "faq", false); err != nil {
+		t.Fatalf("unable to unlock the page: %s", err)
+	}
+	if b, _ := bb.Get("golang"); len(b.WikiLocked) != 0 {
+		t.Errorf("the page is still locked %v", b.WikiLocked)
+	}
+}
+
+func TestWikiPageFromActivity(t *testing.T) {
+	p := wikiPage{
+		id:    "https://fedbox.example.com/objects/1",
+		Board: "golang",
+		Slug:  "getting-started",
+		Revisions: []wikiRevision{
+			{ID: 1, Title: "Getting started", Content: "Install it", By: "jane", Actor: "https://fedbox.example.com/actors/1"},
+		},
+	}
+	act := &pub.Activity{Type: pub.UpdateType, Actor: pub.IRI("https://fedbox.example.com/actors/1"), Object: p.Article()}
+	act.Name = pub.NaturalLanguageValuesNew()
+	act.Name.Set(pub.NilLangRef, pub.Content("jane"))
+	act.Summary = pub.NaturalLanguageValuesNew()
+	act.Summary.Set(pub.NilLangRef, pub.Content("first version"))
+
+	loaded, rev, err := wikiPageFromActivity(act)
+	if err != nil {
+		t.Fatalf("unable to load the wiki page: %s", err)
+	}
+	if loaded.id != p.id || loaded.Board != "golang" || loaded.Slug != "getting-started" {
+		t.Errorf("unexpected page %+v", loaded)
+	}
+	if rev.Title != "Getting started" || rev.Content != "Install it" || rev.By != "jane" || rev.Summary != "first version" || rev.Actor != act.Actor.GetLink() {
+		t.Errorf("unexpected revision %+v", rev)
+	}
+
+	note := &pub.Activity{Type: pub.CreateType, Object: &pub.Object{Type: pub.NoteType}}
+	if _, _, err := wikiPageFromActivity(note); err == nil {
+		t.Errorf("loaded a wiki page from a Note")
+	}
+	outside := p.Article()
+	outside.Context = pub.IRI("https://fedbox.example.com/objects/2")
+	if _, _, err := wikiPageFromActivity(&pub.Activity{Type: pub.CreateType, Object: outside}); err == nil {
+		t.Errorf("loaded a wiki page from an Article outside the boards")
+	}
+}
@@ -1,24 +1,11 @@
 OnReady( function() {
-    let isInverted = function () { return getCookie("inverted") == "true" || false; };
     let haveModals = function() { return (typeof  document.createElement('dialog').showModal === "function"); };
 
-    let root = $("html")[0];
-    if (isInverted()) {
-        root.classList.add("inverted");
-    } else {
-        root.classList.remove("inverted");
+    // NOTE(marius): the flash messages get the focus, so the screen readers announce them before the page
+    let flashes = $("#flashes")[0];
+    if (flashes != undefined) {
+        flashes.focus();
     }
-    addEvent($("#invert")[0], "click", function(e) {
-        if (isInverted()) {
-            root.classList.remove("inverted");
-            deleteCookie("inverted");
-        } else {
-            root.classList.add("inverted");
-            setCookie("inverted", true);
-        }
-        e.preventDefault();
-        e.stopPropagation();
-    });
     $(".score a").forEach(function(lnk) {
         if(lnk.getAttribute("href") != "#") { return; }
         addEvent(lnk, "click", function(e){
@@ -70,6 +57,238 @@ OnReady( function() {
             }
         });
     });
+    $("button.copy-link").forEach(function (btn) {
+        if (!navigator.clipboard) { return; }
+        btn.hidden = false;
+        addEvent(btn, "click", function(e) {
+            e.preventDefault();
+            navigator.clipboard.writeText(btn.getAttribute("data-link")).then(function () {
+                btn.textContent = "Copied";
+            });
+        });
+    });
+    $("button.web-share").forEach(function (btn) {
+        if (typeof navigator.share !== "function") { return; }
+        btn.hidden = false;
+        addEvent(btn, "click", function(e) {
+            e.preventDefault();
+            navigator.share({ title: btn.getAttribute("data-title"), url: btn.getAttribute("data-link") }).catch(function () {});
+        });
+    });
+    $("p.fetched-title").forEach(function (box) {
+        let form = box.closest("form");
+        let data = form.querySelector("#submit-data");
+        let title = form.querySelector("#submit-title");
+        if (data == undefined || title == undefined || typeof window.fetch !== "function") { return; }
+        addEvent(data, "change", function() {
+            box.hidden = true;
+            let u = data.value.trim();
+            if (title.value.trim().length > 0 || !/^https?:\/\/\S+$/.test(u)) { return; }
+            fetch("/submit/title?url=" + encodeURIComponent(u), { credentials: "same-origin" }).then(function (res) {
+                return res.ok ? res.json() : {};
+            }).then(function (dat) {
+                if (!dat.title || title.value.trim().length > 0) { return; }
+                box.querySelector("q").textContent = dat.title;
+                box.hidden = false;
+            }).catch(function () {});
+        });
+        addEvent(box.querySelector("button.use-title"), "click", function(e) {
+            e.preventDefault();
+            title.value = box.querySelector("q").textContent;
+            box.hidden = true;
+        });
+    });
+    $("textarea#submit-data").forEach(function (area) {
+        if (area.disabled || typeof window.fetch !== "function") { return; }
+        let kinds = { "@": "handles", "~": "handles", "#": "tags" };
+        let list = document.createElement("ul");
+        list.className = "completions";
+        list.hidden = true;
+        area.after(list);
+        let timer, word;
+        let current = function () {
+            let before = area.value.slice(0, area.selectionStart);
+            let m = before.match(/(?:^|\s)([@~#])([\w-]{1,64})$/);
+            if (!m) { return null; }
+            return { sigil: m[1], prefix: m[2], start: before.length - m[2].length };
+        };
+        let pick = function (name) {
+            let end = word.start + word.prefix.length;
+            area.value = area.value.slice(0, word.start) + name + " " + area.value.slice(end);
+            area.selectionStart = area.selectionEnd = word.start + name.length + 1;
+            list.hidden = true;
+            area.focus();
+        };
+        addEvent(area, "input", function () {
+            window.clearTimeout(timer);
+            word = current();
+            if (!word) { list.hidden = true; return; }
+            timer = window.setTimeout(function () {
+                fetch("/complete/" + kinds[word.sigil] + "?q=" + encodeURIComponent(word.prefix), { credentials: "same-origin" }).then(function (res) {
+                    return res.ok ? res.json() : [];
+                }).then(function (names) {
+                    list.innerHTML = "";
+                    names.forEach(function (n) {
+                        let li = document.createElement("li");
+                        let btn = document.createElement("button");
+                        btn.type = "button";
+                        btn.textContent = word.sigil + n.name;
+                        addEvent(btn, "click", function (e) {
+                            e.preventDefault();
+                            pick(n.name);
+                        });
+                        li.appendChild(btn);
+                        list.appendChild(li);
+                    });
+                    list.hidden = names.length == 0;
+                }).catch(function () {});
+            }, 250);
+        });
+        addEvent(area, "keydown", function (e) {
+            if (e.key == "Escape") { list.hidden = true; }
+            if (e.key == "ArrowDown" && !list.hidden) {
+                e.preventDefault();
+                list.querySelector("button").focus();
+            }
+        });
+    });
+    $("button[formaction='/preview']").forEach(function (btn) {
+        let form = btn.closest("form");
+        let area = form.querySelector("textarea#submit-data");
+        if (area == undefined || typeof window.fetch !== "function") { return; }
+        let box = document.createElement("section");
+        box.className = "live-preview";
+        box.setAttribute("aria-live", "polite");
+        box.hidden = true;
+        form.after(box);
+        let timer;
+        addEvent(area, "input", function () {
+            window.clearTimeout(timer);
+            timer = window.setTimeout(function () {
+                if (area.value.trim().length == 0) { box.hidden = true; return; }
+                fetch("/api/preview", { method: "POST", credentials: "same-origin", body: new FormData(form) }).then(function (res) {
+                    return res.ok ? res.json() : {};
+                }).then(function (dat) {
+                    if (dat.html == undefined) { return; }
+                    box.innerHTML = "<h2>Preview</h2>" + dat.html;
+                    box.hidden = false;
+                }).catch(function () {});
+            }, 500);
+        });
+    });
+    $("a.translate").forEach(function (lnk) {
+        if (typeof window.fetch !== "function") { return; }
+        addEvent(lnk, "click", function(e) {
+            let item = lnk.closest("section.item");
+            if (item == undefined) { return; }
+            e.preventDefault();
+            let shown = item.querySelector(".translation");
+            if (shown != undefined) {
+                shown.hidden = !shown.hidden;
+                return;
+            }
+            fetch(lnk.getAttribute("href"), { credentials: "same-origin", headers: { "Accept": "application/json" } }).then(function (res) {
+                if (!res.ok) { throw new Error(res.statusText); }
+                return res.json();
+            }).then(function (tr) {
+                let el = document.createElement("div");
+                el.classList.add("translation");
+                el.setAttribute("lang", tr.lang);
+                if (tr.title) {
+                    let t = document.createElement("strong");
+                    t.textContent = tr.title;
+                    el.appendChild(t);
+                }
+                if (tr.text) {
+                    let txt = document.createElement("div");
+                    txt.classList.add("translated");
+                    txt.textContent = tr.text;
+                    el.appendChild(txt);
+                }
+                let attr = document.createElement("small");
+                attr.textContent = "Translated " + (tr.from ? "from " + tr.from + " " : "") + "by " + tr.provider;
+                el.appendChild(attr);
+                item.insertBefore(el, item.querySelector("footer.meta"));
+            }).catch(function () {
+                lnk.textContent = "translation failed";
+            });
+        });
+    });
+    $("form.web-push").forEach(function (form) {
+        if (!("serviceWorker" in navigator) || !("PushManager" in window)) { return; }
+        let sub = form.querySelector("button.push-subscribe");
+        let unsub = form.querySelector("button.push-unsubscribe");
+        let send = function (action, s) {
+            let act = document.createElement("input");
+            act.type = "hidden";
+            act.name = "action";
+            act.value = action;
+            form.appendChild(act);
+            let j = s.toJSON();
+            form.querySelector("input[name='endpoint']").value = j.endpoint;
+            if (j.keys) {
+                form.querySelector("input[name='p256dh']").value = j.keys.p256dh;
+                form.querySelector("input[name='auth']").value = j.keys.auth;
+            }
+            form.submit();
+        };
+        let serverKey = function () {
+            let k = atob(form.getAttribute("data-key").replace(/-/g, "+").replace(/_/g, "/"));
+            return Uint8Array.from(k, function (c) { return c.charCodeAt(0); });
+        };
+        navigator.serviceWorker.register("/sw.js").then(function (reg) {
+            return reg.pushManager.getSubscription().then(function (s) {
+                if (s) {
+                    unsub.hidden = false;
+                    addEvent(unsub, "click", function (e) {
+                        e.preventDefault();
+                        s.unsubscribe().then(function () { send("unsubscribe", s); });
+                    });
+                    return;
+                }
+                sub.hidden = false;
+                addEvent(sub, "click", function (e) {
+                    e.preventDefault();
+                    reg.pushManager.subscribe({ userVisibleOnly: true, applicationServerKey: serverKey() }).then(function (s) {
+                        send("subscribe", s);
+                    }).catch(function () {
+                        sub.textContent = "Notifications are blocked in this browser";
+                    });
+                });
+            });
+        }).catch(function () {});
+    });
+    $("form.quiet-hours input[name='timezone']").forEach(function (tz) {
+        if (tz.value == "" && window.Intl) {
+            tz.value = Intl.DateTimeFormat().resolvedOptions().timeZone || "";
+        }
+    });
+    $("a.notifications").forEach(function (lnk) {
+        let update = function () {
+            fetch("/notifications/unread", { credentials: "same-origin", headers: { "Accept": "application/json" } }).then(function (res) {
+                return res.ok ? res.json() : null;
+            }).then(function (d) {
+                if (!d) { return; }
+                let badge = lnk.querySelector("data.unread");
+                if (d.unread == 0) {
+                    if (badge) { badge.parentNode.remove(); }
+                    return;
+                }
+                if (!badge) {
+                    let small = document.createElement("small");
+                    badge = document.createElement("data");
+                    badge.className = "unread";
+                    small.appendChild(badge);
+                    lnk.append(" ", small);
+                }
+                badge.value = d.unread;
+                badge.textContent = d.unread;
+            }).catch(function () {});
+        };
+        window.setInterval(function () {
+            if (!document.hidden) { update(); }
+        }, 60000);
+    });
     $("button.close").forEach(function (close) {
         addEvent(close, "click", function(e) {
             e.stopPropagation();
@@ -80,6 +299,10 @@ OnReady( function() {
             } else {
                 el.remove();
             }
+            let main = $("#main")[0];
+            if (main != undefined) {
+                main.focus();
+            }
         });
     });
 });
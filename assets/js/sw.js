@@ -0,0 +1,13 @@
+self.addEventListener("push", function (e) {
+    let msg = e.data ? e.data.json() : {};
+    e.waitUntil(self.registration.showNotification(msg.title || "New notification", {
+        body: msg.body || "",
+        tag: msg.tag,
+        icon: "/favicon.ico",
+        data: { url: msg.url || "/" }
+    }));
+});
+self.addEventListener("notificationclick", function (e) {
+    e.notification.close();
+    e.waitUntil(clients.openWindow(e.notification.data.url));
+});
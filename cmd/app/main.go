@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"syscall"
@@ -63,6 +64,10 @@ func Run(a app.Application) int {
 			a.Logger.Info("SIGUSR1 received, switching to maintenance mode")
 			a.Conf.MaintenanceMode = !a.Conf.MaintenanceMode
 		},
+		syscall.SIGUSR2: func(_ chan int) {
+			a.Logger.Info("SIGUSR2 received, pruning cached content")
+			a.Prune()
+		},
 		syscall.SIGTERM: func(status chan int) {
 			// kill -SIGTERM XXXX
 			a.Logger.Info("SIGTERM received, stopping")
@@ -86,6 +91,40 @@ func Run(a app.Application) int {
 	}
 	return code
 }
+
+// reindex runs the reindex command, which needs the fedbox instance, but not the web-server
+func reindex(c *config.Configuration, host string, port int) int {
+	cnt, err := app.Reindex(context.Background(), c, host, port, version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to rebuild the search index: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Indexed %d items\n", cnt)
+	return 0
+}
+
+// export runs the export command, which writes the anonymized dumps of the items and votes to dir
+func export(c *config.Configuration, host string, port int, dir string) int {
+	items, votes, err := app.DumpAnonymized(context.Background(), c, host, port, version, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to export the data: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Exported %d items and %d votes to %s\n", items, votes, dir)
+	return 0
+}
+
+// mediaGC runs the "media gc" command, which removes the media files the items don't use anymore
+func mediaGC(c *config.Configuration, host string, port int) int {
+	files, size, err := app.MediaGC(context.Background(), c, host, port, version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to remove the orphaned media files: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Removed %d orphaned media files, %d bytes\n", files, size)
+	return 0
+}
+
 func main() {
 	var wait time.Duration
 	var port int
@@ -96,11 +135,34 @@ func main() {
 	flag.IntVar(&port, "port", defaultPort, "the port on which we should listen on")
 	flag.StringVar(&host, "host", "", "the host on which we should listen on")
 	flag.StringVar(&env, "env", "unknown", "the environment type")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [reindex|export [dir]|media gc]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "The reindex command rebuilds the search index from the objects stored in fedbox.\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "The export command writes anonymized CSV files of the items and votes stored in fedbox to dir,\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "the current directory by default. It needs EXPORT_ENABLED to be set. The files are:\n\n%s\n\n", app.DumpSchema)
+		fmt.Fprintf(flag.CommandLine.Output(), "The media gc command removes the media files of the items which were deleted, and the ones\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "we don't know the item of, and updates the media usage counted for the storage quotas.\n\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	c := config.Load(config.EnvType(env), wait)
 	errors.IncludeBacktrace = c.Env.IsDev()
 
+	if flag.Arg(0) == "reindex" {
+		os.Exit(reindex(c, host, port))
+	}
+	if flag.Arg(0) == "export" {
+		dir := flag.Arg(1)
+		if len(dir) == 0 {
+			dir = "."
+		}
+		os.Exit(export(c, host, port, dir))
+	}
+	if flag.Arg(0) == "media" && flag.Arg(1) == "gc" {
+		os.Exit(mediaGC(c, host, port))
+	}
+
 	// Routes
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/go-chi/chi"
 	"html/template"
+	"mime"
 	"net/http"
 	"os"
 	"path"
@@ -133,6 +134,16 @@ func ServeStatic(st string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fullPath := filepath.Clean(filepath.Join(st, chi.URLParam(r, "path")))
 		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(year.Seconds())))
+		// NOTE(marius): we serve the precompressed version of the file if one exists next to it
+		if enc := AcceptedEncoding(r); len(enc) > 0 {
+			if _, err := os.Stat(fullPath + precompressedExt[enc]); err == nil {
+				if typ := mime.TypeByExtension(filepath.Ext(fullPath)); len(typ) > 0 {
+					w.Header().Set("Content-Type", typ)
+				}
+				w.Header().Set("Content-Encoding", enc)
+				fullPath += precompressedExt[enc]
+			}
+		}
 		http.ServeFile(w, r, fullPath)
 	}
 }
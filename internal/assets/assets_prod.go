@@ -18,29 +18,39 @@ var walkFsFn = assets.Walk
 var openFsFn = assets.Open
 
 func writeAsset(s AssetFiles) func(http.ResponseWriter, *http.Request) {
+	// NOTE(marius): we build the contents and their compressed variants before serving any request,
+	// so the handler only reads from the map, and the concurrent requests don't need to synchronize on it
 	assetContents := make(AssetContents)
+	for asset, files := range s {
+		buf := bytes.Buffer{}
+		for _, file := range files {
+			if piece, _ := getFileContent(assetPath(path.Ext(asset)[1:], file)); len(piece) > 0 {
+				buf.Write(piece)
+			}
+		}
+		assetContents[asset] = buf.Bytes()
+		for enc, ext := range precompressedExt {
+			if comp, err := Compress(buf.Bytes(), enc); err == nil {
+				assetContents[asset+ext] = comp
+			}
+		}
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		asset := filepath.Clean(chi.URLParam(r, "path"))
 		ext := path.Ext(r.RequestURI)
 		mimeType := mime.TypeByExtension(ext)
-		files, ok := s[asset]
+		cont, ok := assetContents[asset]
 		if !ok {
 			w.Write([]byte("not found"))
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-
-		cont, ok := assetContents[asset]
-		if !ok {
-			buf := bytes.Buffer{}
-			for _, file := range files {
-				if piece, _ := getFileContent(assetPath(ext[1:], file)); len(piece) > 0 {
-					buf.Write(piece)
-				}
+		if enc := AcceptedEncoding(r); len(enc) > 0 {
+			if comp := assetContents[asset+precompressedExt[enc]]; len(comp) > 0 {
+				w.Header().Set("Content-Encoding", enc)
+				cont = comp
 			}
-			assetContents[asset] = buf.Bytes()
 		}
-		cont = assetContents[asset]
 
 		w.Header().Set("Cache-Control", fmt.Sprintf("public,max-age=%d", int(year.Seconds())))
 		w.Header().Set("Content-Type", mimeType)
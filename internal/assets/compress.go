@@ -0,0 +1,111 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// supportedEncodings are in the order of our preference
+var supportedEncodings = []string{EncodingBrotli, EncodingGzip}
+
+// compressibleTypes are the content types that benefit from compression,
+// the media ones (images, audio, video, archives) are already compressed
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/activity+json",
+	"application/ld+json",
+	"application/javascript",
+	"application/xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+// AcceptedEncoding returns the encoding we should use for the response, or an empty string
+func AcceptedEncoding(r *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc := strings.TrimSpace(part)
+		q := 1.0
+		if i := strings.Index(enc, ";"); i >= 0 {
+			if p := strings.TrimSpace(enc[i+1:]); strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+			enc = strings.TrimSpace(enc[:i])
+		}
+		accepted[strings.ToLower(enc)] = q > 0
+	}
+	for _, enc := range supportedEncodings {
+		if accepted[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// IsCompressible returns if the content type benefits from compression
+func IsCompressible(contentType string) bool {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(typ, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEncoder returns a writer compressing to w with the encoding
+func NewEncoder(w io.Writer, enc string) io.WriteCloser {
+	switch enc {
+	case EncodingBrotli:
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	case EncodingGzip:
+		return gzip.NewWriter(w)
+	}
+	return nil
+}
+
+// Compress returns the data compressed with the encoding
+func Compress(data []byte, enc string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	var c io.WriteCloser
+	switch enc {
+	case EncodingBrotli:
+		// NOTE(marius): static assets get compressed once, so we can afford the best compression
+		c = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	case EncodingGzip:
+		c, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	default:
+		return data, nil
+	}
+	if _, err := c.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var precompressedExt = map[string]string{
+	EncodingBrotli: ".br",
+	EncodingGzip:   ".gz",
+}
@@ -32,12 +32,95 @@ type Configuration struct {
 	UserFollowingEnabled       bool
 	ModerationEnabled          bool
 	MaintenanceMode            bool
+	PagesPath                  string
+	BannerMessage              string
+	BannerLevel                string
+	BannerExpires              time.Time
+	IndexRefreshInterval       time.Duration
+	IndexMaxItems              int
+	DedupTTL                   time.Duration
+	Relays                     []string
+	RelayObjectTypes           []string
+	InstancesBlocked           []string
+	InstancesLimited           []string
+	Retention                  time.Duration
+	PruneInterval              time.Duration
+	RequestTimeout             time.Duration
+	BreakerThreshold           int
+	BreakerCoolDown            time.Duration
+	HTTPMaxIdleConnsPerHost    int
+	HTTPDialTimeout            time.Duration
+	HTTPTLSTimeout             time.Duration
+	HTTPIdleConnTimeout        time.Duration
+	HTTP2Enabled               bool
+	DataPath                   string
+	HandleReservePeriod        time.Duration
+	HandleRenameInterval       time.Duration
+	AvatarLookupURL            string
+	ReaderEnabled              bool
+	ReaderOptOut               []string
+	BotPostsPerHour            int
+	FeedBotsInterval           time.Duration
+	SnapshotsEnabled           bool
+	TitleStripPatterns         []string
+	TranslateProvider          string
+	TranslateURL               string
+	TranslateAPIKey            string
+	ItemsPerPage               int
+	MaxItemsPerPage            int
+	MaxCommentDepth            int
+	DeleteRestoreWindow        time.Duration
+	Admins                     []string
+	Moderators                 []string
+	SlowModeInterval           time.Duration
+	SlowModeDuration           time.Duration
+	PremoderateFirst           int
+	PremoderateAccountAge      time.Duration
+	BanEvasionChecksEnabled    bool
+	BanEvasionWindow           time.Duration
+	BoardCreation              string
+	BoardCreationKarma         int
+	ClassifiedsExpiryDays      int
+	JobsExpiryDays             int
+	JobsAccountAge             time.Duration
+	JobsKarma                  int
+	JobsApproval               bool
+	AskPrefixes                []string
+	ShowPrefixes               []string
+	AskGravity                 float64
+	ShowGravity                float64
+	MailGatewayDomain          string
+	MailGatewaySecret          string
+	SMTPAddress                string
+	SMTPUser                   string
+	SMTPPassword               string
+	MailFrom                   string
+	DigestItems                int
+	DigestTemplate             string
+	WebPush                    bool
+	VAPIDPrivateKey            string
+	VAPIDSubject               string
+	NotificationChannels       []string
+	SearchBackend              string
+	SearchURL                  string
+	SearchAPIKey               string
+	SearchIndex                string
+	TrendingMinAuthors         int
+	ExportEnabled              bool
+	ExportSalt                 string
+	MediaScanner               string
+	MediaScannerURL            string
+	EditWindow                 time.Duration
+	CommentEditWindow          time.Duration
+	MediaQuota                 int64
+	AccountMediaQuota          int64
 }
 
 const (
-	DefaultListenPort = 3000
-	DefaultListenHost = ""
-	Prefix            = "LITTR"
+	DefaultListenPort    = 3000
+	DefaultListenHost    = ""
+	DefaultIndexMaxItems = 2000
+	Prefix               = "LITTR"
 )
 
 const (
@@ -61,6 +144,88 @@ const (
 	KeyDisableUserFollowing       = "DISABLE_USER_FOLLOWING"
 	KeyDisableModeration          = "DISABLE_MODERATION"
 	KeyAdminContact               = "ADMIN_CONTACT"
+	KeyPagesPath                  = "PAGES_PATH"
+	KeyBannerMessage              = "BANNER_MESSAGE"
+	KeyBannerLevel                = "BANNER_LEVEL"
+	KeyBannerExpires              = "BANNER_EXPIRES"
+	KeyIndexRefreshInterval       = "INDEX_REFRESH_INTERVAL"
+	KeyIndexMaxItems              = "INDEX_MAX_ITEMS"
+	KeyDedupTTL                   = "DEDUP_TTL"
+	KeyRelays                     = "RELAYS"
+	KeyRelayObjectTypes           = "RELAY_OBJECT_TYPES"
+	KeyInstancesBlocked           = "INSTANCES_BLOCKED"
+	KeyInstancesLimited           = "INSTANCES_LIMITED"
+	KeyRetentionDays              = "RETENTION_DAYS"
+	KeyPruneInterval              = "PRUNE_INTERVAL"
+	KeyRequestTimeout             = "REQUEST_TIMEOUT"
+	KeyBreakerThreshold           = "BREAKER_THRESHOLD"
+	KeyBreakerCoolDown            = "BREAKER_COOL_DOWN"
+	KeyHTTPMaxIdleConnsPerHost    = "HTTP_MAX_IDLE_CONNS_PER_HOST"
+	KeyHTTPDialTimeout            = "HTTP_DIAL_TIMEOUT"
+	KeyHTTPTLSTimeout             = "HTTP_TLS_TIMEOUT"
+	KeyHTTPIdleConnTimeout        = "HTTP_IDLE_CONN_TIMEOUT"
+	KeyDisableHTTP2               = "DISABLE_HTTP2"
+	KeyDataPath                   = "DATA_PATH"
+	KeyHandleReserveDays          = "HANDLE_RESERVE_DAYS"
+	KeyHandleRenameDays           = "HANDLE_RENAME_DAYS"
+	KeyAvatarLookupURL            = "AVATAR_LOOKUP_URL"
+	KeyDisableReader              = "DISABLE_READER"
+	KeyReaderOptOut               = "READER_OPT_OUT"
+	KeyBotPostsPerHour            = "BOT_POSTS_PER_HOUR"
+	KeyFeedBotsInterval           = "FEED_BOTS_INTERVAL"
+	KeyDisableSnapshots           = "DISABLE_SNAPSHOTS"
+	KeyTitleStripPatterns         = "TITLE_STRIP_PATTERNS"
+	KeyTranslateProvider          = "TRANSLATE_PROVIDER"
+	KeyTranslateURL               = "TRANSLATE_URL"
+	KeyTranslateAPIKey            = "TRANSLATE_API_KEY"
+	KeyItemsPerPage               = "ITEMS_PER_PAGE"
+	KeyMaxItemsPerPage            = "MAX_ITEMS_PER_PAGE"
+	KeyMaxCommentDepth            = "MAX_COMMENT_DEPTH"
+	KeyDeleteRestoreWindow        = "DELETE_RESTORE_WINDOW"
+	KeyAdmins                     = "ADMINS"
+	KeyModerators                 = "MODERATORS"
+	KeySlowModeInterval           = "SLOW_MODE_INTERVAL"
+	KeySlowModeDuration           = "SLOW_MODE_DURATION"
+	KeyPremoderateFirst           = "PREMODERATE_FIRST"
+	KeyPremoderateAccountAge      = "PREMODERATE_ACCOUNT_AGE"
+	KeyDisableBanEvasionChecks    = "DISABLE_BAN_EVASION_CHECKS"
+	KeyBanEvasionWindow           = "BAN_EVASION_WINDOW"
+	KeyBoardCreation              = "BOARD_CREATION"
+	KeyBoardCreationKarma         = "BOARD_CREATION_KARMA"
+	KeyClassifiedsExpiryDays      = "CLASSIFIEDS_EXPIRY_DAYS"
+	KeyJobsExpiryDays             = "JOBS_EXPIRY_DAYS"
+	KeyJobsAccountAge             = "JOBS_ACCOUNT_AGE"
+	KeyJobsKarma                  = "JOBS_KARMA"
+	KeyDisableJobsApproval        = "DISABLE_JOBS_APPROVAL"
+	KeyAskPrefixes                = "ASK_PREFIXES"
+	KeyShowPrefixes               = "SHOW_PREFIXES"
+	KeyAskGravity                 = "ASK_GRAVITY"
+	KeyShowGravity                = "SHOW_GRAVITY"
+	KeyMailGatewayDomain          = "MAIL_GATEWAY_DOMAIN"
+	KeyMailGatewaySecret          = "MAIL_GATEWAY_SECRET"
+	KeySMTPAddress                = "SMTP_ADDRESS"
+	KeySMTPUser                   = "SMTP_USER"
+	KeySMTPPassword               = "SMTP_PASSWORD"
+	KeyMailFrom                   = "MAIL_FROM"
+	KeyDigestItems                = "DIGEST_ITEMS"
+	KeyDigestTemplate             = "DIGEST_TEMPLATE"
+	KeyDisableWebPush             = "DISABLE_WEB_PUSH"
+	KeyVAPIDPrivateKey            = "VAPID_PRIVATE_KEY"
+	KeyVAPIDSubject               = "VAPID_SUBJECT"
+	KeyNotificationChannels       = "NOTIFICATION_CHANNELS"
+	KeySearchBackend              = "SEARCH_BACKEND"
+	KeySearchURL                  = "SEARCH_URL"
+	KeySearchAPIKey               = "SEARCH_API_KEY"
+	KeySearchIndex                = "SEARCH_INDEX"
+	KeyTrendingMinAuthors         = "TRENDING_MIN_AUTHORS"
+	KeyExportEnabled              = "EXPORT_ENABLED"
+	KeyExportSalt                 = "EXPORT_SALT"
+	KeyMediaScanner               = "MEDIA_SCANNER"
+	KeyMediaScannerURL            = "MEDIA_SCANNER_URL"
+	KeyEditWindow                 = "EDIT_WINDOW"
+	KeyCommentEditWindow          = "COMMENT_EDIT_WINDOW"
+	KeyMediaQuota                 = "MEDIA_QUOTA_MB"
+	KeyAccountMediaQuota          = "ACCOUNT_MEDIA_QUOTA_MB"
 )
 
 func prefKey(k string) string {
@@ -70,6 +235,17 @@ func prefKey(k string) string {
 	return k
 }
 
+// splitList splits a comma separated value, the items can contain spaces
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, it := range strings.Split(s, ",") {
+		if it = strings.TrimSpace(it); len(it) > 0 {
+			list = append(list, it)
+		}
+	}
+	return list
+}
+
 func loadKeyFromEnv(name, def string) string {
 	if val := os.Getenv(prefKey(name)); len(val) > 0 {
 		return val
@@ -160,6 +336,155 @@ func Load(e EnvType, wait time.Duration) *Configuration {
 	c.AdminContact = loadKeyFromEnv(KeyAdminContact, "") // ADMIN_CONTACT
 
 	c.APIURL = loadKeyFromEnv(KeyAPIUrl, "")
+	c.PagesPath = loadKeyFromEnv(KeyPagesPath, "pages") // PAGES_PATH
+
+	c.BannerMessage = loadKeyFromEnv(KeyBannerMessage, "")              // BANNER_MESSAGE
+	c.BannerLevel = strings.ToLower(loadKeyFromEnv(KeyBannerLevel, "")) // BANNER_LEVEL
+	if exp, err := time.Parse(time.RFC3339, loadKeyFromEnv(KeyBannerExpires, "")); err == nil {
+		c.BannerExpires = exp // BANNER_EXPIRES
+	}
+	c.IndexRefreshInterval, _ = time.ParseDuration(loadKeyFromEnv(KeyIndexRefreshInterval, "10m")) // INDEX_REFRESH_INTERVAL
+	if max, _ := strconv.ParseInt(loadKeyFromEnv(KeyIndexMaxItems, ""), 10, 32); max > 0 {
+		c.IndexMaxItems = int(max) // INDEX_MAX_ITEMS
+	} else {
+		c.IndexMaxItems = DefaultIndexMaxItems
+	}
+	c.DedupTTL, _ = time.ParseDuration(loadKeyFromEnv(KeyDedupTTL, "1h")) // DEDUP_TTL
+
+	c.Relays = strings.Fields(strings.Replace(loadKeyFromEnv(KeyRelays, ""), ",", " ", -1))                     // RELAYS
+	c.RelayObjectTypes = strings.Fields(strings.Replace(loadKeyFromEnv(KeyRelayObjectTypes, ""), ",", " ", -1)) // RELAY_OBJECT_TYPES
+
+	c.InstancesBlocked = strings.Fields(strings.Replace(loadKeyFromEnv(KeyInstancesBlocked, ""), ",", " ", -1)) // INSTANCES_BLOCKED
+	c.InstancesLimited = strings.Fields(strings.Replace(loadKeyFromEnv(KeyInstancesLimited, ""), ",", " ", -1)) // INSTANCES_LIMITED
+
+	if days, _ := strconv.ParseInt(loadKeyFromEnv(KeyRetentionDays, "90"), 10, 32); days > 0 {
+		c.Retention = time.Duration(days) * 24 * time.Hour // RETENTION_DAYS
+	}
+	c.PruneInterval, _ = time.ParseDuration(loadKeyFromEnv(KeyPruneInterval, "24h")) // PRUNE_INTERVAL
+
+	c.RequestTimeout, _ = time.ParseDuration(loadKeyFromEnv(KeyRequestTimeout, "10s")) // REQUEST_TIMEOUT
+	if threshold, err := strconv.ParseInt(loadKeyFromEnv(KeyBreakerThreshold, "5"), 10, 32); err == nil {
+		c.BreakerThreshold = int(threshold) // BREAKER_THRESHOLD
+	}
+	c.BreakerCoolDown, _ = time.ParseDuration(loadKeyFromEnv(KeyBreakerCoolDown, "30s")) // BREAKER_COOL_DOWN
+
+	if conns, _ := strconv.ParseInt(loadKeyFromEnv(KeyHTTPMaxIdleConnsPerHost, "20"), 10, 32); conns > 0 {
+		c.HTTPMaxIdleConnsPerHost = int(conns) // HTTP_MAX_IDLE_CONNS_PER_HOST
+	}
+	c.HTTPDialTimeout, _ = time.ParseDuration(loadKeyFromEnv(KeyHTTPDialTimeout, "5s"))          // HTTP_DIAL_TIMEOUT
+	c.HTTPTLSTimeout, _ = time.ParseDuration(loadKeyFromEnv(KeyHTTPTLSTimeout, "5s"))            // HTTP_TLS_TIMEOUT
+	c.HTTPIdleConnTimeout, _ = time.ParseDuration(loadKeyFromEnv(KeyHTTPIdleConnTimeout, "90s")) // HTTP_IDLE_CONN_TIMEOUT
+	http2Disabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableHTTP2, ""))                   // DISABLE_HTTP2
+	c.HTTP2Enabled = !http2Disabled
+
+	c.DataPath = loadKeyFromEnv(KeyDataPath, "data") // DATA_PATH
+	if days, err := strconv.ParseInt(loadKeyFromEnv(KeyHandleReserveDays, "90"), 10, 32); err == nil && days >= 0 {
+		c.HandleReservePeriod = time.Duration(days) * 24 * time.Hour // HANDLE_RESERVE_DAYS
+	}
+	if days, err := strconv.ParseInt(loadKeyFromEnv(KeyHandleRenameDays, "30"), 10, 32); err == nil && days >= 0 {
+		c.HandleRenameInterval = time.Duration(days) * 24 * time.Hour // HANDLE_RENAME_DAYS
+	}
+	c.AvatarLookupURL = loadKeyFromEnv(KeyAvatarLookupURL, "https://seccdn.libravatar.org/avatar/%s?s=96&d=404") // AVATAR_LOOKUP_URL
+
+	readerDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableReader, "")) // DISABLE_READER
+	c.ReaderEnabled = !readerDisabled
+	c.ReaderOptOut = strings.Fields(strings.Replace(loadKeyFromEnv(KeyReaderOptOut, ""), ",", " ", -1)) // READER_OPT_OUT
+
+	if posts, err := strconv.ParseInt(loadKeyFromEnv(KeyBotPostsPerHour, "30"), 10, 32); err == nil && posts >= 0 {
+		c.BotPostsPerHour = int(posts) // BOT_POSTS_PER_HOUR
+	}
+	c.FeedBotsInterval, _ = time.ParseDuration(loadKeyFromEnv(KeyFeedBotsInterval, "30m")) // FEED_BOTS_INTERVAL
+	snapshotsDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableSnapshots, "")) // DISABLE_SNAPSHOTS
+	c.SnapshotsEnabled = !snapshotsDisabled
+	c.TitleStripPatterns = strings.Fields(loadKeyFromEnv(KeyTitleStripPatterns, `\s+[|]\s+[^|]{1,60}$`)) // TITLE_STRIP_PATTERNS
+	c.TranslateProvider = loadKeyFromEnv(KeyTranslateProvider, "") // TRANSLATE_PROVIDER
+	c.TranslateURL = loadKeyFromEnv(KeyTranslateURL, "")           // TRANSLATE_URL
+	c.TranslateAPIKey = loadKeyFromEnv(KeyTranslateAPIKey, "")     // TRANSLATE_API_KEY
+	if items, err := strconv.ParseInt(loadKeyFromEnv(KeyItemsPerPage, "35"), 10, 32); err == nil && items > 0 {
+		c.ItemsPerPage = int(items) // ITEMS_PER_PAGE
+	}
+	if items, err := strconv.ParseInt(loadKeyFromEnv(KeyMaxItemsPerPage, "100"), 10, 32); err == nil && items > 0 {
+		c.MaxItemsPerPage = int(items) // MAX_ITEMS_PER_PAGE
+	}
+	c.MaxCommentDepth = -1
+	if depth, err := strconv.ParseInt(loadKeyFromEnv(KeyMaxCommentDepth, "12"), 10, 32); err == nil && depth >= 0 {
+		c.MaxCommentDepth = int(depth) // MAX_COMMENT_DEPTH
+	}
+	c.DeleteRestoreWindow, _ = time.ParseDuration(loadKeyFromEnv(KeyDeleteRestoreWindow, "10m")) // DELETE_RESTORE_WINDOW
+	c.Admins = strings.Fields(strings.Replace(loadKeyFromEnv(KeyAdmins, ""), ",", " ", -1))         // ADMINS
+	c.Moderators = strings.Fields(strings.Replace(loadKeyFromEnv(KeyModerators, ""), ",", " ", -1)) // MODERATORS
+	c.SlowModeInterval, _ = time.ParseDuration(loadKeyFromEnv(KeySlowModeInterval, "10m")) // SLOW_MODE_INTERVAL
+	c.SlowModeDuration, _ = time.ParseDuration(loadKeyFromEnv(KeySlowModeDuration, "24h")) // SLOW_MODE_DURATION
+	if first, err := strconv.ParseInt(loadKeyFromEnv(KeyPremoderateFirst, "0"), 10, 32); err == nil && first > 0 {
+		c.PremoderateFirst = int(first) // PREMODERATE_FIRST
+	}
+	c.PremoderateAccountAge, _ = time.ParseDuration(loadKeyFromEnv(KeyPremoderateAccountAge, "168h")) // PREMODERATE_ACCOUNT_AGE
+	evasionChecksDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableBanEvasionChecks, "")) // DISABLE_BAN_EVASION_CHECKS
+	c.BanEvasionChecksEnabled = !evasionChecksDisabled
+	c.BanEvasionWindow, _ = time.ParseDuration(loadKeyFromEnv(KeyBanEvasionWindow, "720h")) // BAN_EVASION_WINDOW
+	c.BoardCreation = strings.ToLower(loadKeyFromEnv(KeyBoardCreation, "admin")) // BOARD_CREATION
+	if karma, err := strconv.ParseInt(loadKeyFromEnv(KeyBoardCreationKarma, "50"), 10, 32); err == nil {
+		c.BoardCreationKarma = int(karma) // BOARD_CREATION_KARMA
+	}
+	if days, err := strconv.ParseInt(loadKeyFromEnv(KeyClassifiedsExpiryDays, "30"), 10, 32); err == nil && days > 0 {
+		c.ClassifiedsExpiryDays = int(days) // CLASSIFIEDS_EXPIRY_DAYS
+	}
+	if days, err := strconv.ParseInt(loadKeyFromEnv(KeyJobsExpiryDays, "60"), 10, 32); err == nil && days > 0 {
+		c.JobsExpiryDays = int(days) // JOBS_EXPIRY_DAYS
+	}
+	c.JobsAccountAge, _ = time.ParseDuration(loadKeyFromEnv(KeyJobsAccountAge, "720h")) // JOBS_ACCOUNT_AGE
+	if karma, err := strconv.ParseInt(loadKeyFromEnv(KeyJobsKarma, "20"), 10, 32); err == nil {
+		c.JobsKarma = int(karma) // JOBS_KARMA
+	}
+	jobsApprovalDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableJobsApproval, "")) // DISABLE_JOBS_APPROVAL
+	c.JobsApproval = !jobsApprovalDisabled
+	c.AskPrefixes = splitList(loadKeyFromEnv(KeyAskPrefixes, "Ask:,Ask HN:"))    // ASK_PREFIXES
+	c.ShowPrefixes = splitList(loadKeyFromEnv(KeyShowPrefixes, "Show:,Show HN:")) // SHOW_PREFIXES
+	c.AskGravity = 1.2
+	if g, err := strconv.ParseFloat(loadKeyFromEnv(KeyAskGravity, "1.2"), 64); err == nil && g > 0 {
+		c.AskGravity = g // ASK_GRAVITY
+	}
+	c.ShowGravity = 1.5
+	if g, err := strconv.ParseFloat(loadKeyFromEnv(KeyShowGravity, "1.5"), 64); err == nil && g > 0 {
+		c.ShowGravity = g // SHOW_GRAVITY
+	}
+	c.MailGatewayDomain = strings.ToLower(loadKeyFromEnv(KeyMailGatewayDomain, "")) // MAIL_GATEWAY_DOMAIN
+	c.MailGatewaySecret = loadKeyFromEnv(KeyMailGatewaySecret, "")                  // MAIL_GATEWAY_SECRET
+	c.SMTPAddress = loadKeyFromEnv(KeySMTPAddress, "")                              // SMTP_ADDRESS
+	c.SMTPUser = loadKeyFromEnv(KeySMTPUser, "")                                    // SMTP_USER
+	c.SMTPPassword = loadKeyFromEnv(KeySMTPPassword, "")                            // SMTP_PASSWORD
+	c.MailFrom = loadKeyFromEnv(KeyMailFrom, "")                                    // MAIL_FROM
+	c.DigestItems = 10
+	if max, err := strconv.ParseInt(loadKeyFromEnv(KeyDigestItems, "10"), 10, 32); err == nil && max > 0 {
+		c.DigestItems = int(max) // DIGEST_ITEMS
+	}
+	c.DigestTemplate = loadKeyFromEnv(KeyDigestTemplate, "") // DIGEST_TEMPLATE
+	webPushDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableWebPush, "")) // DISABLE_WEB_PUSH
+	c.WebPush = !webPushDisabled
+	c.VAPIDPrivateKey = loadKeyFromEnv(KeyVAPIDPrivateKey, "") // VAPID_PRIVATE_KEY
+	c.VAPIDSubject = loadKeyFromEnv(KeyVAPIDSubject, "")       // VAPID_SUBJECT
+	c.NotificationChannels = splitList(strings.ToLower(loadKeyFromEnv(KeyNotificationChannels, "ntfy,gotify,email"))) // NOTIFICATION_CHANNELS
+	c.SearchBackend = strings.ToLower(loadKeyFromEnv(KeySearchBackend, "bleve")) // SEARCH_BACKEND
+	c.SearchURL = loadKeyFromEnv(KeySearchURL, "")                                // SEARCH_URL
+	c.SearchAPIKey = loadKeyFromEnv(KeySearchAPIKey, "")                          // SEARCH_API_KEY
+	c.SearchIndex = loadKeyFromEnv(KeySearchIndex, "littr")                       // SEARCH_INDEX
+	c.TrendingMinAuthors = 3
+	if authors, err := strconv.ParseInt(loadKeyFromEnv(KeyTrendingMinAuthors, "3"), 10, 32); err == nil && authors > 0 {
+		c.TrendingMinAuthors = int(authors) // TRENDING_MIN_AUTHORS
+	}
+	c.ExportEnabled, _ = strconv.ParseBool(loadKeyFromEnv(KeyExportEnabled, "")) // EXPORT_ENABLED
+	c.ExportSalt = loadKeyFromEnv(KeyExportSalt, "")                             // EXPORT_SALT
+	c.MediaScanner = strings.ToLower(loadKeyFromEnv(KeyMediaScanner, "none"))    // MEDIA_SCANNER
+	c.MediaScannerURL = loadKeyFromEnv(KeyMediaScannerURL, "")                   // MEDIA_SCANNER_URL
+
+	c.EditWindow, _ = time.ParseDuration(loadKeyFromEnv(KeyEditWindow, "0"))                 // EDIT_WINDOW
+	c.CommentEditWindow, _ = time.ParseDuration(loadKeyFromEnv(KeyCommentEditWindow, "10m")) // COMMENT_EDIT_WINDOW
+	if mb, err := strconv.ParseInt(loadKeyFromEnv(KeyMediaQuota, "0"), 10, 64); err == nil && mb > 0 {
+		c.MediaQuota = mb << 20 // MEDIA_QUOTA_MB
+	}
+	if mb, err := strconv.ParseInt(loadKeyFromEnv(KeyAccountMediaQuota, "0"), 10, 64); err == nil && mb > 0 {
+		c.AccountMediaQuota = mb << 20 // ACCOUNT_MEDIA_QUOTA_MB
+	}
 
 	return c
 }